@@ -1,10 +1,14 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"runtime"
 	"strings"
+
+	"github.com/HakAl/langley/internal/config"
+	"github.com/HakAl/langley/internal/store"
 )
 
 // ActionableError represents an error with user-friendly guidance.
@@ -83,6 +87,15 @@ func portNum(port string) int {
 	return n
 }
 
+// weakTokenFix returns instructions for serving the API beyond localhost.
+func weakTokenFix(configPath string) string {
+	return fmt.Sprintf(`Set auth.token in %s to a random secret of at least %d characters:
+       openssl rand -hex 32
+
+       Or keep the dashboard local:
+       langley -api localhost:9091`, configPath, config.MinRemoteTokenLength)
+}
+
 // caCorruptFix returns instructions for regenerating the CA certificate.
 func caCorruptFix(certsDir string) string {
 	switch runtime.GOOS {
@@ -192,6 +205,9 @@ func isDBLocked(err error) bool {
 	if err == nil {
 		return false
 	}
+	if errors.Is(err, store.ErrMigrationLocked) {
+		return true
+	}
 	errStr := err.Error()
 	return strings.Contains(errStr, "database is locked") ||
 		strings.Contains(errStr, "SQLITE_BUSY") ||
@@ -2,8 +2,11 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 	"testing"
+
+	"github.com/HakAl/langley/internal/store"
 )
 
 func TestActionableError_Format(t *testing.T) {
@@ -115,6 +118,7 @@ func TestIsDBLocked(t *testing.T) {
 		{errors.New("database is locked"), true},
 		{errors.New("SQLITE_BUSY"), true},
 		{errors.New("cannot start a transaction within a transaction"), true},
+		{fmt.Errorf("running migrations: %w", store.ErrMigrationLocked), true},
 		{errors.New("some other error"), false},
 		{nil, false},
 	}
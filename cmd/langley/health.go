@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/HakAl/langley/internal/api"
+)
+
+// healthTimeout bounds the /api/health request.
+const healthTimeout = 5 * time.Second
+
+// handleHealthCommand handles the "health" subcommand.
+// It exits 0 if the running server reports "ok" and 1 otherwise.
+func handleHealthCommand(args []string) {
+	healthFlags := flag.NewFlagSet("health", flag.ExitOnError)
+	configPath := healthFlags.String("config", "", "Path to config file")
+	apiAddr := healthFlags.String("api", "localhost:9091", "API server address")
+	asJSON := healthFlags.Bool("json", false, "Print the raw health response as JSON")
+	showHelp := healthFlags.Bool("help", false, "Show help")
+	_ = healthFlags.Parse(args)
+
+	if *showHelp {
+		printHealthHelp()
+		os.Exit(0)
+	}
+
+	cfg, _, err := loadConfigForToken(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+	defer cancel()
+	code, err := runHealth(ctx, os.Stdout, *apiAddr, cfg.Auth.Token, *asJSON)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+	}
+	os.Exit(code)
+}
+
+// runHealth fetches /api/health from the server at apiAddr and prints it to
+// w. It returns the exit code: 0 if the status is "ok", 1 otherwise,
+// including when the server can't be reached.
+func runHealth(ctx context.Context, w io.Writer, apiAddr, token string, asJSON bool) (int, error) {
+	client, baseURL := httpClientFor(apiAddr, healthTimeout)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL+"/api/health", nil)
+	if err != nil {
+		return 1, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 1, fmt.Errorf("langley is not reachable at %s: %w", apiAddr, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 1, fmt.Errorf("reading health response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 1, fmt.Errorf("health check failed: %d - %s", resp.StatusCode, body)
+	}
+
+	var health api.HealthResponse
+	if err := json.Unmarshal(body, &health); err != nil {
+		return 1, fmt.Errorf("decoding health response: %w", err)
+	}
+
+	if asJSON {
+		if _, err := w.Write(body); err != nil {
+			return 1, err
+		}
+		if len(body) > 0 && body[len(body)-1] != '\n' {
+			fmt.Fprintln(w)
+		}
+	} else if err := printHealth(w, &health); err != nil {
+		return 1, err
+	}
+
+	if health.Status != "ok" {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// printHealth writes a short human-readable summary of a health response.
+func printHealth(w io.Writer, h *api.HealthResponse) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Status:\t%s\n", h.Status)
+	fmt.Fprintf(tw, "Uptime:\t%s\n", h.Uptime)
+	fmt.Fprintf(tw, "Flows:\t%d total, %d in last 5m\n", h.TotalFlows, h.ActiveFlows)
+	fmt.Fprintf(tw, "Drops (24h):\t%d\n", h.DropsLast24h)
+	fmt.Fprintf(tw, "Database:\t%s (WAL %s)\n", formatSize(h.DBSizeBytes), formatSize(h.WALSizeBytes))
+	if c := h.Capture; c != nil {
+		fmt.Fprintf(tw, "Capture:\t%s (%d of last %d writes failed)\n", c.Status, c.Failures, c.Samples)
+		if c.LastError != "" {
+			fmt.Fprintf(tw, "Last capture error:\t%s\n", c.LastError)
+		}
+	}
+	if t := h.Throughput; t != nil {
+		fmt.Fprintf(tw, "Proxy:\t%d in flight, %d requests in last 1m, avg %.0fms to upstream response\n", t.InFlight, t.RequestsLastMinute, t.AvgLatencyMs)
+	}
+	if h.Warning != "" {
+		fmt.Fprintf(tw, "Warning:\t%s\n", h.Warning)
+	}
+	return tw.Flush()
+}
+
+// formatSize formats a byte count with a binary unit, e.g. "1.5 MiB".
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// printHealthHelp prints help for the health subcommand.
+func printHealthHelp() {
+	fmt.Printf(`Usage: langley health [options]
+
+Check a running langley server via /api/health. Prints a short summary and
+exits non-zero unless the status is "ok", so it can be used from scripts.
+
+Options:
+    -api <addr>       API server address or unix:/path (default: localhost:9091)
+    -config <path>    Path to configuration file (for the auth token)
+    -json             Print the raw health response as JSON
+
+Examples:
+    langley health
+    langley health -api localhost:8080
+    langley health -json
+`)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRunHealth(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		asJSON   bool
+		wantCode int
+		want     []string
+	}{
+		{
+			name:     "ok",
+			body:     `{"status":"ok","uptime":"1h0m0s","total_flows":12,"active_flows":2,"db_size_bytes":2097152,"wal_size_bytes":512,"throughput":{"in_flight":3,"requests_last_minute":40,"avg_forward_latency_ms":812.4}}`,
+			wantCode: 0,
+			want:     []string{"Status:", "ok", "12 total, 2 in last 5m", "2.0 MiB (WAL 512 B)", "3 in flight, 40 requests in last 1m, avg 812ms"},
+		},
+		{
+			name:     "degraded",
+			body:     `{"status":"degraded","uptime":"5m0s","capture":{"status":"degraded","failure_rate":0.2,"samples":10,"failures":2,"total_failures":2,"last_error":"disk full"},"warning":"Flow capture failing"}`,
+			wantCode: 1,
+			want:     []string{"degraded", "2 of last 10 writes failed", "disk full", "Warning:", "Flow capture failing"},
+		},
+		{
+			name:     "json",
+			body:     `{"status":"error","uptime":"1s"}`,
+			asJSON:   true,
+			wantCode: 1,
+			want:     []string{`"status":"error"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotAuth string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/health" {
+					http.NotFound(w, r)
+					return
+				}
+				gotAuth = r.Header.Get("Authorization")
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			var out bytes.Buffer
+			code, err := runHealth(context.Background(), &out, strings.TrimPrefix(srv.URL, "http://"), "secret", tt.asJSON)
+			if err != nil {
+				t.Fatalf("runHealth: %v", err)
+			}
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d", code, tt.wantCode)
+			}
+			if gotAuth != "Bearer secret" {
+				t.Errorf("Authorization = %q, want Bearer secret", gotAuth)
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(out.String(), s) {
+					t.Errorf("output missing %q:\n%s", s, out.String())
+				}
+			}
+			if tt.asJSON && !json.Valid(out.Bytes()) {
+				t.Errorf("-json output is not valid JSON:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestRunHealth_Unreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	srv.Close()
+
+	code, err := runHealth(context.Background(), &bytes.Buffer{}, addr, "", false)
+	if err == nil || code != 1 {
+		t.Errorf("runHealth = %d, %v; want 1 and an error", code, err)
+	}
+}
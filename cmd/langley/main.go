@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"flag"
 	"fmt"
 	"io"
@@ -19,6 +20,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/HakAl/langley/internal/analytics"
 	"github.com/HakAl/langley/internal/api"
 	"github.com/HakAl/langley/internal/config"
 	"github.com/HakAl/langley/internal/pricing"
@@ -26,6 +28,7 @@ import (
 	"github.com/HakAl/langley/internal/redact"
 	"github.com/HakAl/langley/internal/store"
 	"github.com/HakAl/langley/internal/task"
+	"github.com/HakAl/langley/internal/telemetry"
 	langleytls "github.com/HakAl/langley/internal/tls"
 	"github.com/HakAl/langley/internal/ws"
 	"github.com/HakAl/langley/web"
@@ -49,13 +52,27 @@ func main() {
 		case "setup":
 			handleSetupCommand(os.Args[2:])
 			return
+		case "stats":
+			handleStatsCommand(os.Args[2:])
+			return
+		case "health":
+			handleHealthCommand(os.Args[2:])
+			return
+		case "tail":
+			handleTailCommand(os.Args[2:])
+			return
+		case "workspaces":
+			handleWorkspacesCommand(os.Args[2:])
+			return
 		}
 	}
 
 	// CLI flags for main server mode
 	configPath := flag.String("config", "", "Path to config file")
 	listenAddr := flag.String("listen", "", "Proxy listen address (overrides config)")
-	apiAddr := flag.String("api", "localhost:9091", "API server listen address")
+	apiAddr := flag.String("api", "", "API server listen address (overrides config)")
+	dbPath := flag.String("db", "", "Database path (overrides config)")
+	workspace := flag.String("workspace", "", "Workspace to capture into (database langley-{name}.db)")
 	debugMode := flag.Bool("debug", false, "Enable debug logging")
 	showVersion := flag.Bool("version", false, "Show version and exit")
 	showCA := flag.Bool("show-ca", false, "Show CA certificate path and exit")
@@ -102,6 +119,18 @@ func main() {
 	if *listenAddr != "" {
 		cfg.Proxy.Listen = *listenAddr
 	}
+	if *apiAddr != "" {
+		cfg.API.Listen = *apiAddr
+	}
+
+	// Sharing the dashboard on the network needs a token worth the name
+	if err := cfg.Auth.ValidateForListen(cfg.API.Listen); err != nil {
+		printError("Refusing to serve the API beyond localhost", err, weakTokenFix(actualConfigPath))
+	}
+	if !config.IsLoopbackListen(cfg.Proxy.ListenAddr()) && len(cfg.Proxy.AllowedCIDRs) == 0 {
+		slog.Warn("proxy listens beyond localhost; it has no authentication, so anyone who can reach it can send traffic through it; set proxy.allowed_cidrs to restrict it",
+			"addr", cfg.Proxy.ListenAddr())
+	}
 
 	// Get config directory for certs
 	configDir, err := config.ConfigDir()
@@ -114,6 +143,13 @@ func main() {
 		printError("Failed to create config directory", err, caPermissionFix(configDir))
 	}
 
+	// Pick the database for -db or -workspace
+	var currentWorkspace string
+	cfg.Persistence.DBPath, currentWorkspace, err = resolveDBPath(cfg, configDir, *dbPath, *workspace)
+	if err != nil {
+		printError("Invalid database selection", err, configLoadFix(*configPath))
+	}
+
 	// Load or create CA
 	certsDir := filepath.Join(configDir, "certs")
 	ca, err := langleytls.LoadOrCreateCA(certsDir)
@@ -141,9 +177,9 @@ func main() {
 	const maxPortAttempts = 10
 
 	// Create API server listener with fallback (langley-rla)
-	apiListener, actualAPIAddr, err := listenWithFallback(*apiAddr, maxPortAttempts)
+	apiListener, actualAPIAddr, err := listenWithFallback(cfg.API.Listen, maxPortAttempts)
 	if err != nil {
-		printError("Failed to bind API server", err, portInUseFix(*apiAddr, maxPortAttempts))
+		printError("Failed to bind API server", err, portInUseFix(cfg.API.Listen, maxPortAttempts))
 	}
 	slog.Info("API server bound", "addr", actualAPIAddr)
 
@@ -156,18 +192,22 @@ func main() {
 	slog.Info("proxy server bound", "addr", actualProxyAddr)
 
 	// Set CRL URL for Windows compatibility (langley-2qj)
-	// Use actual API address after fallback
-	crlURL := fmt.Sprintf("http://%s/crl/ca.crl", actualAPIAddr)
-	if err := ca.SetCRLURL(crlURL); err != nil {
-		slog.Error("failed to set CRL URL", "error", err)
-		apiListener.Close()
-		proxyListener.Close()
-		os.Exit(1)
+	// Use actual API address after fallback. A Unix socket has no URL that
+	// certificate verifiers could fetch, so leave the CRL out.
+	if _, ok := unixSocketPath(actualAPIAddr); !ok {
+		crlURL := fmt.Sprintf("http://%s/crl/ca.crl", actualAPIAddr)
+		if err := ca.SetCRLURL(crlURL); err != nil {
+			slog.Error("failed to set CRL URL", "error", err)
+			apiListener.Close()
+			proxyListener.Close()
+			os.Exit(1)
+		}
+		slog.Info("CRL configured", "url", crlURL)
 	}
-	slog.Info("CRL configured", "url", crlURL)
 
 	// Create cert cache
 	certCache := langleytls.NewCertCache(ca, 1000)
+	certCache.SetMaxConcurrentGenerations(cfg.TLS.MaxCertGenConcurrency)
 
 	// Create redactor
 	redactor, err := redact.New(&cfg.Redaction)
@@ -177,7 +217,8 @@ func main() {
 	}
 
 	// Create store
-	dataStore, err := store.NewSQLiteStore(cfg.Persistence.DBPath, &cfg.Retention)
+	dataStore, err := store.NewSQLiteStore(cfg.Persistence.DBPath, &cfg.Retention,
+		store.WithPersistenceConfig(&cfg.Persistence))
 	if err != nil {
 		if isDBLocked(err) {
 			printError("Database is locked", err, dbLockedFix(cfg.Persistence.DBPath))
@@ -193,6 +234,8 @@ func main() {
 	// Create task assigner with configurable idle gap
 	taskAssigner := task.NewAssigner(task.AssignerConfig{
 		IdleGapMinutes: cfg.Task.IdleGapMinutes,
+		QueryParam:     cfg.Task.QueryParam,
+		IDJSONPath:     cfg.Task.IDJSONPath,
 	})
 
 	// Load LiteLLM pricing data (langley-mxx)
@@ -220,19 +263,134 @@ func main() {
 	}()
 
 	// Create WebSocket hub
-	wsHub := ws.NewHub(cfg, logger)
+	hubOpts := []ws.HubOption{ws.WithFlowStore(dataStore)}
+	if db, ok := dataStore.DB().(*sql.DB); ok {
+		hubOpts = append(hubOpts, ws.WithTaskSummaries(analytics.NewEngine(db)))
+	}
+	wsHub := ws.NewHub(cfg, logger, hubOpts...)
 	go wsHub.Run(ctx)
 
-	// Create API server with reload support
+	// Cap concurrent upstream requests (shared with /api/health)
+	upstreamLimiter, err := proxy.NewUpstreamLimiter(cfg.Proxy)
+	if err != nil {
+		slog.Error("invalid proxy config", "error", err)
+		os.Exit(1)
+	}
+
+	// Pace requests per model (shared with /api/health)
+	modelLimiter, err := proxy.NewModelLimiter(cfg.Proxy)
+	if err != nil {
+		slog.Error("invalid proxy config", "error", err)
+		os.Exit(1)
+	}
+
+	// Track flow write failures for /api/health and alert on status changes
+	captureMonitor := proxy.NewCaptureMonitor()
+	captureMonitor.OnStatusChange(func(health store.CaptureHealth) {
+		if health.Status == store.CaptureStatusOK {
+			logger.Info("flow capture recovered", "failure_rate", health.FailureRate)
+		} else {
+			logger.Warn("flow capture failing", "status", health.Status,
+				"failure_rate", health.FailureRate, "last_error", health.LastError)
+		}
+		wsHub.BroadcastCaptureHealth(health)
+	})
+
+	// Tracing is off unless a collector is configured
+	var tracer *telemetry.Tracer
+	if cfg.Telemetry.OTLPEndpoint != "" {
+		exporter := telemetry.NewOTLPExporter(cfg.Telemetry.OTLPEndpoint, cfg.Telemetry.ServiceName, cfg.Telemetry.OTLPHeaders)
+		tracer = telemetry.NewTracer(exporter, logger)
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := tracer.Shutdown(ctx); err != nil {
+				slog.Warn("failed to flush spans", "error", err)
+			}
+		}()
+		slog.Info("tracing enabled", "otlp_endpoint", cfg.Telemetry.OTLPEndpoint)
+	}
+
+	// Create MITM proxy; it starts serving once the API server is up
+	mitmProxy, err := proxy.NewMITMProxy(proxy.MITMProxyConfig{
+		Config:          cfg,
+		Logger:          logger,
+		CA:              ca,
+		CertCache:       certCache,
+		Redactor:        redactor,
+		Store:           dataStore,
+		TaskAssigner:    taskAssigner,
+		PricingSource:   pricingSource,
+		CaptureMonitor:  captureMonitor,
+		UpstreamLimiter: upstreamLimiter,
+		ModelLimiter:    modelLimiter,
+		Tracer:          tracer,
+		OnFlow: func(flow *store.Flow) {
+			slog.Debug("flow started", "id", flow.ID, "host", flow.Host, "method", flow.Method)
+			wsHub.BroadcastFlowStart(flow)
+		},
+		OnUpdate: func(flow *store.Flow) {
+			status := 0
+			if flow.StatusCode != nil {
+				status = *flow.StatusCode
+			}
+			slog.Debug("flow completed", "id", flow.ID, "status", status, "sse", flow.IsSSE)
+			wsHub.BroadcastFlowComplete(flow)
+		},
+		OnEvent: func(event *store.Event) {
+			slog.Debug("SSE event", "flow_id", event.FlowID, "type", event.EventType, "seq", event.Sequence)
+			wsHub.BroadcastEvent(event)
+		},
+	})
+	if err != nil {
+		slog.Error("failed to create proxy", "error", err)
+		os.Exit(1)
+	}
+
+	// Generate certificates for known hosts before clients ask for them
+	go func() {
+		start := time.Now()
+		if err := mitmProxy.WarmCertificates(); err != nil {
+			slog.Warn("certificate warmup failed", "error", err)
+		}
+		slog.Debug("certificate warmup done", "certs", certCache.Size(), "duration", time.Since(start))
+	}()
+
+	// Create API server with reload support. Reload and settings updates
+	// change cfg in place under its lock, which the WebSocket hub, store and
+	// proxy read live; the proxy needs the rebuilt redactor handed over.
 	apiServer := api.NewServer(cfg, dataStore, logger,
 		api.WithConfigPath(actualConfigPath),
-		api.WithOnReload(func(newToken string) {
-			slog.Info("token reloaded", "token_length", len(newToken))
-			// Note: WebSocket hub reads token from cfg.Auth.Token directly,
-			// which is updated by the reload handler
+		api.WithOnReload(func(result *api.ReloadResult) {
+			mitmProxy.SetRedactor(result.Redactor)
 		}),
 		api.WithPricingSource(pricingSource),
+		api.WithCaptureMonitor(captureMonitor),
+		api.WithUpstreamLimiter(upstreamLimiter),
+		api.WithModelLimiter(modelLimiter),
+		api.WithThroughputSource(mitmProxy),
+		api.WithCA(ca),
+		api.WithEventSource(wsHub),
+		api.WithNotesBroadcaster(wsHub),
+		api.WithWorkspaces(configDir, currentWorkspace),
 	)
+	defer apiServer.Close()
+
+	// Reload config on SIGHUP, same as POST /api/admin/reload
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hupCh:
+				if _, err := apiServer.Reload(); err != nil {
+					slog.Error("config reload failed", "error", err)
+				}
+			}
+		}
+	}()
 	apiMux := http.NewServeMux()
 	apiMux.Handle("/api/", apiServer.Handler())
 	apiMux.HandleFunc("/ws", wsHub.Handler(cfg.Auth.Token))
@@ -245,8 +403,9 @@ func main() {
 
 	// Create API server instance for graceful shutdown
 	apiSrv := &http.Server{
-		Addr:    actualAPIAddr,
-		Handler: apiMux,
+		Addr:        actualAPIAddr,
+		Handler:     apiMux,
+		ConnContext: api.UnixConnContext, // Unix socket peers count as local
 	}
 
 	// Start API server using the pre-created listener (langley-rla)
@@ -264,6 +423,7 @@ func main() {
 
 		// Run immediately on startup
 		runRetention(dataStore, logger)
+		lastVacuum := time.Now()
 
 		for {
 			select {
@@ -271,40 +431,40 @@ func main() {
 				return
 			case <-ticker.C:
 				runRetention(dataStore, logger)
+
+				// Optional scheduled compaction after retention deletes
+				interval := time.Duration(cfg.Persistence.VacuumIntervalHours) * time.Hour
+				if interval > 0 && time.Since(lastVacuum) >= interval {
+					runVacuum(dataStore, logger)
+					lastVacuum = time.Now()
+				}
 			}
 		}
 	}()
 
-	// Create and start MITM proxy
-	mitmProxy, err := proxy.NewMITMProxy(proxy.MITMProxyConfig{
-		Config:        cfg,
-		Logger:        logger,
-		CA:            ca,
-		CertCache:     certCache,
-		Redactor:      redactor,
-		Store:         dataStore,
-		TaskAssigner:  taskAssigner,
-		PricingSource: pricingSource,
-		OnFlow: func(flow *store.Flow) {
-			slog.Debug("flow started", "id", flow.ID, "host", flow.Host, "method", flow.Method)
-			wsHub.BroadcastFlowStart(flow)
-		},
-		OnUpdate: func(flow *store.Flow) {
-			status := 0
-			if flow.StatusCode != nil {
-				status = *flow.StatusCode
-			}
-			slog.Debug("flow completed", "id", flow.ID, "status", status, "sse", flow.IsSSE)
-			wsHub.BroadcastFlowComplete(flow)
-		},
-		OnEvent: func(event *store.Event) {
-			slog.Debug("SSE event", "flow_id", event.FlowID, "type", event.EventType, "seq", event.Sequence)
-			wsHub.BroadcastEvent(event)
-		},
-	})
-	if err != nil {
-		slog.Error("failed to create proxy", "error", err)
-		os.Exit(1)
+	// Checkpoint the WAL when readers keep autocheckpoint from keeping up
+	if pages := cfg.Persistence.WALAutocheckpointPages; pages > 0 {
+		go store.NewWALMonitor(dataStore, pages, logger).Run(ctx, time.Minute)
+	}
+
+	// Start daily budget check goroutine
+	if cfg.Budget.DailyUSD > 0 {
+		if db, ok := dataStore.DB().(*sql.DB); ok {
+			budget := analytics.NewBudgetMonitor(analytics.NewEngine(db), cfg.Budget.DailyUSD)
+			go func() {
+				ticker := time.NewTicker(1 * time.Minute)
+				defer ticker.Stop()
+
+				for {
+					runBudgetCheck(budget, wsHub, logger)
+					select {
+					case <-ctx.Done():
+						return
+					case <-ticker.C:
+					}
+				}
+			}()
+		}
 	}
 
 	// Use actual addresses after fallback (langley-rla)
@@ -315,16 +475,22 @@ func main() {
 	caPath := filepath.Join(certsDir, "ca.crt")
 
 	fmt.Fprintf(os.Stderr, "\n")
-	fmt.Fprintf(os.Stderr, "  Proxy:     http://%s\n", actualProxyAddr)
-	fmt.Fprintf(os.Stderr, "  API:       http://%s\n", actualAPIAddr)
-	fmt.Fprintf(os.Stderr, "  WebSocket: ws://%s/ws\n", actualAPIAddr)
+	fmt.Fprintf(os.Stderr, "  Proxy:     %s\n", displayAddr("http", actualProxyAddr))
+	fmt.Fprintf(os.Stderr, "  API:       %s\n", displayAddr("http", actualAPIAddr))
+	fmt.Fprintf(os.Stderr, "  WebSocket: %s/ws\n", displayAddr("ws", actualAPIAddr))
 	fmt.Fprintf(os.Stderr, "  CA:        %s\n", caPath)
 	fmt.Fprintf(os.Stderr, "  DB:        %s\n", cfg.Persistence.DBPath)
+	if currentWorkspace != "" {
+		fmt.Fprintf(os.Stderr, "  Workspace: %s\n", currentWorkspace)
+	}
 	fmt.Fprintf(os.Stderr, "  Token:     %s\n", cfg.Auth.Token)
 	fmt.Fprintf(os.Stderr, "\n")
 
-	// Print copy-paste environment variables (OS-aware syntax)
-	fmt.Fprint(os.Stderr, formatEnvVars(actualProxyAddr, caPath, runtime.GOOS))
+	// Print copy-paste environment variables (OS-aware syntax). HTTP_PROXY
+	// can't point at a Unix socket, so there is nothing to paste.
+	if _, ok := unixSocketPath(actualProxyAddr); !ok {
+		fmt.Fprint(os.Stderr, formatEnvVars(actualProxyAddr, caPath, runtime.GOOS))
+	}
 
 	// Write state file for 'langley run' command (langley-5i2).
 	// Written AFTER apiSrv.Serve() goroutine is launched so the health
@@ -384,10 +550,50 @@ func runRetention(dataStore store.Store, logger *slog.Logger) {
 	}
 }
 
+// runVacuum compacts the database file
+func runVacuum(dataStore store.Store, logger *slog.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	before, after, err := dataStore.Vacuum(ctx)
+	if err != nil {
+		logger.Error("scheduled vacuum failed", "error", err)
+		return
+	}
+	logger.Info("scheduled vacuum completed", "size_before", before, "size_after", after)
+}
+
+// runBudgetCheck warns and notifies dashboard clients when today's spend
+// crosses the daily budget.
+func runBudgetCheck(budget *analytics.BudgetMonitor, hub *ws.Hub, logger *slog.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	alert, err := budget.Check(ctx, time.Now())
+	if err != nil {
+		logger.Error("budget check failed", "error", err)
+		return
+	}
+	if alert == nil {
+		return
+	}
+	logger.Warn("daily budget exceeded", "date", alert.Date, "spent_usd", alert.SpentUSD, "limit_usd", alert.LimitUSD)
+	hub.BroadcastBudgetAlert(alert)
+}
+
 // listenWithFallback attempts to listen on the given address, falling back to
 // subsequent ports if the port is already in use. It tries up to maxAttempts ports.
 // Returns the listener, the actual address used, and any error. (langley-rla)
+// A "unix:/path" address listens on that socket with no fallback.
 func listenWithFallback(baseAddr string, maxAttempts int) (net.Listener, string, error) {
+	if path, ok := unixSocketPath(baseAddr); ok {
+		ln, err := listenUnix(path)
+		if err != nil {
+			return nil, "", err
+		}
+		return ln, baseAddr, nil
+	}
+
 	// Parse host and port from the address
 	host, portStr, err := net.SplitHostPort(baseAddr)
 	if err != nil {
@@ -456,13 +662,19 @@ USAGE:
 COMMANDS:
     run <cmd> [args]  Run a command with proxy environment configured
     setup             Install CA certificate to system trust store
+    stats             Print traffic/cost summary from the database
+    health            Check a running server (exits non-zero unless ok)
+    tail              Print flows from a running server as they complete
+    workspaces list   List workspace databases
     token show        Show the current auth token
     token rotate      Generate a new auth token
 
 OPTIONS:
     -config <path>    Path to configuration file
-    -listen <addr>    Proxy listen address (default: from config or localhost:9090)
-    -api <addr>       API/WebSocket server address (default: localhost:9091)
+    -listen <addr>    Proxy listen address or unix:/path (default: from config or localhost:9090)
+    -api <addr>       API/WebSocket server address or unix:/path (default: from config or localhost:9091)
+    -db <path>        Database path (overrides config)
+    -workspace <name> Capture into workspace <name> (langley-<name>.db in the config dir)
     -version          Show version information
     -show-ca          Show CA certificate path and trust instructions
     -help             Show this help message
@@ -471,7 +683,11 @@ EXAMPLES:
     langley                     Start with default config
     langley run claude          Run claude with proxy env configured
     langley setup               Install CA certificate (first-time setup)
+    langley stats -since 168h   Summarize the last week of traffic
+    langley health -json        Print the running server's health as JSON
+    langley tail -filter host=openai  Follow completed OpenAI flows
     langley -listen :8080       Start proxy on port 8080
+    langley -workspace client-a Keep client-a's traffic in its own database
     langley -config ./my.yaml   Use custom config file
     langley -show-ca            Show how to trust the CA certificate
     langley token show          Show current auth token
@@ -501,6 +717,8 @@ func handleTokenCommand(args []string) {
 	tokenFlags := flag.NewFlagSet("token", flag.ExitOnError)
 	configPath := tokenFlags.String("config", "", "Path to config file")
 	apiAddr := tokenFlags.String("api", "localhost:9091", "API server address for reload")
+	name := tokenFlags.String("name", "", "Token name (add, revoke)")
+	scope := tokenFlags.String("scope", config.ScopeRead, "Token scope: read or admin (add)")
 
 	if len(args) == 0 {
 		printTokenHelp()
@@ -515,6 +733,20 @@ func handleTokenCommand(args []string) {
 		tokenShow(*configPath)
 	case "rotate":
 		tokenRotate(*configPath, *apiAddr)
+	case "add":
+		if *name == "" {
+			fmt.Fprintln(os.Stderr, "Error: -name is required")
+			os.Exit(1)
+		}
+		tokenAdd(*configPath, *apiAddr, *name, *scope)
+	case "list":
+		tokenList(*configPath)
+	case "revoke":
+		if *name == "" {
+			fmt.Fprintln(os.Stderr, "Error: -name is required")
+			os.Exit(1)
+		}
+		tokenRevoke(*configPath, *apiAddr, *name)
 	case "help", "-help", "--help":
 		printTokenHelp()
 	default:
@@ -600,15 +832,14 @@ func loadConfigForToken(configPath string) (*config.Config, string, error) {
 
 // reloadRunningServer attempts to notify a running server to reload its config
 func reloadRunningServer(apiAddr, oldToken string) bool {
-	url := fmt.Sprintf("http://%s/api/admin/reload", apiAddr)
+	client, baseURL := httpClientFor(apiAddr, 5*time.Second)
 
-	req, err := http.NewRequest("POST", url, bytes.NewReader(nil))
+	req, err := http.NewRequest("POST", baseURL+"/api/admin/reload", bytes.NewReader(nil))
 	if err != nil {
 		return false
 	}
 	req.Header.Set("Authorization", "Bearer "+oldToken)
 
-	client := &http.Client{Timeout: 5 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
 		return false
@@ -636,15 +867,26 @@ func printTokenHelp() {
 Commands:
     show        Show the current auth token
     rotate      Generate a new auth token and save to config
+    add         Create an additional token with a scope (stored hashed)
+    list        List additional tokens and their scopes
+    revoke      Remove an additional token
+
+The primary token (show/rotate) has admin scope. Additional tokens have
+"read" scope - read-only API access - or "admin" scope, which can also
+change settings and tags and run admin endpoints.
 
 Options:
     -config <path>    Path to configuration file
     -api <addr>       API server address for reload notification (default: localhost:9091)
+    -name <name>      Token name (add, revoke)
+    -scope <scope>    Token scope for add: read or admin (default: read)
 
 Examples:
     langley token show
     langley token rotate
     langley token rotate -api localhost:8080
+    langley token add -name grafana -scope read
+    langley token revoke -name grafana
 `)
 }
 
@@ -652,6 +894,7 @@ Examples:
 func handleSetupCommand(args []string) {
 	setupFlags := flag.NewFlagSet("setup", flag.ExitOnError)
 	skipMkcert := setupFlags.Bool("no-mkcert", false, "Skip mkcert detection and show manual instructions")
+	verify := setupFlags.Bool("verify", false, "After installing, check that the system trust store accepts the CA")
 	showHelp := setupFlags.Bool("help", false, "Show help")
 	_ = setupFlags.Parse(args)
 
@@ -676,8 +919,6 @@ func handleSetupCommand(args []string) {
 		fmt.Fprintf(os.Stderr, "Error loading/creating CA: %v\n", err)
 		os.Exit(1)
 	}
-	_ = ca // CA is loaded, cert file exists
-
 	fmt.Println("Langley Setup - CA Certificate Installation")
 	fmt.Println("============================================")
 	fmt.Println()
@@ -689,6 +930,10 @@ func handleSetupCommand(args []string) {
 	} else {
 		printManualInstructions(caPath)
 	}
+
+	if *verify && !runVerify(os.Stdout, ca, caPath, runtime.GOOS) {
+		os.Exit(1)
+	}
 }
 
 // hasMkcert checks if mkcert is available in PATH
@@ -898,6 +1143,9 @@ This allows Langley to intercept HTTPS traffic to Claude and other LLM APIs.
 
 Options:
     --no-mkcert    Skip mkcert detection and show manual instructions
+    --verify       After installing, make a test TLS connection with a
+                   certificate from the CA and report whether the system
+                   trust store accepts it (exits 1 if not)
     --help         Show this help message
 
 The setup wizard will:
@@ -909,5 +1157,6 @@ The setup wizard will:
 Examples:
     langley setup              Auto-detect and install CA
     langley setup --no-mkcert  Show manual installation instructions
+    langley setup --verify     Install the CA, then confirm it is trusted
 `)
 }
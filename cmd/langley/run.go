@@ -113,6 +113,13 @@ func (r *RunCommand) Execute(ctx context.Context, args []string) int {
 		return 1
 	}
 
+	// Clients only reach proxies over TCP via HTTPS_PROXY
+	if _, ok := unixSocketPath(state.ProxyAddr); ok {
+		fmt.Fprintf(r.stderr, "Error: the proxy is listening on %s.\n", state.ProxyAddr)
+		fmt.Fprintln(r.stderr, "\nHTTPS_PROXY can't point at a Unix socket; set proxy.listen to host:port to use 'langley run'.")
+		return 1
+	}
+
 	// Build environment and run
 	env := r.envBuilder.Build(state.ProxyAddr, state.CAPath)
 	return r.processRunner.Run(ctx, args[0], args[1:], env)
@@ -135,11 +142,12 @@ type HTTPHealthChecker struct{}
 
 // Check verifies the server is healthy by hitting the health endpoint.
 func (h *HTTPHealthChecker) Check(ctx context.Context, apiAddr string) error {
-	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+apiAddr+"/api/health", nil)
+	client, baseURL := httpClientFor(apiAddr, 0)
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL+"/api/health", nil)
 	if err != nil {
 		return err
 	}
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return err
 	}
@@ -142,6 +142,27 @@ func TestRunCommand_CAMissing(t *testing.T) {
 	}
 }
 
+func TestRunCommand_UnixSocketProxy(t *testing.T) {
+	var stderr bytes.Buffer
+	cmd := &RunCommand{
+		stateReader:   &mockStateReader{state: &ServerState{ProxyAddr: "unix:/tmp/langley.sock", CAPath: "/ca.crt"}},
+		healthChecker: &mockHealthChecker{err: nil},
+		fileChecker:   &mockFileChecker{exists: true},
+		envBuilder:    &mockEnvBuilder{},
+		processRunner: &mockProcessRunner{exitCode: 0},
+		stderr:        &stderr,
+	}
+
+	code := cmd.Execute(context.Background(), []string{"echo"})
+
+	if code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	if !bytes.Contains(stderr.Bytes(), []byte("unix:/tmp/langley.sock")) {
+		t.Errorf("expected socket address in error, got %q", stderr.String())
+	}
+}
+
 func TestRunCommand_Success(t *testing.T) {
 	var stderr bytes.Buffer
 	cmd := &RunCommand{
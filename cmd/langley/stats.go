@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/HakAl/langley/internal/analytics"
+	"github.com/HakAl/langley/internal/config"
+	"github.com/HakAl/langley/internal/store"
+)
+
+// statsTopN is the number of models and tasks listed in the stats output.
+const statsTopN = 5
+
+// handleStatsCommand handles the "stats" subcommand.
+// It reads the database directly, so it works without a running server.
+func handleStatsCommand(args []string) {
+	statsFlags := flag.NewFlagSet("stats", flag.ExitOnError)
+	configPath := statsFlags.String("config", "", "Path to config file")
+	dbPath := statsFlags.String("db", "", "Path to database (overrides config)")
+	workspace := statsFlags.String("workspace", "", "Workspace to summarize")
+	since := statsFlags.Duration("since", 24*time.Hour, "Time window to summarize")
+	showHelp := statsFlags.Bool("help", false, "Show help")
+	_ = statsFlags.Parse(args)
+
+	if *showHelp {
+		printStatsHelp()
+		os.Exit(0)
+	}
+
+	path := *dbPath
+	if path == "" {
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			printError("Failed to load configuration", err, configLoadFix(*configPath))
+		}
+		dir, err := config.ConfigDir()
+		if err != nil {
+			printError("Failed to determine config directory", err, configLoadFix(""))
+		}
+		path, _, err = resolveDBPath(cfg, dir, "", *workspace)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+	} else if *workspace != "" {
+		fmt.Fprintln(os.Stderr, "Error: -db and -workspace can't be used together")
+		os.Exit(1)
+	}
+
+	if err := runStats(context.Background(), os.Stdout, path, *since, time.Now()); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// runStats prints overall stats for the window (now-since, now] from the
+// database at dbPath. It mirrors /api/stats without needing the server.
+func runStats(ctx context.Context, w io.Writer, dbPath string, since time.Duration, now time.Time) error {
+	if since <= 0 {
+		return fmt.Errorf("-since must be positive, got %s", since)
+	}
+	if _, err := os.Stat(dbPath); err != nil {
+		return fmt.Errorf("database not found at %s: %w", dbPath, err)
+	}
+
+	dataStore, err := store.NewSQLiteStore(dbPath, &config.DefaultConfig().Retention)
+	if err != nil {
+		return fmt.Errorf("opening database: %w", err)
+	}
+	defer dataStore.Close()
+
+	db, ok := dataStore.DB().(*sql.DB)
+	if !ok {
+		return fmt.Errorf("store does not expose a SQL database")
+	}
+	engine := analytics.NewEngine(db)
+
+	start := now.Add(-since)
+
+	overall, err := engine.GetOverallStats(ctx, start, now)
+	if err != nil {
+		return fmt.Errorf("getting overall stats: %w", err)
+	}
+	models, err := engine.GetCostByModel(ctx, start, now)
+	if err != nil {
+		return fmt.Errorf("getting cost by model: %w", err)
+	}
+	tasks, err := engine.ListTaskSummaries(ctx, start, now, statsTopN)
+	if err != nil {
+		return fmt.Errorf("getting task summaries: %w", err)
+	}
+
+	fmt.Fprintf(w, "Langley stats (last %s)\n\n", since)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Flows:\t%d\n", overall.TotalFlows)
+	fmt.Fprintf(tw, "Tasks:\t%d\n", overall.TotalTasks)
+	fmt.Fprintf(tw, "Tool calls:\t%d\n", overall.TotalToolCalls)
+	fmt.Fprintf(tw, "Tokens in:\t%d\n", overall.TotalTokensIn)
+	fmt.Fprintf(tw, "Tokens out:\t%d\n", overall.TotalTokensOut)
+	fmt.Fprintf(tw, "Cost:\t$%.4f\n", overall.TotalCost)
+	fmt.Fprintf(tw, "Avg cost/flow:\t$%.4f\n", overall.AvgCostPerFlow)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(models) > 0 {
+		fmt.Fprintln(w, "\nTop models:")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for i, m := range models {
+			if i >= statsTopN {
+				break
+			}
+			fmt.Fprintf(tw, "  %s\t%d flows\t$%.4f\n", m.Period, m.FlowCount, m.TotalCost)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(tasks) > 0 {
+		fmt.Fprintln(w, "\nTop tasks:")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, t := range tasks {
+			fmt.Fprintf(tw, "  %s\t%d flows\t$%.4f\n", t.TaskID, t.FlowCount, t.TotalCost)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// printStatsHelp prints help for the stats subcommand.
+func printStatsHelp() {
+	fmt.Printf(`Usage: langley stats [options]
+
+Print a summary of captured traffic (flows, cost, tokens, top models and
+tasks). Reads the database directly; the server does not need to be running.
+
+Options:
+    -since <duration>  Time window to summarize (default: 24h)
+    -config <path>     Path to configuration file
+    -db <path>         Path to database (overrides config)
+    -workspace <name>  Summarize a workspace (see langley workspaces)
+
+Examples:
+    langley stats
+    langley stats -since 168h
+    langley stats -db ./langley.db -since 1h
+    langley stats -workspace client-a
+`)
+}
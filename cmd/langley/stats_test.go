@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/config"
+	"github.com/HakAl/langley/internal/store"
+)
+
+// seedStatsDB creates a file-backed database with a few flows for stats tests.
+func seedStatsDB(t *testing.T, now time.Time) string {
+	t.Helper()
+	dbPath := filepath.Join(t.TempDir(), "langley.db")
+	s, err := store.NewSQLiteStore(dbPath, &config.DefaultConfig().Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer s.Close()
+
+	intp := func(v int) *int { return &v }
+	floatp := func(v float64) *float64 { return &v }
+	strp := func(v string) *string { return &v }
+
+	flows := []*store.Flow{
+		{ID: "f1", TaskID: strp("task-a"), Model: strp("claude-sonnet-4"), Timestamp: now.Add(-1 * time.Hour),
+			InputTokens: intp(100), OutputTokens: intp(10), TotalCost: floatp(0.5)},
+		{ID: "f2", TaskID: strp("task-a"), Model: strp("claude-sonnet-4"), Timestamp: now.Add(-2 * time.Hour),
+			InputTokens: intp(200), OutputTokens: intp(20), TotalCost: floatp(0.25)},
+		{ID: "f3", TaskID: strp("task-b"), Model: strp("gpt-4o"), Timestamp: now.Add(-3 * time.Hour),
+			InputTokens: intp(300), OutputTokens: intp(30), TotalCost: floatp(0.125)},
+		// Outside the default 24h window
+		{ID: "f-old", TaskID: strp("task-old"), Model: strp("gpt-4o"), Timestamp: now.Add(-48 * time.Hour),
+			InputTokens: intp(1000), OutputTokens: intp(100), TotalCost: floatp(10)},
+	}
+	for _, f := range flows {
+		f.Host = "api.anthropic.com"
+		f.Method = "POST"
+		f.Path = "/v1/messages"
+		f.FlowIntegrity = "complete"
+		f.Provider = "anthropic"
+		if err := s.SaveFlow(context.Background(), f); err != nil {
+			t.Fatalf("SaveFlow(%s): %v", f.ID, err)
+		}
+	}
+	return dbPath
+}
+
+func TestRunStats_PrintsTotals(t *testing.T) {
+	now := time.Now().UTC()
+	dbPath := seedStatsDB(t, now)
+
+	var out bytes.Buffer
+	if err := runStats(context.Background(), &out, dbPath, 24*time.Hour, now); err != nil {
+		t.Fatalf("runStats: %v", err)
+	}
+	got := out.String()
+
+	for label, want := range map[string]string{
+		"Flows:":          "3",
+		"Tasks:":          "2",
+		"Tokens in:":      "600",
+		"Tokens out:":     "60",
+		"Cost:":           "$0.8750",
+		"claude-sonnet-4": "2 flows $0.7500",
+		"task-a":          "2 flows $0.7500",
+	} {
+		if v := statValue(got, label); v != want {
+			t.Errorf("%s = %q, want %q\n%s", label, v, want, got)
+		}
+	}
+	if strings.Contains(got, "task-old") {
+		t.Errorf("output should not include flows outside the window\n%s", got)
+	}
+}
+
+func TestRunStats_WiderWindowIncludesOlderFlows(t *testing.T) {
+	now := time.Now().UTC()
+	dbPath := seedStatsDB(t, now)
+
+	var out bytes.Buffer
+	if err := runStats(context.Background(), &out, dbPath, 72*time.Hour, now); err != nil {
+		t.Fatalf("runStats: %v", err)
+	}
+	if v := statValue(out.String(), "Flows:"); v != "4" {
+		t.Errorf("expected 4 flows in 72h window\n%s", out.String())
+	}
+}
+
+func TestRunStats_MissingDatabase(t *testing.T) {
+	var out bytes.Buffer
+	err := runStats(context.Background(), &out, filepath.Join(t.TempDir(), "missing.db"), time.Hour, time.Now())
+	if err == nil {
+		t.Fatal("expected error for missing database")
+	}
+}
+
+// statValue returns the whitespace-normalized remainder of the first output
+// line starting with label, or "" if no line matches.
+func statValue(out, label string) string {
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if rest, ok := strings.CutPrefix(line, label); ok {
+			return strings.Join(strings.Fields(rest), " ")
+		}
+	}
+	return ""
+}
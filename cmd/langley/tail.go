@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/HakAl/langley/internal/ws"
+	"github.com/gorilla/websocket"
+)
+
+// tailDialTimeout bounds connecting to the server's /ws endpoint.
+const tailDialTimeout = 5 * time.Second
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ",") }
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// handleTailCommand handles the "tail" subcommand.
+// It follows a running server's WebSocket and prints flows as they complete.
+func handleTailCommand(args []string) {
+	tailFlags := flag.NewFlagSet("tail", flag.ExitOnError)
+	configPath := tailFlags.String("config", "", "Path to config file")
+	apiAddr := tailFlags.String("api", "localhost:9091", "API server address")
+	asJSON := tailFlags.Bool("json", false, "Print raw WebSocket frames as JSON")
+	var filters stringList
+	tailFlags.Var(&filters, "filter", "Only show matching flows, e.g. host=anthropic (repeatable)")
+	showHelp := tailFlags.Bool("help", false, "Show help")
+	_ = tailFlags.Parse(args)
+
+	if *showHelp {
+		printTailHelp()
+		os.Exit(0)
+	}
+
+	filter, err := parseTailFilters(filters)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+
+	cfg, _, err := loadConfigForToken(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := runTail(ctx, os.Stdout, *apiAddr, cfg.Auth.Token, filter, *asJSON); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// tailFilter selects which completed flows are printed. Empty fields
+// match everything.
+type tailFilter struct {
+	host string // Case-insensitive substring of the flow's host
+}
+
+// parseTailFilters parses -filter values of the form key=value.
+func parseTailFilters(values []string) (tailFilter, error) {
+	var f tailFilter
+	for _, v := range values {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok || value == "" {
+			return f, fmt.Errorf("invalid -filter %q: want key=value", v)
+		}
+		switch strings.ToLower(key) {
+		case "host":
+			f.host = strings.ToLower(value)
+		default:
+			return f, fmt.Errorf("unknown -filter key %q (supported: host)", key)
+		}
+	}
+	return f, nil
+}
+
+// tailFlow is the part of a flow_complete summary that tail prints.
+type tailFlow struct {
+	Host         string    `json:"host"`
+	Method       string    `json:"method"`
+	Path         string    `json:"path"`
+	Timestamp    time.Time `json:"timestamp"`
+	StatusCode   *int      `json:"status_code"`
+	Model        *string   `json:"model"`
+	InputTokens  *int      `json:"input_tokens"`
+	OutputTokens *int      `json:"output_tokens"`
+	TotalCost    *float64  `json:"total_cost"`
+}
+
+func (f tailFilter) match(flow *tailFlow) bool {
+	return f.host == "" || strings.Contains(strings.ToLower(flow.Host), f.host)
+}
+
+// runTail connects to /ws on the server at apiAddr and writes each
+// completed flow to w until ctx is done or the server closes the connection.
+func runTail(ctx context.Context, w io.Writer, apiAddr, token string, filter tailFilter, asJSON bool) error {
+	dialer := websocket.Dialer{HandshakeTimeout: tailDialTimeout}
+	wsURL := "ws://" + apiAddr + "/ws"
+	if path, ok := unixSocketPath(apiAddr); ok {
+		dialer.NetDialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+		wsURL = "ws://unix/ws"
+	}
+	header := http.Header{}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+
+	conn, resp, err := dialer.DialContext(ctx, wsURL, header)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			return fmt.Errorf("server at %s rejected the auth token", apiAddr)
+		}
+		return fmt.Errorf("langley is not reachable at %s: %w", apiAddr, err)
+	}
+	defer conn.Close()
+
+	// Unblock ReadMessage when interrupted
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	for {
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("connection closed: %w", err)
+		}
+		if err := renderTailFrame(w, frame, filter, asJSON); err != nil {
+			return err
+		}
+	}
+}
+
+// renderTailFrame writes a line for each flow_complete message in frame
+// that passes filter. The hub joins messages queued for a client into one
+// frame, separated by newlines, so a frame may hold several. Decoding stops
+// at the first message that isn't JSON.
+func renderTailFrame(w io.Writer, frame []byte, filter tailFilter, asJSON bool) error {
+	dec := json.NewDecoder(bytes.NewReader(frame))
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil
+		}
+		if err := renderTailMessage(w, raw, filter, asJSON); err != nil {
+			return err
+		}
+	}
+}
+
+// renderTailMessage writes one line for a flow_complete message that passes
+// filter: the raw message with asJSON, else the formatted summary. Other
+// message types are ignored.
+func renderTailMessage(w io.Writer, raw json.RawMessage, filter tailFilter, asJSON bool) error {
+	var msg struct {
+		Type string   `json:"type"`
+		Data tailFlow `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &msg); err != nil || msg.Type != ws.MessageTypeFlowComplete {
+		return nil
+	}
+	if !filter.match(&msg.Data) {
+		return nil
+	}
+	if asJSON {
+		_, err := fmt.Fprintf(w, "%s\n", raw)
+		return err
+	}
+	_, err := fmt.Fprintln(w, formatTailLine(&msg.Data))
+	return err
+}
+
+// formatTailLine renders a completed flow as a single line:
+// time, method, host and path, status, model, tokens in→out, and cost.
+func formatTailLine(f *tailFlow) string {
+	status := "-"
+	if f.StatusCode != nil {
+		status = fmt.Sprintf("%d", *f.StatusCode)
+	}
+	model := "-"
+	if f.Model != nil && *f.Model != "" {
+		model = *f.Model
+	}
+	tokens := "-"
+	if f.InputTokens != nil || f.OutputTokens != nil {
+		tokens = fmt.Sprintf("%s→%s", optInt(f.InputTokens), optInt(f.OutputTokens))
+	}
+	cost := "-"
+	if f.TotalCost != nil {
+		cost = fmt.Sprintf("$%.4f", *f.TotalCost)
+	}
+	return fmt.Sprintf("%s  %-6s %s%s  %s  %s  %s  %s",
+		f.Timestamp.Local().Format("15:04:05"), f.Method, f.Host, f.Path, status, model, tokens, cost)
+}
+
+func optInt(v *int) string {
+	if v == nil {
+		return "?"
+	}
+	return fmt.Sprintf("%d", *v)
+}
+
+func printTailHelp() {
+	fmt.Printf(`Usage: langley tail [options]
+
+Follow a running langley server and print each flow as it completes, one
+line per flow: time, method, host and path, status, model, tokens in→out
+and cost. Stop with Ctrl-C.
+
+Options:
+    -api <addr>       API server address or unix:/path (default: localhost:9091)
+    -config <path>    Path to configuration file (for the auth token)
+    -filter <k=v>     Only show matching flows; host=<substring> (repeatable)
+    -json             Print the raw flow_complete WebSocket frames
+
+Examples:
+    langley tail
+    langley tail -filter host=anthropic
+    langley tail -json | jq .data.total_cost
+`)
+}
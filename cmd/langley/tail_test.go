@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderTailFrame(t *testing.T) {
+	ts := time.Date(2026, 3, 4, 15, 4, 5, 0, time.UTC)
+	clock := ts.Local().Format("15:04:05")
+
+	complete := `{"type":"flow_complete","timestamp":"2026-03-04T15:04:06Z","data":{"id":"f1","host":"api.anthropic.com","method":"POST","path":"/v1/messages","timestamp":"2026-03-04T15:04:05Z","provider":"anthropic","status_code":200,"model":"claude-sonnet-4","input_tokens":1200,"output_tokens":340,"total_cost":0.0087}}`
+	noUsage := `{"type":"flow_complete","timestamp":"2026-03-04T15:04:06Z","data":{"id":"f2","host":"api.openai.com","method":"GET","path":"/v1/models","timestamp":"2026-03-04T15:04:05Z","provider":"openai","status_code":401}}`
+
+	tests := []struct {
+		name   string
+		frame  string
+		filter []string
+		asJSON bool
+		want   string
+	}{
+		{
+			name:  "completed flow",
+			frame: complete,
+			want:  clock + "  POST   api.anthropic.com/v1/messages  200  claude-sonnet-4  1200→340  $0.0087\n",
+		},
+		{
+			name:  "missing usage",
+			frame: noUsage,
+			want:  clock + "  GET    api.openai.com/v1/models  401  -  -  -\n",
+		},
+		{
+			name:  "flow start ignored",
+			frame: `{"type":"flow_start","timestamp":"2026-03-04T15:04:05Z","data":{"id":"f1","host":"api.anthropic.com"}}`,
+		},
+		{
+			name:  "malformed ignored",
+			frame: `not json`,
+		},
+		{
+			name:   "host filter matches",
+			frame:  complete,
+			filter: []string{"host=ANTHROPIC"},
+			want:   clock + "  POST   api.anthropic.com/v1/messages  200  claude-sonnet-4  1200→340  $0.0087\n",
+		},
+		{
+			name:   "host filter excludes",
+			frame:  noUsage,
+			filter: []string{"host=anthropic"},
+		},
+		{
+			name:   "raw json",
+			frame:  complete,
+			asJSON: true,
+			want:   complete + "\n",
+		},
+		{
+			name:  "coalesced frame",
+			frame: complete + "\n" + `{"type":"flow_start","timestamp":"2026-03-04T15:04:05Z","data":{"id":"f3"}}` + "\n" + noUsage,
+			want: clock + "  POST   api.anthropic.com/v1/messages  200  claude-sonnet-4  1200→340  $0.0087\n" +
+				clock + "  GET    api.openai.com/v1/models  401  -  -  -\n",
+		},
+		{
+			name:   "coalesced raw json",
+			frame:  complete + "\n" + noUsage,
+			asJSON: true,
+			want:   complete + "\n" + noUsage + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := parseTailFilters(tt.filter)
+			if err != nil {
+				t.Fatalf("parseTailFilters: %v", err)
+			}
+			var buf bytes.Buffer
+			if err := renderTailFrame(&buf, []byte(tt.frame), filter, tt.asJSON); err != nil {
+				t.Fatalf("renderTailFrame: %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got %q\nwant %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTailFilters_Invalid(t *testing.T) {
+	for _, v := range []string{"host", "host=", "model=gpt-4o"} {
+		if _, err := parseTailFilters([]string{v}); err == nil {
+			t.Errorf("parseTailFilters(%q) succeeded, want error", v)
+		} else if !strings.Contains(err.Error(), "-filter") {
+			t.Errorf("parseTailFilters(%q) error = %v", v, err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/HakAl/langley/internal/config"
+)
+
+// addScopedToken generates a token, adds its hash to cfg.Auth.Tokens and
+// returns the plaintext. The plaintext is not stored anywhere.
+func addScopedToken(cfg *config.Config, name, scope string) (string, error) {
+	for _, t := range cfg.Auth.Tokens {
+		if t.Name == name {
+			return "", fmt.Errorf("a token named %q already exists", name)
+		}
+	}
+
+	token, err := config.GenerateToken()
+	if err != nil {
+		return "", fmt.Errorf("generating token: %w", err)
+	}
+	tokens := append(cfg.Auth.Tokens, config.ScopedToken{
+		Name:  name,
+		Hash:  config.HashToken(token),
+		Scope: scope,
+	})
+	candidate := config.AuthConfig{Token: cfg.Auth.Token, Tokens: tokens}
+	if err := candidate.Validate(); err != nil {
+		return "", err
+	}
+	cfg.Auth.Tokens = tokens
+	return token, nil
+}
+
+// revokeScopedToken removes the token called name from cfg.Auth.Tokens.
+func revokeScopedToken(cfg *config.Config, name string) error {
+	for i, t := range cfg.Auth.Tokens {
+		if t.Name == name {
+			cfg.Auth.Tokens = append(cfg.Auth.Tokens[:i:i], cfg.Auth.Tokens[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no token named %q", name)
+}
+
+// runTokenList prints the scoped tokens in cfg. Only names and scopes are
+// known; the tokens themselves were shown once when added.
+func runTokenList(w io.Writer, cfg *config.Config) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSCOPE")
+	fmt.Fprintf(tw, "%s\t%s\n", "(primary)", config.ScopeAdmin)
+	for _, t := range cfg.Auth.Tokens {
+		fmt.Fprintf(tw, "%s\t%s\n", t.Name, t.Scope)
+	}
+	return tw.Flush()
+}
+
+// tokenAdd creates a scoped token and saves its hash to the config.
+func tokenAdd(configPath, apiAddr, name, scope string) {
+	cfg, cfgPath, err := loadConfigForToken(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	token, err := addScopedToken(cfg, name, scope)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := cfg.Save(cfgPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving config: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Config:  %s\n", cfgPath)
+	fmt.Printf("Name:    %s\n", name)
+	fmt.Printf("Scope:   %s\n", scope)
+	fmt.Printf("Token:   %s\n", token)
+	fmt.Println()
+	fmt.Println("Only a hash of this token is stored - copy it now, it can't be shown again.")
+	notifyTokenReload(apiAddr, cfg.Auth.Token)
+}
+
+// tokenRevoke removes a scoped token from the config.
+func tokenRevoke(configPath, apiAddr, name string) {
+	cfg, cfgPath, err := loadConfigForToken(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := revokeScopedToken(cfg, name); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := cfg.Save(cfgPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving config: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Revoked token %q\n", name)
+	notifyTokenReload(apiAddr, cfg.Auth.Token)
+}
+
+// tokenList lists the scoped tokens in the config.
+func tokenList(configPath string) {
+	cfg, _, err := loadConfigForToken(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	if err := runTokenList(os.Stdout, cfg); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// notifyTokenReload asks a running server to pick up token changes.
+func notifyTokenReload(apiAddr, adminToken string) {
+	if reloadRunningServer(apiAddr, adminToken) {
+		fmt.Println("✓ Running server notified - change is active immediately")
+	} else {
+		fmt.Println("Note: Restart langley for the change to take effect")
+		fmt.Println("      (Or the server is not running on " + apiAddr + ")")
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/HakAl/langley/internal/config"
+)
+
+func TestScopedTokenCommands(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "primary"
+
+	token, err := addScopedToken(cfg, "grafana", config.ScopeRead)
+	if err != nil {
+		t.Fatalf("addScopedToken: %v", err)
+	}
+	if len(cfg.Auth.Tokens) != 1 || cfg.Auth.Tokens[0].Hash == token {
+		t.Fatalf("tokens = %+v, want one hashed entry", cfg.Auth.Tokens)
+	}
+	if scope, ok := cfg.Auth.Authenticate(token); !ok || scope != config.ScopeRead {
+		t.Errorf("Authenticate(new token) = %q, %v; want read", scope, ok)
+	}
+	if scope, ok := cfg.Auth.Authenticate("primary"); !ok || scope != config.ScopeAdmin {
+		t.Errorf("Authenticate(primary) = %q, %v; want admin", scope, ok)
+	}
+
+	if _, err := addScopedToken(cfg, "grafana", config.ScopeAdmin); err == nil {
+		t.Error("duplicate name: expected error")
+	}
+	if _, err := addScopedToken(cfg, "ci", "write"); err == nil {
+		t.Error("bad scope: expected error")
+	}
+	if len(cfg.Auth.Tokens) != 1 {
+		t.Errorf("failed adds changed tokens: %+v", cfg.Auth.Tokens)
+	}
+
+	var out bytes.Buffer
+	if err := runTokenList(&out, cfg); err != nil {
+		t.Fatalf("runTokenList: %v", err)
+	}
+	if !strings.Contains(out.String(), "grafana") || strings.Contains(out.String(), token) {
+		t.Errorf("list output:\n%s", out.String())
+	}
+
+	if err := revokeScopedToken(cfg, "grafana"); err != nil {
+		t.Fatalf("revokeScopedToken: %v", err)
+	}
+	if _, ok := cfg.Auth.Authenticate(token); ok {
+		t.Error("revoked token still authenticates")
+	}
+	if err := revokeScopedToken(cfg, "grafana"); err == nil {
+		t.Error("revoking twice: expected error")
+	}
+}
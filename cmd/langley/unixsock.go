@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// unixAddrPrefix marks a listen address as a Unix domain socket path,
+// e.g. "unix:/run/user/1000/langley.sock".
+const unixAddrPrefix = "unix:"
+
+// unixSocketPath returns the socket path if addr uses the unix: prefix.
+func unixSocketPath(addr string) (string, bool) {
+	if !strings.HasPrefix(addr, unixAddrPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(addr, unixAddrPrefix), true
+}
+
+// listenUnix listens on a Unix domain socket readable only by the owner.
+// A leftover socket from a crashed run is removed; a socket with a live
+// listener, or any other file at path, is an error. The listener removes
+// the socket file when closed.
+func listenUnix(path string) (net.Listener, error) {
+	if path == "" {
+		return nil, fmt.Errorf("empty unix socket path")
+	}
+
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("listen unix %s: address already in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("removing stale socket: %w", err)
+		}
+	}
+
+	return listenUnixOwnerOnly(path)
+}
+
+// httpClientFor returns a client and base URL for reaching a server at addr,
+// which may be host:port or a unix: socket address.
+func httpClientFor(addr string, timeout time.Duration) (*http.Client, string) {
+	path, ok := unixSocketPath(addr)
+	if !ok {
+		return &http.Client{Timeout: timeout}, "http://" + addr
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}, "http://unix"
+}
+
+// displayAddr formats a listen address for the startup banner.
+func displayAddr(scheme, addr string) string {
+	if _, ok := unixSocketPath(addr); ok {
+		return addr
+	}
+	return scheme + "://" + addr
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/config"
+	"github.com/HakAl/langley/internal/proxy"
+	"github.com/HakAl/langley/internal/redact"
+	langleytls "github.com/HakAl/langley/internal/tls"
+)
+
+// socketPath returns a short socket path; sun_path is limited to ~104 bytes.
+func socketPath(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("unix socket permissions are not supported on Windows")
+	}
+	dir, err := os.MkdirTemp("", "lgy")
+	if err != nil {
+		t.Fatalf("MkdirTemp: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "s.sock")
+}
+
+func TestListenWithFallback_UnixSocket(t *testing.T) {
+	path := socketPath(t)
+
+	ln, addr, err := listenWithFallback("unix:"+path, 5)
+	if err != nil {
+		t.Fatalf("listenWithFallback: %v", err)
+	}
+	if addr != "unix:"+path {
+		t.Errorf("addr = %q, want %q", addr, "unix:"+path)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat socket: %v", err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("socket permissions = %o, want 600", perm)
+	}
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/health" {
+			fmt.Fprint(w, `{"status":"ok"}`)
+			return
+		}
+		http.NotFound(w, r)
+	})}
+	go srv.Serve(ln)
+
+	if err := (&HTTPHealthChecker{}).Check(context.Background(), addr); err != nil {
+		t.Errorf("health check over unix socket: %v", err)
+	}
+
+	// Shutdown closes the listener, which removes the socket file
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("socket file still present after shutdown: %v", err)
+	}
+}
+
+func TestListenUnix_ExistingPath(t *testing.T) {
+	t.Run("stale socket is replaced", func(t *testing.T) {
+		path := socketPath(t)
+		stale, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		// Simulate a crash: stop listening but leave the file behind
+		stale.(*net.UnixListener).SetUnlinkOnClose(false)
+		stale.Close()
+
+		ln, err := listenUnix(path)
+		if err != nil {
+			t.Fatalf("listenUnix over stale socket: %v", err)
+		}
+		ln.Close()
+	})
+
+	t.Run("live socket is in use", func(t *testing.T) {
+		path := socketPath(t)
+		live, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		defer live.Close()
+
+		if ln, err := listenUnix(path); err == nil {
+			ln.Close()
+			t.Fatal("expected error for socket with a live listener")
+		} else if !isAddrInUse(err) {
+			t.Errorf("error %q not recognised as address in use", err)
+		}
+	})
+
+	t.Run("regular file is left alone", func(t *testing.T) {
+		path := socketPath(t)
+		if err := os.WriteFile(path, []byte("keep"), 0600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		if ln, err := listenUnix(path); err == nil {
+			ln.Close()
+			t.Fatal("expected error for non-socket file")
+		}
+		if data, _ := os.ReadFile(path); string(data) != "keep" {
+			t.Error("regular file was modified")
+		}
+	})
+}
+
+func TestMITMProxy_ServesOverUnixSocket(t *testing.T) {
+	path := socketPath(t)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "via unix socket")
+	}))
+	defer upstream.Close()
+
+	cfg := config.DefaultConfig()
+	ca, err := langleytls.LoadOrCreateCA(t.TempDir())
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA: %v", err)
+	}
+	redactor, err := redact.New(&cfg.Redaction)
+	if err != nil {
+		t.Fatalf("redact.New: %v", err)
+	}
+	p, err := proxy.NewMITMProxy(proxy.MITMProxyConfig{
+		Config:    cfg,
+		Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		CA:        ca,
+		CertCache: langleytls.NewCertCache(ca, 10),
+		Redactor:  redactor,
+	})
+	if err != nil {
+		t.Fatalf("NewMITMProxy: %v", err)
+	}
+
+	ln, _, err := listenWithFallback("unix:"+path, 1)
+	if err != nil {
+		t.Fatalf("listenWithFallback: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- p.ServeListener(ctx, ln) }()
+
+	// Send a proxied request through the socket
+	client, proxyURL := httpClientFor("unix:"+path, 5*time.Second)
+	transport := client.Transport.(*http.Transport)
+	transport.Proxy = func(*http.Request) (*url.URL, error) { return url.Parse(proxyURL) }
+
+	resp, err := client.Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("GET through unix socket proxy: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "via unix socket" {
+		t.Errorf("body = %q", body)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("ServeListener: %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("socket file still present after shutdown: %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenUnixOwnerOnly listens on a Unix socket created with mode 0600. The
+// umask is narrowed while the socket is created, rather than chmodding it
+// after, so there is no moment when other users can connect.
+func listenUnixOwnerOnly(path string) (net.Listener, error) {
+	old := syscall.Umask(0177)
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
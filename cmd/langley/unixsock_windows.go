@@ -0,0 +1,23 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// listenUnixOwnerOnly listens on a Unix socket. Windows has no umask, so
+// the socket's permissions are set once it exists.
+func listenUnixOwnerOnly(path string) (net.Listener, error) {
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("setting socket permissions: %w", err)
+	}
+	return ln, nil
+}
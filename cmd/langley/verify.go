@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	langleytls "github.com/HakAl/langley/internal/tls"
+)
+
+// verifyHost is the host name the trust check's certificate is issued for,
+// one the proxy intercepts. Nothing is sent to it: the check connects to a
+// local server.
+const verifyHost = "api.anthropic.com"
+
+// verifyTimeout bounds the trust check's TLS handshake.
+const verifyTimeout = 5 * time.Second
+
+// verifyCATrust issues a certificate for host from ca, the way the proxy
+// does when intercepting, serves it from an in-process TLS server and
+// connects to it with roots (nil for the system trust store). It returns
+// the handshake error if the client doesn't accept the certificate.
+func verifyCATrust(ctx context.Context, ca *langleytls.CA, host string, roots *x509.CertPool) error {
+	certCache := langleytls.NewCertCache(ca, 1)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: certCache.GetCertificate})
+	if err != nil {
+		return fmt.Errorf("starting test server: %w", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// A client that rejects the certificate aborts the handshake
+			_ = conn.(*tls.Conn).HandshakeContext(ctx)
+			conn.Close()
+		}
+	}()
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{},
+		Config:    &tls.Config{ServerName: host, RootCAs: roots},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", ln.Addr().String())
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// runVerify checks that the system trust store accepts certificates issued
+// by ca and prints the result, with hints for goos on failure. It reports
+// whether the check passed.
+func runVerify(w io.Writer, ca *langleytls.CA, caPath, goos string) bool {
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Verifying that the system trusts the Langley CA (test TLS connection for %s)...\n", verifyHost)
+
+	ctx, cancel := context.WithTimeout(context.Background(), verifyTimeout)
+	defer cancel()
+	err := verifyCATrust(ctx, ca, verifyHost, nil)
+	if err == nil {
+		fmt.Fprintln(w, "✓ The system trust store accepts Langley's certificates")
+		return true
+	}
+
+	fmt.Fprintf(w, "✗ The system trust store rejected Langley's certificate: %v\n", err)
+	var unknown x509.UnknownAuthorityError
+	if !errors.As(err, &unknown) {
+		fmt.Fprintln(w, "  The check itself failed, so trust could not be confirmed either way.")
+	}
+	fmt.Fprintln(w)
+	printTrustHints(w, caPath, goos)
+	return false
+}
+
+// printTrustHints prints what to check when the CA isn't trusted on goos.
+func printTrustHints(w io.Writer, caPath, goos string) {
+	fmt.Fprintln(w, "Things to check:")
+	switch goos {
+	case "darwin":
+		fmt.Fprintln(w, "  - Open Keychain Access, find \"Langley CA\" in the System keychain and set")
+		fmt.Fprintln(w, "    Trust > When using this certificate to \"Always Trust\"")
+		fmt.Fprintf(w, "  - Or re-run: sudo security add-trusted-cert -d -r trustRoot -k /Library/Keychains/System.keychain %s\n", caPath)
+	case "linux":
+		fmt.Fprintln(w, "  - Debian/Ubuntu: the certificate must be in /usr/local/share/ca-certificates/ with a .crt")
+		fmt.Fprintln(w, "    extension, followed by: sudo update-ca-certificates")
+		fmt.Fprintln(w, "  - RHEL/Fedora: copy it to /etc/pki/ca-trust/source/anchors/ and run: sudo update-ca-trust")
+		fmt.Fprintln(w, "  - SSL_CERT_FILE or SSL_CERT_DIR in your environment replace the system store")
+	case "windows":
+		fmt.Fprintln(w, "  - Run as Administrator: certutil -addstore -f \"ROOT\" "+caPath)
+		fmt.Fprintln(w, "  - Check it appears under Trusted Root Certification Authorities in certmgr.msc")
+	default:
+		fmt.Fprintln(w, "  - Install the CA with your platform's trust store tool (see 'langley setup --no-mkcert')")
+	}
+	fmt.Fprintln(w, "  - Node.js, Python and Firefox keep their own stores; point them at the CA with")
+	fmt.Fprintf(w, "    NODE_EXTRA_CA_CERTS=%s or REQUESTS_CA_BUNDLE, or 'langley run', which sets them\n", caPath)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	langleytls "github.com/HakAl/langley/internal/tls"
+)
+
+func TestVerifyCATrust(t *testing.T) {
+	ca, err := langleytls.LoadOrCreateCA(t.TempDir())
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA: %v", err)
+	}
+	other, err := langleytls.LoadOrCreateCA(t.TempDir())
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA: %v", err)
+	}
+	trusting := x509.NewCertPool()
+	trusting.AddCert(ca.Certificate())
+	untrusting := x509.NewCertPool()
+	untrusting.AddCert(other.Certificate())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := verifyCATrust(ctx, ca, verifyHost, trusting); err != nil {
+		t.Errorf("trust store with the CA: %v, want success", err)
+	}
+
+	err = verifyCATrust(ctx, ca, verifyHost, untrusting)
+	var unknown x509.UnknownAuthorityError
+	if !errors.As(err, &unknown) {
+		t.Errorf("trust store without the CA: got %v, want an unknown authority error", err)
+	}
+}
+
+func TestPrintTrustHints(t *testing.T) {
+	for goos, want := range map[string]string{
+		"darwin":  "Always Trust",
+		"linux":   "update-ca-certificates",
+		"windows": "certutil -addstore",
+		"plan9":   "langley setup --no-mkcert",
+	} {
+		var buf bytes.Buffer
+		printTrustHints(&buf, "/tmp/ca.crt", goos)
+		if out := buf.String(); !strings.Contains(out, want) || !strings.Contains(out, "NODE_EXTRA_CA_CERTS=/tmp/ca.crt") {
+			t.Errorf("%s hints missing %q or the Node.js hint:\n%s", goos, want, out)
+		}
+	}
+}
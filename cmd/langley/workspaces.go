@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/HakAl/langley/internal/config"
+)
+
+// resolveDBPath applies the -db and -workspace flags to the configured
+// database path. It returns the path and the workspace it belongs to, which
+// is empty for a database outside the workspace layout.
+func resolveDBPath(cfg *config.Config, dir, dbFlag, workspaceFlag string) (string, string, error) {
+	switch {
+	case dbFlag != "" && workspaceFlag != "":
+		return "", "", fmt.Errorf("-db and -workspace can't be used together")
+	case dbFlag != "":
+		return dbFlag, "", nil
+	case workspaceFlag != "":
+		if err := config.ValidateWorkspaceName(workspaceFlag); err != nil {
+			return "", "", err
+		}
+		return config.WorkspaceDBPath(dir, workspaceFlag), workspaceFlag, nil
+	}
+	if cfg.Persistence.DBPath == config.WorkspaceDBPath(dir, config.DefaultWorkspace) {
+		return cfg.Persistence.DBPath, config.DefaultWorkspace, nil
+	}
+	return cfg.Persistence.DBPath, "", nil
+}
+
+// handleWorkspacesCommand handles the "workspaces" subcommand.
+func handleWorkspacesCommand(args []string) {
+	if len(args) == 0 {
+		printWorkspacesHelp()
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "list":
+		listFlags := flag.NewFlagSet("workspaces list", flag.ExitOnError)
+		_ = listFlags.Parse(args[1:])
+
+		dir, err := config.ConfigDir()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		if err := runWorkspacesList(os.Stdout, dir); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+	case "help", "-help", "--help":
+		printWorkspacesHelp()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown workspaces command: %s\n", args[0])
+		printWorkspacesHelp()
+		os.Exit(1)
+	}
+}
+
+// runWorkspacesList prints the workspace databases in dir.
+func runWorkspacesList(w io.Writer, dir string) error {
+	workspaces, err := config.ListWorkspaces(dir)
+	if err != nil {
+		return err
+	}
+	if len(workspaces) == 0 {
+		fmt.Fprintf(w, "No workspaces in %s\n", dir)
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSIZE\tPATH")
+	for _, ws := range workspaces {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", ws.Name, formatSize(ws.SizeBytes), ws.Path)
+	}
+	return tw.Flush()
+}
+
+// printWorkspacesHelp prints help for the workspaces subcommand.
+func printWorkspacesHelp() {
+	fmt.Printf(`Usage: langley workspaces <command>
+
+Workspaces keep separate datasets, e.g. one per project. Each is a database
+in the config directory: langley.db for "default", langley-{name}.db for
+the rest. Start langley with -workspace <name> to capture into one (it is
+created on first use), and add ?workspace=<name> to API requests to read
+another workspace from a running server.
+
+Commands:
+    list        List workspace databases
+
+Examples:
+    langley workspaces list
+    langley -workspace client-a
+    langley stats -workspace client-a
+`)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/HakAl/langley/internal/config"
+)
+
+func TestResolveDBPath(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.Persistence.DBPath = config.WorkspaceDBPath(dir, config.DefaultWorkspace)
+
+	tests := []struct {
+		name          string
+		db, workspace string
+		wantPath      string
+		wantWorkspace string
+		wantErr       bool
+	}{
+		{name: "config default", wantPath: filepath.Join(dir, "langley.db"), wantWorkspace: "default"},
+		{name: "workspace", workspace: "client-a", wantPath: filepath.Join(dir, "langley-client-a.db"), wantWorkspace: "client-a"},
+		{name: "db override", db: "/tmp/other.db", wantPath: "/tmp/other.db"},
+		{name: "both", db: "/tmp/other.db", workspace: "client-a", wantErr: true},
+		{name: "invalid name", workspace: "../x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, workspace, err := resolveDBPath(cfg, dir, tt.db, tt.workspace)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveDBPath: %v", err)
+			}
+			if path != tt.wantPath || workspace != tt.wantWorkspace {
+				t.Errorf("got (%s, %q), want (%s, %q)", path, workspace, tt.wantPath, tt.wantWorkspace)
+			}
+		})
+	}
+}
+
+func TestRunWorkspacesList(t *testing.T) {
+	dir := t.TempDir()
+
+	var out bytes.Buffer
+	if err := runWorkspacesList(&out, dir); err != nil {
+		t.Fatalf("runWorkspacesList: %v", err)
+	}
+	if !strings.Contains(out.String(), "No workspaces") {
+		t.Errorf("empty dir output = %q", out.String())
+	}
+
+	for _, file := range []string{"langley.db", "langley-beta.db", "langley-alpha.db", "langley.db-wal", "config.yaml", "other.db"} {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte("x"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out.Reset()
+	if err := runWorkspacesList(&out, dir); err != nil {
+		t.Fatalf("runWorkspacesList: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	var names []string
+	for _, line := range lines[1:] {
+		names = append(names, strings.Fields(line)[0])
+	}
+	if got := strings.Join(names, ","); got != "alpha,beta,default" {
+		t.Errorf("listed workspaces %s, want alpha,beta,default\n%s", got, out.String())
+	}
+}
@@ -4,16 +4,20 @@ package analytics
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/HakAl/langley/internal/pricing"
+	"github.com/HakAl/langley/internal/store"
 )
 
 // Engine provides analytics queries and calculations.
 type Engine struct {
 	db            *sql.DB
 	pricingSource *pricing.Source
+	tierState     tierState
 }
 
 // NewEngine creates a new analytics engine.
@@ -39,11 +43,13 @@ type ModelPricing struct {
 
 // GetPricing retrieves pricing for a model.
 // It first checks the LiteLLM pricing source, then falls back to the database.
+// LiteLLM entries without prompt-cache rates take them from the database, so
+// cache tokens are never silently priced at zero.
 func (e *Engine) GetPricing(ctx context.Context, provider, model string) (*ModelPricing, error) {
 	// Try LiteLLM pricing source first
 	if e.pricingSource != nil {
 		if price := e.pricingSource.GetPrice(provider, model); price != nil {
-			return &ModelPricing{
+			result := &ModelPricing{
 				Provider:           price.Provider,
 				ModelPattern:       price.Model,
 				InputCostPer1k:     price.InputCostPer1k,
@@ -51,19 +57,42 @@ func (e *Engine) GetPricing(ctx context.Context, provider, model string) (*Model
 				CacheCreationPer1k: price.CacheCreationPer1k,
 				CacheReadPer1k:     price.CacheReadPer1k,
 				EffectiveDate:      time.Now(), // LiteLLM pricing is always current
-			}, nil
+			}
+			if result.CacheCreationPer1k == nil || result.CacheReadPer1k == nil {
+				db, err := e.dbPricing(ctx, provider, model)
+				if err != nil {
+					return nil, err
+				}
+				if db != nil {
+					if result.CacheCreationPer1k == nil {
+						result.CacheCreationPer1k = db.CacheCreationPer1k
+					}
+					if result.CacheReadPer1k == nil {
+						result.CacheReadPer1k = db.CacheReadPer1k
+					}
+				}
+			}
+			return result, nil
 		}
 	}
 
 	// Fall back to database pricing
+	return e.dbPricing(ctx, provider, model)
+}
+
+// dbPricing looks up the pricing table row in effect for the model: the
+// matching row with the latest effective_date not in the future, preferring
+// the longest (most specific) pattern on a tie. It returns nil if no row
+// matches.
+func (e *Engine) dbPricing(ctx context.Context, provider, model string) (*ModelPricing, error) {
 	row := e.db.QueryRowContext(ctx, `
 		SELECT provider, model_pattern, input_cost_per_1k, output_cost_per_1k,
 		       cache_creation_per_1k, cache_read_per_1k, effective_date
 		FROM pricing
-		WHERE provider = ? AND ? LIKE model_pattern
-		ORDER BY effective_date DESC
+		WHERE provider = ? AND ? LIKE model_pattern AND effective_date <= ?
+		ORDER BY effective_date DESC, length(model_pattern) DESC
 		LIMIT 1
-	`, provider, model)
+	`, provider, model, time.Now().UTC().Format("2006-01-02"))
 
 	var pricingResult ModelPricing
 	var effectiveDate string
@@ -92,12 +121,50 @@ func (e *Engine) GetPricing(ctx context.Context, provider, model string) (*Model
 	return &pricingResult, nil
 }
 
+// UpsertPricing inserts a pricing table row, or replaces the rates of the
+// row with the same provider, pattern and effective date. The pattern is a
+// SQL LIKE pattern matched against model names.
+func (e *Engine) UpsertPricing(ctx context.Context, p *ModelPricing) error {
+	_, err := e.db.ExecContext(ctx, `
+		INSERT INTO pricing (provider, model_pattern, input_cost_per_1k, output_cost_per_1k,
+		                     cache_creation_per_1k, cache_read_per_1k, effective_date)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (provider, model_pattern, effective_date) DO UPDATE SET
+			input_cost_per_1k = excluded.input_cost_per_1k,
+			output_cost_per_1k = excluded.output_cost_per_1k,
+			cache_creation_per_1k = excluded.cache_creation_per_1k,
+			cache_read_per_1k = excluded.cache_read_per_1k
+	`, p.Provider, p.ModelPattern, p.InputCostPer1k, p.OutputCostPer1k,
+		p.CacheCreationPer1k, p.CacheReadPer1k, p.EffectiveDate.Format("2006-01-02"))
+	if err != nil {
+		return fmt.Errorf("upserting pricing for %s/%s: %w", p.Provider, p.ModelPattern, err)
+	}
+	return nil
+}
+
+// localProviders run models on the user's own hardware, so flows to them are
+// free rather than unpriced.
+var localProviders = map[string]bool{"ollama": true}
+
 // CalculateCost computes the cost for token usage.
+// Input/output rates come from the highest volume tier reached this month,
+// if any tiers are configured for the model. The month's volume is kept as
+// a running total of the usage priced here, re-summed every few minutes. Anthropic's input_tokens excludes
+// cached tokens, so cache writes and reads are charged at their own rates on
+// top of it rather than as input. Models served by a local provider (Ollama)
+// cost nothing and are reported with cost source "local".
 func (e *Engine) CalculateCost(ctx context.Context, provider, model string, inputTokens, outputTokens, cacheCreation, cacheRead int) (float64, string, error) {
+	if localProviders[provider] {
+		return 0, "local", nil
+	}
 	pricing, err := e.GetPricing(ctx, provider, model)
 	if err != nil {
 		return 0, "", err
 	}
+	pricing, err = e.applyPricingTier(ctx, provider, model, pricing, int64(inputTokens)+int64(outputTokens))
+	if err != nil {
+		return 0, "", err
+	}
 	if pricing == nil {
 		return 0, "", nil // No pricing available
 	}
@@ -210,7 +277,7 @@ func (e *Engine) ListTaskSummaries(ctx context.Context, start, end time.Time, li
 		GROUP BY task_id
 		ORDER BY total_cost DESC
 		LIMIT ?
-	`, start.Format(time.RFC3339Nano), end.Format(time.RFC3339Nano), limit)
+	`, store.FormatTime(start), store.FormatTime(end), limit)
 	if err != nil {
 		return nil, err
 	}
@@ -255,6 +322,12 @@ type ToolStats struct {
 	AvgDurationMs  float64
 	TotalTokensIn  int
 	TotalTokensOut int
+
+	// Latency percentiles over invocations with a correlated tool_result:
+	// time from the tool_use to the request that carried its result
+	P50DurationMs int64
+	P95DurationMs int64
+	P99DurationMs int64
 }
 
 // GetToolStats returns aggregated statistics for tools.
@@ -273,7 +346,7 @@ func (e *Engine) GetToolStats(ctx context.Context, start, end time.Time) ([]*Too
 		WHERE timestamp >= ? AND timestamp <= ?
 		GROUP BY tool_name
 		ORDER BY invocation_count DESC
-	`, start.Format(time.RFC3339Nano), end.Format(time.RFC3339Nano))
+	`, store.FormatTime(start), store.FormatTime(end))
 	if err != nil {
 		return nil, err
 	}
@@ -302,8 +375,68 @@ func (e *Engine) GetToolStats(ctx context.Context, start, end time.Time) ([]*Too
 
 		stats = append(stats, &s)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := e.fillToolLatencyPercentiles(ctx, stats, start, end); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// fillToolLatencyPercentiles sets the duration percentiles on stats.
+// SQLite has no percentile aggregate, so durations are sorted in SQL and
+// ranked here.
+func (e *Engine) fillToolLatencyPercentiles(ctx context.Context, stats []*ToolStats, start, end time.Time) error {
+	if len(stats) == 0 {
+		return nil
+	}
+
+	rows, err := e.db.QueryContext(ctx, `
+		SELECT tool_name, duration_ms
+		FROM tool_invocations
+		WHERE timestamp >= ? AND timestamp <= ? AND duration_ms IS NOT NULL
+		ORDER BY tool_name, duration_ms
+	`, store.FormatTime(start), store.FormatTime(end))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	durations := make(map[string][]int64)
+	for rows.Next() {
+		var name string
+		var ms int64
+		if err := rows.Scan(&name, &ms); err != nil {
+			return err
+		}
+		durations[name] = append(durations[name], ms)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-	return stats, rows.Err()
+	for _, s := range stats {
+		sorted := durations[s.ToolName]
+		s.P50DurationMs = percentile(sorted, 50)
+		s.P95DurationMs = percentile(sorted, 95)
+		s.P99DurationMs = percentile(sorted, 99)
+	}
+	return nil
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted, or 0 if empty.
+func percentile(sorted []int64, p int) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100 // ceil(p/100 * n)
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
 }
 
 // CostByPeriod represents cost aggregated by time period.
@@ -328,7 +461,42 @@ func (e *Engine) GetCostByDay(ctx context.Context, start, end time.Time) ([]*Cos
 		WHERE timestamp >= ? AND timestamp <= ?
 		GROUP BY date(timestamp)
 		ORDER BY period
-	`, start.Format(time.RFC3339Nano), end.Format(time.RFC3339Nano))
+	`, store.FormatTime(start), store.FormatTime(end))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var periods []*CostByPeriod
+	for rows.Next() {
+		var p CostByPeriod
+		err := rows.Scan(&p.Period, &p.FlowCount, &p.TotalCost, &p.TotalTokensIn, &p.TotalTokensOut)
+		if err != nil {
+			return nil, err
+		}
+		periods = append(periods, &p)
+	}
+
+	return periods, rows.Err()
+}
+
+// GetCostByHour returns hourly cost breakdown. Periods are RFC 3339 UTC hours
+// (e.g. "2026-03-04T09:00:00Z"). Stored timestamps may carry any UTC offset,
+// so both bucketing and the range filter compare normalized instants rather
+// than timestamp strings.
+func (e *Engine) GetCostByHour(ctx context.Context, start, end time.Time) ([]*CostByPeriod, error) {
+	rows, err := e.db.QueryContext(ctx, `
+		SELECT
+			strftime('%Y-%m-%dT%H:00:00Z', timestamp) as period,
+			COUNT(*) as flow_count,
+			COALESCE(SUM(total_cost), 0) as total_cost,
+			COALESCE(SUM(input_tokens), 0) as total_in,
+			COALESCE(SUM(output_tokens), 0) as total_out
+		FROM flows
+		WHERE timestamp >= ? AND timestamp <= ?
+		GROUP BY period
+		ORDER BY period
+	`, store.FormatTime(start), store.FormatTime(end))
 	if err != nil {
 		return nil, err
 	}
@@ -360,7 +528,7 @@ func (e *Engine) GetCostByModel(ctx context.Context, start, end time.Time) ([]*C
 		WHERE timestamp >= ? AND timestamp <= ?
 		GROUP BY model
 		ORDER BY total_cost DESC
-	`, start.Format(time.RFC3339Nano), end.Format(time.RFC3339Nano))
+	`, store.FormatTime(start), store.FormatTime(end))
 	if err != nil {
 		return nil, err
 	}
@@ -389,6 +557,17 @@ type OverallStats struct {
 	TotalToolCalls  int
 	AvgCostPerFlow  float64
 	AvgTokensPerFlow float64
+	ByProvider      []*StatsFacet // Most expensive first
+	ByModel         []*StatsFacet // Most expensive first
+}
+
+// StatsFacet is the share of OverallStats for one provider or model.
+type StatsFacet struct {
+	Name           string
+	FlowCount      int
+	TotalCost      float64
+	TotalTokensIn  int
+	TotalTokensOut int
 }
 
 // GetOverallStats returns summary statistics for a time range.
@@ -405,7 +584,7 @@ func (e *Engine) GetOverallStats(ctx context.Context, start, end time.Time) (*Ov
 			COUNT(DISTINCT task_id) as total_tasks
 		FROM flows
 		WHERE timestamp >= ? AND timestamp <= ?
-	`, start.Format(time.RFC3339Nano), end.Format(time.RFC3339Nano))
+	`, store.FormatTime(start), store.FormatTime(end))
 
 	err := row.Scan(
 		&stats.TotalFlows,
@@ -422,7 +601,7 @@ func (e *Engine) GetOverallStats(ctx context.Context, start, end time.Time) (*Ov
 	row = e.db.QueryRowContext(ctx, `
 		SELECT COUNT(*) FROM tool_invocations
 		WHERE timestamp >= ? AND timestamp <= ?
-	`, start.Format(time.RFC3339Nano), end.Format(time.RFC3339Nano))
+	`, store.FormatTime(start), store.FormatTime(end))
 	_ = row.Scan(&stats.TotalToolCalls)
 
 	// Calculate averages
@@ -431,5 +610,80 @@ func (e *Engine) GetOverallStats(ctx context.Context, start, end time.Time) (*Ov
 		stats.AvgTokensPerFlow = float64(stats.TotalTokensIn+stats.TotalTokensOut) / float64(stats.TotalFlows)
 	}
 
+	stats.ByProvider, stats.ByModel, err = e.getStatsFacets(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+
 	return &stats, nil
 }
+
+// getStatsFacets breaks a time range down by provider and by model. Both
+// come from one query grouped by the pair, folded per facet here.
+func (e *Engine) getStatsFacets(ctx context.Context, start, end time.Time) (byProvider, byModel []*StatsFacet, err error) {
+	rows, err := e.db.QueryContext(ctx, `
+		SELECT
+			provider,
+			COALESCE(model, 'unknown') as model,
+			COUNT(*) as flow_count,
+			COALESCE(SUM(total_cost), 0) as total_cost,
+			COALESCE(SUM(input_tokens), 0) as total_in,
+			COALESCE(SUM(output_tokens), 0) as total_out
+		FROM flows
+		WHERE timestamp >= ? AND timestamp <= ?
+		GROUP BY provider, COALESCE(model, 'unknown')
+	`, store.FormatTime(start), store.FormatTime(end))
+	if err != nil {
+		return nil, nil, fmt.Errorf("querying stats facets: %w", err)
+	}
+	defer rows.Close()
+
+	providers := make(map[string]*StatsFacet)
+	models := make(map[string]*StatsFacet)
+	for rows.Next() {
+		var provider, model string
+		var row StatsFacet
+		if err := rows.Scan(&provider, &model, &row.FlowCount, &row.TotalCost, &row.TotalTokensIn, &row.TotalTokensOut); err != nil {
+			return nil, nil, fmt.Errorf("scanning stats facet: %w", err)
+		}
+		addFacet(providers, provider, &row)
+		addFacet(models, model, &row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("iterating stats facets: %w", err)
+	}
+
+	return sortedFacets(providers), sortedFacets(models), nil
+}
+
+// addFacet adds row's counts to the facet called name.
+func addFacet(facets map[string]*StatsFacet, name string, row *StatsFacet) {
+	f, ok := facets[name]
+	if !ok {
+		f = &StatsFacet{Name: name}
+		facets[name] = f
+	}
+	f.FlowCount += row.FlowCount
+	f.TotalCost += row.TotalCost
+	f.TotalTokensIn += row.TotalTokensIn
+	f.TotalTokensOut += row.TotalTokensOut
+}
+
+// sortedFacets orders facets by cost, then flow count, then name, so the
+// order is stable for facets that cost nothing.
+func sortedFacets(facets map[string]*StatsFacet) []*StatsFacet {
+	out := make([]*StatsFacet, 0, len(facets))
+	for _, f := range facets {
+		out = append(out, f)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].TotalCost != out[j].TotalCost {
+			return out[i].TotalCost > out[j].TotalCost
+		}
+		if out[i].FlowCount != out[j].FlowCount {
+			return out[i].FlowCount > out[j].FlowCount
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
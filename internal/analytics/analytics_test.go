@@ -0,0 +1,331 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/pricing"
+	"github.com/HakAl/langley/internal/store"
+)
+
+func TestGetToolStats_LatencyPercentiles(t *testing.T) {
+	engine, s := setupTestEngine(t)
+	ctx := context.Background()
+
+	base := time.Now().Add(-time.Hour)
+	flow := &store.Flow{ID: "flow-1", Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages",
+		Timestamp: base, FlowIntegrity: "complete", Provider: "anthropic"}
+	if err := s.SaveFlow(ctx, flow); err != nil {
+		t.Fatalf("SaveFlow: %v", err)
+	}
+
+	// Bash: 100ms..1000ms in 100ms steps; Read: one uncorrelated invocation
+	for i := 1; i <= 10; i++ {
+		toolUseID := fmt.Sprintf("toolu_%d", i)
+		ts := base.Add(time.Duration(i) * time.Minute)
+		inv := &store.ToolInvocation{ID: toolUseID, FlowID: "flow-1", ToolUseID: &toolUseID,
+			ToolName: "Bash", Timestamp: ts}
+		if err := s.SaveToolInvocation(ctx, inv); err != nil {
+			t.Fatalf("SaveToolInvocation: %v", err)
+		}
+		resultTime := ts.Add(time.Duration(i*100) * time.Millisecond)
+		if err := s.UpdateToolResult(ctx, toolUseID, true, nil, nil, resultTime); err != nil {
+			t.Fatalf("UpdateToolResult: %v", err)
+		}
+	}
+	if err := s.SaveToolInvocation(ctx, &store.ToolInvocation{ID: "read-1", FlowID: "flow-1",
+		ToolName: "Read", Timestamp: base.Add(time.Minute)}); err != nil {
+		t.Fatalf("SaveToolInvocation: %v", err)
+	}
+
+	stats, err := engine.GetToolStats(ctx, base, time.Now())
+	if err != nil {
+		t.Fatalf("GetToolStats: %v", err)
+	}
+	byName := make(map[string]*ToolStats)
+	for _, st := range stats {
+		byName[st.ToolName] = st
+	}
+
+	// Durations go through julianday, so allow a millisecond of rounding
+	near := func(got, want int64) bool { return got >= want-1 && got <= want+1 }
+	bash := byName["Bash"]
+	if bash == nil {
+		t.Fatal("no stats for Bash")
+	}
+	if !near(bash.P50DurationMs, 500) || !near(bash.P95DurationMs, 1000) || !near(bash.P99DurationMs, 1000) {
+		t.Errorf("Bash p50/p95/p99 = %d/%d/%d, want 500/1000/1000",
+			bash.P50DurationMs, bash.P95DurationMs, bash.P99DurationMs)
+	}
+
+	read := byName["Read"]
+	if read == nil {
+		t.Fatal("no stats for Read")
+	}
+	if read.P50DurationMs != 0 || read.P99DurationMs != 0 {
+		t.Errorf("Read without results should have zero percentiles, got %d/%d", read.P50DurationMs, read.P99DurationMs)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		sorted []int64
+		p      int
+		want   int64
+	}{
+		{nil, 50, 0},
+		{[]int64{7}, 99, 7},
+		{[]int64{1, 2, 3, 4}, 50, 2},
+		{[]int64{1, 2, 3, 4}, 95, 4},
+		{[]int64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}, 95, 100},
+	}
+	for _, tt := range tests {
+		if got := percentile(tt.sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(%v, %d) = %d, want %d", tt.sorted, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestGetCostByHour(t *testing.T) {
+	engine, s := setupTestEngine(t)
+	ctx := context.Background()
+
+	// Timestamps are stored with whatever offset the flow carried; buckets
+	// must line up on UTC hours regardless.
+	ist := time.FixedZone("IST", 5*3600+1800)
+	pdt := time.FixedZone("PDT", -7*3600)
+	base := time.Date(2026, 3, 4, 9, 0, 0, 0, time.UTC)
+	cost := func(v float64) *float64 { return &v }
+	tokens := func(v int) *int { return &v }
+	flows := []struct {
+		id  string
+		ts  time.Time
+		usd float64
+	}{
+		{"h9-a", base.Add(5 * time.Minute), 1.25},
+		{"h9-b", base.Add(59*time.Minute + 59*time.Second + 999*time.Millisecond).In(ist), 0.75},
+		{"h10-a", base.Add(time.Hour).In(pdt), 2.0},
+		{"h12-a", base.Add(3*time.Hour + 30*time.Minute).In(ist), 0.5},
+		{"h12-b", base.Add(3*time.Hour + 45*time.Minute + 123456789), 0.25},
+		{"before", base.Add(-time.Minute).In(pdt), 100},
+	}
+	for _, f := range flows {
+		flow := &store.Flow{ID: f.id, Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages",
+			Timestamp: f.ts, FlowIntegrity: "complete", Provider: "anthropic",
+			TotalCost: cost(f.usd), InputTokens: tokens(100), OutputTokens: tokens(10)}
+		if err := s.SaveFlow(ctx, flow); err != nil {
+			t.Fatalf("SaveFlow(%s): %v", f.id, err)
+		}
+	}
+
+	// The range itself is given in yet another zone
+	periods, err := engine.GetCostByHour(ctx, base.In(ist), base.Add(4*time.Hour).In(pdt))
+	if err != nil {
+		t.Fatalf("GetCostByHour: %v", err)
+	}
+
+	want := []struct {
+		period string
+		count  int
+		usd    float64
+	}{
+		{"2026-03-04T09:00:00Z", 2, 2.0},
+		{"2026-03-04T10:00:00Z", 1, 2.0},
+		{"2026-03-04T12:00:00Z", 2, 0.75},
+	}
+	if len(periods) != len(want) {
+		for _, p := range periods {
+			t.Logf("period %+v", *p)
+		}
+		t.Fatalf("got %d periods, want %d", len(periods), len(want))
+	}
+	for i, w := range want {
+		p := periods[i]
+		if p.Period != w.period || p.FlowCount != w.count || p.TotalCost != w.usd {
+			t.Errorf("periods[%d] = %+v, want %s with %d flows costing %.2f", i, *p, w.period, w.count, w.usd)
+		}
+		if p.TotalTokensIn != 100*w.count || p.TotalTokensOut != 10*w.count {
+			t.Errorf("periods[%d] tokens = %d/%d", i, p.TotalTokensIn, p.TotalTokensOut)
+		}
+	}
+	if _, err := time.Parse(time.RFC3339, periods[0].Period); err != nil {
+		t.Errorf("period %q is not RFC 3339: %v", periods[0].Period, err)
+	}
+}
+
+func TestGetOverallStats_Facets(t *testing.T) {
+	engine, s := setupTestEngine(t)
+	ctx := context.Background()
+
+	base := time.Date(2026, 3, 4, 9, 0, 0, 0, time.UTC)
+	cost := func(v float64) *float64 { return &v }
+	tokens := func(v int) *int { return &v }
+	model := func(v string) *string { return &v }
+	flows := []struct {
+		provider string
+		model    *string
+		usd      float64
+		in, out  int
+	}{
+		{"anthropic", model("claude-sonnet-4"), 1.5, 1000, 100},
+		{"anthropic", model("claude-sonnet-4"), 0.5, 500, 50},
+		{"anthropic", model("claude-haiku-4"), 0.25, 2000, 20},
+		{"openai", model("gpt-4o"), 2.0, 300, 300},
+		{"openai", nil, 0, 10, 1}, // No model reported
+		{"ollama", model("llama3"), 0, 40, 4},
+	}
+	for i, f := range flows {
+		flow := &store.Flow{ID: fmt.Sprintf("f%d", i), Host: "api.example.com", Method: "POST", Path: "/v1/messages",
+			Timestamp: base.Add(time.Duration(i) * time.Minute), FlowIntegrity: "complete", Provider: f.provider,
+			Model: f.model, TotalCost: cost(f.usd), InputTokens: tokens(f.in), OutputTokens: tokens(f.out)}
+		if err := s.SaveFlow(ctx, flow); err != nil {
+			t.Fatalf("SaveFlow(%d): %v", i, err)
+		}
+	}
+
+	stats, err := engine.GetOverallStats(ctx, base, base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetOverallStats: %v", err)
+	}
+
+	wantProviders := []string{"anthropic", "openai", "ollama"}
+	// claude-sonnet-4 ties gpt-4o on cost and wins on flow count; llama3 and
+	// unknown tie on both and fall back to name
+	wantModels := []string{"claude-sonnet-4", "gpt-4o", "claude-haiku-4", "llama3", "unknown"}
+	for _, tc := range []struct {
+		name   string
+		facets []*StatsFacet
+		want   []string
+	}{
+		{"ByProvider", stats.ByProvider, wantProviders},
+		{"ByModel", stats.ByModel, wantModels},
+	} {
+		var names []string
+		var sum StatsFacet
+		for _, f := range tc.facets {
+			names = append(names, f.Name)
+			sum.FlowCount += f.FlowCount
+			sum.TotalCost += f.TotalCost
+			sum.TotalTokensIn += f.TotalTokensIn
+			sum.TotalTokensOut += f.TotalTokensOut
+		}
+		if fmt.Sprint(names) != fmt.Sprint(tc.want) {
+			t.Errorf("%s = %v, want %v", tc.name, names, tc.want)
+		}
+		if sum.FlowCount != stats.TotalFlows || math.Abs(sum.TotalCost-stats.TotalCost) > 1e-9 ||
+			sum.TotalTokensIn != stats.TotalTokensIn || sum.TotalTokensOut != stats.TotalTokensOut {
+			t.Errorf("%s sums to %+v, want totals %d flows, %.2f, %d/%d tokens", tc.name, sum,
+				stats.TotalFlows, stats.TotalCost, stats.TotalTokensIn, stats.TotalTokensOut)
+		}
+	}
+
+	anthropic := stats.ByProvider[0]
+	if anthropic.FlowCount != 3 || anthropic.TotalCost != 2.25 || anthropic.TotalTokensIn != 3500 || anthropic.TotalTokensOut != 170 {
+		t.Errorf("anthropic facet = %+v", *anthropic)
+	}
+}
+
+func TestUpsertPricing(t *testing.T) {
+	engine, _ := setupTestEngine(t)
+	ctx := context.Background()
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	upsert := func(pattern string, input float64, effective time.Time) {
+		t.Helper()
+		err := engine.UpsertPricing(ctx, &ModelPricing{
+			Provider: "anthropic", ModelPattern: pattern,
+			InputCostPer1k: input, OutputCostPer1k: 1, EffectiveDate: effective,
+		})
+		if err != nil {
+			t.Fatalf("UpsertPricing(%s): %v", pattern, err)
+		}
+	}
+	rate := func(model string) float64 {
+		t.Helper()
+		p, err := engine.GetPricing(ctx, "anthropic", model)
+		if err != nil || p == nil {
+			t.Fatalf("GetPricing(%s) = %v, %v", model, p, err)
+		}
+		return p.InputCostPer1k
+	}
+
+	// Newer effective date beats the seeded row; same key replaces the rate
+	upsert("claude-sonnet-4%", 0.004, today)
+	upsert("claude-sonnet-4%", 0.005, today)
+	if got := rate("claude-sonnet-4-20250514"); got != 0.005 {
+		t.Errorf("rate = %v, want 0.005", got)
+	}
+
+	// Same date: the more specific pattern wins
+	upsert("claude-sonnet-4-2025%", 0.006, today)
+	if got := rate("claude-sonnet-4-20250514"); got != 0.006 {
+		t.Errorf("rate = %v, want 0.006 (specific pattern)", got)
+	}
+
+	// Future rates aren't in effect yet
+	upsert("claude-sonnet-4%", 0.009, today.AddDate(0, 0, 1))
+	if got := rate("claude-sonnet-4-20250514"); got != 0.006 {
+		t.Errorf("rate = %v, want 0.006 (future rate ignored)", got)
+	}
+}
+
+func TestCalculateCost_LocalProvider(t *testing.T) {
+	engine, _ := setupTestEngine(t)
+	cost, source, err := engine.CalculateCost(context.Background(), "ollama", "llama3.2", 1000, 500, 0, 0)
+	if err != nil || cost != 0 || source != "local" {
+		t.Errorf("CalculateCost(ollama) = %v, %q, %v; want 0, local", cost, source, err)
+	}
+}
+
+func TestCalculateCost_CacheTokens(t *testing.T) {
+	ctx := context.Background()
+
+	// Seeded claude-sonnet-4% rates per 1k: input 0.003, output 0.015,
+	// cache creation 0.00375, cache read 0.0003
+	const (
+		input, output, cacheCreation, cacheRead = 1000, 500, 2000, 10000
+		want                                    = 1000*0.003/1000 + 500*0.015/1000 + 2000*0.00375/1000 + 10000*0.0003/1000
+	)
+
+	t.Run("database pricing", func(t *testing.T) {
+		engine, _ := setupTestEngine(t)
+		cost, source, err := engine.CalculateCost(ctx, "anthropic", "claude-sonnet-4-20250514", input, output, cacheCreation, cacheRead)
+		if err != nil {
+			t.Fatalf("CalculateCost: %v", err)
+		}
+		if source != "exact" {
+			t.Errorf("cost source = %q, want exact", source)
+		}
+		if math.Abs(cost-want) > 1e-9 {
+			t.Errorf("cost = %v, want %v", cost, want)
+		}
+	})
+
+	t.Run("LiteLLM entry without cache rates", func(t *testing.T) {
+		engine, _ := setupTestEngine(t)
+		dir := t.TempDir()
+		data := `{"claude-sonnet-4-20250514": {"litellm_provider": "anthropic", "input_cost_per_token": 0.000003, "output_cost_per_token": 0.000015, "mode": "chat"}}`
+		if err := os.WriteFile(filepath.Join(dir, "litellm_pricing.json"), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		source := pricing.NewSource(pricing.Config{CacheDir: dir})
+		if err := source.Load(ctx); err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		engine.SetPricingSource(source)
+
+		// Cache rates come from the pricing table instead of counting as free
+		cost, _, err := engine.CalculateCost(ctx, "anthropic", "claude-sonnet-4-20250514", input, output, cacheCreation, cacheRead)
+		if err != nil {
+			t.Fatalf("CalculateCost: %v", err)
+		}
+		if math.Abs(cost-want) > 1e-9 {
+			t.Errorf("cost = %v, want %v", cost, want)
+		}
+	})
+}
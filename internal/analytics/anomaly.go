@@ -3,6 +3,8 @@ package analytics
 import (
 	"context"
 	"time"
+
+	"github.com/HakAl/langley/internal/store"
 )
 
 // AnomalyType identifies the kind of anomaly detected.
@@ -32,28 +34,41 @@ type Anomaly struct {
 
 // AnomalyThresholds configures what triggers anomaly detection.
 type AnomalyThresholds struct {
-	LargeContextTokens  int           // Input tokens above this = large context
-	SlowResponseMs      int64         // Duration above this = slow response
-	RapidRepeatWindow   time.Duration // Window for detecting rapid repeats
-	RapidRepeatCount    int           // Number of similar requests to trigger
-	HighCostDollars     float64       // Cost above this = high cost
-	ManyToolCallsCount  int           // Tool calls above this = many tool calls
+	LargeContextTokens int           // Input tokens above this = large context
+	SlowResponseMs     int64         // Duration above this = slow response
+	RapidRepeatWindow  time.Duration // Window for detecting rapid repeats
+	RapidRepeatCount   int           // Number of similar requests to trigger
+	HighCostDollars    float64       // Cost above this = high cost
+	ManyToolCallsCount int           // Tool calls above this = many tool calls
+	LatencySigma       float64       // Std devs above the model's mean duration = slow response (0 = disabled)
+	LatencyMinSamples  int           // Flows of the model needed before latency outliers are flagged
+	LatencyWindow      time.Duration // How far back a flow's latency baseline reaches
 }
 
 // DefaultThresholds returns sensible default anomaly thresholds.
 func DefaultThresholds() *AnomalyThresholds {
 	return &AnomalyThresholds{
-		LargeContextTokens:  100000, // 100k tokens
-		SlowResponseMs:      30000,  // 30 seconds
-		RapidRepeatWindow:   60 * time.Second,
-		RapidRepeatCount:    5,
-		HighCostDollars:     1.0, // $1 per request
-		ManyToolCallsCount:  20,
+		LargeContextTokens: 100000, // 100k tokens
+		SlowResponseMs:     30000,  // 30 seconds
+		RapidRepeatWindow:  60 * time.Second,
+		RapidRepeatCount:   5,
+		HighCostDollars:    1.0, // $1 per request
+		ManyToolCallsCount: 20,
+		LatencySigma:       3,
+		LatencyMinSamples:  10,
+		LatencyWindow:      7 * 24 * time.Hour,
 	}
 }
 
-// DetectFlowAnomalies checks a single flow for anomalies.
+// DetectFlowAnomalies checks a single flow for anomalies, including
+// whether its duration is an outlier for its model.
 func (e *Engine) DetectFlowAnomalies(ctx context.Context, flowID string, thresholds *AnomalyThresholds) ([]*Anomaly, error) {
+	return e.detectFlowAnomalies(ctx, flowID, thresholds, true)
+}
+
+// detectFlowAnomalies checks a single flow. ListRecentAnomalies finds latency
+// outliers in one pass instead, so it skips the per-flow latency check.
+func (e *Engine) detectFlowAnomalies(ctx context.Context, flowID string, thresholds *AnomalyThresholds, checkLatency bool) ([]*Anomaly, error) {
 	if thresholds == nil {
 		thresholds = DefaultThresholds()
 	}
@@ -62,17 +77,17 @@ func (e *Engine) DetectFlowAnomalies(ctx context.Context, flowID string, thresho
 
 	// Get flow details
 	row := e.db.QueryRowContext(ctx, `
-		SELECT id, task_id, timestamp, duration_ms, input_tokens, total_cost, events_dropped_count
+		SELECT id, task_id, model, timestamp, duration_ms, input_tokens, total_cost, events_dropped_count
 		FROM flows WHERE id = ?
 	`, flowID)
 
 	var id string
-	var taskID *string
+	var taskID, model *string
 	var ts string
 	var durationMs, inputTokens, droppedCount *int64
 	var totalCost *float64
 
-	err := row.Scan(&id, &taskID, &ts, &durationMs, &inputTokens, &totalCost, &droppedCount)
+	err := row.Scan(&id, &taskID, &model, &ts, &durationMs, &inputTokens, &totalCost, &droppedCount)
 	if err != nil {
 		return nil, err
 	}
@@ -94,7 +109,8 @@ func (e *Engine) DetectFlowAnomalies(ctx context.Context, flowID string, thresho
 	}
 
 	// Check slow response
-	if durationMs != nil && *durationMs > thresholds.SlowResponseMs {
+	slow := durationMs != nil && *durationMs > thresholds.SlowResponseMs
+	if slow {
 		anomalies = append(anomalies, &Anomaly{
 			Type:        AnomalySlowResponse,
 			FlowID:      flowID,
@@ -107,6 +123,20 @@ func (e *Engine) DetectFlowAnomalies(ctx context.Context, flowID string, thresho
 		})
 	}
 
+	// Check slow response relative to the model's usual latency, unless
+	// it's already flagged slow
+	if checkLatency && !slow && durationMs != nil && model != nil {
+		outlier, err := e.detectFlowLatencyAnomaly(ctx, latencySample{
+			id: flowID, taskID: taskID, model: *model, timestamp: timestamp, durationMs: float64(*durationMs),
+		}, thresholds)
+		if err != nil {
+			return anomalies, err
+		}
+		if outlier != nil {
+			anomalies = append(anomalies, outlier)
+		}
+	}
+
 	// Check high cost
 	if totalCost != nil && *totalCost > thresholds.HighCostDollars {
 		anomalies = append(anomalies, &Anomaly{
@@ -208,13 +238,13 @@ func (e *Engine) DetectRapidRepeats(ctx context.Context, thresholds *AnomalyThre
 				MAX(timestamp) as last_ts,
 				GROUP_CONCAT(id) as flow_ids
 			FROM flows
-			WHERE timestamp >= datetime('now', ?)
+			WHERE timestamp >= ?
 			GROUP BY host, path, task_id
 			HAVING COUNT(*) >= ?
 		)
 		SELECT host, path, task_id, count, first_ts, flow_ids
 		FROM repeat_groups
-	`, "-"+thresholds.RapidRepeatWindow.String(), thresholds.RapidRepeatCount)
+	`, store.FormatTime(time.Now().Add(-thresholds.RapidRepeatWindow)), thresholds.RapidRepeatCount)
 	if err != nil {
 		return nil, err
 	}
@@ -259,7 +289,7 @@ func (e *Engine) ListRecentAnomalies(ctx context.Context, since time.Time, thres
 	// Get recent flow IDs
 	rows, err := e.db.QueryContext(ctx, `
 		SELECT id FROM flows WHERE timestamp >= ? ORDER BY timestamp DESC LIMIT 100
-	`, since.Format(time.RFC3339Nano))
+	`, store.FormatTime(since))
 	if err != nil {
 		return nil, err
 	}
@@ -273,14 +303,31 @@ func (e *Engine) ListRecentAnomalies(ctx context.Context, since time.Time, thres
 	}
 
 	// Check each flow for anomalies
+	slow := make(map[string]bool)
 	for _, flowID := range flowIDs {
-		anomalies, err := e.DetectFlowAnomalies(ctx, flowID, thresholds)
+		anomalies, err := e.detectFlowAnomalies(ctx, flowID, thresholds, false)
 		if err != nil {
 			continue
 		}
+		for _, a := range anomalies {
+			if a.Type == AnomalySlowResponse {
+				slow[a.FlowID] = true
+			}
+		}
 		allAnomalies = append(allAnomalies, anomalies...)
 	}
 
+	// Check for latency outliers against each model's baseline, skipping
+	// flows already flagged slow by the absolute threshold
+	latency, err := e.DetectLatencyAnomalies(ctx, since, thresholds)
+	if err == nil {
+		for _, a := range latency {
+			if !slow[a.FlowID] {
+				allAnomalies = append(allAnomalies, a)
+			}
+		}
+	}
+
 	// Also check for rapid repeats
 	rapidRepeats, err := e.DetectRapidRepeats(ctx, thresholds)
 	if err == nil {
@@ -312,7 +359,7 @@ func (e *Engine) getDropLogAnomalies(ctx context.Context, since time.Time) ([]*A
 		GROUP BY flow_id, priority
 		ORDER BY drop_count DESC
 		LIMIT 50
-	`, since.Format(time.RFC3339Nano))
+	`, store.FormatTime(since))
 	if err != nil {
 		return nil, err
 	}
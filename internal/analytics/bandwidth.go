@@ -0,0 +1,67 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+// bandwidthBucketFormats maps GetBandwidth bucket names to strftime formats.
+var bandwidthBucketFormats = map[string]string{
+	"hour": "%Y-%m-%dT%H:00:00Z",
+	"day":  "%Y-%m-%dT00:00:00Z",
+}
+
+// ValidBandwidthBucket reports whether bucket is accepted by GetBandwidth.
+func ValidBandwidthBucket(bucket string) bool {
+	_, ok := bandwidthBucketFormats[bucket]
+	return ok
+}
+
+// BandwidthPoint is the body bytes transferred over one time bucket.
+type BandwidthPoint struct {
+	Period        string // Bucket start, RFC 3339 UTC
+	FlowCount     int
+	RequestBytes  int64
+	ResponseBytes int64
+}
+
+// GetBandwidth sums the request and response body sizes of flows between
+// start and end per bucket, oldest first. Flows saved before body sizes
+// were recorded are left out.
+func (e *Engine) GetBandwidth(ctx context.Context, start, end time.Time, bucket string) ([]*BandwidthPoint, error) {
+	format, ok := bandwidthBucketFormats[bucket]
+	if !ok {
+		return nil, fmt.Errorf("unknown bandwidth bucket %q", bucket)
+	}
+
+	rows, err := e.db.QueryContext(ctx, `
+		SELECT
+			strftime(?, timestamp) as period,
+			COUNT(*) as flow_count,
+			COALESCE(SUM(request_body_size), 0),
+			COALESCE(SUM(response_body_size), 0)
+		FROM flows
+		WHERE timestamp >= ? AND timestamp <= ?
+			AND (request_body_size IS NOT NULL OR response_body_size IS NOT NULL)
+		GROUP BY period
+		ORDER BY period
+	`, format, store.FormatTime(start), store.FormatTime(end))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []*BandwidthPoint{}
+	for rows.Next() {
+		var p BandwidthPoint
+		if err := rows.Scan(&p.Period, &p.FlowCount, &p.RequestBytes, &p.ResponseBytes); err != nil {
+			return nil, err
+		}
+		points = append(points, &p)
+	}
+
+	return points, rows.Err()
+}
@@ -0,0 +1,66 @@
+package analytics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+	"github.com/HakAl/langley/internal/testutil"
+)
+
+func TestGetBandwidth(t *testing.T) {
+	engine, s := setupTestEngine(t)
+	ctx := context.Background()
+
+	base := time.Date(2026, 5, 6, 12, 0, 0, 0, time.UTC)
+	flow := func(id string, at time.Duration, reqSize, respSize *int64) *store.Flow {
+		f := testutil.NewFlow().WithID(id).WithProvider("anthropic").Build()
+		f.Timestamp = base.Add(at)
+		f.RequestBodySize, f.ResponseBodySize = reqSize, respSize
+		return f
+	}
+	size := func(n int64) *int64 { return &n }
+
+	flows := []*store.Flow{
+		flow("a", 5*time.Minute, size(1000), size(20000)),
+		flow("b", 40*time.Minute, size(3000), size(500)),
+		flow("c", 65*time.Minute, size(200), nil), // Response never arrived
+		flow("legacy", 10*time.Minute, nil, nil),  // Saved before sizes were recorded
+		flow("old", -2*time.Hour, size(9999), size(9999)),
+	}
+	for _, f := range flows {
+		if err := s.SaveFlow(ctx, f); err != nil {
+			t.Fatalf("SaveFlow(%s): %v", f.ID, err)
+		}
+	}
+
+	hourly, err := engine.GetBandwidth(ctx, base, base.Add(2*time.Hour), "hour")
+	if err != nil {
+		t.Fatalf("GetBandwidth(hour): %v", err)
+	}
+	want := []BandwidthPoint{
+		{Period: "2026-05-06T12:00:00Z", FlowCount: 2, RequestBytes: 4000, ResponseBytes: 20500},
+		{Period: "2026-05-06T13:00:00Z", FlowCount: 1, RequestBytes: 200, ResponseBytes: 0},
+	}
+	if len(hourly) != len(want) {
+		t.Fatalf("got %d hourly points, want %d", len(hourly), len(want))
+	}
+	for i, w := range want {
+		if *hourly[i] != w {
+			t.Errorf("point %d = %+v, want %+v", i, *hourly[i], w)
+		}
+	}
+
+	daily, err := engine.GetBandwidth(ctx, base.Add(-3*time.Hour), base.Add(2*time.Hour), "day")
+	if err != nil {
+		t.Fatalf("GetBandwidth(day): %v", err)
+	}
+	if len(daily) != 1 || *daily[0] != (BandwidthPoint{Period: "2026-05-06T00:00:00Z", FlowCount: 4, RequestBytes: 14199, ResponseBytes: 30499}) {
+		t.Errorf("daily = %+v, want one day with 4 flows", daily)
+	}
+
+	if _, err := engine.GetBandwidth(ctx, base, base.Add(time.Hour), "week"); err == nil {
+		t.Error("unknown bucket accepted, want error")
+	}
+}
@@ -0,0 +1,78 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+// BudgetAlert reports that a day's spend has reached the daily budget.
+type BudgetAlert struct {
+	Date     string  `json:"date"` // UTC calendar day, YYYY-MM-DD
+	LimitUSD float64 `json:"limit_usd"`
+	SpentUSD float64 `json:"spent_usd"`
+}
+
+// BudgetMonitor checks today's spend against a daily budget and alerts once
+// per UTC day when it is crossed.
+type BudgetMonitor struct {
+	engine   *Engine
+	dailyUSD float64
+
+	mu         sync.Mutex
+	alertedDay string // Day an alert last fired for
+}
+
+// NewBudgetMonitor creates a monitor for the given daily budget in USD.
+func NewBudgetMonitor(engine *Engine, dailyUSD float64) *BudgetMonitor {
+	return &BudgetMonitor{engine: engine, dailyUSD: dailyUSD}
+}
+
+// Check sums the spend for now's UTC day and returns an alert if it has
+// reached the budget and no alert has fired yet that day. Otherwise it
+// returns nil.
+func (m *BudgetMonitor) Check(ctx context.Context, now time.Time) (*BudgetAlert, error) {
+	if m.dailyUSD <= 0 {
+		return nil, nil
+	}
+
+	dayStart := startOfDay(now)
+	day := dayStart.Format("2006-01-02")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.alertedDay == day {
+		return nil, nil
+	}
+
+	spent, err := m.engine.GetTotalCost(ctx, dayStart, dayStart.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, fmt.Errorf("summing daily cost: %w", err)
+	}
+	if spent < m.dailyUSD {
+		return nil, nil
+	}
+
+	m.alertedDay = day
+	return &BudgetAlert{Date: day, LimitUSD: m.dailyUSD, SpentUSD: spent}, nil
+}
+
+// GetTotalCost returns the total cost of flows with start <= timestamp < end.
+func (e *Engine) GetTotalCost(ctx context.Context, start, end time.Time) (float64, error) {
+	var total float64
+	err := e.db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(total_cost), 0)
+		FROM flows
+		WHERE timestamp >= ? AND timestamp < ?
+	`, store.FormatTime(start), store.FormatTime(end)).Scan(&total)
+	return total, err
+}
+
+// startOfDay returns midnight UTC on t's day.
+func startOfDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
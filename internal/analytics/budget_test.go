@@ -0,0 +1,80 @@
+package analytics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+func TestBudgetMonitor_AlertsOncePerDay(t *testing.T) {
+	engine, s := setupTestEngine(t)
+	ctx := context.Background()
+
+	day1 := time.Date(2026, 3, 4, 9, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	cost := func(v float64) *float64 { return &v }
+	save := func(id string, ts time.Time, usd float64) {
+		t.Helper()
+		f := &store.Flow{ID: id, Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages",
+			Timestamp: ts, FlowIntegrity: "complete", Provider: "anthropic", TotalCost: cost(usd)}
+		if err := s.SaveFlow(ctx, f); err != nil {
+			t.Fatalf("SaveFlow(%s): %v", id, err)
+		}
+	}
+
+	monitor := NewBudgetMonitor(engine, 5.0)
+
+	// Under budget: no alert
+	save("d1-a", day1, 3.0)
+	if alert, err := monitor.Check(ctx, day1.Add(time.Hour)); err != nil || alert != nil {
+		t.Fatalf("Check under budget = %+v, %v; want nil", alert, err)
+	}
+
+	// Crossing fires exactly once
+	save("d1-b", day1.Add(2*time.Hour), 2.5)
+	alerts := 0
+	for i := 0; i < 3; i++ {
+		alert, err := monitor.Check(ctx, day1.Add(3*time.Hour))
+		if err != nil {
+			t.Fatalf("Check: %v", err)
+		}
+		if alert != nil {
+			alerts++
+			if alert.Date != "2026-03-04" || alert.SpentUSD != 5.5 || alert.LimitUSD != 5.0 {
+				t.Errorf("alert = %+v", alert)
+			}
+		}
+	}
+	save("d1-c", day1.Add(4*time.Hour), 10.0)
+	if alert, _ := monitor.Check(ctx, day1.Add(5*time.Hour)); alert != nil {
+		alerts++
+	}
+	if alerts != 1 {
+		t.Errorf("alerts on day 1 = %d, want 1", alerts)
+	}
+
+	// Yesterday's spend doesn't count toward today
+	if alert, err := monitor.Check(ctx, day2.Add(time.Minute)); err != nil || alert != nil {
+		t.Fatalf("Check at start of day 2 = %+v, %v; want nil", alert, err)
+	}
+
+	// Crossing again the next day fires again
+	save("d2-a", day2.Add(time.Hour), 6.0)
+	alert, err := monitor.Check(ctx, day2.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("Check day 2: %v", err)
+	}
+	if alert == nil || alert.Date != "2026-03-05" {
+		t.Errorf("day 2 alert = %+v, want alert for 2026-03-05", alert)
+	}
+}
+
+func TestBudgetMonitor_Disabled(t *testing.T) {
+	engine, _ := setupTestEngine(t)
+	alert, err := NewBudgetMonitor(engine, 0).Check(context.Background(), time.Now())
+	if err != nil || alert != nil {
+		t.Errorf("Check with no budget = %+v, %v; want nil", alert, err)
+	}
+}
@@ -0,0 +1,188 @@
+package analytics
+
+import (
+	"context"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+// CacheBreakpointStats summarizes prompt-cache results for flows that sent
+// the same number of cache_control breakpoints.
+type CacheBreakpointStats struct {
+	Breakpoints         int // 0 = no cache_control markers
+	FlowCount           int
+	CacheHitFlows       int // Flows that read anything from the cache
+	HitRate             float64
+	InputTokens         int64 // Uncached input tokens
+	CacheReadTokens     int64
+	CacheCreationTokens int64
+	CachedShare         float64 // Cache reads as a share of all prompt tokens
+}
+
+// GetCacheBreakpointStats correlates request breakpoint counts with the cache
+// read/creation tokens reported in responses, to show whether breakpoints pay
+// off. Only completed Anthropic and Bedrock flows (with input tokens) are
+// counted. Rows are ordered by breakpoint count.
+func (e *Engine) GetCacheBreakpointStats(ctx context.Context, start, end time.Time) ([]*CacheBreakpointStats, error) {
+	rows, err := e.db.QueryContext(ctx, `
+		SELECT
+			COALESCE(cache_breakpoints, 0) as breakpoints,
+			COUNT(*) as flow_count,
+			SUM(CASE WHEN COALESCE(cache_read_tokens, 0) > 0 THEN 1 ELSE 0 END) as hit_flows,
+			COALESCE(SUM(input_tokens), 0),
+			COALESCE(SUM(cache_read_tokens), 0),
+			COALESCE(SUM(cache_creation_tokens), 0)
+		FROM flows
+		WHERE timestamp >= ? AND timestamp <= ?
+			AND provider IN ('anthropic', 'bedrock')
+			AND input_tokens IS NOT NULL
+		GROUP BY breakpoints
+		ORDER BY breakpoints
+	`, store.FormatTime(start), store.FormatTime(end))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []*CacheBreakpointStats{}
+	for rows.Next() {
+		var s CacheBreakpointStats
+		if err := rows.Scan(&s.Breakpoints, &s.FlowCount, &s.CacheHitFlows,
+			&s.InputTokens, &s.CacheReadTokens, &s.CacheCreationTokens); err != nil {
+			return nil, err
+		}
+		if s.FlowCount > 0 {
+			s.HitRate = float64(s.CacheHitFlows) / float64(s.FlowCount)
+		}
+		if prompt := s.InputTokens + s.CacheReadTokens + s.CacheCreationTokens; prompt > 0 {
+			s.CachedShare = float64(s.CacheReadTokens) / float64(prompt)
+		}
+		stats = append(stats, &s)
+	}
+
+	return stats, rows.Err()
+}
+
+// CacheEfficiency totals prompt-cache usage for a period, and breaks it down
+// by model.
+type CacheEfficiency struct {
+	CacheUsage
+	ByModel []*ModelCacheEfficiency
+}
+
+// ModelCacheEfficiency is one model's share of CacheEfficiency.
+type ModelCacheEfficiency struct {
+	Provider string
+	Model    string // Empty for flows with no model recorded
+	CacheUsage
+	// Priced is false when the model has no price list with cache rates;
+	// its savings are then left at zero and out of the totals.
+	Priced bool
+}
+
+// CacheUsage is the token and savings arithmetic shared by the totals and
+// each model.
+type CacheUsage struct {
+	FlowCount           int
+	InputTokens         int64 // Uncached input tokens
+	CacheReadTokens     int64
+	CacheCreationTokens int64
+	// HitRatio is cache reads as a share of all prompt tokens (uncached
+	// input plus cache reads and writes), 0-1.
+	HitRatio float64
+	// ReadSavings is what cache reads would have cost more at the input
+	// rate. WritePremium is what cache writes cost over the input rate.
+	// NetSavings is the difference.
+	ReadSavings  float64
+	WritePremium float64
+	NetSavings   float64
+}
+
+// add accumulates o's tokens and savings into u.
+func (u *CacheUsage) add(o CacheUsage) {
+	u.FlowCount += o.FlowCount
+	u.InputTokens += o.InputTokens
+	u.CacheReadTokens += o.CacheReadTokens
+	u.CacheCreationTokens += o.CacheCreationTokens
+	u.ReadSavings += o.ReadSavings
+	u.WritePremium += o.WritePremium
+	u.NetSavings += o.NetSavings
+}
+
+// setHitRatio computes HitRatio from the token counts.
+func (u *CacheUsage) setHitRatio() {
+	if prompt := u.InputTokens + u.CacheReadTokens + u.CacheCreationTokens; prompt > 0 {
+		u.HitRatio = float64(u.CacheReadTokens) / float64(prompt)
+	}
+}
+
+// GetCacheEfficiency reports how much of the prompt traffic between start
+// and end was served from the prompt cache, and roughly what that saved, by
+// model. Savings are estimated at each model's list prices: cache reads
+// against the input rate they replaced, less the premium paid on cache
+// writes. Models are ordered by cache reads, most first.
+func (e *Engine) GetCacheEfficiency(ctx context.Context, start, end time.Time) (*CacheEfficiency, error) {
+	rows, err := e.db.QueryContext(ctx, `
+		SELECT
+			provider,
+			COALESCE(model, '') as model,
+			COUNT(*),
+			COALESCE(SUM(input_tokens), 0),
+			COALESCE(SUM(cache_read_tokens), 0),
+			COALESCE(SUM(cache_creation_tokens), 0)
+		FROM flows
+		WHERE timestamp >= ? AND timestamp <= ?
+			AND input_tokens IS NOT NULL
+		GROUP BY provider, model
+		ORDER BY 5 DESC, 4 DESC, provider, model
+	`, store.FormatTime(start), store.FormatTime(end))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &CacheEfficiency{ByModel: []*ModelCacheEfficiency{}}
+	for rows.Next() {
+		var m ModelCacheEfficiency
+		if err := rows.Scan(&m.Provider, &m.Model, &m.FlowCount,
+			&m.InputTokens, &m.CacheReadTokens, &m.CacheCreationTokens); err != nil {
+			return nil, err
+		}
+		result.ByModel = append(result.ByModel, &m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Pricing is looked up once the rows are closed, as it may query too
+	for _, m := range result.ByModel {
+		if m.Model != "" && (m.CacheReadTokens > 0 || m.CacheCreationTokens > 0) {
+			pricing, err := e.GetPricing(ctx, m.Provider, m.Model)
+			if err != nil {
+				return nil, err
+			}
+			m.estimateSavings(pricing)
+		}
+		m.setHitRatio()
+		result.add(m.CacheUsage)
+	}
+	result.setHitRatio()
+	return result, nil
+}
+
+// estimateSavings fills in m's savings from pricing, if it has cache rates.
+func (m *ModelCacheEfficiency) estimateSavings(pricing *ModelPricing) {
+	if pricing == nil || (m.CacheReadTokens > 0 && pricing.CacheReadPer1k == nil) ||
+		(m.CacheCreationTokens > 0 && pricing.CacheCreationPer1k == nil) {
+		return
+	}
+	m.Priced = true
+	if pricing.CacheReadPer1k != nil {
+		m.ReadSavings = float64(m.CacheReadTokens) * (pricing.InputCostPer1k - *pricing.CacheReadPer1k) / 1000
+	}
+	if pricing.CacheCreationPer1k != nil {
+		m.WritePremium = float64(m.CacheCreationTokens) * (*pricing.CacheCreationPer1k - pricing.InputCostPer1k) / 1000
+	}
+	m.NetSavings = m.ReadSavings - m.WritePremium
+}
@@ -0,0 +1,147 @@
+package analytics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+func TestGetCacheBreakpointStats(t *testing.T) {
+	engine, s := setupTestEngine(t)
+	ctx := context.Background()
+
+	base := time.Date(2026, 2, 3, 12, 0, 0, 0, time.UTC)
+	n := func(v int) *int { return &v }
+	flow := func(id, provider string, breakpoints *int, input, read, creation int) *store.Flow {
+		return &store.Flow{ID: id, Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages",
+			Timestamp: base, FlowIntegrity: "complete", Provider: provider,
+			CacheBreakpoints: breakpoints, InputTokens: n(input),
+			CacheReadTokens: n(read), CacheCreationTokens: n(creation)}
+	}
+
+	flows := []*store.Flow{
+		flow("none-1", "anthropic", nil, 1000, 0, 0),
+		flow("two-miss", "anthropic", n(2), 100, 0, 900),
+		flow("two-hit-1", "anthropic", n(2), 100, 900, 0),
+		flow("two-hit-2", "bedrock", n(2), 100, 900, 0),
+		flow("openai", "openai", nil, 500, 0, 0), // Not a cache_control provider
+	}
+	for _, f := range flows {
+		if err := s.SaveFlow(ctx, f); err != nil {
+			t.Fatalf("SaveFlow(%s): %v", f.ID, err)
+		}
+	}
+
+	stats, err := engine.GetCacheBreakpointStats(ctx, base.Add(-time.Hour), base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetCacheBreakpointStats: %v", err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("len(stats) = %d, want 2", len(stats))
+	}
+
+	if stats[0].Breakpoints != 0 || stats[0].FlowCount != 1 || stats[0].HitRate != 0 {
+		t.Errorf("stats[0] = %+v, want 1 flow without breakpoints and no hits", stats[0])
+	}
+
+	two := stats[1]
+	if two.Breakpoints != 2 || two.FlowCount != 3 || two.CacheHitFlows != 2 {
+		t.Errorf("stats[1] = %+v, want 3 flows with 2 breakpoints, 2 hits", two)
+	}
+	if two.CacheReadTokens != 1800 || two.CacheCreationTokens != 900 {
+		t.Errorf("cache tokens = read %d creation %d, want 1800/900", two.CacheReadTokens, two.CacheCreationTokens)
+	}
+	if want := 1800.0 / 3000.0; two.CachedShare != want {
+		t.Errorf("CachedShare = %v, want %v", two.CachedShare, want)
+	}
+}
+
+func TestGetCacheEfficiency(t *testing.T) {
+	engine, s := setupTestEngine(t)
+	ctx := context.Background()
+
+	read, creation := 0.0003, 0.00375
+	if err := engine.UpsertPricing(ctx, &ModelPricing{
+		Provider: "anthropic", ModelPattern: "cache-test-model%",
+		InputCostPer1k: 0.003, OutputCostPer1k: 0.015,
+		CacheReadPer1k: &read, CacheCreationPer1k: &creation,
+		EffectiveDate: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+	}); err != nil {
+		t.Fatalf("UpsertPricing: %v", err)
+	}
+
+	base := time.Date(2026, 2, 3, 12, 0, 0, 0, time.UTC)
+	n := func(v int) *int { return &v }
+	flow := func(id, provider, model string, input, read, creation int) *store.Flow {
+		return &store.Flow{ID: id, Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages",
+			Timestamp: base, FlowIntegrity: "complete", Provider: provider, Model: &model,
+			InputTokens: n(input), CacheReadTokens: n(read), CacheCreationTokens: n(creation)}
+	}
+	flows := []*store.Flow{
+		flow("write", "anthropic", "cache-test-model-1", 1000, 0, 2000),
+		flow("hit-1", "anthropic", "cache-test-model-1", 500, 4000, 0),
+		flow("hit-2", "anthropic", "cache-test-model-1", 500, 4000, 0),
+		flow("unpriced", "other", "mystery-model", 1000, 1000, 0),
+		flow("no-cache", "other", "mystery-model", 1000, 0, 0),
+	}
+	for _, f := range flows {
+		if err := s.SaveFlow(ctx, f); err != nil {
+			t.Fatalf("SaveFlow(%s): %v", f.ID, err)
+		}
+	}
+
+	eff, err := engine.GetCacheEfficiency(ctx, base.Add(-time.Hour), base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetCacheEfficiency: %v", err)
+	}
+	if len(eff.ByModel) != 2 {
+		t.Fatalf("len(ByModel) = %d, want 2", len(eff.ByModel))
+	}
+
+	const epsilon = 1e-9
+	near := func(got, want float64) bool { return got-want < epsilon && want-got < epsilon }
+
+	// 8000 reads of 2000 + 8000 + 2000 prompt tokens; reads save
+	// 0.003-0.0003 per 1k and writes cost 0.00375-0.003 per 1k extra
+	m := eff.ByModel[0]
+	if m.Model != "cache-test-model-1" || m.FlowCount != 3 || !m.Priced {
+		t.Fatalf("ByModel[0] = %+v, want 3 priced cache-test-model-1 flows", m)
+	}
+	if m.InputTokens != 2000 || m.CacheReadTokens != 8000 || m.CacheCreationTokens != 2000 {
+		t.Errorf("tokens = input %d read %d creation %d, want 2000/8000/2000",
+			m.InputTokens, m.CacheReadTokens, m.CacheCreationTokens)
+	}
+	if want := 8000.0 / 12000.0; !near(m.HitRatio, want) {
+		t.Errorf("HitRatio = %v, want %v", m.HitRatio, want)
+	}
+	if want := 8.0 * 0.0027; !near(m.ReadSavings, want) {
+		t.Errorf("ReadSavings = %v, want %v", m.ReadSavings, want)
+	}
+	if want := 2.0 * 0.00075; !near(m.WritePremium, want) {
+		t.Errorf("WritePremium = %v, want %v", m.WritePremium, want)
+	}
+	if want := 8.0*0.0027 - 2.0*0.00075; !near(m.NetSavings, want) {
+		t.Errorf("NetSavings = %v, want %v", m.NetSavings, want)
+	}
+
+	// A model without cache prices counts toward the ratio but not savings
+	other := eff.ByModel[1]
+	if other.Model != "mystery-model" || other.Priced || other.NetSavings != 0 {
+		t.Errorf("ByModel[1] = %+v, want unpriced mystery-model", other)
+	}
+	if want := 1000.0 / 3000.0; !near(other.HitRatio, want) {
+		t.Errorf("mystery-model HitRatio = %v, want %v", other.HitRatio, want)
+	}
+
+	if eff.FlowCount != 5 || eff.CacheReadTokens != 9000 {
+		t.Errorf("totals = %d flows, %d reads; want 5, 9000", eff.FlowCount, eff.CacheReadTokens)
+	}
+	if want := 9000.0 / 15000.0; !near(eff.HitRatio, want) {
+		t.Errorf("total HitRatio = %v, want %v", eff.HitRatio, want)
+	}
+	if !near(eff.NetSavings, m.NetSavings) {
+		t.Errorf("total NetSavings = %v, want %v from the priced model", eff.NetSavings, m.NetSavings)
+	}
+}
@@ -0,0 +1,68 @@
+package analytics
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+// DuplicateGroup is a set of flows that sent the same request, as identified
+// by their request signature (method, host, path and normalized body).
+type DuplicateGroup struct {
+	Signature string
+	Count     int
+	Method    string
+	Host      string
+	Path      string
+	FirstSeen time.Time
+	LastSeen  time.Time
+	FlowIDs   []string // Oldest first
+}
+
+// FindDuplicateRequests returns groups of two or more flows between start and
+// end that share a request signature, largest groups first, so clients
+// retrying or hammering identical requests stand out. At most limit groups
+// are returned.
+func (e *Engine) FindDuplicateRequests(ctx context.Context, start, end time.Time, limit int) ([]*DuplicateGroup, error) {
+	rows, err := e.db.QueryContext(ctx, `
+		SELECT
+			request_signature,
+			COUNT(*) as flow_count,
+			MIN(method), MIN(host), MIN(path),
+			MIN(timestamp), MAX(timestamp),
+			GROUP_CONCAT(id)
+		FROM (
+			SELECT id, request_signature, method, host, path, timestamp
+			FROM flows
+			WHERE request_signature IS NOT NULL
+				AND timestamp >= ? AND timestamp <= ?
+			ORDER BY timestamp
+		)
+		GROUP BY request_signature
+		HAVING COUNT(*) > 1
+		ORDER BY flow_count DESC, MAX(timestamp) DESC
+		LIMIT ?
+	`, store.FormatTime(start), store.FormatTime(end), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []*DuplicateGroup{}
+	for rows.Next() {
+		var g DuplicateGroup
+		var firstSeen, lastSeen, ids string
+		if err := rows.Scan(&g.Signature, &g.Count, &g.Method, &g.Host, &g.Path,
+			&firstSeen, &lastSeen, &ids); err != nil {
+			return nil, err
+		}
+		g.FirstSeen, _ = time.Parse(time.RFC3339Nano, firstSeen)
+		g.LastSeen, _ = time.Parse(time.RFC3339Nano, lastSeen)
+		g.FlowIDs = strings.Split(ids, ",")
+		groups = append(groups, &g)
+	}
+
+	return groups, rows.Err()
+}
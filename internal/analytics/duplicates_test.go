@@ -0,0 +1,74 @@
+package analytics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+func TestFindDuplicateRequests(t *testing.T) {
+	engine, s := setupTestEngine(t)
+	ctx := context.Background()
+
+	base := time.Date(2026, 3, 4, 9, 0, 0, 0, time.UTC)
+	sig := func(v string) *string { return &v }
+	flow := func(id string, signature *string, at time.Duration) *store.Flow {
+		return &store.Flow{ID: id, Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages",
+			Timestamp: base.Add(at), FlowIntegrity: "complete", Provider: "anthropic",
+			RequestSignature: signature}
+	}
+
+	flows := []*store.Flow{
+		flow("retry-2", sig("aaa"), 2*time.Second),
+		flow("retry-1", sig("aaa"), time.Second),
+		flow("retry-3", sig("aaa"), 3*time.Second),
+		flow("pair-1", sig("bbb"), 10*time.Second),
+		flow("pair-2", sig("bbb"), 11*time.Second),
+		flow("single", sig("ccc"), 5*time.Second),
+		flow("unsigned-1", nil, 5*time.Second),
+		flow("unsigned-2", nil, 6*time.Second),
+		flow("old", sig("bbb"), -2*time.Hour), // Outside the window
+	}
+	for _, f := range flows {
+		if err := s.SaveFlow(ctx, f); err != nil {
+			t.Fatalf("SaveFlow(%s): %v", f.ID, err)
+		}
+	}
+
+	groups, err := engine.FindDuplicateRequests(ctx, base.Add(-time.Hour), base.Add(time.Hour), 10)
+	if err != nil {
+		t.Fatalf("FindDuplicateRequests: %v", err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("len(groups) = %d, want 2", len(groups))
+	}
+
+	retries := groups[0]
+	if retries.Signature != "aaa" || retries.Count != 3 {
+		t.Errorf("groups[0] = %+v, want 3 flows with signature aaa", retries)
+	}
+	if got := retries.FlowIDs; len(got) != 3 || got[0] != "retry-1" || got[1] != "retry-2" || got[2] != "retry-3" {
+		t.Errorf("FlowIDs = %v, want [retry-1 retry-2 retry-3]", got)
+	}
+	if !retries.FirstSeen.Equal(base.Add(time.Second)) || !retries.LastSeen.Equal(base.Add(3*time.Second)) {
+		t.Errorf("seen = %v..%v, want %v..%v", retries.FirstSeen, retries.LastSeen,
+			base.Add(time.Second), base.Add(3*time.Second))
+	}
+	if retries.Method != "POST" || retries.Host != "api.anthropic.com" || retries.Path != "/v1/messages" {
+		t.Errorf("request = %s %s%s", retries.Method, retries.Host, retries.Path)
+	}
+
+	if groups[1].Signature != "bbb" || groups[1].Count != 2 {
+		t.Errorf("groups[1] = %+v, want 2 flows with signature bbb", groups[1])
+	}
+
+	limited, err := engine.FindDuplicateRequests(ctx, base.Add(-time.Hour), base.Add(time.Hour), 1)
+	if err != nil {
+		t.Fatalf("FindDuplicateRequests: %v", err)
+	}
+	if len(limited) != 1 || limited[0].Signature != "aaa" {
+		t.Errorf("limit 1 returned %d groups, want only aaa", len(limited))
+	}
+}
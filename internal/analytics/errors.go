@@ -0,0 +1,53 @@
+package analytics
+
+import (
+	"context"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+// ErrorStats counts the failed flows of one provider that share a status
+// code and error type.
+type ErrorStats struct {
+	Provider   string
+	StatusCode int
+	ErrorType  string // Empty when the response had no parseable error
+	Count      int
+	LastSeen   time.Time
+}
+
+// GetErrorStats groups the flows between start and end with a status of 400
+// or above by provider, status code and error type, most frequent first.
+func (e *Engine) GetErrorStats(ctx context.Context, start, end time.Time) ([]*ErrorStats, error) {
+	rows, err := e.db.QueryContext(ctx, `
+		SELECT
+			provider,
+			status_code,
+			COALESCE(error_type, '') as error_type,
+			COUNT(*) as flow_count,
+			MAX(timestamp)
+		FROM flows
+		WHERE status_code >= 400
+			AND timestamp >= ? AND timestamp <= ?
+		GROUP BY provider, status_code, COALESCE(error_type, '')
+		ORDER BY flow_count DESC, status_code, provider, error_type
+	`, store.FormatTime(start), store.FormatTime(end))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []*ErrorStats{}
+	for rows.Next() {
+		var st ErrorStats
+		var lastSeen string
+		if err := rows.Scan(&st.Provider, &st.StatusCode, &st.ErrorType, &st.Count, &lastSeen); err != nil {
+			return nil, err
+		}
+		st.LastSeen, _ = time.Parse(time.RFC3339Nano, lastSeen)
+		stats = append(stats, &st)
+	}
+
+	return stats, rows.Err()
+}
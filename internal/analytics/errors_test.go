@@ -0,0 +1,79 @@
+package analytics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+	"github.com/HakAl/langley/internal/testutil"
+)
+
+func TestGetErrorStats(t *testing.T) {
+	engine, s := setupTestEngine(t)
+	ctx := context.Background()
+
+	base := time.Date(2026, 5, 6, 12, 0, 0, 0, time.UTC)
+	strp := func(v string) *string { return &v }
+	flow := func(id, provider string, status int, errorType *string, at time.Duration) *store.Flow {
+		f := testutil.NewFlow().WithID(id).WithProvider(provider).WithStatus(status).Build()
+		f.Timestamp = base.Add(at)
+		f.ErrorType = errorType
+		return f
+	}
+
+	flows := []*store.Flow{
+		flow("ok-1", "anthropic", 200, nil, time.Second),
+		flow("ok-2", "openai", 200, nil, 2*time.Second),
+		flow("bad-1", "anthropic", 400, strp("invalid_request_error"), 3*time.Second),
+		flow("limited-1", "anthropic", 429, strp("rate_limit_error"), 4*time.Second),
+		flow("limited-2", "anthropic", 429, strp("rate_limit_error"), 5*time.Second),
+		flow("limited-3", "anthropic", 429, strp("rate_limit_error"), 6*time.Second),
+		flow("limited-openai", "openai", 429, strp("rate_limit_error"), 7*time.Second),
+		flow("server-1", "anthropic", 500, strp("api_error"), 8*time.Second),
+		flow("server-2", "openai", 500, nil, 9*time.Second),            // No parseable error body
+		flow("old", "anthropic", 500, strp("api_error"), -2*time.Hour), // Outside the window
+	}
+	for _, f := range flows {
+		if err := s.SaveFlow(ctx, f); err != nil {
+			t.Fatalf("SaveFlow(%s): %v", f.ID, err)
+		}
+	}
+
+	stats, err := engine.GetErrorStats(ctx, base.Add(-time.Hour), base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetErrorStats: %v", err)
+	}
+
+	want := []ErrorStats{
+		{Provider: "anthropic", StatusCode: 429, ErrorType: "rate_limit_error", Count: 3, LastSeen: base.Add(6 * time.Second)},
+		{Provider: "anthropic", StatusCode: 400, ErrorType: "invalid_request_error", Count: 1, LastSeen: base.Add(3 * time.Second)},
+		{Provider: "openai", StatusCode: 429, ErrorType: "rate_limit_error", Count: 1, LastSeen: base.Add(7 * time.Second)},
+		{Provider: "anthropic", StatusCode: 500, ErrorType: "api_error", Count: 1, LastSeen: base.Add(8 * time.Second)},
+		{Provider: "openai", StatusCode: 500, ErrorType: "", Count: 1, LastSeen: base.Add(9 * time.Second)},
+	}
+	if len(stats) != len(want) {
+		for _, st := range stats {
+			t.Logf("got %+v", *st)
+		}
+		t.Fatalf("got %d groups, want %d", len(stats), len(want))
+	}
+	for i, w := range want {
+		got := *stats[i]
+		if !got.LastSeen.Equal(w.LastSeen) {
+			t.Errorf("group %d LastSeen = %v, want %v", i, got.LastSeen, w.LastSeen)
+		}
+		got.LastSeen, w.LastSeen = time.Time{}, time.Time{}
+		if got != w {
+			t.Errorf("group %d = %+v, want %+v", i, got, w)
+		}
+	}
+
+	empty, err := engine.GetErrorStats(ctx, base.Add(time.Hour), base.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("GetErrorStats(empty): %v", err)
+	}
+	if len(empty) != 0 {
+		t.Errorf("empty range = %d groups, want 0", len(empty))
+	}
+}
@@ -0,0 +1,140 @@
+package analytics
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+// Token estimate constants. Four characters per token is the usual rule of
+// thumb for English text with BPE tokenizers; the per-message overhead
+// covers role markers and separators the chat templates add.
+const (
+	charsPerToken         = 4
+	tokensPerMessage      = 4
+	tokensPerConversation = 3
+)
+
+// EstimateInputTokens estimates the input tokens of an LLM request body
+// from its prompt text, for flows whose response carried no usage. It
+// understands Anthropic and OpenAI chat requests (system, messages and
+// tools), the OpenAI Responses API (input, instructions), Gemini
+// (contents, systemInstruction) and Ollama (prompt). It returns false when
+// the body isn't JSON or carries none of those fields.
+func EstimateInputTokens(body []byte) (int, bool) {
+	var req struct {
+		System            json.RawMessage   `json:"system"`
+		Messages          []json.RawMessage `json:"messages"`
+		Tools             []json.RawMessage `json:"tools"`
+		Input             json.RawMessage   `json:"input"`
+		Instructions      string            `json:"instructions"`
+		Prompt            string            `json:"prompt"`
+		Contents          []json.RawMessage `json:"contents"`
+		SystemInstruction json.RawMessage   `json:"systemInstruction"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return 0, false
+	}
+
+	var chars, messages int
+	var found bool
+	add := func(raw json.RawMessage) {
+		if len(raw) == 0 || string(raw) == "null" {
+			return
+		}
+		found = true
+		chars += textChars(raw)
+	}
+
+	add(req.System)
+	add(req.SystemInstruction)
+	for _, m := range req.Messages {
+		add(m)
+		messages++
+	}
+	for _, c := range req.Contents {
+		add(c)
+		messages++
+	}
+	add(req.Input)
+	if req.Instructions != "" {
+		found = true
+		chars += utf8.RuneCountInString(req.Instructions)
+	}
+	if req.Prompt != "" {
+		found = true
+		chars += utf8.RuneCountInString(req.Prompt)
+		messages++
+	}
+	// Tool definitions are sent to the model as schema text, so count them
+	// whole rather than just their descriptions
+	for _, tool := range req.Tools {
+		found = true
+		chars += utf8.RuneCount(tool)
+	}
+	if !found {
+		return 0, false
+	}
+
+	tokens := (chars + charsPerToken - 1) / charsPerToken
+	if messages > 0 {
+		tokens += messages*tokensPerMessage + tokensPerConversation
+	}
+	return tokens, true
+}
+
+// textChars counts the characters of every string value in a JSON value:
+// message text, tool results and the like. Keys and structure aren't
+// counted, and neither are image or document data, which are billed by size
+// rather than by characters.
+func textChars(raw json.RawMessage) int {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return 0
+	}
+	return stringChars(v)
+}
+
+func stringChars(v interface{}) int {
+	switch v := v.(type) {
+	case string:
+		return utf8.RuneCountInString(v)
+	case []interface{}:
+		var n int
+		for _, item := range v {
+			n += stringChars(item)
+		}
+		return n
+	case map[string]interface{}:
+		if isBinaryBlock(v) {
+			return 0
+		}
+		var n int
+		for key, item := range v {
+			// Roles and block types are template tokens, already covered
+			// by the per-message overhead
+			if key == "role" || key == "type" {
+				continue
+			}
+			n += stringChars(item)
+		}
+		return n
+	}
+	return 0
+}
+
+// isBinaryBlock reports whether a content block carries inline base64 data:
+// an Anthropic image/document source, a Gemini inlineData part or an
+// OpenAI image_url data URL.
+func isBinaryBlock(block map[string]interface{}) bool {
+	if block["type"] == "base64" {
+		return true
+	}
+	if _, ok := block["inlineData"]; ok {
+		return true
+	}
+	if url, ok := block["url"].(string); ok && strings.HasPrefix(url, "data:") {
+		return true
+	}
+	return false
+}
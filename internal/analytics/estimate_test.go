@@ -0,0 +1,61 @@
+package analytics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEstimateInputTokens(t *testing.T) {
+	text := strings.Repeat("a", 400) // 100 tokens of text
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{
+			name: "anthropic messages",
+			body: `{"model":"claude-sonnet-4","system":"` + text + `","messages":[{"role":"user","content":[{"type":"text","text":"` + text + `"}]}]}`,
+			want: 200 + 1*tokensPerMessage + tokensPerConversation,
+		},
+		{
+			name: "openai chat",
+			body: `{"model":"gpt-4o","messages":[{"role":"system","content":"` + text + `"},{"role":"user","content":"` + text + `"}]}`,
+			want: 200 + 2*tokensPerMessage + tokensPerConversation,
+		},
+		{
+			name: "images are not counted as text",
+			body: `{"messages":[{"role":"user","content":[{"type":"image","source":{"type":"base64","media_type":"image/png","data":"` + text + `"}},{"type":"text","text":"` + text + `"}]}]}`,
+			want: 100 + 1*tokensPerMessage + tokensPerConversation,
+		},
+		{
+			name: "gemini contents",
+			body: `{"contents":[{"role":"user","parts":[{"text":"` + text + `"}]}]}`,
+			want: 100 + 1*tokensPerMessage + tokensPerConversation,
+		},
+		{
+			name: "responses api input",
+			body: `{"model":"gpt-4.1","instructions":"` + text + `","input":"` + text + `"}`,
+			want: 200,
+		},
+		{
+			name: "ollama generate",
+			body: `{"model":"llama3.2","prompt":"` + text + `"}`,
+			want: 100 + 1*tokensPerMessage + tokensPerConversation,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := EstimateInputTokens([]byte(tt.body))
+			if !ok || got != tt.want {
+				t.Errorf("EstimateInputTokens = %d, %v; want %d", got, ok, tt.want)
+			}
+		})
+	}
+
+	for _, body := range []string{`not json`, `{"model":"claude-sonnet-4"}`, ``} {
+		if got, ok := EstimateInputTokens([]byte(body)); ok {
+			t.Errorf("EstimateInputTokens(%q) = %d, want no estimate", body, got)
+		}
+	}
+}
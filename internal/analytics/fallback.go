@@ -0,0 +1,187 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+// fallbackPageSize is how many flows FlowStats reads from the store at a time.
+const fallbackPageSize = 500
+
+// FlowStats computes the flow totals and cost breakdowns an Engine answers
+// in SQL by listing flows from any store.Store and adding them up in Go. It
+// is for stores without a SQL database behind them, and is much slower than
+// an Engine: every flow in the range is read, bodies included, on every call.
+type FlowStats struct {
+	store store.Store
+}
+
+// NewFlowStats creates a fallback analytics source over s.
+func NewFlowStats(s store.Store) *FlowStats {
+	return &FlowStats{store: s}
+}
+
+// GetOverallStats returns the same summary as Engine.GetOverallStats. Tool
+// calls are counted from the invocations recorded for the flows in range.
+func (f *FlowStats) GetOverallStats(ctx context.Context, start, end time.Time) (*OverallStats, error) {
+	var stats OverallStats
+	tasks := make(map[string]bool)
+	providers := make(map[string]*StatsFacet)
+	models := make(map[string]*StatsFacet)
+	err := f.eachFlow(ctx, start, end, func(flow *store.Flow) error {
+		row := flowFacet(flow)
+		stats.TotalFlows++
+		stats.TotalCost += row.TotalCost
+		stats.TotalTokensIn += row.TotalTokensIn
+		stats.TotalTokensOut += row.TotalTokensOut
+		if flow.TaskID != nil {
+			tasks[*flow.TaskID] = true
+		}
+		addFacet(providers, flow.Provider, row)
+		addFacet(models, flowModel(flow), row)
+
+		invs, err := f.store.GetToolInvocationsByFlow(ctx, flow.ID)
+		if err != nil {
+			return fmt.Errorf("listing tool invocations of flow %s: %w", flow.ID, err)
+		}
+		for _, inv := range invs {
+			if !inv.Timestamp.Before(start) && !inv.Timestamp.After(end) {
+				stats.TotalToolCalls++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats.TotalTasks = len(tasks)
+	if stats.TotalFlows > 0 {
+		stats.AvgCostPerFlow = stats.TotalCost / float64(stats.TotalFlows)
+		stats.AvgTokensPerFlow = float64(stats.TotalTokensIn+stats.TotalTokensOut) / float64(stats.TotalFlows)
+	}
+	stats.ByProvider = sortedFacets(providers)
+	stats.ByModel = sortedFacets(models)
+	return &stats, nil
+}
+
+// GetCostByDay returns the same breakdown as Engine.GetCostByDay, by UTC date.
+func (f *FlowStats) GetCostByDay(ctx context.Context, start, end time.Time) ([]*CostByPeriod, error) {
+	periods, err := f.costBy(ctx, start, end, func(flow *store.Flow) string {
+		return flow.Timestamp.UTC().Format(time.DateOnly)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(periods, func(i, j int) bool { return periods[i].Period < periods[j].Period })
+	return periods, nil
+}
+
+// GetCostByHour returns the same breakdown as Engine.GetCostByHour, by RFC
+// 3339 UTC hour.
+func (f *FlowStats) GetCostByHour(ctx context.Context, start, end time.Time) ([]*CostByPeriod, error) {
+	periods, err := f.costBy(ctx, start, end, func(flow *store.Flow) string {
+		return flow.Timestamp.UTC().Truncate(time.Hour).Format(time.RFC3339)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(periods, func(i, j int) bool { return periods[i].Period < periods[j].Period })
+	return periods, nil
+}
+
+// GetCostByModel returns the same breakdown as Engine.GetCostByModel, most
+// expensive model first.
+func (f *FlowStats) GetCostByModel(ctx context.Context, start, end time.Time) ([]*CostByPeriod, error) {
+	models, err := f.costBy(ctx, start, end, flowModel)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(models, func(i, j int) bool {
+		if models[i].TotalCost != models[j].TotalCost {
+			return models[i].TotalCost > models[j].TotalCost
+		}
+		return models[i].Period < models[j].Period
+	})
+	return models, nil
+}
+
+// costBy totals the flows between start and end under the period key
+// returns for each, in no particular order.
+func (f *FlowStats) costBy(ctx context.Context, start, end time.Time, key func(*store.Flow) string) ([]*CostByPeriod, error) {
+	byKey := make(map[string]*CostByPeriod)
+	var periods []*CostByPeriod
+	err := f.eachFlow(ctx, start, end, func(flow *store.Flow) error {
+		k := key(flow)
+		p, ok := byKey[k]
+		if !ok {
+			p = &CostByPeriod{Period: k}
+			byKey[k] = p
+			periods = append(periods, p)
+		}
+		row := flowFacet(flow)
+		p.FlowCount++
+		p.TotalCost += row.TotalCost
+		p.TotalTokensIn += row.TotalTokensIn
+		p.TotalTokensOut += row.TotalTokensOut
+		return nil
+	})
+	return periods, err
+}
+
+// eachFlow calls fn for every flow between start and end, a page at a time.
+// Flows saved while paging can shift the pages, so flows already seen are
+// skipped rather than counted twice.
+func (f *FlowStats) eachFlow(ctx context.Context, start, end time.Time, fn func(*store.Flow) error) error {
+	seen := make(map[string]bool)
+	for offset := 0; ; offset += fallbackPageSize {
+		flows, err := f.store.ListFlows(ctx, store.FlowFilter{
+			StartTime: &start,
+			EndTime:   &end,
+			Limit:     fallbackPageSize,
+			Offset:    offset,
+		})
+		if err != nil {
+			return fmt.Errorf("listing flows: %w", err)
+		}
+		for _, flow := range flows {
+			if seen[flow.ID] {
+				continue
+			}
+			seen[flow.ID] = true
+			if err := fn(flow); err != nil {
+				return err
+			}
+		}
+		if len(flows) < fallbackPageSize {
+			return nil
+		}
+	}
+}
+
+// flowFacet returns a flow's cost and token counts as a one-flow facet.
+func flowFacet(flow *store.Flow) *StatsFacet {
+	row := &StatsFacet{FlowCount: 1}
+	if flow.TotalCost != nil {
+		row.TotalCost = *flow.TotalCost
+	}
+	if flow.InputTokens != nil {
+		row.TotalTokensIn = *flow.InputTokens
+	}
+	if flow.OutputTokens != nil {
+		row.TotalTokensOut = *flow.OutputTokens
+	}
+	return row
+}
+
+// flowModel returns a flow's model, or "unknown" as the SQL queries do.
+func flowModel(flow *store.Flow) string {
+	if flow.Model == nil {
+		return "unknown"
+	}
+	return *flow.Model
+}
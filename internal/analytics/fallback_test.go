@@ -0,0 +1,109 @@
+package analytics
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+	"github.com/HakAl/langley/internal/store/storetest"
+)
+
+func TestFlowStats_MatchesEngine(t *testing.T) {
+	engine, sqlStore := setupTestEngine(t)
+	memStore := storetest.New()
+	ctx := context.Background()
+
+	base := time.Date(2026, 3, 4, 9, 30, 0, 0, time.UTC)
+	n := func(v int) *int { return &v }
+	cost := func(v float64) *float64 { return &v }
+	str := func(v string) *string { return &v }
+	flows := []*store.Flow{
+		{ID: "a", Timestamp: base, Provider: "anthropic", Model: str("claude-sonnet-4"), TaskID: str("task-1"),
+			InputTokens: n(100), OutputTokens: n(50), TotalCost: cost(0.5)},
+		{ID: "b", Timestamp: base.Add(10 * time.Minute), Provider: "anthropic", Model: str("claude-sonnet-4"), TaskID: str("task-1"),
+			InputTokens: n(200), OutputTokens: n(20), TotalCost: cost(0.25)},
+		{ID: "c", Timestamp: base.Add(2 * time.Hour), Provider: "openai", Model: str("gpt-4o"), TaskID: str("task-2"),
+			InputTokens: n(300), OutputTokens: n(30), TotalCost: cost(1)},
+		{ID: "d", Timestamp: base.Add(25 * time.Hour), Provider: "other"}, // No usage or model
+		{ID: "out-of-range", Timestamp: base.Add(-48 * time.Hour), Provider: "anthropic", TotalCost: cost(9)},
+	}
+	for _, f := range flows {
+		f.Host, f.Method, f.Path, f.FlowIntegrity = "api.example.com", "POST", "/v1", "complete"
+		for _, s := range []store.Store{sqlStore, memStore} {
+			c := *f
+			if err := s.SaveFlow(ctx, &c); err != nil {
+				t.Fatalf("SaveFlow(%s): %v", f.ID, err)
+			}
+		}
+	}
+	for _, s := range []store.Store{sqlStore, memStore} {
+		if err := s.SaveToolInvocation(ctx, &store.ToolInvocation{
+			ID: "tool-1", FlowID: "a", ToolName: "Read", Timestamp: base,
+		}); err != nil {
+			t.Fatalf("SaveToolInvocation: %v", err)
+		}
+	}
+
+	fallback := NewFlowStats(memStore)
+	start, end := base.Add(-time.Hour), base.Add(48*time.Hour)
+
+	got, err := fallback.GetOverallStats(ctx, start, end)
+	if err != nil {
+		t.Fatalf("fallback GetOverallStats: %v", err)
+	}
+	want, err := engine.GetOverallStats(ctx, start, end)
+	if err != nil {
+		t.Fatalf("engine GetOverallStats: %v", err)
+	}
+	if got.TotalFlows != 4 || got.TotalCost != 1.75 || got.TotalTokensIn != 600 || got.TotalTasks != 2 || got.TotalToolCalls != 1 {
+		t.Errorf("fallback stats = %+v, want 4 flows, $1.75, 600 tokens in, 2 tasks, 1 tool call", got)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fallback stats = %+v\nengine stats = %+v", got, want)
+	}
+
+	for name, query := range map[string]func(*FlowStats, *Engine) ([]*CostByPeriod, []*CostByPeriod, error){
+		"day": func(f *FlowStats, e *Engine) ([]*CostByPeriod, []*CostByPeriod, error) {
+			a, err := f.GetCostByDay(ctx, start, end)
+			if err != nil {
+				return nil, nil, err
+			}
+			b, err := e.GetCostByDay(ctx, start, end)
+			return a, b, err
+		},
+		"hour": func(f *FlowStats, e *Engine) ([]*CostByPeriod, []*CostByPeriod, error) {
+			a, err := f.GetCostByHour(ctx, start, end)
+			if err != nil {
+				return nil, nil, err
+			}
+			b, err := e.GetCostByHour(ctx, start, end)
+			return a, b, err
+		},
+		"model": func(f *FlowStats, e *Engine) ([]*CostByPeriod, []*CostByPeriod, error) {
+			a, err := f.GetCostByModel(ctx, start, end)
+			if err != nil {
+				return nil, nil, err
+			}
+			b, err := e.GetCostByModel(ctx, start, end)
+			return a, b, err
+		},
+	} {
+		got, want, err := query(fallback, engine)
+		if err != nil {
+			t.Fatalf("cost by %s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("cost by %s: fallback %v, engine %v", name, periodsString(got), periodsString(want))
+		}
+	}
+}
+
+func periodsString(periods []*CostByPeriod) []CostByPeriod {
+	out := make([]CostByPeriod, len(periods))
+	for i, p := range periods {
+		out[i] = *p
+	}
+	return out
+}
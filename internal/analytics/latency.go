@@ -0,0 +1,171 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+// latencyBaseline accumulates the durations of one model's recent flows.
+type latencyBaseline struct {
+	n     int
+	sum   float64
+	sumSq float64
+}
+
+func (b *latencyBaseline) add(ms float64) {
+	b.n++
+	b.sum += ms
+	b.sumSq += ms * ms
+}
+
+func (b *latencyBaseline) remove(ms float64) {
+	b.n--
+	b.sum -= ms
+	b.sumSq -= ms * ms
+}
+
+// threshold returns mean + sigma standard deviations and the mean. The
+// deviation is floored at a tenth of the mean so a model with very steady
+// latency doesn't flag flows that are only marginally slower.
+func (b *latencyBaseline) threshold(sigma float64) (limit, mean float64) {
+	mean = b.sum / float64(b.n)
+	stddev := math.Sqrt(math.Max(b.sumSq/float64(b.n)-mean*mean, 0))
+	stddev = math.Max(stddev, mean/10)
+	return mean + sigma*stddev, mean
+}
+
+// check returns a slow_response anomaly if durationMs is an outlier against
+// the baseline, or nil if it isn't or the baseline is too small.
+func (b *latencyBaseline) check(t *AnomalyThresholds, f latencySample) *Anomaly {
+	if b.n < t.LatencyMinSamples || b.n == 0 {
+		return nil
+	}
+	limit, mean := b.threshold(t.LatencySigma)
+	if f.durationMs <= limit {
+		return nil
+	}
+	return &Anomaly{
+		Type:      AnomalySlowResponse,
+		FlowID:    f.id,
+		TaskID:    f.taskID,
+		Timestamp: f.timestamp,
+		Severity:  "info",
+		Description: fmt.Sprintf("Response took %.1fx the mean latency for %s (%.0fms vs %.0fms over %d flows)",
+			f.durationMs/mean, f.model, f.durationMs, mean, b.n),
+		Value:     f.durationMs,
+		Threshold: limit,
+	}
+}
+
+// latencySample is one flow's duration.
+type latencySample struct {
+	id         string
+	taskID     *string
+	model      string
+	timestamp  time.Time
+	durationMs float64
+}
+
+// DetectLatencyAnomalies flags flows since the given time whose duration is
+// more than thresholds.LatencySigma standard deviations above the mean for
+// their model. Each flow is compared with the same model's flows in the
+// LatencyWindow before it, so the baseline follows gradual changes.
+func (e *Engine) DetectLatencyAnomalies(ctx context.Context, since time.Time, thresholds *AnomalyThresholds) ([]*Anomaly, error) {
+	if thresholds == nil {
+		thresholds = DefaultThresholds()
+	}
+	if thresholds.LatencySigma <= 0 {
+		return nil, nil
+	}
+
+	rows, err := e.db.QueryContext(ctx, `
+		SELECT id, task_id, model, timestamp, duration_ms
+		FROM flows
+		WHERE model IS NOT NULL AND duration_ms IS NOT NULL
+			AND timestamp >= ?
+		ORDER BY timestamp
+	`, store.FormatTime(since.Add(-thresholds.LatencyWindow)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var samples []latencySample
+	for rows.Next() {
+		var s latencySample
+		var ts string
+		var durationMs int64
+		if err := rows.Scan(&s.id, &s.taskID, &s.model, &ts, &durationMs); err != nil {
+			return nil, err
+		}
+		s.timestamp, _ = time.Parse(time.RFC3339Nano, ts)
+		s.durationMs = float64(durationMs)
+		samples = append(samples, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Slide a window per model over the flows in time order
+	baselines := make(map[string]*latencyBaseline)
+	windowStart := make(map[string]int) // Oldest sample still in each model's window
+	byModel := make(map[string][]latencySample)
+	var anomalies []*Anomaly
+	for _, s := range samples {
+		b := baselines[s.model]
+		if b == nil {
+			b = &latencyBaseline{}
+			baselines[s.model] = b
+		}
+		prior := byModel[s.model]
+		for i := windowStart[s.model]; i < len(prior) && prior[i].timestamp.Before(s.timestamp.Add(-thresholds.LatencyWindow)); i++ {
+			b.remove(prior[i].durationMs)
+			windowStart[s.model] = i + 1
+		}
+		if !s.timestamp.Before(since) {
+			if a := b.check(thresholds, s); a != nil {
+				anomalies = append(anomalies, a)
+			}
+		}
+		b.add(s.durationMs)
+		byModel[s.model] = append(prior, s)
+	}
+
+	return anomalies, nil
+}
+
+// detectFlowLatencyAnomaly compares one flow's duration with the baseline of
+// its model's flows in the LatencyWindow before it.
+func (e *Engine) detectFlowLatencyAnomaly(ctx context.Context, f latencySample, thresholds *AnomalyThresholds) (*Anomaly, error) {
+	if thresholds.LatencySigma <= 0 {
+		return nil, nil
+	}
+
+	ts := f.timestamp.UTC()
+	rows, err := e.db.QueryContext(ctx, `
+		SELECT duration_ms FROM flows
+		WHERE model = ? AND duration_ms IS NOT NULL AND id != ?
+			AND timestamp >= ? AND timestamp < ?
+	`, f.model, f.id, store.FormatTime(ts.Add(-thresholds.LatencyWindow)), store.FormatTime(ts))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var b latencyBaseline
+	for rows.Next() {
+		var durationMs int64
+		if err := rows.Scan(&durationMs); err != nil {
+			return nil, err
+		}
+		b.add(float64(durationMs))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return b.check(thresholds, f), nil
+}
@@ -0,0 +1,116 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/testutil"
+)
+
+func TestDetectLatencyAnomalies(t *testing.T) {
+	engine, s := setupTestEngine(t)
+	ctx := context.Background()
+
+	base := time.Now().Add(-2 * time.Hour)
+	save := func(id, model string, at time.Duration, durationMs int64) {
+		t.Helper()
+		flow := testutil.NewFlow().WithID(id).WithModel(model).WithDuration(durationMs).Build()
+		flow.Timestamp = base.Add(at)
+		if err := s.SaveFlow(ctx, flow); err != nil {
+			t.Fatalf("SaveFlow(%s): %v", id, err)
+		}
+	}
+
+	// Baseline: 1000ms +/- 100ms
+	for i := 0; i < 20; i++ {
+		save(fmt.Sprintf("normal-%d", i), "claude-sonnet-4", time.Duration(i)*time.Minute, 900+int64(i%3)*100)
+	}
+	save("slow", "claude-sonnet-4", time.Hour, 5000)
+	save("after", "claude-sonnet-4", time.Hour+time.Minute, 1100)
+	// Too few flows of this model for a baseline
+	for i := 0; i < 3; i++ {
+		save(fmt.Sprintf("haiku-%d", i), "claude-haiku-4", time.Duration(i)*time.Minute, 200)
+	}
+	save("haiku-slow", "claude-haiku-4", time.Hour, 5000)
+
+	since := base.Add(-time.Minute)
+	anomalies, err := engine.DetectLatencyAnomalies(ctx, since, nil)
+	if err != nil {
+		t.Fatalf("DetectLatencyAnomalies: %v", err)
+	}
+	if len(anomalies) != 1 {
+		t.Fatalf("got %d anomalies, want 1: %+v", len(anomalies), anomalies)
+	}
+	a := anomalies[0]
+	if a.FlowID != "slow" || a.Type != AnomalySlowResponse || a.Value != 5000 {
+		t.Errorf("anomaly = %+v, want slow_response for flow slow", a)
+	}
+	if a.Threshold <= 1000 || a.Threshold >= 5000 {
+		t.Errorf("threshold = %v, want between the mean and the slow flow", a.Threshold)
+	}
+
+	// Same verdict for the single-flow check
+	flowAnomalies, err := engine.DetectFlowAnomalies(ctx, "slow", nil)
+	if err != nil {
+		t.Fatalf("DetectFlowAnomalies: %v", err)
+	}
+	if len(flowAnomalies) != 1 || flowAnomalies[0].Type != AnomalySlowResponse {
+		t.Errorf("DetectFlowAnomalies(slow) = %+v, want one slow_response", flowAnomalies)
+	}
+	for _, id := range []string{"after", "haiku-slow"} {
+		flowAnomalies, err := engine.DetectFlowAnomalies(ctx, id, nil)
+		if err != nil {
+			t.Fatalf("DetectFlowAnomalies(%s): %v", id, err)
+		}
+		if len(flowAnomalies) != 0 {
+			t.Errorf("DetectFlowAnomalies(%s) = %+v, want none", id, flowAnomalies)
+		}
+	}
+
+	// Listed once among recent anomalies
+	recent, err := engine.ListRecentAnomalies(ctx, since, nil)
+	if err != nil {
+		t.Fatalf("ListRecentAnomalies: %v", err)
+	}
+	slow := 0
+	for _, a := range recent {
+		if a.Type == AnomalySlowResponse && a.FlowID == "slow" {
+			slow++
+		}
+	}
+	if slow != 1 {
+		t.Errorf("ListRecentAnomalies reported the slow flow %d times, want 1", slow)
+	}
+
+	// Over both the absolute and the relative threshold: still one anomaly
+	save("very-slow", "claude-sonnet-4", time.Hour+2*time.Minute, 40000)
+	flowAnomalies, err = engine.DetectFlowAnomalies(ctx, "very-slow", nil)
+	if err != nil {
+		t.Fatalf("DetectFlowAnomalies(very-slow): %v", err)
+	}
+	if len(flowAnomalies) != 1 || flowAnomalies[0].Type != AnomalySlowResponse {
+		t.Errorf("DetectFlowAnomalies(very-slow) = %+v, want one slow_response", flowAnomalies)
+	}
+	recent, err = engine.ListRecentAnomalies(ctx, since, nil)
+	if err != nil {
+		t.Fatalf("ListRecentAnomalies: %v", err)
+	}
+	slow = 0
+	for _, a := range recent {
+		if a.Type == AnomalySlowResponse && a.FlowID == "very-slow" {
+			slow++
+		}
+	}
+	if slow != 1 {
+		t.Errorf("ListRecentAnomalies reported very-slow %d times, want 1", slow)
+	}
+
+	// Disabled with a zero sigma
+	thresholds := DefaultThresholds()
+	thresholds.LatencySigma = 0
+	if anomalies, _ := engine.DetectLatencyAnomalies(ctx, since, thresholds); len(anomalies) != 0 {
+		t.Errorf("sigma 0: got %d anomalies, want none", len(anomalies))
+	}
+}
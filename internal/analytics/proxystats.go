@@ -0,0 +1,35 @@
+package analytics
+
+// Snapshots of the proxy's live limits and forwarding rate, reported in
+// /api/health.
+
+// UpstreamStats is a snapshot of the upstream concurrency limit.
+type UpstreamStats struct {
+	MaxConcurrent int    `json:"max_concurrent"`
+	Overflow      string `json:"overflow"` // "queue" or "reject"
+	InFlight      int    `json:"in_flight"`
+	Waiting       int    `json:"waiting"`
+	Queued        int64  `json:"queued_total"`   // Requests that had to wait, since startup
+	Rejected      int64  `json:"rejected_total"` // Requests answered 503, since startup
+}
+
+// ModelLimitStats is a snapshot of one proxy.rate_limits rule.
+type ModelLimitStats struct {
+	Model             string `json:"model"`
+	RequestsPerMinute int    `json:"requests_per_minute,omitempty"`
+	TokensPerMinute   int    `json:"tokens_per_minute,omitempty"`
+	Requests          int    `json:"requests"`         // Admitted in the last minute
+	Tokens            int    `json:"tokens"`           // Estimated input tokens admitted in the last minute
+	Delayed           int64  `json:"delayed_total"`    // Requests that had to wait, since startup
+	DelayedMs         int64  `json:"delayed_ms_total"` // Time spent waiting, since startup
+	Rejected          int64  `json:"rejected_total"`   // Requests answered 429, since startup
+}
+
+// ThroughputStats is a snapshot of how much the proxy is forwarding and how
+// long upstreams take to answer, to tell whether langley itself is the
+// bottleneck.
+type ThroughputStats struct {
+	InFlight           int     `json:"in_flight"`              // Intercepted requests not yet finished
+	RequestsLastMinute int     `json:"requests_last_minute"`   // Forwarded requests answered in the last minute
+	AvgLatencyMs       float64 `json:"avg_forward_latency_ms"` // From receiving a request to its upstream response headers, over the last minute
+}
@@ -0,0 +1,93 @@
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+// quotaBucketFormats maps GetQuotaTimeline bucket names to strftime formats.
+var quotaBucketFormats = map[string]string{
+	"minute": "%Y-%m-%dT%H:%M:00Z",
+	"hour":   "%Y-%m-%dT%H:00:00Z",
+}
+
+// ValidQuotaBucket reports whether bucket is accepted by GetQuotaTimeline.
+func ValidQuotaBucket(bucket string) bool {
+	_, ok := quotaBucketFormats[bucket]
+	return ok
+}
+
+// QuotaPoint is a provider's rate-limit state over one time bucket.
+// Remaining values are the lowest seen in the bucket (closest to the limit).
+type QuotaPoint struct {
+	Provider          string
+	Period            string // Bucket start, RFC 3339 UTC
+	Samples           int
+	RequestsLimit     *int64
+	RequestsRemaining *int64
+	TokensLimit       *int64
+	TokensRemaining   *int64
+}
+
+// GetQuotaTimeline returns remaining quota per provider over time, from flows
+// that captured rate-limit headers. provider filters to one provider when
+// non-empty. Points are ordered by provider, then period.
+func (e *Engine) GetQuotaTimeline(ctx context.Context, start, end time.Time, provider, bucket string) ([]*QuotaPoint, error) {
+	format, ok := quotaBucketFormats[bucket]
+	if !ok {
+		return nil, fmt.Errorf("unknown quota bucket %q", bucket)
+	}
+
+	query := `
+		SELECT
+			provider,
+			strftime(?, timestamp) as period,
+			COUNT(*) as samples,
+			MAX(ratelimit_requests_limit),
+			MIN(ratelimit_requests_remaining),
+			MAX(ratelimit_tokens_limit),
+			MIN(ratelimit_tokens_remaining)
+		FROM flows
+		WHERE timestamp >= ? AND timestamp <= ?
+			AND (ratelimit_requests_remaining IS NOT NULL OR ratelimit_tokens_remaining IS NOT NULL)`
+	args := []interface{}{format, store.FormatTime(start), store.FormatTime(end)}
+	if provider != "" {
+		query += " AND provider = ?"
+		args = append(args, provider)
+	}
+	query += " GROUP BY provider, period ORDER BY provider, period"
+
+	rows, err := e.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []*QuotaPoint{}
+	for rows.Next() {
+		var p QuotaPoint
+		var reqLimit, reqRemaining, tokLimit, tokRemaining sql.NullInt64
+		if err := rows.Scan(&p.Provider, &p.Period, &p.Samples,
+			&reqLimit, &reqRemaining, &tokLimit, &tokRemaining); err != nil {
+			return nil, err
+		}
+		p.RequestsLimit = nullInt64Ptr(reqLimit)
+		p.RequestsRemaining = nullInt64Ptr(reqRemaining)
+		p.TokensLimit = nullInt64Ptr(tokLimit)
+		p.TokensRemaining = nullInt64Ptr(tokRemaining)
+		points = append(points, &p)
+	}
+
+	return points, rows.Err()
+}
+
+func nullInt64Ptr(v sql.NullInt64) *int64 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Int64
+}
@@ -0,0 +1,86 @@
+package analytics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+func TestGetQuotaTimeline(t *testing.T) {
+	engine, s := setupTestEngine(t)
+	ctx := context.Background()
+
+	base := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	n := func(v int64) *int64 { return &v }
+
+	flows := []*store.Flow{
+		{ID: "a1", Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages", Timestamp: base,
+			FlowIntegrity: "complete", Provider: "anthropic",
+			RateLimitRequestsLimit: n(50), RateLimitRequestsRemaining: n(49),
+			RateLimitTokensLimit: n(80000), RateLimitTokensRemaining: n(70000)},
+		{ID: "a2", Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages", Timestamp: base.Add(20 * time.Minute),
+			FlowIntegrity: "complete", Provider: "anthropic",
+			RateLimitRequestsLimit: n(50), RateLimitRequestsRemaining: n(48),
+			RateLimitTokensLimit: n(80000), RateLimitTokensRemaining: n(60000)},
+		{ID: "a3", Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages", Timestamp: base.Add(70 * time.Minute),
+			FlowIntegrity: "complete", Provider: "anthropic",
+			RateLimitRequestsLimit: n(50), RateLimitRequestsRemaining: n(50),
+			RateLimitTokensLimit: n(80000), RateLimitTokensRemaining: n(79000)},
+		{ID: "o1", Host: "api.openai.com", Method: "POST", Path: "/v1/chat/completions", Timestamp: base.Add(5 * time.Minute),
+			FlowIntegrity: "complete", Provider: "openai",
+			RateLimitRequestsRemaining: n(9999)},
+		// No rate-limit headers: excluded
+		{ID: "x1", Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages", Timestamp: base.Add(10 * time.Minute),
+			FlowIntegrity: "complete", Provider: "anthropic"},
+	}
+	for _, f := range flows {
+		if err := s.SaveFlow(ctx, f); err != nil {
+			t.Fatalf("SaveFlow(%s): %v", f.ID, err)
+		}
+	}
+
+	points, err := engine.GetQuotaTimeline(ctx, base.Add(-time.Hour), base.Add(3*time.Hour), "", "hour")
+	if err != nil {
+		t.Fatalf("GetQuotaTimeline: %v", err)
+	}
+	if len(points) != 3 {
+		t.Fatalf("len(points) = %d, want 3", len(points))
+	}
+
+	first := points[0]
+	if first.Provider != "anthropic" || first.Period != "2026-01-02T10:00:00Z" {
+		t.Errorf("points[0] = %s %s, want anthropic 2026-01-02T10:00:00Z", first.Provider, first.Period)
+	}
+	if first.Samples != 2 {
+		t.Errorf("points[0].Samples = %d, want 2", first.Samples)
+	}
+	if first.TokensRemaining == nil || *first.TokensRemaining != 60000 {
+		t.Errorf("points[0].TokensRemaining = %v, want 60000 (lowest in bucket)", first.TokensRemaining)
+	}
+	if first.RequestsRemaining == nil || *first.RequestsRemaining != 48 {
+		t.Errorf("points[0].RequestsRemaining = %v, want 48", first.RequestsRemaining)
+	}
+	if points[1].Period != "2026-01-02T11:00:00Z" {
+		t.Errorf("points[1].Period = %s, want 2026-01-02T11:00:00Z", points[1].Period)
+	}
+
+	last := points[2]
+	if last.Provider != "openai" || last.TokensRemaining != nil {
+		t.Errorf("points[2] = %s tokens %v, want openai with no token data", last.Provider, last.TokensRemaining)
+	}
+
+	// Provider filter
+	points, err = engine.GetQuotaTimeline(ctx, base.Add(-time.Hour), base.Add(3*time.Hour), "openai", "minute")
+	if err != nil {
+		t.Fatalf("GetQuotaTimeline(openai): %v", err)
+	}
+	if len(points) != 1 || points[0].Period != "2026-01-02T10:05:00Z" {
+		t.Errorf("openai points = %+v, want one point at 10:05", points)
+	}
+
+	if _, err := engine.GetQuotaTimeline(ctx, base, base, "", "week"); err == nil {
+		t.Error("expected error for unknown bucket")
+	}
+}
@@ -0,0 +1,93 @@
+package analytics
+
+import (
+	"context"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+// CostBucket totals the flows in one cost-source bucket.
+type CostBucket struct {
+	FlowCount    int
+	Cost         float64
+	InputTokens  int
+	OutputTokens int
+}
+
+// CostReconciliation breaks a period's estimated cost down by how each
+// flow was costed, to show how much of the traffic the estimate covers
+// when comparing it with a provider's bill.
+type CostReconciliation struct {
+	TotalFlows int
+	TotalCost  float64
+	Exact      CostBucket // cost_source "exact": computed from the model's price list, "local": free, or "manual": set by the client
+	// Estimated holds cost_source "estimated", and flows with a cost but no
+	// recorded source.
+	Estimated CostBucket
+	// Uncosted flows have no cost. MissingUsage had no token counts in the
+	// response; UnknownModel had token counts but no price for the model.
+	Uncosted     CostBucket
+	MissingUsage int
+	UnknownModel int
+	// Coverage is the share of flows that have a cost, 0-1.
+	Coverage float64
+}
+
+// ReconcileCost counts flows between start and end by cost source.
+func (e *Engine) ReconcileCost(ctx context.Context, start, end time.Time) (*CostReconciliation, error) {
+	rows, err := e.db.QueryContext(ctx, `
+		SELECT
+			CASE
+				WHEN total_cost IS NOT NULL AND cost_source IN ('exact', 'local', 'manual') THEN 'exact'
+				WHEN total_cost IS NOT NULL THEN 'estimated'
+				WHEN input_tokens IS NULL AND output_tokens IS NULL THEN 'missing_usage'
+				ELSE 'unknown_model'
+			END as bucket,
+			COUNT(*),
+			COALESCE(SUM(total_cost), 0),
+			COALESCE(SUM(input_tokens), 0),
+			COALESCE(SUM(output_tokens), 0)
+		FROM flows
+		WHERE timestamp >= ? AND timestamp <= ?
+		GROUP BY bucket
+	`, store.FormatTime(start), store.FormatTime(end))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rec CostReconciliation
+	for rows.Next() {
+		var bucket string
+		var b CostBucket
+		if err := rows.Scan(&bucket, &b.FlowCount, &b.Cost, &b.InputTokens, &b.OutputTokens); err != nil {
+			return nil, err
+		}
+		switch bucket {
+		case "exact":
+			rec.Exact = b
+		case "estimated":
+			rec.Estimated = b
+		default:
+			if bucket == "missing_usage" {
+				rec.MissingUsage = b.FlowCount
+			} else {
+				rec.UnknownModel = b.FlowCount
+			}
+			rec.Uncosted.FlowCount += b.FlowCount
+			rec.Uncosted.InputTokens += b.InputTokens
+			rec.Uncosted.OutputTokens += b.OutputTokens
+		}
+		rec.TotalFlows += b.FlowCount
+		rec.TotalCost += b.Cost
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if rec.TotalFlows > 0 {
+		rec.Coverage = float64(rec.Exact.FlowCount+rec.Estimated.FlowCount) / float64(rec.TotalFlows)
+	}
+	return &rec, nil
+}
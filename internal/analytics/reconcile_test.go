@@ -0,0 +1,78 @@
+package analytics
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+func TestReconcileCost(t *testing.T) {
+	engine, s := setupTestEngine(t)
+	ctx := context.Background()
+
+	base := time.Date(2026, 3, 4, 9, 0, 0, 0, time.UTC)
+	intp := func(v int) *int { return &v }
+	strp := func(v string) *string { return &v }
+	flow := func(id string, cost *float64, source *string, in, out *int, at time.Duration) *store.Flow {
+		return &store.Flow{ID: id, Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages",
+			Timestamp: base.Add(at), FlowIntegrity: "complete", Provider: "anthropic",
+			TotalCost: cost, CostSource: source, InputTokens: in, OutputTokens: out}
+	}
+	costp := func(v float64) *float64 { return &v }
+
+	flows := []*store.Flow{
+		flow("exact-1", costp(0.50), strp("exact"), intp(1000), intp(100), time.Second),
+		flow("exact-2", costp(0.25), strp("exact"), intp(500), intp(50), 2*time.Second),
+		flow("estimated", costp(0.10), strp("estimated"), intp(200), intp(20), 3*time.Second),
+		flow("no-usage-1", nil, nil, nil, nil, 4*time.Second),
+		flow("no-usage-2", nil, nil, nil, nil, 5*time.Second),
+		flow("unknown-model", nil, nil, intp(300), intp(30), 6*time.Second),
+		flow("old", costp(9), strp("exact"), intp(1), intp(1), -2*time.Hour), // Outside the window
+	}
+	for _, f := range flows {
+		if err := s.SaveFlow(ctx, f); err != nil {
+			t.Fatalf("SaveFlow(%s): %v", f.ID, err)
+		}
+	}
+
+	rec, err := engine.ReconcileCost(ctx, base.Add(-time.Hour), base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("ReconcileCost: %v", err)
+	}
+
+	if rec.TotalFlows != 6 {
+		t.Errorf("TotalFlows = %d, want 6", rec.TotalFlows)
+	}
+	if math.Abs(rec.TotalCost-0.85) > 1e-9 {
+		t.Errorf("TotalCost = %v, want 0.85", rec.TotalCost)
+	}
+	if rec.Exact.FlowCount != 2 || math.Abs(rec.Exact.Cost-0.75) > 1e-9 || rec.Exact.InputTokens != 1500 {
+		t.Errorf("Exact = %+v, want 2 flows, $0.75, 1500 input tokens", rec.Exact)
+	}
+	if rec.Estimated.FlowCount != 1 || math.Abs(rec.Estimated.Cost-0.10) > 1e-9 {
+		t.Errorf("Estimated = %+v, want 1 flow, $0.10", rec.Estimated)
+	}
+	if rec.Uncosted.FlowCount != 3 || rec.Uncosted.Cost != 0 || rec.Uncosted.InputTokens != 300 {
+		t.Errorf("Uncosted = %+v, want 3 flows, no cost, 300 input tokens", rec.Uncosted)
+	}
+	if rec.MissingUsage != 2 || rec.UnknownModel != 1 {
+		t.Errorf("MissingUsage = %d, UnknownModel = %d; want 2, 1", rec.MissingUsage, rec.UnknownModel)
+	}
+	if sum := rec.Exact.FlowCount + rec.Estimated.FlowCount + rec.Uncosted.FlowCount; sum != rec.TotalFlows {
+		t.Errorf("buckets sum to %d flows, want %d", sum, rec.TotalFlows)
+	}
+	if math.Abs(rec.Coverage-0.5) > 1e-9 {
+		t.Errorf("Coverage = %v, want 0.5", rec.Coverage)
+	}
+
+	empty, err := engine.ReconcileCost(ctx, base.Add(time.Hour), base.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("ReconcileCost(empty): %v", err)
+	}
+	if empty.TotalFlows != 0 || empty.Coverage != 0 {
+		t.Errorf("empty range = %+v", empty)
+	}
+}
@@ -0,0 +1,163 @@
+package analytics
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+// PricingTier overrides per-token rates for a provider/model once its
+// month-to-date token volume reaches MinMonthlyTokens.
+type PricingTier struct {
+	Provider         string
+	Model            string // "*" matches any run of characters
+	MinMonthlyTokens int64
+	InputCostPer1k   float64
+	OutputCostPer1k  float64
+}
+
+// tierVolumeRefresh is how long a month-to-date volume is kept as a
+// running total before it is summed from the database again, picking up
+// flows that were saved without being priced.
+const tierVolumeRefresh = 5 * time.Minute
+
+// tierState holds the pricing tiers and the month-to-date token volume of
+// each provider and tier model pattern, so that pricing a flow doesn't sum
+// the month's flows every time.
+type tierState struct {
+	mu      sync.Mutex
+	tiers   []PricingTier
+	volumes map[tierVolumeKey]*tierVolume
+}
+
+type tierVolumeKey struct {
+	provider, pattern string
+}
+
+type tierVolume struct {
+	month  time.Time // Start of the month the total covers
+	loaded time.Time // When the total was last summed from the database
+	tokens int64
+}
+
+// SetPricingTiers sets the volume discount tiers consulted by CalculateCost.
+func (e *Engine) SetPricingTiers(tiers []PricingTier) {
+	e.tierState.mu.Lock()
+	defer e.tierState.mu.Unlock()
+	e.tierState.tiers = tiers
+	e.tierState.volumes = nil
+}
+
+// applyPricingTier returns pricing with input/output rates replaced by the
+// highest tier the provider/model has reached this month. Cache rates are
+// kept from the base pricing. If no tier applies, base is returned unchanged.
+// tokens, the usage being priced, is then added to the month's volume.
+func (e *Engine) applyPricingTier(ctx context.Context, provider, model string, base *ModelPricing, tokens int64) (*ModelPricing, error) {
+	ts := &e.tierState
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if len(ts.tiers) == 0 {
+		return base, nil
+	}
+
+	now := time.Now()
+	monthStart := startOfMonth(now)
+	matched := make(map[tierVolumeKey]*tierVolume)
+
+	var best *PricingTier
+	for i := range ts.tiers {
+		tier := &ts.tiers[i]
+		if tier.Provider != provider || !MatchModelPattern(tier.Model, model) {
+			continue
+		}
+
+		key := tierVolumeKey{provider: provider, pattern: tier.Model}
+		volume, ok := matched[key]
+		if !ok {
+			volume = ts.volumes[key]
+			if volume == nil || !volume.month.Equal(monthStart) || now.Sub(volume.loaded) >= tierVolumeRefresh {
+				total, err := e.monthlyTokenVolume(ctx, provider, tier.Model, monthStart)
+				if err != nil {
+					return nil, err
+				}
+				volume = &tierVolume{month: monthStart, loaded: now, tokens: total}
+				if ts.volumes == nil {
+					ts.volumes = make(map[tierVolumeKey]*tierVolume)
+				}
+				ts.volumes[key] = volume
+			}
+			matched[key] = volume
+		}
+
+		if volume.tokens >= tier.MinMonthlyTokens && (best == nil || tier.MinMonthlyTokens > best.MinMonthlyTokens) {
+			best = tier
+		}
+	}
+	for _, volume := range matched {
+		volume.tokens += tokens
+	}
+	if best == nil {
+		return base, nil
+	}
+
+	tiered := ModelPricing{
+		Provider:        provider,
+		ModelPattern:    best.Model,
+		InputCostPer1k:  best.InputCostPer1k,
+		OutputCostPer1k: best.OutputCostPer1k,
+		EffectiveDate:   monthStart,
+	}
+	if base != nil {
+		tiered.CacheCreationPer1k = base.CacheCreationPer1k
+		tiered.CacheReadPer1k = base.CacheReadPer1k
+	}
+	return &tiered, nil
+}
+
+// monthlyTokenVolume sums input and output tokens for flows matching the
+// provider and model pattern since monthStart.
+func (e *Engine) monthlyTokenVolume(ctx context.Context, provider, pattern string, monthStart time.Time) (int64, error) {
+	var volume int64
+	err := e.db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(COALESCE(input_tokens, 0) + COALESCE(output_tokens, 0)), 0)
+		FROM flows
+		WHERE provider = ? AND model LIKE ? ESCAPE '\' AND timestamp >= ?
+	`, provider, patternToLike(pattern), store.FormatTime(monthStart)).Scan(&volume)
+	return volume, err
+}
+
+// startOfMonth returns midnight UTC on the first day of t's month.
+func startOfMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// MatchModelPattern reports whether model matches pattern, where "*" matches
+// any run of characters and everything else matches literally.
+func MatchModelPattern(pattern, model string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == model
+	}
+	if !strings.HasPrefix(model, parts[0]) {
+		return false
+	}
+	rest := model[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+	return strings.HasSuffix(rest, parts[len(parts)-1])
+}
+
+// patternToLike converts a "*" model pattern to an escaped SQL LIKE pattern.
+func patternToLike(pattern string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`, `*`, `%`)
+	return r.Replace(pattern)
+}
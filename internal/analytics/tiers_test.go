@@ -0,0 +1,118 @@
+package analytics
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+func TestCalculateCost_PricingTierThreshold(t *testing.T) {
+	engine, s := setupTestEngine(t)
+	ctx := context.Background()
+
+	tiers := []PricingTier{
+		{Provider: "anthropic", Model: "claude-sonnet-4*", MinMonthlyTokens: 1000, InputCostPer1k: 0.002, OutputCostPer1k: 0.010},
+		{Provider: "anthropic", Model: "claude-sonnet-4*", MinMonthlyTokens: 1000000, InputCostPer1k: 0.001, OutputCostPer1k: 0.005},
+	}
+	engine.SetPricingTiers(tiers)
+
+	const model = "claude-sonnet-4-20250514"
+	cost := func() float64 {
+		t.Helper()
+		c, source, err := engine.CalculateCost(ctx, "anthropic", model, 1000, 1000, 0, 0)
+		if err != nil {
+			t.Fatalf("CalculateCost: %v", err)
+		}
+		if source != "exact" {
+			t.Errorf("cost source = %q, want exact", source)
+		}
+		return c
+	}
+
+	// No volume yet: base database rates (0.003 in, 0.015 out)
+	if got, want := cost(), 0.018; math.Abs(got-want) > 1e-9 {
+		t.Fatalf("cost below threshold = %v, want %v", got, want)
+	}
+
+	ptr := func(v int) *int { return &v }
+	modelPtr := func(v string) *string { return &v }
+	now := time.Now().UTC()
+	flows := []*store.Flow{
+		// 1200 tokens this month for the tiered model crosses the first tier
+		{ID: "flow-1", Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages", Timestamp: now,
+			Model: modelPtr(model), InputTokens: ptr(800), OutputTokens: ptr(400), FlowIntegrity: "complete", Provider: "anthropic"},
+		// Other models and last month's traffic don't count toward the volume
+		{ID: "flow-2", Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages", Timestamp: now,
+			Model: modelPtr("claude-opus-4-20250514"), InputTokens: ptr(5000000), FlowIntegrity: "complete", Provider: "anthropic"},
+		{ID: "flow-3", Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages", Timestamp: startOfMonth(now).Add(-time.Hour),
+			Model: modelPtr(model), InputTokens: ptr(5000000), FlowIntegrity: "complete", Provider: "anthropic"},
+	}
+	for _, f := range flows {
+		if err := s.SaveFlow(ctx, f); err != nil {
+			t.Fatalf("SaveFlow(%s): %v", f.ID, err)
+		}
+	}
+	// Setting the tiers again sums the volume from the saved flows rather
+	// than from the usage priced so far
+	engine.SetPricingTiers(tiers)
+
+	if got, want := cost(), 0.012; math.Abs(got-want) > 1e-9 {
+		t.Errorf("cost above threshold = %v, want %v", got, want)
+	}
+
+	// Untiered models keep their base rates
+	c, _, err := engine.CalculateCost(ctx, "anthropic", "claude-opus-4-20250514", 1000, 1000, 0, 0)
+	if err != nil {
+		t.Fatalf("CalculateCost: %v", err)
+	}
+	if want := 0.09; math.Abs(c-want) > 1e-9 {
+		t.Errorf("untiered cost = %v, want %v", c, want)
+	}
+}
+
+func TestMatchModelPattern(t *testing.T) {
+	tests := []struct {
+		pattern, model string
+		want           bool
+	}{
+		{"gpt-4o", "gpt-4o", true},
+		{"gpt-4o", "gpt-4o-mini", false},
+		{"gpt-4o*", "gpt-4o-mini", true},
+		{"*sonnet*", "claude-sonnet-4-20250514", true},
+		{"claude-*-4*", "claude-opus-4-1", true},
+		{"claude-*-4*", "claude-3-opus", false},
+		{"*", "anything", true},
+	}
+	for _, tt := range tests {
+		if got := MatchModelPattern(tt.pattern, tt.model); got != tt.want {
+			t.Errorf("MatchModelPattern(%q, %q) = %v, want %v", tt.pattern, tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestCalculateCost_PricingTierRunningTotal(t *testing.T) {
+	engine, _ := setupTestEngine(t)
+	ctx := context.Background()
+
+	engine.SetPricingTiers([]PricingTier{
+		{Provider: "anthropic", Model: "claude-sonnet-4*", MinMonthlyTokens: 3000, InputCostPer1k: 0.002, OutputCostPer1k: 0.010},
+	})
+
+	// Each call prices 2000 tokens, which count toward the next call's volume
+	var costs []float64
+	for i := 0; i < 3; i++ {
+		c, _, err := engine.CalculateCost(ctx, "anthropic", "claude-sonnet-4-20250514", 1000, 1000, 0, 0)
+		if err != nil {
+			t.Fatalf("CalculateCost: %v", err)
+		}
+		costs = append(costs, c)
+	}
+	for i, want := range []float64{0.018, 0.018, 0.012} {
+		if math.Abs(costs[i]-want) > 1e-9 {
+			t.Errorf("call %d cost = %v, want %v", i+1, costs[i], want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// TimelineTool is a tool invocation nested under its flow in a task timeline.
+type TimelineTool struct {
+	ID         string
+	ToolUseID  *string
+	ToolName   string
+	Timestamp  time.Time
+	DurationMs *int64
+	Success    *bool
+}
+
+// TimelineEntry is a single flow in a task timeline.
+type TimelineEntry struct {
+	FlowID       string
+	Timestamp    time.Time
+	DurationMs   *int64
+	StatusCode   *int
+	Method       string
+	Host         string
+	Path         string
+	Model        *string
+	InputTokens  int
+	OutputTokens int
+	TotalCost    float64
+	Tools        []*TimelineTool
+}
+
+// GetTaskTimeline returns the flows of a task in chronological order, each
+// with its tool invocations nested underneath (also in chronological order).
+// Returns an empty slice if the task has no flows.
+func (e *Engine) GetTaskTimeline(ctx context.Context, taskID string) ([]*TimelineEntry, error) {
+	rows, err := e.db.QueryContext(ctx, `
+		SELECT id, timestamp, duration_ms, status_code, method, host, path, model,
+			COALESCE(input_tokens, 0), COALESCE(output_tokens, 0), COALESCE(total_cost, 0)
+		FROM flows
+		WHERE task_id = ?
+		ORDER BY timestamp ASC
+	`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*TimelineEntry{}
+	byFlow := make(map[string]*TimelineEntry)
+	for rows.Next() {
+		var entry TimelineEntry
+		var ts string
+		var durationMs sql.NullInt64
+		var statusCode sql.NullInt64
+		var model sql.NullString
+
+		err := rows.Scan(
+			&entry.FlowID,
+			&ts,
+			&durationMs,
+			&statusCode,
+			&entry.Method,
+			&entry.Host,
+			&entry.Path,
+			&model,
+			&entry.InputTokens,
+			&entry.OutputTokens,
+			&entry.TotalCost,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		entry.Timestamp, _ = time.Parse(time.RFC3339Nano, ts)
+		if durationMs.Valid {
+			entry.DurationMs = &durationMs.Int64
+		}
+		if statusCode.Valid {
+			code := int(statusCode.Int64)
+			entry.StatusCode = &code
+		}
+		if model.Valid {
+			entry.Model = &model.String
+		}
+		entry.Tools = []*TimelineTool{}
+
+		entries = append(entries, &entry)
+		byFlow[entry.FlowID] = &entry
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return entries, nil
+	}
+
+	// Attach tool invocations to the flow that produced them
+	toolRows, err := e.db.QueryContext(ctx, `
+		SELECT t.id, t.flow_id, t.tool_use_id, t.tool_name, t.timestamp, t.duration_ms, t.success
+		FROM tool_invocations t
+		JOIN flows f ON f.id = t.flow_id
+		WHERE f.task_id = ?
+		ORDER BY t.timestamp ASC
+	`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer toolRows.Close()
+
+	for toolRows.Next() {
+		var tool TimelineTool
+		var flowID, ts string
+		var toolUseID sql.NullString
+		var durationMs sql.NullInt64
+		var success sql.NullBool
+
+		err := toolRows.Scan(
+			&tool.ID,
+			&flowID,
+			&toolUseID,
+			&tool.ToolName,
+			&ts,
+			&durationMs,
+			&success,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		tool.Timestamp, _ = time.Parse(time.RFC3339Nano, ts)
+		if toolUseID.Valid {
+			tool.ToolUseID = &toolUseID.String
+		}
+		if durationMs.Valid {
+			tool.DurationMs = &durationMs.Int64
+		}
+		if success.Valid {
+			tool.Success = &success.Bool
+		}
+
+		if entry, ok := byFlow[flowID]; ok {
+			entry.Tools = append(entry.Tools, &tool)
+		}
+	}
+
+	return entries, toolRows.Err()
+}
@@ -0,0 +1,122 @@
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/config"
+	"github.com/HakAl/langley/internal/store"
+)
+
+// setupTestEngine creates an analytics engine backed by an in-memory store.
+func setupTestEngine(t *testing.T) (*Engine, *store.SQLiteStore) {
+	t.Helper()
+	s, err := store.NewSQLiteStore(":memory:", &config.RetentionConfig{
+		FlowsTTLDays:   7,
+		EventsTTLDays:  3,
+		DropLogTTLDays: 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create test store: %v", err)
+	}
+	t.Cleanup(func() {
+		s.Close()
+	})
+	return NewEngine(s.DB().(*sql.DB)), s
+}
+
+func TestGetTaskTimeline(t *testing.T) {
+	engine, s := setupTestEngine(t)
+	ctx := context.Background()
+
+	taskID := "task-timeline"
+	otherTask := "task-other"
+	base := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	ptr := func(v int) *int { return &v }
+	dur := func(v int64) *int64 { return &v }
+
+	// Insert the later flow first to make sure ordering comes from timestamps
+	flows := []*store.Flow{
+		{ID: "flow-2", TaskID: &taskID, Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages",
+			Timestamp: base.Add(30 * time.Second), DurationMs: dur(1500), StatusCode: ptr(200),
+			InputTokens: ptr(300), OutputTokens: ptr(40), FlowIntegrity: "complete", Provider: "anthropic"},
+		{ID: "flow-1", TaskID: &taskID, Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages",
+			Timestamp: base, DurationMs: dur(2000), StatusCode: ptr(200),
+			InputTokens: ptr(100), OutputTokens: ptr(20), FlowIntegrity: "complete", Provider: "anthropic"},
+		{ID: "flow-other", TaskID: &otherTask, Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages",
+			Timestamp: base.Add(10 * time.Second), FlowIntegrity: "complete", Provider: "anthropic"},
+	}
+	for _, f := range flows {
+		if err := s.SaveFlow(ctx, f); err != nil {
+			t.Fatalf("SaveFlow(%s): %v", f.ID, err)
+		}
+	}
+
+	tools := []*store.ToolInvocation{
+		{ID: "tool-2b", FlowID: "flow-2", TaskID: &taskID, ToolName: "Edit", Timestamp: base.Add(32 * time.Second)},
+		{ID: "tool-1b", FlowID: "flow-1", TaskID: &taskID, ToolName: "Grep", Timestamp: base.Add(2 * time.Second)},
+		{ID: "tool-1a", FlowID: "flow-1", TaskID: &taskID, ToolName: "Read", Timestamp: base.Add(1 * time.Second)},
+		{ID: "tool-2a", FlowID: "flow-2", TaskID: &taskID, ToolName: "Bash", Timestamp: base.Add(31 * time.Second)},
+		{ID: "tool-other", FlowID: "flow-other", TaskID: &otherTask, ToolName: "Read", Timestamp: base.Add(11 * time.Second)},
+	}
+	for _, inv := range tools {
+		if err := s.SaveToolInvocation(ctx, inv); err != nil {
+			t.Fatalf("SaveToolInvocation(%s): %v", inv.ID, err)
+		}
+	}
+
+	timeline, err := engine.GetTaskTimeline(ctx, taskID)
+	if err != nil {
+		t.Fatalf("GetTaskTimeline: %v", err)
+	}
+
+	if len(timeline) != 2 {
+		t.Fatalf("len(timeline) = %d, want 2", len(timeline))
+	}
+
+	want := []struct {
+		flowID string
+		tools  []string
+	}{
+		{"flow-1", []string{"tool-1a", "tool-1b"}},
+		{"flow-2", []string{"tool-2a", "tool-2b"}},
+	}
+	for i, w := range want {
+		entry := timeline[i]
+		if entry.FlowID != w.flowID {
+			t.Errorf("timeline[%d].FlowID = %q, want %q", i, entry.FlowID, w.flowID)
+		}
+		if len(entry.Tools) != len(w.tools) {
+			t.Fatalf("timeline[%d] has %d tools, want %d", i, len(entry.Tools), len(w.tools))
+		}
+		for j, toolID := range w.tools {
+			if entry.Tools[j].ID != toolID {
+				t.Errorf("timeline[%d].Tools[%d].ID = %q, want %q", i, j, entry.Tools[j].ID, toolID)
+			}
+		}
+	}
+
+	if !timeline[0].Timestamp.Equal(base) {
+		t.Errorf("timeline[0].Timestamp = %v, want %v", timeline[0].Timestamp, base)
+	}
+	if timeline[0].DurationMs == nil || *timeline[0].DurationMs != 2000 {
+		t.Errorf("timeline[0].DurationMs = %v, want 2000", timeline[0].DurationMs)
+	}
+	if timeline[1].InputTokens != 300 || timeline[1].OutputTokens != 40 {
+		t.Errorf("timeline[1] tokens = %d/%d, want 300/40", timeline[1].InputTokens, timeline[1].OutputTokens)
+	}
+}
+
+func TestGetTaskTimeline_UnknownTask(t *testing.T) {
+	engine, _ := setupTestEngine(t)
+
+	timeline, err := engine.GetTaskTimeline(context.Background(), "missing")
+	if err != nil {
+		t.Fatalf("GetTaskTimeline: %v", err)
+	}
+	if len(timeline) != 0 {
+		t.Errorf("len(timeline) = %d, want 0", len(timeline))
+	}
+}
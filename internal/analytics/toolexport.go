@@ -0,0 +1,119 @@
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+// ToolInvocationRow is a tool invocation joined with the flow that made it,
+// flattened for export.
+type ToolInvocationRow struct {
+	ID           string
+	FlowID       string
+	TaskID       *string // The invocation's task, else its flow's
+	ToolName     string
+	ToolType     *string
+	Timestamp    time.Time
+	Success      *bool
+	DurationMs   *int64
+	InputTokens  *int
+	OutputTokens *int
+	Cost         *float64
+	ErrorMessage *string
+	Model        *string
+	Provider     string
+}
+
+// ToolInvocationRowFilter selects the invocations ListToolInvocationRows returns.
+type ToolInvocationRowFilter struct {
+	ToolName *string
+	TaskID   *string
+	Start    time.Time
+	End      time.Time
+	Limit    int
+	Offset   int
+}
+
+// ListToolInvocationRows returns tool invocations between filter.Start and
+// filter.End joined with their flow's model, provider and task, oldest
+// first so rows added while an export pages through them land at the end.
+func (e *Engine) ListToolInvocationRows(ctx context.Context, filter ToolInvocationRowFilter) ([]*ToolInvocationRow, error) {
+	query := `
+		SELECT
+			ti.id, ti.flow_id, COALESCE(ti.task_id, f.task_id),
+			ti.tool_name, ti.tool_type, ti.timestamp,
+			ti.success, ti.duration_ms, ti.input_tokens, ti.output_tokens,
+			ti.cost, ti.error_message,
+			f.model, COALESCE(f.provider, '')
+		FROM tool_invocations ti
+		LEFT JOIN flows f ON f.id = ti.flow_id
+		WHERE ti.timestamp >= ? AND ti.timestamp <= ?`
+	args := []any{store.FormatTime(filter.Start), store.FormatTime(filter.End)}
+	if filter.ToolName != nil {
+		query += ` AND ti.tool_name = ?`
+		args = append(args, *filter.ToolName)
+	}
+	if filter.TaskID != nil {
+		query += ` AND COALESCE(ti.task_id, f.task_id) = ?`
+		args = append(args, *filter.TaskID)
+	}
+	query += ` ORDER BY ti.timestamp, ti.id LIMIT ? OFFSET ?`
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := e.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*ToolInvocationRow
+	for rows.Next() {
+		var r ToolInvocationRow
+		var timestamp string
+		var taskID, toolType, errorMessage, model sql.NullString
+		var success sql.NullBool
+		var durationMs sql.NullInt64
+		var inputTokens, outputTokens sql.NullInt64
+		var cost sql.NullFloat64
+		if err := rows.Scan(&r.ID, &r.FlowID, &taskID, &r.ToolName, &toolType, &timestamp,
+			&success, &durationMs, &inputTokens, &outputTokens, &cost, &errorMessage,
+			&model, &r.Provider); err != nil {
+			return nil, err
+		}
+		r.Timestamp, _ = time.Parse(time.RFC3339Nano, timestamp)
+		r.TaskID = nullStringPtr(taskID)
+		r.ToolType = nullStringPtr(toolType)
+		r.ErrorMessage = nullStringPtr(errorMessage)
+		r.Model = nullStringPtr(model)
+		if success.Valid {
+			r.Success = &success.Bool
+		}
+		if durationMs.Valid {
+			r.DurationMs = &durationMs.Int64
+		}
+		if inputTokens.Valid {
+			n := int(inputTokens.Int64)
+			r.InputTokens = &n
+		}
+		if outputTokens.Valid {
+			n := int(outputTokens.Int64)
+			r.OutputTokens = &n
+		}
+		if cost.Valid {
+			r.Cost = &cost.Float64
+		}
+		result = append(result, &r)
+	}
+
+	return result, rows.Err()
+}
+
+func nullStringPtr(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
@@ -0,0 +1,94 @@
+package analytics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+func TestListToolInvocationRows(t *testing.T) {
+	engine, s := setupTestEngine(t)
+	ctx := context.Background()
+
+	base := time.Now().Add(-time.Hour).UTC()
+	str := func(v string) *string { return &v }
+	flows := []*store.Flow{
+		{ID: "flow-a", Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages", Timestamp: base,
+			FlowIntegrity: "complete", Provider: "anthropic", Model: str("claude-sonnet-4"), TaskID: str("task-a")},
+		{ID: "flow-b", Host: "api.openai.com", Method: "POST", Path: "/v1/chat/completions", Timestamp: base,
+			FlowIntegrity: "complete", Provider: "openai", Model: str("gpt-4o")},
+	}
+	for _, f := range flows {
+		if err := s.SaveFlow(ctx, f); err != nil {
+			t.Fatalf("SaveFlow(%s): %v", f.ID, err)
+		}
+	}
+
+	ok, cost, duration := true, 0.25, int64(120)
+	invocations := []*store.ToolInvocation{
+		{ID: "inv-1", FlowID: "flow-a", ToolName: "Bash", Timestamp: base.Add(time.Second),
+			Success: &ok, DurationMs: &duration, Cost: &cost},
+		{ID: "inv-2", FlowID: "flow-b", TaskID: str("task-b"), ToolName: "Read", Timestamp: base.Add(2 * time.Second)},
+		{ID: "inv-3", FlowID: "flow-a", ToolName: "Read", Timestamp: base.Add(3 * time.Second)},
+	}
+	for _, inv := range invocations {
+		if err := s.SaveToolInvocation(ctx, inv); err != nil {
+			t.Fatalf("SaveToolInvocation(%s): %v", inv.ID, err)
+		}
+	}
+
+	filter := ToolInvocationRowFilter{Start: base.Add(-time.Minute), End: time.Now(), Limit: 10}
+	rows, err := engine.ListToolInvocationRows(ctx, filter)
+	if err != nil {
+		t.Fatalf("ListToolInvocationRows: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("len(rows) = %d, want one per invocation", len(rows))
+	}
+
+	// Oldest first, each joined with its own flow
+	first := rows[0]
+	if first.ID != "inv-1" || first.Model == nil || *first.Model != "claude-sonnet-4" || first.Provider != "anthropic" {
+		t.Errorf("rows[0] = %+v, want inv-1 on claude-sonnet-4", first)
+	}
+	if first.TaskID == nil || *first.TaskID != "task-a" {
+		t.Errorf("rows[0].TaskID = %v, want the flow's task-a", first.TaskID)
+	}
+	if first.Success == nil || !*first.Success || first.DurationMs == nil || *first.DurationMs != 120 ||
+		first.Cost == nil || *first.Cost != 0.25 {
+		t.Errorf("rows[0] success/duration/cost = %v/%v/%v", first.Success, first.DurationMs, first.Cost)
+	}
+	second := rows[1]
+	if second.ID != "inv-2" || second.Model == nil || *second.Model != "gpt-4o" || second.Provider != "openai" {
+		t.Errorf("rows[1] = %+v, want inv-2 on gpt-4o", second)
+	}
+	if second.TaskID == nil || *second.TaskID != "task-b" {
+		t.Errorf("rows[1].TaskID = %v, want its own task-b", second.TaskID)
+	}
+	if second.Success != nil || second.DurationMs != nil || second.Cost != nil {
+		t.Errorf("rows[1] should have no result, got %v/%v/%v", second.Success, second.DurationMs, second.Cost)
+	}
+
+	// Filters
+	filter.ToolName = str("Read")
+	filter.TaskID = str("task-a")
+	rows, err = engine.ListToolInvocationRows(ctx, filter)
+	if err != nil {
+		t.Fatalf("ListToolInvocationRows(filtered): %v", err)
+	}
+	if len(rows) != 1 || rows[0].ID != "inv-3" {
+		t.Errorf("filtered rows = %v, want only inv-3", rows)
+	}
+
+	// Paging
+	rows, err = engine.ListToolInvocationRows(ctx, ToolInvocationRowFilter{
+		Start: base.Add(-time.Minute), End: time.Now(), Limit: 2, Offset: 2})
+	if err != nil {
+		t.Fatalf("ListToolInvocationRows(page 2): %v", err)
+	}
+	if len(rows) != 1 || rows[0].ID != "inv-3" {
+		t.Errorf("page 2 = %v, want only inv-3", rows)
+	}
+}
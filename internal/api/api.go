@@ -2,21 +2,33 @@
 package api
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
 	"crypto/subtle"
+	"encoding/hex"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"reflect"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/HakAl/langley/internal/analytics"
 	"github.com/HakAl/langley/internal/config"
 	"github.com/HakAl/langley/internal/pricing"
+	"github.com/HakAl/langley/internal/redact"
 	"github.com/HakAl/langley/internal/store"
+	langleytls "github.com/HakAl/langley/internal/tls"
 )
 
 // Server is the REST API server.
@@ -25,14 +37,46 @@ type Server struct {
 	cfgPath       string // Path to config file for reload
 	store         store.Store
 	analytics     *analytics.Engine
+	flowStats     flowStatsSource // analytics, or the slower store-backed fallback when there's no SQL database
 	pricingSource *pricing.Source
+	capture       CaptureSource    // Flow capture health; nil leaves it out of /api/health
+	upstream      UpstreamSource   // Upstream concurrency limit; nil leaves it out of /api/health
+	models        ModelLimitSource // Per-model rate limits; nil leaves them out of /api/health
+	throughput    ThroughputSource // Proxy forwarding rate and latency; nil leaves them out of /api/health
+	ca            *langleytls.CA // Served at /api/ca.crt; nil returns 404
+	events        EventSource // Live SSE events for /events/stream; nil replays stored ones only
+	notes         NotesBroadcaster // Tells dashboards about notes changes; nil disables
+	redactor      *atomic.Pointer[redact.Redactor] // Live redactor for redaction.scrub_on_read; shared with workspaces
 	logger        *slog.Logger
 	mux           *http.ServeMux
 	startTime     time.Time
-	onReload      func(newToken string) // Callback when token changes
+	onReload      func(*ReloadResult) // Callback after a config reload
+	reloadMu      sync.Mutex          // Serializes Reload
 	rateLimiter   *RateLimiter          // Rate limiter for API requests
+
+	resetMu      sync.Mutex // Guards the pending factory-reset nonce
+	resetNonce   string
+	resetExpires time.Time
+
+	workspaceDir string // Where workspace databases live; empty disables ?workspace
+	workspace    string // Workspace of this server's store
+	workspacesMu sync.Mutex
+	workspaces   map[string]*Server // Other workspaces, opened on demand
+}
+
+// flowStatsSource answers the flow totals and cost breakdowns. An
+// *analytics.Engine does it in SQL; *analytics.FlowStats lists flows from
+// any store.
+type flowStatsSource interface {
+	GetOverallStats(ctx context.Context, start, end time.Time) (*analytics.OverallStats, error)
+	GetCostByDay(ctx context.Context, start, end time.Time) ([]*analytics.CostByPeriod, error)
+	GetCostByHour(ctx context.Context, start, end time.Time) ([]*analytics.CostByPeriod, error)
+	GetCostByModel(ctx context.Context, start, end time.Time) ([]*analytics.CostByPeriod, error)
 }
 
+// resetNonceTTL is how long a factory-reset confirmation token stays valid.
+const resetNonceTTL = 2 * time.Minute
+
 // ServerOption configures the API server.
 type ServerOption func(*Server)
 
@@ -43,9 +87,10 @@ func WithConfigPath(path string) ServerOption {
 	}
 }
 
-// WithOnReload sets a callback to be called when config is reloaded.
-// The callback receives the new auth token.
-func WithOnReload(fn func(newToken string)) ServerOption {
+// WithOnReload sets a callback to be called when config is reloaded or the
+// settings API changes it. The callback receives what changed and the
+// rebuilt redactor.
+func WithOnReload(fn func(*ReloadResult)) ServerOption {
 	return func(s *Server) {
 		s.onReload = fn
 	}
@@ -58,6 +103,79 @@ func WithPricingSource(source *pricing.Source) ServerOption {
 	}
 }
 
+// CaptureSource reports recent flow capture outcomes.
+// proxy.CaptureMonitor implements it.
+type CaptureSource interface {
+	Health() store.CaptureHealth
+}
+
+// WithCaptureMonitor reports the proxy's flow capture health in /api/health.
+func WithCaptureMonitor(m CaptureSource) ServerOption {
+	return func(s *Server) {
+		s.capture = m
+	}
+}
+
+// UpstreamSource reports the upstream concurrency limit, nil if there is
+// none. proxy.UpstreamLimiter implements it.
+type UpstreamSource interface {
+	Stats() *analytics.UpstreamStats
+}
+
+// WithUpstreamLimiter reports the proxy's upstream concurrency limit in /api/health.
+func WithUpstreamLimiter(l UpstreamSource) ServerOption {
+	return func(s *Server) {
+		s.upstream = l
+	}
+}
+
+// ModelLimitSource reports the per-model rate limits.
+// proxy.ModelLimiter implements it.
+type ModelLimitSource interface {
+	Stats() []analytics.ModelLimitStats
+}
+
+// WithModelLimiter reports the proxy's per-model rate limits in /api/health.
+func WithModelLimiter(l ModelLimitSource) ServerOption {
+	return func(s *Server) {
+		s.models = l
+	}
+}
+
+// ThroughputSource reports how much the proxy is forwarding and how fast.
+// proxy.MITMProxy implements it.
+type ThroughputSource interface {
+	Throughput() analytics.ThroughputStats
+}
+
+// WithThroughputSource reports the proxy's in-flight requests and
+// forwarding latency in /api/health.
+func WithThroughputSource(src ThroughputSource) ServerOption {
+	return func(s *Server) {
+		s.throughput = src
+	}
+}
+
+// WithCA serves the proxy's CA certificate at /api/ca.crt for client setup.
+func WithCA(ca *langleytls.CA) ServerOption {
+	return func(s *Server) {
+		s.ca = ca
+	}
+}
+
+// NotesBroadcaster tells connected dashboards that a flow's notes changed.
+// ws.Hub implements it.
+type NotesBroadcaster interface {
+	BroadcastFlowNotes(flowID string, notes *string)
+}
+
+// WithNotesBroadcaster broadcasts notes set through the API.
+func WithNotesBroadcaster(b NotesBroadcaster) ServerOption {
+	return func(s *Server) {
+		s.notes = b
+	}
+}
+
 // NewServer creates a new API server.
 func NewServer(cfg *config.Config, dataStore store.Store, logger *slog.Logger, opts ...ServerOption) *Server {
 	if logger == nil {
@@ -71,6 +189,12 @@ func NewServer(cfg *config.Config, dataStore store.Store, logger *slog.Logger, o
 		mux:         http.NewServeMux(),
 		startTime:   time.Now(),
 		rateLimiter: NewRateLimiter(20, 100), // 20 req/sec sustained, 100 burst (2.2.9)
+		redactor:    new(atomic.Pointer[redact.Redactor]),
+	}
+	if r, err := redact.New(&cfg.Redaction); err != nil {
+		logger.Error("failed to create redactor, scrubbed bodies will be omitted", "error", err)
+	} else {
+		s.redactor.Store(r)
 	}
 
 	// Apply options
@@ -84,38 +208,73 @@ func NewServer(cfg *config.Config, dataStore store.Store, logger *slog.Logger, o
 		if s.pricingSource != nil {
 			s.analytics.SetPricingSource(s.pricingSource)
 		}
+		s.flowStats = s.analytics
+	} else {
+		logger.Info("store has no SQL database; stats and cost breakdowns use the slower fallback, other analytics are unavailable")
+		s.flowStats = analytics.NewFlowStats(dataStore)
 	}
 
 	// Register routes
 	s.mux.HandleFunc("GET /api/flows", s.authMiddleware(s.listFlows))
 	s.mux.HandleFunc("GET /api/flows/count", s.authMiddleware(s.countFlows))
+	s.mux.HandleFunc("GET /api/facets", s.authMiddleware(s.getFacets))
 	s.mux.HandleFunc("GET /api/flows/export", s.authMiddleware(s.exportFlows))
+	s.mux.HandleFunc("POST /api/flows/export/s3", s.authMiddleware(s.requireAdmin(s.exportFlowsS3)))
+	s.mux.HandleFunc("GET /api/flows/expensive", s.authMiddleware(s.listExpensiveFlows))
 	s.mux.HandleFunc("GET /api/flows/{id}", s.authMiddleware(s.getFlow))
+	s.mux.HandleFunc("GET /api/flows/{id}/request.body", s.authMiddleware(s.getRequestBody))
+	s.mux.HandleFunc("GET /api/flows/{id}/response.body", s.authMiddleware(s.getResponseBody))
+	s.mux.HandleFunc("GET /api/flows/{id}/curl", s.authMiddleware(s.getFlowCurl))
 	s.mux.HandleFunc("GET /api/flows/{id}/events", s.authMiddleware(s.getFlowEvents))
+	s.mux.HandleFunc("GET /api/flows/{id}/events/stream", s.authMiddleware(s.streamFlowEvents))
 	s.mux.HandleFunc("GET /api/flows/{id}/anomalies", s.authMiddleware(s.getFlowAnomalies))
+	s.mux.HandleFunc("GET /api/flows/{id}/tags", s.authMiddleware(s.listFlowTags))
+	s.mux.HandleFunc("POST /api/flows/{id}/tags", s.authMiddleware(s.requireAdmin(s.addFlowTag)))
+	s.mux.HandleFunc("DELETE /api/flows/{id}/tags", s.authMiddleware(s.requireAdmin(s.deleteFlowTag)))
+	s.mux.HandleFunc("PUT /api/flows/{id}/notes", s.authMiddleware(s.requireAdmin(s.setFlowNotes)))
 	s.mux.HandleFunc("GET /api/stats", s.authMiddleware(s.getStats))
+	s.mux.HandleFunc("GET /api/tunnels", s.authMiddleware(s.listTunnels))
 	s.mux.HandleFunc("GET /api/analytics/tasks", s.authMiddleware(s.getTaskAnalytics))
 	s.mux.HandleFunc("GET /api/analytics/tasks/{id}", s.authMiddleware(s.getTaskSummary))
+	s.mux.HandleFunc("GET /api/analytics/tasks/{id}/timeline", s.authMiddleware(s.getTaskTimeline))
+	s.mux.HandleFunc("GET /api/tasks/{id}/retention", s.authMiddleware(s.getTaskRetention))
+	s.mux.HandleFunc("PUT /api/tasks/{id}/retention", s.authMiddleware(s.requireAdmin(s.setTaskRetention)))
 	s.mux.HandleFunc("GET /api/analytics/tools", s.authMiddleware(s.getToolAnalytics))
 	s.mux.HandleFunc("GET /api/analytics/tool-invocations/{id}", s.authMiddleware(s.getToolInvocation))
+	s.mux.HandleFunc("GET /api/analytics/tools/export", s.authMiddleware(s.exportToolInvocations))
 	s.mux.HandleFunc("GET /api/analytics/tools/{name}/invocations", s.authMiddleware(s.listToolInvocations))
 	s.mux.HandleFunc("GET /api/analytics/cost/daily", s.authMiddleware(s.getCostByDay))
+	s.mux.HandleFunc("GET /api/analytics/cost/hourly", s.authMiddleware(s.getCostByHour))
 	s.mux.HandleFunc("GET /api/analytics/cost/model", s.authMiddleware(s.getCostByModel))
+	s.mux.HandleFunc("GET /api/analytics/cost/reconcile", s.authMiddleware(s.getCostReconciliation))
+	s.mux.HandleFunc("GET /api/analytics/quota", s.authMiddleware(s.getQuotaTimeline))
+	s.mux.HandleFunc("GET /api/analytics/cache", s.authMiddleware(s.getCacheEfficiency))
+	s.mux.HandleFunc("GET /api/analytics/cache-breakpoints", s.authMiddleware(s.getCacheBreakpointStats))
+	s.mux.HandleFunc("GET /api/analytics/duplicates", s.authMiddleware(s.getDuplicateRequests))
+	s.mux.HandleFunc("GET /api/analytics/errors", s.authMiddleware(s.getErrorStats))
+	s.mux.HandleFunc("GET /api/analytics/bandwidth", s.authMiddleware(s.getBandwidth))
 	s.mux.HandleFunc("GET /api/analytics/anomalies", s.authMiddleware(s.getAnomalies))
 	s.mux.HandleFunc("GET /api/health", s.healthCheck)
-	s.mux.HandleFunc("POST /api/checkpoint", s.authMiddleware(s.checkpoint))
-	s.mux.HandleFunc("POST /api/admin/reload", s.authMiddleware(s.adminReload))
+	s.mux.HandleFunc("GET /api/ca.crt", s.getCACert)
+	s.mux.HandleFunc("POST /api/checkpoint", s.authMiddleware(s.requireAdmin(s.checkpoint)))
+	s.mux.HandleFunc("POST /api/admin/reload", s.authMiddleware(s.requireAdmin(s.requireLocalAdmin(s.adminReload))))
+	s.mux.HandleFunc("POST /api/admin/vacuum", s.authMiddleware(s.requireAdmin(s.requireLocalAdmin(s.adminVacuum))))
+	s.mux.HandleFunc("GET /api/admin/retention/preview", s.authMiddleware(s.requireAdmin(s.requireLocalAdmin(s.adminRetentionPreview))))
+	s.mux.HandleFunc("GET /api/admin/reset/confirm", s.authMiddleware(s.requireAdmin(s.requireLocalAdmin(s.adminResetConfirm))))
+	s.mux.HandleFunc("POST /api/admin/reset", s.authMiddleware(s.requireAdmin(s.requireLocalAdmin(s.adminReset))))
+	s.mux.HandleFunc("PUT /api/pricing/{provider}/{model_pattern...}", s.authMiddleware(s.requireAdmin(s.upsertPricing)))
 	s.mux.HandleFunc("GET /api/settings", s.authMiddleware(s.getSettings))
-	s.mux.HandleFunc("PUT /api/settings", s.authMiddleware(s.updateSettings))
+	s.mux.HandleFunc("PUT /api/settings", s.authMiddleware(s.requireAdmin(s.updateSettings)))
+	s.mux.HandleFunc("PATCH /api/settings", s.authMiddleware(s.requireAdmin(s.updateSettings)))
 
 	return s
 }
 
 // Handler returns the HTTP handler for the API.
-// Applies middleware chain: CORS -> Rate Limit -> routes
+// Applies middleware chain: CORS -> Rate Limit -> workspace -> routes
 func (s *Server) Handler() http.Handler {
-	// Chain: CORS -> Rate Limit -> actual handlers
-	return s.corsMiddleware(s.rateLimiter.Middleware(s.mux))
+	// Chain: CORS -> Rate Limit -> workspace -> actual handlers
+	return s.corsMiddleware(s.rateLimiter.Middleware(s.routeWorkspace(s.mux)))
 }
 
 // sessionCookieName is the HTTP-only cookie used for browser authentication.
@@ -129,12 +288,28 @@ func isLocalhostOrigin(origin string) bool {
 		strings.HasPrefix(origin, "https://127.0.0.1")
 }
 
+// isAllowedOrigin reports whether a browser origin is trusted: localhost, or
+// one of api.cors_origins.
+func (s *Server) isAllowedOrigin(origin string) bool {
+	return isLocalhostOrigin(origin) || s.cfg.API.AllowsOrigin(origin)
+}
+
 // authMiddleware wraps a handler with authentication.
 //
 // Authentication modes (checked in order):
 // 1. Session cookie - browser sends automatically after first request
 // 2. Authorization header - for CLI/automation (curl, scripts)
 // 3. Localhost Origin - auto-sets cookie for browser's first request
+// 4. Same-origin Sec-Fetch-Site - auto-sets cookie when the browser sends no Origin
+//
+// The cookie and header accept auth.token (admin scope) or any of
+// auth.tokens. The token's scope is attached to the request context;
+// handlers wrapped in requireAdmin reject read-scoped tokens with 403.
+// The browser auto-cookie carries the primary token, so the dashboard
+// always has admin scope. Modes 3 and 4 only apply to connections from
+// this machine, since any client can set those headers. Origins listed in
+// api.cors_origins get CORS headers but no cookie; they authenticate with
+// a token like any other client.
 //
 // SECURITY: Defense in depth - Origin check + cookie + HttpOnly + SameSite=Strict
 func (s *Server) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
@@ -143,81 +318,97 @@ func (s *Server) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		// URL tokens are logged by proxies/browsers - always reject regardless of other auth
 		if r.URL.Query().Get("token") != "" {
 			s.logger.Warn("rejected token in URL", "path", r.URL.Path, "remote", r.RemoteAddr)
-			http.Error(w, "Token in URL is not allowed. Use Authorization header instead.", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, errCodeTokenInURL, "Token in URL is not allowed. Use Authorization header instead.")
 			return
 		}
 
 		// 1. Check session cookie first (works for same-origin browser requests)
 		cookie, err := r.Cookie(sessionCookieName)
-		if err == nil && subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(s.cfg.Auth.Token)) == 1 {
-			next(w, r)
-			return
+		if err == nil {
+			if scope, ok := s.cfg.Auth.Authenticate(cookie.Value); ok {
+				next(w, withScope(r, scope))
+				return
+			}
 		}
 
 		// 2. Check Authorization header (for CLI/automation)
 		auth := r.Header.Get("Authorization")
-		expected := "Bearer " + s.cfg.Auth.Token
-		if subtle.ConstantTimeCompare([]byte(auth), []byte(expected)) == 1 {
-			next(w, r)
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			if scope, ok := s.cfg.Auth.Authenticate(token); ok {
+				next(w, withScope(r, scope))
+				return
+			}
+		}
+
+		// Headers alone don't authenticate a remote client
+		origin := r.Header.Get("Origin")
+		secFetchSite := r.Header.Get("Sec-Fetch-Site")
+		if !isLocalRequest(r) {
+			s.logger.Debug("auth failed", "has_cookie", err == nil, "has_auth", auth != "", "origin", origin, "remote", r.RemoteAddr)
+			writeError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
 			return
 		}
 
 		// 3. Check if this is a browser request from localhost (auto-set cookie)
-		origin := r.Header.Get("Origin")
 		if origin != "" {
-			if !isLocalhostOrigin(origin) {
+			if !s.isAllowedOrigin(origin) {
 				s.logger.Warn("rejected non-localhost origin", "origin", origin, "path", r.URL.Path)
-				http.Error(w, "Forbidden: non-localhost origin", http.StatusForbidden)
+				writeError(w, http.StatusForbidden, errCodeForbidden, "Forbidden: non-localhost origin")
+				return
+			}
+			if !isLocalhostOrigin(origin) {
+				s.logger.Debug("auth failed", "has_cookie", err == nil, "has_auth", auth != "", "origin", origin)
+				writeError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
 				return
 			}
 			// Localhost origin - set cookie and authenticate
 			http.SetCookie(w, &http.Cookie{
 				Name:     sessionCookieName,
-				Value:    s.cfg.Auth.Token,
+				Value:    s.cfg.Auth.PrimaryToken(),
 				Path:     "/",
 				HttpOnly: true,
 				Secure:   false,
 				SameSite: http.SameSiteLaxMode, // Lax allows same-site navigation
 			})
 			s.logger.Debug("set session cookie for localhost origin", "remote", r.RemoteAddr)
-			next(w, r)
+			next(w, withScope(r, config.ScopeAdmin))
 			return
 		}
 
 		// 4. Check for Sec-Fetch-Site header (modern browsers send this even without Origin)
-		secFetchSite := r.Header.Get("Sec-Fetch-Site")
 		if secFetchSite == "same-origin" || secFetchSite == "same-site" {
 			// Same-origin browser request without cookie - set one
 			http.SetCookie(w, &http.Cookie{
 				Name:     sessionCookieName,
-				Value:    s.cfg.Auth.Token,
+				Value:    s.cfg.Auth.PrimaryToken(),
 				Path:     "/",
 				HttpOnly: true,
 				Secure:   false,
 				SameSite: http.SameSiteLaxMode,
 			})
 			s.logger.Debug("set session cookie for same-origin request", "remote", r.RemoteAddr)
-			next(w, r)
+			next(w, withScope(r, config.ScopeAdmin))
 			return
 		}
 
 		s.logger.Debug("auth failed", "has_cookie", err == nil, "has_auth", auth != "", "origin", origin, "sec_fetch", secFetchSite)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		writeError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
 	}
 }
 
-// corsMiddleware adds CORS headers for local development.
+// corsMiddleware adds CORS headers for localhost and api.cors_origins.
 func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
 
-		// Only allow localhost origins
-		if origin != "" && isLocalhostOrigin(origin) {
+		// Only allow localhost and configured origins
+		if origin != "" && s.isAllowedOrigin(origin) {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, OPTIONS")
 			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
 			w.Header().Set("Access-Control-Allow-Credentials", "true") // Allow cookies
 			w.Header().Set("Access-Control-Max-Age", "86400")
+			w.Header().Set("Access-Control-Expose-Headers", "X-Total-Count, X-Has-More, X-Next-Offset, X-Prev-Offset, X-Body-Truncated, Content-Disposition")
 		}
 
 		if r.Method == "OPTIONS" {
@@ -259,6 +450,17 @@ func (s *Server) listFlows(w http.ResponseWriter, r *http.Request) {
 	if v := r.URL.Query().Get("model"); v != "" {
 		filter.Model = &v
 	}
+	if v := r.URL.Query().Get("stop_reason"); v != "" {
+		filter.StopReason = &v
+	}
+	if v := r.URL.Query().Get("tag"); v != "" {
+		filter.Tag = &v
+	}
+	if v := r.URL.Query().Get("provider"); v != "" {
+		filter.Provider = &v
+	}
+	filter.StatusMin = parseStatusParam(r.URL.Query().Get("status_min"))
+	filter.StatusMax = parseStatusParam(r.URL.Query().Get("status_max"))
 	if v := r.URL.Query().Get("start_time"); v != "" {
 		if t, err := time.Parse(time.RFC3339, v); err == nil {
 			filter.StartTime = &t
@@ -273,7 +475,15 @@ func (s *Server) listFlows(w http.ResponseWriter, r *http.Request) {
 	flows, err := s.store.ListFlows(ctx, filter)
 	if err != nil {
 		s.logger.Error("failed to list flows", "error", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+
+	// Total for the same filter, for "showing 50 of 1240"
+	total, err := s.store.CountFlows(ctx, filter)
+	if err != nil {
+		s.logger.Error("failed to count flows", "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
 		return
 	}
 
@@ -283,6 +493,97 @@ func (s *Server) listFlows(w http.ResponseWriter, r *http.Request) {
 		response[i] = toFlowSummary(f)
 	}
 
+	page := FlowListResponse{
+		Items:  response,
+		Total:  total,
+		Limit:  filter.Limit,
+		Offset: filter.Offset,
+	}
+	if next := filter.Offset + len(flows); next < total {
+		page.NextOffset = &next
+	}
+	if filter.Offset > 0 {
+		prev := filter.Offset - filter.Limit
+		if prev < 0 {
+			prev = 0
+		}
+		page.PrevOffset = &prev
+	}
+
+	// Pagination headers keep the bare-array body working for existing callers
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	w.Header().Set("X-Has-More", strconv.FormatBool(page.NextOffset != nil))
+	if page.NextOffset != nil {
+		w.Header().Set("X-Next-Offset", strconv.Itoa(*page.NextOffset))
+	}
+	if page.PrevOffset != nil {
+		w.Header().Set("X-Prev-Offset", strconv.Itoa(*page.PrevOffset))
+	}
+
+	if r.URL.Query().Get("envelope") == "true" {
+		s.writeJSON(w, page)
+		return
+	}
+	s.writeJSON(w, response)
+}
+
+// parseStatusParam parses a status_min/status_max query value. Anything
+// that isn't an HTTP status code is ignored, like other malformed filters.
+func parseStatusParam(v string) *int {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 100 || n > 599 {
+		return nil
+	}
+	return &n
+}
+
+// listExpensiveFlows returns the N most expensive flows in a time range,
+// for cost audits. Flows without a computed cost are excluded.
+func (s *Server) listExpensiveFlows(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	start, end := s.parseTimeRange(r)
+	filter := store.FlowFilter{
+		StartTime:  &start,
+		EndTime:    &end,
+		SortByCost: true,
+		Limit:      10,
+	}
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 100 {
+			filter.Limit = n
+		}
+	}
+	if v := r.URL.Query().Get("host"); v != "" {
+		filter.Host = &v
+	}
+	if v := r.URL.Query().Get("task_id"); v != "" {
+		filter.TaskID = &v
+	}
+	if v := r.URL.Query().Get("model"); v != "" {
+		filter.Model = &v
+	}
+	if v := r.URL.Query().Get("stop_reason"); v != "" {
+		filter.StopReason = &v
+	}
+	if v := r.URL.Query().Get("tag"); v != "" {
+		filter.Tag = &v
+	}
+
+	flows, err := s.store.ListFlows(ctx, filter)
+	if err != nil {
+		s.logger.Error("failed to list expensive flows", "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+
+	response := make([]FlowSummary, len(flows))
+	for i, f := range flows {
+		response[i] = toFlowSummary(f)
+	}
+
 	s.writeJSON(w, response)
 }
 
@@ -303,6 +604,17 @@ func (s *Server) countFlows(w http.ResponseWriter, r *http.Request) {
 	if v := r.URL.Query().Get("model"); v != "" {
 		filter.Model = &v
 	}
+	if v := r.URL.Query().Get("stop_reason"); v != "" {
+		filter.StopReason = &v
+	}
+	if v := r.URL.Query().Get("tag"); v != "" {
+		filter.Tag = &v
+	}
+	if v := r.URL.Query().Get("provider"); v != "" {
+		filter.Provider = &v
+	}
+	filter.StatusMin = parseStatusParam(r.URL.Query().Get("status_min"))
+	filter.StatusMax = parseStatusParam(r.URL.Query().Get("status_max"))
 	if v := r.URL.Query().Get("start_time"); v != "" {
 		if t, err := time.Parse(time.RFC3339, v); err == nil {
 			filter.StartTime = &t
@@ -317,7 +629,7 @@ func (s *Server) countFlows(w http.ResponseWriter, r *http.Request) {
 	count, err := s.store.CountFlows(ctx, filter)
 	if err != nil {
 		s.logger.Error("failed to count flows", "error", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
 		return
 	}
 
@@ -330,30 +642,13 @@ func (s *Server) exportFlows(w http.ResponseWriter, r *http.Request) {
 	exportCfg := ParseExportConfig(r)
 
 	// Parse filters (same as listFlows)
-	filter := store.FlowFilter{
-		Limit:  100, // batch size for streaming
-		Offset: 0,
-	}
-
-	if v := r.URL.Query().Get("host"); v != "" {
-		filter.Host = &v
-	}
-	if v := r.URL.Query().Get("task_id"); v != "" {
-		filter.TaskID = &v
-	}
-	if v := r.URL.Query().Get("model"); v != "" {
-		filter.Model = &v
-	}
-	if v := r.URL.Query().Get("start_time"); v != "" {
-		if t, err := time.Parse(time.RFC3339, v); err == nil {
-			filter.StartTime = &t
-		}
-	}
-	if v := r.URL.Query().Get("end_time"); v != "" {
-		if t, err := time.Parse(time.RFC3339, v); err == nil {
-			filter.EndTime = &t
-		}
+	filter := parseExportFilter(r)
+	after, err := s.parseExportCursor(r.Context(), r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+		return
 	}
+	filter.After = after
 
 	// Create exporter for requested format
 	exporter := NewExporter(exportCfg.Format)
@@ -363,10 +658,11 @@ func (s *Server) exportFlows(w http.ResponseWriter, r *http.Request) {
 	filename := fmt.Sprintf("flows-%s.%s", timestamp, exporter.FileExtension())
 	w.Header().Set("Content-Type", exporter.ContentType())
 	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Header().Set("Trailer", exportCursorTrailer)
 
 	flusher, ok := w.(http.Flusher)
 	if !ok {
-		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Streaming not supported")
 		return
 	}
 
@@ -376,55 +672,27 @@ func (s *Server) exportFlows(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rowCount := 0
-	truncatedBodies := 0
-	for {
-		// Check row limit
-		if exportCfg.MaxRows > 0 && rowCount >= exportCfg.MaxRows {
-			break
-		}
-
-		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
-		flows, err := s.store.ListFlows(ctx, filter)
-		cancel()
-
-		if err != nil {
-			s.logger.Error("export: failed to list flows", "error", err, "offset", filter.Offset)
-			break
-		}
-		if len(flows) == 0 {
-			break
-		}
-
-		for _, f := range flows {
-			if exportCfg.MaxRows > 0 && rowCount >= exportCfg.MaxRows {
-				break
-			}
-
-			if err := exporter.WriteFlow(w, f, exportCfg.IncludeBodies); err != nil {
-				s.logger.Error("export: failed to write flow", "error", err, "flow_id", f.ID)
-				return
-			}
-
-			// Track truncated bodies
-			if exportCfg.IncludeBodies && (f.RequestBodyTruncated || f.ResponseBodyTruncated) {
-				truncatedBodies++
-			}
-
-			// Flush for streaming formats
-			if exportCfg.Format == FormatNDJSON {
-				flusher.Flush()
-			}
-			rowCount++
-		}
-
-		filter.Offset += len(flows)
+	// Flush after each row for streaming formats
+	var flush func()
+	if exportCfg.Format == FormatNDJSON {
+		flush = flusher.Flush
+	}
+	rowCount, truncatedBodies, last, err := s.writeExportRows(r.Context(), w, exporter, filter, exportCfg, flush)
+	if errors.Is(err, errExportRead) {
+		// Headers are sent; end the export early at the last row written
+		s.logger.Error("export: ended early", "error", err, "rows", rowCount)
+	} else if err != nil {
+		s.logger.Error("export: failed to write flow", "error", err)
+		return
 	}
 
 	// Write footer
 	if err := exporter.WriteFooter(w, rowCount, truncatedBodies); err != nil {
 		s.logger.Error("export: failed to write footer", "error", err)
 	}
+	if last != nil {
+		w.Header().Set(exportCursorTrailer, encodeExportCursor(last))
+	}
 
 	// Set row count header (for NDJSON/CSV - JSON has it in body)
 	if exportCfg.Format != FormatJSON {
@@ -444,117 +712,358 @@ func (s *Server) getFlow(w http.ResponseWriter, r *http.Request) {
 
 	id := r.PathValue("id")
 	if id == "" {
-		http.Error(w, "Missing flow ID", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "Missing flow ID")
 		return
 	}
 
 	flow, err := s.store.GetFlow(ctx, id)
 	if err != nil {
 		s.logger.Error("failed to get flow", "id", id, "error", err)
-		http.Error(w, "Not found", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Not found")
 		return
 	}
 
+	s.scrubFlow(flow)
 	s.writeJSON(w, toFlowDetail(flow))
 }
 
-// getFlowEvents returns events for a flow.
-func (s *Server) getFlowEvents(w http.ResponseWriter, r *http.Request) {
+// getRequestBody downloads a flow's stored request body.
+func (s *Server) getRequestBody(w http.ResponseWriter, r *http.Request) {
+	s.writeFlowBody(w, r, "request")
+}
+
+// getResponseBody downloads a flow's stored response body.
+func (s *Server) getResponseBody(w http.ResponseWriter, r *http.Request) {
+	s.writeFlowBody(w, r, "response")
+}
+
+// writeFlowBody writes the stored request or response body of a flow as a
+// file download, with the Content-Type it was sent with. Bodies cut off at
+// max_body_size are marked with X-Body-Truncated.
+func (s *Server) writeFlowBody(w http.ResponseWriter, r *http.Request, which string) {
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
 	id := r.PathValue("id")
-	if id == "" {
-		http.Error(w, "Missing flow ID", http.StatusBadRequest)
+	flow, err := s.store.GetFlow(ctx, id)
+	if err != nil {
+		s.logger.Error("failed to get flow", "id", id, "error", err)
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Not found")
 		return
 	}
+	s.scrubFlow(flow)
 
-	events, err := s.store.GetEventsByFlow(ctx, id)
-	if err != nil {
-		s.logger.Error("failed to get events", "flow_id", id, "error", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+	body, truncated, headers := flow.RequestBody, flow.RequestBodyTruncated, flow.RequestHeaders
+	if which == "response" {
+		body, truncated, headers = flow.ResponseBody, flow.ResponseBodyTruncated, flow.ResponseHeaders
+	}
+	if body == nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "No body stored")
 		return
 	}
 
-	response := make([]EventResponse, len(events))
-	for i, e := range events {
-		response[i] = toEventResponse(e)
+	contentType := http.Header(headers).Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
 	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.body"`, flow.ID, which))
+	w.Header().Set("X-Content-Type-Options", "nosniff") // Captured bodies are untrusted
+	if truncated {
+		w.Header().Set("X-Body-Truncated", "true")
+	}
+	_, _ = io.WriteString(w, *body)
+}
 
-	s.writeJSON(w, response)
+// Tag limits keep labels short enough to show in the UI
+const (
+	maxTagKeyLen   = 64
+	maxTagValueLen = 256
+)
+
+// listFlowTags returns the tags on a flow.
+func (s *Server) listFlowTags(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	id := r.PathValue("id")
+	tags, err := s.store.ListFlowTags(ctx, id)
+	if err != nil {
+		s.logger.Error("failed to list flow tags", "id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+
+	s.writeJSON(w, toFlowTagResponses(tags))
 }
 
-// getStats returns aggregate statistics.
-func (s *Server) getStats(w http.ResponseWriter, r *http.Request) {
+// addFlowTag sets a tag on a flow and returns the flow's tags.
+// Setting an existing key replaces its value.
+func (s *Server) addFlowTag(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
-	// Parse time range (default: last 24 hours)
-	start, end := s.parseTimeRange(r)
+	id := r.PathValue("id")
 
-	if s.analytics == nil {
-		s.writeJSON(w, StatsResponse{Status: "analytics_unavailable", Timestamp: time.Now()})
+	var req FlowTagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidJSON, "Invalid JSON: "+err.Error())
+		return
+	}
+	req.Key = strings.TrimSpace(req.Key)
+	if req.Key == "" || len(req.Key) > maxTagKeyLen || strings.Contains(req.Key, "=") {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("key must be 1-%d characters and must not contain '='", maxTagKeyLen))
+		return
+	}
+	if len(req.Value) > maxTagValueLen {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("value must be at most %d characters", maxTagValueLen))
 		return
 	}
 
-	stats, err := s.analytics.GetOverallStats(ctx, start, end)
-	if err != nil {
-		s.logger.Error("failed to get stats", "error", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+	if _, err := s.store.GetFlow(ctx, id); err != nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Not found")
 		return
 	}
 
-	allTimeCount, err := s.store.CountFlows(ctx, store.FlowFilter{})
+	if err := s.store.AddFlowTag(ctx, id, req.Key, req.Value); err != nil {
+		s.logger.Error("failed to add flow tag", "id", id, "key", req.Key, "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+
+	tags, err := s.store.ListFlowTags(ctx, id)
 	if err != nil {
-		s.logger.Error("failed to count all-time flows", "error", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+		s.logger.Error("failed to list flow tags", "id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
 		return
 	}
 
-	s.writeJSON(w, OverallStatsResponse{
-		Status:           "ok",
-		Timestamp:        time.Now(),
-		TotalFlows:       stats.TotalFlows,
-		AllTimeFlows:     allTimeCount,
-		TotalCost:        stats.TotalCost,
-		TotalTokensIn:    stats.TotalTokensIn,
-		TotalTokensOut:   stats.TotalTokensOut,
-		TotalTasks:       stats.TotalTasks,
-		TotalToolCalls:   stats.TotalToolCalls,
-		AvgCostPerFlow:   stats.AvgCostPerFlow,
-		AvgTokensPerFlow: stats.AvgTokensPerFlow,
-		StartTime:        start,
-		EndTime:          end,
-	})
+	s.writeJSON(w, toFlowTagResponses(tags))
 }
 
-// getTaskAnalytics returns task-level analytics.
-func (s *Server) getTaskAnalytics(w http.ResponseWriter, r *http.Request) {
+// deleteFlowTag removes the tag given by the key query param from a flow.
+func (s *Server) deleteFlowTag(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
-	if s.analytics == nil {
-		http.Error(w, "Analytics unavailable", http.StatusServiceUnavailable)
+	id := r.PathValue("id")
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "Missing key")
 		return
 	}
 
-	start, end := s.parseTimeRange(r)
-	limit := 50
-	if v := r.URL.Query().Get("limit"); v != "" {
-		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 100 {
-			limit = n
-		}
+	if err := s.store.DeleteFlowTag(ctx, id, key); err != nil {
+		s.logger.Error("failed to delete flow tag", "id", id, "key", key, "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
 	}
 
-	summaries, err := s.analytics.ListTaskSummaries(ctx, start, end, limit)
-	if err != nil {
-		s.logger.Error("failed to get task summaries", "error", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// maxNotesLen caps flow notes, in bytes.
+const maxNotesLen = 10000
+
+// setFlowNotes sets a flow's notes and returns the flow. Null, empty or
+// blank notes clear them.
+func (s *Server) setFlowNotes(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	id := r.PathValue("id")
+
+	var req FlowNotesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidJSON, "Invalid JSON: "+err.Error())
+		return
+	}
+	if req.Notes != nil && strings.TrimSpace(*req.Notes) == "" {
+		req.Notes = nil
+	}
+	if req.Notes != nil && len(*req.Notes) > maxNotesLen {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("notes must be at most %d bytes", maxNotesLen))
 		return
 	}
 
-	response := make([]TaskSummaryResponse, len(summaries))
-	for i, ts := range summaries {
+	if err := s.store.SetFlowNotes(ctx, id, req.Notes); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, errCodeNotFound, "Not found")
+			return
+		}
+		s.logger.Error("failed to set flow notes", "id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+	if s.notes != nil {
+		s.notes.BroadcastFlowNotes(id, req.Notes)
+	}
+
+	flow, err := s.store.GetFlow(ctx, id)
+	if err != nil {
+		s.logger.Error("failed to get flow", "id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+	s.scrubFlow(flow)
+	s.writeJSON(w, toFlowDetail(flow))
+}
+
+// getFlowEvents returns events for a flow.
+func (s *Server) getFlowEvents(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	id := r.PathValue("id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "Missing flow ID")
+		return
+	}
+
+	events, err := s.store.GetEventsByFlow(ctx, id)
+	if err != nil {
+		s.logger.Error("failed to get events", "flow_id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+
+	response := make([]EventResponse, len(events))
+	for i, e := range events {
+		response[i] = toEventResponse(e)
+	}
+
+	s.writeJSON(w, response)
+}
+
+// listTunnels returns recent CONNECT tunnels, intercepted and passthrough.
+func (s *Server) listTunnels(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 1000 {
+			limit = n
+		}
+	}
+
+	tunnels, err := s.store.ListTunnels(ctx, limit)
+	if err != nil {
+		s.logger.Error("failed to list tunnels", "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+
+	response := make([]TunnelResponse, len(tunnels))
+	for i, t := range tunnels {
+		response[i] = TunnelResponse{
+			ID:         t.ID,
+			Host:       t.Host,
+			Mode:       t.Mode,
+			StartedAt:  t.StartedAt,
+			EndedAt:    t.EndedAt,
+			DurationMs: t.EndedAt.Sub(t.StartedAt).Milliseconds(),
+			BytesUp:    t.BytesUp,
+			BytesDown:  t.BytesDown,
+		}
+	}
+
+	s.writeJSON(w, response)
+}
+
+// getStats returns aggregate statistics.
+func (s *Server) getStats(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	// Parse time range (default: last 24 hours)
+	start, end := s.parseTimeRange(r)
+
+	s.markFallback(w)
+	stats, err := s.flowStats.GetOverallStats(ctx, start, end)
+	if err != nil {
+		s.logger.Error("failed to get stats", "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+
+	allTimeCount, err := s.store.CountFlows(ctx, store.FlowFilter{})
+	if err != nil {
+		s.logger.Error("failed to count all-time flows", "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+
+	s.writeJSON(w, OverallStatsResponse{
+		Status:           "ok",
+		Timestamp:        time.Now(),
+		TotalFlows:       stats.TotalFlows,
+		AllTimeFlows:     allTimeCount,
+		TotalCost:        stats.TotalCost,
+		TotalTokensIn:    stats.TotalTokensIn,
+		TotalTokensOut:   stats.TotalTokensOut,
+		TotalTasks:       stats.TotalTasks,
+		TotalToolCalls:   stats.TotalToolCalls,
+		AvgCostPerFlow:   stats.AvgCostPerFlow,
+		AvgTokensPerFlow: stats.AvgTokensPerFlow,
+		ByProvider:       toStatsFacetResponses(stats.ByProvider),
+		ByModel:          toStatsFacetResponses(stats.ByModel),
+		StartTime:        start,
+		EndTime:          end,
+		Fallback:         s.analytics == nil,
+	})
+}
+
+// markFallback sets X-Analytics-Fallback on responses computed by the
+// store-backed fallback rather than SQL, so clients know why they're slow.
+func (s *Server) markFallback(w http.ResponseWriter) {
+	if s.analytics == nil {
+		w.Header().Set("X-Analytics-Fallback", "true")
+	}
+}
+
+// toStatsFacetResponses converts analytics facets for the stats response.
+func toStatsFacetResponses(facets []*analytics.StatsFacet) []StatsFacetResponse {
+	out := make([]StatsFacetResponse, len(facets))
+	for i, f := range facets {
+		out[i] = StatsFacetResponse{
+			Name:           f.Name,
+			FlowCount:      f.FlowCount,
+			TotalCost:      f.TotalCost,
+			TotalTokensIn:  f.TotalTokensIn,
+			TotalTokensOut: f.TotalTokensOut,
+		}
+	}
+	return out
+}
+
+// getTaskAnalytics returns task-level analytics.
+func (s *Server) getTaskAnalytics(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if s.analytics == nil {
+		writeError(w, http.StatusServiceUnavailable, errCodeUnavailable, "Analytics unavailable")
+		return
+	}
+
+	start, end := s.parseTimeRange(r)
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 100 {
+			limit = n
+		}
+	}
+
+	summaries, err := s.analytics.ListTaskSummaries(ctx, start, end, limit)
+	if err != nil {
+		s.logger.Error("failed to get task summaries", "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+
+	response := make([]TaskSummaryResponse, len(summaries))
+	for i, ts := range summaries {
 		response[i] = TaskSummaryResponse{
 			TaskID:         ts.TaskID,
 			FlowCount:      ts.FlowCount,
@@ -578,20 +1087,20 @@ func (s *Server) getTaskSummary(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	if s.analytics == nil {
-		http.Error(w, "Analytics unavailable", http.StatusServiceUnavailable)
+		writeError(w, http.StatusServiceUnavailable, errCodeUnavailable, "Analytics unavailable")
 		return
 	}
 
 	taskID := r.PathValue("id")
 	if taskID == "" {
-		http.Error(w, "Missing task ID", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "Missing task ID")
 		return
 	}
 
 	summary, err := s.analytics.GetTaskSummary(ctx, taskID)
 	if err != nil {
 		s.logger.Error("failed to get task summary", "task_id", taskID, "error", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
 		return
 	}
 
@@ -609,13 +1118,102 @@ func (s *Server) getTaskSummary(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// maxTaskRetentionDays caps a task retention override at ten years.
+const maxTaskRetentionDays = 3650
+
+// getTaskRetention returns how long a task's flows are kept.
+func (s *Server) getTaskRetention(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	id := r.PathValue("id")
+	days, err := s.store.GetTaskRetention(ctx, id)
+	if err != nil {
+		s.logger.Error("failed to get task retention", "task_id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+	s.writeJSON(w, s.taskRetentionResponse(id, days))
+}
+
+// setTaskRetention sets or, with null or 0, removes a task's retention
+// override. The task doesn't need to have flows yet.
+func (s *Server) setTaskRetention(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	id := r.PathValue("id")
+
+	var req TaskRetentionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidJSON, "Invalid JSON: "+err.Error())
+		return
+	}
+	days := 0
+	if req.TTLDays != nil {
+		days = *req.TTLDays
+	}
+	if days < 0 || days > maxTaskRetentionDays {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest,
+			fmt.Sprintf("ttl_days must be between 0 and %d", maxTaskRetentionDays))
+		return
+	}
+
+	if err := s.store.SetTaskRetention(ctx, id, days); err != nil {
+		s.logger.Error("failed to set task retention", "task_id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+	s.writeJSON(w, s.taskRetentionResponse(id, days))
+}
+
+// taskRetentionResponse describes a task's override, falling back to the
+// global flows TTL when it has none.
+func (s *Server) taskRetentionResponse(taskID string, days int) TaskRetentionResponse {
+	if days > 0 {
+		return TaskRetentionResponse{TaskID: taskID, TTLDays: days, Override: true}
+	}
+	return TaskRetentionResponse{TaskID: taskID, TTLDays: s.cfg.Retention.Snapshot().FlowsTTLDays}
+}
+
+// getTaskTimeline returns a chronological timeline of a task's flows and tool invocations.
+func (s *Server) getTaskTimeline(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if s.analytics == nil {
+		writeError(w, http.StatusServiceUnavailable, errCodeUnavailable, "Analytics unavailable")
+		return
+	}
+
+	taskID := r.PathValue("id")
+	if taskID == "" {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "Missing task ID")
+		return
+	}
+
+	timeline, err := s.analytics.GetTaskTimeline(ctx, taskID)
+	if err != nil {
+		s.logger.Error("failed to get task timeline", "task_id", taskID, "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+
+	response := make([]TimelineEntryResponse, len(timeline))
+	for i, entry := range timeline {
+		response[i] = toTimelineEntryResponse(entry)
+	}
+
+	s.writeJSON(w, response)
+}
+
 // getToolAnalytics returns tool usage analytics.
 func (s *Server) getToolAnalytics(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
 	if s.analytics == nil {
-		http.Error(w, "Analytics unavailable", http.StatusServiceUnavailable)
+		writeError(w, http.StatusServiceUnavailable, errCodeUnavailable, "Analytics unavailable")
 		return
 	}
 
@@ -624,7 +1222,7 @@ func (s *Server) getToolAnalytics(w http.ResponseWriter, r *http.Request) {
 	stats, err := s.analytics.GetToolStats(ctx, start, end)
 	if err != nil {
 		s.logger.Error("failed to get tool stats", "error", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
 		return
 	}
 
@@ -638,6 +1236,9 @@ func (s *Server) getToolAnalytics(w http.ResponseWriter, r *http.Request) {
 			SuccessRate:     ts.SuccessRate,
 			TotalCost:       ts.TotalCost,
 			AvgDurationMs:   ts.AvgDurationMs,
+			P50DurationMs:   ts.P50DurationMs,
+			P95DurationMs:   ts.P95DurationMs,
+			P99DurationMs:   ts.P99DurationMs,
 			TotalTokensIn:   ts.TotalTokensIn,
 			TotalTokensOut:  ts.TotalTokensOut,
 		}
@@ -653,7 +1254,7 @@ func (s *Server) listToolInvocations(w http.ResponseWriter, r *http.Request) {
 
 	name := r.PathValue("name")
 	if name == "" {
-		http.Error(w, "Missing tool name", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "Missing tool name")
 		return
 	}
 
@@ -675,7 +1276,7 @@ func (s *Server) listToolInvocations(w http.ResponseWriter, r *http.Request) {
 	invocations, total, err := s.store.ListToolInvocations(ctx, name, start, end, limit, offset)
 	if err != nil {
 		s.logger.Error("failed to list tool invocations", "tool", name, "error", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
 		return
 	}
 
@@ -690,6 +1291,67 @@ func (s *Server) listToolInvocations(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// exportToolInvocations streams tool invocations, joined with their flow's
+// model, provider and task, in the format chosen like exportFlows.
+func (s *Server) exportToolInvocations(w http.ResponseWriter, r *http.Request) {
+	if s.analytics == nil {
+		writeError(w, http.StatusServiceUnavailable, errCodeUnavailable, "Analytics unavailable")
+		return
+	}
+
+	exportCfg := ParseExportConfig(r)
+	start, end := s.parseTimeRange(r)
+	filter := analytics.ToolInvocationRowFilter{
+		Start: start,
+		End:   end,
+		Limit: 100, // batch size for streaming
+	}
+	if v := r.URL.Query().Get("tool_name"); v != "" {
+		filter.ToolName = &v
+	}
+	if v := r.URL.Query().Get("task_id"); v != "" {
+		filter.TaskID = &v
+	}
+
+	exporter := NewToolInvocationExporter(exportCfg.Format)
+
+	timestamp := time.Now().UTC().Format("20060102-150405")
+	filename := fmt.Sprintf("tool-invocations-%s.%s", timestamp, exporter.FileExtension())
+	w.Header().Set("Content-Type", exporter.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Streaming not supported")
+		return
+	}
+
+	if err := exporter.WriteHeader(w); err != nil {
+		s.logger.Error("tool export: failed to write header", "error", err)
+		return
+	}
+
+	var flush func()
+	if exportCfg.Format == FormatNDJSON {
+		flush = flusher.Flush
+	}
+	rowCount, err := s.writeToolInvocationRows(r.Context(), w, exporter, filter, exportCfg, flush)
+	if err != nil {
+		s.logger.Error("tool export: failed to write tool invocation", "error", err)
+		return
+	}
+
+	if err := exporter.WriteFooter(w, rowCount, 0); err != nil {
+		s.logger.Error("tool export: failed to write footer", "error", err)
+	}
+
+	if exportCfg.Format != FormatJSON {
+		w.Header().Set("X-Export-Row-Count", fmt.Sprintf("%d", rowCount))
+	}
+
+	s.logger.Info("tool export complete", "format", exportCfg.Format, "row_count", rowCount)
+}
+
 // getToolInvocation returns a single tool invocation by ID.
 func (s *Server) getToolInvocation(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
@@ -697,80 +1359,399 @@ func (s *Server) getToolInvocation(w http.ResponseWriter, r *http.Request) {
 
 	id := r.PathValue("id")
 	if id == "" {
-		http.Error(w, "Missing invocation ID", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "Missing invocation ID")
 		return
 	}
 
 	inv, err := s.store.GetToolInvocation(ctx, id)
 	if err != nil {
-		s.logger.Error("failed to get tool invocation", "id", id, "error", err)
-		http.Error(w, "Not found", http.StatusNotFound)
+		s.logger.Error("failed to get tool invocation", "id", id, "error", err)
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Not found")
+		return
+	}
+
+	s.writeJSON(w, toToolInvocationResponse(inv))
+}
+
+// getCostByDay returns daily cost breakdown.
+func (s *Server) getCostByDay(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	start, end := s.parseTimeRange(r)
+
+	s.markFallback(w)
+	periods, err := s.flowStats.GetCostByDay(ctx, start, end)
+	if err != nil {
+		s.logger.Error("failed to get daily costs", "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+
+	response := make([]CostPeriodResponse, len(periods))
+	for i, p := range periods {
+		response[i] = CostPeriodResponse{
+			Period:         p.Period,
+			FlowCount:      p.FlowCount,
+			TotalCost:      p.TotalCost,
+			TotalTokensIn:  p.TotalTokensIn,
+			TotalTokensOut: p.TotalTokensOut,
+		}
+	}
+
+	s.writeJSON(w, response)
+}
+
+// getCostByHour returns hourly cost breakdown, keyed by RFC 3339 UTC hour.
+func (s *Server) getCostByHour(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	start, end := s.parseTimeRange(r)
+
+	s.markFallback(w)
+	periods, err := s.flowStats.GetCostByHour(ctx, start, end)
+	if err != nil {
+		s.logger.Error("failed to get hourly costs", "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+
+	response := make([]CostPeriodResponse, len(periods))
+	for i, p := range periods {
+		response[i] = CostPeriodResponse{
+			Period:         p.Period,
+			FlowCount:      p.FlowCount,
+			TotalCost:      p.TotalCost,
+			TotalTokensIn:  p.TotalTokensIn,
+			TotalTokensOut: p.TotalTokensOut,
+		}
+	}
+
+	s.writeJSON(w, response)
+}
+
+// getCostByModel returns cost breakdown by model.
+func (s *Server) getCostByModel(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	start, end := s.parseTimeRange(r)
+
+	s.markFallback(w)
+	models, err := s.flowStats.GetCostByModel(ctx, start, end)
+	if err != nil {
+		s.logger.Error("failed to get model costs", "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+
+	response := make([]CostPeriodResponse, len(models))
+	for i, m := range models {
+		response[i] = CostPeriodResponse{
+			Period:         m.Period, // Model name
+			FlowCount:      m.FlowCount,
+			TotalCost:      m.TotalCost,
+			TotalTokensIn:  m.TotalTokensIn,
+			TotalTokensOut: m.TotalTokensOut,
+		}
+	}
+
+	s.writeJSON(w, response)
+}
+
+// getCostReconciliation breaks estimated cost down by how flows were costed.
+func (s *Server) getCostReconciliation(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if s.analytics == nil {
+		writeError(w, http.StatusServiceUnavailable, errCodeUnavailable, "Analytics unavailable")
+		return
+	}
+
+	start, end := s.parseTimeRange(r)
+
+	rec, err := s.analytics.ReconcileCost(ctx, start, end)
+	if err != nil {
+		s.logger.Error("failed to reconcile cost", "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+
+	bucket := func(b analytics.CostBucket) CostBucketResponse {
+		return CostBucketResponse{
+			FlowCount:    b.FlowCount,
+			Cost:         b.Cost,
+			InputTokens:  b.InputTokens,
+			OutputTokens: b.OutputTokens,
+		}
+	}
+	s.writeJSON(w, CostReconciliationResponse{
+		Start:        start,
+		End:          end,
+		TotalFlows:   rec.TotalFlows,
+		TotalCost:    rec.TotalCost,
+		Exact:        bucket(rec.Exact),
+		Estimated:    bucket(rec.Estimated),
+		Uncosted:     bucket(rec.Uncosted),
+		MissingUsage: rec.MissingUsage,
+		UnknownModel: rec.UnknownModel,
+		Coverage:     rec.Coverage,
+	})
+}
+
+// getQuotaTimeline returns remaining rate-limit quota over time per provider.
+func (s *Server) getQuotaTimeline(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if s.analytics == nil {
+		writeError(w, http.StatusServiceUnavailable, errCodeUnavailable, "Analytics unavailable")
+		return
+	}
+
+	start, end := s.parseTimeRange(r)
+
+	bucket := r.URL.Query().Get("bucket")
+	if bucket == "" {
+		bucket = "hour"
+	}
+	if !analytics.ValidQuotaBucket(bucket) {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "bucket must be minute or hour")
+		return
+	}
+
+	points, err := s.analytics.GetQuotaTimeline(ctx, start, end, r.URL.Query().Get("provider"), bucket)
+	if err != nil {
+		s.logger.Error("failed to get quota timeline", "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+
+	response := make([]QuotaPointResponse, len(points))
+	for i, p := range points {
+		response[i] = QuotaPointResponse{
+			Provider:          p.Provider,
+			Period:            p.Period,
+			Samples:           p.Samples,
+			RequestsLimit:     p.RequestsLimit,
+			RequestsRemaining: p.RequestsRemaining,
+			TokensLimit:       p.TokensLimit,
+			TokensRemaining:   p.TokensRemaining,
+		}
+	}
+
+	s.writeJSON(w, response)
+}
+
+// getCacheBreakpointStats returns prompt-cache results grouped by the number
+// of cache_control breakpoints in the request.
+func (s *Server) getCacheBreakpointStats(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if s.analytics == nil {
+		writeError(w, http.StatusServiceUnavailable, errCodeUnavailable, "Analytics unavailable")
+		return
+	}
+
+	start, end := s.parseTimeRange(r)
+	stats, err := s.analytics.GetCacheBreakpointStats(ctx, start, end)
+	if err != nil {
+		s.logger.Error("failed to get cache breakpoint stats", "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+
+	response := make([]CacheBreakpointStatsResponse, len(stats))
+	for i, st := range stats {
+		response[i] = CacheBreakpointStatsResponse{
+			Breakpoints:         st.Breakpoints,
+			FlowCount:           st.FlowCount,
+			CacheHitFlows:       st.CacheHitFlows,
+			HitRate:             st.HitRate,
+			InputTokens:         st.InputTokens,
+			CacheReadTokens:     st.CacheReadTokens,
+			CacheCreationTokens: st.CacheCreationTokens,
+			CachedShare:         st.CachedShare,
+		}
+	}
+
+	s.writeJSON(w, response)
+}
+
+// getCacheEfficiency returns the prompt-cache hit ratio and estimated
+// savings, in total and by model.
+func (s *Server) getCacheEfficiency(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if s.analytics == nil {
+		writeError(w, http.StatusServiceUnavailable, errCodeUnavailable, "Analytics unavailable")
+		return
+	}
+
+	start, end := s.parseTimeRange(r)
+	eff, err := s.analytics.GetCacheEfficiency(ctx, start, end)
+	if err != nil {
+		s.logger.Error("failed to get cache efficiency", "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+
+	response := CacheEfficiencyResponse{
+		CacheUsageResponse: toCacheUsageResponse(eff.CacheUsage),
+		ByModel:            make([]ModelCacheEfficiencyResponse, len(eff.ByModel)),
+	}
+	for i, m := range eff.ByModel {
+		response.ByModel[i] = ModelCacheEfficiencyResponse{
+			Provider:           m.Provider,
+			Model:              m.Model,
+			CacheUsageResponse: toCacheUsageResponse(m.CacheUsage),
+			Priced:             m.Priced,
+		}
+	}
+
+	s.writeJSON(w, response)
+}
+
+func toCacheUsageResponse(u analytics.CacheUsage) CacheUsageResponse {
+	return CacheUsageResponse{
+		FlowCount:           u.FlowCount,
+		InputTokens:         u.InputTokens,
+		CacheReadTokens:     u.CacheReadTokens,
+		CacheCreationTokens: u.CacheCreationTokens,
+		HitRatio:            u.HitRatio,
+		ReadSavings:         u.ReadSavings,
+		WritePremium:        u.WritePremium,
+		NetSavings:          u.NetSavings,
+	}
+}
+
+// getDuplicateRequests returns groups of flows that sent identical requests.
+func (s *Server) getDuplicateRequests(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if s.analytics == nil {
+		writeError(w, http.StatusServiceUnavailable, errCodeUnavailable, "Analytics unavailable")
+		return
+	}
+
+	start, end := s.parseTimeRange(r)
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 100 {
+			limit = n
+		}
+	}
+
+	groups, err := s.analytics.FindDuplicateRequests(ctx, start, end, limit)
+	if err != nil {
+		s.logger.Error("failed to find duplicate requests", "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
 		return
 	}
 
-	s.writeJSON(w, toToolInvocationResponse(inv))
+	response := make([]DuplicateGroupResponse, len(groups))
+	for i, g := range groups {
+		response[i] = DuplicateGroupResponse{
+			Signature: g.Signature,
+			Count:     g.Count,
+			Method:    g.Method,
+			Host:      g.Host,
+			Path:      g.Path,
+			FirstSeen: g.FirstSeen,
+			LastSeen:  g.LastSeen,
+			FlowIDs:   g.FlowIDs,
+		}
+	}
+
+	s.writeJSON(w, response)
 }
 
-// getCostByDay returns daily cost breakdown.
-func (s *Server) getCostByDay(w http.ResponseWriter, r *http.Request) {
+// getErrorStats returns failed flows counted by provider, status code and
+// error type.
+func (s *Server) getErrorStats(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
 	if s.analytics == nil {
-		http.Error(w, "Analytics unavailable", http.StatusServiceUnavailable)
+		writeError(w, http.StatusServiceUnavailable, errCodeUnavailable, "Analytics unavailable")
 		return
 	}
 
 	start, end := s.parseTimeRange(r)
-
-	periods, err := s.analytics.GetCostByDay(ctx, start, end)
+	stats, err := s.analytics.GetErrorStats(ctx, start, end)
 	if err != nil {
-		s.logger.Error("failed to get daily costs", "error", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+		s.logger.Error("failed to get error stats", "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
 		return
 	}
 
-	response := make([]CostPeriodResponse, len(periods))
-	for i, p := range periods {
-		response[i] = CostPeriodResponse{
-			Period:         p.Period,
-			FlowCount:      p.FlowCount,
-			TotalCost:      p.TotalCost,
-			TotalTokensIn:  p.TotalTokensIn,
-			TotalTokensOut: p.TotalTokensOut,
+	response := ErrorStatsResponse{
+		Start:  start,
+		End:    end,
+		Groups: make([]ErrorGroupResponse, len(stats)),
+	}
+	for i, st := range stats {
+		response.TotalErrors += st.Count
+		response.Groups[i] = ErrorGroupResponse{
+			Provider:   st.Provider,
+			StatusCode: st.StatusCode,
+			ErrorType:  st.ErrorType,
+			Count:      st.Count,
+			LastSeen:   st.LastSeen,
 		}
 	}
 
 	s.writeJSON(w, response)
 }
 
-// getCostByModel returns cost breakdown by model.
-func (s *Server) getCostByModel(w http.ResponseWriter, r *http.Request) {
+// getBandwidth returns the request and response body bytes transferred per
+// hour or day.
+func (s *Server) getBandwidth(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
 	if s.analytics == nil {
-		http.Error(w, "Analytics unavailable", http.StatusServiceUnavailable)
+		writeError(w, http.StatusServiceUnavailable, errCodeUnavailable, "Analytics unavailable")
 		return
 	}
 
 	start, end := s.parseTimeRange(r)
 
-	models, err := s.analytics.GetCostByModel(ctx, start, end)
+	bucket := r.URL.Query().Get("bucket")
+	if bucket == "" {
+		bucket = "hour"
+	}
+	if !analytics.ValidBandwidthBucket(bucket) {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "bucket must be hour or day")
+		return
+	}
+
+	points, err := s.analytics.GetBandwidth(ctx, start, end, bucket)
 	if err != nil {
-		s.logger.Error("failed to get model costs", "error", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+		s.logger.Error("failed to get bandwidth", "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
 		return
 	}
 
-	response := make([]CostPeriodResponse, len(models))
-	for i, m := range models {
-		response[i] = CostPeriodResponse{
-			Period:         m.Period, // Model name
-			FlowCount:      m.FlowCount,
-			TotalCost:      m.TotalCost,
-			TotalTokensIn:  m.TotalTokensIn,
-			TotalTokensOut: m.TotalTokensOut,
+	response := BandwidthResponse{
+		Start:  start,
+		End:    end,
+		Bucket: bucket,
+		Points: make([]BandwidthPointResponse, len(points)),
+	}
+	for i, p := range points {
+		response.TotalRequestBytes += p.RequestBytes
+		response.TotalResponseBytes += p.ResponseBytes
+		response.Points[i] = BandwidthPointResponse{
+			Period:        p.Period,
+			FlowCount:     p.FlowCount,
+			RequestBytes:  p.RequestBytes,
+			ResponseBytes: p.ResponseBytes,
 		}
 	}
 
@@ -783,20 +1764,20 @@ func (s *Server) getFlowAnomalies(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	if s.analytics == nil {
-		http.Error(w, "Analytics unavailable", http.StatusServiceUnavailable)
+		writeError(w, http.StatusServiceUnavailable, errCodeUnavailable, "Analytics unavailable")
 		return
 	}
 
 	flowID := r.PathValue("id")
 	if flowID == "" {
-		http.Error(w, "Missing flow ID", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "Missing flow ID")
 		return
 	}
 
-	anomalies, err := s.analytics.DetectFlowAnomalies(ctx, flowID, nil)
+	anomalies, err := s.analytics.DetectFlowAnomalies(ctx, flowID, s.anomalyThresholds())
 	if err != nil {
 		s.logger.Error("failed to detect anomalies", "flow_id", flowID, "error", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
 		return
 	}
 
@@ -808,13 +1789,21 @@ func (s *Server) getFlowAnomalies(w http.ResponseWriter, r *http.Request) {
 	s.writeJSON(w, response)
 }
 
+// anomalyThresholds returns the default thresholds with the configured
+// latency outlier sensitivity.
+func (s *Server) anomalyThresholds() *analytics.AnomalyThresholds {
+	t := analytics.DefaultThresholds()
+	t.LatencySigma = s.cfg.Analytics.AnomalyLatencySigma
+	return t
+}
+
 // getAnomalies returns recent anomalies.
 func (s *Server) getAnomalies(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
 	if s.analytics == nil {
-		http.Error(w, "Analytics unavailable", http.StatusServiceUnavailable)
+		writeError(w, http.StatusServiceUnavailable, errCodeUnavailable, "Analytics unavailable")
 		return
 	}
 
@@ -826,10 +1815,10 @@ func (s *Server) getAnomalies(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	anomalies, err := s.analytics.ListRecentAnomalies(ctx, since, nil)
+	anomalies, err := s.analytics.ListRecentAnomalies(ctx, since, s.anomalyThresholds())
 	if err != nil {
 		s.logger.Error("failed to list anomalies", "error", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
 		return
 	}
 
@@ -860,6 +1849,18 @@ func (s *Server) parseTimeRange(r *http.Request) (start, end time.Time) {
 	return start, end
 }
 
+// getCACert downloads the CA certificate clients must trust. It is public,
+// so no auth is needed, like the CRL served next to it.
+func (s *Server) getCACert(w http.ResponseWriter, r *http.Request) {
+	if s.ca == nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "No CA configured")
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Header().Set("Content-Disposition", `attachment; filename="langley-ca.crt"`)
+	_, _ = w.Write(s.ca.CertPEM())
+}
+
 // healthCheck returns server health status with operational metrics.
 func (s *Server) healthCheck(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
@@ -890,7 +1891,8 @@ func (s *Server) healthCheck(w http.ResponseWriter, r *http.Request) {
 
 		// Active flows (recent 5 minutes)
 		var activeFlows int
-		row = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM flows WHERE timestamp > datetime('now', '-5 minutes')")
+		row = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM flows WHERE timestamp > ?",
+			store.FormatTime(time.Now().Add(-5*time.Minute)))
 		_ = row.Scan(&activeFlows)
 		health.ActiveFlows = activeFlows
 
@@ -916,6 +1918,29 @@ func (s *Server) healthCheck(w http.ResponseWriter, r *http.Request) {
 		health.Status = "degraded"
 		health.Warning = "Large WAL file - consider checkpoint"
 	}
+	if s.capture != nil {
+		capture := s.capture.Health()
+		health.Capture = &capture
+		switch capture.Status {
+		case store.CaptureStatusError:
+			health.Status = "error"
+			health.Warning = fmt.Sprintf("Flow capture failing (%.0f%% of recent writes): %s", capture.FailureRate*100, capture.LastError)
+		case store.CaptureStatusDegraded:
+			health.Status = "degraded"
+			health.Warning = fmt.Sprintf("Some flow writes failing (%.0f%% of recent writes): %s", capture.FailureRate*100, capture.LastError)
+		}
+	}
+
+	if s.upstream != nil {
+		health.Upstream = s.upstream.Stats()
+	}
+	if s.models != nil {
+		health.RateLimits = s.models.Stats()
+	}
+	if s.throughput != nil {
+		throughput := s.throughput.Throughput()
+		health.Throughput = &throughput
+	}
 
 	s.writeJSON(w, health)
 }
@@ -928,7 +1953,7 @@ func (s *Server) checkpoint(w http.ResponseWriter, r *http.Request) {
 
 	db, ok := s.store.DB().(*sql.DB)
 	if !ok {
-		http.Error(w, "Database unavailable", http.StatusServiceUnavailable)
+		writeError(w, http.StatusServiceUnavailable, errCodeUnavailable, "Database unavailable")
 		return
 	}
 
@@ -941,7 +1966,7 @@ func (s *Server) checkpoint(w http.ResponseWriter, r *http.Request) {
 	err := db.QueryRowContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)").Scan(&blocked, &walPagesLog, &walPagesCheckpointed)
 	if err != nil {
 		s.logger.Error("checkpoint failed", "error", err)
-		http.Error(w, "Checkpoint failed: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Checkpoint failed: "+err.Error())
 		return
 	}
 
@@ -969,98 +1994,418 @@ func (s *Server) checkpoint(w http.ResponseWriter, r *http.Request) {
 	s.writeJSON(w, response)
 }
 
-// adminReload reloads configuration from disk.
-// SECURITY: Requires authentication and localhost-only access.
-func (s *Server) adminReload(w http.ResponseWriter, r *http.Request) {
-	// Security: Only allow from localhost
-	remoteAddr := r.RemoteAddr
-	if !isLocalhost(remoteAddr) {
-		s.logger.Warn("admin reload rejected: not localhost", "remote", remoteAddr)
-		http.Error(w, "Admin endpoints are localhost-only", http.StatusForbidden)
+// adminVacuum runs VACUUM to shrink the database file after retention deletes.
+// SECURITY: Requires an admin token and, unless api.allow_remote_admin is
+// set, a local connection.
+func (s *Server) adminVacuum(w http.ResponseWriter, r *http.Request) {
+	// VACUUM rewrites the whole file; allow more time than regular queries
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	start := time.Now()
+	sizeBefore, sizeAfter, err := s.store.Vacuum(ctx)
+	if err != nil {
+		s.logger.Error("vacuum failed", "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Vacuum failed: "+err.Error())
 		return
 	}
 
-	if s.cfgPath == "" {
-		http.Error(w, "Config path not set - reload not supported", http.StatusServiceUnavailable)
+	s.logger.Info("database vacuumed", "size_before", sizeBefore, "size_after", sizeAfter)
+	s.writeJSON(w, VacuumResponse{
+		Success:        true,
+		SizeBefore:     sizeBefore,
+		SizeAfter:      sizeAfter,
+		BytesReclaimed: sizeBefore - sizeAfter,
+		DurationMs:     time.Since(start).Milliseconds(),
+		Timestamp:      time.Now(),
+	})
+}
+
+// adminRetentionPreview reports what the next retention run would delete
+// with the current TTLs, without deleting anything.
+// SECURITY: Requires an admin token and, unless api.allow_remote_admin is
+// set, a local connection.
+func (s *Server) adminRetentionPreview(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	preview, err := s.store.PreviewRetention(ctx)
+	if err != nil {
+		s.logger.Error("retention preview failed", "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
 		return
 	}
 
-	// Reload config from disk
-	newCfg, err := config.Load(s.cfgPath)
+	retention := s.cfg.Retention.Snapshot()
+	s.writeJSON(w, RetentionPreviewResponse{
+		Flows:            preview.Flows,
+		Events:           preview.Events,
+		ToolInvocations:  preview.ToolCalls,
+		BodiesStripped:   preview.BodiesStripped,
+		Tunnels:          preview.Tunnels,
+		DropLog:          preview.DropLog,
+		ReclaimableBytes: preview.ReclaimableBytes,
+		FlowsTTLDays:     retention.FlowsTTLDays,
+		EventsTTLDays:    retention.EventsTTLDays,
+		BodiesTTLDays:    retention.BodiesTTLDays,
+		DropLogTTLDays:   retention.DropLogTTLDays,
+	})
+}
+
+// adminResetConfirm issues a single-use token that POST /api/admin/reset
+// must echo back. Issuing a new token invalidates the previous one.
+// SECURITY: Requires an admin token and, unless api.allow_remote_admin is
+// set, a local connection.
+func (s *Server) adminResetConfirm(w http.ResponseWriter, r *http.Request) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		s.logger.Error("failed to generate reset nonce", "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+
+	s.resetMu.Lock()
+	s.resetNonce = hex.EncodeToString(b)
+	s.resetExpires = time.Now().Add(resetNonceTTL)
+	resp := ResetConfirmResponse{Confirm: s.resetNonce, ExpiresAt: s.resetExpires}
+	s.resetMu.Unlock()
+
+	s.writeJSON(w, resp)
+}
+
+// adminReset deletes all captured data (flows, events, tool invocations,
+// tags, drop log, tunnels) and vacuums, keeping the schema and pricing.
+// The body must carry the token from GET /api/admin/reset/confirm; any
+// attempt consumes the token.
+// SECURITY: Requires an admin token and, unless api.allow_remote_admin is
+// set, a local connection.
+func (s *Server) adminReset(w http.ResponseWriter, r *http.Request) {
+	var req ResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidJSON, "Invalid JSON: "+err.Error())
+		return
+	}
+	if req.Confirm == "" {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "confirm is required; get one from GET /api/admin/reset/confirm")
+		return
+	}
+
+	s.resetMu.Lock()
+	nonce, expires := s.resetNonce, s.resetExpires
+	s.resetNonce = ""
+	s.resetMu.Unlock()
+
+	if nonce == "" || time.Now().After(expires) ||
+		subtle.ConstantTimeCompare([]byte(req.Confirm), []byte(nonce)) != 1 {
+		writeError(w, http.StatusForbidden, errCodeInvalidConfirmation, "Invalid or expired confirmation token")
+		return
+	}
+
+	// VACUUM rewrites the whole file; allow more time than regular queries
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	start := time.Now()
+	deleted, err := s.store.PurgeAll(ctx)
 	if err != nil {
-		s.logger.Error("failed to reload config", "error", err)
-		http.Error(w, "Failed to reload config: "+err.Error(), http.StatusInternalServerError)
+		s.logger.Error("reset failed", "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Reset failed: "+err.Error())
 		return
 	}
+	var reclaimed int64
+	if sizeBefore, sizeAfter, err := s.store.Vacuum(ctx); err != nil {
+		// The data is gone; only the space reclaim failed
+		s.logger.Error("vacuum after reset failed", "error", err)
+	} else {
+		reclaimed = sizeBefore - sizeAfter
+	}
+
+	s.logger.Warn("all captured data purged", "rows_deleted", deleted, "remote", r.RemoteAddr)
+	s.writeJSON(w, ResetResponse{
+		Success:        true,
+		RowsDeleted:    deleted,
+		BytesReclaimed: reclaimed,
+		DurationMs:     time.Since(start).Milliseconds(),
+		Timestamp:      time.Now(),
+	})
+}
+
+// ReloadResult describes a config reload.
+type ReloadResult struct {
+	Changed  []string         // Config keys that changed, e.g. "auth.token", "redaction"
+	Redactor *redact.Redactor // Rebuilt from the reloaded redaction config
+}
+
+// Reload re-reads the config file and applies the settings that can change
+// without a restart: auth tokens, redaction and retention. They are updated
+// in the live config under its lock, which the WebSocket hub and store read;
+// anything holding a redactor gets the rebuilt one through the onReload
+// callback. Nothing is applied if the file fails to load or its redaction
+// patterns don't compile. Used by both POST /api/admin/reload and SIGHUP.
+func (s *Server) Reload() (*ReloadResult, error) {
+	if s.cfgPath == "" {
+		return nil, errors.New("config path not set")
+	}
+
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	newCfg, err := config.Load(s.cfgPath)
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+	redactor, err := redact.New(&newCfg.Redaction)
+	if err != nil {
+		return nil, err
+	}
+	// The listen address isn't reloaded, but the token guarding it is
+	if err := newCfg.Auth.ValidateForListen(s.cfg.API.Listen); err != nil {
+		return nil, err
+	}
+
+	result := &ReloadResult{Changed: []string{}}
+	s.cfg.Update(func(cfg *config.Config) {
+		if cfg.Auth.Token != newCfg.Auth.Token {
+			result.Changed = append(result.Changed, "auth.token")
+		}
+		if !reflect.DeepEqual(cfg.Auth.Tokens, newCfg.Auth.Tokens) {
+			result.Changed = append(result.Changed, "auth.tokens")
+		}
+		if !reflect.DeepEqual(cfg.Redaction, newCfg.Redaction) {
+			result.Changed = append(result.Changed, "redaction")
+		}
+		if cfg.Retention != newCfg.Retention {
+			result.Changed = append(result.Changed, "retention")
+		}
+
+		cfg.Auth.Token = newCfg.Auth.Token
+		cfg.Auth.Tokens = newCfg.Auth.Tokens
+		cfg.Redaction = newCfg.Redaction
+		cfg.Retention = newCfg.Retention
+	})
 
-	oldToken := s.cfg.Auth.Token
-	newToken := newCfg.Auth.Token
+	result.Redactor = redactor
+	s.publish(result)
 
-	// Update the token in current config
-	s.cfg.Auth.Token = newToken
+	s.logger.Info("config reloaded", "path", s.cfgPath, "changed", result.Changed)
+	return result, nil
+}
 
-	// Notify callback if registered (e.g., to update WebSocket handler)
+// publish makes result's redactor the API's and hands result to the
+// onReload callback for anything else holding a redactor.
+func (s *Server) publish(result *ReloadResult) {
+	s.redactor.Store(result.Redactor)
 	if s.onReload != nil {
-		s.onReload(newToken)
+		s.onReload(result)
+	}
+}
+
+// adminReload reloads configuration from disk.
+// SECURITY: Requires an admin token and, unless api.allow_remote_admin is
+// set, a local connection.
+func (s *Server) adminReload(w http.ResponseWriter, r *http.Request) {
+	if s.cfgPath == "" {
+		writeError(w, http.StatusServiceUnavailable, errCodeUnavailable, "Config path not set - reload not supported")
+		return
 	}
 
-	s.logger.Info("config reloaded", "token_changed", oldToken != newToken)
+	result, err := s.Reload()
+	if err != nil {
+		s.logger.Error("failed to reload config", "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to reload config: "+err.Error())
+		return
+	}
 
 	response := map[string]interface{}{
 		"success":       true,
-		"token_changed": oldToken != newToken,
+		"token_changed": slices.Contains(result.Changed, "auth.token"),
+		"changed":       result.Changed,
 		"timestamp":     time.Now(),
 	}
 	s.writeJSON(w, response)
 }
 
-// getSettings returns current server settings.
+// getSettings returns the runtime-tunable settings.
 func (s *Server) getSettings(w http.ResponseWriter, r *http.Request) {
-	settings := SettingsResponse{
-		IdleGapMinutes: s.cfg.Task.IdleGapMinutes,
-		// Add more settings as needed
-	}
-	s.writeJSON(w, settings)
+	s.writeJSON(w, s.currentSettings())
+}
+
+// currentSettings reads the tunable settings from the live config.
+func (s *Server) currentSettings() SettingsResponse {
+	var settings SettingsResponse
+	s.cfg.View(func(cfg *config.Config) {
+		settings = SettingsResponse{
+			IdleGapMinutes:     cfg.Task.IdleGapMinutes,
+			BodyMaxBytes:       cfg.Persistence.BodyMaxBytes,
+			FlowsTTLDays:       cfg.Retention.FlowsTTLDays,
+			EventsTTLDays:      cfg.Retention.EventsTTLDays,
+			BodiesTTLDays:      cfg.Retention.BodiesTTLDays,
+			DropLogTTLDays:     cfg.Retention.DropLogTTLDays,
+			RedactAPIKeys:      cfg.Redaction.RedactAPIKeys,
+			RedactBase64Images: cfg.Redaction.RedactBase64Images,
+			DisableBodyStorage: cfg.Redaction.DisableBodyStorage,
+		}
+	})
+	return settings
+}
+
+// restartRequiredSettings are config fields that are only read at startup,
+// keyed by the name a client might send. Changing them needs a config file
+// edit and a restart, so updateSettings rejects them with 409.
+var restartRequiredSettings = map[string]string{
+	"listen":                   "proxy.listen",
+	"intercept_hosts":          "proxy.intercept_hosts",
+	"db_path":                  "persistence.db_path",
+	"event_batch_size":         "persistence.event_batch_size",
+	"event_batch_timeout_ms":   "persistence.event_batch_timeout_ms",
+	"queue_max_size":           "persistence.queue_max_size",
+	"store_headers":            "persistence.store_headers",
+	"store_deltas":             "parser.store_deltas",
+	"always_redact_headers":    "redaction.always_redact_headers",
+	"pattern_redact_headers":   "redaction.pattern_redact_headers",
+	"custom_patterns":          "redaction.custom_patterns",
+	"cors_origins":             "api.cors_origins",
+	"max_cert_gen_concurrency": "tls.max_cert_gen_concurrency",
+	"token":                    "auth.token",
 }
 
+// Settings ranges
+const (
+	minBodyMaxBytes = 1024
+	maxBodyMaxBytes = 100 * 1024 * 1024
+	maxTTLDays      = 3650
+)
+
 // updateSettings updates server settings and persists to config file.
+// Only fields present in the body change (PUT and PATCH behave the same).
+// Body size, retention and redaction toggles are read live by the proxy and
+// store, so they apply to the next flow or retention run.
 func (s *Server) updateSettings(w http.ResponseWriter, r *http.Request) {
 	if s.cfgPath == "" {
-		http.Error(w, "Config path not set - settings update not supported", http.StatusServiceUnavailable)
+		writeError(w, http.StatusServiceUnavailable, errCodeUnavailable, "Config path not set - settings update not supported")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "Failed to read body")
+		return
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidJSON, "Invalid JSON: "+err.Error())
 		return
 	}
+	for name := range fields {
+		if key, ok := restartRequiredSettings[name]; ok {
+			writeError(w, http.StatusConflict, errCodeRestartRequired, fmt.Sprintf("%s requires a restart: edit %s in the config file", name, key))
+			return
+		}
+	}
 
 	var req SettingsUpdateRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidJSON, "Invalid JSON: "+err.Error())
+		return
+	}
+
+	// Validate everything before applying anything
+	if err := req.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
 		return
 	}
+	s.cfg.Update(req.apply)
 
-	// Validate settings
-	if req.IdleGapMinutes != nil {
-		if *req.IdleGapMinutes < 1 || *req.IdleGapMinutes > 60 {
-			http.Error(w, "idle_gap_minutes must be between 1 and 60", http.StatusBadRequest)
+	// Redactors keep their own copy of the redaction config, so toggles
+	// need a new one
+	if req.RedactAPIKeys != nil || req.RedactBase64Images != nil || req.DisableBodyStorage != nil {
+		redactor, err := redact.New(&s.cfg.Redaction)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to rebuild redactor: "+err.Error())
 			return
 		}
-		s.cfg.Task.IdleGapMinutes = *req.IdleGapMinutes
+		s.publish(&ReloadResult{Changed: []string{"redaction"}, Redactor: redactor})
 	}
 
 	// Save config to file
 	if err := s.cfg.Save(s.cfgPath); err != nil {
 		s.logger.Error("failed to save config", "error", err)
-		http.Error(w, "Failed to save config: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to save config: "+err.Error())
 		return
 	}
 
-	s.logger.Info("settings updated", "idle_gap_minutes", s.cfg.Task.IdleGapMinutes)
+	settings := s.currentSettings()
+	s.logger.Info("settings updated", "settings", settings)
 
 	// Return updated settings
-	settings := SettingsResponse{
-		IdleGapMinutes: s.cfg.Task.IdleGapMinutes,
-	}
 	s.writeJSON(w, settings)
 }
 
+// validate checks the requested values against their allowed ranges.
+func (req *SettingsUpdateRequest) validate() error {
+	checkRange := func(name string, v *int, min, max int) error {
+		if v != nil && (*v < min || *v > max) {
+			return fmt.Errorf("%s must be between %d and %d", name, min, max)
+		}
+		return nil
+	}
+	return errors.Join(
+		checkRange("idle_gap_minutes", req.IdleGapMinutes, 1, 60),
+		checkRange("body_max_bytes", req.BodyMaxBytes, minBodyMaxBytes, maxBodyMaxBytes),
+		checkRange("flows_ttl_days", req.FlowsTTLDays, 1, maxTTLDays),
+		checkRange("events_ttl_days", req.EventsTTLDays, 0, maxTTLDays),
+		checkRange("bodies_ttl_days", req.BodiesTTLDays, 0, maxTTLDays),
+		checkRange("drop_log_ttl_days", req.DropLogTTLDays, 1, maxTTLDays),
+	)
+}
+
+// apply copies the requested values into cfg.
+func (req *SettingsUpdateRequest) apply(cfg *config.Config) {
+	set := func(dst *int, v *int) {
+		if v != nil {
+			*dst = *v
+		}
+	}
+	setBool := func(dst *bool, v *bool) {
+		if v != nil {
+			*dst = *v
+		}
+	}
+	set(&cfg.Task.IdleGapMinutes, req.IdleGapMinutes)
+	set(&cfg.Persistence.BodyMaxBytes, req.BodyMaxBytes)
+	set(&cfg.Retention.FlowsTTLDays, req.FlowsTTLDays)
+	set(&cfg.Retention.EventsTTLDays, req.EventsTTLDays)
+	set(&cfg.Retention.BodiesTTLDays, req.BodiesTTLDays)
+	set(&cfg.Retention.DropLogTTLDays, req.DropLogTTLDays)
+	setBool(&cfg.Redaction.RedactAPIKeys, req.RedactAPIKeys)
+	setBool(&cfg.Redaction.RedactBase64Images, req.RedactBase64Images)
+	setBool(&cfg.Redaction.DisableBodyStorage, req.DisableBodyStorage)
+}
+
+// unixPeerKey marks the context of a request that arrived on a Unix socket.
+type unixPeerKey struct{}
+
+// UnixConnContext is an http.Server ConnContext that marks connections
+// accepted on a Unix socket, whose peers can only be local processes.
+func UnixConnContext(ctx context.Context, c net.Conn) context.Context {
+	if _, ok := c.(*net.UnixConn); ok {
+		return context.WithValue(ctx, unixPeerKey{}, true)
+	}
+	return ctx
+}
+
+// isLocalRequest reports whether r came from this machine: over a Unix
+// socket, going by the mark UnixConnContext leaves, or from a loopback
+// address.
+func isLocalRequest(r *http.Request) bool {
+	if unix, _ := r.Context().Value(unixPeerKey{}).(bool); unix {
+		return true
+	}
+	return isLocalhost(r.RemoteAddr)
+}
+
 // isLocalhost checks if the remote address is from localhost.
 func isLocalhost(remoteAddr string) bool {
 	// Handle various address formats:
@@ -1068,7 +2413,7 @@ func isLocalhost(remoteAddr string) bool {
 	// - "127.0.0.1" (IPv4 without port)
 	// - "[::1]:8080" (IPv6 with port)
 	// - "::1" (IPv6 without port)
-
+	// A Unix socket peer has no address to go by; see isLocalRequest.
 	host := remoteAddr
 
 	// Check for IPv6 with port: [::1]:port
@@ -1096,8 +2441,47 @@ func (s *Server) writeJSON(w http.ResponseWriter, v interface{}) {
 	}
 }
 
+// Error codes for ErrorDetail.Code. Clients can match on these; messages
+// are for people and may change.
+const (
+	errCodeBadRequest          = "bad_request"
+	errCodeInvalidJSON         = "invalid_json"
+	errCodeTokenInURL          = "token_in_url"
+	errCodeUnauthorized        = "unauthorized"
+	errCodeForbidden           = "forbidden"
+	errCodeAdminRequired       = "admin_required"
+	errCodeLocalhostOnly       = "localhost_only"
+	errCodeInvalidConfirmation = "invalid_confirmation"
+	errCodeNotFound            = "not_found"
+	errCodeRestartRequired     = "restart_required"
+	errCodeRateLimited         = "rate_limited"
+	errCodeInternal            = "internal_error"
+	errCodeUpstream            = "upstream_error"
+	errCodeUnavailable         = "unavailable"
+)
+
+// writeError writes a JSON ErrorResponse with the given status. CORS
+// headers set by the middleware are left in place.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(ErrorResponse{Error: ErrorDetail{Code: code, Message: message}})
+}
+
 // API response types
 
+// ErrorResponse is the body of every API error response.
+type ErrorResponse struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail describes what went wrong.
+type ErrorDetail struct {
+	Code    string `json:"code"`    // Stable, e.g. "not_found"
+	Message string `json:"message"` // Human-readable
+}
+
 // FlowSummary is the summary view of a flow.
 type FlowSummary struct {
 	ID           string     `json:"id"`
@@ -1106,6 +2490,8 @@ type FlowSummary struct {
 	Path         string     `json:"path"`
 	StatusCode   *int       `json:"status_code"`
 	IsSSE        bool       `json:"is_sse"`
+	IsWebSocket  bool       `json:"is_websocket,omitempty"`
+	StreamMismatch bool     `json:"stream_mismatch,omitempty"` // Request's "stream" field disagreed with the response
 	Timestamp    time.Time  `json:"timestamp"`
 	DurationMs   *int64     `json:"duration_ms,omitempty"`
 	TaskID       *string    `json:"task_id,omitempty"`
@@ -1114,25 +2500,65 @@ type FlowSummary struct {
 	InputTokens  *int       `json:"input_tokens,omitempty"`
 	OutputTokens *int       `json:"output_tokens,omitempty"`
 	TotalCost    *float64   `json:"total_cost,omitempty"`
+
+	// Events saved and request plus response body bytes stored, as of the
+	// last read from the store (live WebSocket updates report 0)
+	EventCount      int   `json:"event_count"`
+	StoredBodyBytes int64 `json:"stored_body_bytes"`
+
+	// Full body lengths as transferred, however much is stored
+	RequestBodySize  *int64 `json:"request_body_size,omitempty"`
+	ResponseBodySize *int64 `json:"response_body_size,omitempty"`
+}
+
+// FlowListResponse is the GET /api/flows?envelope=true response.
+type FlowListResponse struct {
+	Items      []FlowSummary `json:"items"`
+	Total      int           `json:"total"`
+	Limit      int           `json:"limit"`
+	Offset     int           `json:"offset"`
+	NextOffset *int          `json:"next_offset"` // nil on the last page
+	PrevOffset *int          `json:"prev_offset"` // nil on the first page
 }
 
 // FlowDetail is the detailed view of a flow.
+
 type FlowDetail struct {
 	FlowSummary
-	URL                   string              `json:"url"`
-	StatusText            *string             `json:"status_text,omitempty"`
-	Provider              string              `json:"provider"`
-	FlowIntegrity         string              `json:"flow_integrity"`
-	EventsDroppedCount    int                 `json:"events_dropped_count"`
-	RequestBody           *string             `json:"request_body,omitempty"`
-	RequestBodyTruncated  bool                `json:"request_body_truncated"`
-	ResponseBody          *string             `json:"response_body,omitempty"`
-	ResponseBodyTruncated bool                `json:"response_body_truncated"`
-	RequestHeaders        map[string][]string `json:"request_headers,omitempty"`
-	ResponseHeaders       map[string][]string `json:"response_headers,omitempty"`
-	CacheCreationTokens   *int                `json:"cache_creation_tokens,omitempty"`
-	CacheReadTokens       *int                `json:"cache_read_tokens,omitempty"`
-	CostSource            *string             `json:"cost_source,omitempty"`
+	URL                      string              `json:"url"`
+	StatusText               *string             `json:"status_text,omitempty"`
+	Provider                 string              `json:"provider"`
+	FlowIntegrity            string              `json:"flow_integrity"`
+	EventsDroppedCount       int                 `json:"events_dropped_count"`
+	EventsSkippedCount       int                 `json:"events_skipped_count"` // Deltas not stored (parser.store_deltas)
+	RequestBody              *string             `json:"request_body,omitempty"`
+	RequestBodyTruncated     bool                `json:"request_body_truncated"`
+	RequestBodyInvalid       bool                `json:"request_body_invalid"` // Sent as JSON but didn't parse (proxy.validate_request_json)
+	ResponseBody             *string             `json:"response_body,omitempty"`
+	ResponseBodyTruncated    bool                `json:"response_body_truncated"`
+	RequestHeaders           map[string][]string `json:"request_headers,omitempty"`
+	RequestHeaderOrder       []string            `json:"request_header_order,omitempty"`
+	ResponseHeaders          map[string][]string `json:"response_headers,omitempty"`
+	CacheCreationTokens      *int                `json:"cache_creation_tokens,omitempty"`
+	CacheReadTokens          *int                `json:"cache_read_tokens,omitempty"`
+	CostSource               *string             `json:"cost_source,omitempty"`
+	RateLimit                *RateLimitResponse  `json:"rate_limit,omitempty"`
+	CacheBreakpoints         *int                `json:"cache_breakpoints,omitempty"`
+	CacheBreakpointPositions []string            `json:"cache_breakpoint_positions,omitempty"`
+	RequestSignature         *string             `json:"request_signature,omitempty"`
+	StopReason               *string             `json:"stop_reason,omitempty"`
+	ErrorType                *string             `json:"error_type,omitempty"`
+	ErrorMessage             *string             `json:"error_message,omitempty"`
+	Notes                    *string             `json:"notes,omitempty"`
+}
+
+// RateLimitResponse is the normalized rate-limit state reported with a flow.
+type RateLimitResponse struct {
+	RequestsLimit     *int64  `json:"requests_limit,omitempty"`
+	RequestsRemaining *int64  `json:"requests_remaining,omitempty"`
+	TokensLimit       *int64  `json:"tokens_limit,omitempty"`
+	TokensRemaining   *int64  `json:"tokens_remaining,omitempty"`
+	Reset             *string `json:"reset,omitempty"`
 }
 
 // ExportFlowSummary is the export format for flows (NDJSON streaming).
@@ -1145,6 +2571,8 @@ type ExportFlowSummary struct {
 	StatusCode    *int     `json:"status_code"`
 	DurationMs    *int64   `json:"duration_ms,omitempty"`
 	IsSSE         bool     `json:"is_sse"`
+	IsWebSocket   bool     `json:"is_websocket,omitempty"`
+	StreamMismatch bool    `json:"stream_mismatch,omitempty"`
 	TaskID        *string  `json:"task_id,omitempty"`
 	TaskSource    *string  `json:"task_source,omitempty"`
 	Model         *string  `json:"model,omitempty"`
@@ -1165,27 +2593,45 @@ type EventResponse struct {
 	Priority  string                 `json:"priority"`
 }
 
-// StatsResponse is the API response for stats.
-type StatsResponse struct {
-	Status    string    `json:"status"`
-	Timestamp time.Time `json:"timestamp"`
-}
-
 // OverallStatsResponse is the detailed stats response.
 type OverallStatsResponse struct {
-	Status           string    `json:"status"`
-	Timestamp        time.Time `json:"timestamp"`
-	TotalFlows       int       `json:"total_flows"`
-	AllTimeFlows     int       `json:"all_time_flows"`
-	TotalCost        float64   `json:"total_cost"`
-	TotalTokensIn    int       `json:"total_tokens_in"`
-	TotalTokensOut   int       `json:"total_tokens_out"`
-	TotalTasks       int       `json:"total_tasks"`
-	TotalToolCalls   int       `json:"total_tool_calls"`
-	AvgCostPerFlow   float64   `json:"avg_cost_per_flow"`
-	AvgTokensPerFlow float64   `json:"avg_tokens_per_flow"`
-	StartTime        time.Time `json:"start_time"`
-	EndTime          time.Time `json:"end_time"`
+	Status           string               `json:"status"`
+	Timestamp        time.Time            `json:"timestamp"`
+	TotalFlows       int                  `json:"total_flows"`
+	AllTimeFlows     int                  `json:"all_time_flows"`
+	TotalCost        float64              `json:"total_cost"`
+	TotalTokensIn    int                  `json:"total_tokens_in"`
+	TotalTokensOut   int                  `json:"total_tokens_out"`
+	TotalTasks       int                  `json:"total_tasks"`
+	TotalToolCalls   int                  `json:"total_tool_calls"`
+	AvgCostPerFlow   float64              `json:"avg_cost_per_flow"`
+	AvgTokensPerFlow float64              `json:"avg_tokens_per_flow"`
+	ByProvider       []StatsFacetResponse `json:"by_provider"`
+	ByModel          []StatsFacetResponse `json:"by_model"`
+	StartTime        time.Time            `json:"start_time"`
+	EndTime          time.Time            `json:"end_time"`
+	Fallback         bool                 `json:"fallback,omitempty"` // Computed without SQL by listing flows (slower)
+}
+
+// StatsFacetResponse is one provider's or model's share of the stats totals.
+type StatsFacetResponse struct {
+	Name           string  `json:"name"`
+	FlowCount      int     `json:"flow_count"`
+	TotalCost      float64 `json:"total_cost"`
+	TotalTokensIn  int     `json:"total_tokens_in"`
+	TotalTokensOut int     `json:"total_tokens_out"`
+}
+
+// TaskRetentionRequest is the body of PUT /api/tasks/{id}/retention.
+type TaskRetentionRequest struct {
+	TTLDays *int `json:"ttl_days"` // null or 0 removes the override
+}
+
+// TaskRetentionResponse is how long a task's flows are kept.
+type TaskRetentionResponse struct {
+	TaskID   string `json:"task_id"`
+	TTLDays  int    `json:"ttl_days"` // The override, or the global flows TTL
+	Override bool   `json:"override"`
 }
 
 // TaskSummaryResponse is the API response for task analytics.
@@ -1202,6 +2648,32 @@ type TaskSummaryResponse struct {
 	ToolsUsed      []string  `json:"tools_used,omitempty"`
 }
 
+// TimelineEntryResponse is a flow in a task timeline with its tool invocations.
+type TimelineEntryResponse struct {
+	FlowID       string                 `json:"flow_id"`
+	Timestamp    time.Time              `json:"timestamp"`
+	DurationMs   *int64                 `json:"duration_ms,omitempty"`
+	StatusCode   *int                   `json:"status_code,omitempty"`
+	Method       string                 `json:"method"`
+	Host         string                 `json:"host"`
+	Path         string                 `json:"path"`
+	Model        *string                `json:"model,omitempty"`
+	InputTokens  int                    `json:"input_tokens"`
+	OutputTokens int                    `json:"output_tokens"`
+	TotalCost    float64                `json:"total_cost"`
+	Tools        []TimelineToolResponse `json:"tools"`
+}
+
+// TimelineToolResponse is a tool invocation nested under a timeline entry.
+type TimelineToolResponse struct {
+	ID         string    `json:"id"`
+	ToolUseID  *string   `json:"tool_use_id,omitempty"`
+	ToolName   string    `json:"tool_name"`
+	Timestamp  time.Time `json:"timestamp"`
+	DurationMs *int64    `json:"duration_ms,omitempty"`
+	Success    *bool     `json:"success,omitempty"`
+}
+
 // ToolStatsResponse is the API response for tool analytics.
 type ToolStatsResponse struct {
 	ToolName        string  `json:"tool_name"`
@@ -1211,6 +2683,9 @@ type ToolStatsResponse struct {
 	SuccessRate     float64 `json:"success_rate"`
 	TotalCost       float64 `json:"total_cost"`
 	AvgDurationMs   float64 `json:"avg_duration_ms"`
+	P50DurationMs   int64   `json:"p50_duration_ms"`
+	P95DurationMs   int64   `json:"p95_duration_ms"`
+	P99DurationMs   int64   `json:"p99_duration_ms"`
 	TotalTokensIn   int     `json:"total_tokens_in"`
 	TotalTokensOut  int     `json:"total_tokens_out"`
 }
@@ -1245,6 +2720,125 @@ type CostPeriodResponse struct {
 	TotalTokensOut int     `json:"total_tokens_out"`
 }
 
+// QuotaPointResponse is the API response for one quota timeline bucket.
+type QuotaPointResponse struct {
+	Provider          string `json:"provider"`
+	Period            string `json:"period"`
+	Samples           int    `json:"samples"`
+	RequestsLimit     *int64 `json:"requests_limit"`
+	RequestsRemaining *int64 `json:"requests_remaining"`
+	TokensLimit       *int64 `json:"tokens_limit"`
+	TokensRemaining   *int64 `json:"tokens_remaining"`
+}
+
+// CostBucketResponse totals the flows costed one way.
+type CostBucketResponse struct {
+	FlowCount    int     `json:"flow_count"`
+	Cost         float64 `json:"cost"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+}
+
+// CostReconciliationResponse is the API response for cost coverage by cost source.
+type CostReconciliationResponse struct {
+	Start        time.Time          `json:"start"`
+	End          time.Time          `json:"end"`
+	TotalFlows   int                `json:"total_flows"`
+	TotalCost    float64            `json:"total_cost"`
+	Exact        CostBucketResponse `json:"exact"`
+	Estimated    CostBucketResponse `json:"estimated"`
+	Uncosted     CostBucketResponse `json:"uncosted"`
+	MissingUsage int                `json:"missing_usage"`
+	UnknownModel int                `json:"unknown_model"`
+	Coverage     float64            `json:"coverage"`
+}
+
+// CacheBreakpointStatsResponse is the API response for prompt-cache breakpoint effectiveness.
+type CacheBreakpointStatsResponse struct {
+	Breakpoints         int     `json:"breakpoints"`
+	FlowCount           int     `json:"flow_count"`
+	CacheHitFlows       int     `json:"cache_hit_flows"`
+	HitRate             float64 `json:"hit_rate"`
+	InputTokens         int64   `json:"input_tokens"`
+	CacheReadTokens     int64   `json:"cache_read_tokens"`
+	CacheCreationTokens int64   `json:"cache_creation_tokens"`
+	CachedShare         float64 `json:"cached_share"`
+}
+
+// CacheUsageResponse is prompt-cache usage and estimated savings, in USD.
+type CacheUsageResponse struct {
+	FlowCount           int     `json:"flow_count"`
+	InputTokens         int64   `json:"input_tokens"`
+	CacheReadTokens     int64   `json:"cache_read_tokens"`
+	CacheCreationTokens int64   `json:"cache_creation_tokens"`
+	HitRatio            float64 `json:"hit_ratio"`
+	ReadSavings         float64 `json:"read_savings"`
+	WritePremium        float64 `json:"write_premium"`
+	NetSavings          float64 `json:"net_savings"`
+}
+
+// CacheEfficiencyResponse is the API response for GET /api/analytics/cache.
+type CacheEfficiencyResponse struct {
+	CacheUsageResponse
+	ByModel []ModelCacheEfficiencyResponse `json:"by_model"`
+}
+
+// ModelCacheEfficiencyResponse is one model's prompt-cache usage.
+type ModelCacheEfficiencyResponse struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	CacheUsageResponse
+	Priced bool `json:"priced"` // False when the model has no cache prices; savings are zero
+}
+
+// DuplicateGroupResponse is the API response for a group of identical requests.
+type DuplicateGroupResponse struct {
+	Signature string    `json:"signature"`
+	Count     int       `json:"count"`
+	Method    string    `json:"method"`
+	Host      string    `json:"host"`
+	Path      string    `json:"path"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	FlowIDs   []string  `json:"flow_ids"` // Oldest first
+}
+
+// ErrorStatsResponse is the API response for GET /api/analytics/errors.
+type ErrorStatsResponse struct {
+	Start       time.Time            `json:"start"`
+	End         time.Time            `json:"end"`
+	TotalErrors int                  `json:"total_errors"`
+	Groups      []ErrorGroupResponse `json:"groups"` // Most frequent first
+}
+
+// ErrorGroupResponse counts the failed flows of one provider with the same
+// status code and error type.
+type ErrorGroupResponse struct {
+	Provider   string    `json:"provider"`
+	StatusCode int       `json:"status_code"`
+	ErrorType  string    `json:"error_type"` // Empty if the response had no parseable error
+	Count      int       `json:"count"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+// BandwidthResponse is the API response for GET /api/analytics/bandwidth.
+type BandwidthResponse struct {
+	Start              time.Time                `json:"start"`
+	End                time.Time                `json:"end"`
+	Bucket             string                   `json:"bucket"`
+	TotalRequestBytes  int64                    `json:"total_request_bytes"`
+	TotalResponseBytes int64                    `json:"total_response_bytes"`
+	Points             []BandwidthPointResponse `json:"points"` // Oldest first
+}
+
+// BandwidthPointResponse is the body bytes transferred in one bucket.
+type BandwidthPointResponse struct {
+	Period        string `json:"period"` // Bucket start, RFC 3339 UTC
+	FlowCount     int    `json:"flow_count"`
+	RequestBytes  int64  `json:"request_bytes"`
+	ResponseBytes int64  `json:"response_bytes"`
+}
+
 // AnomalyResponse is the API response for anomalies.
 type AnomalyResponse struct {
 	Type        string    `json:"type"`
@@ -1259,16 +2853,20 @@ type AnomalyResponse struct {
 
 // HealthResponse is the API response for health status.
 type HealthResponse struct {
-	Status         string    `json:"status"` // "ok", "degraded", "error"
-	Timestamp      time.Time `json:"timestamp"`
-	Uptime         string    `json:"uptime"`
-	WALSizeBytes   int64     `json:"wal_size_bytes"`
-	WALCheckpointed int64    `json:"wal_checkpointed_bytes"`
-	DropsLast24h   int64     `json:"drops_last_24h"`
-	ActiveFlows    int       `json:"active_flows"` // Flows in last 5 minutes
-	TotalFlows     int64     `json:"total_flows"`
-	DBSizeBytes    int64     `json:"db_size_bytes"`
-	Warning        string    `json:"warning,omitempty"`
+	Status          string                      `json:"status"` // "ok", "degraded", "error"
+	Timestamp       time.Time                   `json:"timestamp"`
+	Uptime          string                      `json:"uptime"`
+	WALSizeBytes    int64                       `json:"wal_size_bytes"`
+	WALCheckpointed int64                       `json:"wal_checkpointed_bytes"`
+	DropsLast24h    int64                       `json:"drops_last_24h"`
+	ActiveFlows     int                         `json:"active_flows"` // Flows in last 5 minutes
+	TotalFlows      int64                       `json:"total_flows"`
+	DBSizeBytes     int64                       `json:"db_size_bytes"`
+	Capture         *store.CaptureHealth        `json:"capture,omitempty"`     // Recent flow write outcomes
+	Upstream        *analytics.UpstreamStats    `json:"upstream,omitempty"`    // Upstream concurrency limit, when set
+	RateLimits      []analytics.ModelLimitStats `json:"rate_limits,omitempty"` // Per-model rate limits, when set
+	Throughput      *analytics.ThroughputStats  `json:"throughput,omitempty"`  // Proxy in-flight requests and forwarding latency
+	Warning         string                      `json:"warning,omitempty"`
 }
 
 // CheckpointResponse is the API response for WAL checkpoint operations.
@@ -1283,14 +2881,117 @@ type CheckpointResponse struct {
 	Timestamp         time.Time `json:"timestamp"`
 }
 
+// VacuumResponse is the API response for a VACUUM operation.
+type VacuumResponse struct {
+	Success        bool      `json:"success"`
+	SizeBefore     int64     `json:"size_before_bytes"`
+	SizeAfter      int64     `json:"size_after_bytes"`
+	BytesReclaimed int64     `json:"bytes_reclaimed"`
+	DurationMs     int64     `json:"duration_ms"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// RetentionPreviewResponse is the response for GET /api/admin/retention/preview.
+// Counts are rows the next retention run would remove; tool invocations go
+// with their flows. Flows expire at the expires_at set when they were
+// captured, so changing flows_ttl_days only affects new flows.
+type RetentionPreviewResponse struct {
+	Flows            int64 `json:"flows"`
+	Events           int64 `json:"events"`
+	ToolInvocations  int64 `json:"tool_invocations"`
+	BodiesStripped   int64 `json:"bodies_stripped"` // Kept flows whose bodies would be removed
+	Tunnels          int64 `json:"tunnels"`
+	DropLog          int64 `json:"drop_log"`
+	ReclaimableBytes int64 `json:"reclaimable_bytes"` // Estimate of body, header and event data freed
+	FlowsTTLDays     int   `json:"flows_ttl_days"`
+	EventsTTLDays    int   `json:"events_ttl_days"`
+	BodiesTTLDays    int   `json:"bodies_ttl_days"`
+	DropLogTTLDays   int   `json:"drop_log_ttl_days"`
+}
+
+// ResetConfirmResponse carries the token required by POST /api/admin/reset.
+type ResetConfirmResponse struct {
+	Confirm   string    `json:"confirm"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ResetRequest is the request body for POST /api/admin/reset.
+type ResetRequest struct {
+	Confirm string `json:"confirm"`
+}
+
+// ResetResponse is the API response for a factory reset.
+type ResetResponse struct {
+	Success        bool      `json:"success"`
+	RowsDeleted    int64     `json:"rows_deleted"`
+	BytesReclaimed int64     `json:"bytes_reclaimed"`
+	DurationMs     int64     `json:"duration_ms"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// TunnelResponse is a CONNECT tunnel access log entry.
+type TunnelResponse struct {
+	ID         int64     `json:"id"`
+	Host       string    `json:"host"`
+	Mode       string    `json:"mode"` // "passthrough" or "intercepted"
+	StartedAt  time.Time `json:"started_at"`
+	EndedAt    time.Time `json:"ended_at"`
+	DurationMs int64     `json:"duration_ms"`
+	BytesUp    int64     `json:"bytes_up"`
+	BytesDown  int64     `json:"bytes_down"`
+}
+
+// FlowTagRequest is the body of POST /api/flows/{id}/tags.
+type FlowTagRequest struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// FlowNotesRequest is the body of PUT /api/flows/{id}/notes.
+type FlowNotesRequest struct {
+	Notes *string `json:"notes"` // null or empty clears the notes
+}
+
+// FlowTagResponse is a tag on a flow.
+type FlowTagResponse struct {
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
 // SettingsResponse is the API response for settings.
 type SettingsResponse struct {
-	IdleGapMinutes int `json:"idle_gap_minutes"`
+	IdleGapMinutes     int  `json:"idle_gap_minutes"`
+	BodyMaxBytes       int  `json:"body_max_bytes"`
+	FlowsTTLDays       int  `json:"flows_ttl_days"`
+	EventsTTLDays      int  `json:"events_ttl_days"`
+	BodiesTTLDays      int  `json:"bodies_ttl_days"`
+	DropLogTTLDays     int  `json:"drop_log_ttl_days"`
+	RedactAPIKeys      bool `json:"redact_api_keys"`
+	RedactBase64Images bool `json:"redact_base64_images"`
+	DisableBodyStorage bool `json:"disable_body_storage"`
 }
 
 // SettingsUpdateRequest is the request body for updating settings.
+// Omitted fields are left unchanged.
 type SettingsUpdateRequest struct {
-	IdleGapMinutes *int `json:"idle_gap_minutes,omitempty"`
+	IdleGapMinutes     *int  `json:"idle_gap_minutes,omitempty"`
+	BodyMaxBytes       *int  `json:"body_max_bytes,omitempty"`
+	FlowsTTLDays       *int  `json:"flows_ttl_days,omitempty"`
+	EventsTTLDays      *int  `json:"events_ttl_days,omitempty"`
+	BodiesTTLDays      *int  `json:"bodies_ttl_days,omitempty"`
+	DropLogTTLDays     *int  `json:"drop_log_ttl_days,omitempty"`
+	RedactAPIKeys      *bool `json:"redact_api_keys,omitempty"`
+	RedactBase64Images *bool `json:"redact_base64_images,omitempty"`
+	DisableBodyStorage *bool `json:"disable_body_storage,omitempty"`
+}
+
+func toFlowTagResponses(tags []*store.FlowTag) []FlowTagResponse {
+	result := make([]FlowTagResponse, len(tags))
+	for i, t := range tags {
+		result[i] = FlowTagResponse{Key: t.Key, Value: t.Value, CreatedAt: t.CreatedAt}
+	}
+	return result
 }
 
 func toAnomalyResponse(a *analytics.Anomaly) AnomalyResponse {
@@ -1314,6 +3015,8 @@ func toFlowSummary(f *store.Flow) FlowSummary {
 		Path:         f.Path,
 		StatusCode:   f.StatusCode,
 		IsSSE:        f.IsSSE,
+		IsWebSocket:  f.IsWebSocket,
+		StreamMismatch: f.StreamMismatch,
 		Timestamp:    f.Timestamp,
 		DurationMs:   f.DurationMs,
 		TaskID:       f.TaskID,
@@ -1322,27 +3025,64 @@ func toFlowSummary(f *store.Flow) FlowSummary {
 		InputTokens:  f.InputTokens,
 		OutputTokens: f.OutputTokens,
 		TotalCost:    f.TotalCost,
+
+		EventCount:      f.EventCount,
+		StoredBodyBytes: f.StoredBodyBytes,
+
+		RequestBodySize:  f.RequestBodySize,
+		ResponseBodySize: f.ResponseBodySize,
 	}
 }
 
+
 func toFlowDetail(f *store.Flow) FlowDetail {
 	return FlowDetail{
-		FlowSummary:           toFlowSummary(f),
-		URL:                   f.URL,
-		StatusText:            f.StatusText,
-		Provider:              f.Provider,
-		FlowIntegrity:         f.FlowIntegrity,
-		EventsDroppedCount:    f.EventsDroppedCount,
-		RequestBody:           f.RequestBody,
-		RequestBodyTruncated:  f.RequestBodyTruncated,
-		ResponseBody:          f.ResponseBody,
-		ResponseBodyTruncated: f.ResponseBodyTruncated,
-		RequestHeaders:        f.RequestHeaders,
-		ResponseHeaders:       f.ResponseHeaders,
-		CacheCreationTokens:   f.CacheCreationTokens,
-		CacheReadTokens:       f.CacheReadTokens,
-		CostSource:            f.CostSource,
+		FlowSummary:              toFlowSummary(f),
+		URL:                      f.URL,
+		StatusText:               f.StatusText,
+		Provider:                 f.Provider,
+		FlowIntegrity:            f.FlowIntegrity,
+		EventsDroppedCount:       f.EventsDroppedCount,
+		EventsSkippedCount:       f.EventsSkippedCount,
+		RequestBody:              f.RequestBody,
+		RequestBodyTruncated:     f.RequestBodyTruncated,
+		RequestBodyInvalid:       f.RequestBodyInvalid,
+		ResponseBody:             f.ResponseBody,
+		ResponseBodyTruncated:    f.ResponseBodyTruncated,
+		RequestHeaders:           f.RequestHeaders,
+		RequestHeaderOrder:       f.RequestHeaderOrder,
+		ResponseHeaders:          f.ResponseHeaders,
+		CacheCreationTokens:      f.CacheCreationTokens,
+		CacheReadTokens:          f.CacheReadTokens,
+		CostSource:               f.CostSource,
+		RateLimit:                toRateLimitResponse(f),
+		CacheBreakpoints:         f.CacheBreakpoints,
+		CacheBreakpointPositions: f.CacheBreakpointPositions,
+		RequestSignature:         f.RequestSignature,
+		StopReason:               f.StopReason,
+		ErrorType:                f.ErrorType,
+		ErrorMessage:             f.ErrorMessage,
+		Notes:                    f.Notes,
+	}
+}
+
+// toRateLimitResponse returns nil if the flow captured no rate-limit headers.
+func toRateLimitResponse(f *store.Flow) *RateLimitResponse {
+	if f.RateLimitRequestsLimit == nil && f.RateLimitRequestsRemaining == nil &&
+		f.RateLimitTokensLimit == nil && f.RateLimitTokensRemaining == nil && f.RateLimitReset == nil {
+		return nil
+	}
+	rl := &RateLimitResponse{
+		RequestsLimit:     f.RateLimitRequestsLimit,
+		RequestsRemaining: f.RateLimitRequestsRemaining,
+		TokensLimit:       f.RateLimitTokensLimit,
+		TokensRemaining:   f.RateLimitTokensRemaining,
 	}
+	if f.RateLimitReset != nil {
+		reset := f.RateLimitReset.Format(time.RFC3339)
+		rl.Reset = &reset
+	}
+	return rl
 }
 
 func toExportFlowSummary(f *store.Flow) ExportFlowSummary {
@@ -1355,6 +3095,8 @@ func toExportFlowSummary(f *store.Flow) ExportFlowSummary {
 		StatusCode:    f.StatusCode,
 		DurationMs:    f.DurationMs,
 		IsSSE:         f.IsSSE,
+		IsWebSocket:   f.IsWebSocket,
+		StreamMismatch: f.StreamMismatch,
 		TaskID:        f.TaskID,
 		TaskSource:    f.TaskSource,
 		Model:         f.Model,
@@ -1382,6 +3124,34 @@ func toToolInvocationResponse(inv *store.ToolInvocation) ToolInvocationResponse
 	}
 }
 
+func toTimelineEntryResponse(entry *analytics.TimelineEntry) TimelineEntryResponse {
+	tools := make([]TimelineToolResponse, len(entry.Tools))
+	for i, tool := range entry.Tools {
+		tools[i] = TimelineToolResponse{
+			ID:         tool.ID,
+			ToolUseID:  tool.ToolUseID,
+			ToolName:   tool.ToolName,
+			Timestamp:  tool.Timestamp,
+			DurationMs: tool.DurationMs,
+			Success:    tool.Success,
+		}
+	}
+	return TimelineEntryResponse{
+		FlowID:       entry.FlowID,
+		Timestamp:    entry.Timestamp,
+		DurationMs:   entry.DurationMs,
+		StatusCode:   entry.StatusCode,
+		Method:       entry.Method,
+		Host:         entry.Host,
+		Path:         entry.Path,
+		Model:        entry.Model,
+		InputTokens:  entry.InputTokens,
+		OutputTokens: entry.OutputTokens,
+		TotalCost:    entry.TotalCost,
+		Tools:        tools,
+	}
+}
+
 func toEventResponse(e *store.Event) EventResponse {
 	return EventResponse{
 		ID:        e.ID,
@@ -2,78 +2,36 @@ package api
 
 import (
 	"context"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/HakAl/langley/internal/analytics"
 	"github.com/HakAl/langley/internal/config"
+	"github.com/HakAl/langley/internal/proxy"
 	"github.com/HakAl/langley/internal/store"
+	"github.com/HakAl/langley/internal/store/storetest"
 	"github.com/HakAl/langley/internal/testutil"
+	langleytls "github.com/HakAl/langley/internal/tls"
 )
 
-// mockStore implements store.Store for testing.
-type mockStore struct {
-	flows []*store.Flow
-}
-
-func (m *mockStore) SaveFlow(ctx context.Context, flow *store.Flow) error      { return nil }
-func (m *mockStore) UpdateFlow(ctx context.Context, flow *store.Flow) error    { return nil }
-func (m *mockStore) GetFlow(ctx context.Context, id string) (*store.Flow, error) {
-	return &store.Flow{ID: id, Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages"}, nil
-}
-func (m *mockStore) ListFlows(ctx context.Context, filter store.FlowFilter) ([]*store.Flow, error) {
-	if m.flows == nil {
-		return []*store.Flow{}, nil
-	}
-	// Simple pagination
-	start := filter.Offset
-	if start >= len(m.flows) {
-		return []*store.Flow{}, nil
-	}
-	end := start + filter.Limit
-	if end > len(m.flows) {
-		end = len(m.flows)
-	}
-	return m.flows[start:end], nil
-}
-func (m *mockStore) DeleteFlow(ctx context.Context, id string) error { return nil }
-func (m *mockStore) CountFlows(ctx context.Context, filter store.FlowFilter) (int, error) {
-	if m.flows == nil {
-		return 0, nil
-	}
-	return len(m.flows), nil
-}
-func (m *mockStore) SaveEvent(ctx context.Context, event *store.Event) error                   { return nil }
-func (m *mockStore) SaveEvents(ctx context.Context, events []*store.Event) error               { return nil }
-func (m *mockStore) GetEventsByFlow(ctx context.Context, flowID string) ([]*store.Event, error) {
-	return []*store.Event{}, nil
-}
-func (m *mockStore) SaveToolInvocation(ctx context.Context, inv *store.ToolInvocation) error { return nil }
-func (m *mockStore) GetToolInvocationsByFlow(ctx context.Context, flowID string) ([]*store.ToolInvocation, error) {
-	return []*store.ToolInvocation{}, nil
-}
-func (m *mockStore) GetToolInvocation(ctx context.Context, id string) (*store.ToolInvocation, error) {
-	return &store.ToolInvocation{ID: id, ToolName: "test_tool"}, nil
-}
-func (m *mockStore) ListToolInvocations(ctx context.Context, toolName string, start, end time.Time, limit, offset int) ([]*store.ToolInvocation, int, error) {
-	return []*store.ToolInvocation{}, 0, nil
-}
-func (m *mockStore) UpdateToolResult(ctx context.Context, toolUseID string, success bool, errorMsg *string, resultContent *string, resultTime time.Time) error {
-	return nil
-}
-func (m *mockStore) LogDrop(ctx context.Context, entry *store.DropLogEntry) error { return nil }
-func (m *mockStore) RunRetention(ctx context.Context) (int64, error)              { return 0, nil }
-func (m *mockStore) Close() error                                                 { return nil }
-func (m *mockStore) DB() interface{}                                              { return nil }
-
 func TestAuthMiddleware_RejectsTokenInURL(t *testing.T) {
 	cfg := config.DefaultConfig()
 	cfg.Auth.Token = "test-token-12345"
 
-	server := NewServer(cfg, &mockStore{}, nil)
+	server := NewServer(cfg, storetest.New(), nil)
 	handler := server.Handler()
 
 	tests := []struct {
@@ -171,7 +129,7 @@ func TestAuthMiddleware_ConstantTimeComparison(t *testing.T) {
 	cfg := config.DefaultConfig()
 	cfg.Auth.Token = "secure-token-abc123"
 
-	server := NewServer(cfg, &mockStore{}, nil)
+	server := NewServer(cfg, storetest.New(), nil)
 	handler := server.Handler()
 
 	// Test that similar-length wrong tokens are rejected
@@ -209,11 +167,56 @@ func containsSubstringHelper(s, substr string) bool {
 	return false
 }
 
+func TestErrorResponses(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+	handler := NewServer(cfg, storetest.New(), nil).Handler()
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"missing flow", "GET", "/api/flows/nope", "", http.StatusNotFound, "not_found"},
+		{"malformed JSON", "POST", "/api/flows/nope/tags", "{", http.StatusBadRequest, "invalid_json"},
+		{"invalid tag", "POST", "/api/flows/nope/tags", `{"key":"a=b"}`, http.StatusBadRequest, "bad_request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer test-token")
+			req.Header.Set("Origin", "http://localhost:5173")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "http://localhost:5173" {
+				t.Errorf("Access-Control-Allow-Origin = %q, want the CORS headers kept", got)
+			}
+			var resp ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("body %q is not JSON: %v", rr.Body.String(), err)
+			}
+			if resp.Error.Code != tt.wantCode || resp.Error.Message == "" {
+				t.Errorf("error = %+v, want code %q with a message", resp.Error, tt.wantCode)
+			}
+		})
+	}
+}
+
 func TestAdminReload_LocalhostOnly(t *testing.T) {
 	cfg := config.DefaultConfig()
 	cfg.Auth.Token = "test-token"
 
-	server := NewServer(cfg, &mockStore{}, nil)
+	server := NewServer(cfg, storetest.New(), nil)
 	handler := server.Handler()
 
 	tests := []struct {
@@ -251,166 +254,2101 @@ func TestAdminReload_LocalhostOnly(t *testing.T) {
 	}
 }
 
-func TestIsLocalhost(t *testing.T) {
+func TestReload(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "langley.yaml")
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "old-token"
+	if err := cfg.Save(cfgPath); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	var callback *ReloadResult
+	server := NewServer(cfg, storetest.New(), nil, WithConfigPath(cfgPath),
+		WithOnReload(func(r *ReloadResult) { callback = r }))
+
+	oldRedactor := server.redactor.Load()
+
+	edited := config.DefaultConfig()
+	edited.Auth.Token = "new-token"
+	edited.Redaction.CustomPatterns = []config.CustomRedactionPattern{{Pattern: `ticket-\d+`, Replacement: "[TICKET]"}}
+	edited.Retention.FlowsTTLDays = 14
+	if err := edited.Save(cfgPath); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	result, err := server.Reload()
+	if err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got := strings.Join(result.Changed, ","); got != "auth.token,redaction,retention" {
+		t.Errorf("Changed = %s, want auth.token,redaction,retention", got)
+	}
+	if callback != result {
+		t.Error("onReload did not receive the reload result")
+	}
+	if cfg.Auth.Token != "new-token" || cfg.Retention.FlowsTTLDays != 14 {
+		t.Errorf("live config token %q, flows TTL %d; want the reloaded values", cfg.Auth.Token, cfg.Retention.FlowsTTLDays)
+	}
+	if got := result.Redactor.RedactBody("see ticket-42"); got != "see [TICKET]" {
+		t.Errorf("rebuilt redactor gave %q, want the new custom pattern applied", got)
+	}
+	if got := oldRedactor.RedactBody("see ticket-42"); got != "see ticket-42" {
+		t.Errorf("old redactor gave %q, want it unchanged by the reload", got)
+	}
+
+	// The admin endpoint goes through the same reload, with the new token
+	req := httptest.NewRequest("POST", "/api/admin/reload", nil)
+	req.Header.Set("Authorization", "Bearer new-token")
+	req.RemoteAddr = "127.0.0.1:12345"
+	rr := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"changed":[]`) {
+		t.Errorf("admin reload: status %d, body %s; want 200 with nothing changed", rr.Code, rr.Body.String())
+	}
+
+	// A pattern that doesn't compile leaves the running config alone
+	edited.Auth.Token = "third-token"
+	edited.Redaction.CustomPatterns = []config.CustomRedactionPattern{{Pattern: `(`}}
+	if err := edited.Save(cfgPath); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if _, err := server.Reload(); err == nil {
+		t.Error("Reload with a bad pattern succeeded")
+	}
+	if cfg.Auth.Token != "new-token" {
+		t.Errorf("token = %q after a failed reload, want new-token", cfg.Auth.Token)
+	}
+}
+
+func TestReload_RemoteListenNeedsStrongToken(t *testing.T) {
+	strong, err := config.GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfgPath := filepath.Join(t.TempDir(), "langley.yaml")
+	cfg := config.DefaultConfig()
+	cfg.API.Listen = "0.0.0.0:9091"
+	cfg.Auth.Token = strong
+	if err := cfg.Save(cfgPath); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	server := NewServer(cfg, storetest.New(), nil, WithConfigPath(cfgPath))
+
+	readToken := []config.ScopedToken{{Name: "ci", Hash: config.HashToken("ci-token"), Scope: config.ScopeRead}}
+	for _, weak := range []string{"hunter2", strings.Repeat("ab", 20)} {
+		edited := config.DefaultConfig()
+		edited.API.Listen = cfg.API.Listen
+		edited.Auth.Token = weak
+		edited.Auth.Tokens = readToken
+		if err := edited.Save(cfgPath); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		if _, err := server.Reload(); err == nil {
+			t.Errorf("Reload with token %q on a remote listen address succeeded", weak)
+		}
+		if cfg.Auth.Token != strong || len(cfg.Auth.Tokens) != 0 {
+			t.Errorf("tokens changed by a rejected reload: %q, %v", cfg.Auth.Token, cfg.Auth.Tokens)
+		}
+	}
+
+	// A local listen address still takes any token
+	cfg.API.Listen = "localhost:9091"
+	if _, err := server.Reload(); err != nil {
+		t.Fatalf("Reload on a local listen address: %v", err)
+	}
+	if cfg.Auth.Token != strings.Repeat("ab", 20) {
+		t.Errorf("token = %q, want the reloaded one", cfg.Auth.Token)
+	}
+}
+
+func TestAdminVacuum(t *testing.T) {
 	tests := []struct {
-		addr string
-		want bool
+		name        string
+		remoteAddr  string
+		allowRemote bool
+		token       string
+		wantStatus  int
 	}{
-		{"127.0.0.1:8080", true},
-		{"127.0.0.1", true},
-		{"localhost:8080", true},
-		{"localhost", true},
-		{"[::1]:8080", true},
-		{"::1", true},
-		{"192.168.1.1:8080", false},
-		{"10.0.0.1:8080", false},
-		{"8.8.8.8:8080", false},
+		{name: "localhost allowed", remoteAddr: "127.0.0.1:12345", token: "test-token", wantStatus: http.StatusOK},
+		{name: "remote rejected", remoteAddr: "10.0.0.5:12345", token: "test-token", wantStatus: http.StatusForbidden},
+		{name: "remote allowed when enabled", remoteAddr: "10.0.0.5:12345", allowRemote: true, token: "test-token", wantStatus: http.StatusOK},
+		{name: "remote read token still rejected", remoteAddr: "10.0.0.5:12345", allowRemote: true, token: "read-token", wantStatus: http.StatusForbidden},
 	}
 
 	for _, tt := range tests {
-		t.Run(tt.addr, func(t *testing.T) {
-			got := isLocalhost(tt.addr)
-			if got != tt.want {
-				t.Errorf("isLocalhost(%q) = %v, want %v", tt.addr, got, tt.want)
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Auth.Token = "test-token"
+			cfg.Auth.Tokens = []config.ScopedToken{
+				{Name: "dashboard", Hash: config.HashToken("read-token"), Scope: config.ScopeRead},
+			}
+			cfg.API.AllowRemoteAdmin = tt.allowRemote
+			handler := NewServer(cfg, storetest.New(), nil).Handler()
+
+			req := httptest.NewRequest("POST", "/api/admin/vacuum", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			req.RemoteAddr = tt.remoteAddr
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d, body: %s", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp VacuumResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if !resp.Success {
+				t.Error("expected success")
 			}
 		})
 	}
 }
 
-func TestExportFlows_NDJSON(t *testing.T) {
+func TestAdminRetentionPreview(t *testing.T) {
 	cfg := config.DefaultConfig()
 	cfg.Auth.Token = "test-token"
+	st, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer st.Close()
+
+	ctx := context.Background()
+	expired := time.Now().Add(-time.Hour)
+	old := testutil.NewFlow().WithID("flow-expired").Build()
+	old.ExpiresAt = &expired
+	if err := st.SaveFlow(ctx, old); err != nil {
+		t.Fatalf("SaveFlow: %v", err)
+	}
+	if err := st.SaveFlow(ctx, testutil.NewFlow().WithID("flow-live").Build()); err != nil {
+		t.Fatalf("SaveFlow: %v", err)
+	}
 
-	mockFlows := createTestFlows(3)
-	ms := &mockStore{flows: mockFlows}
-
-	server := NewServer(cfg, ms, nil)
-	handler := server.Handler()
-
-	req := httptest.NewRequest("GET", "/api/flows/export?format=ndjson", nil)
-	req.Header.Set("Authorization", "Bearer test-token")
+	handler := NewServer(cfg, st, nil).Handler()
+	do := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/admin/retention/preview", nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
 
-	rr := httptest.NewRecorder()
-	handler.ServeHTTP(rr, req)
+	if rr := do("10.0.0.5:12345"); rr.Code != http.StatusForbidden {
+		t.Fatalf("remote preview: got status %d, want 403", rr.Code)
+	}
 
+	rr := do("127.0.0.1:12345")
 	if rr.Code != http.StatusOK {
-		t.Fatalf("got status %d, want 200, body: %s", rr.Code, rr.Body.String())
+		t.Fatalf("got status %d, body: %s", rr.Code, rr.Body.String())
 	}
-
-	if ct := rr.Header().Get("Content-Type"); ct != "application/x-ndjson" {
-		t.Errorf("Content-Type = %q, want application/x-ndjson", ct)
+	var resp RetentionPreviewResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
 	}
-
-	// Should have 3 lines (one per flow)
-	lines := splitNonEmpty(rr.Body.String(), "\n")
-	if len(lines) != 3 {
-		t.Errorf("got %d lines, want 3", len(lines))
+	if resp.Flows != 1 || resp.FlowsTTLDays != cfg.Retention.FlowsTTLDays {
+		t.Errorf("preview = %+v, want 1 flow and flows_ttl_days %d", resp, cfg.Retention.FlowsTTLDays)
+	}
+	// Previewing deletes nothing
+	if f, err := st.GetFlow(ctx, "flow-expired"); err != nil || f == nil {
+		t.Errorf("expired flow gone after preview: %v", err)
 	}
 }
 
-func TestExportFlows_JSON(t *testing.T) {
+func TestAdminReset(t *testing.T) {
 	cfg := config.DefaultConfig()
 	cfg.Auth.Token = "test-token"
 
-	mockFlows := createTestFlows(2)
-	ms := &mockStore{flows: mockFlows}
+	dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer dataStore.Close()
 
-	server := NewServer(cfg, ms, nil)
-	handler := server.Handler()
+	ctx := context.Background()
+	if err := dataStore.SaveFlow(ctx, testutil.NewFlow().WithID("flow-reset").Build()); err != nil {
+		t.Fatalf("SaveFlow: %v", err)
+	}
 
-	req := httptest.NewRequest("GET", "/api/flows/export?format=json", nil)
-	req.Header.Set("Authorization", "Bearer test-token")
+	handler := NewServer(cfg, dataStore, nil).Handler()
+	do := func(method, path, body, remote string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		req.RemoteAddr = remote
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	const local = "127.0.0.1:12345"
+	confirm := func() string {
+		t.Helper()
+		rr := do("GET", "/api/admin/reset/confirm", "", local)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET confirm: got status %d, body: %s", rr.Code, rr.Body.String())
+		}
+		var resp ResetConfirmResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode confirm: %v", err)
+		}
+		if resp.Confirm == "" || !resp.ExpiresAt.After(time.Now()) {
+			t.Fatalf("confirm response = %+v", resp)
+		}
+		return resp.Confirm
+	}
+	flowExists := func() bool {
+		_, err := dataStore.GetFlow(ctx, "flow-reset")
+		return err == nil
+	}
 
-	rr := httptest.NewRecorder()
-	handler.ServeHTTP(rr, req)
+	if rr := do("GET", "/api/admin/reset/confirm", "", "10.0.0.5:12345"); rr.Code != http.StatusForbidden {
+		t.Errorf("remote confirm: got status %d, want 403", rr.Code)
+	}
+	if rr := do("POST", "/api/admin/reset", `{}`, local); rr.Code != http.StatusBadRequest {
+		t.Errorf("missing confirm: got status %d, want 400", rr.Code)
+	}
+	if rr := do("POST", "/api/admin/reset", `{"confirm":"guess"}`, local); rr.Code != http.StatusForbidden {
+		t.Errorf("no token issued: got status %d, want 403", rr.Code)
+	}
+
+	// A wrong guess consumes the issued token
+	token := confirm()
+	if rr := do("POST", "/api/admin/reset", `{"confirm":"guess"}`, local); rr.Code != http.StatusForbidden {
+		t.Errorf("wrong token: got status %d, want 403", rr.Code)
+	}
+	if rr := do("POST", "/api/admin/reset", `{"confirm":"`+token+`"}`, local); rr.Code != http.StatusForbidden {
+		t.Errorf("consumed token: got status %d, want 403", rr.Code)
+	}
 
+	// Only the latest token is valid, and only from localhost
+	stale := confirm()
+	token = confirm()
+	if rr := do("POST", "/api/admin/reset", `{"confirm":"`+stale+`"}`, local); rr.Code != http.StatusForbidden {
+		t.Errorf("superseded token: got status %d, want 403", rr.Code)
+	}
+	token = confirm()
+	if rr := do("POST", "/api/admin/reset", `{"confirm":"`+token+`"}`, "10.0.0.5:12345"); rr.Code != http.StatusForbidden {
+		t.Errorf("remote reset: got status %d, want 403", rr.Code)
+	}
+	if !flowExists() {
+		t.Fatal("flow deleted by a rejected reset")
+	}
+
+	rr := do("POST", "/api/admin/reset", `{"confirm":"`+token+`"}`, local)
 	if rr.Code != http.StatusOK {
-		t.Fatalf("got status %d, want 200", rr.Code)
+		t.Fatalf("reset: got status %d, body: %s", rr.Code, rr.Body.String())
+	}
+	var resp ResetResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode reset: %v", err)
+	}
+	if !resp.Success || resp.RowsDeleted != 1 {
+		t.Errorf("reset response = %+v, want success with 1 row deleted", resp)
+	}
+	if flowExists() {
+		t.Error("flow still present after reset")
 	}
+}
 
-	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
-		t.Errorf("Content-Type = %q, want application/json", ct)
+func TestListFlows_Pagination(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+
+	dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer dataStore.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+	for i := 0; i < 7; i++ {
+		f := testutil.NewFlow().WithID(fmt.Sprintf("flow-%d", i)).Build()
+		f.Host = "api.anthropic.com"
+		if i >= 5 {
+			f.Host = "api.openai.com"
+		}
+		f.Timestamp = now.Add(-time.Duration(i) * time.Minute)
+		if err := dataStore.SaveFlow(ctx, f); err != nil {
+			t.Fatalf("SaveFlow: %v", err)
+		}
 	}
 
-	// Parse JSON response
-	var result map[string]interface{}
-	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
-		t.Fatalf("failed to parse JSON: %v", err)
+	host := "api.anthropic.com"
+	wantTotal, err := dataStore.CountFlows(ctx, store.FlowFilter{Host: &host})
+	if err != nil {
+		t.Fatalf("CountFlows: %v", err)
 	}
 
-	flows, ok := result["flows"].([]interface{})
-	if !ok {
-		t.Fatal("missing 'flows' array")
+	server := NewServer(cfg, dataStore, nil)
+	handler := server.Handler()
+	get := func(url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d, body: %s", url, rr.Code, rr.Body.String())
+		}
+		return rr
+	}
+
+	// First page: bare array body, totals in headers
+	rr := get("/api/flows?host=api.anthropic.com&limit=2")
+	if got := rr.Header().Get("X-Total-Count"); got != strconv.Itoa(wantTotal) {
+		t.Errorf("X-Total-Count = %s, want %d (CountFlows)", got, wantTotal)
+	}
+	if got := rr.Header().Get("X-Has-More"); got != "true" {
+		t.Errorf("X-Has-More = %s, want true", got)
+	}
+	if got := rr.Header().Get("X-Next-Offset"); got != "2" {
+		t.Errorf("X-Next-Offset = %s, want 2", got)
+	}
+	if got := rr.Header().Get("X-Prev-Offset"); got != "" {
+		t.Errorf("X-Prev-Offset = %s, want none on first page", got)
+	}
+	var flows []FlowSummary
+	if err := json.Unmarshal(rr.Body.Bytes(), &flows); err != nil {
+		t.Fatalf("decode array body: %v", err)
 	}
 	if len(flows) != 2 {
 		t.Errorf("got %d flows, want 2", len(flows))
 	}
 
-	meta, ok := result["meta"].(map[string]interface{})
-	if !ok {
-		t.Fatal("missing 'meta' object")
+	// Last page via envelope
+	rr = get("/api/flows?host=api.anthropic.com&limit=2&offset=4&envelope=true")
+	if got := rr.Header().Get("X-Has-More"); got != "false" {
+		t.Errorf("X-Has-More = %s, want false", got)
 	}
-	if meta["row_count"].(float64) != 2 {
-		t.Errorf("row_count = %v, want 2", meta["row_count"])
+	var page FlowListResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+		t.Fatalf("decode envelope: %v", err)
+	}
+	if page.Total != wantTotal || page.Limit != 2 || page.Offset != 4 || len(page.Items) != 1 {
+		t.Errorf("page = total %d limit %d offset %d items %d, want %d/2/4/1",
+			page.Total, page.Limit, page.Offset, len(page.Items), wantTotal)
+	}
+	if page.NextOffset != nil {
+		t.Errorf("NextOffset = %d, want nil", *page.NextOffset)
+	}
+	if page.PrevOffset == nil || *page.PrevOffset != 2 {
+		t.Errorf("PrevOffset = %v, want 2", page.PrevOffset)
 	}
 }
 
-func TestExportFlows_CSV(t *testing.T) {
+func TestListFlows_EventCount(t *testing.T) {
 	cfg := config.DefaultConfig()
 	cfg.Auth.Token = "test-token"
 
-	mockFlows := createTestFlows(2)
-	ms := &mockStore{flows: mockFlows}
-
-	server := NewServer(cfg, ms, nil)
-	handler := server.Handler()
+	ctx := context.Background()
+	st := storetest.New(storetest.WithForeignKeys())
+	flow := testutil.NewFlow().WithID("streamed").Streaming().WithResponseBody("data: {}").Build()
+	if err := st.SaveFlow(ctx, flow); err != nil {
+		t.Fatalf("SaveFlow: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := st.SaveEvent(ctx, &store.Event{ID: fmt.Sprintf("ev-%d", i), FlowID: "streamed", Sequence: i}); err != nil {
+			t.Fatalf("SaveEvent: %v", err)
+		}
+	}
 
-	req := httptest.NewRequest("GET", "/api/flows/export?format=csv", nil)
+	req := httptest.NewRequest("GET", "/api/flows", nil)
 	req.Header.Set("Authorization", "Bearer test-token")
-
 	rr := httptest.NewRecorder()
-	handler.ServeHTTP(rr, req)
-
+	NewServer(cfg, st, nil).Handler().ServeHTTP(rr, req)
 	if rr.Code != http.StatusOK {
-		t.Fatalf("got status %d, want 200", rr.Code)
+		t.Fatalf("got status %d, body: %s", rr.Code, rr.Body.String())
+	}
+	var flows []FlowSummary
+	if err := json.Unmarshal(rr.Body.Bytes(), &flows); err != nil {
+		t.Fatalf("decode response: %v", err)
 	}
+	if len(flows) != 1 || flows[0].EventCount != 3 || flows[0].StoredBodyBytes == 0 {
+		t.Errorf("flows = %+v, want one flow with event_count 3 and its body size", flows)
+	}
+}
 
-	if ct := rr.Header().Get("Content-Type"); ct != "text/csv" {
-		t.Errorf("Content-Type = %q, want text/csv", ct)
+func TestFlowFilters_StatusAndProvider(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+
+	dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer dataStore.Close()
+
+	ctx := context.Background()
+	for _, f := range []*store.Flow{
+		testutil.NewFlow().WithID("anthropic-200").WithProvider("anthropic").WithStatus(200).Build(),
+		testutil.NewFlow().WithID("anthropic-529").WithProvider("anthropic").WithStatus(529).Build(),
+		testutil.NewFlow().WithID("openai-400").WithProvider("openai").WithStatus(400).Build(),
+		testutil.NewFlow().WithID("openai-200").WithProvider("openai").WithStatus(200).Build(),
+	} {
+		if err := dataStore.SaveFlow(ctx, f); err != nil {
+			t.Fatalf("SaveFlow: %v", err)
+		}
 	}
 
-	// Should have header + 2 data rows
-	lines := splitNonEmpty(rr.Body.String(), "\n")
-	if len(lines) != 3 {
-		t.Errorf("got %d lines, want 3 (header + 2 rows)", len(lines))
+	handler := NewServer(cfg, dataStore, nil).Handler()
+	get := func(url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d, body: %s", url, rr.Code, rr.Body.String())
+		}
+		return rr
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"status_min=400", []string{"anthropic-529", "openai-400"}},
+		{"status_min=400&status_max=499", []string{"openai-400"}},
+		{"provider=anthropic", []string{"anthropic-200", "anthropic-529"}},
+		{"provider=openai&status_max=299", []string{"openai-200"}},
+		{"status_min=abc", []string{"anthropic-200", "anthropic-529", "openai-200", "openai-400"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			var flows []FlowSummary
+			if err := json.Unmarshal(get("/api/flows?"+tt.query).Body.Bytes(), &flows); err != nil {
+				t.Fatalf("decode flows: %v", err)
+			}
+			var got []string
+			for _, f := range flows {
+				got = append(got, f.ID)
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("listFlows = %v, want %v", got, tt.want)
+			}
+
+			got = nil
+			for _, line := range splitNonEmpty(get("/api/flows/export?format=ndjson&"+tt.query).Body.String(), "\n") {
+				var f struct {
+					ID string `json:"id"`
+				}
+				if err := json.Unmarshal([]byte(line), &f); err != nil {
+					t.Fatalf("decode export line: %v", err)
+				}
+				got = append(got, f.ID)
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("exportFlows = %v, want %v", got, tt.want)
+			}
+		})
 	}
 }
 
-func TestExportFlows_MaxRows(t *testing.T) {
+func TestListExpensiveFlows(t *testing.T) {
 	cfg := config.DefaultConfig()
 	cfg.Auth.Token = "test-token"
 
-	mockFlows := createTestFlows(10)
-	ms := &mockStore{flows: mockFlows}
+	dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer dataStore.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+	seed := []struct {
+		id   string
+		cost float64
+		age  time.Duration
+	}{
+		{"flow-a", 0.20, time.Hour},
+		{"flow-b", 3.00, 2 * time.Hour},
+		{"flow-c", 1.10, 3 * time.Hour},
+		{"flow-d", 0.05, 4 * time.Hour},
+		{"flow-old", 50.0, 72 * time.Hour}, // Outside the default 24h window
+	}
+	for _, sd := range seed {
+		f := testutil.NewFlow().WithID(sd.id).Build()
+		f.Timestamp = now.Add(-sd.age)
+		cost := sd.cost
+		f.TotalCost = &cost
+		if err := dataStore.SaveFlow(ctx, f); err != nil {
+			t.Fatalf("SaveFlow(%s): %v", sd.id, err)
+		}
+	}
+	unpriced := testutil.NewFlow().WithID("flow-unpriced").Build()
+	unpriced.Timestamp = now
+	unpriced.TotalCost = nil
+	if err := dataStore.SaveFlow(ctx, unpriced); err != nil {
+		t.Fatalf("SaveFlow: %v", err)
+	}
 
-	server := NewServer(cfg, ms, nil)
+	server := NewServer(cfg, dataStore, nil)
 	handler := server.Handler()
 
-	req := httptest.NewRequest("GET", "/api/flows/export?format=ndjson&max_rows=3", nil)
+	req := httptest.NewRequest("GET", "/api/flows/expensive?limit=3", nil)
 	req.Header.Set("Authorization", "Bearer test-token")
-
 	rr := httptest.NewRecorder()
 	handler.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusOK {
-		t.Fatalf("got status %d, want 200", rr.Code)
+		t.Fatalf("got status %d, body: %s", rr.Code, rr.Body.String())
 	}
-
-	lines := splitNonEmpty(rr.Body.String(), "\n")
-	if len(lines) != 3 {
-		t.Errorf("got %d lines, want 3 (max_rows limit)", len(lines))
+	var flows []FlowSummary
+	if err := json.Unmarshal(rr.Body.Bytes(), &flows); err != nil {
+		t.Fatalf("decode response: %v", err)
 	}
-}
+
+	want := []string{"flow-b", "flow-c", "flow-a"}
+	if len(flows) != len(want) {
+		t.Fatalf("got %d flows, want %d", len(flows), len(want))
+	}
+	for i, id := range want {
+		if flows[i].ID != id {
+			t.Errorf("flows[%d] = %s, want %s", i, flows[i].ID, id)
+		}
+	}
+
+	// An explicit range pulls in older flows
+	start := now.Add(-96 * time.Hour).UTC().Format(time.RFC3339)
+	req = httptest.NewRequest("GET", "/api/flows/expensive?limit=1&start="+start, nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	flows = nil
+	if err := json.Unmarshal(rr.Body.Bytes(), &flows); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(flows) != 1 || flows[0].ID != "flow-old" {
+		t.Errorf("with start=%s got %+v, want flow-old", start, flows)
+	}
+}
+
+func TestFlowTagsAPI(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+
+	dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer dataStore.Close()
+
+	ctx := context.Background()
+	for _, id := range []string{"flow-a", "flow-b"} {
+		if err := dataStore.SaveFlow(ctx, testutil.NewFlow().WithID(id).Build()); err != nil {
+			t.Fatalf("SaveFlow(%s): %v", id, err)
+		}
+	}
+
+	handler := NewServer(cfg, dataStore, nil).Handler()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do("POST", "/api/flows/flow-a/tags", `{"key":"experiment","value":"prompt-v2"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("POST tags: got status %d, body: %s", rr.Code, rr.Body.String())
+	}
+	var tags []FlowTagResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &tags); err != nil {
+		t.Fatalf("decode tags: %v", err)
+	}
+	if len(tags) != 1 || tags[0].Key != "experiment" || tags[0].Value != "prompt-v2" {
+		t.Errorf("tags = %+v, want experiment=prompt-v2", tags)
+	}
+
+	// Filter the flow list by tag
+	rr = do("GET", "/api/flows?tag=experiment=prompt-v2", "")
+	var flows []FlowSummary
+	if err := json.Unmarshal(rr.Body.Bytes(), &flows); err != nil {
+		t.Fatalf("decode flows: %v", err)
+	}
+	if len(flows) != 1 || flows[0].ID != "flow-a" {
+		t.Errorf("tag filter returned %+v, want only flow-a", flows)
+	}
+
+	// Validation
+	if rr := do("POST", "/api/flows/flow-a/tags", `{"key":"a=b"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("key with '=': got status %d, want 400", rr.Code)
+	}
+	if rr := do("POST", "/api/flows/missing/tags", `{"key":"x"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown flow: got status %d, want 404", rr.Code)
+	}
+	if rr := do("DELETE", "/api/flows/flow-a/tags", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("DELETE without key: got status %d, want 400", rr.Code)
+	}
+
+	if rr := do("DELETE", "/api/flows/flow-a/tags?key=experiment", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("DELETE tag: got status %d, want 204", rr.Code)
+	}
+	rr = do("GET", "/api/flows/flow-a/tags", "")
+	tags = nil
+	if err := json.Unmarshal(rr.Body.Bytes(), &tags); err != nil {
+		t.Fatalf("decode tags: %v", err)
+	}
+	if len(tags) != 0 {
+		t.Errorf("tags after delete = %+v, want none", tags)
+	}
+}
+
+// notesRecorder records BroadcastFlowNotes calls.
+type notesRecorder struct {
+	calls []*string
+}
+
+func (n *notesRecorder) BroadcastFlowNotes(flowID string, notes *string) {
+	n.calls = append(n.calls, notes)
+}
+
+func TestFlowNotesAPI(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+
+	dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer dataStore.Close()
+
+	ctx := context.Background()
+	if err := dataStore.SaveFlow(ctx, testutil.NewFlow().WithID("flow-a").Build()); err != nil {
+		t.Fatalf("SaveFlow: %v", err)
+	}
+
+	recorder := &notesRecorder{}
+	handler := NewServer(cfg, dataStore, nil, WithNotesBroadcaster(recorder)).Handler()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	getNotes := func() *string {
+		t.Helper()
+		rr := do("GET", "/api/flows/flow-a", "")
+		var detail FlowDetail
+		if err := json.Unmarshal(rr.Body.Bytes(), &detail); err != nil {
+			t.Fatalf("decode flow: %v", err)
+		}
+		return detail.Notes
+	}
+
+	rr := do("PUT", "/api/flows/flow-a/notes", `{"notes":"this caused the prod incident"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("PUT notes: got status %d, body: %s", rr.Code, rr.Body.String())
+	}
+	if notes := getNotes(); notes == nil || *notes != "this caused the prod incident" {
+		t.Errorf("notes = %v, want the note just set", notes)
+	}
+
+	// Blank notes clear them, and so does null
+	for _, body := range []string{`{"notes":"  "}`, `{"notes":null}`} {
+		if rr := do("PUT", "/api/flows/flow-a/notes", `{"notes":"again"}`); rr.Code != http.StatusOK {
+			t.Fatalf("PUT notes: got status %d", rr.Code)
+		}
+		if rr := do("PUT", "/api/flows/flow-a/notes", body); rr.Code != http.StatusOK {
+			t.Fatalf("PUT %s: got status %d", body, rr.Code)
+		}
+		if notes := getNotes(); notes != nil {
+			t.Errorf("notes after PUT %s = %q, want cleared", body, *notes)
+		}
+	}
+
+	if len(recorder.calls) != 5 {
+		t.Fatalf("broadcasts = %d, want 5", len(recorder.calls))
+	}
+	if recorder.calls[0] == nil || recorder.calls[4] != nil {
+		t.Errorf("broadcast notes = %v ... %v, want set then cleared", recorder.calls[0], recorder.calls[4])
+	}
+
+	// Validation
+	if rr := do("PUT", "/api/flows/missing/notes", `{"notes":"x"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown flow: got status %d, want 404", rr.Code)
+	}
+	long := `{"notes":"` + strings.Repeat("a", maxNotesLen+1) + `"}`
+	if rr := do("PUT", "/api/flows/flow-a/notes", long); rr.Code != http.StatusBadRequest {
+		t.Errorf("long notes: got status %d, want 400", rr.Code)
+	}
+	if len(recorder.calls) != 5 {
+		t.Errorf("failed requests broadcast: %d calls, want 5", len(recorder.calls))
+	}
+}
+
+func TestTaskRetentionAPI(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+
+	dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer dataStore.Close()
+
+	handler := NewServer(cfg, dataStore, nil).Handler()
+	do := func(method, body string) (int, TaskRetentionResponse) {
+		t.Helper()
+		req := httptest.NewRequest(method, "/api/tasks/task-a/retention", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		var resp TaskRetentionResponse
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+		}
+		return rr.Code, resp
+	}
+	global := TaskRetentionResponse{TaskID: "task-a", TTLDays: cfg.Retention.FlowsTTLDays}
+	flagged := TaskRetentionResponse{TaskID: "task-a", TTLDays: 365, Override: true}
+
+	if code, resp := do("GET", ""); code != http.StatusOK || resp != global {
+		t.Errorf("GET before override = %d %+v, want %+v", code, resp, global)
+	}
+	if code, resp := do("PUT", `{"ttl_days":365}`); code != http.StatusOK || resp != flagged {
+		t.Errorf("PUT = %d %+v, want %+v", code, resp, flagged)
+	}
+	if code, resp := do("GET", ""); code != http.StatusOK || resp != flagged {
+		t.Errorf("GET = %d %+v, want %+v", code, resp, flagged)
+	}
+	if days, _ := dataStore.GetTaskRetention(context.Background(), "task-a"); days != 365 {
+		t.Errorf("stored override = %d, want 365", days)
+	}
+
+	for _, body := range []string{`{"ttl_days":-1}`, `{"ttl_days":100000}`, `{"ttl_days":"forever"}`} {
+		if code, _ := do("PUT", body); code != http.StatusBadRequest {
+			t.Errorf("PUT %s: got status %d, want 400", body, code)
+		}
+	}
+
+	// Null and 0 both remove the override
+	for _, body := range []string{`{"ttl_days":null}`, `{"ttl_days":0}`} {
+		do("PUT", `{"ttl_days":365}`)
+		if code, resp := do("PUT", body); code != http.StatusOK || resp != global {
+			t.Errorf("PUT %s = %d %+v, want %+v", body, code, resp, global)
+		}
+	}
+}
+
+func TestScrubOnRead(t *testing.T) {
+	const rawBody = `{"api_key": "sk-ant-REDACTED"}`
+
+	for _, scrub := range []bool{true, false} {
+		t.Run(fmt.Sprintf("scrub_on_read=%v", scrub), func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Auth.Token = "test-token"
+			cfg.Redaction.RedactAPIKeys = true
+			cfg.Redaction.ScrubOnRead = scrub
+
+			dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+			if err != nil {
+				t.Fatalf("NewSQLiteStore: %v", err)
+			}
+			defer dataStore.Close()
+
+			// Stored raw, as the proxy does with scrub_on_read
+			flow := testutil.NewFlow().WithID("flow-raw").WithRequestBody(rawBody).WithResponseBody(rawBody).Build()
+			if err := dataStore.SaveFlow(context.Background(), flow); err != nil {
+				t.Fatalf("SaveFlow: %v", err)
+			}
+
+			handler := NewServer(cfg, dataStore, nil).Handler()
+			get := func(path string) string {
+				t.Helper()
+				req := httptest.NewRequest("GET", path, nil)
+				req.Header.Set("Authorization", "Bearer test-token")
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+				if rr.Code != http.StatusOK {
+					t.Fatalf("GET %s: got status %d, body: %s", path, rr.Code, rr.Body.String())
+				}
+				return rr.Body.String()
+			}
+
+			var detail FlowDetail
+			if err := json.Unmarshal([]byte(get("/api/flows/flow-raw")), &detail); err != nil {
+				t.Fatalf("decode flow: %v", err)
+			}
+			responses := map[string]string{
+				"detail request body":  *detail.RequestBody,
+				"detail response body": *detail.ResponseBody,
+				"request.body":         get("/api/flows/flow-raw/request.body"),
+				"response.body":        get("/api/flows/flow-raw/response.body"),
+				"export":               get("/api/flows/export?include_bodies=true"),
+			}
+			for name, got := range responses {
+				leaked := strings.Contains(got, "abcdefghijklmnopqrstuvwxyz")
+				if scrub && leaked {
+					t.Errorf("%s not scrubbed: %s", name, got)
+				}
+				if !scrub && !leaked {
+					t.Errorf("%s redacted without scrub_on_read: %s", name, got)
+				}
+			}
+		})
+	}
+}
+
+func TestQuotaAPI(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+
+	dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer dataStore.Close()
+
+	ctx := context.Background()
+	remaining, limit := int64(1200), int64(80000)
+	reset := time.Now().Add(time.Minute).UTC().Truncate(time.Second)
+	flow := testutil.NewFlow().WithID("flow-rl").Build()
+	flow.Timestamp = time.Now().Add(-time.Hour)
+	flow.RateLimitTokensLimit = &limit
+	flow.RateLimitTokensRemaining = &remaining
+	flow.RateLimitReset = &reset
+	if err := dataStore.SaveFlow(ctx, flow); err != nil {
+		t.Fatalf("SaveFlow: %v", err)
+	}
+
+	handler := NewServer(cfg, dataStore, nil).Handler()
+	get := func(path string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/api/analytics/quota")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET quota: got status %d, body: %s", rr.Code, rr.Body.String())
+	}
+	var points []QuotaPointResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &points); err != nil {
+		t.Fatalf("decode quota: %v", err)
+	}
+	if len(points) != 1 || points[0].TokensRemaining == nil || *points[0].TokensRemaining != remaining {
+		t.Errorf("quota points = %+v, want one point with tokens_remaining %d", points, remaining)
+	}
+
+	if rr := get("/api/analytics/quota?bucket=week"); rr.Code != http.StatusBadRequest {
+		t.Errorf("bucket=week: got status %d, want 400", rr.Code)
+	}
+
+	rr = get("/api/flows/flow-rl")
+	var detail FlowDetail
+	if err := json.Unmarshal(rr.Body.Bytes(), &detail); err != nil {
+		t.Fatalf("decode flow: %v", err)
+	}
+	if detail.RateLimit == nil || detail.RateLimit.Reset == nil || *detail.RateLimit.Reset != reset.Format(time.RFC3339) {
+		t.Errorf("flow rate_limit = %+v, want reset %s", detail.RateLimit, reset.Format(time.RFC3339))
+	}
+}
+
+func TestErrorStatsAPI(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+
+	dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer dataStore.Close()
+
+	ctx := context.Background()
+	seed := []struct {
+		status    int
+		errorType string
+		count     int
+	}{
+		{200, "", 3},
+		{400, "invalid_request_error", 1},
+		{429, "rate_limit_error", 2},
+		{500, "", 1},
+	}
+	n := 0
+	for _, sd := range seed {
+		for i := 0; i < sd.count; i++ {
+			n++
+			flow := testutil.NewFlow().WithID(fmt.Sprintf("flow-%d", n)).WithProvider("anthropic").WithStatus(sd.status).Build()
+			flow.Timestamp = time.Now().Add(-time.Hour)
+			if sd.errorType != "" {
+				errorType := sd.errorType
+				flow.ErrorType = &errorType
+			}
+			if err := dataStore.SaveFlow(ctx, flow); err != nil {
+				t.Fatalf("SaveFlow: %v", err)
+			}
+		}
+	}
+
+	handler := NewServer(cfg, dataStore, nil).Handler()
+	req := httptest.NewRequest("GET", "/api/analytics/errors", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET errors: got status %d, body: %s", rr.Code, rr.Body.String())
+	}
+
+	var resp ErrorStatsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode errors: %v", err)
+	}
+	if resp.TotalErrors != 4 {
+		t.Errorf("total_errors = %d, want 4", resp.TotalErrors)
+	}
+	want := []ErrorGroupResponse{
+		{Provider: "anthropic", StatusCode: 429, ErrorType: "rate_limit_error", Count: 2},
+		{Provider: "anthropic", StatusCode: 400, ErrorType: "invalid_request_error", Count: 1},
+		{Provider: "anthropic", StatusCode: 500, ErrorType: "", Count: 1},
+	}
+	if len(resp.Groups) != len(want) {
+		t.Fatalf("groups = %+v, want %d groups", resp.Groups, len(want))
+	}
+	for i, w := range want {
+		got := resp.Groups[i]
+		if got.LastSeen.IsZero() {
+			t.Errorf("group %d has no last_seen", i)
+		}
+		got.LastSeen = time.Time{}
+		if got != w {
+			t.Errorf("group %d = %+v, want %+v", i, got, w)
+		}
+	}
+}
+
+func TestBandwidthAPI(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+
+	dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer dataStore.Close()
+
+	ctx := context.Background()
+	hour := time.Now().UTC().Add(-2 * time.Hour).Truncate(time.Hour)
+	for i, sizes := range [][2]int64{{1000, 5000}, {2000, 7000}} {
+		flow := testutil.NewFlow().WithID(fmt.Sprintf("flow-%d", i)).Build()
+		flow.Timestamp = hour.Add(time.Duration(i+1) * time.Minute)
+		flow.RequestBodySize, flow.ResponseBodySize = &sizes[0], &sizes[1]
+		if err := dataStore.SaveFlow(ctx, flow); err != nil {
+			t.Fatalf("SaveFlow: %v", err)
+		}
+	}
+
+	handler := NewServer(cfg, dataStore, nil).Handler()
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/analytics/bandwidth"+query, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET bandwidth: got status %d, body: %s", rr.Code, rr.Body.String())
+	}
+	var resp BandwidthResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode bandwidth: %v", err)
+	}
+	if resp.Bucket != "hour" || resp.TotalRequestBytes != 3000 || resp.TotalResponseBytes != 12000 {
+		t.Errorf("bucket %q, totals %d/%d; want hour, 3000/12000", resp.Bucket, resp.TotalRequestBytes, resp.TotalResponseBytes)
+	}
+	want := BandwidthPointResponse{Period: hour.Format(time.RFC3339), FlowCount: 2, RequestBytes: 3000, ResponseBytes: 12000}
+	if len(resp.Points) != 1 || resp.Points[0] != want {
+		t.Errorf("points = %+v, want [%+v]", resp.Points, want)
+	}
+
+	if rr := get("?bucket=week"); rr.Code != http.StatusBadRequest {
+		t.Errorf("bucket=week: status %d, want 400", rr.Code)
+	}
+}
+
+func TestCacheBreakpointsAPI(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+
+	dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer dataStore.Close()
+
+	ctx := context.Background()
+	breakpoints := 2
+	flow := testutil.NewFlow().WithID("flow-cache").WithCacheTokens(0, 4000).Build()
+	flow.Timestamp = time.Now().Add(-time.Hour)
+	flow.CacheBreakpoints = &breakpoints
+	flow.CacheBreakpointPositions = []string{"system[0]", "messages[3].content[0]"}
+	if err := dataStore.SaveFlow(ctx, flow); err != nil {
+		t.Fatalf("SaveFlow: %v", err)
+	}
+
+	handler := NewServer(cfg, dataStore, nil).Handler()
+	get := func(path string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/api/analytics/cache-breakpoints")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET cache-breakpoints: got status %d, body: %s", rr.Code, rr.Body.String())
+	}
+	var stats []CacheBreakpointStatsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("decode stats: %v", err)
+	}
+	if len(stats) != 1 || stats[0].Breakpoints != 2 || stats[0].CacheHitFlows != 1 || stats[0].HitRate != 1 {
+		t.Errorf("stats = %+v, want one row for 2 breakpoints with a cache hit", stats)
+	}
+
+	rr = get("/api/flows/flow-cache")
+	var detail FlowDetail
+	if err := json.Unmarshal(rr.Body.Bytes(), &detail); err != nil {
+		t.Fatalf("decode flow: %v", err)
+	}
+	if detail.CacheBreakpoints == nil || *detail.CacheBreakpoints != 2 || len(detail.CacheBreakpointPositions) != 2 {
+		t.Errorf("flow breakpoints = %v %v, want 2 with positions", detail.CacheBreakpoints, detail.CacheBreakpointPositions)
+	}
+}
+
+func TestCacheEfficiencyAPI(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+
+	dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer dataStore.Close()
+
+	flow := testutil.NewFlow().WithID("flow-cache").WithTokens(1000, 50).WithCacheTokens(0, 3000).Build()
+	flow.Timestamp = time.Now().Add(-time.Hour)
+	if err := dataStore.SaveFlow(context.Background(), flow); err != nil {
+		t.Fatalf("SaveFlow: %v", err)
+	}
+
+	handler := NewServer(cfg, dataStore, nil).Handler()
+	req := httptest.NewRequest("GET", "/api/analytics/cache", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET cache: got status %d, body: %s", rr.Code, rr.Body.String())
+	}
+	var eff CacheEfficiencyResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &eff); err != nil {
+		t.Fatalf("decode cache efficiency: %v", err)
+	}
+	if eff.FlowCount != 1 || eff.CacheReadTokens != 3000 || eff.HitRatio != 0.75 {
+		t.Errorf("totals = %+v, want 1 flow with 3000 reads, hit ratio 0.75", eff.CacheUsageResponse)
+	}
+	if len(eff.ByModel) != 1 || eff.ByModel[0].Model != "claude-sonnet-4-20250514" || !eff.ByModel[0].Priced {
+		t.Fatalf("by_model = %+v, want one priced claude-sonnet-4 row", eff.ByModel)
+	}
+	if eff.NetSavings <= 0 || eff.NetSavings != eff.ByModel[0].NetSavings {
+		t.Errorf("net_savings = %v, want the model's positive savings %v", eff.NetSavings, eff.ByModel[0].NetSavings)
+	}
+}
+
+func TestDuplicatesAPI(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+
+	dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer dataStore.Close()
+
+	ctx := context.Background()
+	base := time.Now().Add(-time.Hour)
+	for i, sig := range []string{"same", "same", "other"} {
+		signature := sig
+		flow := testutil.NewFlow().WithID(fmt.Sprintf("flow-%d", i)).Build()
+		flow.Timestamp = base.Add(time.Duration(i) * time.Second)
+		flow.RequestSignature = &signature
+		if err := dataStore.SaveFlow(ctx, flow); err != nil {
+			t.Fatalf("SaveFlow: %v", err)
+		}
+	}
+
+	handler := NewServer(cfg, dataStore, nil).Handler()
+	req := httptest.NewRequest("GET", "/api/analytics/duplicates", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET duplicates: got status %d, body: %s", rr.Code, rr.Body.String())
+	}
+
+	var groups []DuplicateGroupResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &groups); err != nil {
+		t.Fatalf("decode groups: %v", err)
+	}
+	if len(groups) != 1 {
+		t.Fatalf("got %d groups, want 1", len(groups))
+	}
+	if g := groups[0]; g.Signature != "same" || g.Count != 2 || len(g.FlowIDs) != 2 || g.FlowIDs[0] != "flow-0" {
+		t.Errorf("group = %+v, want flow-0 and flow-1 under signature same", g)
+	}
+}
+
+func TestCostReconcileAPI(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+
+	dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer dataStore.Close()
+
+	ctx := context.Background()
+	exact, cost := "exact", 0.5
+	flows := []*store.Flow{
+		testutil.NewFlow().WithID("exact").WithTokens(100, 10).Build(),
+		testutil.NewFlow().WithID("no-usage").Build(),
+	}
+	flows[0].TotalCost, flows[0].CostSource = &cost, &exact
+	flows[1].InputTokens, flows[1].OutputTokens = nil, nil
+	for i, f := range flows {
+		f.Timestamp = time.Now().Add(-time.Hour + time.Duration(i)*time.Second)
+		if err := dataStore.SaveFlow(ctx, f); err != nil {
+			t.Fatalf("SaveFlow: %v", err)
+		}
+	}
+
+	handler := NewServer(cfg, dataStore, nil).Handler()
+	req := httptest.NewRequest("GET", "/api/analytics/cost/reconcile", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET reconcile: got status %d, body: %s", rr.Code, rr.Body.String())
+	}
+
+	var rec CostReconciliationResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &rec); err != nil {
+		t.Fatalf("decode reconciliation: %v", err)
+	}
+	if rec.TotalFlows != 2 || rec.TotalCost != 0.5 || rec.Exact.FlowCount != 1 ||
+		rec.Uncosted.FlowCount != 1 || rec.MissingUsage != 1 || rec.Coverage != 0.5 {
+		t.Errorf("reconciliation = %+v", rec)
+	}
+}
+
+func TestWorkspaces(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	openWorkspace := func(name, flowID string) store.Store {
+		t.Helper()
+		dataStore, err := store.NewSQLiteStore(config.WorkspaceDBPath(dir, name), &cfg.Retention)
+		if err != nil {
+			t.Fatalf("NewSQLiteStore(%s): %v", name, err)
+		}
+		t.Cleanup(func() { dataStore.Close() })
+		if err := dataStore.SaveFlow(ctx, testutil.NewFlow().WithID(flowID).Build()); err != nil {
+			t.Fatalf("SaveFlow: %v", err)
+		}
+		return dataStore
+	}
+	defaultStore := openWorkspace(config.DefaultWorkspace, "flow-default")
+	openWorkspace("alpha", "flow-alpha")
+
+	server := NewServer(cfg, defaultStore, nil, WithWorkspaces(dir, config.DefaultWorkspace))
+	defer server.Close()
+	handler := server.Handler()
+	get := func(path string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	listIDs := func(path string) []string {
+		t.Helper()
+		rr := get(path)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET %s: got status %d, body: %s", path, rr.Code, rr.Body.String())
+		}
+		var flows []FlowSummary
+		if err := json.Unmarshal(rr.Body.Bytes(), &flows); err != nil {
+			t.Fatalf("decode %s: %v", path, err)
+		}
+		var ids []string
+		for _, f := range flows {
+			ids = append(ids, f.ID)
+		}
+		return ids
+	}
+
+	for path, want := range map[string]string{
+		"/api/flows":                   "flow-default",
+		"/api/flows?workspace=default": "flow-default",
+		"/api/flows?workspace=alpha":   "flow-alpha",
+	} {
+		if ids := listIDs(path); len(ids) != 1 || ids[0] != want {
+			t.Errorf("GET %s = %v, want [%s]", path, ids, want)
+		}
+	}
+
+	if rr := get("/api/flows/flow-default?workspace=alpha"); rr.Code != http.StatusNotFound {
+		t.Errorf("default flow in alpha: got status %d, want 404", rr.Code)
+	}
+	if rr := get("/api/flows?workspace=missing"); rr.Code != http.StatusNotFound {
+		t.Errorf("missing workspace: got status %d, want 404", rr.Code)
+	}
+	if rr := get("/api/flows?workspace=../etc"); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid workspace name: got status %d, want 400", rr.Code)
+	}
+	if rr := get("/api/flows"); rr.Code != http.StatusOK {
+		t.Errorf("no workspace param: got status %d, want 200", rr.Code)
+	}
+}
+
+func TestFlowBodyDownload(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+
+	dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer dataStore.Close()
+
+	flow := testutil.NewFlow().WithID("flow-body").
+		WithRequestBody(`{"model":"claude-sonnet-4"}`).
+		WithResponseBody("event: message_start\ndata: {}\n\n").Build()
+	flow.RequestHeaders = map[string][]string{"Content-Type": {"application/json"}}
+	flow.ResponseHeaders = map[string][]string{"Content-Type": {"text/event-stream; charset=utf-8"}}
+	flow.ResponseBodyTruncated = true
+	if err := dataStore.SaveFlow(context.Background(), flow); err != nil {
+		t.Fatalf("SaveFlow: %v", err)
+	}
+	bare := testutil.NewFlow().WithID("flow-bare").WithRequestBody("raw").Build()
+	if err := dataStore.SaveFlow(context.Background(), bare); err != nil {
+		t.Fatalf("SaveFlow: %v", err)
+	}
+
+	handler := NewServer(cfg, dataStore, nil).Handler()
+	get := func(path string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	tests := []struct {
+		path          string
+		wantType      string
+		wantBody      string
+		wantTruncated string
+		wantFilename  string
+	}{
+		{"/api/flows/flow-body/request.body", "application/json", `{"model":"claude-sonnet-4"}`, "", "flow-body-request.body"},
+		{"/api/flows/flow-body/response.body", "text/event-stream; charset=utf-8", "event: message_start\ndata: {}\n\n", "true", "flow-body-response.body"},
+		{"/api/flows/flow-bare/request.body", "application/octet-stream", "raw", "", "flow-bare-request.body"},
+	}
+	for _, tt := range tests {
+		rr := get(tt.path)
+		if rr.Code != http.StatusOK {
+			t.Errorf("GET %s: got status %d, body: %s", tt.path, rr.Code, rr.Body.String())
+			continue
+		}
+		if got := rr.Header().Get("Content-Type"); got != tt.wantType {
+			t.Errorf("GET %s: Content-Type = %q, want %q", tt.path, got, tt.wantType)
+		}
+		if got := rr.Body.String(); got != tt.wantBody {
+			t.Errorf("GET %s: body = %q, want %q", tt.path, got, tt.wantBody)
+		}
+		if got := rr.Header().Get("X-Body-Truncated"); got != tt.wantTruncated {
+			t.Errorf("GET %s: X-Body-Truncated = %q, want %q", tt.path, got, tt.wantTruncated)
+		}
+		if got := rr.Header().Get("Content-Disposition"); !strings.Contains(got, `filename="`+tt.wantFilename+`"`) {
+			t.Errorf("GET %s: Content-Disposition = %q, want filename %s", tt.path, got, tt.wantFilename)
+		}
+	}
+
+	if rr := get("/api/flows/flow-bare/response.body"); rr.Code != http.StatusNotFound {
+		t.Errorf("missing body: got status %d, want 404", rr.Code)
+	}
+	if rr := get("/api/flows/nope/request.body"); rr.Code != http.StatusNotFound {
+		t.Errorf("missing flow: got status %d, want 404", rr.Code)
+	}
+}
+
+func TestUpdateSettings(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+	cfgPath := filepath.Join(t.TempDir(), "langley.yaml")
+	var reloaded *ReloadResult
+	handler := NewServer(cfg, storetest.New(), nil, WithConfigPath(cfgPath),
+		WithOnReload(func(r *ReloadResult) { reloaded = r })).Handler()
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("PATCH", "/api/settings", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := patch(`{"body_max_bytes": 4096, "redact_base64_images": false}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("PATCH body_max_bytes: got status %d, body: %s", rr.Code, rr.Body.String())
+	}
+	var settings SettingsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &settings); err != nil {
+		t.Fatalf("decode settings: %v", err)
+	}
+	if settings.BodyMaxBytes != 4096 || settings.RedactBase64Images {
+		t.Errorf("settings = %+v, want body_max_bytes 4096 and base64 redaction off", settings)
+	}
+	if settings.FlowsTTLDays != 30 || !settings.RedactAPIKeys {
+		t.Errorf("settings = %+v, omitted fields should be unchanged", settings)
+	}
+	if cfg.Persistence.BodyMaxBytes != 4096 || cfg.Redaction.RedactBase64Images {
+		t.Error("live config not updated")
+	}
+	if reloaded == nil || reloaded.Redactor == nil {
+		t.Fatal("redaction toggle did not hand over a rebuilt redactor")
+	}
+	if img := `"data:image/png;base64,` + strings.Repeat("A", 200); reloaded.Redactor.RedactBody(img) != img {
+		t.Error("rebuilt redactor still redacts base64 images")
+	}
+	saved, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load saved config: %v", err)
+	}
+	if saved.Persistence.BodyMaxBytes != 4096 {
+		t.Errorf("saved body_max_bytes = %d, want 4096", saved.Persistence.BodyMaxBytes)
+	}
+
+	// A bad value rejects the whole update
+	rr = patch(`{"events_ttl_days": 1, "flows_ttl_days": 0}`)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "flows_ttl_days") {
+		t.Errorf("out-of-range retention: got status %d, body: %s", rr.Code, rr.Body.String())
+	}
+	if cfg.Retention.EventsTTLDays != 7 || cfg.Retention.FlowsTTLDays != 30 {
+		t.Errorf("retention changed by a rejected update: %+v", cfg.Retention)
+	}
+
+	if rr := patch(`{"body_max_bytes": 10}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("body_max_bytes too small: got status %d, want 400", rr.Code)
+	}
+	if rr := patch(`{"db_path": "/tmp/other.db"}`); rr.Code != http.StatusConflict {
+		t.Errorf("restart-only field: got status %d, want 409", rr.Code)
+	}
+	if rr := patch(`{"no_such_setting": 1}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown field: got status %d, want 400", rr.Code)
+	}
+}
+
+// Run with -race: settings updates and reloads write the config that
+// request handling reads.
+func TestUpdateSettings_ConcurrentWithReads(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+	cfgPath := filepath.Join(t.TempDir(), "langley.yaml")
+	if err := cfg.Save(cfgPath); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	server := NewServer(cfg, storetest.New(), nil, WithConfigPath(cfgPath))
+	handler := server.Handler()
+
+	do := func(method, path, body string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			do("PATCH", "/api/settings", fmt.Sprintf(`{"body_max_bytes": %d, "redact_api_keys": %t}`, 4096+i, i%2 == 0))
+		}()
+		go func() {
+			defer wg.Done()
+			do("GET", "/api/settings", "")
+			_ = cfg.Persistence.StoredBodyLimit()
+			_ = cfg.Retention.Snapshot()
+		}()
+		go func() {
+			defer wg.Done()
+			// May read the file mid-save; only the locking is under test
+			_, _ = server.Reload()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestScopedTokens(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+	cfg.Auth.Tokens = []config.ScopedToken{
+		{Name: "dashboard", Hash: config.HashToken("read-token"), Scope: config.ScopeRead},
+		{Name: "ops", Hash: config.HashToken("admin-token"), Scope: config.ScopeAdmin},
+	}
+	cfgPath := filepath.Join(t.TempDir(), "langley.yaml")
+	handler := NewServer(cfg, storetest.New(), nil, WithConfigPath(cfgPath)).Handler()
+
+	do := func(method, path, token, body string) int {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.RemoteAddr = "127.0.0.1:12345"
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	tests := []struct {
+		name         string
+		method, path string
+		token        string
+		body         string
+		want         int
+	}{
+		{"read token lists flows", "GET", "/api/flows", "read-token", "", http.StatusOK},
+		{"read token reads settings", "GET", "/api/settings", "read-token", "", http.StatusOK},
+		{"read token can't vacuum", "POST", "/api/admin/vacuum", "read-token", "", http.StatusForbidden},
+		{"read token can't reload", "POST", "/api/admin/reload", "read-token", "", http.StatusForbidden},
+		{"read token can't change settings", "PATCH", "/api/settings", "read-token", `{"body_max_bytes": 4096}`, http.StatusForbidden},
+		{"read token can't tag", "POST", "/api/flows/f1/tags", "read-token", `{"tag": "x"}`, http.StatusForbidden},
+		{"admin token changes settings", "PATCH", "/api/settings", "admin-token", `{"body_max_bytes": 4096}`, http.StatusOK},
+		{"primary token changes settings", "PATCH", "/api/settings", "test-token", `{"body_max_bytes": 8192}`, http.StatusOK},
+		{"primary token lists flows", "GET", "/api/flows", "test-token", "", http.StatusOK},
+		{"unknown token", "GET", "/api/flows", "nope", "", http.StatusUnauthorized},
+		{"hash is not a token", "GET", "/api/flows", config.HashToken("read-token"), "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := do(tt.method, tt.path, tt.token, tt.body); got != tt.want {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthMiddleware_BrowserHeadersNeedLocalPeer(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+	cfg.Auth.Tokens = []config.ScopedToken{
+		{Name: "dashboard", Hash: config.HashToken("read-token"), Scope: config.ScopeRead},
+	}
+	handler := NewServer(cfg, storetest.New(), nil).Handler()
+
+	tests := []struct {
+		name         string
+		method, path string
+		remote       string
+		headers      map[string]string
+		want         int
+		wantCookie   bool
+	}{
+		{"read token with localhost origin can't tag", "POST", "/api/flows/f1/tags", "127.0.0.1:12345",
+			map[string]string{"Authorization": "Bearer read-token", "Origin": "http://localhost"}, http.StatusForbidden, false},
+		{"remote read token with localhost origin can't tag", "POST", "/api/flows/f1/tags", "192.168.1.20:12345",
+			map[string]string{"Authorization": "Bearer read-token", "Origin": "http://localhost"}, http.StatusForbidden, false},
+		{"remote localhost origin without token", "POST", "/api/flows/f1/tags", "192.168.1.20:12345",
+			map[string]string{"Origin": "http://localhost"}, http.StatusUnauthorized, false},
+		{"remote same-origin fetch without token", "GET", "/api/flows", "192.168.1.20:12345",
+			map[string]string{"Sec-Fetch-Site": "same-origin"}, http.StatusUnauthorized, false},
+		{"unnamed peer outside a unix socket", "GET", "/api/flows", "@",
+			map[string]string{"Origin": "http://localhost"}, http.StatusUnauthorized, false},
+		{"empty peer address", "GET", "/api/flows", "",
+			map[string]string{"Sec-Fetch-Site": "same-origin"}, http.StatusUnauthorized, false},
+		{"local dashboard gets a cookie", "GET", "/api/flows", "127.0.0.1:12345",
+			map[string]string{"Origin": "http://localhost:9091"}, http.StatusOK, true},
+		{"local same-origin fetch gets a cookie", "GET", "/api/flows", "[::1]:12345",
+			map[string]string{"Sec-Fetch-Site": "same-origin"}, http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{"tag": "x"}`))
+			req.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.want {
+				t.Errorf("status = %d, want %d", rr.Code, tt.want)
+			}
+			if got := len(rr.Result().Cookies()) > 0; got != tt.wantCookie {
+				t.Errorf("cookie set = %v, want %v", got, tt.wantCookie)
+			}
+		})
+	}
+}
+
+func TestHealthCheck_UpstreamLimit(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Proxy.MaxConcurrentUpstream = 1
+	cfg.Proxy.UpstreamOverflow = proxy.UpstreamOverflowReject
+	limiter, err := proxy.NewUpstreamLimiter(cfg.Proxy)
+	if err != nil {
+		t.Fatalf("NewUpstreamLimiter: %v", err)
+	}
+	release, err := limiter.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	defer release()
+	if _, err := limiter.Acquire(context.Background()); err == nil {
+		t.Fatal("second Acquire succeeded, want rejection")
+	}
+
+	handler := NewServer(cfg, storetest.New(), nil, WithUpstreamLimiter(limiter)).Handler()
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api/health", nil))
+	var h HealthResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &h); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if h.Upstream == nil || h.Upstream.MaxConcurrent != 1 || h.Upstream.InFlight != 1 || h.Upstream.Rejected != 1 {
+		t.Errorf("upstream = %+v", h.Upstream)
+	}
+}
+
+// fixedThroughput is a ThroughputSource reporting fixed stats.
+type fixedThroughput analytics.ThroughputStats
+
+func (f fixedThroughput) Throughput() analytics.ThroughputStats { return analytics.ThroughputStats(f) }
+
+func TestHealthCheck_Throughput(t *testing.T) {
+	cfg := config.DefaultConfig()
+	stats := analytics.ThroughputStats{InFlight: 2, RequestsLastMinute: 30, AvgLatencyMs: 412.5}
+	handler := NewServer(cfg, storetest.New(), nil, WithThroughputSource(fixedThroughput(stats))).Handler()
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api/health", nil))
+	var h HealthResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &h); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if h.Throughput == nil || *h.Throughput != stats {
+		t.Errorf("throughput = %+v, want %+v", h.Throughput, stats)
+	}
+}
+
+func TestHealthCheck_CaptureFailures(t *testing.T) {
+	cfg := config.DefaultConfig()
+	monitor := proxy.NewCaptureMonitor()
+	handler := NewServer(cfg, storetest.New(), nil, WithCaptureMonitor(monitor)).Handler()
+
+	getHealth := func() HealthResponse {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api/health", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status = %d, body: %s", rr.Code, rr.Body.String())
+		}
+		var resp HealthResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return resp
+	}
+	record := func(n int, err error) {
+		for i := 0; i < n; i++ {
+			monitor.Record(err)
+		}
+	}
+
+	record(100, nil)
+	if h := getHealth(); h.Status != "ok" || h.Capture == nil || h.Capture.Samples != 100 {
+		t.Fatalf("healthy: %+v", h)
+	}
+
+	diskFull := errors.New("database or disk is full")
+	record(20, diskFull)
+	if h := getHealth(); h.Status != "degraded" || !strings.Contains(h.Warning, "disk is full") {
+		t.Fatalf("some failures: status = %s, warning = %q", h.Status, h.Warning)
+	}
+
+	record(50, diskFull)
+	if h := getHealth(); h.Status != "error" || h.Capture.FailureRate < 0.5 {
+		t.Fatalf("sustained failures: status = %s, capture = %+v", h.Status, h.Capture)
+	}
+
+	// Store heals
+	record(100, nil)
+	h := getHealth()
+	if h.Status != "ok" || h.Warning != "" || h.Capture.TotalFailures != 70 {
+		t.Fatalf("recovered: status = %s, warning = %q, capture = %+v", h.Status, h.Warning, h.Capture)
+	}
+}
+
+func TestCORS_ConfiguredOrigins(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+	cfg.API.CORSOrigins = []string{"http://langley.internal:9091", "*.corp.example"}
+	if err := cfg.API.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	handler := NewServer(cfg, storetest.New(), nil).Handler()
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"http://localhost:5173", true}, // Built-in allowance is kept
+		{"http://langley.internal:9091", true},
+		{"https://dash.corp.example:8443", true},
+		{"http://langley.internal:8080", false}, // Exact origins include the port
+		{"https://corp.example", false},
+		{"https://evil.example", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodOptions, "/api/flows", nil)
+			req.Header.Set("Origin", tt.origin)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			got := rec.Header().Get("Access-Control-Allow-Origin")
+			if tt.want && got != tt.origin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.origin)
+			}
+			if !tt.want && got != "" {
+				t.Errorf("Access-Control-Allow-Origin = %q, want none", got)
+			}
+		})
+	}
+}
+
+func TestAuthMiddleware_ConfiguredOriginNeedsToken(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+	cfg.API.CORSOrigins = []string{"http://langley.internal:9091"}
+	handler := NewServer(cfg, storetest.New(), nil).Handler()
+
+	get := func(origin, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/flows", nil)
+		req.RemoteAddr = "127.0.0.1:40000"
+		req.Header.Set("Origin", origin)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A listed origin gets CORS headers but no cookie, even from this machine
+	rec := get("http://langley.internal:9091", "")
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("listed origin without token: status = %d, want 401", rec.Code)
+	}
+	if c := rec.Header().Get("Set-Cookie"); c != "" {
+		t.Errorf("listed origin got a session cookie: %s", c)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "http://langley.internal:9091" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the listed origin", got)
+	}
+	if rec := get("http://langley.internal:9091", "test-token"); rec.Code != http.StatusOK {
+		t.Errorf("listed origin with token: status = %d, want 200", rec.Code)
+	}
+
+	rec = get("http://localhost:5173", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Header().Get("Set-Cookie"), sessionCookieName) {
+		t.Errorf("localhost origin: status = %d, Set-Cookie = %q; want 200 and the session cookie", rec.Code, rec.Header().Get("Set-Cookie"))
+	}
+}
+
+func TestAPIConfig_ValidateCORSOrigins(t *testing.T) {
+	for _, origin := range []string{"*", "*.", "langley.internal", "ftp://langley.internal", "http://langley.internal/app", "*.internal:9091"} {
+		cfg := config.APIConfig{CORSOrigins: []string{origin}}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate(%q) = nil, want error", origin)
+		}
+	}
+}
+
+func TestIsLocalhost(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"127.0.0.1:8080", true},
+		{"127.0.0.1", true},
+		{"localhost:8080", true},
+		{"localhost", true},
+		{"[::1]:8080", true},
+		{"::1", true},
+		{"@", false}, // Unix socket peers are told apart by UnixConnContext
+		{"", false},
+		{"192.168.1.1:8080", false},
+		{"10.0.0.1:8080", false},
+		{"8.8.8.8:8080", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			got := isLocalhost(tt.addr)
+			if got != tt.want {
+				t.Errorf("isLocalhost(%q) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsLocalRequest_UnixSocket(t *testing.T) {
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "s.sock"))
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	defer ln.Close()
+	conn, err := net.Dial("unix", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	pipe, _ := net.Pipe()
+	defer pipe.Close()
+
+	req := httptest.NewRequest("GET", "/api/flows", nil)
+	req.RemoteAddr = "@"
+	if isLocalRequest(req) {
+		t.Error("unmarked request from @ is local, want not")
+	}
+	if isLocalRequest(req.WithContext(UnixConnContext(req.Context(), pipe))) {
+		t.Error("request over a non-unix conn is local, want not")
+	}
+	if !isLocalRequest(req.WithContext(UnixConnContext(req.Context(), conn))) {
+		t.Error("request over a unix socket is not local")
+	}
+}
+
+func TestExportFlows_NDJSON(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+
+	mockFlows := createTestFlows(3)
+	ms := storetest.New(storetest.WithFlows(mockFlows...))
+
+	server := NewServer(cfg, ms, nil)
+	handler := server.Handler()
+
+	req := httptest.NewRequest("GET", "/api/flows/export?format=ndjson", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200, body: %s", rr.Code, rr.Body.String())
+	}
+
+	if ct := rr.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q, want application/x-ndjson", ct)
+	}
+
+	// Should have 3 lines (one per flow)
+	lines := splitNonEmpty(rr.Body.String(), "\n")
+	if len(lines) != 3 {
+		t.Errorf("got %d lines, want 3", len(lines))
+	}
+}
+
+func TestExportFlows_JSON(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+
+	mockFlows := createTestFlows(2)
+	ms := storetest.New(storetest.WithFlows(mockFlows...))
+
+	server := NewServer(cfg, ms, nil)
+	handler := server.Handler()
+
+	req := httptest.NewRequest("GET", "/api/flows/export?format=json", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", rr.Code)
+	}
+
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+
+	// Parse JSON response
+	var result map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatalf("failed to parse JSON: %v", err)
+	}
+
+	flows, ok := result["flows"].([]interface{})
+	if !ok {
+		t.Fatal("missing 'flows' array")
+	}
+	if len(flows) != 2 {
+		t.Errorf("got %d flows, want 2", len(flows))
+	}
+
+	meta, ok := result["meta"].(map[string]interface{})
+	if !ok {
+		t.Fatal("missing 'meta' object")
+	}
+	if meta["row_count"].(float64) != 2 {
+		t.Errorf("row_count = %v, want 2", meta["row_count"])
+	}
+}
+
+func TestExportFlows_CSV(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+
+	mockFlows := createTestFlows(2)
+	ms := storetest.New(storetest.WithFlows(mockFlows...))
+
+	server := NewServer(cfg, ms, nil)
+	handler := server.Handler()
+
+	req := httptest.NewRequest("GET", "/api/flows/export?format=csv", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", rr.Code)
+	}
+
+	if ct := rr.Header().Get("Content-Type"); ct != "text/csv" {
+		t.Errorf("Content-Type = %q, want text/csv", ct)
+	}
+
+	// Should have header + 2 data rows
+	lines := splitNonEmpty(rr.Body.String(), "\n")
+	if len(lines) != 3 {
+		t.Errorf("got %d lines, want 3 (header + 2 rows)", len(lines))
+	}
+}
+
+func TestExportFlows_MaxRows(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+
+	mockFlows := createTestFlows(10)
+	ms := storetest.New(storetest.WithFlows(mockFlows...))
+
+	server := NewServer(cfg, ms, nil)
+	handler := server.Handler()
+
+	req := httptest.NewRequest("GET", "/api/flows/export?format=ndjson&max_rows=3", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", rr.Code)
+	}
+
+	lines := splitNonEmpty(rr.Body.String(), "\n")
+	if len(lines) != 3 {
+		t.Errorf("got %d lines, want 3 (max_rows limit)", len(lines))
+	}
+}
+
+func TestExportFlows_ResumeFromCursor(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+
+	// Flows b and c share a timestamp, so the cursor's ID breaks the tie
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	var flows []*store.Flow
+	for i, offset := range []time.Duration{0, time.Minute, time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		f := testutil.NewFlow().WithID(fmt.Sprintf("flow-%c", 'a'+i)).Build()
+		f.Timestamp = base.Add(offset).Add(123 * time.Millisecond)
+		flows = append(flows, f)
+	}
+	ms := storetest.New(storetest.WithFlows(flows...))
+	handler := NewServer(cfg, ms, nil).Handler()
+
+	export := func(query string) (ids []string, cursor string) {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/flows/export?format=ndjson&max_rows=2&"+query, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("export %q: status %d, body: %s", query, rr.Code, rr.Body.String())
+		}
+		for _, line := range splitNonEmpty(rr.Body.String(), "\n") {
+			var row ExportFlowSummary
+			if err := json.Unmarshal([]byte(line), &row); err != nil {
+				t.Fatalf("decoding row: %v", err)
+			}
+			ids = append(ids, row.ID)
+		}
+		return ids, rr.Result().Trailer.Get(exportCursorTrailer)
+	}
+
+	var got []string
+	ids, cursor := export("")
+	got = append(got, ids...)
+
+	// A flow saved mid-export is newer than the cursor, so it doesn't shift
+	// the later pages the way an offset would
+	newer := testutil.NewFlow().WithID("flow-new").Build()
+	newer.Timestamp = time.Now()
+	if err := ms.SaveFlow(context.Background(), newer); err != nil {
+		t.Fatalf("SaveFlow: %v", err)
+	}
+	for cursor != "" {
+		ids, cursor = export(cursor)
+		got = append(got, ids...)
+		if len(got) > 10 {
+			t.Fatalf("export didn't finish: %v", got)
+		}
+	}
+	if want := "flow-e,flow-d,flow-c,flow-b,flow-a"; strings.Join(got, ",") != want {
+		t.Errorf("resumed export = %v, want %s", got, want)
+	}
+
+	// A client cut off mid-stream resumes from the last row it received
+	if ids, _ := export("after_id=flow-c"); strings.Join(ids, ",") != "flow-b,flow-a" {
+		t.Errorf("after_id=flow-c: got %v, want flow-b, flow-a", ids)
+	}
+
+	req := httptest.NewRequest("GET", "/api/flows/export?after_id=deleted", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown after_id without after_timestamp: status %d, want 400", rr.Code)
+	}
+}
+
+func TestExportFlows_IncludeEvents(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	streamed := testutil.NewFlow().WithID("flow-streamed").Build()
+	streamed.Timestamp = base.Add(2 * time.Minute)
+	long := testutil.NewFlow().WithID("flow-long").Build()
+	long.Timestamp = base.Add(time.Minute)
+	quiet := testutil.NewFlow().WithID("flow-quiet").Build()
+	quiet.Timestamp = base
+	ms := storetest.New(storetest.WithFlows(streamed, long, quiet))
+
+	saveEvents := func(flowID string, n int) {
+		t.Helper()
+		events := make([]*store.Event, n)
+		for i := range events {
+			events[i] = &store.Event{ID: fmt.Sprintf("%s-ev-%d", flowID, i), FlowID: flowID, Sequence: i,
+				Timestamp: base.Add(time.Duration(i) * time.Millisecond), EventType: "content_block_delta", Priority: "medium"}
+		}
+		if err := ms.SaveEvents(context.Background(), events); err != nil {
+			t.Fatalf("SaveEvents: %v", err)
+		}
+	}
+	saveEvents("flow-streamed", 3)
+	saveEvents("flow-long", MaxExportEventsPerFlow+5)
+	handler := NewServer(cfg, ms, nil).Handler()
+
+	export := func(query string) string {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/flows/export?"+query, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("export %q: status %d, body: %s", query, rr.Code, rr.Body.String())
+		}
+		return rr.Body.String()
+	}
+
+	checkRows := func(format string, rows []ExportFlowWithEvents) {
+		t.Helper()
+		if len(rows) != 3 {
+			t.Fatalf("%s: got %d rows, want 3", format, len(rows))
+		}
+		streamedRow, longRow, quietRow := rows[0], rows[1], rows[2]
+		if streamedRow.ID != "flow-streamed" || len(streamedRow.Events) != 3 || streamedRow.EventsTruncated {
+			t.Errorf("%s: streamed row = %s with %d events (truncated %v), want 3 events",
+				format, streamedRow.ID, len(streamedRow.Events), streamedRow.EventsTruncated)
+		} else if streamedRow.Events[2].Sequence != 2 || streamedRow.Events[2].EventType != "content_block_delta" {
+			t.Errorf("%s: last event = %+v, want sequence 2 content_block_delta", format, streamedRow.Events[2])
+		}
+		if len(longRow.Events) != MaxExportEventsPerFlow || !longRow.EventsTruncated {
+			t.Errorf("%s: long row has %d events (truncated %v), want %d, truncated",
+				format, len(longRow.Events), longRow.EventsTruncated, MaxExportEventsPerFlow)
+		}
+		if quietRow.Events == nil || len(quietRow.Events) != 0 {
+			t.Errorf("%s: quiet row events = %v, want an empty list", format, quietRow.Events)
+		}
+	}
+
+	var ndjsonRows []ExportFlowWithEvents
+	for _, line := range splitNonEmpty(export("format=ndjson&include_events=true"), "\n") {
+		var row ExportFlowWithEvents
+		if err := json.Unmarshal([]byte(line), &row); err != nil {
+			t.Fatalf("decoding row: %v", err)
+		}
+		ndjsonRows = append(ndjsonRows, row)
+	}
+	checkRows("ndjson", ndjsonRows)
+
+	var doc struct {
+		Flows []ExportFlowWithEvents `json:"flows"`
+	}
+	if err := json.Unmarshal([]byte(export("format=json&include_events=true")), &doc); err != nil {
+		t.Fatalf("decoding json export: %v", err)
+	}
+	checkRows("json", doc.Flows)
+
+	// Without the option rows carry no events
+	for _, query := range []string{"format=ndjson", "format=json"} {
+		if body := export(query); strings.Contains(body, `"events"`) {
+			t.Errorf("%s without include_events: body has events: %s", query, body)
+		}
+	}
+}
 
 // Helper to create test flows using testutil fixtures
 func createTestFlows(n int) []*store.Flow {
@@ -456,3 +2394,190 @@ func indexOf(s, substr string) int {
 	}
 	return -1
 }
+
+func TestCACertEndpoint(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+	ca, err := langleytls.LoadOrCreateCA(t.TempDir())
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA: %v", err)
+	}
+
+	// No Authorization header: the certificate is public
+	handler := NewServer(cfg, storetest.New(), nil, WithCA(ca)).Handler()
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api/ca.crt", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET /api/ca.crt: got status %d, body: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/x-pem-file" {
+		t.Errorf("Content-Type = %q, want application/x-pem-file", ct)
+	}
+	if cd := rr.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment;") || !strings.Contains(cd, ".crt") {
+		t.Errorf("Content-Disposition = %q, want a .crt attachment", cd)
+	}
+
+	block, _ := pem.Decode(rr.Body.Bytes())
+	if block == nil || block.Type != "CERTIFICATE" {
+		t.Fatalf("body is not a PEM certificate: %q", rr.Body.String())
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+	if !cert.Equal(ca.Certificate()) || !cert.IsCA {
+		t.Errorf("served certificate %q is not the proxy CA", cert.Subject)
+	}
+
+	// Without a CA there is nothing to serve
+	rr = httptest.NewRecorder()
+	NewServer(cfg, storetest.New(), nil).Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/api/ca.crt", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("GET /api/ca.crt without a CA: got status %d, want 404", rr.Code)
+	}
+}
+
+func TestFlowCurlEndpoint(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+	st := storetest.New()
+	ctx := context.Background()
+
+	flow := testutil.NewFlow().WithID("flow-curl").
+		WithRequestBody(`{"model":"claude-sonnet-4","messages":[{"role":"user","content":"it's"}]}`).
+		Build()
+	flow.RequestHeaders = map[string][]string{
+		"Authorization":     {"[REDACTED]"},
+		"X-Api-Key":         {"[REDACTED]"},
+		"Content-Type":      {"application/json"},
+		"Anthropic-Version": {"2023-06-01"},
+		"Content-Length":    {"80"},
+	}
+	flow.RequestHeaderOrder = []string{"content-type", "authorization", "x-api-key", "anthropic-version", "content-length"}
+	flow.RequestBodyTruncated = true
+	if err := st.SaveFlow(ctx, flow); err != nil {
+		t.Fatalf("SaveFlow: %v", err)
+	}
+
+	handler := NewServer(cfg, st, nil).Handler()
+	req := httptest.NewRequest("GET", "/api/flows/flow-curl/curl", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET curl: got status %d, body: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q, want text/plain", ct)
+	}
+
+	cmd := rr.Body.String()
+	for _, want := range []string{
+		"curl -X 'POST' 'https://api.anthropic.com/v1/messages'",
+		`-H 'Authorization: '"${AUTHORIZATION}"`,
+		`-H 'X-Api-Key: '"${API_KEY}"`,
+		`-H 'Content-Type: application/json'`,
+		`--data-raw '{"model":"claude-sonnet-4","messages":[{"role":"user","content":"it'\''s"}]}'`,
+		"# The request body was truncated",
+		"$AUTHORIZATION, $API_KEY",
+	} {
+		if !strings.Contains(cmd, want) {
+			t.Errorf("command missing %q:\n%s", want, cmd)
+		}
+	}
+	if strings.Contains(cmd, "[REDACTED]") || strings.Contains(cmd, "Content-Length") {
+		t.Errorf("command has redacted values or Content-Length:\n%s", cmd)
+	}
+	// Headers follow the order the client sent them
+	if strings.Index(cmd, "Content-Type") > strings.Index(cmd, "Authorization") {
+		t.Errorf("headers out of request order:\n%s", cmd)
+	}
+
+	req = httptest.NewRequest("GET", "/api/flows/missing/curl", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("GET curl for a missing flow: got status %d, want 404", rr.Code)
+	}
+}
+
+func TestStatsFallbackWithoutSQL(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+
+	cost := 0.5
+	a := testutil.NewFlow().WithID("flow-a").WithModel("claude-sonnet-4").WithTaskID("task-1").WithTokens(100, 10).Build()
+	a.TotalCost = &cost
+	b := testutil.NewFlow().WithID("flow-b").WithModel("claude-sonnet-4").WithTaskID("task-1").WithTokens(200, 20).Build()
+	b.TotalCost = &cost
+	handler := NewServer(cfg, storetest.New(storetest.WithFlows(a, b)), nil).Handler()
+
+	req := httptest.NewRequest("GET", "/api/stats", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET /api/stats: got status %d, body: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("X-Analytics-Fallback") != "true" {
+		t.Error("X-Analytics-Fallback header not set")
+	}
+	var stats OverallStatsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("decoding stats: %v", err)
+	}
+	if !stats.Fallback || stats.TotalFlows != 2 || stats.TotalCost != 1 || stats.TotalTokensIn != 300 || stats.TotalTasks != 1 {
+		t.Errorf("stats = %+v, want fallback totals for 2 flows, $1, 300 tokens in, 1 task", stats)
+	}
+
+	req = httptest.NewRequest("GET", "/api/analytics/cost/model", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET /api/analytics/cost/model: got status %d, body: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("X-Analytics-Fallback") != "true" {
+		t.Error("X-Analytics-Fallback header not set on cost breakdown")
+	}
+	if !strings.Contains(rr.Body.String(), `"claude-sonnet-4"`) {
+		t.Errorf("cost by model missing the flows' model: %s", rr.Body.String())
+	}
+}
+
+func TestFacetsEndpoint(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+
+	handler := NewServer(cfg, storetest.New(storetest.WithFlows(
+		testutil.NewFlow().WithID("a").WithModel("claude-sonnet-4").WithTaskID("task-1").Build(),
+		testutil.NewFlow().WithID("b").WithModel("claude-sonnet-4").WithTaskID("task-2").Build(),
+		testutil.NewFlow().WithID("c").WithProvider("openai").WithModel("gpt-4o").Build(),
+	)), nil).Handler()
+
+	req := httptest.NewRequest("GET", "/api/facets?task_limit=1", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, body: %s", rr.Code, rr.Body.String())
+	}
+
+	var facets FacetsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &facets); err != nil {
+		t.Fatalf("decoding facets: %v", err)
+	}
+	if len(facets.Models) != 2 || facets.Models[0].Value != "claude-sonnet-4" || facets.Models[0].Count != 2 {
+		t.Errorf("models = %+v, want claude-sonnet-4 (2) first of 2", facets.Models)
+	}
+	if len(facets.Providers) != 2 {
+		t.Errorf("providers = %+v, want 2", facets.Providers)
+	}
+	if len(facets.Hosts) == 0 {
+		t.Error("no hosts listed")
+	}
+	if len(facets.Tasks) != 1 {
+		t.Errorf("tasks = %+v, want 1 with task_limit=1", facets.Tasks)
+	}
+}
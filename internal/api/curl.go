@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/HakAl/langley/internal/redact"
+	"github.com/HakAl/langley/internal/store"
+)
+
+// curlSkipHeaders are request headers left out of generated curl commands:
+// curl sets them itself from the URL and body, or they only applied to the
+// hop to the proxy.
+var curlSkipHeaders = map[string]bool{
+	"Host":                true,
+	"Content-Length":      true,
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Transfer-Encoding":   true,
+	"Proxy-Connection":    true,
+	"Proxy-Authorization": true,
+	"Accept-Encoding":     true, // Would get a compressed response curl doesn't decode
+}
+
+// getFlowCurl returns a curl command that replays a flow's request, for bug
+// reports. Redacted headers become shell variables to fill in.
+func (s *Server) getFlowCurl(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	id := r.PathValue("id")
+	flow, err := s.store.GetFlow(ctx, id)
+	if err != nil {
+		s.logger.Error("failed to get flow", "id", id, "error", err)
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Not found")
+		return
+	}
+	s.scrubFlow(flow)
+
+	headers := http.Header(flow.RequestHeaders)
+	if redactor := s.redactor.Load(); redactor != nil {
+		headers = redactor.RedactHeaders(headers) // Under the current rules, too
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, _ = fmt.Fprintln(w, buildCurlCommand(flow, headers))
+}
+
+// buildCurlCommand renders flow's request as a curl command line, with
+// headers taken from headers. Header values of [REDACTED] are replaced with
+// a variable named after the header, e.g. $API_KEY for x-api-key, and a
+// comment lists the variables to set. Truncated or missing bodies are noted
+// in a comment too.
+func buildCurlCommand(flow *store.Flow, headers http.Header) string {
+	var notes []string
+	var vars []string
+
+	target := flow.URL
+	if u, err := url.Parse(target); err != nil || !u.IsAbs() {
+		target = "https://" + flow.Host + flow.Path
+		if err == nil && u.RawQuery != "" {
+			target += "?" + u.RawQuery
+		}
+	}
+
+	first := "curl"
+	if flow.Method != http.MethodGet || flow.RequestBody != nil {
+		first += " -X " + shellQuote(flow.Method)
+	}
+	args := []string{first + " " + shellQuote(target)}
+
+	for _, name := range curlHeaderOrder(flow.RequestHeaderOrder, headers) {
+		if curlSkipHeaders[http.CanonicalHeaderKey(name)] {
+			continue
+		}
+		for _, value := range headers[name] {
+			if value == redact.RedactedValue {
+				v := curlPlaceholder(name)
+				if !slices.Contains(vars, "$"+v) {
+					vars = append(vars, "$"+v)
+				}
+				args = append(args, "-H "+shellQuote(name+": ")+`"${`+v+`}"`)
+				continue
+			}
+			args = append(args, "-H "+shellQuote(name+": "+value))
+		}
+	}
+
+	switch {
+	case flow.RequestBody != nil:
+		args = append(args, "--data-raw "+shellQuote(*flow.RequestBody))
+		if flow.RequestBodyTruncated {
+			notes = append(notes, "# The request body was truncated when captured; this sends only the stored part.")
+		}
+	case flow.Method != http.MethodGet && flow.Method != http.MethodHead:
+		notes = append(notes, "# The request body was not stored; add it with --data-raw.")
+	}
+
+	if len(vars) > 0 {
+		notes = append(notes, "# Set "+strings.Join(vars, ", ")+" to the redacted header values before running.")
+	}
+	cmd := strings.Join(args, " \\\n  ")
+	if len(notes) > 0 {
+		return strings.Join(notes, "\n") + "\n" + cmd
+	}
+	return cmd
+}
+
+// curlHeaderOrder returns the keys of headers in the order the client sent
+// them, then any others sorted.
+func curlHeaderOrder(order []string, headers http.Header) []string {
+	keys := make(map[string]string, len(headers)) // Canonical name to key
+	for key := range headers {
+		keys[http.CanonicalHeaderKey(key)] = key
+	}
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range order {
+		key, ok := keys[http.CanonicalHeaderKey(name)]
+		if ok && !seen[key] {
+			seen[key] = true
+			names = append(names, key)
+		}
+	}
+	var rest []string
+	for key := range headers {
+		if !seen[key] {
+			rest = append(rest, key)
+		}
+	}
+	slices.Sort(rest)
+	return append(names, rest...)
+}
+
+// curlPlaceholder names the shell variable standing in for a redacted
+// header: upper case, dashes as underscores, without an X- prefix.
+func curlPlaceholder(header string) string {
+	name := strings.ToUpper(strings.ReplaceAll(header, "-", "_"))
+	return strings.TrimPrefix(name, "X_")
+}
+
+// shellQuote single-quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
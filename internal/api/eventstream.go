@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+// eventStreamPing is how often an idle event stream sends a keep-alive comment.
+const eventStreamPing = 15 * time.Second
+
+// EventSource delivers SSE events for a flow as the proxy parses them.
+// The channel is closed when the flow completes. ws.Hub implements it.
+type EventSource interface {
+	SubscribeEvents(flowID string) (<-chan *store.Event, func())
+}
+
+// WithEventSource enables live event streams at /api/flows/{id}/events/stream.
+func WithEventSource(src EventSource) ServerOption {
+	return func(s *Server) {
+		s.events = src
+	}
+}
+
+// streamFlowEvents relays a flow's events as Server-Sent Events: the stored
+// events first, then new ones as the proxy emits them. The stream ends after
+// message_stop, when the flow completes, or when the client goes away.
+func (s *Server) streamFlowEvents(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "Missing flow ID")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Streaming unsupported")
+		return
+	}
+
+	// Subscribe before reading the store so no event falls in between;
+	// replayed events are skipped when they arrive live too
+	var live <-chan *store.Event
+	if s.events != nil {
+		var unsubscribe func()
+		live, unsubscribe = s.events.SubscribeEvents(id)
+		defer unsubscribe()
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	flow, err := s.store.GetFlow(ctx, id)
+	if err != nil {
+		cancel()
+		s.logger.Error("failed to get flow", "id", id, "error", err)
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Not found")
+		return
+	}
+	events, err := s.store.GetEventsByFlow(ctx, id)
+	cancel()
+	if err != nil {
+		s.logger.Error("failed to get events", "flow_id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+	if flow.StatusCode != nil || flow.FlowIntegrity == "interrupted" {
+		live = nil // Already finished, nothing more will arrive
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	last := -1
+	for _, e := range events {
+		if err := writeSSEEvent(w, e); err != nil {
+			return
+		}
+		last = e.Sequence
+		if e.EventType == "message_stop" {
+			flusher.Flush()
+			return
+		}
+	}
+	flusher.Flush()
+	if live == nil {
+		return
+	}
+
+	ping := time.NewTicker(eventStreamPing)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ping.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case e, ok := <-live:
+			if !ok {
+				return
+			}
+			if e.Sequence <= last {
+				continue
+			}
+			if err := writeSSEEvent(w, e); err != nil {
+				return
+			}
+			flusher.Flush()
+			last = e.Sequence
+			if e.EventType == "message_stop" {
+				return
+			}
+		}
+	}
+}
+
+// writeSSEEvent writes one event in text/event-stream framing, with the
+// sequence number as its id and the EventResponse JSON as its data.
+func writeSSEEvent(w http.ResponseWriter, e *store.Event) error {
+	data, err := json.Marshal(toEventResponse(e))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Sequence, e.EventType, data)
+	return err
+}
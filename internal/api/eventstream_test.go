@@ -0,0 +1,171 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/config"
+	"github.com/HakAl/langley/internal/proxy"
+	"github.com/HakAl/langley/internal/redact"
+	"github.com/HakAl/langley/internal/store"
+	langleytls "github.com/HakAl/langley/internal/tls"
+	"github.com/HakAl/langley/internal/ws"
+)
+
+func TestStreamFlowEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+	cfg.Proxy.InterceptHosts = []string{"127.0.0.1"} // The upstream is a loopback test server
+	dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer dataStore.Close()
+
+	hub := ws.NewHub(cfg, nil)
+	go hub.Run(ctx)
+	apiSrv := httptest.NewServer(NewServer(cfg, dataStore, nil, WithEventSource(hub)).Handler())
+	defer apiSrv.Close()
+
+	// Upstream streams its events once the test is subscribed
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-release
+		for _, ev := range []string{
+			`event: message_start` + "\n" + `data: {"type":"message_start","message":{"model":"claude-sonnet-4-20250514","usage":{"input_tokens":5}}}`,
+			`event: content_block_delta` + "\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}`,
+			`event: message_delta` + "\n" + `data: {"type":"message_delta","usage":{"output_tokens":1}}`,
+			`event: message_stop` + "\n" + `data: {"type":"message_stop"}`,
+		} {
+			fmt.Fprint(w, ev+"\n\n")
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer upstream.Close()
+
+	ca, err := langleytls.LoadOrCreateCA(t.TempDir())
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA: %v", err)
+	}
+	redactor, _ := redact.New(&config.RedactionConfig{})
+	flowIDs := make(chan string, 1)
+	mitm, err := proxy.NewMITMProxy(proxy.MITMProxyConfig{
+		Config:    cfg,
+		CA:        ca,
+		CertCache: langleytls.NewCertCache(ca, 10),
+		Redactor:  redactor,
+		Store:     dataStore,
+		OnFlow:    func(f *store.Flow) { flowIDs <- f.ID },
+		OnUpdate:  hub.BroadcastFlowComplete,
+		OnEvent:   hub.BroadcastEvent,
+	})
+	if err != nil {
+		t.Fatalf("NewMITMProxy: %v", err)
+	}
+	proxySrv := httptest.NewServer(mitm)
+	defer proxySrv.Close()
+
+	proxyURL, _ := url.Parse(proxySrv.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	go func() {
+		resp, err := client.Post(upstream.URL+"/v1/messages", "application/json", strings.NewReader(`{"stream":true}`))
+		if err == nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}()
+
+	var flowID string
+	select {
+	case flowID = <-flowIDs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxied flow never started")
+	}
+
+	req, _ := http.NewRequest("GET", apiSrv.URL+"/api/flows/"+flowID+"/events/stream", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET events/stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d, Content-Type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	close(release)
+
+	// The stream ends by itself after message_stop
+	done := make(chan struct{})
+	var types []string
+	var seqs []int
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if v, ok := strings.CutPrefix(line, "event: "); ok {
+				types = append(types, v)
+			}
+			if v, ok := strings.CutPrefix(line, "id: "); ok {
+				n, _ := strconv.Atoi(v)
+				seqs = append(seqs, n)
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event stream did not end after message_stop")
+	}
+
+	want := []string{"message_start", "content_block_delta", "message_delta", "message_stop"}
+	if strings.Join(types, ",") != strings.Join(want, ",") {
+		t.Errorf("event types = %v, want %v", types, want)
+	}
+	for i := 1; i < len(seqs); i++ {
+		if seqs[i] <= seqs[i-1] {
+			t.Errorf("sequences not increasing: %v", seqs)
+			break
+		}
+	}
+
+	// A finished flow replays its stored events and ends
+	time.Sleep(100 * time.Millisecond)
+	req, _ = http.NewRequest("GET", apiSrv.URL+"/api/flows/"+flowID+"/events/stream", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	replay, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET events/stream (replay): %v", err)
+	}
+	body, _ := io.ReadAll(replay.Body)
+	replay.Body.Close()
+	if got := strings.Count(string(body), "event: "); got != len(want) {
+		t.Errorf("replay sent %d events, want %d:\n%s", got, len(want), body)
+	}
+
+	req, _ = http.NewRequest("GET", apiSrv.URL+"/api/flows/nope/events/stream", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	missing, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET events/stream (missing): %v", err)
+	}
+	missing.Body.Close()
+	if missing.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown flow: status %d, want 404", missing.StatusCode)
+	}
+}
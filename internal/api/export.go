@@ -1,14 +1,18 @@
 package api
 
 import (
+	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
+	"github.com/HakAl/langley/internal/analytics"
 	"github.com/HakAl/langley/internal/store"
 )
 
@@ -24,6 +28,16 @@ const (
 	MaxCSVRows = 10000
 	// MaxJSONRows limits JSON exports to prevent OOM (JSON buffers all rows in memory)
 	MaxJSONRows = 10000
+	// MaxJSONRowsWithEvents is MaxJSONRows with include_events, when each
+	// buffered row can carry MaxExportEventsPerFlow events
+	MaxJSONRowsWithEvents = 100
+	// MaxExportEventsPerFlow limits the events nested in each flow's row
+	// when include_events is set; a long stream has thousands of deltas
+	MaxExportEventsPerFlow = 1000
+
+	// exportCursorTrailer carries the after_id/after_timestamp query
+	// parameters that continue an export after its last row.
+	exportCursorTrailer = "X-Export-Cursor"
 )
 
 // ExportFlowFull extends ExportFlowSummary with body fields.
@@ -31,16 +45,46 @@ type ExportFlowFull struct {
 	ExportFlowSummary
 	RequestBody           *string            `json:"request_body,omitempty"`
 	RequestBodyTruncated  bool               `json:"request_body_truncated,omitempty"`
+	RequestBodyInvalid    bool               `json:"request_body_invalid,omitempty"`
 	ResponseBody          *string            `json:"response_body,omitempty"`
 	ResponseBodyTruncated bool               `json:"response_body_truncated,omitempty"`
 	RequestHeaders        map[string][]string `json:"request_headers,omitempty"`
+	RequestHeaderOrder    []string           `json:"request_header_order,omitempty"`
 	ResponseHeaders       map[string][]string `json:"response_headers,omitempty"`
 }
 
+// ExportFlowWithEvents is an export row with the flow's events nested, for
+// include_events. Bodies are only set with include_bodies.
+type ExportFlowWithEvents struct {
+	ExportFlowFull
+	Events          []EventResponse `json:"events"`
+	EventsTruncated bool            `json:"events_truncated,omitempty"` // More than MaxExportEventsPerFlow
+}
+
+// ExportToolInvocation is a tool invocation flattened with its flow's model,
+// provider and task for export.
+type ExportToolInvocation struct {
+	ID           string   `json:"id"`
+	FlowID       string   `json:"flow_id"`
+	TaskID       *string  `json:"task_id,omitempty"`
+	ToolName     string   `json:"tool_name"`
+	ToolType     *string  `json:"tool_type,omitempty"`
+	Timestamp    string   `json:"timestamp"`
+	Success      *bool    `json:"success"`
+	DurationMs   *int64   `json:"duration_ms"`
+	InputTokens  *int     `json:"input_tokens,omitempty"`
+	OutputTokens *int     `json:"output_tokens,omitempty"`
+	Cost         *float64 `json:"cost"`
+	Model        *string  `json:"model,omitempty"`
+	Provider     string   `json:"provider"`
+	ErrorMessage *string  `json:"error_message,omitempty"`
+}
+
 // ExportConfig holds export configuration parsed from query params.
 type ExportConfig struct {
 	Format        ExportFormat
 	IncludeBodies bool
+	IncludeEvents bool // Ignored by CSV
 	MaxRows       int
 }
 
@@ -72,12 +116,18 @@ func ParseExportConfig(r *http.Request) ExportConfig {
 	if v := r.URL.Query().Get("include_bodies"); v == "true" {
 		cfg.IncludeBodies = true
 	}
+	if v := r.URL.Query().Get("include_events"); v == "true" {
+		cfg.IncludeEvents = true
+	}
 
 	if v := r.URL.Query().Get("max_rows"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil && n > 0 {
 			cfg.MaxRows = n
 		}
 	}
+	if cfg.Format == FormatJSON && cfg.IncludeEvents && (cfg.MaxRows == 0 || cfg.MaxRows > MaxJSONRowsWithEvents) {
+		cfg.MaxRows = MaxJSONRowsWithEvents
+	}
 
 	return cfg
 }
@@ -90,12 +140,24 @@ type FlowExporter interface {
 	FileExtension() string
 	// WriteHeader writes any header/preamble needed.
 	WriteHeader(w io.Writer) error
-	// WriteFlow writes a single flow.
-	WriteFlow(w io.Writer, flow *store.Flow, includeBodies bool) error
+	// WriteFlow writes a single flow, with its events nested when events
+	// is non-nil.
+	WriteFlow(w io.Writer, flow *store.Flow, includeBodies bool, events []*store.Event) error
 	// WriteFooter writes any footer/closing needed.
 	WriteFooter(w io.Writer, rowCount int, truncatedBodies int) error
 }
 
+// ToolInvocationExporter writes tool invocations in a specific format. The
+// flow exporters implement it too, so both exports share their formats.
+type ToolInvocationExporter interface {
+	ContentType() string
+	FileExtension() string
+	WriteHeader(w io.Writer) error
+	// WriteToolInvocation writes a single invocation.
+	WriteToolInvocation(w io.Writer, inv ExportToolInvocation) error
+	WriteFooter(w io.Writer, rowCount int, truncatedBodies int) error
+}
+
 // NDJSONExporter exports flows as newline-delimited JSON.
 type NDJSONExporter struct {
 	encoder *json.Encoder
@@ -113,11 +175,12 @@ func (e *NDJSONExporter) WriteHeader(w io.Writer) error {
 	return nil
 }
 
-func (e *NDJSONExporter) WriteFlow(w io.Writer, flow *store.Flow, includeBodies bool) error {
-	if includeBodies {
-		return e.encoder.Encode(toExportFlowFull(flow))
-	}
-	return e.encoder.Encode(toExportFlowSummary(flow))
+func (e *NDJSONExporter) WriteFlow(w io.Writer, flow *store.Flow, includeBodies bool, events []*store.Event) error {
+	return e.encoder.Encode(toExportFlowRow(flow, includeBodies, events))
+}
+
+func (e *NDJSONExporter) WriteToolInvocation(w io.Writer, inv ExportToolInvocation) error {
+	return e.encoder.Encode(inv)
 }
 
 func (e *NDJSONExporter) WriteFooter(w io.Writer, rowCount int, truncatedBodies int) error {
@@ -126,6 +189,7 @@ func (e *NDJSONExporter) WriteFooter(w io.Writer, rowCount int, truncatedBodies
 
 // JSONExporter exports flows as a JSON array with metadata.
 type JSONExporter struct {
+	key             string // Name of the rows array
 	flows           []interface{}
 	includeBodies   bool
 	truncatedBodies int
@@ -133,6 +197,7 @@ type JSONExporter struct {
 
 func NewJSONExporter() *JSONExporter {
 	return &JSONExporter{
+		key:   "flows",
 		flows: make([]interface{}, 0),
 	}
 }
@@ -144,23 +209,23 @@ func (e *JSONExporter) WriteHeader(w io.Writer) error {
 	return nil // JSON writes everything in footer
 }
 
-func (e *JSONExporter) WriteFlow(w io.Writer, flow *store.Flow, includeBodies bool) error {
+func (e *JSONExporter) WriteFlow(w io.Writer, flow *store.Flow, includeBodies bool, events []*store.Event) error {
 	e.includeBodies = includeBodies
-	if includeBodies {
-		full := toExportFlowFull(flow)
-		if flow.RequestBodyTruncated || flow.ResponseBodyTruncated {
-			e.truncatedBodies++
-		}
-		e.flows = append(e.flows, full)
-	} else {
-		e.flows = append(e.flows, toExportFlowSummary(flow))
+	if includeBodies && (flow.RequestBodyTruncated || flow.ResponseBodyTruncated) {
+		e.truncatedBodies++
 	}
+	e.flows = append(e.flows, toExportFlowRow(flow, includeBodies, events))
+	return nil
+}
+
+func (e *JSONExporter) WriteToolInvocation(w io.Writer, inv ExportToolInvocation) error {
+	e.flows = append(e.flows, inv)
 	return nil
 }
 
 func (e *JSONExporter) WriteFooter(w io.Writer, rowCount int, truncatedBodies int) error {
 	response := map[string]interface{}{
-		"flows": e.flows,
+		e.key: e.flows,
 		"meta": map[string]interface{}{
 			"row_count":   rowCount,
 			"exported_at": time.Now().UTC().Format(time.RFC3339),
@@ -174,13 +239,28 @@ func (e *JSONExporter) WriteFooter(w io.Writer, rowCount int, truncatedBodies in
 	return encoder.Encode(response)
 }
 
+// flowCSVColumns are the columns of a flow CSV export.
+var flowCSVColumns = []string{
+	"id", "timestamp", "host", "method", "path", "status_code",
+	"duration_ms", "is_sse", "task_id", "task_source", "model",
+	"provider", "input_tokens", "output_tokens", "total_cost", "flow_integrity",
+}
+
+// toolInvocationCSVColumns are the columns of a tool invocation CSV export.
+var toolInvocationCSVColumns = []string{
+	"id", "flow_id", "task_id", "tool_name", "tool_type", "timestamp",
+	"success", "duration_ms", "input_tokens", "output_tokens", "cost",
+	"model", "provider", "error_message",
+}
+
 // CSVExporter exports flows as CSV (summary fields only).
 type CSVExporter struct {
-	writer *csv.Writer
+	writer  *csv.Writer
+	columns []string
 }
 
 func NewCSVExporter() *CSVExporter {
-	return &CSVExporter{}
+	return &CSVExporter{columns: flowCSVColumns}
 }
 
 func (e *CSVExporter) ContentType() string   { return "text/csv" }
@@ -188,15 +268,11 @@ func (e *CSVExporter) FileExtension() string { return "csv" }
 
 func (e *CSVExporter) WriteHeader(w io.Writer) error {
 	e.writer = csv.NewWriter(w)
-	return e.writer.Write([]string{
-		"id", "timestamp", "host", "method", "path", "status_code",
-		"duration_ms", "is_sse", "task_id", "task_source", "model",
-		"provider", "input_tokens", "output_tokens", "total_cost", "flow_integrity",
-	})
+	return e.writer.Write(e.columns)
 }
 
-func (e *CSVExporter) WriteFlow(w io.Writer, flow *store.Flow, includeBodies bool) error {
-	// CSV ignores includeBodies - always summary only
+func (e *CSVExporter) WriteFlow(w io.Writer, flow *store.Flow, includeBodies bool, events []*store.Event) error {
+	// CSV ignores includeBodies and events - always summary only
 	record := []string{
 		flow.ID,
 		flow.Timestamp.Format(time.RFC3339),
@@ -218,11 +294,189 @@ func (e *CSVExporter) WriteFlow(w io.Writer, flow *store.Flow, includeBodies boo
 	return e.writer.Write(record)
 }
 
+func (e *CSVExporter) WriteToolInvocation(w io.Writer, inv ExportToolInvocation) error {
+	success := ""
+	if inv.Success != nil {
+		success = strconv.FormatBool(*inv.Success)
+	}
+	return e.writer.Write([]string{
+		inv.ID,
+		inv.FlowID,
+		ptrStr(inv.TaskID),
+		inv.ToolName,
+		ptrStr(inv.ToolType),
+		inv.Timestamp,
+		success,
+		ptrInt64ToStr(inv.DurationMs),
+		ptrToStr(inv.InputTokens),
+		ptrToStr(inv.OutputTokens),
+		ptrFloat64ToStr(inv.Cost),
+		ptrStr(inv.Model),
+		inv.Provider,
+		ptrStr(inv.ErrorMessage),
+	})
+}
+
 func (e *CSVExporter) WriteFooter(w io.Writer, rowCount int, truncatedBodies int) error {
 	e.writer.Flush()
 	return e.writer.Error()
 }
 
+// parseExportFilter parses flow filters for exports (same params as listFlows).
+// Limit is the batch size used while paging through the store.
+func parseExportFilter(r *http.Request) store.FlowFilter {
+	filter := store.FlowFilter{
+		Limit: 100, // batch size for streaming
+	}
+
+	if v := r.URL.Query().Get("host"); v != "" {
+		filter.Host = &v
+	}
+	if v := r.URL.Query().Get("task_id"); v != "" {
+		filter.TaskID = &v
+	}
+	if v := r.URL.Query().Get("model"); v != "" {
+		filter.Model = &v
+	}
+	if v := r.URL.Query().Get("stop_reason"); v != "" {
+		filter.StopReason = &v
+	}
+	if v := r.URL.Query().Get("tag"); v != "" {
+		filter.Tag = &v
+	}
+	if v := r.URL.Query().Get("provider"); v != "" {
+		filter.Provider = &v
+	}
+	filter.StatusMin = parseStatusParam(r.URL.Query().Get("status_min"))
+	filter.StatusMax = parseStatusParam(r.URL.Query().Get("status_max"))
+	if v := r.URL.Query().Get("start_time"); v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			filter.StartTime = &t
+		}
+	}
+	if v := r.URL.Query().Get("end_time"); v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			filter.EndTime = &t
+		}
+	}
+
+	return filter
+}
+
+// parseExportCursor parses the after_id and after_timestamp params that
+// resume an export after a flow. The flow's own timestamp is used when it
+// still exists, since exported timestamps are only to the second;
+// after_timestamp is needed to resume after a flow that has been deleted.
+func (s *Server) parseExportCursor(ctx context.Context, r *http.Request) (*store.FlowCursor, error) {
+	id := r.URL.Query().Get("after_id")
+	ts := r.URL.Query().Get("after_timestamp")
+	if id == "" && ts == "" {
+		return nil, nil
+	}
+
+	cursor := &store.FlowCursor{ID: id}
+	if ts != "" {
+		t, err := time.Parse(time.RFC3339Nano, ts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid after_timestamp %q, want RFC 3339", ts)
+		}
+		cursor.Timestamp = t
+	}
+	if id != "" {
+		flow, err := s.store.GetFlow(ctx, id)
+		switch {
+		case err == nil:
+			cursor.Timestamp = flow.Timestamp
+		case ts == "":
+			return nil, fmt.Errorf("unknown after_id %q; pass after_timestamp to resume after a deleted flow", id)
+		}
+	}
+	return cursor, nil
+}
+
+// encodeExportCursor formats the query parameters that resume an export
+// after cursor.
+func encodeExportCursor(cursor *store.FlowCursor) string {
+	return url.Values{
+		"after_id":        {cursor.ID},
+		"after_timestamp": {cursor.Timestamp.Format(time.RFC3339Nano)},
+	}.Encode()
+}
+
+// errExportRead marks a writeExportRows error from reading the store, after
+// which the rows already written are intact.
+var errExportRead = errors.New("reading export rows")
+
+// writeExportRows pages through flows matching filter and writes each one
+// with exporter until the store is exhausted or exportCfg.MaxRows is reached.
+// Pages continue from the last flow rather than by offset, so flows saved
+// during the export don't shift rows into the next page twice. It returns
+// the position of the last row written, nil if none. flush, if non-nil, is
+// called after every row. A store error ends the export early and is
+// returned wrapping errExportRead, so a streaming caller can still write the
+// footer; a write error is returned as is. With exportCfg.IncludeEvents,
+// each flow's events are read and nested in its row.
+func (s *Server) writeExportRows(ctx context.Context, w io.Writer, exporter FlowExporter, filter store.FlowFilter, exportCfg ExportConfig, flush func()) (rowCount, truncatedBodies int, last *store.FlowCursor, err error) {
+	for {
+		// Check row limit
+		if exportCfg.MaxRows > 0 && rowCount >= exportCfg.MaxRows {
+			break
+		}
+
+		listCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		flows, err := s.store.ListFlows(listCtx, filter)
+		cancel()
+
+		if err != nil {
+			return rowCount, truncatedBodies, last, fmt.Errorf("%w: listing flows: %w", errExportRead, err)
+		}
+		if len(flows) == 0 {
+			break
+		}
+
+		for _, f := range flows {
+			if exportCfg.MaxRows > 0 && rowCount >= exportCfg.MaxRows {
+				break
+			}
+
+			if exportCfg.IncludeBodies {
+				s.scrubFlow(f)
+			}
+			var events []*store.Event
+			if exportCfg.IncludeEvents {
+				eventsCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+				// One past the cap, to tell a full list from a truncated one
+				events, err = s.store.GetEventsByFlowLimit(eventsCtx, f.ID, MaxExportEventsPerFlow+1)
+				cancel()
+				if err != nil {
+					return rowCount, truncatedBodies, last, fmt.Errorf("%w: getting events for flow %s: %w", errExportRead, f.ID, err)
+				}
+				if events == nil {
+					events = []*store.Event{} // Still nest an empty list
+				}
+			}
+			if err := exporter.WriteFlow(w, f, exportCfg.IncludeBodies, events); err != nil {
+				return rowCount, truncatedBodies, last, fmt.Errorf("writing flow %s: %w", f.ID, err)
+			}
+			last = &store.FlowCursor{Timestamp: f.Timestamp, ID: f.ID}
+
+			// Track truncated bodies
+			if exportCfg.IncludeBodies && (f.RequestBodyTruncated || f.ResponseBodyTruncated) {
+				truncatedBodies++
+			}
+
+			if flush != nil {
+				flush()
+			}
+			rowCount++
+		}
+
+		filter.After = last
+	}
+
+	return rowCount, truncatedBodies, last, nil
+}
+
 // NewExporter creates an exporter for the given format.
 func NewExporter(format ExportFormat) FlowExporter {
 	switch format {
@@ -235,15 +489,118 @@ func NewExporter(format ExportFormat) FlowExporter {
 	}
 }
 
+// NewToolInvocationExporter creates a tool invocation exporter for the given format.
+func NewToolInvocationExporter(format ExportFormat) ToolInvocationExporter {
+	switch format {
+	case FormatJSON:
+		e := NewJSONExporter()
+		e.key = "tool_invocations"
+		return e
+	case FormatCSV:
+		return &CSVExporter{columns: toolInvocationCSVColumns}
+	default:
+		return NewNDJSONExporter()
+	}
+}
+
+// writeToolInvocationRows pages through tool invocations matching filter
+// and writes each one with exporter, like writeExportRows does for flows.
+func (s *Server) writeToolInvocationRows(ctx context.Context, w io.Writer, exporter ToolInvocationExporter, filter analytics.ToolInvocationRowFilter, exportCfg ExportConfig, flush func()) (rowCount int, err error) {
+	for {
+		if exportCfg.MaxRows > 0 && rowCount >= exportCfg.MaxRows {
+			break
+		}
+
+		listCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		rows, err := s.analytics.ListToolInvocationRows(listCtx, filter)
+		cancel()
+
+		if err != nil {
+			s.logger.Error("export: failed to list tool invocations", "error", err, "offset", filter.Offset)
+			break
+		}
+		if len(rows) == 0 {
+			break
+		}
+
+		for _, row := range rows {
+			if exportCfg.MaxRows > 0 && rowCount >= exportCfg.MaxRows {
+				break
+			}
+			if err := exporter.WriteToolInvocation(w, toExportToolInvocation(row)); err != nil {
+				return rowCount, fmt.Errorf("writing tool invocation %s: %w", row.ID, err)
+			}
+			if flush != nil {
+				flush()
+			}
+			rowCount++
+		}
+
+		filter.Offset += len(rows)
+	}
+
+	return rowCount, nil
+}
+
+// toExportToolInvocation converts an analytics row to ExportToolInvocation.
+func toExportToolInvocation(r *analytics.ToolInvocationRow) ExportToolInvocation {
+	return ExportToolInvocation{
+		ID:           r.ID,
+		FlowID:       r.FlowID,
+		TaskID:       r.TaskID,
+		ToolName:     r.ToolName,
+		ToolType:     r.ToolType,
+		Timestamp:    r.Timestamp.Format(time.RFC3339),
+		Success:      r.Success,
+		DurationMs:   r.DurationMs,
+		InputTokens:  r.InputTokens,
+		OutputTokens: r.OutputTokens,
+		Cost:         r.Cost,
+		Model:        r.Model,
+		Provider:     r.Provider,
+		ErrorMessage: r.ErrorMessage,
+	}
+}
+
+// toExportFlowRow returns the row a JSON exporter writes for a flow: its
+// summary, with bodies when includeBodies is set, and with up to
+// MaxExportEventsPerFlow events when events is non-nil.
+func toExportFlowRow(f *store.Flow, includeBodies bool, events []*store.Event) interface{} {
+	if events == nil {
+		if includeBodies {
+			return toExportFlowFull(f)
+		}
+		return toExportFlowSummary(f)
+	}
+
+	row := ExportFlowWithEvents{
+		ExportFlowFull: ExportFlowFull{ExportFlowSummary: toExportFlowSummary(f)},
+		Events:         make([]EventResponse, 0, min(len(events), MaxExportEventsPerFlow)),
+	}
+	if includeBodies {
+		row.ExportFlowFull = toExportFlowFull(f)
+	}
+	if len(events) > MaxExportEventsPerFlow {
+		events = events[:MaxExportEventsPerFlow]
+		row.EventsTruncated = true
+	}
+	for _, e := range events {
+		row.Events = append(row.Events, toEventResponse(e))
+	}
+	return row
+}
+
 // toExportFlowFull converts a store.Flow to ExportFlowFull.
 func toExportFlowFull(f *store.Flow) ExportFlowFull {
 	return ExportFlowFull{
 		ExportFlowSummary:     toExportFlowSummary(f),
 		RequestBody:          f.RequestBody,
 		RequestBodyTruncated:  f.RequestBodyTruncated,
+		RequestBodyInvalid:    f.RequestBodyInvalid,
 		ResponseBody:          f.ResponseBody,
 		ResponseBodyTruncated: f.ResponseBodyTruncated,
 		RequestHeaders:        f.RequestHeaders,
+		RequestHeaderOrder:    f.RequestHeaderOrder,
 		ResponseHeaders:       f.ResponseHeaders,
 	}
 }
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"github.com/HakAl/langley/internal/config"
+)
+
+// s3ExportPartSize is the multipart chunk size for S3 uploads. minio-go
+// buffers one part in memory, so this bounds export memory use.
+const s3ExportPartSize = 16 * 1024 * 1024
+
+// S3ExportRequest is the body of POST /api/flows/export/s3.
+// Empty fields fall back to export.s3 in the config.
+type S3ExportRequest struct {
+	Endpoint        string `json:"endpoint,omitempty"`
+	Bucket          string `json:"bucket,omitempty"`
+	Prefix          string `json:"prefix,omitempty"`
+	Region          string `json:"region,omitempty"`
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+	Insecure        *bool  `json:"insecure,omitempty"`
+}
+
+// S3ExportResponse is the response for POST /api/flows/export/s3.
+type S3ExportResponse struct {
+	Bucket          string `json:"bucket"`
+	Key             string `json:"key"`
+	RowCount        int    `json:"row_count"`
+	TruncatedBodies int    `json:"truncated_bodies,omitempty"`
+	SizeBytes       int64  `json:"size_bytes"`
+	DurationMs      int64  `json:"duration_ms"`
+}
+
+// merge returns the effective S3 settings: request fields over config.
+// A request endpoint must be the configured one or one of AllowedEndpoints,
+// so that a request can't send the export or credentials elsewhere.
+func (req S3ExportRequest) merge(cfg config.S3ExportConfig) (config.S3ExportConfig, error) {
+	if req.Endpoint != "" && req.Endpoint != cfg.Endpoint {
+		if !slices.Contains(cfg.AllowedEndpoints, req.Endpoint) {
+			return cfg, fmt.Errorf("endpoint %q is not allowed (set export.s3.endpoint or export.s3.allowed_endpoints)", req.Endpoint)
+		}
+		cfg.Endpoint = req.Endpoint
+	}
+	if req.Bucket != "" {
+		cfg.Bucket = req.Bucket
+	}
+	if req.Prefix != "" {
+		cfg.Prefix = req.Prefix
+	}
+	if req.Region != "" {
+		cfg.Region = req.Region
+	}
+	if req.AccessKeyID != "" || req.SecretAccessKey != "" {
+		cfg.AccessKeyID = req.AccessKeyID
+		cfg.SecretAccessKey = req.SecretAccessKey
+	}
+	if req.Insecure != nil {
+		cfg.Insecure = *req.Insecure
+	}
+	return cfg, nil
+}
+
+// exportFlowsS3 streams an NDJSON export straight into an S3-compatible
+// bucket instead of through the browser. Filters, include_bodies and
+// max_rows are the same query params as exportFlows.
+func (s *Server) exportFlowsS3(w http.ResponseWriter, r *http.Request) {
+	var req S3ExportRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			writeError(w, http.StatusBadRequest, errCodeInvalidJSON, "Invalid JSON: "+err.Error())
+			return
+		}
+	}
+	target, err := req.merge(s.cfg.Export.S3)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+		return
+	}
+	if target.Endpoint == "" || target.Bucket == "" {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "endpoint and bucket are required (request body or export.s3 config)")
+		return
+	}
+	if target.AccessKeyID == "" || target.SecretAccessKey == "" {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "credentials are required (request body or export.s3 config)")
+		return
+	}
+
+	exportCfg := ParseExportConfig(r)
+	exportCfg.Format = FormatNDJSON
+	filter := parseExportFilter(r)
+	after, err := s.parseExportCursor(r.Context(), r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+		return
+	}
+	filter.After = after
+
+	region := target.Region
+	if region == "" {
+		region = "us-east-1" // Skips minio-go's bucket location lookup
+	}
+	client, err := minio.New(target.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(target.AccessKeyID, target.SecretAccessKey, ""),
+		Secure: !target.Insecure,
+		Region: region,
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "Invalid S3 endpoint: "+err.Error())
+		return
+	}
+
+	started := time.Now()
+	key := fmt.Sprintf("%sflows-%s.ndjson", target.Prefix, started.UTC().Format("20060102-150405"))
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Minute)
+	defer cancel()
+
+	// The exporter writes into a pipe that minio-go reads from, so rows are
+	// uploaded as they're produced rather than buffered in full
+	pr, pw := io.Pipe()
+	exporter := NewExporter(FormatNDJSON)
+	var rowCount, truncatedBodies int
+	var writeErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		writeErr = exporter.WriteHeader(pw)
+		if writeErr == nil {
+			rowCount, truncatedBodies, _, writeErr = s.writeExportRows(ctx, pw, exporter, filter, exportCfg, nil)
+		}
+		if writeErr == nil {
+			writeErr = exporter.WriteFooter(pw, rowCount, truncatedBodies)
+		}
+		pw.CloseWithError(writeErr)
+	}()
+
+	info, err := client.PutObject(ctx, target.Bucket, key, pr, -1, minio.PutObjectOptions{
+		ContentType: exporter.ContentType(),
+		PartSize:    s3ExportPartSize,
+	})
+	// Unblock the writer if the upload failed before consuming everything
+	_ = pr.CloseWithError(io.ErrClosedPipe)
+	<-done
+	// A failed upload leaves the writer with io.ErrClosedPipe; any other
+	// write error means the export itself failed, whether or not the
+	// upload went through with the rows written before it
+	if writeErr != nil && !errors.Is(writeErr, io.ErrClosedPipe) {
+		if err == nil {
+			if rmErr := client.RemoveObject(context.WithoutCancel(ctx), target.Bucket, key, minio.RemoveObjectOptions{}); rmErr != nil {
+				s.logger.Warn("s3 export: failed to remove partial object", "bucket", target.Bucket, "key", key, "error", rmErr)
+			}
+		}
+		s.logger.Error("s3 export failed", "bucket", target.Bucket, "key", key, "rows", rowCount, "error", writeErr)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Export failed: "+writeErr.Error())
+		return
+	}
+	if err != nil {
+		s.logger.Error("s3 export failed", "endpoint", target.Endpoint, "bucket", target.Bucket, "key", key, "error", err)
+		writeError(w, http.StatusBadGateway, errCodeUpstream, "S3 upload failed: "+err.Error())
+		return
+	}
+
+	s.logger.Info("s3 export complete", "bucket", target.Bucket, "key", key, "row_count", rowCount, "bytes", info.Size)
+
+	s.writeJSON(w, S3ExportResponse{
+		Bucket:          target.Bucket,
+		Key:             key,
+		RowCount:        rowCount,
+		TruncatedBodies: truncatedBodies,
+		SizeBytes:       info.Size,
+		DurationMs:      time.Since(started).Milliseconds(),
+	})
+}
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/config"
+	"github.com/HakAl/langley/internal/store"
+	"github.com/HakAl/langley/internal/store/storetest"
+	"github.com/HakAl/langley/internal/testutil"
+)
+
+// s3Stub is a minimal S3-compatible server: single PUT and multipart uploads.
+type s3Stub struct {
+	mu      sync.Mutex
+	objects map[string][]byte         // "bucket/key" -> body
+	parts   map[string]map[int][]byte // uploadID -> part number -> body
+}
+
+func newS3Stub() *s3Stub {
+	return &s3Stub{objects: make(map[string][]byte), parts: make(map[string]map[int][]byte)}
+}
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	q := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+	if r.Header.Get("X-Amz-Content-Sha256") == "STREAMING-AWS4-HMAC-SHA256-PAYLOAD" {
+		body = decodeAWSChunked(body)
+	}
+
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		uploadID := fmt.Sprintf("upload-%d", len(s.parts)+1)
+		s.parts[uploadID] = make(map[int][]byte)
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Bucket>b</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, path, uploadID)
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		var n int
+		fmt.Sscanf(q.Get("partNumber"), "%d", &n)
+		s.parts[q.Get("uploadId")][n] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, n))
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		parts := s.parts[q.Get("uploadId")]
+		nums := make([]int, 0, len(parts))
+		for n := range parts {
+			nums = append(nums, n)
+		}
+		sort.Ints(nums)
+		var full []byte
+		for _, n := range nums {
+			full = append(full, parts[n]...)
+		}
+		s.objects[path] = full
+		bucket, key, _ := strings.Cut(path, "/")
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Bucket>%s</Bucket><Key>%s</Key><ETag>"etag"</ETag></CompleteMultipartUploadResult>`, bucket, key)
+	case r.Method == http.MethodPut:
+		s.objects[path] = body
+		w.Header().Set("ETag", `"etag"`)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (s *s3Stub) object(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.objects[key]
+	return b, ok
+}
+
+// decodeAWSChunked strips aws-chunked framing ("<hex-size>;chunk-signature=...\r\n<data>\r\n").
+func decodeAWSChunked(body []byte) []byte {
+	var out bytes.Buffer
+	br := bufio.NewReader(bytes.NewReader(body))
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return out.Bytes()
+		}
+		var size int
+		fmt.Sscanf(strings.SplitN(line, ";", 2)[0], "%x", &size)
+		if size == 0 {
+			return out.Bytes()
+		}
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return out.Bytes()
+		}
+		out.Write(chunk[:size])
+	}
+}
+
+func TestExportFlowsS3(t *testing.T) {
+	stub := newS3Stub()
+	s3Server := httptest.NewServer(stub)
+	defer s3Server.Close()
+	endpoint := strings.TrimPrefix(s3Server.URL, "http://")
+
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+	cfg.Export.S3 = config.S3ExportConfig{
+		Endpoint:        endpoint,
+		Bucket:          "archive",
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "secret",
+		Insecure:        true,
+	}
+
+	// Newest first, the order exports are written in
+	now := time.Now()
+	flows := make([]*store.Flow, 5)
+	for i := range flows {
+		flows[i] = testutil.NewFlow().WithID(fmt.Sprintf("flow-%d", i)).Build()
+		flows[i].Timestamp = now.Add(-time.Duration(i) * time.Minute)
+	}
+	server := NewServer(cfg, storetest.New(storetest.WithFlows(flows...)), nil)
+	handler := server.Handler()
+
+	body := `{"prefix": "langley/"}`
+	req := httptest.NewRequest("POST", "/api/flows/export/s3?max_rows=3", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, body: %s", rr.Code, rr.Body.String())
+	}
+	var resp S3ExportResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Bucket != "archive" {
+		t.Errorf("bucket = %q, want archive", resp.Bucket)
+	}
+	if !strings.HasPrefix(resp.Key, "langley/flows-") || !strings.HasSuffix(resp.Key, ".ndjson") {
+		t.Errorf("unexpected key %q", resp.Key)
+	}
+	if resp.RowCount != 3 {
+		t.Errorf("row_count = %d, want 3 (max_rows)", resp.RowCount)
+	}
+
+	uploaded, ok := stub.object("archive/" + resp.Key)
+	if !ok {
+		t.Fatalf("object %q not uploaded", resp.Key)
+	}
+	lines := strings.Split(strings.TrimSpace(string(uploaded)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("uploaded %d lines, want 3:\n%s", len(lines), uploaded)
+	}
+	for i, line := range lines {
+		var row ExportFlowSummary
+		if err := json.Unmarshal([]byte(line), &row); err != nil {
+			t.Fatalf("line %d is not JSON: %v", i, err)
+		}
+		if want := fmt.Sprintf("flow-%d", i); row.ID != want {
+			t.Errorf("line %d id = %q, want %q", i, row.ID, want)
+		}
+	}
+	if resp.SizeBytes != int64(len(uploaded)) {
+		t.Errorf("size_bytes = %d, uploaded %d", resp.SizeBytes, len(uploaded))
+	}
+}
+
+func TestExportFlowsS3_MissingTarget(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+
+	server := NewServer(cfg, storetest.New(), nil)
+	handler := server.Handler()
+
+	req := httptest.NewRequest("POST", "/api/flows/export/s3", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want 400", rr.Code)
+	}
+}
+
+func TestExportFlowsS3_Rejected(t *testing.T) {
+	stub := newS3Stub()
+	s3Server := httptest.NewServer(stub)
+	defer s3Server.Close()
+	endpoint := strings.TrimPrefix(s3Server.URL, "http://")
+
+	// Ambient credentials are never used
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+
+	tests := []struct {
+		name string
+		s3   config.S3ExportConfig
+		body string
+		want int
+	}{
+		{"endpoint not configured",
+			config.S3ExportConfig{Endpoint: "s3.example.com", Bucket: "archive", AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", Insecure: true},
+			fmt.Sprintf(`{"endpoint": %q}`, endpoint), http.StatusBadRequest},
+		{"endpoint allowed",
+			config.S3ExportConfig{Endpoint: "s3.example.com", AllowedEndpoints: []string{endpoint}, Bucket: "archive", AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", Insecure: true},
+			fmt.Sprintf(`{"endpoint": %q}`, endpoint), http.StatusOK},
+		{"no credentials",
+			config.S3ExportConfig{Endpoint: endpoint, Bucket: "archive", Insecure: true},
+			`{}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Auth.Token = "test-token"
+			cfg.Export.S3 = tt.s3
+			server := NewServer(cfg, storetest.New(storetest.WithFlows(testutil.NewFlow().Build())), nil)
+
+			req := httptest.NewRequest("POST", "/api/flows/export/s3", strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer test-token")
+			rr := httptest.NewRecorder()
+			server.Handler().ServeHTTP(rr, req)
+
+			if rr.Code != tt.want {
+				t.Errorf("got status %d, want %d; body: %s", rr.Code, tt.want, rr.Body.String())
+			}
+		})
+	}
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if len(stub.objects) != 1 {
+		t.Errorf("stub holds %d objects, want only the allowed endpoint's", len(stub.objects))
+	}
+}
+
+func TestExportFlowsS3_StoreError(t *testing.T) {
+	stub := newS3Stub()
+	s3Server := httptest.NewServer(stub)
+	defer s3Server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+	cfg.Export.S3 = config.S3ExportConfig{
+		Endpoint:        strings.TrimPrefix(s3Server.URL, "http://"),
+		Bucket:          "archive",
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "secret",
+		Insecure:        true,
+	}
+	st := storetest.New(storetest.WithFlows(testutil.NewFlow().Build()), storetest.WithError("ListFlows", fmt.Errorf("disk I/O error")))
+	server := NewServer(cfg, st, nil)
+
+	req := httptest.NewRequest("POST", "/api/flows/export/s3", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want 500; body: %s", rr.Code, rr.Body.String())
+	}
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if len(stub.objects) != 0 {
+		t.Errorf("partial export left %d objects", len(stub.objects))
+	}
+}
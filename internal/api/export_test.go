@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/csv"
 	"encoding/json"
 	"net/http"
@@ -10,7 +11,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/HakAl/langley/internal/config"
 	"github.com/HakAl/langley/internal/store"
+	"github.com/HakAl/langley/internal/testutil"
 )
 
 func TestParseExportConfig(t *testing.T) {
@@ -21,6 +24,7 @@ func TestParseExportConfig(t *testing.T) {
 		query         string
 		wantFormat    ExportFormat
 		wantBodies    bool
+		wantEvents    bool
 		wantMaxRows   int
 	}{
 		{
@@ -58,6 +62,20 @@ func TestParseExportConfig(t *testing.T) {
 			wantBodies:  false,
 			wantMaxRows: 500,
 		},
+		{
+			name:        "include events",
+			query:       "include_events=true",
+			wantFormat:  FormatNDJSON,
+			wantEvents:  true,
+			wantMaxRows: 0,
+		},
+		{
+			name:        "json with events caps rows",
+			query:       "format=json&include_events=true&max_rows=5000",
+			wantFormat:  FormatJSON,
+			wantEvents:  true,
+			wantMaxRows: MaxJSONRowsWithEvents,
+		},
 		{
 			name:        "all options",
 			query:       "format=json&include_bodies=true&max_rows=100",
@@ -78,6 +96,9 @@ func TestParseExportConfig(t *testing.T) {
 			if cfg.IncludeBodies != tt.wantBodies {
 				t.Errorf("IncludeBodies = %v, want %v", cfg.IncludeBodies, tt.wantBodies)
 			}
+			if cfg.IncludeEvents != tt.wantEvents {
+				t.Errorf("IncludeEvents = %v, want %v", cfg.IncludeEvents, tt.wantEvents)
+			}
 			if cfg.MaxRows != tt.wantMaxRows {
 				t.Errorf("MaxRows = %v, want %v", cfg.MaxRows, tt.wantMaxRows)
 			}
@@ -97,7 +118,7 @@ func TestNDJSONExporter(t *testing.T) {
 	}
 
 	// Write without bodies
-	if err := exporter.WriteFlow(&buf, flow, false); err != nil {
+	if err := exporter.WriteFlow(&buf, flow, false, nil); err != nil {
 		t.Fatalf("WriteFlow error: %v", err)
 	}
 
@@ -132,7 +153,7 @@ func TestNDJSONExporter_WithBodies(t *testing.T) {
 	exporter := NewNDJSONExporter()
 
 	_ = exporter.WriteHeader(&buf)
-	if err := exporter.WriteFlow(&buf, flow, true); err != nil {
+	if err := exporter.WriteFlow(&buf, flow, true, nil); err != nil {
 		t.Fatalf("WriteFlow error: %v", err)
 	}
 	_ = exporter.WriteFooter(&buf, 1, 0)
@@ -161,7 +182,7 @@ func TestJSONExporter(t *testing.T) {
 
 	_ = exporter.WriteHeader(&buf)
 	for _, f := range flows {
-		if err := exporter.WriteFlow(&buf, f, false); err != nil {
+		if err := exporter.WriteFlow(&buf, f, false, nil); err != nil {
 			t.Fatalf("WriteFlow error: %v", err)
 		}
 	}
@@ -199,7 +220,7 @@ func TestCSVExporter(t *testing.T) {
 	if err := exporter.WriteHeader(&buf); err != nil {
 		t.Fatalf("WriteHeader error: %v", err)
 	}
-	if err := exporter.WriteFlow(&buf, flow, false); err != nil {
+	if err := exporter.WriteFlow(&buf, flow, false, nil); err != nil {
 		t.Fatalf("WriteFlow error: %v", err)
 	}
 	if err := exporter.WriteFooter(&buf, 1, 0); err != nil {
@@ -242,7 +263,7 @@ func TestCSVExporter_IgnoresBodies(t *testing.T) {
 
 	_ = exporter.WriteHeader(&buf)
 	// Even with includeBodies=true, CSV should not include them
-	_ = exporter.WriteFlow(&buf, flow, true)
+	_ = exporter.WriteFlow(&buf, flow, true, nil)
 	_ = exporter.WriteFooter(&buf, 1, 0)
 
 	output := buf.String()
@@ -321,3 +342,118 @@ func mustNewRequest(t *testing.T, method, url string) *http.Request {
 	t.Helper()
 	return httptest.NewRequest(method, url, nil)
 }
+
+func TestExportToolInvocations(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+
+	dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer dataStore.Close()
+
+	ctx := context.Background()
+	base := time.Now().Add(-time.Hour)
+	flowA := testutil.NewFlow().WithID("flow-a").WithModel("claude-sonnet-4").WithTaskID("task-a").Build()
+	flowB := testutil.NewFlow().WithID("flow-b").WithModel("claude-haiku-4").WithTaskID("task-b").Build()
+	for _, f := range []*store.Flow{flowA, flowB} {
+		f.Timestamp = base
+		if err := dataStore.SaveFlow(ctx, f); err != nil {
+			t.Fatalf("SaveFlow: %v", err)
+		}
+	}
+	ok, failed, cost, duration := true, false, 0.5, int64(250)
+	invocations := []*store.ToolInvocation{
+		{ID: "inv-1", FlowID: "flow-a", ToolName: "Bash", Timestamp: base.Add(time.Second),
+			Success: &ok, DurationMs: &duration, Cost: &cost},
+		{ID: "inv-2", FlowID: "flow-b", ToolName: "Read", Timestamp: base.Add(2 * time.Second), Success: &failed},
+		{ID: "inv-3", FlowID: "flow-a", ToolName: "Edit", Timestamp: base.Add(3 * time.Second)},
+	}
+	for _, inv := range invocations {
+		if err := dataStore.SaveToolInvocation(ctx, inv); err != nil {
+			t.Fatalf("SaveToolInvocation: %v", err)
+		}
+	}
+	want := map[string]struct{ tool, model, task, success, duration, cost string }{
+		"inv-1": {"Bash", "claude-sonnet-4", "task-a", "true", "250", "0.500000"},
+		"inv-2": {"Read", "claude-haiku-4", "task-b", "false", "", ""},
+		"inv-3": {"Edit", "claude-sonnet-4", "task-a", "", "", ""},
+	}
+
+	handler := NewServer(cfg, dataStore, nil).Handler()
+	export := func(query string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/analytics/tools/export?"+query, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET tools/export?%s: got status %d, body: %s", query, rr.Code, rr.Body.String())
+		}
+		return rr
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		rr := export("format=csv")
+		if ct := rr.Header().Get("Content-Type"); ct != "text/csv" {
+			t.Errorf("Content-Type = %q, want text/csv", ct)
+		}
+		records, err := csv.NewReader(rr.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("parse CSV: %v", err)
+		}
+		if len(records) != len(invocations)+1 {
+			t.Fatalf("got %d records, want header + one row per invocation", len(records))
+		}
+		col := make(map[string]int)
+		for i, name := range records[0] {
+			col[name] = i
+		}
+		for _, row := range records[1:] {
+			w, found := want[row[col["id"]]]
+			if !found {
+				t.Errorf("unexpected row %v", row)
+				continue
+			}
+			got := struct{ tool, model, task, success, duration, cost string }{
+				row[col["tool_name"]], row[col["model"]], row[col["task_id"]],
+				row[col["success"]], row[col["duration_ms"]], row[col["cost"]],
+			}
+			if got != w {
+				t.Errorf("row %s = %+v, want %+v", row[col["id"]], got, w)
+			}
+		}
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		rr := export("tool_name=Bash")
+		lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+		if len(lines) != 1 {
+			t.Fatalf("got %d lines, want only the Bash invocation: %s", len(lines), rr.Body.String())
+		}
+		var row ExportToolInvocation
+		if err := json.Unmarshal([]byte(lines[0]), &row); err != nil {
+			t.Fatalf("decode row: %v", err)
+		}
+		if row.ID != "inv-1" || row.FlowID != "flow-a" || row.Model == nil || *row.Model != "claude-sonnet-4" ||
+			row.TaskID == nil || *row.TaskID != "task-a" || row.Success == nil || !*row.Success ||
+			row.DurationMs == nil || *row.DurationMs != 250 || row.Cost == nil || *row.Cost != 0.5 {
+			t.Errorf("row = %+v", row)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		rr := export("format=json&task_id=task-a")
+		var result struct {
+			ToolInvocations []ExportToolInvocation `json:"tool_invocations"`
+			Meta            map[string]interface{} `json:"meta"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if len(result.ToolInvocations) != 2 || result.Meta["row_count"] != float64(2) {
+			t.Errorf("got %d invocations, meta %v; want task-a's 2", len(result.ToolInvocations), result.Meta)
+		}
+	})
+}
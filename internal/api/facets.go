@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+// FacetsResponse lists the values the dashboard's flow filters offer.
+type FacetsResponse struct {
+	Hosts     []FacetValueResponse `json:"hosts"`
+	Models    []FacetValueResponse `json:"models"`
+	Providers []FacetValueResponse `json:"providers"`
+	Tasks     []FacetValueResponse `json:"tasks"`
+}
+
+// FacetValueResponse is one distinct value and how many flows have it.
+type FacetValueResponse struct {
+	Value    string    `json:"value"`
+	Count    int64     `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// getFacets returns the distinct hosts, models and providers of stored
+// flows, and the most recently active tasks, for filter dropdowns.
+func (s *Server) getFacets(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	taskLimit := 50
+	if v := r.URL.Query().Get("task_limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 1000 {
+			taskLimit = n
+		}
+	}
+
+	facets, err := s.store.ListFlowFacets(ctx, taskLimit)
+	if err != nil {
+		s.logger.Error("failed to list flow facets", "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+
+	s.writeJSON(w, FacetsResponse{
+		Hosts:     toFacetValueResponses(facets.Hosts),
+		Models:    toFacetValueResponses(facets.Models),
+		Providers: toFacetValueResponses(facets.Providers),
+		Tasks:     toFacetValueResponses(facets.Tasks),
+	})
+}
+
+func toFacetValueResponses(values []*store.FacetValue) []FacetValueResponse {
+	out := make([]FacetValueResponse, len(values))
+	for i, v := range values {
+		out[i] = FacetValueResponse{Value: v.Value, Count: v.Count, LastSeen: v.LastSeen}
+	}
+	return out
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/HakAl/langley/internal/analytics"
+)
+
+// PricingUpdateRequest is the body of PUT /api/pricing/{provider}/{model_pattern}.
+// Rates are USD per 1,000 tokens.
+type PricingUpdateRequest struct {
+	InputCostPer1k     *float64 `json:"input_cost_per_1k"`
+	OutputCostPer1k    *float64 `json:"output_cost_per_1k"`
+	CacheCreationPer1k *float64 `json:"cache_creation_per_1k,omitempty"`
+	CacheReadPer1k     *float64 `json:"cache_read_per_1k,omitempty"`
+	EffectiveDate      string   `json:"effective_date,omitempty"` // YYYY-MM-DD, default today (UTC)
+}
+
+// PricingResponse is a pricing table row.
+type PricingResponse struct {
+	Provider           string   `json:"provider"`
+	ModelPattern       string   `json:"model_pattern"`
+	InputCostPer1k     float64  `json:"input_cost_per_1k"`
+	OutputCostPer1k    float64  `json:"output_cost_per_1k"`
+	CacheCreationPer1k *float64 `json:"cache_creation_per_1k,omitempty"`
+	CacheReadPer1k     *float64 `json:"cache_read_per_1k,omitempty"`
+	EffectiveDate      string   `json:"effective_date"`
+}
+
+// validate checks the rates and date, returning the effective date.
+func (req *PricingUpdateRequest) validate(now time.Time) (time.Time, error) {
+	var errs []error
+	if req.InputCostPer1k == nil || req.OutputCostPer1k == nil {
+		errs = append(errs, errors.New("input_cost_per_1k and output_cost_per_1k are required"))
+	}
+	for name, v := range map[string]*float64{
+		"input_cost_per_1k":     req.InputCostPer1k,
+		"output_cost_per_1k":    req.OutputCostPer1k,
+		"cache_creation_per_1k": req.CacheCreationPer1k,
+		"cache_read_per_1k":     req.CacheReadPer1k,
+	} {
+		if v != nil && *v < 0 {
+			errs = append(errs, errors.New(name+" must not be negative"))
+		}
+	}
+
+	effective := now.UTC().Truncate(24 * time.Hour)
+	if req.EffectiveDate != "" {
+		d, err := time.Parse("2006-01-02", req.EffectiveDate)
+		if err != nil {
+			errs = append(errs, errors.New("effective_date must be YYYY-MM-DD"))
+		}
+		effective = d
+	}
+	return effective, errors.Join(errs...)
+}
+
+// upsertPricing adds or replaces a pricing table rate. The model pattern is
+// a SQL LIKE pattern ("gpt-5%") and may contain slashes. Flows costed after
+// the effective date use it when LiteLLM has no price for the model.
+func (s *Server) upsertPricing(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if s.analytics == nil {
+		writeError(w, http.StatusServiceUnavailable, errCodeUnavailable, "Analytics unavailable")
+		return
+	}
+
+	provider := strings.TrimSpace(r.PathValue("provider"))
+	pattern := strings.TrimSpace(r.PathValue("model_pattern"))
+	if provider == "" || pattern == "" {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "provider and model_pattern are required")
+		return
+	}
+
+	var req PricingUpdateRequest
+	dec := json.NewDecoder(io.LimitReader(r.Body, 64*1024))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidJSON, "Invalid JSON: "+err.Error())
+		return
+	}
+	effective, err := req.validate(time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+		return
+	}
+
+	p := &analytics.ModelPricing{
+		Provider:           provider,
+		ModelPattern:       pattern,
+		InputCostPer1k:     *req.InputCostPer1k,
+		OutputCostPer1k:    *req.OutputCostPer1k,
+		CacheCreationPer1k: req.CacheCreationPer1k,
+		CacheReadPer1k:     req.CacheReadPer1k,
+		EffectiveDate:      effective,
+	}
+	if err := s.analytics.UpsertPricing(ctx, p); err != nil {
+		s.logger.Error("failed to upsert pricing", "provider", provider, "model_pattern", pattern, "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return
+	}
+	s.logger.Info("pricing updated", "provider", provider, "model_pattern", pattern, "effective_date", effective.Format("2006-01-02"))
+
+	s.writeJSON(w, PricingResponse{
+		Provider:           p.Provider,
+		ModelPattern:       p.ModelPattern,
+		InputCostPer1k:     p.InputCostPer1k,
+		OutputCostPer1k:    p.OutputCostPer1k,
+		CacheCreationPer1k: p.CacheCreationPer1k,
+		CacheReadPer1k:     p.CacheReadPer1k,
+		EffectiveDate:      effective.Format("2006-01-02"),
+	})
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/config"
+	"github.com/HakAl/langley/internal/proxy"
+	"github.com/HakAl/langley/internal/redact"
+	"github.com/HakAl/langley/internal/store"
+	langleytls "github.com/HakAl/langley/internal/tls"
+)
+
+func TestUpsertPricing(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Token = "test-token"
+	cfg.Auth.Tokens = []config.ScopedToken{
+		{Name: "dashboard", Hash: config.HashToken("read-token"), Scope: config.ScopeRead},
+	}
+	cfg.Providers.Custom = []config.CustomProviderConfig{
+		{Name: "acme", Hosts: []string{"127.0.0.1"}, Parser: "openai"},
+	}
+	dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer dataStore.Close()
+	handler := NewServer(cfg, dataStore, nil).Handler()
+
+	put := func(path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	const path = "/api/pricing/openai/acme%2Fmodel-%25"
+	if rr := put(path, "read-token", `{"input_cost_per_1k":1,"output_cost_per_1k":2}`); rr.Code != http.StatusForbidden {
+		t.Errorf("read token: got status %d, want 403", rr.Code)
+	}
+	for _, body := range []string{
+		`{"input_cost_per_1k":1}`,
+		`{"input_cost_per_1k":-1,"output_cost_per_1k":2}`,
+		`{"input_cost_per_1k":1,"output_cost_per_1k":2,"effective_date":"tomorrow"}`,
+		`{"input_cost_per_1k":1,"output_cost_per_1k":2,"bogus":true}`,
+	} {
+		if rr := put(path, "test-token", body); rr.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s: got status %d, want 400", body, rr.Code)
+		}
+	}
+
+	// A future-dated rate isn't used yet; the rate effective today is
+	future := time.Now().UTC().AddDate(0, 0, 7).Format("2006-01-02")
+	if rr := put(path, "test-token", `{"input_cost_per_1k":100,"output_cost_per_1k":100,"effective_date":"`+future+`"}`); rr.Code != http.StatusOK {
+		t.Fatalf("PUT future: got status %d, body: %s", rr.Code, rr.Body.String())
+	}
+	rr := put(path, "test-token", `{"input_cost_per_1k":0.5,"output_cost_per_1k":2}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("PUT: got status %d, body: %s", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), `"model_pattern":"acme/model-%"`) {
+		t.Errorf("response = %s", rr.Body.String())
+	}
+
+	// The next flow for a matching model is costed at the new rate
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"model":"acme/model-7","choices":[],"usage":{"prompt_tokens":1000,"completion_tokens":500}}`))
+	}))
+	defer upstream.Close()
+
+	ca, err := langleytls.LoadOrCreateCA(t.TempDir())
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA: %v", err)
+	}
+	redactor, _ := redact.New(&config.RedactionConfig{})
+	done := make(chan *store.Flow, 1)
+	mitm, err := proxy.NewMITMProxy(proxy.MITMProxyConfig{
+		Config:    cfg,
+		CA:        ca,
+		CertCache: langleytls.NewCertCache(ca, 10),
+		Redactor:  redactor,
+		Store:     dataStore,
+		OnUpdate:  func(f *store.Flow) { done <- f },
+	})
+	if err != nil {
+		t.Fatalf("NewMITMProxy: %v", err)
+	}
+	proxySrv := httptest.NewServer(mitm)
+	defer proxySrv.Close()
+
+	proxyURL, _ := url.Parse(proxySrv.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}, Timeout: 5 * time.Second}
+	resp, err := client.Post(upstream.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("request through proxy: %v", err)
+	}
+	resp.Body.Close()
+
+	var flow *store.Flow
+	select {
+	case flow = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("flow not completed")
+	}
+	stored, err := dataStore.GetFlow(context.Background(), flow.ID)
+	if err != nil {
+		t.Fatalf("GetFlow: %v", err)
+	}
+	// 1000 in at $0.5/1k + 500 out at $2/1k
+	if stored.TotalCost == nil || math.Abs(*stored.TotalCost-1.5) > 1e-9 {
+		t.Errorf("TotalCost = %v, want 1.5", stored.TotalCost)
+	}
+}
@@ -100,7 +100,7 @@ func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
 
 		if !rl.Allow(ip) {
 			w.Header().Set("Retry-After", "1")
-			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			writeError(w, http.StatusTooManyRequests, errCodeRateLimited, "Too Many Requests")
 			return
 		}
 
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/HakAl/langley/internal/config"
+)
+
+// scopeKey is the context key for the authenticated token's scope.
+type scopeKey struct{}
+
+// withScope returns r with the token scope attached to its context.
+func withScope(r *http.Request, scope string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), scopeKey{}, scope))
+}
+
+// requestScope returns the scope authMiddleware attached to r, or "" for
+// a request that wasn't authenticated.
+func requestScope(r *http.Request) string {
+	scope, _ := r.Context().Value(scopeKey{}).(string)
+	return scope
+}
+
+// requireAdmin wraps a handler that needs an admin-scoped token. It must be
+// inside authMiddleware.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if requestScope(r) != config.ScopeAdmin {
+			s.logger.Warn("rejected request without admin scope", "path", r.URL.Path, "remote", r.RemoteAddr)
+			writeError(w, http.StatusForbidden, errCodeAdminRequired, "Forbidden: admin token required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireLocalAdmin wraps an /api/admin/* handler so it only answers local
+// connections, unless api.allow_remote_admin is set for a shared server.
+func (s *Server) requireLocalAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.cfg.API.AllowRemoteAdmin && !isLocalRequest(r) {
+			s.logger.Warn("rejected remote admin request", "path", r.URL.Path, "remote", r.RemoteAddr)
+			writeError(w, http.StatusForbidden, errCodeLocalhostOnly, "Admin endpoints are localhost-only unless api.allow_remote_admin is set")
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"github.com/HakAl/langley/internal/store"
+)
+
+// scrubFlow redacts a stored flow's bodies with the live redactor when
+// redaction.scrub_on_read is on, so bodies stored raw are served redacted
+// under the current rules. Without a redactor the bodies are dropped rather
+// than served raw.
+func (s *Server) scrubFlow(f *store.Flow) {
+	if !s.cfg.Redaction.Snapshot().ScrubOnRead {
+		return
+	}
+	r := s.redactor.Load()
+	for _, body := range []**string{&f.RequestBody, &f.ResponseBody} {
+		if *body == nil {
+			continue
+		}
+		if r == nil {
+			*body = nil
+			continue
+		}
+		scrubbed := r.RedactForRead(**body)
+		*body = &scrubbed
+	}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/HakAl/langley/internal/config"
+	"github.com/HakAl/langley/internal/store"
+)
+
+// errUnknownWorkspace is returned for a workspace with no database on disk.
+var errUnknownWorkspace = errors.New("unknown workspace")
+
+// WithWorkspaces lets requests read other workspaces with ?workspace=name.
+// dir holds the workspace databases (see config.WorkspaceDBPath) and
+// current is the workspace this server's own store belongs to.
+func WithWorkspaces(dir, current string) ServerOption {
+	return func(s *Server) {
+		s.workspaceDir = dir
+		s.workspace = current
+	}
+}
+
+// routeWorkspace sends requests for another workspace to a server backed by
+// that workspace's database. Requests without ?workspace, or for the current
+// one, are served from this server's store.
+func (s *Server) routeWorkspace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("workspace")
+		if name == "" || name == s.workspace {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := config.ValidateWorkspaceName(name); err != nil {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+			return
+		}
+
+		ws, err := s.workspaceServer(name)
+		if errors.Is(err, errUnknownWorkspace) {
+			writeError(w, http.StatusNotFound, errCodeNotFound, "Unknown workspace")
+			return
+		}
+		if err != nil {
+			s.logger.Error("failed to open workspace", "workspace", name, "error", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+		// CORS and rate limiting were already applied by this server
+		ws.mux.ServeHTTP(w, r)
+	})
+}
+
+// workspaceServer returns the server for workspace name, opening its
+// database on first use. Workspaces are never created from the API.
+func (s *Server) workspaceServer(name string) (*Server, error) {
+	s.workspacesMu.Lock()
+	defer s.workspacesMu.Unlock()
+
+	if ws, ok := s.workspaces[name]; ok {
+		return ws, nil
+	}
+	if s.workspaceDir == "" {
+		return nil, errUnknownWorkspace
+	}
+
+	path := config.WorkspaceDBPath(s.workspaceDir, name)
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil, errUnknownWorkspace
+		}
+		return nil, err
+	}
+	dataStore, err := store.NewSQLiteStore(path, &s.cfg.Retention,
+		store.WithPersistenceConfig(&s.cfg.Persistence))
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+
+	ws := NewServer(s.cfg, dataStore, s.logger,
+		WithConfigPath(s.cfgPath),
+		WithPricingSource(s.pricingSource),
+		WithCA(s.ca),
+	)
+	ws.workspace = name
+	ws.redactor = s.redactor // Follow reloads of the main server
+	if s.workspaces == nil {
+		s.workspaces = make(map[string]*Server)
+	}
+	s.workspaces[name] = ws
+	return ws, nil
+}
+
+// Close closes the databases of other workspaces opened for requests.
+// The server's own store is owned by the caller.
+func (s *Server) Close() error {
+	s.workspacesMu.Lock()
+	defer s.workspacesMu.Unlock()
+
+	var errs []error
+	for name, ws := range s.workspaces {
+		if err := ws.store.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing workspace %s: %w", name, err))
+		}
+	}
+	s.workspaces = nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Token scopes. Read tokens can use the read-only API; admin tokens can also
+// change settings, tags and the database.
+const (
+	ScopeRead  = "read"
+	ScopeAdmin = "admin"
+)
+
+// tokenHashPrefix marks the hash algorithm of a stored token.
+const tokenHashPrefix = "sha256:"
+
+// ScopedToken is an API token stored by hash.
+type ScopedToken struct {
+	Name  string `yaml:"name"`
+	Hash  string `yaml:"hash"`  // "sha256:<hex>", see HashToken
+	Scope string `yaml:"scope"` // "read" or "admin"
+}
+
+// HashToken returns the form a token is stored in under auth.tokens.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return tokenHashPrefix + hex.EncodeToString(sum[:])
+}
+
+// MinRemoteTokenLength is the shortest auth.token accepted when the API
+// listens beyond loopback. Generated tokens are 72 characters.
+const MinRemoteTokenLength = 32
+
+// IsLoopbackListen reports whether a listen address only accepts local
+// connections: a Unix socket ("unix:/path"), localhost or a loopback IP. An
+// empty host (":9091") binds every interface, and any other hostname may
+// resolve to a routable address, so neither counts.
+func IsLoopbackListen(addr string) bool {
+	if strings.HasPrefix(addr, "unix:") {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = strings.Trim(addr, "[]")
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ValidateForListen refuses a primary token too weak for an API listening
+// on addr: beyond loopback, auth.token must be at least
+// MinRemoteTokenLength characters and not a short run repeated. It is
+// checked at startup and again on every reload.
+func (c *AuthConfig) ValidateForListen(addr string) error {
+	if IsLoopbackListen(addr) {
+		return nil
+	}
+	switch {
+	case c.Token == "":
+		return fmt.Errorf("api.listen %s accepts connections from other hosts, but auth.token is empty", addr)
+	case len(c.Token) < MinRemoteTokenLength:
+		return fmt.Errorf("api.listen %s accepts connections from other hosts, but auth.token is shorter than %d characters", addr, MinRemoteTokenLength)
+	case distinctChars(c.Token) < 8:
+		return fmt.Errorf("api.listen %s accepts connections from other hosts, but auth.token is too repetitive to be a random secret", addr)
+	}
+	return nil
+}
+
+// distinctChars counts the different characters in s.
+func distinctChars(s string) int {
+	seen := make(map[rune]bool)
+	for _, r := range s {
+		seen[r] = true
+	}
+	return len(seen)
+}
+
+// Validate checks the scoped tokens.
+func (c *AuthConfig) Validate() error {
+	var errs []error
+	names := make(map[string]bool)
+	for i, t := range c.Tokens {
+		if t.Name == "" {
+			errs = append(errs, fmt.Errorf("tokens[%d]: name is required", i))
+		} else if names[t.Name] {
+			errs = append(errs, fmt.Errorf("tokens[%d]: duplicate name %q", i, t.Name))
+		}
+		names[t.Name] = true
+		if raw, ok := strings.CutPrefix(t.Hash, tokenHashPrefix); !ok || len(raw) != sha256.Size*2 {
+			errs = append(errs, fmt.Errorf("tokens[%d]: hash must be %q followed by 64 hex digits", i, tokenHashPrefix))
+		} else if _, err := hex.DecodeString(raw); err != nil {
+			errs = append(errs, fmt.Errorf("tokens[%d]: hash: %w", i, err))
+		}
+		if t.Scope != ScopeRead && t.Scope != ScopeAdmin {
+			errs = append(errs, fmt.Errorf("tokens[%d]: scope must be %q or %q", i, ScopeRead, ScopeAdmin))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Authenticate returns the scope of token, or false if it matches neither
+// the primary token nor any of auth.tokens. Every stored hash is compared
+// in constant time so the position of a match doesn't leak.
+func (c *AuthConfig) Authenticate(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	live.RLock()
+	defer live.RUnlock()
+	if c.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(c.Token)) == 1 {
+		return ScopeAdmin, true
+	}
+
+	hash := []byte(HashToken(token))
+	scope := ""
+	for _, t := range c.Tokens {
+		if subtle.ConstantTimeCompare(hash, []byte(strings.ToLower(t.Hash))) == 1 && scope == "" {
+			scope = t.Scope
+		}
+	}
+	return scope, scope != ""
+}
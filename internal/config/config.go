@@ -5,10 +5,13 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -22,43 +25,222 @@ type Config struct {
 	Retention   RetentionConfig   `yaml:"retention"`
 	Redaction   RedactionConfig   `yaml:"redaction"`
 	Auth        AuthConfig        `yaml:"auth"`
+	API         APIConfig         `yaml:"api"`
+	Parser      ParserConfig      `yaml:"parser"`
 	Task        TaskConfig        `yaml:"task"`
+	Logging     LoggingConfig     `yaml:"logging"`
+	Export      ExportConfig      `yaml:"export"`
+	TLS         TLSConfig         `yaml:"tls"`
+	Budget      BudgetConfig      `yaml:"budget"`
+	Providers   ProvidersConfig   `yaml:"providers"`
+	Telemetry   TelemetryConfig   `yaml:"telemetry"`
+}
+
+// TelemetryConfig configures tracing of proxied requests.
+type TelemetryConfig struct {
+	// OTLPEndpoint is an OTLP/HTTP collector, e.g. http://localhost:4318.
+	// Empty (the default) disables tracing.
+	OTLPEndpoint string            `yaml:"otlp_endpoint"`
+	OTLPHeaders  map[string]string `yaml:"otlp_headers"` // Sent with every export, e.g. an auth header
+	ServiceName  string            `yaml:"service_name"` // Resource service.name (default: "langley")
+}
+
+// ProvidersConfig configures LLM providers beyond the built-in set.
+type ProvidersConfig struct {
+	Custom []CustomProviderConfig `yaml:"custom"`
+}
+
+// CustomProviderConfig defines a provider for hosts Langley doesn't know,
+// such as a self-hosted server that speaks an existing API protocol.
+type CustomProviderConfig struct {
+	Name   string   `yaml:"name"`
+	Hosts  []string `yaml:"hosts"`  // Domain suffixes to match, e.g. "llm.internal"
+	Parser string   `yaml:"parser"` // Built-in provider whose response parsing to reuse: openai, anthropic, gemini, bedrock or ollama
+}
+
+// BudgetConfig configures spend alerts.
+type BudgetConfig struct {
+	DailyUSD float64 `yaml:"daily_usd"` // Alert when a UTC day's cost reaches this (0 = disabled)
+}
+
+// TLSConfig configures interception certificate generation.
+type TLSConfig struct {
+	MaxCertGenConcurrency int `yaml:"max_cert_gen_concurrency"` // Leaf certificates signed at once (default: 4)
+}
+
+// ExportConfig configures export destinations.
+type ExportConfig struct {
+	S3 S3ExportConfig `yaml:"s3"`
+}
+
+// S3ExportConfig holds defaults for POST /api/flows/export/s3.
+// Request fields override these, but a request can only name Endpoint or
+// one of AllowedEndpoints. Credentials must come from here or the request.
+type S3ExportConfig struct {
+	Endpoint         string   `yaml:"endpoint"`          // host[:port], e.g. "s3.amazonaws.com" or "localhost:9000"
+	AllowedEndpoints []string `yaml:"allowed_endpoints"` // Other endpoints a request may name
+	Bucket           string   `yaml:"bucket"`
+	Prefix           string   `yaml:"prefix"` // Object key prefix, e.g. "langley/"
+	Region           string   `yaml:"region"`
+	AccessKeyID      string   `yaml:"access_key_id"`
+	SecretAccessKey  string   `yaml:"secret_access_key"`
+	Insecure         bool     `yaml:"insecure"` // Use plain HTTP (local MinIO)
+}
+
+// LoggingConfig configures what the proxy writes to its own logs.
+type LoggingConfig struct {
+	RedactLogs bool `yaml:"redact_logs"` // Redact URLs, headers and errors in log output (default: true)
 }
 
 // TaskConfig configures task grouping behavior.
 type TaskConfig struct {
-	IdleGapMinutes int `yaml:"idle_gap_minutes"` // Minutes of inactivity before starting new task
+	IdleGapMinutes int    `yaml:"idle_gap_minutes"` // Minutes of inactivity before starting new task
+	QueryParam     string `yaml:"query_param"`      // Query parameter for explicit task IDs (stripped before forwarding)
+	IDJSONPath     string `yaml:"id_json_path"`     // Dotted path of the request body field holding a task ID
 }
 
 // ProxyConfig configures the HTTP/TLS proxy.
 type ProxyConfig struct {
-	Listen         string   `yaml:"listen"`          // e.g., "localhost:9090"
-	Host           string   `yaml:"host"`            // Bind host
-	Port           int      `yaml:"port"`            // Bind port (alternative to listen)
-	InterceptHosts []string `yaml:"intercept_hosts"` // Additional hosts to MITM (e.g., Azure OpenAI, OpenRouter)
+	Listen         string        `yaml:"listen"`           // e.g., "localhost:9090"
+	Host           string        `yaml:"host"`             // Bind host
+	Port           int           `yaml:"port"`             // Bind port (alternative to listen)
+	InterceptHosts []string      `yaml:"intercept_hosts"`  // Additional hosts to MITM (e.g., Azure OpenAI, OpenRouter)
+	GRPCHosts      []string      `yaml:"grpc_hosts"`       // Intercepted hosts whose HTTP/2 clients are tunneled untouched (gRPC gateways)
+	TLSIdleTimeout time.Duration `yaml:"tls_idle_timeout"` // Close tunnels idle this long, e.g. "5m" (0 = default)
+
+	// UpstreamTrustCerts are PEM files of certificates or CAs trusted for
+	// upstream TLS in addition to the system roots (e.g. a self-signed gateway).
+	UpstreamTrustCerts []string `yaml:"upstream_trust_certs"`
+	// UpstreamOverrides dials another address for a host, e.g.
+	// api.anthropic.com: localhost:8443. Host header, SNI and capture keep
+	// the original host.
+	UpstreamOverrides map[string]string `yaml:"upstream_overrides"`
+	// AllowedCIDRs restricts the proxy to clients in these ranges, e.g.
+	// "192.168.1.0/24" or a single "10.0.0.5". Others get 403 and their
+	// connection closed. Empty allows every client.
+	AllowedCIDRs []string `yaml:"allowed_cidrs"`
+
+	// UpstreamMaxIdlePerHost keeps up to this many idle upstream connections
+	// per intercepted HTTPS host for reuse by later tunnels (0 = 4, negative
+	// disables pooling). UpstreamIdleTimeout closes them after sitting idle
+	// this long (0 = 30s, or tls_idle_timeout if shorter).
+	UpstreamMaxIdlePerHost int           `yaml:"upstream_max_idle_per_host"`
+	UpstreamIdleTimeout    time.Duration `yaml:"upstream_idle_timeout"`
+
+	// MaxConcurrentUpstream caps requests forwarded upstream at once (0 = unlimited).
+	// Requests over the cap wait ("queue") or get a 503 ("reject").
+	MaxConcurrentUpstream int           `yaml:"max_concurrent_upstream"`
+	UpstreamOverflow      string        `yaml:"upstream_overflow"`      // "queue" (default) or "reject"
+	UpstreamQueueTimeout  time.Duration `yaml:"upstream_queue_timeout"` // 503 after queueing this long (0 = wait until the client gives up)
+
+	// RateLimits pace requests per model, matched against the request
+	// body's "model" field. Requests over a limit wait for room ("delay")
+	// or get a 429 ("reject").
+	RateLimits        []ModelRateLimit `yaml:"rate_limits"`
+	RateLimitOverflow string           `yaml:"rate_limit_overflow"` // "delay" (default) or "reject"
+
+	// ValidateRequestJSON flags flows whose request body is sent as JSON but
+	// doesn't parse. Bodies over ValidateRequestJSONMaxBytes (default 1MB)
+	// are not checked.
+	ValidateRequestJSON         bool `yaml:"validate_request_json"`
+	ValidateRequestJSONMaxBytes int  `yaml:"validate_request_json_max_bytes"`
+
+	// RequestStreamThresholdBytes streams request bodies larger than this
+	// upstream instead of reading them whole; only the first this-many bytes
+	// are kept for storage and parsing (0 = 8MB).
+	RequestStreamThresholdBytes int `yaml:"request_stream_threshold_bytes"`
+}
+
+// ModelRateLimit caps requests and estimated input tokens per minute for
+// the models matching Model. Models matching one rule share its budget.
+type ModelRateLimit struct {
+	Model             string `yaml:"model"`               // Model name; "*" matches any run of characters
+	RequestsPerMinute int    `yaml:"requests_per_minute"` // 0 = no request limit
+	TokensPerMinute   int    `yaml:"tokens_per_minute"`   // 0 = no token limit
 }
 
 // MemoryConfig configures in-memory caching.
 type MemoryConfig struct {
-	MaxFlows         int `yaml:"max_flows"`           // N - flows in RAM
-	MaxEventsPerFlow int `yaml:"max_events_per_flow"` // M - events per flow in RAM
+	MaxFlows            int `yaml:"max_flows"`             // N - flows in RAM
+	MaxEventsPerFlow    int `yaml:"max_events_per_flow"`   // M - events per flow in RAM
+	PressureThresholdMB int `yaml:"pressure_threshold_mb"` // Heap MB above which capture is metadata-only (0 = disabled)
 }
 
 // PersistenceConfig configures SQLite persistence.
 type PersistenceConfig struct {
-	DBPath             string `yaml:"db_path"`
-	BodyMaxBytes       int    `yaml:"body_max_bytes"`
-	EventBatchSize     int    `yaml:"event_batch_size"`
-	EventBatchTimeoutMs int    `yaml:"event_batch_timeout_ms"`
-	QueueMaxSize       int    `yaml:"queue_max_size"`
+	DBPath              string             `yaml:"db_path"`
+	BodyMaxBytes        int                `yaml:"body_max_bytes"`
+	EventBatchSize      int                `yaml:"event_batch_size"`
+	EventBatchTimeoutMs int                `yaml:"event_batch_timeout_ms"`
+	QueueMaxSize        int                `yaml:"queue_max_size"`
+	VacuumIntervalHours int                `yaml:"vacuum_interval_hours"` // Scheduled VACUUM interval (0 = disabled)
+	StoreHeaders        StoreHeadersConfig `yaml:"store_headers"`
+	// WALAutocheckpointPages sets PRAGMA wal_autocheckpoint and the WAL size
+	// past which a background monitor checkpoints, escalating to TRUNCATE when
+	// readers keep blocking it (0 = SQLite defaults, no monitor).
+	WALAutocheckpointPages int `yaml:"wal_autocheckpoint_pages"`
+	// BusyTimeoutMs is how long a connection waits on a locked database
+	// before failing with "database is locked".
+	BusyTimeoutMs int `yaml:"busy_timeout_ms"`
+	// Synchronous is PRAGMA synchronous: OFF, NORMAL, FULL or EXTRA.
+	Synchronous string `yaml:"synchronous"`
+	// CacheSizeKB sets PRAGMA cache_size per connection (0 = SQLite default).
+	CacheSizeKB int `yaml:"cache_size_kb"`
+	// ReadConnections opens a separate pool of read-only connections for
+	// flow listing and lookups, so reads don't queue behind the single
+	// writer (0 = share the writer connection).
+	ReadConnections int `yaml:"read_connections"`
+	// CompressBodies gzips stored request and response bodies. Bodies are
+	// read back the same way whatever the setting was when they were written.
+	CompressBodies bool `yaml:"compress_bodies"`
 }
 
-// AnalyticsConfig configures anomaly detection thresholds.
+// Header storage modes for StoreHeadersConfig.Mode.
+const (
+	StoreHeadersAll       = "all"
+	StoreHeadersNone      = "none"
+	StoreHeadersAllowlist = "allowlist"
+)
+
+// StoreHeadersConfig selects which request/response headers are persisted
+// with flows. Forwarding is unaffected.
+type StoreHeadersConfig struct {
+	Mode      string   `yaml:"mode"`      // "all" (default), "none", or "allowlist"
+	Allowlist []string `yaml:"allowlist"` // Header names kept in allowlist mode (case-insensitive)
+}
+
+// ParserConfig configures which parsed SSE events are stored.
+type ParserConfig struct {
+	// StoreDeltas selects the content deltas stored per flow: "all"
+	// (default), "none", or "sampled:N" to keep every Nth. Start, stop and
+	// tool-use events are always stored.
+	StoreDeltas string `yaml:"store_deltas"`
+	// MaxEventsPerFlow stops storing a stream's events once this many have
+	// been stored for the flow (0 = no limit). The client still gets the
+	// whole stream.
+	MaxEventsPerFlow int `yaml:"max_events_per_flow"`
+}
+
+// AnalyticsConfig configures anomaly detection thresholds and cost calculation.
 type AnalyticsConfig struct {
-	AnomalyContextTokens      int `yaml:"anomaly_context_tokens"`
-	AnomalyToolDelayMs        int `yaml:"anomaly_tool_delay_ms"`
-	AnomalyRapidCallsWindowS  int `yaml:"anomaly_rapid_calls_window_s"`
-	AnomalyRapidCallsThreshold int `yaml:"anomaly_rapid_calls_threshold"`
+	AnomalyContextTokens       int                 `yaml:"anomaly_context_tokens"`
+	AnomalyToolDelayMs         int                 `yaml:"anomaly_tool_delay_ms"`
+	AnomalyRapidCallsWindowS   int                 `yaml:"anomaly_rapid_calls_window_s"`
+	AnomalyRapidCallsThreshold int                 `yaml:"anomaly_rapid_calls_threshold"`
+	AnomalyLatencySigma        float64             `yaml:"anomaly_latency_sigma"`     // Flag flows this many std devs slower than their model's mean (0 = disabled)
+	PricingTiers               []PricingTierConfig `yaml:"pricing_tiers"`             // Volume discount tiers (optional)
+	CaptureRateLimits          bool                `yaml:"capture_rate_limits"`       // Store anthropic-ratelimit-* / x-ratelimit-* headers on flows
+	CaptureCacheBreakpoints    bool                `yaml:"capture_cache_breakpoints"` // Store cache_control breakpoint count/positions from request bodies
+}
+
+// PricingTierConfig overrides per-token rates for a provider/model once its
+// month-to-date token volume (input + output) reaches MinMonthlyTokens.
+type PricingTierConfig struct {
+	Provider         string  `yaml:"provider"`
+	Model            string  `yaml:"model"`              // Model name; "*" matches any run of characters
+	MinMonthlyTokens int64   `yaml:"min_monthly_tokens"` // Tier applies at or above this volume
+	InputCostPer1k   float64 `yaml:"input_cost_per_1k"`
+	OutputCostPer1k  float64 `yaml:"output_cost_per_1k"`
 }
 
 // RetentionConfig configures data retention TTLs.
@@ -71,40 +253,90 @@ type RetentionConfig struct {
 
 // RedactionConfig configures credential redaction.
 type RedactionConfig struct {
-	AlwaysRedactHeaders []string `yaml:"always_redact_headers"`
-	PatternRedactHeaders []string `yaml:"pattern_redact_headers"`
-	RedactAPIKeys        bool `yaml:"redact_api_keys"`
-	RedactBase64Images   bool `yaml:"redact_base64_images"`
-	DisableBodyStorage   bool `yaml:"disable_body_storage"`
+	AlwaysRedactHeaders  []string                 `yaml:"always_redact_headers"`
+	PatternRedactHeaders []string                 `yaml:"pattern_redact_headers"`
+	RedactAPIKeys        bool                     `yaml:"redact_api_keys"`
+	RedactBase64Images   bool                     `yaml:"redact_base64_images"`
+	DisableBodyStorage   bool                     `yaml:"disable_body_storage"`
+	CustomPatterns       []CustomRedactionPattern `yaml:"custom_patterns"` // Extra body secret patterns
+	// ScrubOnRead stores bodies raw and redacts them when the API serves
+	// them, with the redaction settings current at that time
+	ScrubOnRead bool `yaml:"scrub_on_read"`
+}
+
+// CustomRedactionPattern is a user-defined body redaction rule.
+type CustomRedactionPattern struct {
+	Pattern     string `yaml:"pattern"`     // Go regular expression
+	Replacement string `yaml:"replacement"` // Supports $1-style group references (default: "[REDACTED]")
 }
 
 // AuthConfig configures API authentication.
 type AuthConfig struct {
-	Token string `yaml:"token"` // Bearer token for API access
+	Token string `yaml:"token"` // Bearer token for API access (admin scope)
+
+	// Tokens are additional API tokens, stored hashed (see HashToken).
+	Tokens []ScopedToken `yaml:"tokens,omitempty"`
+}
+
+// APIConfig configures the API and dashboard server.
+type APIConfig struct {
+	// Listen is the API and dashboard address, e.g. "localhost:9091". A
+	// non-loopback address such as "0.0.0.0:9091" shares the dashboard on
+	// the network and requires a strong auth.token.
+	Listen string `yaml:"listen"`
+
+	// AllowRemoteAdmin lets admin tokens use /api/admin/* from other hosts.
+	// By default admin endpoints only answer local connections.
+	AllowRemoteAdmin bool `yaml:"allow_remote_admin"`
+
+	// CORSOrigins are browser origins trusted in addition to localhost:
+	// exact origins ("https://langley.internal:9091") or host suffix
+	// patterns ("*.internal") matching any scheme and port.
+	CORSOrigins []string `yaml:"cors_origins"`
+
+	// WSPingInterval is how often WebSocket clients are pinged. A client
+	// that hasn't answered within WSPongTimeout of a ping is disconnected,
+	// so dead connections don't linger (0 = 30s and 10s).
+	WSPingInterval time.Duration `yaml:"ws_ping_interval"`
+	WSPongTimeout  time.Duration `yaml:"ws_pong_timeout"`
 }
 
 // DefaultConfig returns a Config with secure defaults.
 func DefaultConfig() *Config {
 	return &Config{
 		Proxy: ProxyConfig{
-			Listen: "localhost:9090",
+			Listen:         "localhost:9090",
+			TLSIdleTimeout: 5 * time.Minute,
+		},
+		API: APIConfig{
+			Listen: "localhost:9091",
 		},
 		Memory: MemoryConfig{
 			MaxFlows:         1000,
 			MaxEventsPerFlow: 500,
 		},
 		Persistence: PersistenceConfig{
-			DBPath:             "", // Set in Load based on platform
-			BodyMaxBytes:       1048576, // 1MB
-			EventBatchSize:     50,
-			EventBatchTimeoutMs: 1000,
-			QueueMaxSize:       10000,
+			DBPath:                 "",      // Set in Load based on platform
+			BodyMaxBytes:           1048576, // 1MB
+			EventBatchSize:         50,
+			EventBatchTimeoutMs:    1000,
+			QueueMaxSize:           10000,
+			WALAutocheckpointPages: 1000,
+			BusyTimeoutMs:          5000,
+			Synchronous:            "NORMAL",
+			StoreHeaders:           StoreHeadersConfig{Mode: StoreHeadersAll},
+		},
+		Parser: ParserConfig{
+			StoreDeltas: "all",
 		},
 		Analytics: AnalyticsConfig{
-			AnomalyContextTokens:      100000,
-			AnomalyToolDelayMs:        30000,
-			AnomalyRapidCallsWindowS:  10,
+			AnomalyContextTokens:       100000,
+			AnomalyToolDelayMs:         30000,
+			AnomalyRapidCallsWindowS:   10,
 			AnomalyRapidCallsThreshold: 5,
+			AnomalyLatencySigma:        3,
+			CaptureRateLimits:          true,
+			CaptureCacheBreakpoints:    true,
 		},
 		Retention: RetentionConfig{
 			FlowsTTLDays:   30,
@@ -116,7 +348,7 @@ func DefaultConfig() *Config {
 			AlwaysRedactHeaders: []string{
 				"authorization",
 				"x-api-key",
-				"api-key", // Azure OpenAI uses this header
+				"api-key",              // Azure OpenAI uses this header
 				"x-amz-security-token", // AWS session tokens
 				"cookie",
 				"set-cookie",
@@ -133,6 +365,17 @@ func DefaultConfig() *Config {
 		},
 		Task: TaskConfig{
 			IdleGapMinutes: 5, // Default 5 minutes between tasks
+			QueryParam:     "langley_task",
+			IDJSONPath:     "metadata.user_id",
+		},
+		Logging: LoggingConfig{
+			RedactLogs: true,
+		},
+		TLS: TLSConfig{
+			MaxCertGenConcurrency: 4,
+		},
+		Telemetry: TelemetryConfig{
+			ServiceName: "langley",
 		},
 	}
 }
@@ -220,6 +463,19 @@ func Load(path string) (*Config, error) {
 	// Apply environment variable overrides
 	cfg.applyEnvOverrides()
 
+	if err := cfg.API.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid api config: %w", err)
+	}
+	if err := cfg.Auth.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid auth config: %w", err)
+	}
+	if err := cfg.Task.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid task config: %w", err)
+	}
+	if err := cfg.Persistence.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid persistence config: %w", err)
+	}
+
 	// Generate token if not set
 	if cfg.Auth.Token == "" {
 		cfg.Auth.Token, err = generateToken()
@@ -243,7 +499,9 @@ func (c *Config) Save(path string) error {
 		return fmt.Errorf("creating config directory: %w", err)
 	}
 
+	live.RLock()
 	data, err := yaml.Marshal(c)
+	live.RUnlock()
 	if err != nil {
 		return fmt.Errorf("marshaling config: %w", err)
 	}
@@ -312,6 +570,86 @@ func (c *ProxyConfig) ListenAddr() string {
 	return fmt.Sprintf("%s:%d", host, port)
 }
 
+// Validate checks that every cors_origins entry is an http(s) origin or a
+// *.domain pattern.
+func (c *APIConfig) Validate() error {
+	for _, o := range c.CORSOrigins {
+		if suffix, ok := strings.CutPrefix(o, "*."); ok {
+			if suffix == "" || strings.ContainsAny(suffix, "/:*") {
+				return fmt.Errorf("cors_origins: invalid pattern %q, want *.domain", o)
+			}
+			continue
+		}
+		u, err := url.Parse(o)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+			return fmt.Errorf("cors_origins: invalid origin %q, want scheme://host[:port] or *.domain", o)
+		}
+	}
+	return nil
+}
+
+// AllowsOrigin reports whether a browser Origin header matches cors_origins.
+// Localhost is allowed separately and isn't covered here.
+func (c *APIConfig) AllowsOrigin(origin string) bool {
+	if len(c.CORSOrigins) == 0 || origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, o := range c.CORSOrigins {
+		if suffix, ok := strings.CutPrefix(o, "*."); ok {
+			if strings.HasSuffix(host, "."+strings.ToLower(suffix)) {
+				return true
+			}
+			continue
+		}
+		if strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonPathKeyRe matches one key of a dotted JSON path.
+var jsonPathKeyRe = regexp.MustCompile(`^[A-Za-z0-9_$@-]+$`)
+
+// Validate checks the task settings. id_json_path must be dot-separated
+// object keys, e.g. "request.context.trace_id".
+func (c *TaskConfig) Validate() error {
+	if c.IDJSONPath == "" {
+		return nil
+	}
+	for _, key := range strings.Split(c.IDJSONPath, ".") {
+		if !jsonPathKeyRe.MatchString(key) {
+			return fmt.Errorf("id_json_path: invalid path %q, want dot-separated keys like metadata.user_id", c.IDJSONPath)
+		}
+	}
+	return nil
+}
+
+// Validate checks the SQLite tuning settings.
+func (c *PersistenceConfig) Validate() error {
+	switch strings.ToUpper(c.Synchronous) {
+	case "", "OFF", "NORMAL", "FULL", "EXTRA":
+	default:
+		return fmt.Errorf("synchronous: invalid mode %q, want OFF, NORMAL, FULL or EXTRA", c.Synchronous)
+	}
+	if c.BusyTimeoutMs < 0 {
+		return fmt.Errorf("busy_timeout_ms: must not be negative, got %d", c.BusyTimeoutMs)
+	}
+	if c.CacheSizeKB < 0 {
+		return fmt.Errorf("cache_size_kb: must not be negative, got %d", c.CacheSizeKB)
+	}
+	if c.ReadConnections < 0 {
+		return fmt.Errorf("read_connections: must not be negative, got %d", c.ReadConnections)
+	}
+	return nil
+}
+
 // HeaderShouldRedact checks if a header name should be redacted.
 func (c *RedactionConfig) HeaderShouldRedact(name string) bool {
 	nameLower := strings.ToLower(name)
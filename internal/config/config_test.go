@@ -0,0 +1,92 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoad_TaskIDJSONPath(t *testing.T) {
+	t.Parallel()
+
+	load := func(path string) error {
+		t.Helper()
+		cfgPath := filepath.Join(t.TempDir(), "langley.yaml")
+		data := "auth:\n  token: test\ntask:\n  id_json_path: \"" + path + "\"\n"
+		if err := os.WriteFile(cfgPath, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := Load(cfgPath)
+		return err
+	}
+
+	if err := load("request.context.trace_id"); err != nil {
+		t.Errorf("valid path rejected: %v", err)
+	}
+	for _, bad := range []string{"request..trace_id", ".metadata", "metadata.", "messages[0].id", "a b"} {
+		err := load(bad)
+		if err == nil || !strings.Contains(err.Error(), "id_json_path") {
+			t.Errorf("path %q: error = %v, want id_json_path error", bad, err)
+		}
+	}
+}
+
+func TestIsLoopbackListen(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"localhost:9091", true},
+		{"LOCALHOST:9091", true},
+		{"127.0.0.1:9091", true},
+		{"127.1.2.3:9091", true},
+		{"[::1]:9091", true},
+		{"unix:/run/langley.sock", true},
+		{"0.0.0.0:9091", false},
+		{"[::]:9091", false},
+		{":9091", false},
+		{"192.168.1.10:9091", false},
+		{"langley.internal:9091", false},
+	}
+	for _, tt := range tests {
+		if got := IsLoopbackListen(tt.addr); got != tt.want {
+			t.Errorf("IsLoopbackListen(%q) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestAuthConfig_ValidateForListen(t *testing.T) {
+	generated, err := GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		listen  string
+		token   string
+		wantErr string
+	}{
+		{"local with a weak token", "localhost:9091", "secret", ""},
+		{"remote with a generated token", "0.0.0.0:9091", generated, ""},
+		{"remote with no token", "0.0.0.0:9091", "", "empty"},
+		{"remote with a short token", "0.0.0.0:9091", "hunter2hunter2", "shorter than 32"},
+		{"remote with a repetitive token", ":9091", strings.Repeat("ab", 20), "repetitive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := AuthConfig{Token: tt.token}
+			err := auth.ValidateForListen(tt.listen)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateForListen: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateForListen error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
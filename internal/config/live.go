@@ -0,0 +1,54 @@
+package config
+
+import "sync"
+
+// live guards the settings that change while Langley runs: auth tokens,
+// retention, redaction, task and body limits. The settings API and config
+// reload write them through Update; the proxy, store and API read them
+// through View and the accessors below. One lock covers every Config, as
+// a process only ever runs with one.
+var live sync.RWMutex
+
+// Update runs fn with the live settings locked for writing. fn must not
+// call View or the accessors.
+func (c *Config) Update(fn func(c *Config)) {
+	live.Lock()
+	defer live.Unlock()
+	fn(c)
+}
+
+// View runs fn with the live settings locked for reading.
+func (c *Config) View(fn func(c *Config)) {
+	live.RLock()
+	defer live.RUnlock()
+	fn(c)
+}
+
+// Snapshot returns a copy of the retention settings.
+func (c *RetentionConfig) Snapshot() RetentionConfig {
+	live.RLock()
+	defer live.RUnlock()
+	return *c
+}
+
+// Snapshot returns a copy of the redaction settings. The pattern slices
+// are shared; Update replaces them rather than editing them.
+func (c *RedactionConfig) Snapshot() RedactionConfig {
+	live.RLock()
+	defer live.RUnlock()
+	return *c
+}
+
+// StoredBodyLimit returns BodyMaxBytes.
+func (c *PersistenceConfig) StoredBodyLimit() int {
+	live.RLock()
+	defer live.RUnlock()
+	return c.BodyMaxBytes
+}
+
+// PrimaryToken returns Token.
+func (c *AuthConfig) PrimaryToken() string {
+	live.RLock()
+	defer live.RUnlock()
+	return c.Token
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultWorkspace is the workspace stored in the default database,
+// langley.db. Other workspaces live next to it as langley-{name}.db.
+const DefaultWorkspace = "default"
+
+var workspaceNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// ValidateWorkspaceName checks that name is usable in a database file name:
+// letters, digits, '-' and '_', starting with a letter or digit.
+func ValidateWorkspaceName(name string) error {
+	if !workspaceNameRe.MatchString(name) {
+		return fmt.Errorf("invalid workspace name %q: use up to 64 letters, digits, '-' or '_'", name)
+	}
+	return nil
+}
+
+// WorkspaceDBPath returns the database path for a workspace in dir.
+func WorkspaceDBPath(dir, name string) string {
+	if name == DefaultWorkspace {
+		return filepath.Join(dir, "langley.db")
+	}
+	return filepath.Join(dir, "langley-"+name+".db")
+}
+
+// Workspace is a workspace database found on disk.
+type Workspace struct {
+	Name      string
+	Path      string
+	SizeBytes int64
+}
+
+// ListWorkspaces returns the workspace databases in dir, sorted by name.
+// A missing dir has no workspaces.
+func ListWorkspaces(dir string) ([]Workspace, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading %s: %w", dir, err)
+	}
+
+	var workspaces []Workspace
+	for _, entry := range entries {
+		file := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(file, "langley") || !strings.HasSuffix(file, ".db") {
+			continue
+		}
+		name := DefaultWorkspace
+		if file != "langley.db" {
+			name = strings.TrimSuffix(strings.TrimPrefix(file, "langley-"), ".db")
+			if !strings.HasPrefix(file, "langley-") || name == DefaultWorkspace || ValidateWorkspaceName(name) != nil {
+				continue
+			}
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // Removed since ReadDir
+		}
+		workspaces = append(workspaces, Workspace{
+			Name:      name,
+			Path:      filepath.Join(dir, file),
+			SizeBytes: info.Size(),
+		})
+	}
+
+	sort.Slice(workspaces, func(i, j int) bool { return workspaces[i].Name < workspaces[j].Name })
+	return workspaces, nil
+}
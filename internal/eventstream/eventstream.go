@@ -0,0 +1,209 @@
+// Package eventstream decodes the AWS event stream binary framing used by
+// Bedrock's streaming APIs (InvokeModelWithResponseStream, ConverseStream).
+package eventstream
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+// ContentType is the media type of an AWS event stream response.
+const ContentType = "application/vnd.amazon.eventstream"
+
+const (
+	preludeLen    = 12               // total length, headers length, prelude CRC
+	minMessageLen = preludeLen + 4   // prelude + message CRC
+	maxMessageLen = 16 * 1024 * 1024 // AWS limit per message
+	maxHeadersLen = 128 * 1024       // AWS limit per message
+	headerString  = 7
+	headerByteArr = 6
+)
+
+// headerValueSizes gives the fixed value size for each non-variable header type.
+var headerValueSizes = map[byte]int{
+	0: 0,  // bool true
+	1: 0,  // bool false
+	2: 1,  // byte
+	3: 2,  // short
+	4: 4,  // integer
+	5: 8,  // long
+	8: 8,  // timestamp
+	9: 16, // uuid
+}
+
+// IsContentType reports whether a Content-Type header is an event stream.
+func IsContentType(ct string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(ct)), ContentType)
+}
+
+// IsFramed reports whether b starts with a valid event stream prelude.
+func IsFramed(b []byte) bool {
+	if len(b) < preludeLen {
+		return false
+	}
+	return crc32.ChecksumIEEE(b[:8]) == binary.BigEndian.Uint32(b[8:12])
+}
+
+// Message is one decoded event stream message. Only string and byte-array
+// headers are kept; other header types are skipped.
+type Message struct {
+	Headers map[string]string
+	Payload []byte
+}
+
+// Decoder reads messages from an event stream.
+type Decoder struct {
+	r io.Reader
+}
+
+// NewDecoder returns a Decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Next returns the next message. It returns io.EOF at a clean end of stream
+// and io.ErrUnexpectedEOF if the stream stops mid-message.
+func (d *Decoder) Next() (*Message, error) {
+	var prelude [preludeLen]byte
+	if _, err := io.ReadFull(d.r, prelude[:]); err != nil {
+		return nil, err
+	}
+
+	totalLen := binary.BigEndian.Uint32(prelude[0:4])
+	headersLen := binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return nil, errors.New("eventstream: prelude checksum mismatch")
+	}
+	if totalLen < minMessageLen || totalLen > maxMessageLen {
+		return nil, fmt.Errorf("eventstream: invalid message length %d", totalLen)
+	}
+	if headersLen > maxHeadersLen || headersLen > totalLen-minMessageLen {
+		return nil, fmt.Errorf("eventstream: invalid headers length %d", headersLen)
+	}
+
+	rest := make([]byte, totalLen-preludeLen)
+	if _, err := io.ReadFull(d.r, rest); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(prelude[:])
+	crc.Write(rest[:len(rest)-4])
+	if crc.Sum32() != binary.BigEndian.Uint32(rest[len(rest)-4:]) {
+		return nil, errors.New("eventstream: message checksum mismatch")
+	}
+
+	headers, err := decodeHeaders(rest[:headersLen])
+	if err != nil {
+		return nil, err
+	}
+	return &Message{
+		Headers: headers,
+		Payload: rest[headersLen : len(rest)-4],
+	}, nil
+}
+
+// decodeHeaders parses the header block of a message.
+func decodeHeaders(b []byte) (map[string]string, error) {
+	headers := make(map[string]string)
+	for len(b) > 0 {
+		nameLen := int(b[0])
+		if len(b) < 1+nameLen+1 {
+			return nil, errors.New("eventstream: truncated header")
+		}
+		name := string(b[1 : 1+nameLen])
+		typ := b[1+nameLen]
+		b = b[2+nameLen:]
+
+		switch typ {
+		case headerString, headerByteArr:
+			if len(b) < 2 {
+				return nil, errors.New("eventstream: truncated header value")
+			}
+			n := int(binary.BigEndian.Uint16(b))
+			if len(b) < 2+n {
+				return nil, errors.New("eventstream: truncated header value")
+			}
+			headers[name] = string(b[2 : 2+n])
+			b = b[2+n:]
+		default:
+			size, ok := headerValueSizes[typ]
+			if !ok {
+				return nil, fmt.Errorf("eventstream: unknown header type %d", typ)
+			}
+			if len(b) < size {
+				return nil, errors.New("eventstream: truncated header value")
+			}
+			b = b[size:]
+		}
+	}
+	return headers, nil
+}
+
+// BedrockEvent returns the event type and JSON data carried by a Bedrock
+// message, in the same shape the SSE parser produces:
+//   - InvokeModelWithResponseStream "chunk" events carry base64 model output;
+//     it is decoded and its "type" field (e.g. "message_start") is the event type.
+//   - ConverseStream events (messageStart, contentBlockDelta, metadata, ...)
+//     use the :event-type header and the payload as-is.
+//   - Exceptions and errors become "error" events.
+func BedrockEvent(m *Message) (string, []byte, error) {
+	switch m.Headers[":message-type"] {
+	case "exception":
+		data, err := json.Marshal(map[string]interface{}{
+			"type":  "error",
+			"error": map[string]interface{}{"type": m.Headers[":exception-type"], "message": exceptionMessage(m.Payload)},
+		})
+		return "error", data, err
+	case "error":
+		data, err := json.Marshal(map[string]interface{}{
+			"type":  "error",
+			"error": map[string]interface{}{"type": m.Headers[":error-code"], "message": m.Headers[":error-message"]},
+		})
+		return "error", data, err
+	}
+
+	eventType := m.Headers[":event-type"]
+	if eventType != "chunk" {
+		return eventType, m.Payload, nil
+	}
+
+	var chunk struct {
+		Bytes string `json:"bytes"`
+	}
+	if err := json.Unmarshal(m.Payload, &chunk); err != nil {
+		return "", nil, fmt.Errorf("eventstream: decoding chunk: %w", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(chunk.Bytes)
+	if err != nil {
+		return "", nil, fmt.Errorf("eventstream: decoding chunk bytes: %w", err)
+	}
+
+	var inner struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &inner); err == nil && inner.Type != "" {
+		eventType = inner.Type
+	}
+	return eventType, data, nil
+}
+
+// exceptionMessage extracts the message from an exception payload.
+func exceptionMessage(payload []byte) string {
+	var p struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(payload, &p); err == nil && p.Message != "" {
+		return p.Message
+	}
+	return string(payload)
+}
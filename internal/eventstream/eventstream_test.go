@@ -0,0 +1,103 @@
+package eventstream
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/HakAl/langley/internal/testutil"
+)
+
+func TestDecoder_BedrockInvokeStream(t *testing.T) {
+	data := testutil.LoadEventStream(t, "bedrock_invoke_stream")
+	dec := NewDecoder(bytes.NewReader(data))
+
+	var types []string
+	for {
+		msg, err := dec.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if msg.Headers[":message-type"] != "event" || msg.Headers[":event-type"] != "chunk" {
+			t.Errorf("headers = %v", msg.Headers)
+		}
+		eventType, _, err := BedrockEvent(msg)
+		if err != nil {
+			t.Fatalf("BedrockEvent: %v", err)
+		}
+		types = append(types, eventType)
+	}
+
+	want := []string{"message_start", "content_block_start", "content_block_delta", "content_block_delta",
+		"content_block_stop", "message_delta", "message_stop"}
+	if len(types) != len(want) {
+		t.Fatalf("event types = %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Errorf("types[%d] = %q, want %q", i, types[i], want[i])
+		}
+	}
+}
+
+func TestDecoder_Errors(t *testing.T) {
+	data := testutil.LoadEventStream(t, "bedrock_invoke_stream")
+
+	t.Run("truncated message", func(t *testing.T) {
+		dec := NewDecoder(bytes.NewReader(data[:40]))
+		if _, err := dec.Next(); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("err = %v, want io.ErrUnexpectedEOF", err)
+		}
+	})
+
+	t.Run("corrupt payload", func(t *testing.T) {
+		corrupt := bytes.Clone(data)
+		corrupt[100] ^= 0xff
+		if _, err := NewDecoder(bytes.NewReader(corrupt)).Next(); err == nil {
+			t.Error("expected checksum error")
+		}
+	})
+
+	t.Run("not framed", func(t *testing.T) {
+		text := []byte("event: message_start\ndata: {}\n\n")
+		if IsFramed(text) {
+			t.Error("IsFramed(SSE text) = true")
+		}
+		if _, err := NewDecoder(bytes.NewReader(text)).Next(); err == nil {
+			t.Error("expected error decoding SSE text")
+		}
+		if !IsFramed(data) {
+			t.Error("IsFramed(fixture) = false")
+		}
+	})
+}
+
+func TestBedrockEvent_Exception(t *testing.T) {
+	msg := &Message{
+		Headers: map[string]string{":message-type": "exception", ":exception-type": "throttlingException"},
+		Payload: []byte(`{"message":"Too many requests"}`),
+	}
+	eventType, data, err := BedrockEvent(msg)
+	if err != nil {
+		t.Fatalf("BedrockEvent: %v", err)
+	}
+	if eventType != "error" {
+		t.Errorf("eventType = %q, want error", eventType)
+	}
+	if !bytes.Contains(data, []byte("throttlingException")) || !bytes.Contains(data, []byte("Too many requests")) {
+		t.Errorf("data = %s", data)
+	}
+}
+
+func TestIsContentType(t *testing.T) {
+	if !IsContentType("application/vnd.amazon.eventstream") {
+		t.Error("expected event stream content type to match")
+	}
+	if IsContentType("text/event-stream") {
+		t.Error("SSE content type should not match")
+	}
+}
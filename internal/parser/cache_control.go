@@ -0,0 +1,70 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// cacheBlock is any request element that can carry a cache_control marker.
+type cacheBlock struct {
+	CacheControl json.RawMessage `json:"cache_control"`
+}
+
+func (b cacheBlock) marked() bool {
+	return len(b.CacheControl) > 0 && !bytes.Equal(b.CacheControl, []byte("null"))
+}
+
+// ExtractCacheBreakpoints parses an Anthropic API request body for prompt-cache
+// breakpoints (blocks with cache_control) and returns their positions in
+// cache prefix order: tools, then system, then messages. Positions look like
+// "tools[3]", "system[0]" or "messages[2].content[1]".
+// Returns nil for non-JSON bodies or bodies without breakpoints.
+func ExtractCacheBreakpoints(body []byte) []string {
+	if len(body) == 0 || !bytes.Contains(body, []byte(`"cache_control"`)) {
+		return nil
+	}
+
+	var req struct {
+		Tools    []cacheBlock    `json:"tools"`
+		System   json.RawMessage `json:"system"`
+		Messages []struct {
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil
+	}
+
+	var positions []string
+	for i, tool := range req.Tools {
+		if tool.marked() {
+			positions = append(positions, fmt.Sprintf("tools[%d]", i))
+		}
+	}
+
+	// system can be a string or an array of text blocks
+	var system []cacheBlock
+	if err := json.Unmarshal(req.System, &system); err == nil {
+		for i, block := range system {
+			if block.marked() {
+				positions = append(positions, fmt.Sprintf("system[%d]", i))
+			}
+		}
+	}
+
+	for i, msg := range req.Messages {
+		// content can be a string or an array of content blocks
+		var blocks []cacheBlock
+		if err := json.Unmarshal(msg.Content, &blocks); err != nil {
+			continue
+		}
+		for j, block := range blocks {
+			if block.marked() {
+				positions = append(positions, fmt.Sprintf("messages[%d].content[%d]", i, j))
+			}
+		}
+	}
+
+	return positions
+}
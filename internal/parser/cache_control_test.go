@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractCacheBreakpoints(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{
+			name: "tools, system and messages",
+			body: `{
+				"model": "claude-sonnet-4-20250514",
+				"tools": [
+					{"name": "read_file", "input_schema": {}},
+					{"name": "write_file", "input_schema": {}, "cache_control": {"type": "ephemeral"}}
+				],
+				"system": [
+					{"type": "text", "text": "You are a coding assistant."},
+					{"type": "text", "text": "<large codebase context>", "cache_control": {"type": "ephemeral", "ttl": "1h"}}
+				],
+				"messages": [
+					{"role": "user", "content": "hi"},
+					{"role": "assistant", "content": [{"type": "text", "text": "hello"}]},
+					{"role": "user", "content": [
+						{"type": "text", "text": "first"},
+						{"type": "text", "text": "second", "cache_control": {"type": "ephemeral"}}
+					]}
+				]
+			}`,
+			want: []string{"tools[1]", "system[1]", "messages[2].content[1]"},
+		},
+		{
+			name: "string system prompt",
+			body: `{
+				"system": "plain system prompt",
+				"messages": [
+					{"role": "user", "content": [{"type": "text", "text": "q", "cache_control": {"type": "ephemeral"}}]}
+				]
+			}`,
+			want: []string{"messages[0].content[0]"},
+		},
+		{
+			name: "null cache_control is not a breakpoint",
+			body: `{"messages": [{"role": "user", "content": [{"type": "text", "text": "q", "cache_control": null}]}]}`,
+			want: nil,
+		},
+		{
+			name: "no markers",
+			body: `{"messages": [{"role": "user", "content": "hello"}]}`,
+			want: nil,
+		},
+		{
+			name: "not JSON",
+			body: `"cache_control" but not json`,
+			want: nil,
+		},
+		{
+			name: "empty",
+			body: ``,
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ExtractCacheBreakpoints([]byte(tt.body))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExtractCacheBreakpoints() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package parser
+
+import (
+	"io"
+
+	"github.com/HakAl/langley/internal/eventstream"
+	"github.com/HakAl/langley/internal/store"
+)
+
+// EventStreamParser parses AWS event stream responses (Bedrock streaming)
+// into the same events the SSE parser emits, so Claude-on-Bedrock streams
+// yield message_start, content_block_delta, etc.
+type EventStreamParser struct {
+	emitter *SSEParser // Shared sequencing and event emission
+}
+
+// NewEventStreamParser creates a new event stream parser for a flow.
+func NewEventStreamParser(flowID string, eventsCh chan *store.Event, logger Logger) *EventStreamParser {
+	return &EventStreamParser{emitter: NewSSEParserWithLogger(flowID, eventsCh, logger)}
+}
+
+// Parse reads event stream messages from r and sends them to the events
+// channel. It returns when the reader is exhausted or the framing is invalid;
+// on invalid framing the rest of r is drained so writers feeding it don't block.
+// Enforces the same per-event and per-flow limits as the SSE parser.
+func (p *EventStreamParser) Parse(r io.Reader) error {
+	defer close(p.emitter.doneCh)
+
+	dec := eventstream.NewDecoder(r)
+	var eventCount int
+	for {
+		msg, err := dec.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			_, _ = io.Copy(io.Discard, r)
+			return err
+		}
+
+		eventType, data, err := eventstream.BedrockEvent(msg)
+		if err != nil {
+			p.warn("skipping undecodable event stream message", "error", err)
+			continue
+		}
+		if eventType == "" {
+			continue
+		}
+
+		truncated := len(data) > maxEventDataSize
+		if truncated {
+			p.warn("event stream message exceeds size limit, truncating", "size", len(data), "limit", maxEventDataSize)
+			data = data[:maxEventDataSize]
+		}
+		p.emitter.emitEvent(eventType, string(data), truncated)
+
+		eventCount++
+		if eventCount >= maxEventsPerFlow {
+			p.warn("event stream event count limit reached", "limit", maxEventsPerFlow)
+			_, _ = io.Copy(io.Discard, r)
+			return nil
+		}
+	}
+}
+
+// Done returns a channel that's closed when parsing is complete.
+func (p *EventStreamParser) Done() <-chan struct{} {
+	return p.emitter.doneCh
+}
+
+func (p *EventStreamParser) warn(msg string, args ...any) {
+	if p.emitter.logger != nil {
+		p.emitter.logger.Warn(msg, append([]any{"flow_id", p.emitter.flowID}, args...)...)
+	}
+}
@@ -0,0 +1,55 @@
+package parser
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/HakAl/langley/internal/store"
+	"github.com/HakAl/langley/internal/testutil"
+)
+
+func TestEventStreamParser_BedrockInvokeStream(t *testing.T) {
+	data := testutil.LoadEventStream(t, "bedrock_invoke_stream")
+
+	eventsCh := make(chan *store.Event, 100)
+	p := NewEventStreamParser("flow-bedrock", eventsCh, nil)
+	if err := p.Parse(bytes.NewReader(data)); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	close(eventsCh)
+
+	var events []*store.Event
+	for e := range eventsCh {
+		events = append(events, e)
+	}
+	if len(events) != 7 {
+		t.Fatalf("got %d events, want 7", len(events))
+	}
+	if events[0].EventType != "message_start" || events[0].Priority != "high" {
+		t.Errorf("events[0] = %s/%s, want message_start/high", events[0].EventType, events[0].Priority)
+	}
+	if events[6].Sequence != 7 || events[6].FlowID != "flow-bedrock" {
+		t.Errorf("events[6] sequence %d flow %s", events[6].Sequence, events[6].FlowID)
+	}
+
+	usage := ExtractUsage(events)
+	if usage == nil || usage.InputTokens != 25 || usage.OutputTokens != 12 {
+		t.Errorf("ExtractUsage = %+v, want 25 in / 12 out", usage)
+	}
+	if model := ExtractModel(events); model != "claude-3-5-sonnet-20241022" {
+		t.Errorf("ExtractModel = %q", model)
+	}
+}
+
+func TestEventStreamParser_InvalidFraming(t *testing.T) {
+	eventsCh := make(chan *store.Event, 10)
+	p := NewEventStreamParser("flow-bad", eventsCh, nil)
+	if err := p.Parse(bytes.NewReader([]byte("data: not an event stream\n\n"))); err == nil {
+		t.Error("expected error for non event stream input")
+	}
+	select {
+	case <-p.Done():
+	default:
+		t.Error("Done should be closed after Parse returns")
+	}
+}
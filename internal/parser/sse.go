@@ -183,6 +183,30 @@ func (p *SSEParser) Done() <-chan struct{} {
 	return p.doneCh
 }
 
+// EncodeSSE writes parsed events back out as an SSE stream, so code that
+// reads raw streams, such as provider usage parsing, can use events the
+// parser saw even when the raw body wasn't kept. Data that wasn't JSON, kept
+// under "raw", is written as it came.
+func EncodeSSE(events []*store.Event) []byte {
+	var b strings.Builder
+	for _, event := range events {
+		data, ok := event.EventData["raw"].(string)
+		if !ok || len(event.EventData) != 1 {
+			encoded, err := json.Marshal(event.EventData)
+			if err != nil {
+				continue
+			}
+			data = string(encoded)
+		}
+		b.WriteString("event: " + event.EventType + "\n")
+		for _, line := range strings.Split(data, "\n") {
+			b.WriteString("data:" + line + "\n") // The parser keeps the space after "data:"
+		}
+		b.WriteString("\n")
+	}
+	return []byte(b.String())
+}
+
 // ClaudeUsage represents token usage from a Claude response.
 type ClaudeUsage struct {
 	InputTokens         int `json:"input_tokens"`
@@ -255,12 +279,17 @@ type ToolUse struct {
 	ID    string                 `json:"id"`
 	Name  string                 `json:"name"`
 	Input map[string]interface{} `json:"input"`
+
+	// Timestamp is when the tool_use block finished streaming, i.e. when the
+	// client could start running the tool. Zero for non-streamed responses.
+	Timestamp time.Time `json:"-"`
 }
 
 // ExtractToolUses extracts tool invocations from Claude SSE events.
 func ExtractToolUses(events []*store.Event) []*ToolUse {
 	var tools []*ToolUse
 	toolInputs := make(map[string]string) // ID -> accumulated input JSON
+	var open *ToolUse                     // tool_use block still streaming
 
 	for _, event := range events {
 		switch event.EventType {
@@ -268,15 +297,23 @@ func ExtractToolUses(events []*store.Event) []*ToolUse {
 			if cb, ok := event.EventData["content_block"].(map[string]interface{}); ok {
 				if cb["type"] == "tool_use" {
 					tool := &ToolUse{
-						ID:   getString(cb, "id"),
-						Name: getString(cb, "name"),
+						ID:        getString(cb, "id"),
+						Name:      getString(cb, "name"),
+						Timestamp: event.Timestamp,
 					}
 					if tool.ID != "" && tool.Name != "" {
 						tools = append(tools, tool)
 						toolInputs[tool.ID] = ""
+						open = tool
 					}
 				}
 			}
+		case "content_block_stop":
+			// Blocks stream one at a time, so a stop closes the open tool_use
+			if open != nil && !event.Timestamp.IsZero() {
+				open.Timestamp = event.Timestamp
+			}
+			open = nil
 		case "content_block_delta":
 			if delta, ok := event.EventData["delta"].(map[string]interface{}); ok {
 				if delta["type"] == "input_json_delta" {
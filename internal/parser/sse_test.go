@@ -1,8 +1,10 @@
 package parser
 
 import (
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/HakAl/langley/internal/queue"
 	"github.com/HakAl/langley/internal/store"
@@ -453,6 +455,43 @@ func TestExtractToolUsesMultiple(t *testing.T) {
 	}
 }
 
+func TestExtractToolUses_Timestamp(t *testing.T) {
+	base := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	start := func(id string, at time.Duration) *store.Event {
+		return &store.Event{
+			EventType: "content_block_start",
+			Timestamp: base.Add(at),
+			EventData: map[string]interface{}{
+				"content_block": map[string]interface{}{"type": "tool_use", "id": id, "name": "Bash"},
+			},
+		}
+	}
+	stop := func(at time.Duration) *store.Event {
+		return &store.Event{EventType: "content_block_stop", Timestamp: base.Add(at), EventData: map[string]interface{}{}}
+	}
+
+	events := []*store.Event{
+		start("toolu_a", 0), stop(2 * time.Second),
+		{EventType: "content_block_start", Timestamp: base.Add(3 * time.Second), EventData: map[string]interface{}{
+			"content_block": map[string]interface{}{"type": "text"},
+		}},
+		stop(4 * time.Second), // Closes the text block, not toolu_a
+		start("toolu_b", 5*time.Second), stop(9 * time.Second),
+	}
+
+	tools := ExtractToolUses(events)
+	if len(tools) != 2 {
+		t.Fatalf("got %d tools, want 2", len(tools))
+	}
+	// A tool can only start running once its input has finished streaming
+	if want := base.Add(2 * time.Second); !tools[0].Timestamp.Equal(want) {
+		t.Errorf("toolu_a timestamp = %v, want %v", tools[0].Timestamp, want)
+	}
+	if want := base.Add(9 * time.Second); !tools[1].Timestamp.Equal(want) {
+		t.Errorf("toolu_b timestamp = %v, want %v", tools[1].Timestamp, want)
+	}
+}
+
 func TestParseInvalidJSON(t *testing.T) {
 	eventsCh := make(chan *store.Event, 10)
 	p := NewSSEParser("flow-7", eventsCh)
@@ -556,3 +595,51 @@ func BenchmarkParse(b *testing.B) {
 	next:
 	}
 }
+
+func TestEncodeSSE_RoundTrip(t *testing.T) {
+	parse := func(input string) []*store.Event {
+		t.Helper()
+		eventsCh := make(chan *store.Event, 10)
+		if err := NewSSEParser("flow-1", eventsCh).Parse(strings.NewReader(input)); err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		close(eventsCh)
+		var events []*store.Event
+		for e := range eventsCh {
+			events = append(events, e)
+		}
+		return events
+	}
+
+	input := `event: message_start
+data: {"type": "message_start", "message": {"model": "claude-sonnet-4", "usage": {"input_tokens": 12}}}
+
+event: content_block_delta
+data: {"type": "content_block_delta", "delta": {"text": "line one\nline two"}}
+
+event: notice
+data: not json
+data: second line
+
+event: message_delta
+data: {"type": "message_delta", "usage": {"output_tokens": 34}}
+
+`
+	original := parse(input)
+	reparsed := parse(string(EncodeSSE(original)))
+
+	if len(reparsed) != len(original) {
+		t.Fatalf("got %d events after round trip, want %d", len(reparsed), len(original))
+	}
+	for i := range original {
+		if reparsed[i].EventType != original[i].EventType {
+			t.Errorf("events[%d].EventType = %q, want %q", i, reparsed[i].EventType, original[i].EventType)
+		}
+		if !reflect.DeepEqual(reparsed[i].EventData, original[i].EventData) {
+			t.Errorf("events[%d].EventData = %v, want %v", i, reparsed[i].EventData, original[i].EventData)
+		}
+	}
+	if raw := reparsed[2].EventData["raw"]; raw != " not json\n second line" {
+		t.Errorf("non-JSON data = %q, want it as sent", raw)
+	}
+}
@@ -29,8 +29,10 @@ func (a *Anthropic) ParseUsage(body []byte, isSSE bool) (*Usage, error) {
 // parseJSON extracts usage from a non-streaming JSON response.
 func (a *Anthropic) parseJSON(body []byte) (*Usage, error) {
 	var response struct {
-		Model string `json:"model"`
-		Usage struct {
+		Model      string    `json:"model"`
+		StopReason string    `json:"stop_reason"`
+		Error      *apiError `json:"error"`
+		Usage      struct {
 			InputTokens              int `json:"input_tokens"`
 			OutputTokens             int `json:"output_tokens"`
 			CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
@@ -42,13 +44,32 @@ func (a *Anthropic) parseJSON(body []byte) (*Usage, error) {
 		return nil, err
 	}
 
-	return &Usage{
+	usage := &Usage{
 		Model:               response.Model,
 		InputTokens:         response.Usage.InputTokens,
 		OutputTokens:        response.Usage.OutputTokens,
 		CacheCreationTokens: response.Usage.CacheCreationInputTokens,
 		CacheReadTokens:     response.Usage.CacheReadInputTokens,
-	}, nil
+		StopReason:          response.StopReason,
+	}
+	response.Error.apply(usage)
+	return usage, nil
+}
+
+// apiError is the "error" object of an Anthropic error response or SSE
+// error event, e.g. {"type":"overloaded_error","message":"Overloaded"}.
+type apiError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// apply copies the error into usage. A nil error leaves usage unchanged.
+func (e *apiError) apply(usage *Usage) {
+	if e == nil {
+		return
+	}
+	usage.ErrorType = e.Type
+	usage.ErrorMessage = e.Message
 }
 
 // parseSSE extracts usage from an SSE stream.
@@ -108,12 +129,26 @@ func (a *Anthropic) processEvent(usage *Usage, eventType, data string) {
 
 	case "message_delta":
 		var event struct {
+			Delta struct {
+				StopReason string `json:"stop_reason"`
+			} `json:"delta"`
 			Usage struct {
 				OutputTokens int `json:"output_tokens"`
 			} `json:"usage"`
 		}
 		if err := json.Unmarshal([]byte(data), &event); err == nil {
 			usage.OutputTokens = event.Usage.OutputTokens
+			if event.Delta.StopReason != "" {
+				usage.StopReason = event.Delta.StopReason
+			}
+		}
+
+	case "error":
+		var event struct {
+			Error *apiError `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(data), &event); err == nil {
+			event.Error.apply(usage)
 		}
 	}
 }
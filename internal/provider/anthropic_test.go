@@ -116,6 +116,64 @@ data: {"type":"message_stop"}
 	}
 }
 
+func TestAnthropic_ParseUsage_StopReasonAndError(t *testing.T) {
+	a := &Anthropic{}
+
+	tests := []struct {
+		name           string
+		body           string
+		isSSE          bool
+		wantStopReason string
+		wantErrorType  string
+		wantErrorMsg   string
+	}{
+		{
+			name:           "JSON completion",
+			body:           `{"type":"message","model":"claude-sonnet-4-20250514","stop_reason":"max_tokens","usage":{"input_tokens":10,"output_tokens":1024}}`,
+			wantStopReason: "max_tokens",
+		},
+		{
+			name:          "JSON error",
+			body:          `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+			wantErrorType: "overloaded_error",
+			wantErrorMsg:  "Overloaded",
+		},
+		{
+			name: "SSE completion",
+			body: "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"stop_reason\":null,\"usage\":{\"input_tokens\":5}}}\n\n" +
+				"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"tool_use\"},\"usage\":{\"output_tokens\":30}}\n\n",
+			isSSE:          true,
+			wantStopReason: "tool_use",
+		},
+		{
+			name: "SSE error mid-stream",
+			body: "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":5}}}\n\n" +
+				"event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"api_error\",\"message\":\"Internal server error\"}}\n\n",
+			isSSE:         true,
+			wantErrorType: "api_error",
+			wantErrorMsg:  "Internal server error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usage, err := a.ParseUsage([]byte(tt.body), tt.isSSE)
+			if err != nil {
+				t.Fatalf("ParseUsage() error = %v", err)
+			}
+			if usage.StopReason != tt.wantStopReason {
+				t.Errorf("StopReason = %q, want %q", usage.StopReason, tt.wantStopReason)
+			}
+			if usage.ErrorType != tt.wantErrorType {
+				t.Errorf("ErrorType = %q, want %q", usage.ErrorType, tt.wantErrorType)
+			}
+			if usage.ErrorMessage != tt.wantErrorMsg {
+				t.Errorf("ErrorMessage = %q, want %q", usage.ErrorMessage, tt.wantErrorMsg)
+			}
+		})
+	}
+}
+
 func TestAnthropic_ParseUsage_EmptyBody(t *testing.T) {
 	a := &Anthropic{}
 
@@ -1,8 +1,11 @@
 package provider
 
 import (
+	"bytes"
 	"encoding/json"
 	"strings"
+
+	"github.com/HakAl/langley/internal/eventstream"
 )
 
 // Bedrock implements Provider for AWS Bedrock API.
@@ -30,6 +33,9 @@ func (b *Bedrock) DetectHost(host string) bool {
 // - Converse API: usage in top-level "usage" object
 // - InvokeModel API: passes through model's native format
 func (b *Bedrock) ParseUsage(body []byte, isSSE bool) (*Usage, error) {
+	if isSSE && eventstream.IsFramed(body) {
+		return b.parseEventStream(body)
+	}
 	if isSSE {
 		return b.parseSSE(body)
 	}
@@ -148,3 +154,54 @@ func (b *Bedrock) parseSSE(body []byte) (*Usage, error) {
 
 	return usage, nil
 }
+
+// parseEventStream extracts usage from a binary AWS event stream, as returned
+// by InvokeModelWithResponseStream and ConverseStream. A truncated capture
+// yields whatever usage appeared before the cut.
+func (b *Bedrock) parseEventStream(body []byte) (*Usage, error) {
+	usage := &Usage{}
+	anthropic := &Anthropic{}
+	dec := eventstream.NewDecoder(bytes.NewReader(body))
+
+	for {
+		msg, err := dec.Next()
+		if err != nil {
+			break
+		}
+		eventType, data, err := eventstream.BedrockEvent(msg)
+		if err != nil {
+			continue
+		}
+
+		// Claude models stream Anthropic-format events inside chunks
+		anthropic.processEvent(usage, eventType, string(data))
+
+		var event struct {
+			// Final InvokeModelWithResponseStream chunk
+			Metrics *struct {
+				InputTokenCount  int `json:"inputTokenCount"`
+				OutputTokenCount int `json:"outputTokenCount"`
+			} `json:"amazon-bedrock-invocationMetrics"`
+			// ConverseStream metadata event
+			Usage *struct {
+				InputTokens  int `json:"inputTokens"`
+				OutputTokens int `json:"outputTokens"`
+			} `json:"usage"`
+		}
+		if err := json.Unmarshal(data, &event); err != nil {
+			continue
+		}
+
+		// Bedrock's own counts are authoritative when present
+		if event.Metrics != nil {
+			usage.InputTokens = event.Metrics.InputTokenCount
+			usage.OutputTokens = event.Metrics.OutputTokenCount
+		}
+		if eventType == "metadata" && event.Usage != nil {
+			usage.InputTokens = event.Usage.InputTokens
+			usage.OutputTokens = event.Usage.OutputTokens
+		}
+	}
+
+	return usage, nil
+}
@@ -2,6 +2,8 @@ package provider
 
 import (
 	"testing"
+
+	"github.com/HakAl/langley/internal/testutil"
 )
 
 func TestBedrock_Name(t *testing.T) {
@@ -222,3 +224,31 @@ func TestBedrock_ParseUsage_NoCacheTokens(t *testing.T) {
 		t.Errorf("CacheReadTokens = %d, want %d", usage.CacheReadTokens, 0)
 	}
 }
+
+func TestBedrock_ParseUsage_EventStream(t *testing.T) {
+	b := &Bedrock{}
+	body := testutil.LoadEventStream(t, "bedrock_invoke_stream")
+
+	usage, err := b.ParseUsage(body, true)
+	if err != nil {
+		t.Fatalf("ParseUsage() error = %v", err)
+	}
+	if usage.InputTokens != 25 {
+		t.Errorf("InputTokens = %d, want 25", usage.InputTokens)
+	}
+	if usage.OutputTokens != 12 {
+		t.Errorf("OutputTokens = %d, want 12", usage.OutputTokens)
+	}
+	if usage.Model != "claude-3-5-sonnet-20241022" {
+		t.Errorf("Model = %q, want claude-3-5-sonnet-20241022", usage.Model)
+	}
+
+	// A capture cut off mid-stream keeps the usage seen so far
+	usage, err = b.ParseUsage(body[:len(body)-50], true)
+	if err != nil {
+		t.Fatalf("ParseUsage(truncated) error = %v", err)
+	}
+	if usage.InputTokens != 25 || usage.OutputTokens != 12 {
+		t.Errorf("truncated usage = %d/%d, want 25/12 from message_start and message_delta", usage.InputTokens, usage.OutputTokens)
+	}
+}
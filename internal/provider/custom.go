@@ -0,0 +1,37 @@
+package provider
+
+// Custom is a user-defined provider: it matches its own hosts and reuses a
+// built-in provider's response parsing, e.g. a self-hosted server that speaks
+// the OpenAI protocol on an internal hostname.
+type Custom struct {
+	label  string
+	hosts  []string
+	parser Provider
+}
+
+// Name returns the name of the built-in provider whose parser is reused.
+// Flows are stored, priced and re-parsed under this name, and the store only
+// accepts built-in provider names. Label returns the configured name.
+func (c *Custom) Name() string {
+	return c.parser.Name()
+}
+
+// Label returns the name the provider was configured with.
+func (c *Custom) Label() string {
+	return c.label
+}
+
+// DetectHost returns true if host matches one of the configured domain suffixes.
+func (c *Custom) DetectHost(host string) bool {
+	for _, suffix := range c.hosts {
+		if MatchDomainSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseUsage delegates to the reused built-in parser.
+func (c *Custom) ParseUsage(body []byte, isSSE bool) (*Usage, error) {
+	return c.parser.ParseUsage(body, isSSE)
+}
@@ -1,20 +1,28 @@
 package provider
 
-import "strings"
+import (
+	"net"
+	"strings"
+)
 
 // MatchDomainSuffix reports whether host (with optional :port) matches the
 // given domain suffix. It performs case-insensitive comparison and requires an
-// exact match or a subdomain boundary (dot-separated).
+// exact match or a subdomain boundary (dot-separated). A suffix with a port
+// only matches hosts on that port, for local servers such as Ollama that are
+// told apart by port rather than name.
 //
 // Examples:
 //
 //	MatchDomainSuffix("api.anthropic.com", "anthropic.com")   => true
 //	MatchDomainSuffix("anthropic.com:443", "anthropic.com")   => true
 //	MatchDomainSuffix("misanthropic.com",  "anthropic.com")   => false
+//	MatchDomainSuffix("localhost:11434",   "localhost:11434") => true
+//	MatchDomainSuffix("localhost:8080",    "localhost:11434") => false
 func MatchDomainSuffix(host, suffix string) bool {
-	// Strip port if present
-	if i := strings.LastIndex(host, ":"); i != -1 {
-		host = host[:i]
+	host, port := splitHostPort(host)
+	suffix, wantPort := splitHostPort(suffix)
+	if wantPort != "" && port != wantPort {
+		return false
 	}
 
 	host = strings.ToLower(host)
@@ -27,3 +35,12 @@ func MatchDomainSuffix(host, suffix string) bool {
 	// Must end with "."+suffix to be a subdomain match
 	return strings.HasSuffix(host, "."+suffix)
 }
+
+// splitHostPort splits "host:port" or "[::1]:port". A value without a port
+// is returned whole, with brackets removed, and an empty port.
+func splitHostPort(s string) (host, port string) {
+	if h, p, err := net.SplitHostPort(s); err == nil {
+		return h, p
+	}
+	return strings.Trim(s, "[]"), ""
+}
@@ -22,6 +22,14 @@ func TestMatchDomainSuffix(t *testing.T) {
 		{"anthropic.com:8080", "anthropic.com", true},
 		{"openai.com:443", "openai.com", true},
 
+		// Suffixes with a port only match that port
+		{"localhost:11434", "localhost:11434", true},
+		{"localhost:8080", "localhost:11434", false},
+		{"localhost", "localhost:11434", false},
+		{"127.0.0.1:11434", "127.0.0.1", true},
+		{"[::1]:11434", "::1", true},
+		{"[::1]:11434", "[::1]:11434", true},
+
 		// Case insensitivity
 		{"API.Anthropic.COM", "anthropic.com", true},
 		{"api.OPENAI.com", "openai.com", true},
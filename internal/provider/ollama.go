@@ -0,0 +1,70 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Ollama implements Provider for a local Ollama server.
+type Ollama struct{}
+
+// Name returns "ollama".
+func (o *Ollama) Name() string {
+	return "ollama"
+}
+
+// DetectHost returns true for Ollama's default address, localhost:11434.
+func (o *Ollama) DetectHost(host string) bool {
+	return MatchDomainSuffix(host, "localhost:11434") || MatchDomainSuffix(host, "127.0.0.1:11434")
+}
+
+// ollamaResponse is the part of an /api/chat or /api/generate response (or
+// streamed chunk) that carries usage. Counts are only set on the final
+// object, the one with done: true.
+type ollamaResponse struct {
+	Model           string `json:"model"`
+	Done            bool   `json:"done"`
+	DoneReason      string `json:"done_reason"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	Error           string `json:"error"`
+}
+
+// ParseUsage extracts token usage from /api/chat and /api/generate
+// responses. Ollama streams newline-delimited JSON rather than SSE, so isSSE
+// is ignored: a non-streaming response is just a single line.
+func (o *Ollama) ParseUsage(body []byte, isSSE bool) (*Usage, error) {
+	usage := &Usage{}
+	var parsed bool
+	var firstErr error
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var chunk ollamaResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		parsed = true
+
+		if chunk.Model != "" {
+			usage.Model = chunk.Model
+		}
+		if chunk.Error != "" {
+			usage.ErrorMessage = chunk.Error // Ollama errors carry no type
+		}
+		if chunk.Done {
+			usage.InputTokens = chunk.PromptEvalCount
+			usage.OutputTokens = chunk.EvalCount
+			usage.StopReason = chunk.DoneReason
+		}
+	}
+	if !parsed && firstErr != nil {
+		return nil, firstErr
+	}
+	return usage, nil
+}
@@ -0,0 +1,87 @@
+package provider
+
+import "testing"
+
+func TestOllama_DetectHost(t *testing.T) {
+	o := &Ollama{}
+
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"localhost:11434", true},
+		{"127.0.0.1:11434", true},
+		{"localhost", false},
+		{"localhost:8080", false},
+		{"evil-localhost:11434", false},
+		{"api.openai.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got := o.DetectHost(tt.host); got != tt.want {
+				t.Errorf("DetectHost(%q) = %v, want %v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOllama_ParseUsage(t *testing.T) {
+	o := &Ollama{}
+
+	tests := []struct {
+		name string
+		body string
+		want Usage
+	}{
+		{
+			name: "chat",
+			body: `{"model":"llama3.2","created_at":"2025-01-01T00:00:00Z","message":{"role":"assistant","content":"Hi!"},"done_reason":"stop","done":true,"total_duration":512000000,"prompt_eval_count":26,"eval_count":12}`,
+			want: Usage{Model: "llama3.2", InputTokens: 26, OutputTokens: 12, StopReason: "stop"},
+		},
+		{
+			name: "generate",
+			body: `{"model":"qwen2.5-coder:7b","response":"func main() {}","done":true,"done_reason":"length","context":[1,2,3],"prompt_eval_count":40,"eval_count":128}`,
+			want: Usage{Model: "qwen2.5-coder:7b", InputTokens: 40, OutputTokens: 128, StopReason: "length"},
+		},
+		{
+			name: "streamed chat",
+			body: `{"model":"llama3.2","message":{"role":"assistant","content":"Hel"},"done":false}
+{"model":"llama3.2","message":{"role":"assistant","content":"lo"},"done":false}
+{"model":"llama3.2","message":{"role":"assistant","content":""},"done_reason":"stop","done":true,"prompt_eval_count":15,"eval_count":2}
+`,
+			want: Usage{Model: "llama3.2", InputTokens: 15, OutputTokens: 2, StopReason: "stop"},
+		},
+		{
+			name: "stream cut off before the final object",
+			body: `{"model":"llama3.2","response":"Hel","done":false}
+{"model":"llama3.2","resp`,
+			want: Usage{Model: "llama3.2"},
+		},
+		{
+			name: "error",
+			body: `{"error":"model \"llama9\" not found, try pulling it first"}`,
+			want: Usage{ErrorMessage: `model "llama9" not found, try pulling it first`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Streams come back as application/x-ndjson, not SSE, but the
+			// result mustn't depend on the flag either way
+			for _, isSSE := range []bool{false, true} {
+				usage, err := o.ParseUsage([]byte(tt.body), isSSE)
+				if err != nil {
+					t.Fatalf("ParseUsage(isSSE=%v): %v", isSSE, err)
+				}
+				if *usage != tt.want {
+					t.Errorf("ParseUsage(isSSE=%v) = %+v, want %+v", isSSE, *usage, tt.want)
+				}
+			}
+		})
+	}
+
+	if _, err := o.ParseUsage([]byte("not json"), false); err == nil {
+		t.Error("ParseUsage(not json) succeeded, want an error")
+	}
+}
@@ -18,7 +18,20 @@ func (o *OpenAI) DetectHost(host string) bool {
 	return MatchDomainSuffix(host, "openai.com")
 }
 
-// ParseUsage extracts token usage from OpenAI responses.
+// openAIUsage covers both usage shapes: Chat Completions reports
+// prompt/completion tokens, the Responses API (/v1/responses) input/output.
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	InputTokens      int `json:"input_tokens"`
+	OutputTokens     int `json:"output_tokens"`
+}
+
+func (u *openAIUsage) input() int  { return u.PromptTokens + u.InputTokens }
+func (u *openAIUsage) output() int { return u.CompletionTokens + u.OutputTokens }
+
+// ParseUsage extracts token usage from Chat Completions and Responses API
+// responses.
 func (o *OpenAI) ParseUsage(body []byte, isSSE bool) (*Usage, error) {
 	if isSSE {
 		return o.parseSSE(body)
@@ -29,11 +42,8 @@ func (o *OpenAI) ParseUsage(body []byte, isSSE bool) (*Usage, error) {
 // parseJSON extracts usage from a non-streaming JSON response.
 func (o *OpenAI) parseJSON(body []byte) (*Usage, error) {
 	var response struct {
-		Model string `json:"model"`
-		Usage struct {
-			PromptTokens     int `json:"prompt_tokens"`
-			CompletionTokens int `json:"completion_tokens"`
-		} `json:"usage"`
+		Model string      `json:"model"`
+		Usage openAIUsage `json:"usage"`
 	}
 
 	if err := json.Unmarshal(body, &response); err != nil {
@@ -42,14 +52,16 @@ func (o *OpenAI) parseJSON(body []byte) (*Usage, error) {
 
 	return &Usage{
 		Model:        response.Model,
-		InputTokens:  response.Usage.PromptTokens,
-		OutputTokens: response.Usage.CompletionTokens,
+		InputTokens:  response.Usage.input(),
+		OutputTokens: response.Usage.output(),
 	}, nil
 }
 
 // parseSSE extracts usage from an SSE stream.
-// OpenAI includes usage in the final chunk when stream_options.include_usage is true.
-// The stream ends with "data: [DONE]".
+// Chat Completions includes usage in the final chunk when
+// stream_options.include_usage is true, and ends with "data: [DONE]".
+// The Responses API wraps the response object in typed events; usage is on
+// the terminal response.completed (or .incomplete/.failed) event.
 func (o *OpenAI) parseSSE(body []byte) (*Usage, error) {
 	usage := &Usage{}
 	lines := strings.Split(string(body), "\n")
@@ -69,17 +81,23 @@ func (o *OpenAI) parseSSE(body []byte) (*Usage, error) {
 
 		// Try to parse as JSON chunk
 		var chunk struct {
-			Model string `json:"model"`
-			Usage *struct {
-				PromptTokens     int `json:"prompt_tokens"`
-				CompletionTokens int `json:"completion_tokens"`
-			} `json:"usage"`
+			Model    string       `json:"model"`
+			Usage    *openAIUsage `json:"usage"`
+			Response *struct {
+				Model string       `json:"model"`
+				Usage *openAIUsage `json:"usage"`
+			} `json:"response"` // Responses API events
 		}
 
 		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
 			continue // Skip malformed chunks
 		}
 
+		if chunk.Response != nil {
+			chunk.Model = chunk.Response.Model
+			chunk.Usage = chunk.Response.Usage
+		}
+
 		// Capture model from any chunk
 		if chunk.Model != "" {
 			usage.Model = chunk.Model
@@ -87,8 +105,8 @@ func (o *OpenAI) parseSSE(body []byte) (*Usage, error) {
 
 		// Usage appears in the final chunk (when stream_options.include_usage is true)
 		if chunk.Usage != nil {
-			usage.InputTokens = chunk.Usage.PromptTokens
-			usage.OutputTokens = chunk.Usage.CompletionTokens
+			usage.InputTokens = chunk.Usage.input()
+			usage.OutputTokens = chunk.Usage.output()
 		}
 	}
 
@@ -103,6 +103,86 @@ data: [DONE]
 	}
 }
 
+func TestOpenAI_ParseUsage_ResponsesJSON(t *testing.T) {
+	o := &OpenAI{}
+
+	// Non-streaming POST /v1/responses
+	body := []byte(`{
+		"id": "resp_67ccd2bed1ec8190b14f964abc0542670bb6a6b452d3795b",
+		"object": "response",
+		"created_at": 1741476542,
+		"status": "completed",
+		"model": "gpt-4.1-2025-04-14",
+		"output": [
+			{
+				"type": "message",
+				"id": "msg_67ccd2bf17f0819081ff3bb2cf6508e60bb6a6b452d3795b",
+				"status": "completed",
+				"role": "assistant",
+				"content": [{"type": "output_text", "text": "In a peaceful grove...", "annotations": []}]
+			}
+		],
+		"usage": {
+			"input_tokens": 36,
+			"input_tokens_details": {"cached_tokens": 0},
+			"output_tokens": 87,
+			"output_tokens_details": {"reasoning_tokens": 0},
+			"total_tokens": 123
+		}
+	}`)
+
+	usage, err := o.ParseUsage(body, false)
+	if err != nil {
+		t.Fatalf("ParseUsage() error = %v", err)
+	}
+
+	if usage.Model != "gpt-4.1-2025-04-14" {
+		t.Errorf("Model = %q, want %q", usage.Model, "gpt-4.1-2025-04-14")
+	}
+	if usage.InputTokens != 36 {
+		t.Errorf("InputTokens = %d, want %d", usage.InputTokens, 36)
+	}
+	if usage.OutputTokens != 87 {
+		t.Errorf("OutputTokens = %d, want %d", usage.OutputTokens, 87)
+	}
+}
+
+func TestOpenAI_ParseUsage_ResponsesSSE(t *testing.T) {
+	o := &OpenAI{}
+
+	// Streaming POST /v1/responses: typed events, usage on response.completed
+	body := []byte(`event: response.created
+data: {"type":"response.created","sequence_number":0,"response":{"id":"resp_abc","object":"response","status":"in_progress","model":"gpt-4o-2024-08-06","output":[],"usage":null}}
+
+event: response.in_progress
+data: {"type":"response.in_progress","sequence_number":1,"response":{"id":"resp_abc","object":"response","status":"in_progress","model":"gpt-4o-2024-08-06","output":[],"usage":null}}
+
+event: response.output_text.delta
+data: {"type":"response.output_text.delta","sequence_number":4,"item_id":"msg_abc","output_index":0,"content_index":0,"delta":"Hi"}
+
+event: response.output_text.done
+data: {"type":"response.output_text.done","sequence_number":5,"item_id":"msg_abc","output_index":0,"content_index":0,"text":"Hi"}
+
+event: response.completed
+data: {"type":"response.completed","sequence_number":8,"response":{"id":"resp_abc","object":"response","status":"completed","model":"gpt-4o-2024-08-06","output":[{"type":"message","id":"msg_abc","status":"completed","role":"assistant","content":[{"type":"output_text","text":"Hi","annotations":[]}]}],"usage":{"input_tokens":37,"input_tokens_details":{"cached_tokens":0},"output_tokens":11,"output_tokens_details":{"reasoning_tokens":0},"total_tokens":48}}}
+`)
+
+	usage, err := o.ParseUsage(body, true)
+	if err != nil {
+		t.Fatalf("ParseUsage() error = %v", err)
+	}
+
+	if usage.Model != "gpt-4o-2024-08-06" {
+		t.Errorf("Model = %q, want %q", usage.Model, "gpt-4o-2024-08-06")
+	}
+	if usage.InputTokens != 37 {
+		t.Errorf("InputTokens = %d, want %d", usage.InputTokens, 37)
+	}
+	if usage.OutputTokens != 11 {
+		t.Errorf("OutputTokens = %d, want %d", usage.OutputTokens, 11)
+	}
+}
+
 func TestOpenAI_ParseUsage_EmptyBody(t *testing.T) {
 	o := &OpenAI{}
 
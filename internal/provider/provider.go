@@ -8,6 +8,12 @@ type Usage struct {
 	CacheCreationTokens int
 	CacheReadTokens     int
 	Model               string
+
+	// StopReason is why generation ended (e.g. "end_turn", "max_tokens").
+	// ErrorType and ErrorMessage are set when the response is an API error.
+	StopReason   string
+	ErrorType    string
+	ErrorMessage string
 }
 
 // Provider defines the interface for parsing LLM API responses.
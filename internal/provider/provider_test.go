@@ -1,6 +1,7 @@
 package provider
 
 import (
+	"slices"
 	"testing"
 )
 
@@ -16,6 +17,7 @@ func TestRegistry_Detect(t *testing.T) {
 		{"api.openai.com", "openai"},
 		{"bedrock-runtime.us-east-1.amazonaws.com", "bedrock"},
 		{"generativelanguage.googleapis.com", "gemini"},
+		{"localhost:11434", "ollama"},
 		{"example.com", ""},
 	}
 
@@ -64,6 +66,11 @@ func TestRegistry_ShouldIntercept(t *testing.T) {
 		{"bedrock-runtime.evil-amazonaws.com", false},
 		{"bedrock-runtime.us-east-1.amazonaws.com.evil.com", false},
 		{"fakegenerativelanguage.googleapis.com", false},
+
+		// Local servers are only matched on Ollama's port
+		{"localhost:11434", true},
+		{"localhost:3000", false},
+		{"localhost", false},
 	}
 
 	for _, tt := range tests {
@@ -86,6 +93,7 @@ func TestRegistry_Get(t *testing.T) {
 		{"openai", false},
 		{"bedrock", false},
 		{"gemini", false},
+		{"ollama", false},
 		{"unknown", true},
 	}
 
@@ -101,3 +109,80 @@ func TestRegistry_Get(t *testing.T) {
 		})
 	}
 }
+
+func TestRegistry_RegisterCustom(t *testing.T) {
+	r := NewRegistry()
+	if err := r.RegisterCustom("vllm", []string{"llm.internal"}, "openai"); err != nil {
+		t.Fatalf("RegisterCustom: %v", err)
+	}
+
+	p := r.Detect("vllm.llm.internal:8000")
+	if p == nil {
+		t.Fatal("Detect(vllm.llm.internal:8000) = nil, want custom provider")
+	}
+	custom, ok := p.(*Custom)
+	if !ok || custom.Label() != "vllm" {
+		t.Fatalf("Detect = %#v, want *Custom labelled vllm", p)
+	}
+	// Stored and priced under the reused parser's name
+	if p.Name() != "openai" {
+		t.Errorf("Name() = %q, want openai", p.Name())
+	}
+	if !r.ShouldIntercept("llm.internal") {
+		t.Error("ShouldIntercept(llm.internal) = false")
+	}
+	if r.Detect("notllm.internal") != nil {
+		t.Error("Detect(notllm.internal) should not match")
+	}
+	// Built-ins are unaffected
+	if p := r.Detect("api.anthropic.com"); p == nil || p.Name() != "anthropic" {
+		t.Errorf("Detect(api.anthropic.com) = %v", p)
+	}
+
+	usage, err := p.ParseUsage([]byte(`{"model":"meta-llama/Llama-3.1-8B-Instruct","usage":{"prompt_tokens":42,"completion_tokens":7}}`), false)
+	if err != nil {
+		t.Fatalf("ParseUsage: %v", err)
+	}
+	if usage.Model != "meta-llama/Llama-3.1-8B-Instruct" || usage.InputTokens != 42 || usage.OutputTokens != 7 {
+		t.Errorf("usage = %+v", usage)
+	}
+}
+
+func TestRegistry_Hosts(t *testing.T) {
+	r := NewRegistry()
+	if err := r.RegisterCustom("vllm", []string{"llm.internal", "gpu.internal"}, "openai"); err != nil {
+		t.Fatalf("RegisterCustom: %v", err)
+	}
+
+	hosts := r.Hosts()
+	for _, want := range []string{"api.anthropic.com", "api.openai.com", "llm.internal", "gpu.internal"} {
+		if !slices.Contains(hosts, want) {
+			t.Errorf("Hosts() = %v, missing %s", hosts, want)
+		}
+	}
+	for _, host := range hosts {
+		if !r.ShouldIntercept(host) {
+			t.Errorf("Hosts() includes %s, which isn't intercepted", host)
+		}
+	}
+}
+
+func TestRegistry_RegisterCustom_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		pname  string
+		hosts  []string
+		parser string
+	}{
+		{"unknown parser", "x", []string{"x.internal"}, "mistral"},
+		{"no hosts", "x", nil, "openai"},
+		{"no name", "", []string{"x.internal"}, "openai"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewRegistry().RegisterCustom(tt.pname, tt.hosts, tt.parser); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
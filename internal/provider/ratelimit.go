@@ -0,0 +1,117 @@
+package provider
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimits is the normalized quota state reported by a provider's
+// rate-limit response headers. Nil fields were not present.
+type RateLimits struct {
+	RequestsLimit     *int64
+	RequestsRemaining *int64
+	TokensLimit       *int64
+	TokensRemaining   *int64
+	Reset             *time.Time // Earliest reset across requests and tokens
+}
+
+// ParseRateLimits normalizes Anthropic-style (anthropic-ratelimit-*) and
+// OpenAI-style (x-ratelimit-*) headers. now anchors relative reset values.
+// Returns nil if no rate-limit headers are present.
+func ParseRateLimits(h http.Header, now time.Time) *RateLimits {
+	if rl := parseAnthropicRateLimits(h); rl != nil {
+		return rl
+	}
+	return parseOpenAIRateLimits(h, now)
+}
+
+// parseAnthropicRateLimits reads anthropic-ratelimit-{requests,tokens}-*.
+// Resets are RFC 3339 timestamps. When the combined tokens-* headers are
+// absent, the input-tokens-* headers are used instead.
+func parseAnthropicRateLimits(h http.Header) *RateLimits {
+	const prefix = "Anthropic-Ratelimit-"
+	rl := &RateLimits{
+		RequestsLimit:     headerInt(h, prefix+"Requests-Limit"),
+		RequestsRemaining: headerInt(h, prefix+"Requests-Remaining"),
+		TokensLimit:       headerInt(h, prefix+"Tokens-Limit"),
+		TokensRemaining:   headerInt(h, prefix+"Tokens-Remaining"),
+	}
+	tokensReset := prefix + "Tokens-Reset"
+	if rl.TokensLimit == nil && rl.TokensRemaining == nil {
+		rl.TokensLimit = headerInt(h, prefix+"Input-Tokens-Limit")
+		rl.TokensRemaining = headerInt(h, prefix+"Input-Tokens-Remaining")
+		tokensReset = prefix + "Input-Tokens-Reset"
+	}
+	for _, name := range []string{prefix + "Requests-Reset", tokensReset} {
+		if t, err := time.Parse(time.RFC3339, h.Get(name)); err == nil {
+			rl.Reset = earliest(rl.Reset, t)
+		}
+	}
+	if rl.empty() {
+		return nil
+	}
+	return rl
+}
+
+// parseOpenAIRateLimits reads x-ratelimit-{limit,remaining,reset}-*.
+// Resets are durations relative to the response ("6m0s", "20ms", "1s").
+func parseOpenAIRateLimits(h http.Header, now time.Time) *RateLimits {
+	const prefix = "X-Ratelimit-"
+	rl := &RateLimits{
+		RequestsLimit:     headerInt(h, prefix+"Limit-Requests"),
+		RequestsRemaining: headerInt(h, prefix+"Remaining-Requests"),
+		TokensLimit:       headerInt(h, prefix+"Limit-Tokens"),
+		TokensRemaining:   headerInt(h, prefix+"Remaining-Tokens"),
+	}
+	for _, name := range []string{prefix + "Reset-Requests", prefix + "Reset-Tokens"} {
+		if d, ok := parseResetDuration(h.Get(name)); ok {
+			rl.Reset = earliest(rl.Reset, now.Add(d))
+		}
+	}
+	if rl.empty() {
+		return nil
+	}
+	return rl
+}
+
+// parseResetDuration accepts Go-style durations and bare seconds.
+func parseResetDuration(v string) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return d, true
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil && secs >= 0 {
+		return time.Duration(secs * float64(time.Second)), true
+	}
+	return 0, false
+}
+
+func (rl *RateLimits) empty() bool {
+	return rl.RequestsLimit == nil && rl.RequestsRemaining == nil &&
+		rl.TokensLimit == nil && rl.TokensRemaining == nil && rl.Reset == nil
+}
+
+func headerInt(h http.Header, name string) *int64 {
+	v := strings.TrimSpace(h.Get(name))
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
+func earliest(cur *time.Time, t time.Time) *time.Time {
+	t = t.UTC()
+	if cur == nil || t.Before(*cur) {
+		return &t
+	}
+	return cur
+}
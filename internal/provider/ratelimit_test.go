@@ -0,0 +1,134 @@
+package provider
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimits_Anthropic(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	h := http.Header{}
+	h.Set("anthropic-ratelimit-requests-limit", "50")
+	h.Set("anthropic-ratelimit-requests-remaining", "49")
+	h.Set("anthropic-ratelimit-requests-reset", "2026-01-02T03:05:00Z")
+	h.Set("anthropic-ratelimit-tokens-limit", "80000")
+	h.Set("anthropic-ratelimit-tokens-remaining", "72000")
+	h.Set("anthropic-ratelimit-tokens-reset", "2026-01-02T03:04:30Z")
+
+	rl := ParseRateLimits(h, now)
+	if rl == nil {
+		t.Fatal("ParseRateLimits returned nil")
+	}
+	assertInt(t, "RequestsLimit", rl.RequestsLimit, 50)
+	assertInt(t, "RequestsRemaining", rl.RequestsRemaining, 49)
+	assertInt(t, "TokensLimit", rl.TokensLimit, 80000)
+	assertInt(t, "TokensRemaining", rl.TokensRemaining, 72000)
+
+	want := time.Date(2026, 1, 2, 3, 4, 30, 0, time.UTC)
+	if rl.Reset == nil || !rl.Reset.Equal(want) {
+		t.Errorf("Reset = %v, want %v (earliest)", rl.Reset, want)
+	}
+}
+
+func TestParseRateLimits_AnthropicInputTokensFallback(t *testing.T) {
+	h := http.Header{}
+	h.Set("anthropic-ratelimit-input-tokens-limit", "40000")
+	h.Set("anthropic-ratelimit-input-tokens-remaining", "39000")
+	h.Set("anthropic-ratelimit-input-tokens-reset", "2026-01-02T03:05:00Z")
+
+	rl := ParseRateLimits(h, time.Now())
+	if rl == nil {
+		t.Fatal("ParseRateLimits returned nil")
+	}
+	assertInt(t, "TokensLimit", rl.TokensLimit, 40000)
+	assertInt(t, "TokensRemaining", rl.TokensRemaining, 39000)
+	if rl.RequestsLimit != nil {
+		t.Errorf("RequestsLimit = %d, want nil", *rl.RequestsLimit)
+	}
+	if rl.Reset == nil {
+		t.Error("Reset not parsed from input-tokens-reset")
+	}
+}
+
+func TestParseRateLimits_OpenAI(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	h := http.Header{}
+	h.Set("x-ratelimit-limit-requests", "10000")
+	h.Set("x-ratelimit-remaining-requests", "9999")
+	h.Set("x-ratelimit-reset-requests", "6m0s")
+	h.Set("x-ratelimit-limit-tokens", "2000000")
+	h.Set("x-ratelimit-remaining-tokens", "1999000")
+	h.Set("x-ratelimit-reset-tokens", "30ms")
+
+	rl := ParseRateLimits(h, now)
+	if rl == nil {
+		t.Fatal("ParseRateLimits returned nil")
+	}
+	assertInt(t, "RequestsLimit", rl.RequestsLimit, 10000)
+	assertInt(t, "RequestsRemaining", rl.RequestsRemaining, 9999)
+	assertInt(t, "TokensLimit", rl.TokensLimit, 2000000)
+	assertInt(t, "TokensRemaining", rl.TokensRemaining, 1999000)
+
+	want := now.Add(30 * time.Millisecond)
+	if rl.Reset == nil || !rl.Reset.Equal(want) {
+		t.Errorf("Reset = %v, want %v", rl.Reset, want)
+	}
+}
+
+func TestParseRateLimits_ResetFormats(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"1s", time.Second, true},
+		{"6m0s", 6 * time.Minute, true},
+		{"20ms", 20 * time.Millisecond, true},
+		{"2", 2 * time.Second, true},
+		{"0.5", 500 * time.Millisecond, true},
+		{"soon", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			h := http.Header{}
+			h.Set("x-ratelimit-remaining-requests", "1")
+			h.Set("x-ratelimit-reset-requests", tt.value)
+			rl := ParseRateLimits(h, now)
+			if !tt.ok {
+				if rl.Reset != nil {
+					t.Errorf("Reset = %v, want nil", rl.Reset)
+				}
+				return
+			}
+			if rl.Reset == nil || !rl.Reset.Equal(now.Add(tt.want)) {
+				t.Errorf("Reset = %v, want %v", rl.Reset, now.Add(tt.want))
+			}
+		})
+	}
+}
+
+func TestParseRateLimits_NoHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Content-Type", "application/json")
+	h.Set("x-ratelimit-remaining-requests", "not-a-number")
+
+	if rl := ParseRateLimits(h, time.Now()); rl != nil {
+		t.Errorf("ParseRateLimits = %+v, want nil", rl)
+	}
+}
+
+func assertInt(t *testing.T, name string, got *int64, want int64) {
+	t.Helper()
+	if got == nil {
+		t.Errorf("%s = nil, want %d", name, want)
+		return
+	}
+	if *got != want {
+		t.Errorf("%s = %d, want %d", name, *got, want)
+	}
+}
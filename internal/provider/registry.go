@@ -1,8 +1,23 @@
 package provider
 
+import (
+	"fmt"
+	"slices"
+)
+
+// knownHosts are the API hosts of the built-in providers that clients reach
+// by name. Bedrock's are per region and Ollama's is plain HTTP, so neither
+// is listed.
+var knownHosts = []string{
+	"api.anthropic.com",
+	"api.openai.com",
+	"generativelanguage.googleapis.com",
+}
+
 // Registry holds registered providers and selects by host.
 type Registry struct {
 	providers []Provider
+	custom    int // Leading entries of providers that are custom, in config order
 }
 
 // NewRegistry creates a registry with all known providers.
@@ -13,10 +28,36 @@ func NewRegistry() *Registry {
 			&OpenAI{},
 			&Bedrock{},
 			&Gemini{},
+			&Ollama{},
 		},
 	}
 }
 
+// RegisterCustom adds a user-defined provider that matches hosts by domain
+// suffix and parses responses with the built-in provider named parser. It is
+// checked after earlier custom providers but before the built-ins, so it can
+// also claim hosts they would match.
+func (r *Registry) RegisterCustom(name string, hosts []string, parser string) error {
+	if name == "" {
+		return fmt.Errorf("custom provider: name is required")
+	}
+	if len(hosts) == 0 {
+		return fmt.Errorf("custom provider %q: at least one host is required", name)
+	}
+	base := r.Get(parser)
+	if c, ok := base.(*Custom); ok {
+		base = c.parser
+	}
+	if base == nil {
+		return fmt.Errorf("custom provider %q: unknown parser %q (want anthropic, openai, bedrock, gemini or ollama)", name, parser)
+	}
+
+	custom := &Custom{label: name, hosts: hosts, parser: base}
+	r.providers = slices.Insert(r.providers, r.custom, Provider(custom))
+	r.custom++
+	return nil
+}
+
 // Detect returns the provider for a given host, or nil if unknown.
 func (r *Registry) Detect(host string) Provider {
 	for _, p := range r.providers {
@@ -43,3 +84,13 @@ func (r *Registry) Get(name string) Provider {
 func (r *Registry) ShouldIntercept(host string) bool {
 	return r.Detect(host) != nil
 }
+
+// Hosts returns the hosts the registered providers are known to serve: the
+// built-in API hosts, then the custom providers' configured hosts.
+func (r *Registry) Hosts() []string {
+	hosts := slices.Clone(knownHosts)
+	for _, p := range r.providers[:r.custom] {
+		hosts = append(hosts, p.(*Custom).hosts...)
+	}
+	return hosts
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+const (
+	// captureWindowSize is how many recent flow writes the failure rate
+	// covers. Successes push old failures out, so a healed store recovers.
+	captureWindowSize = 100
+
+	// captureMinSamples is the number of writes needed before the status
+	// leaves "ok", so one failure at startup doesn't read as 100%.
+	captureMinSamples = 5
+
+	// Failure rates at or above which capture is degraded or in error.
+	captureDegradedRate = 0.1
+	captureErrorRate    = 0.5
+)
+
+// CaptureMonitor tracks a rolling failure rate for SaveFlow/UpdateFlow so
+// that sustained capture loss (e.g. a full disk) shows up in /api/health
+// instead of only in the logs. A nil monitor records nothing.
+type CaptureMonitor struct {
+	mu            sync.Mutex
+	outcomes      []bool // Ring buffer; true = failed
+	next          int
+	filled        int
+	failures      int
+	totalFailures int64
+	lastErr       string
+	lastFailureAt time.Time
+	status        string
+	onChange      func(store.CaptureHealth)
+}
+
+// NewCaptureMonitor creates a monitor over the default window.
+func NewCaptureMonitor() *CaptureMonitor {
+	return newCaptureMonitor(captureWindowSize)
+}
+
+// newCaptureMonitor creates a monitor over the last size writes.
+func newCaptureMonitor(size int) *CaptureMonitor {
+	return &CaptureMonitor{
+		outcomes: make([]bool, size),
+		status:   store.CaptureStatusOK,
+	}
+}
+
+// OnStatusChange sets a callback run whenever the status changes. It is
+// called without the monitor's lock held.
+func (m *CaptureMonitor) OnStatusChange(fn func(store.CaptureHealth)) {
+	m.mu.Lock()
+	m.onChange = fn
+	m.mu.Unlock()
+}
+
+// Record adds the outcome of one flow write.
+func (m *CaptureMonitor) Record(err error) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	failed := err != nil
+	if m.filled == len(m.outcomes) {
+		if m.outcomes[m.next] {
+			m.failures--
+		}
+	} else {
+		m.filled++
+	}
+	m.outcomes[m.next] = failed
+	m.next = (m.next + 1) % len(m.outcomes)
+	if failed {
+		m.failures++
+		m.totalFailures++
+		m.lastErr = err.Error()
+		m.lastFailureAt = time.Now()
+	}
+
+	prev := m.status
+	m.status = m.statusLocked()
+	changed := m.status != prev
+	health := m.healthLocked()
+	onChange := m.onChange
+	m.mu.Unlock()
+
+	if changed && onChange != nil {
+		onChange(health)
+	}
+}
+
+// Health returns the current capture health.
+func (m *CaptureMonitor) Health() store.CaptureHealth {
+	if m == nil {
+		return store.CaptureHealth{Status: store.CaptureStatusOK}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.healthLocked()
+}
+
+// statusLocked derives the status from the window. m.mu must be held.
+func (m *CaptureMonitor) statusLocked() string {
+	if m.filled < captureMinSamples {
+		return store.CaptureStatusOK
+	}
+	switch rate := m.rateLocked(); {
+	case rate >= captureErrorRate:
+		return store.CaptureStatusError
+	case rate >= captureDegradedRate:
+		return store.CaptureStatusDegraded
+	default:
+		return store.CaptureStatusOK
+	}
+}
+
+// rateLocked returns the failure rate over the window. m.mu must be held.
+func (m *CaptureMonitor) rateLocked() float64 {
+	if m.filled == 0 {
+		return 0
+	}
+	return float64(m.failures) / float64(m.filled)
+}
+
+// healthLocked builds a snapshot. m.mu must be held.
+func (m *CaptureMonitor) healthLocked() store.CaptureHealth {
+	h := store.CaptureHealth{
+		Status:        m.status,
+		FailureRate:   m.rateLocked(),
+		Samples:       m.filled,
+		Failures:      m.failures,
+		TotalFailures: m.totalFailures,
+		LastError:     m.lastErr,
+	}
+	if !m.lastFailureAt.IsZero() {
+		t := m.lastFailureAt
+		h.LastFailureAt = &t
+	}
+	return h
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/redact"
+	"github.com/HakAl/langley/internal/store"
+	"github.com/HakAl/langley/internal/store/storetest"
+	langleytls "github.com/HakAl/langley/internal/tls"
+)
+
+func TestCaptureMonitor_Thresholds(t *testing.T) {
+	m := newCaptureMonitor(10)
+	var changes []string
+	m.OnStatusChange(func(h store.CaptureHealth) { changes = append(changes, h.Status) })
+
+	errDisk := errors.New("disk full")
+
+	// Too few samples to judge
+	m.Record(errDisk)
+	if got := m.Health().Status; got != store.CaptureStatusOK {
+		t.Fatalf("status after 1 failure = %s, want ok", got)
+	}
+
+	// 1 of 10 failed: degraded
+	for i := 0; i < 9; i++ {
+		m.Record(nil)
+	}
+	h := m.Health()
+	if h.Status != store.CaptureStatusDegraded || h.FailureRate != 0.1 {
+		t.Fatalf("health = %+v, want degraded at 0.1", h)
+	}
+
+	// 5 of the last 10 failed (the first failure has aged out): error
+	for i := 0; i < 5; i++ {
+		m.Record(errDisk)
+	}
+	h = m.Health()
+	if h.Status != store.CaptureStatusError || h.LastError != "disk full" || h.LastFailureAt == nil {
+		t.Fatalf("health = %+v, want error with last error", h)
+	}
+
+	// Successes push failures out of the window
+	for i := 0; i < 10; i++ {
+		m.Record(nil)
+	}
+	h = m.Health()
+	if h.Status != store.CaptureStatusOK || h.Failures != 0 || h.TotalFailures != 6 {
+		t.Fatalf("health = %+v, want ok with 6 total failures", h)
+	}
+
+	want := []string{store.CaptureStatusDegraded, store.CaptureStatusError, store.CaptureStatusDegraded, store.CaptureStatusOK}
+	if strings.Join(changes, ",") != strings.Join(want, ",") {
+		t.Errorf("status changes = %v, want %v", changes, want)
+	}
+}
+
+func TestMITMProxy_CaptureHealthDegradesAndRecovers(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer upstream.Close()
+
+	cfg := testConfig()
+	ca, _ := langleytls.LoadOrCreateCA(t.TempDir())
+	redactor, _ := redact.New(&cfg.Redaction)
+	st := storetest.New(storetest.WithForeignKeys())
+	monitor := newCaptureMonitor(10)
+	var completed atomic.Int32
+	p, err := NewMITMProxy(MITMProxyConfig{
+		Config:         cfg,
+		Logger:         testLogger(),
+		CA:             ca,
+		CertCache:      langleytls.NewCertCache(ca, 100),
+		Redactor:       redactor,
+		Store:          st,
+		CaptureMonitor: monitor,
+		OnUpdate:       func(*store.Flow) { completed.Add(1) },
+	})
+	if err != nil {
+		t.Fatalf("NewMITMProxy: %v", err)
+	}
+	proxyServer := httptest.NewServer(p)
+	defer proxyServer.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, proxyServer.URL))},
+	}
+	// Each request makes two writes: SaveFlow at request start, UpdateFlow
+	// before OnUpdate fires
+	send := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			want := completed.Load() + 1
+			resp, err := client.Post(upstream.URL+"/v1/messages", "application/json", strings.NewReader(`{}`))
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+			deadline := time.Now().Add(2 * time.Second)
+			for completed.Load() < want {
+				if time.Now().After(deadline) {
+					t.Fatal("flow not completed")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}
+
+	send(5)
+	if h := monitor.Health(); h.Status != store.CaptureStatusOK || h.Samples != 10 {
+		t.Fatalf("healthy store: health = %+v, want ok over 10 samples", h)
+	}
+
+	// Disk fills up: every write fails and requests are still forwarded
+	diskFull := errors.New("database or disk is full")
+	st.SetError("SaveFlow", diskFull)
+	st.SetError("UpdateFlow", diskFull)
+	send(5)
+	h := monitor.Health()
+	if h.Status != store.CaptureStatusError || h.FailureRate != 1 {
+		t.Fatalf("failing store: health = %+v, want error at rate 1", h)
+	}
+	if !strings.Contains(h.LastError, "disk is full") {
+		t.Errorf("LastError = %q", h.LastError)
+	}
+
+	// Store heals
+	st.SetError("SaveFlow", nil)
+	st.SetError("UpdateFlow", nil)
+	send(5)
+	if h := monitor.Health(); h.Status != store.CaptureStatusOK || h.TotalFailures != 10 {
+		t.Fatalf("healed store: health = %+v, want ok with 10 total failures", h)
+	}
+}
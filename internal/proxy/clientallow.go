@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// clientAllowlist restricts which client addresses may use the proxy
+// (proxy.allowed_cidrs). An empty list allows everyone.
+type clientAllowlist []netip.Prefix
+
+// newClientAllowlist validates proxy.allowed_cidrs. Entries are CIDRs
+// ("10.0.0.0/8") or single addresses ("192.168.1.20").
+func newClientAllowlist(cidrs []string) (clientAllowlist, error) {
+	var a clientAllowlist
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if prefix, err := netip.ParsePrefix(c); err == nil {
+			a = append(a, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(c)
+		if err != nil {
+			return nil, fmt.Errorf("proxy.allowed_cidrs: %q is not a CIDR or IP address", c)
+		}
+		a = append(a, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return a, nil
+}
+
+// allows reports whether a client at remoteAddr (host:port, as in
+// http.Request.RemoteAddr) may use the proxy. Clients on a Unix socket have
+// no IP address and are always local, so they are allowed.
+func (a clientAllowlist) allows(remoteAddr string) bool {
+	if len(a) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return remoteAddr == "" || remoteAddr == "@" // Unix socket peer
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, prefix := range a {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
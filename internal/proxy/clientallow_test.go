@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/config"
+)
+
+func TestClientAllowlist(t *testing.T) {
+	t.Parallel()
+	a, err := newClientAllowlist([]string{"10.0.0.0/8", "192.168.1.20", "fd00::/8"})
+	if err != nil {
+		t.Fatalf("newClientAllowlist: %v", err)
+	}
+	tests := []struct {
+		remoteAddr string
+		want       bool
+	}{
+		{"10.1.2.3:5000", true},
+		{"192.168.1.20:5000", true},
+		{"192.168.1.21:5000", false},
+		{"[::ffff:10.0.0.1]:5000", true}, // IPv4-mapped
+		{"[fd00::1]:5000", true},
+		{"[fe80::1%eth0]:5000", false},
+		{"127.0.0.1:5000", false},
+		{"@", true}, // Unix socket peer
+		{"", true},
+	}
+	for _, tt := range tests {
+		if got := a.allows(tt.remoteAddr); got != tt.want {
+			t.Errorf("allows(%q) = %v, want %v", tt.remoteAddr, got, tt.want)
+		}
+	}
+
+	if !clientAllowlist(nil).allows("203.0.113.9:5000") {
+		t.Error("an empty allowlist should allow every client")
+	}
+	if _, err := newClientAllowlist([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("expected an error for an invalid CIDR")
+	}
+	if _, err := newClientAllowlist([]string{"proxy.internal"}); err == nil {
+		t.Error("expected an error for a hostname")
+	}
+}
+
+func TestMITMProxy_AllowedCIDRs(t *testing.T) {
+	t.Parallel()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"ok":true}`)
+	}))
+	t.Cleanup(upstream.Close) // Outlives the parallel subtests
+
+	tests := []struct {
+		name    string
+		cidrs   []string
+		allowed bool
+	}{
+		{"client outside the allowed CIDRs", []string{"10.0.0.0/8"}, false},
+		{"client inside the allowed CIDRs", []string{"10.0.0.0/8", "127.0.0.0/8"}, true},
+		{"no restriction", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, proxyAddr, capture, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+				cfg.Proxy.AllowedCIDRs = tt.cidrs
+			})
+			defer cleanup()
+
+			// Plain HTTP through the proxy
+			client := &http.Client{
+				Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, "http://"+proxyAddr))},
+				Timeout:   5 * time.Second,
+			}
+			resp, err := client.Get(upstream.URL + "/v1/models")
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+			wantStatus := http.StatusOK
+			if !tt.allowed {
+				wantStatus = http.StatusForbidden
+			}
+			if resp.StatusCode != wantStatus {
+				t.Errorf("HTTP status = %d, want %d", resp.StatusCode, wantStatus)
+			}
+
+			// CONNECT is refused before the tunnel is set up, and the
+			// connection is closed
+			conn, err := net.Dial("tcp", proxyAddr)
+			if err != nil {
+				t.Fatalf("dial proxy: %v", err)
+			}
+			defer conn.Close()
+			_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+			target := upstream.Listener.Addr().String()
+			fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+			br := bufio.NewReader(conn)
+			connectResp, err := http.ReadResponse(br, nil)
+			if !tt.allowed {
+				if err != nil {
+					t.Fatalf("reading CONNECT response: %v", err)
+				}
+				_, _ = io.ReadAll(connectResp.Body)
+				connectResp.Body.Close()
+				if connectResp.StatusCode != http.StatusForbidden {
+					t.Errorf("CONNECT status = %d, want 403", connectResp.StatusCode)
+				}
+				if _, err := br.ReadByte(); err == nil {
+					t.Error("connection still open after a refused CONNECT")
+				}
+				if capture.Flow() != nil {
+					t.Error("a refused client's request was captured")
+				}
+			} else if err != nil || connectResp.StatusCode != http.StatusOK {
+				t.Errorf("CONNECT from an allowed client failed: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+// CostHeader lets a client set a flow's cost itself, in USD, for endpoints
+// the pricing table can't price (flat-rate deployments, experiments). It is
+// never forwarded upstream.
+const CostHeader = "X-Langley-Cost"
+
+// costSourceManual is the cost source of flows costed by CostHeader.
+const costSourceManual = "manual"
+
+// applyManualCost removes CostHeader from r and, if it held a valid
+// non-negative amount, sets the flow's cost from it. calculateCost leaves
+// such a flow's cost alone.
+func (p *MITMProxy) applyManualCost(flow *store.Flow, r *http.Request) {
+	if _, ok := r.Header[http.CanonicalHeaderKey(CostHeader)]; !ok {
+		return
+	}
+	value := strings.TrimSpace(r.Header.Get(CostHeader))
+	r.Header.Del(CostHeader)
+
+	cost, err := strconv.ParseFloat(value, 64)
+	if err != nil || cost < 0 || math.IsInf(cost, 0) || math.IsNaN(cost) {
+		p.logger.Warn("ignoring invalid cost header", "flow_id", flow.ID, "header", CostHeader, "value", value)
+		return
+	}
+	source := costSourceManual
+	flow.TotalCost = &cost
+	flow.CostSource = &source
+}
+
+// hasManualCost reports whether the flow's cost came from CostHeader.
+func hasManualCost(flow *store.Flow) bool {
+	return flow.CostSource != nil && *flow.CostSource == costSourceManual
+}
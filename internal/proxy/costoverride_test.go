@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMITMProxy_CostHeader(t *testing.T) {
+	t.Parallel()
+
+	forwarded := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded <- strings.Join(r.Header.Values(CostHeader), ",")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"type":"message"}`)
+	}))
+	t.Cleanup(upstream.Close)
+
+	tests := []struct {
+		name     string
+		value    string
+		wantCost *float64
+	}{
+		{"valid cost", "0.0123", ptrFloat(0.0123)},
+		{"zero cost", " 0 ", ptrFloat(0)},
+		{"not a number", "cheap", nil},
+		{"negative", "-1", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, proxyAddr, capture, cleanup := setupMITMProxy(t, nil)
+			defer cleanup()
+
+			client := &http.Client{
+				Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, "http://"+proxyAddr))},
+				Timeout:   5 * time.Second,
+			}
+			req, _ := http.NewRequest("POST", upstream.URL+"/v1/messages", strings.NewReader(`{"model":"flat-rate"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(CostHeader, tt.value)
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if got := <-forwarded; got != "" {
+				t.Errorf("upstream got %s: %q, want it stripped", CostHeader, got)
+			}
+			flow := capture.WaitForUpdate(2 * time.Second)
+			if flow == nil {
+				t.Fatal("flow was not completed")
+			}
+			if _, ok := flow.RequestHeaders[CostHeader]; ok {
+				t.Errorf("stored request headers include %s", CostHeader)
+			}
+			if tt.wantCost == nil {
+				if flow.TotalCost != nil || flow.CostSource != nil {
+					t.Errorf("cost = %v (%v), want none for an invalid header", flow.TotalCost, flow.CostSource)
+				}
+				return
+			}
+			if flow.TotalCost == nil || *flow.TotalCost != *tt.wantCost {
+				t.Errorf("TotalCost = %v, want %v", flow.TotalCost, *tt.wantCost)
+			}
+			if flow.CostSource == nil || *flow.CostSource != costSourceManual {
+				t.Errorf("CostSource = %v, want %q", flow.CostSource, costSourceManual)
+			}
+		})
+	}
+}
+
+func ptrFloat(v float64) *float64 { return &v }
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+// deltaFilter limits which content deltas are stored, per
+// parser.store_deltas. A nil deltaFilter stores every delta.
+type deltaFilter struct {
+	every int // Store every Nth delta; 0 stores none
+}
+
+// newDeltaFilter returns a deltaFilter for mode, or nil in "all" mode.
+func newDeltaFilter(mode string) (*deltaFilter, error) {
+	switch {
+	case mode == "" || mode == "all":
+		return nil, nil
+	case mode == "none":
+		return &deltaFilter{}, nil
+	case strings.HasPrefix(mode, "sampled:"):
+		n, err := strconv.Atoi(strings.TrimPrefix(mode, "sampled:"))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("parser.store_deltas: invalid sample rate in %q (want sampled:N with N >= 1)", mode)
+		}
+		if n == 1 {
+			return nil, nil
+		}
+		return &deltaFilter{every: n}, nil
+	default:
+		return nil, fmt.Errorf("parser.store_deltas: unknown mode %q (want all, none or sampled:N)", mode)
+	}
+}
+
+// sampler returns the sampling state for one flow's stream.
+func (f *deltaFilter) sampler() *deltaSampler {
+	return &deltaSampler{filter: f}
+}
+
+// deltaSampler applies a deltaFilter to the events of one flow in order.
+type deltaSampler struct {
+	filter  *deltaFilter
+	deltas  int // Content deltas seen so far
+	skipped int // Content deltas not stored
+}
+
+// keep reports whether event should be stored. Only text and other
+// content deltas are sampled; structural events and tool input are kept.
+func (s *deltaSampler) keep(event *store.Event) bool {
+	if s.filter == nil || !isContentDelta(event) {
+		return true
+	}
+	n := s.deltas
+	s.deltas++
+	if s.filter.every > 0 && n%s.filter.every == 0 {
+		return true
+	}
+	s.skipped++
+	return false
+}
+
+// isContentDelta reports whether event is an incremental content delta:
+// Anthropic's content_block_delta, Bedrock Converse's contentBlockDelta, or
+// an OpenAI Realtime text, transcript or audio delta or audio append.
+// Deltas carrying tool-use input are not counted, so tool calls can always
+// be reconstructed from stored events.
+func isContentDelta(event *store.Event) bool {
+	switch event.EventType {
+	case "response.text.delta", "response.audio.delta", "response.audio_transcript.delta",
+		"input_audio_buffer.append":
+		return true
+	case "content_block_delta":
+		delta, _ := event.EventData["delta"].(map[string]interface{})
+		return delta["type"] != "input_json_delta"
+	case "contentBlockDelta":
+		delta, _ := event.EventData["delta"].(map[string]interface{})
+		_, toolUse := delta["toolUse"]
+		return !toolUse
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/redact"
+	"github.com/HakAl/langley/internal/store"
+	langleytls "github.com/HakAl/langley/internal/tls"
+)
+
+func TestNewDeltaFilter_InvalidConfig(t *testing.T) {
+	for _, mode := range []string{"some", "sampled:", "sampled:0", "sampled:-2", "sampled:x"} {
+		if _, err := newDeltaFilter(mode); err == nil {
+			t.Errorf("newDeltaFilter(%q): expected error", mode)
+		}
+	}
+}
+
+func TestMITMProxy_StoreDeltas(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		send := func(eventType, data string) {
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, data)
+		}
+		send("message_start", `{"type":"message_start","message":{"model":"claude-sonnet-4-20250514","usage":{"input_tokens":10}}}`)
+		send("content_block_start", `{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`)
+		for i := 0; i < 7; i++ {
+			send("content_block_delta", fmt.Sprintf(`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"t%d"}}`, i))
+		}
+		send("content_block_stop", `{"type":"content_block_stop","index":0}`)
+		send("content_block_start", `{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"Bash","input":{}}}`)
+		for _, part := range []string{`{\"com`, `mand\":`, `\"ls\"}`} {
+			send("content_block_delta", fmt.Sprintf(`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"%s"}}`, part))
+		}
+		send("content_block_stop", `{"type":"content_block_stop","index":1}`)
+		send("message_delta", `{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":20}}`)
+		send("message_stop", `{"type":"message_stop"}`)
+	}))
+	t.Cleanup(upstream.Close) // Subtests run in parallel after this function returns
+
+	tests := []struct {
+		mode        string
+		wantText    []string // Text deltas stored
+		wantSkipped int
+	}{
+		{"all", []string{"t0", "t1", "t2", "t3", "t4", "t5", "t6"}, 0},
+		{"sampled:3", []string{"t0", "t3", "t6"}, 4},
+		{"none", nil, 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := testConfig()
+			cfg.Parser.StoreDeltas = tt.mode
+			dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+			if err != nil {
+				t.Fatalf("NewSQLiteStore: %v", err)
+			}
+			defer dataStore.Close()
+
+			ca, _ := langleytls.LoadOrCreateCA(t.TempDir())
+			redactor, _ := redact.New(&cfg.Redaction)
+			capture := &flowCapture{}
+			p, err := NewMITMProxy(MITMProxyConfig{
+				Config:    cfg,
+				Logger:    testLogger(),
+				CA:        ca,
+				CertCache: langleytls.NewCertCache(ca, 100),
+				Redactor:  redactor,
+				Store:     dataStore,
+				OnUpdate:  capture.OnUpdate,
+				OnEvent:   capture.OnEvent,
+			})
+			if err != nil {
+				t.Fatalf("NewMITMProxy: %v", err)
+			}
+			proxyServer := httptest.NewServer(p)
+			defer proxyServer.Close()
+
+			client := &http.Client{
+				Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, proxyServer.URL))},
+			}
+			resp, err := client.Post(upstream.URL+"/v1/messages", "application/json", strings.NewReader(`{"stream":true}`))
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+
+			captured := capture.WaitForFlow(2 * time.Second)
+			if captured == nil {
+				t.Fatal("flow not captured")
+			}
+			ctx := context.Background()
+			flow, err := dataStore.GetFlow(ctx, captured.ID)
+			if err != nil {
+				t.Fatalf("GetFlow: %v", err)
+			}
+			if flow.EventsSkippedCount != tt.wantSkipped {
+				t.Errorf("EventsSkippedCount = %d, want %d", flow.EventsSkippedCount, tt.wantSkipped)
+			}
+
+			// Sampling only affects storage
+			if got := len(capture.Events()); got != 17 {
+				t.Errorf("broadcast %d events, want 17", got)
+			}
+
+			events, err := dataStore.GetEventsByFlow(ctx, flow.ID)
+			if err != nil {
+				t.Fatalf("GetEventsByFlow: %v", err)
+			}
+			var text []string
+			counts := map[string]int{}
+			toolDeltas := 0
+			for _, e := range events {
+				counts[e.EventType]++
+				delta, _ := e.EventData["delta"].(map[string]interface{})
+				switch delta["type"] {
+				case "text_delta":
+					text = append(text, delta["text"].(string))
+				case "input_json_delta":
+					toolDeltas++
+				}
+			}
+			if strings.Join(text, ",") != strings.Join(tt.wantText, ",") {
+				t.Errorf("stored text deltas = %v, want %v", text, tt.wantText)
+			}
+			if toolDeltas != 3 {
+				t.Errorf("stored %d tool input deltas, want 3", toolDeltas)
+			}
+			for eventType, want := range map[string]int{
+				"message_start": 1, "content_block_start": 2, "content_block_stop": 2,
+				"message_delta": 1, "message_stop": 1,
+			} {
+				if counts[eventType] != want {
+					t.Errorf("stored %d %s events, want %d", counts[eventType], eventType, want)
+				}
+			}
+
+			// Tool invocations are extracted from the full stream
+			invocations, _, err := dataStore.ListToolInvocations(ctx, "Bash", time.Now().Add(-time.Minute), time.Now().Add(time.Minute), 10, 0)
+			if err != nil {
+				t.Fatalf("ListToolInvocations: %v", err)
+			}
+			if len(invocations) != 1 {
+				t.Errorf("got %d tool invocations, want 1", len(invocations))
+			}
+		})
+	}
+}
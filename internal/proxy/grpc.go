@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// clientHelloTimeout bounds how long a CONNECT client may take to send its
+// TLS ClientHello.
+const clientHelloTimeout = 10 * time.Second
+
+// errHelloPeeked stops the handshake once the ClientHello has been read.
+var errHelloPeeked = errors.New("client hello peeked")
+
+// peekClientHello reads the TLS ClientHello from conn without answering it.
+// It returns the hello and the raw bytes read, which must be replayed to
+// whatever handles the connection next (see replayConn).
+func peekClientHello(conn net.Conn) (*tls.ClientHelloInfo, []byte, error) {
+	var raw bytes.Buffer
+	var hello *tls.ClientHelloInfo
+
+	_ = conn.SetReadDeadline(time.Now().Add(clientHelloTimeout))
+	defer func() { _ = conn.SetReadDeadline(time.Time{}) }()
+
+	err := tls.Server(&peekConn{Conn: conn, r: io.TeeReader(conn, &raw)}, &tls.Config{
+		GetConfigForClient: func(h *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = h
+			return nil, errHelloPeeked
+		},
+	}).Handshake()
+	if hello == nil {
+		return nil, raw.Bytes(), err
+	}
+	return hello, raw.Bytes(), nil
+}
+
+// peekConn reads through r and drops writes, so a handshake run on it can't
+// send anything (such as an alert) to the client.
+type peekConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *peekConn) Read(b []byte) (int, error)  { return c.r.Read(b) }
+func (c *peekConn) Write(b []byte) (int, error) { return len(b), nil }
+
+// replayConn returns bytes already read from the connection before reading
+// from it again.
+type replayConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func newReplayConn(conn net.Conn, peeked []byte) *replayConn {
+	return &replayConn{Conn: conn, r: io.MultiReader(bytes.NewReader(peeked), conn)}
+}
+
+func (c *replayConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+// isGRPCHello reports whether a ClientHello looks like a gRPC client, which
+// needs HTTP/2 end to end and can't be intercepted (the MITM path speaks
+// HTTP/1.1 only). A client offering h2 without http/1.1 can only be
+// talking HTTP/2; on a proxy.grpc_hosts host, offering h2 at all is enough.
+func isGRPCHello(protos []string, grpcHost bool) bool {
+	if !slices.Contains(protos, "h2") {
+		return false
+	}
+	return grpcHost || !slices.Contains(protos, "http/1.1")
+}
+
+// isGRPCContentType reports whether a request or response carries gRPC
+// (including gRPC-Web) rather than JSON.
+func isGRPCContentType(contentType string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(contentType)), "application/grpc")
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/config"
+)
+
+func TestIsGRPCHello(t *testing.T) {
+	tests := []struct {
+		protos   []string
+		grpcHost bool
+		want     bool
+	}{
+		{[]string{"h2"}, false, true},
+		{[]string{"h2", "http/1.1"}, false, false},
+		{[]string{"h2", "http/1.1"}, true, true},
+		{[]string{"http/1.1"}, true, false},
+		{nil, true, false},
+	}
+	for _, tt := range tests {
+		if got := isGRPCHello(tt.protos, tt.grpcHost); got != tt.want {
+			t.Errorf("isGRPCHello(%v, %v) = %v, want %v", tt.protos, tt.grpcHost, got, tt.want)
+		}
+	}
+}
+
+// TestMITMProxy_GRPCPassthrough verifies that an HTTP/2 gRPC client talking
+// to an intercepted host listed in grpc_hosts is tunneled untouched: the
+// client negotiates h2 with the real upstream and no flow is captured.
+func TestMITMProxy_GRPCPassthrough(t *testing.T) {
+	t.Parallel()
+
+	// Binary payload with a gRPC length prefix, which would not survive
+	// being treated as text
+	payload := []byte{0x00, 0x00, 0x00, 0x00, 0x05, 0x0a, 0x03, 0xff, 0x00, 0x80}
+
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			http.Error(w, "gRPC requires HTTP/2", http.StatusHTTPVersionNotSupported)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		_, _ = w.Write(body)
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	upstream.EnableHTTP2 = true
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+	_, proxyAddr, capture, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+		cfg.Proxy.InterceptHosts = []string{upstreamURL.Hostname()}
+		cfg.Proxy.GRPCHosts = []string{upstreamURL.Hostname()}
+	})
+	defer cleanup()
+
+	// Trust only the upstream's own certificate: the proxy must not intercept
+	proxyURL, _ := url.Parse("http://" + proxyAddr)
+	pool := x509.NewCertPool()
+	pool.AddCert(upstream.Certificate())
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:             http.ProxyURL(proxyURL),
+			TLSClientConfig:   &tls.Config{RootCAs: pool},
+			ForceAttemptHTTP2: true,
+		},
+		Timeout: 10 * time.Second,
+	}
+
+	req, _ := http.NewRequest("POST", upstream.URL+"/llm.v1.Generate/Stream", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("gRPC request through proxy failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.ProtoMajor != 2 {
+		t.Errorf("negotiated %s, want HTTP/2", resp.Proto)
+	}
+	if !bytes.Equal(body, payload) {
+		t.Errorf("body = %x, want %x", body, payload)
+	}
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("Grpc-Status trailer = %q, want 0", got)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if f := capture.Flow(); f != nil {
+		t.Errorf("expected no captured flow for gRPC passthrough, got flow for host %q", f.Host)
+	}
+}
+
+// TestMITMProxy_GRPCHostHTTP1StillIntercepted verifies that HTTP/1.1
+// clients of a grpc_hosts host are still intercepted.
+func TestMITMProxy_GRPCHostHTTP1StillIntercepted(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer upstream.Close()
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+	p, proxyAddr, capture, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+		cfg.Proxy.InterceptHosts = []string{upstreamURL.Hostname()}
+		cfg.Proxy.GRPCHosts = []string{upstreamURL.Hostname()}
+	})
+	defer cleanup()
+
+	proxyURL, _ := url.Parse("http://" + proxyAddr)
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(p.ca.CertPEM())
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+		Timeout: 10 * time.Second,
+	}
+
+	resp, err := client.Get(upstream.URL + "/v1/models")
+	if err != nil {
+		t.Fatalf("request through proxy failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if f := capture.WaitForFlow(2 * time.Second); f == nil {
+		t.Fatal("expected captured flow for HTTP/1.1 client")
+	}
+}
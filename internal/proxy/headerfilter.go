@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/HakAl/langley/internal/config"
+)
+
+// headerFilter limits which headers are stored with a flow, per
+// persistence.store_headers. A nil headerFilter stores every header.
+type headerFilter struct {
+	allowed map[string]bool // Canonical header names; empty stores none
+}
+
+// newHeaderFilter returns a headerFilter for cfg, or nil in "all" mode.
+func newHeaderFilter(cfg config.StoreHeadersConfig) (*headerFilter, error) {
+	switch cfg.Mode {
+	case "", config.StoreHeadersAll:
+		return nil, nil
+	case config.StoreHeadersNone:
+		return &headerFilter{allowed: map[string]bool{}}, nil
+	case config.StoreHeadersAllowlist:
+		if len(cfg.Allowlist) == 0 {
+			return nil, fmt.Errorf("persistence.store_headers: allowlist mode needs at least one header name")
+		}
+		allowed := make(map[string]bool, len(cfg.Allowlist))
+		for _, name := range cfg.Allowlist {
+			allowed[http.CanonicalHeaderKey(name)] = true
+		}
+		return &headerFilter{allowed: allowed}, nil
+	default:
+		return nil, fmt.Errorf("persistence.store_headers: unknown mode %q (want all, none or allowlist)", cfg.Mode)
+	}
+}
+
+// Headers returns the subset of h to store. h itself is not modified.
+func (f *headerFilter) Headers(h http.Header) http.Header {
+	if f == nil {
+		return h
+	}
+	out := make(http.Header, len(f.allowed))
+	for name, values := range h {
+		if f.allowed[http.CanonicalHeaderKey(name)] {
+			out[name] = values
+		}
+	}
+	return out
+}
+
+// Order returns the header order limited to stored headers, so the order
+// doesn't reveal headers that were filtered out.
+func (f *headerFilter) Order(order []string) []string {
+	if f == nil || order == nil {
+		return order
+	}
+	out := []string{}
+	for _, name := range order {
+		if f.allowed[http.CanonicalHeaderKey(name)] {
+			out = append(out, name)
+		}
+	}
+	return out
+}
@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/config"
+	"github.com/HakAl/langley/internal/redact"
+	"github.com/HakAl/langley/internal/store/storetest"
+	langleytls "github.com/HakAl/langley/internal/tls"
+)
+
+func TestNewHeaderFilter_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.StoreHeadersConfig
+	}{
+		{"unknown mode", config.StoreHeadersConfig{Mode: "some"}},
+		{"empty allowlist", config.StoreHeadersConfig{Mode: config.StoreHeadersAllowlist}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newHeaderFilter(tt.cfg); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestMITMProxy_StoreHeadersModes(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cf-Ray", "8c1f2a")
+		w.Header().Set("Request-Id", "req_123")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(upstream.Close) // Subtests run in parallel after this function returns
+
+	tests := []struct {
+		name         string
+		storeHeaders config.StoreHeadersConfig
+		wantReq      []string
+		absentReq    []string
+		wantResp     []string
+		absentResp   []string
+	}{
+		{
+			name:         "all",
+			storeHeaders: config.StoreHeadersConfig{Mode: config.StoreHeadersAll},
+			wantReq:      []string{"Anthropic-Version", "X-Cache-Bust"},
+			wantResp:     []string{"Content-Type", "Cf-Ray", "Request-Id"},
+		},
+		{
+			name:         "default is all",
+			storeHeaders: config.StoreHeadersConfig{},
+			wantReq:      []string{"Anthropic-Version", "X-Cache-Bust"},
+			wantResp:     []string{"Cf-Ray"},
+		},
+		{
+			name:         "none",
+			storeHeaders: config.StoreHeadersConfig{Mode: config.StoreHeadersNone},
+			absentReq:    []string{"Anthropic-Version", "X-Cache-Bust"},
+			absentResp:   []string{"Content-Type", "Cf-Ray", "Request-Id"},
+		},
+		{
+			name: "allowlist",
+			storeHeaders: config.StoreHeadersConfig{
+				Mode:      config.StoreHeadersAllowlist,
+				Allowlist: []string{"anthropic-version", "REQUEST-ID"},
+			},
+			wantReq:    []string{"Anthropic-Version"},
+			absentReq:  []string{"X-Cache-Bust"},
+			wantResp:   []string{"Request-Id"},
+			absentResp: []string{"Content-Type", "Cf-Ray"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := testConfig()
+			cfg.Persistence.StoreHeaders = tt.storeHeaders
+			ca, _ := langleytls.LoadOrCreateCA(t.TempDir())
+			redactor, _ := redact.New(&cfg.Redaction)
+			capture := &flowCapture{}
+			p, err := NewMITMProxy(MITMProxyConfig{
+				Config:    cfg,
+				Logger:    testLogger(),
+				CA:        ca,
+				CertCache: langleytls.NewCertCache(ca, 100),
+				Redactor:  redactor,
+				Store:     storetest.New(storetest.WithForeignKeys()),
+				OnUpdate:  capture.OnUpdate,
+			})
+			if err != nil {
+				t.Fatalf("NewMITMProxy: %v", err)
+			}
+			proxyServer := httptest.NewServer(p)
+			defer proxyServer.Close()
+
+			client := &http.Client{
+				Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, proxyServer.URL))},
+			}
+			req, _ := http.NewRequest("POST", upstream.URL+"/v1/messages", strings.NewReader(`{}`))
+			req.Header.Set("Anthropic-Version", "2023-06-01")
+			req.Header.Set("X-Cache-Bust", "1")
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			// Filtering only affects storage; the client sees every header
+			if resp.Header.Get("Cf-Ray") == "" {
+				t.Error("Cf-Ray not forwarded to client")
+			}
+
+			flow := capture.WaitForFlow(2 * time.Second)
+			if flow == nil {
+				t.Fatal("expected captured flow")
+			}
+			for _, h := range tt.wantReq {
+				if _, ok := flow.RequestHeaders[h]; !ok {
+					t.Errorf("RequestHeaders missing %s: %v", h, flow.RequestHeaders)
+				}
+			}
+			for _, h := range tt.absentReq {
+				if _, ok := flow.RequestHeaders[h]; ok {
+					t.Errorf("RequestHeaders should not contain %s", h)
+				}
+			}
+			for _, h := range tt.wantResp {
+				if _, ok := flow.ResponseHeaders[h]; !ok {
+					t.Errorf("ResponseHeaders missing %s: %v", h, flow.ResponseHeaders)
+				}
+			}
+			for _, h := range tt.absentResp {
+				if _, ok := flow.ResponseHeaders[h]; ok {
+					t.Errorf("ResponseHeaders should not contain %s", h)
+				}
+			}
+		})
+	}
+}
+
+func TestHeaderFilter_Order(t *testing.T) {
+	f, err := newHeaderFilter(config.StoreHeadersConfig{
+		Mode:      config.StoreHeadersAllowlist,
+		Allowlist: []string{"content-type"},
+	})
+	if err != nil {
+		t.Fatalf("newHeaderFilter: %v", err)
+	}
+	got := f.Order([]string{"Host", "content-type", "Authorization"})
+	if len(got) != 1 || got[0] != "content-type" {
+		t.Errorf("Order = %v, want [content-type]", got)
+	}
+
+	var all *headerFilter
+	if got := all.Order([]string{"Host"}); len(got) != 1 {
+		t.Errorf("nil filter Order = %v, want unchanged", got)
+	}
+}
@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// tlsReaderSize is the client read buffer size for MITM connections. It bounds
+// the header block peekHeaderOrder can see; larger blocks fall back to
+// unordered forwarding.
+const tlsReaderSize = 64 * 1024
+
+// peekHeaderOrder returns header names in the order the client sent them,
+// without consuming anything from br. Go's http.Header is a map, so this is
+// the only point where the original order is still available.
+// Returns nil if the header block can't be read or doesn't fit in br.
+func peekHeaderOrder(br *bufio.Reader) []string {
+	n := 1
+	for {
+		if _, err := br.Peek(n); err != nil {
+			return nil
+		}
+		buf, _ := br.Peek(br.Buffered())
+		if end := headerBlockEnd(buf); end >= 0 {
+			return parseHeaderOrder(buf[:end])
+		}
+		if len(buf) >= br.Size() {
+			return nil
+		}
+		n = len(buf) + 1
+	}
+}
+
+// headerBlockEnd returns the index of the blank line ending the header block,
+// accepting both CRLF and bare LF line endings, or -1 if not yet complete.
+func headerBlockEnd(buf []byte) int {
+	end := -1
+	for _, sep := range [][]byte{[]byte("\n\r\n"), []byte("\n\n")} {
+		if i := bytes.Index(buf, sep); i >= 0 && (end < 0 || i < end) {
+			end = i
+		}
+	}
+	return end
+}
+
+// parseHeaderOrder extracts header names, as sent, from a raw header block
+// that starts with the request line. Repeated headers appear once per line.
+func parseHeaderOrder(block []byte) []string {
+	lines := strings.Split(string(block), "\n")
+	if len(lines) < 2 {
+		return nil
+	}
+	order := make([]string, 0, len(lines)-1)
+	for _, line := range lines[1:] {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || line[0] == ' ' || line[0] == '\t' {
+			continue // Obsolete line folding continues the previous header
+		}
+		name, _, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		order = append(order, strings.TrimSpace(name))
+	}
+	return order
+}
+
+// writeRequestInOrder writes req as HTTP/1.1 with headers in the given order,
+// using the names' original casing. Headers in req.Header but not in order
+// (e.g. added by the proxy) follow in sorted order; names in order but no
+// longer in req.Header (e.g. hop-by-hop) are skipped. Content-Length is
+// recomputed from body since the body has already been fully read.
+func writeRequestInOrder(w io.Writer, req *http.Request, body []byte, order []string) error {
+	header := requestHeader(req)
+	if len(body) > 0 || header.Get("Content-Length") != "" {
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	var b bytes.Buffer
+	writeRequestHead(&b, req, header, order)
+	b.Write(body)
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// writeStreamingRequestInOrder is writeRequestInOrder for a body streamed
+// from the client. A length of -1 sends the body chunked.
+func writeStreamingRequestInOrder(w io.Writer, req *http.Request, body io.Reader, length int64, order []string) error {
+	header := requestHeader(req)
+	if length >= 0 {
+		header.Set("Content-Length", strconv.FormatInt(length, 10))
+	} else {
+		header.Del("Content-Length")
+		header.Set("Transfer-Encoding", "chunked")
+	}
+
+	var b bytes.Buffer
+	writeRequestHead(&b, req, header, order)
+	if _, err := w.Write(b.Bytes()); err != nil {
+		return err
+	}
+	if length >= 0 {
+		_, err := io.Copy(w, body)
+		return err
+	}
+	cw := httputil.NewChunkedWriter(w)
+	if _, err := io.Copy(cw, body); err != nil {
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\r\n") // No trailers
+	return err
+}
+
+// requestHeader returns a copy of req's headers to write, with Host set and
+// framing headers left for the caller.
+func requestHeader(req *http.Request) http.Header {
+	header := req.Header.Clone()
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	header.Set("Host", host)
+	header.Del("Transfer-Encoding")
+	return header
+}
+
+// writeRequestHead writes the request line and header, in order, to b.
+func writeRequestHead(b *bytes.Buffer, req *http.Request, header http.Header, order []string) {
+	hasHost := false
+	for _, name := range order {
+		if strings.EqualFold(name, "Host") {
+			hasHost = true
+			break
+		}
+	}
+	if !hasHost {
+		order = append([]string{"Host"}, order...)
+	}
+
+	fmt.Fprintf(b, "%s %s HTTP/1.1\r\n", req.Method, req.URL.RequestURI())
+
+	written := make(map[string]int)
+	for _, name := range order {
+		key := textproto.CanonicalMIMEHeaderKey(name)
+		values := header[key]
+		i := written[key]
+		if i >= len(values) {
+			continue
+		}
+		fmt.Fprintf(b, "%s: %s\r\n", name, values[i])
+		written[key] = i + 1
+	}
+
+	remaining := make([]string, 0, len(header))
+	for key, values := range header {
+		if written[key] < len(values) {
+			remaining = append(remaining, key)
+		}
+	}
+	sort.Strings(remaining)
+	for _, key := range remaining {
+		for _, v := range header[key][written[key]:] {
+			fmt.Fprintf(b, "%s: %s\r\n", key, v)
+		}
+	}
+
+	b.WriteString("\r\n")
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// headerLines returns the header names of a raw HTTP message in wire order.
+func headerLines(t *testing.T, raw string) []string {
+	t.Helper()
+	head, _, ok := strings.Cut(raw, "\r\n\r\n")
+	if !ok {
+		t.Fatalf("no header terminator in %q", raw)
+	}
+	return parseHeaderOrder([]byte(head))
+}
+
+func TestPeekHeaderOrder(t *testing.T) {
+	t.Parallel()
+
+	raw := "POST /v1/messages HTTP/1.1\r\n" +
+		"Host: api.anthropic.com\r\n" +
+		"X-Zeta: 1\r\n" +
+		"content-type: application/json\r\n" +
+		"X-Alpha: a\r\n" +
+		"X-Zeta: 2\r\n" +
+		"Content-Length: 2\r\n" +
+		"\r\n{}"
+
+	br := bufio.NewReader(strings.NewReader(raw))
+	got := peekHeaderOrder(br)
+	want := []string{"Host", "X-Zeta", "content-type", "X-Alpha", "X-Zeta", "Content-Length"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("order = %v, want %v", got, want)
+	}
+
+	// Peeking must not consume the request
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		t.Fatalf("ReadRequest after peek: %v", err)
+	}
+	body, _ := io.ReadAll(req.Body)
+	if string(body) != "{}" {
+		t.Errorf("body = %q, want {}", body)
+	}
+}
+
+func TestPeekHeaderOrder_BareLF(t *testing.T) {
+	t.Parallel()
+
+	br := bufio.NewReader(strings.NewReader("GET / HTTP/1.1\nHost: x\nAccept: */*\n\n"))
+	got := peekHeaderOrder(br)
+	if want := []string{"Host", "Accept"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+}
+
+func TestPeekHeaderOrder_TooLarge(t *testing.T) {
+	t.Parallel()
+
+	raw := "GET / HTTP/1.1\r\nHost: x\r\nX-Big: " + strings.Repeat("a", 8192) + "\r\n\r\n"
+	br := bufio.NewReaderSize(strings.NewReader(raw), 4096)
+	if got := peekHeaderOrder(br); got != nil {
+		t.Errorf("expected nil order for oversized headers, got %v", got)
+	}
+	if _, err := http.ReadRequest(br); err != nil {
+		t.Errorf("request should still be readable: %v", err)
+	}
+}
+
+func TestWriteRequestInOrder_Replay(t *testing.T) {
+	t.Parallel()
+
+	raw := "POST /v1/messages?beta=true HTTP/1.1\r\n" +
+		"x-stainless-lang: js\r\n" +
+		"Host: api.anthropic.com\r\n" +
+		"anthropic-version: 2023-06-01\r\n" +
+		"Connection: keep-alive\r\n" +
+		"Accept: application/json\r\n" +
+		"Accept: text/event-stream\r\n" +
+		"Content-Type: application/json\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"2\r\n{}\r\n0\r\n\r\n"
+
+	// Capture
+	br := bufio.NewReader(strings.NewReader(raw))
+	order := peekHeaderOrder(br)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		t.Fatalf("ReadRequest: %v", err)
+	}
+	body, _ := io.ReadAll(req.Body)
+
+	// Replay the way handleTLSRequest forwards it
+	outReq, _ := http.NewRequest(req.Method, "https://api.anthropic.com"+req.URL.RequestURI(), bytes.NewReader(body))
+	copyHeaders(outReq.Header, req.Header)
+	removeHopByHopHeaders(outReq.Header)
+	outReq.Header.Set("X-Added", "1")
+
+	var out bytes.Buffer
+	if err := writeRequestInOrder(&out, outReq, body, order); err != nil {
+		t.Fatalf("writeRequestInOrder: %v", err)
+	}
+
+	if !strings.HasPrefix(out.String(), "POST /v1/messages?beta=true HTTP/1.1\r\n") {
+		t.Errorf("unexpected request line: %q", out.String())
+	}
+	got := headerLines(t, out.String())
+	want := []string{
+		"x-stainless-lang", "Host", "anthropic-version", "Accept", "Accept", "Content-Type",
+		"Content-Length", "X-Added", // Not sent by the client: appended in sorted order
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed order = %v, want %v", got, want)
+	}
+
+	// The replayed bytes must still be a valid request
+	replayed, err := http.ReadRequest(bufio.NewReader(&out))
+	if err != nil {
+		t.Fatalf("replayed request doesn't parse: %v", err)
+	}
+	replayedBody, _ := io.ReadAll(replayed.Body)
+	if string(replayedBody) != "{}" {
+		t.Errorf("replayed body = %q, want {}", replayedBody)
+	}
+	if got := replayed.Header.Values("Accept"); !reflect.DeepEqual(got, []string{"application/json", "text/event-stream"}) {
+		t.Errorf("Accept values = %v", got)
+	}
+	if replayed.Host != "api.anthropic.com" {
+		t.Errorf("Host = %q", replayed.Host)
+	}
+}
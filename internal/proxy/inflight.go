@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// flowDrainTimeout is how long shutdown lets in-flight flows (usually
+	// SSE streams) finish on their own before cutting them off.
+	flowDrainTimeout = 5 * time.Second
+
+	// flowAbortTimeout bounds the wait for aborted flows to persist their
+	// collected events and final state.
+	flowAbortTimeout = 5 * time.Second
+
+	flowDrainPoll = 20 * time.Millisecond
+)
+
+// inflightFlow is a flow whose response is still being relayed.
+type inflightFlow struct {
+	abort   func() // Stops the relay, e.g. by closing the upstream connection
+	aborted atomic.Bool
+}
+
+// Aborted reports whether shutdown cut the flow off. Such flows are recorded
+// as interrupted rather than complete.
+func (f *inflightFlow) Aborted() bool {
+	return f.aborted.Load()
+}
+
+// beginFlow registers an in-flight flow for graceful shutdown. The caller
+// must call endFlow once the flow has been persisted. If shutdown is already
+// aborting flows, the new one is aborted immediately.
+func (p *MITMProxy) beginFlow(abort func()) *inflightFlow {
+	f := &inflightFlow{abort: abort}
+	p.flowsMu.Lock()
+	p.flows[f] = struct{}{}
+	aborting := p.flowsAborting
+	p.flowsMu.Unlock()
+
+	if aborting {
+		f.aborted.Store(true)
+		f.abort()
+	}
+	return f
+}
+
+// endFlow removes a flow registered with beginFlow.
+func (p *MITMProxy) endFlow(f *inflightFlow) {
+	p.flowsMu.Lock()
+	delete(p.flows, f)
+	p.flowsMu.Unlock()
+}
+
+// drainFlows waits up to p.flowGrace for in-flight flows to finish, then
+// aborts the rest and waits up to flowAbortTimeout for them to be saved as
+// interrupted. It returns once no flows remain or the waits expire, so the
+// store can be closed afterwards.
+func (p *MITMProxy) drainFlows() {
+	if p.waitFlows(p.flowGrace) {
+		return
+	}
+
+	p.flowsMu.Lock()
+	p.flowsAborting = true
+	pending := make([]*inflightFlow, 0, len(p.flows))
+	for f := range p.flows {
+		pending = append(pending, f)
+	}
+	p.flowsMu.Unlock()
+
+	p.logger.Info("interrupting in-flight flows", "count", len(pending))
+	for _, f := range pending {
+		f.aborted.Store(true)
+		f.abort()
+	}
+
+	if !p.waitFlows(flowAbortTimeout) {
+		p.flowsMu.Lock()
+		remaining := len(p.flows)
+		p.flowsMu.Unlock()
+		p.logger.Warn("in-flight flows not saved before shutdown", "count", remaining)
+	}
+}
+
+// waitFlows polls until no flows are in flight or timeout passes. It reports
+// whether all flows finished.
+func (p *MITMProxy) waitFlows(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		p.flowsMu.Lock()
+		n := len(p.flows)
+		p.flowsMu.Unlock()
+		if n == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(flowDrainPoll)
+	}
+}
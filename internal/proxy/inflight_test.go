@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/redact"
+	"github.com/HakAl/langley/internal/store"
+	langleytls "github.com/HakAl/langley/internal/tls"
+)
+
+// TestMITMProxy_ShutdownInterruptsStreamingFlow cancels the proxy context
+// while an intercepted SSE stream is still open and checks that the flow is
+// saved as interrupted along with the events relayed so far.
+func TestMITMProxy_ShutdownInterruptsStreamingFlow(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"model\":\"claude-sonnet-4-20250514\",\"usage\":{\"input_tokens\":10}}}\n\n")
+		fmt.Fprint(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hel\"}}\n\n")
+		w.(http.Flusher).Flush()
+		select { // Never finishes on its own
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+	defer close(release)
+	target := strings.TrimPrefix(upstream.URL, "https://")
+
+	cfg := testConfig()
+	cfg.Proxy.InterceptHosts = []string{"127.0.0.1"}
+	dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer dataStore.Close()
+
+	ca, _ := langleytls.LoadOrCreateCA(t.TempDir())
+	redactor, _ := redact.New(&cfg.Redaction)
+	capture := &flowCapture{}
+	p, err := NewMITMProxy(MITMProxyConfig{
+		Config:                     cfg,
+		Logger:                     testLogger(),
+		CA:                         ca,
+		CertCache:                  langleytls.NewCertCache(ca, 100),
+		Redactor:                   redactor,
+		Store:                      dataStore,
+		OnFlow:                     capture.OnFlow,
+		OnEvent:                    capture.OnEvent,
+		InsecureSkipVerifyUpstream: true,
+	})
+	if err != nil {
+		t.Fatalf("NewMITMProxy: %v", err)
+	}
+	p.flowGrace = 100 * time.Millisecond
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- p.ServeListener(ctx, ln) }()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.CertPEM())
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyURL(mustParseURL(t, "http://"+ln.Addr().String())),
+			TLSClientConfig: &tls.Config{RootCAs: roots},
+		},
+	}
+	resp, err := client.Post("https://"+target+"/v1/messages", "application/json", strings.NewReader(`{"stream":true}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Wait until both events have been relayed, then shut down mid-stream
+	br := bufio.NewReader(resp.Body)
+	for seen := 0; seen < 2; {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading stream: %v", err)
+		}
+		if strings.HasPrefix(line, "data:") {
+			seen++
+		}
+	}
+	cancel()
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("ServeListener: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("ServeListener did not return after shutdown")
+	}
+
+	started := capture.Flow()
+	if started == nil {
+		t.Fatal("flow not captured")
+	}
+	bg := context.Background()
+	flow, err := dataStore.GetFlow(bg, started.ID)
+	if err != nil {
+		t.Fatalf("GetFlow: %v", err)
+	}
+	if flow.FlowIntegrity != "interrupted" {
+		t.Errorf("FlowIntegrity = %q, want interrupted", flow.FlowIntegrity)
+	}
+	events, err := dataStore.GetEventsByFlow(bg, flow.ID)
+	if err != nil {
+		t.Fatalf("GetEventsByFlow: %v", err)
+	}
+	if len(events) != 2 {
+		t.Errorf("saved %d events, want 2", len(events))
+	}
+}
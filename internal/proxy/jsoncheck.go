@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+// defaultValidateJSONMaxBytes bounds request bodies checked by
+// proxy.validate_request_json when no cap is configured.
+const defaultValidateJSONMaxBytes = 1 << 20
+
+// isJSONContentType reports whether a Content-Type claims a JSON body
+// (application/json or a +json type such as application/problem+json).
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// checkRequestJSON sets flow.RequestBodyInvalid when proxy.validate_request_json
+// is on and a request sent as JSON doesn't parse, so a misconfigured client
+// shows up before the upstream's 400 has to be read. Encoded bodies and bodies
+// over the size cap are skipped to keep large uploads off the hot path.
+func (p *MITMProxy) checkRequestJSON(flow *store.Flow, header http.Header, body []byte) {
+	if !p.cfg.Proxy.ValidateRequestJSON || len(body) == 0 {
+		return
+	}
+	maxBytes := p.cfg.Proxy.ValidateRequestJSONMaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultValidateJSONMaxBytes
+	}
+	if len(body) > maxBytes || !isJSONContentType(header.Get("Content-Type")) {
+		return
+	}
+	if enc := header.Get("Content-Encoding"); enc != "" && !strings.EqualFold(enc, "identity") {
+		return
+	}
+	if json.Valid(body) {
+		return
+	}
+
+	flow.RequestBodyInvalid = true
+	p.logger.Warn("request body is not valid JSON",
+		"flow_id", flow.ID, "host", flow.Host, "path", flow.Path, "size", len(body))
+}
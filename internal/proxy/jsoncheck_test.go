@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/config"
+	"github.com/HakAl/langley/internal/store"
+)
+
+func TestMITMProxy_ValidateRequestJSON(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"type":"error"}`))
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		maxBytes    int
+		want        bool
+	}{
+		{"valid JSON", "application/json", `{"model":"claude-3-5-sonnet"}`, 0, false},
+		{"malformed JSON", "application/json; charset=utf-8", `{"model":"claude-3-5-sonnet",}`, 0, true},
+		{"malformed +json", "application/vnd.api+json", `{"model":`, 0, true},
+		{"not JSON", "text/plain", `{"model":`, 0, false},
+		{"over size cap", "application/json", `{"model":`, 4, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, proxyAddr, capture, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+				cfg.Proxy.ValidateRequestJSON = true
+				cfg.Proxy.ValidateRequestJSONMaxBytes = tt.maxBytes
+			})
+			defer cleanup()
+
+			client := &http.Client{
+				Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, "http://"+proxyAddr))},
+				Timeout:   5 * time.Second,
+			}
+			req, _ := http.NewRequest("POST", upstream.URL+"/v1/messages", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			flow := capture.WaitForFlow(2 * time.Second)
+			if flow == nil {
+				t.Fatal("flow was not captured")
+			}
+			if flow.RequestBodyInvalid != tt.want {
+				t.Errorf("RequestBodyInvalid = %v, want %v", flow.RequestBodyInvalid, tt.want)
+			}
+		})
+	}
+}
+
+func TestMITMProxy_ValidateRequestJSON_Disabled(t *testing.T) {
+	p := &MITMProxy{cfg: testConfig(), logger: testLogger()}
+	flow := &store.Flow{ID: "flow-1"}
+	header := http.Header{"Content-Type": {"application/json"}}
+	p.checkRequestJSON(flow, header, []byte(`{"model":`))
+	if flow.RequestBodyInvalid {
+		t.Error("RequestBodyInvalid set with proxy.validate_request_json off")
+	}
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+
+	"github.com/HakAl/langley/internal/redact"
+)
+
+// logRedactor prepares request details for the proxy's own log output.
+// Storage is redacted separately; this keeps -debug logs from spilling the
+// secrets that storage redaction hides. A nil logRedactor logs verbatim.
+type logRedactor struct {
+	redactor atomic.Pointer[redact.Redactor]
+}
+
+// newLogRedactor returns a logRedactor, or nil when log redaction is off.
+func newLogRedactor(enabled bool, redactor *redact.Redactor) *logRedactor {
+	if !enabled || redactor == nil {
+		return nil
+	}
+	l := &logRedactor{}
+	l.redactor.Store(redactor)
+	return l
+}
+
+// setRedactor swaps in a reloaded redactor.
+func (l *logRedactor) setRedactor(redactor *redact.Redactor) {
+	if l != nil && redactor != nil {
+		l.redactor.Store(redactor)
+	}
+}
+
+// URL returns u for logging.
+func (l *logRedactor) URL(u *url.URL) string {
+	if l == nil {
+		return u.String()
+	}
+	return l.redactor.Load().RedactURL(u)
+}
+
+// Headers returns h for logging.
+func (l *logRedactor) Headers(h http.Header) map[string][]string {
+	if l == nil {
+		return redact.HeadersToMap(h)
+	}
+	return redact.HeadersToMap(l.redactor.Load().RedactHeaders(h))
+}
+
+// Err returns err for logging. Client errors (*url.Error) embed the full
+// request URL, query string included, so that part is redacted.
+func (l *logRedactor) Err(err error) error {
+	var ue *url.Error
+	if l == nil || !errors.As(err, &ue) {
+		return err
+	}
+	return &url.Error{Op: ue.Op, URL: l.redactor.Load().RedactURLString(ue.URL), Err: ue.Err}
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/config"
+	"github.com/HakAl/langley/internal/store/storetest"
+)
+
+func TestMITMProxy_MaxEventsPerFlow(t *testing.T) {
+	t.Parallel()
+
+	const deltas = 20
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		send := func(eventType, data string) {
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, data)
+		}
+		send("message_start", `{"type":"message_start","message":{"model":"claude-sonnet-4-20250514","usage":{"input_tokens":10}}}`)
+		for i := 0; i < deltas; i++ {
+			send("content_block_delta", fmt.Sprintf(`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"t%d"}}`, i))
+		}
+		send("message_stop", `{"type":"message_stop"}`)
+	}))
+	defer upstream.Close()
+
+	p, proxyAddr, capture, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+		cfg.Parser.MaxEventsPerFlow = 5
+	})
+	defer cleanup()
+
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, "http://"+proxyAddr))},
+		Timeout:   5 * time.Second,
+	}
+	resp, err := client.Post(upstream.URL+"/v1/messages", "application/json", strings.NewReader(`{"stream":true}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// The client gets the whole stream
+	if got := strings.Count(string(body), "event: content_block_delta"); got != deltas {
+		t.Errorf("client got %d deltas, want %d", got, deltas)
+	}
+	if !strings.Contains(string(body), "event: message_stop") {
+		t.Error("client stream is missing message_stop")
+	}
+
+	flow := capture.WaitForUpdate(2 * time.Second)
+	if flow == nil {
+		t.Fatal("flow was not completed")
+	}
+	if want := deltas + 2 - 5; flow.EventsDroppedCount != want {
+		t.Errorf("EventsDroppedCount = %d, want %d", flow.EventsDroppedCount, want)
+	}
+	if got := len(capture.Events()); got != deltas+2 {
+		t.Errorf("broadcast %d events, want %d", got, deltas+2)
+	}
+
+	ms := p.store.(*storetest.Store)
+	events, err := ms.GetEventsByFlow(context.Background(), flow.ID)
+	if err != nil {
+		t.Fatalf("GetEventsByFlow: %v", err)
+	}
+	if len(events) != 5 {
+		t.Errorf("stored %d events, want 5", len(events))
+	}
+	drops := ms.DropLog()
+	if len(drops) != 1 || drops[0].Reason != "max_events" || drops[0].FlowID == nil || *drops[0].FlowID != flow.ID {
+		t.Errorf("drop log = %+v, want one max_events entry for the flow", drops)
+	}
+}
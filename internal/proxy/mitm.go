@@ -5,7 +5,9 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -13,18 +15,21 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"encoding/json"
 
 	"github.com/HakAl/langley/internal/analytics"
 	"github.com/HakAl/langley/internal/config"
+	"github.com/HakAl/langley/internal/eventstream"
 	"github.com/HakAl/langley/internal/parser"
 	"github.com/HakAl/langley/internal/pricing"
 	"github.com/HakAl/langley/internal/provider"
 	"github.com/HakAl/langley/internal/redact"
 	"github.com/HakAl/langley/internal/store"
 	"github.com/HakAl/langley/internal/task"
+	"github.com/HakAl/langley/internal/telemetry"
 	langleytls "github.com/HakAl/langley/internal/tls"
 	"github.com/google/uuid"
 )
@@ -35,11 +40,19 @@ type MITMProxy struct {
 	logger       *slog.Logger
 	ca           *langleytls.CA
 	certCache    *langleytls.CertCache
-	redactor     *redact.Redactor
+	redactor     atomic.Pointer[redact.Redactor] // Swapped by SetRedactor on config reload
 	store        store.Store
 	analytics    *analytics.Engine
 	taskAssigner *task.Assigner
 	providers    *provider.Registry
+	memGuard     *memoryGuard
+	logRedact    *logRedactor
+	headerFilter *headerFilter
+	deltaFilter  *deltaFilter
+	capture      *CaptureMonitor
+	tracer       *telemetry.Tracer
+	upstream     *UpstreamLimiter
+	models       *ModelLimiter
 	server *http.Server
 	client *http.Client
 
@@ -53,8 +66,32 @@ type MITMProxy struct {
 	tunnelConns map[net.Conn]struct{}
 	tunnelWg    sync.WaitGroup
 
+	// Graceful shutdown: in-flight flows are given flowGrace to finish,
+	// then aborted and saved as interrupted
+	flowsMu       sync.Mutex
+	flows         map[*inflightFlow]struct{}
+	flowsAborting bool
+	flowGrace     time.Duration
+
+	// throughput tracks forwarding latency for Throughput
+	throughput throughputTracker
+
+	// upstreamRoots verifies upstream certificates; nil means system roots
+	upstreamRoots *x509.CertPool
+
+	// overrides redirects upstream dials for configured hosts
+	overrides upstreamOverrides
+	// clients are the addresses allowed to use the proxy (proxy.allowed_cidrs)
+	clients clientAllowlist
+
+	// upstreamPool reuses upstream TLS connections across intercepted tunnels
+	upstreamPool *upstreamPool
+
 	// insecureSkipVerifyUpstream is for testing only
 	insecureSkipVerifyUpstream bool
+
+	// hostRewrite applies MITMProxyConfig.HostRewrite; never nil
+	hostRewrite func(host string) string
 }
 
 // MITMProxyConfig holds configuration for creating a MITM proxy.
@@ -71,9 +108,31 @@ type MITMProxyConfig struct {
 	OnUpdate      func(*store.Flow)
 	OnEvent       func(*store.Event) // Called for each SSE event parsed
 
+	// CaptureMonitor tracks SaveFlow/UpdateFlow failures. One is created
+	// if nil; pass it in to read its health elsewhere.
+	CaptureMonitor *CaptureMonitor
+
+	// Tracer records a span per flow. Nil disables tracing.
+	Tracer *telemetry.Tracer
+
+	// UpstreamLimiter caps concurrent upstream requests. One is created
+	// from Config.Proxy if nil; pass it in to read its stats elsewhere.
+	UpstreamLimiter *UpstreamLimiter
+
+	// ModelLimiter applies proxy.rate_limits. One is created from
+	// Config.Proxy if nil; pass it in to read its stats elsewhere.
+	ModelLimiter *ModelLimiter
+
 	// InsecureSkipVerifyUpstream skips TLS verification for upstream connections.
 	// This should ONLY be used for testing. Do not enable in production.
 	InsecureSkipVerifyUpstream bool
+
+	// HostRewrite maps an upstream host:port to the one to connect to
+	// instead, e.g. so tests can route a provider hostname to a local mock.
+	// Unlike proxy.upstream_overrides, intercepted TLS connections use the
+	// rewritten host for SNI and verification; the Host header and the
+	// captured flow keep the original. Nil leaves hosts unchanged.
+	HostRewrite func(host string) string
 }
 
 // NewMITMProxy creates a new MITM proxy.
@@ -93,15 +152,67 @@ func NewMITMProxy(cfg MITMProxyConfig) (*MITMProxy, error) {
 	if cfg.Logger == nil {
 		cfg.Logger = slog.Default()
 	}
+	headerFilter, err := newHeaderFilter(cfg.Config.Persistence.StoreHeaders)
+	if err != nil {
+		return nil, err
+	}
+	deltaFilter, err := newDeltaFilter(cfg.Config.Parser.StoreDeltas)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.CaptureMonitor == nil {
+		cfg.CaptureMonitor = NewCaptureMonitor()
+	}
+	if cfg.UpstreamLimiter == nil {
+		if cfg.UpstreamLimiter, err = NewUpstreamLimiter(cfg.Config.Proxy); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.ModelLimiter == nil {
+		if cfg.ModelLimiter, err = NewModelLimiter(cfg.Config.Proxy); err != nil {
+			return nil, err
+		}
+	}
+	providers := provider.NewRegistry()
+	for i, custom := range cfg.Config.Providers.Custom {
+		if err := providers.RegisterCustom(custom.Name, custom.Hosts, custom.Parser); err != nil {
+			return nil, fmt.Errorf("providers.custom[%d]: %w", i, err)
+		}
+		cfg.Logger.Info("registered custom provider", "name", custom.Name, "hosts", custom.Hosts, "parser", custom.Parser)
+	}
+
+	upstreamRoots, err := loadUpstreamRoots(cfg.Config.Proxy.UpstreamTrustCerts)
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := newUpstreamOverrides(cfg.Config.Proxy.UpstreamOverrides)
+	if err != nil {
+		return nil, err
+	}
+	for host, target := range overrides {
+		cfg.Logger.Info("upstream override", "host", host, "target", target)
+	}
+	hostRewrite := cfg.HostRewrite
+	if hostRewrite == nil {
+		hostRewrite = func(host string) string { return host }
+	}
+	clients, err := newClientAllowlist(cfg.Config.Proxy.AllowedCIDRs)
+	if err != nil {
+		return nil, err
+	}
 
 	// HTTP client for forwarding requests
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
 	transport := &http.Transport{
-		DialContext: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-		}).DialContext,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, overrides.resolve(hostRewrite(addr)))
+		},
 		TLSClientConfig: &tls.Config{
 			InsecureSkipVerify: false,              // Validate upstream (langley-vu5)
+			RootCAs:            upstreamRoots,
 			NextProtos:         []string{"http/1.1"}, // Force HTTP/1.1 (langley-a4m)
 		},
 		ForceAttemptHTTP2:     false, // Disable HTTP/2 (langley-a4m)
@@ -124,17 +235,39 @@ func NewMITMProxy(cfg MITMProxyConfig) (*MITMProxy, error) {
 		logger:                     cfg.Logger,
 		ca:                         cfg.CA,
 		certCache:                  cfg.CertCache,
-		redactor:                   cfg.Redactor,
 		store:                      cfg.Store,
 		taskAssigner:               cfg.TaskAssigner,
-		providers:                  provider.NewRegistry(),
+		providers:                  providers,
+		memGuard:                   newMemoryGuard(cfg.Config.Memory.PressureThresholdMB, cfg.Logger),
+		headerFilter:               headerFilter,
+		deltaFilter:                deltaFilter,
+		capture:                    cfg.CaptureMonitor,
+		tracer:                     cfg.Tracer,
+		upstream:                   cfg.UpstreamLimiter,
+		models:                     cfg.ModelLimiter,
 		client:                     client,
 		onFlow:                     cfg.OnFlow,
 		onUpdate:                   cfg.OnUpdate,
 		onEvent:                    cfg.OnEvent,
 		tunnelConns:                make(map[net.Conn]struct{}),
+		flows:                      make(map[*inflightFlow]struct{}),
+		flowGrace:                  flowDrainTimeout,
+		upstreamRoots:              upstreamRoots,
+		overrides:                  overrides,
+		clients:                    clients,
 		insecureSkipVerifyUpstream: cfg.InsecureSkipVerifyUpstream,
+		hostRewrite:                hostRewrite,
+	}
+
+	// Pooled connections don't outlast an idle tunnel unless configured to
+	upstreamIdle := cfg.Config.Proxy.UpstreamIdleTimeout
+	if upstreamIdle <= 0 {
+		upstreamIdle = min(p.idleTimeout(), defaultUpstreamIdleTimeout)
 	}
+	p.upstreamPool = newUpstreamPool(p.dialUpstreamTLS, cfg.Config.Proxy.UpstreamMaxIdlePerHost, upstreamIdle)
+
+	p.redactor.Store(cfg.Redactor)
+	p.logRedact = newLogRedactor(cfg.Config.Logging.RedactLogs, cfg.Redactor)
 
 	// Initialize analytics engine if we have a database connection
 	if cfg.Store != nil {
@@ -143,6 +276,7 @@ func NewMITMProxy(cfg MITMProxyConfig) (*MITMProxy, error) {
 			if cfg.PricingSource != nil {
 				p.analytics.SetPricingSource(cfg.PricingSource)
 			}
+			p.analytics.SetPricingTiers(pricingTiers(cfg.Config.Analytics.PricingTiers))
 		}
 	}
 
@@ -157,6 +291,31 @@ func NewMITMProxy(cfg MITMProxyConfig) (*MITMProxy, error) {
 	return p, nil
 }
 
+// pricingTiers converts configured volume tiers to analytics tiers.
+func pricingTiers(cfgTiers []config.PricingTierConfig) []analytics.PricingTier {
+	tiers := make([]analytics.PricingTier, 0, len(cfgTiers))
+	for _, t := range cfgTiers {
+		tiers = append(tiers, analytics.PricingTier{
+			Provider:         t.Provider,
+			Model:            t.Model,
+			MinMonthlyTokens: t.MinMonthlyTokens,
+			InputCostPer1k:   t.InputCostPer1k,
+			OutputCostPer1k:  t.OutputCostPer1k,
+		})
+	}
+	return tiers
+}
+
+// SetRedactor replaces the redactor used for flows and logs, e.g. after a
+// config reload changes the redaction patterns. Flows already in progress
+// finish with the redactor they started with. A nil redactor is ignored.
+func (p *MITMProxy) SetRedactor(r *redact.Redactor) {
+	if r != nil {
+		p.redactor.Store(r)
+		p.logRedact.setRedactor(r)
+	}
+}
+
 // Serve starts the proxy server by creating its own listener.
 func (p *MITMProxy) Serve(ctx context.Context) error {
 	ln, err := net.Listen("tcp", p.server.Addr)
@@ -187,12 +346,23 @@ func (p *MITMProxy) ServeListener(ctx context.Context, ln net.Listener) error {
 	p.closeTunnels()
 	p.tunnelWg.Wait()
 
+	// Let in-flight streams finish and persist before the store closes
+	p.drainFlows()
+	p.upstreamPool.close()
+
 	return nil
 }
 
 // ServeHTTP handles incoming HTTP requests.
 func (p *MITMProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	p.logger.Debug("incoming request", "method", r.Method, "host", r.Host, "url", r.URL.String())
+	p.logger.Debug("incoming request", "method", r.Method, "host", r.Host, "url", p.logRedact.URL(r.URL))
+	if !p.clients.allows(r.RemoteAddr) {
+		// Refuse before any tunnel is hijacked, and drop the connection
+		p.logger.Warn("rejected client outside proxy.allowed_cidrs", "remote_addr", r.RemoteAddr, "method", r.Method, "host", r.Host)
+		w.Header().Set("Connection", "close")
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 	if r.Method == http.MethodConnect {
 		p.handleConnect(w, r)
 		return
@@ -200,21 +370,35 @@ func (p *MITMProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	p.handleHTTP(w, r)
 }
 
-// handleHTTP handles regular HTTP requests.
+// handleHTTP handles regular HTTP requests. Like CONNECT, only provider
+// hosts and intercept_hosts are captured; anything else is forwarded as is.
 func (p *MITMProxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
+	if !p.shouldIntercept(r.Host) {
+		p.handleHTTPPassthrough(w, r)
+		return
+	}
+
 	startTime := time.Now()
 	flowID := uuid.New().String()
 
-	p.logger.Debug("HTTP request", "flow_id", flowID, "method", r.Method, "url", r.URL.String())
+	p.logger.Debug("HTTP request", "flow_id", flowID, "method", r.Method, "url", p.logRedact.URL(r.URL))
 
-	// Read full request body for forwarding and parsing.
-	// Only the stored copy in flow.RequestBody is truncated to BodyMaxBytes.
-	var reqBody []byte
-	var reqBodyTruncated bool
-	if r.Body != nil {
-		reqBody, _ = io.ReadAll(r.Body)
-		r.Body.Close()
-		reqBodyTruncated = len(reqBody) > p.cfg.Persistence.BodyMaxBytes
+	span := p.startSpan(r)
+
+	// Under memory pressure, keep forwarding but capture metadata only
+	metadataOnly := p.memGuard.MetadataOnly()
+
+	// One redactor for the whole flow, even if a reload swaps it midway
+	redactor := p.redactor.Load()
+	bodyMax := p.cfg.Persistence.StoredBodyLimit()
+
+	// Read the request body for forwarding and parsing; large bodies keep a
+	// prefix and stream the rest. Only the stored copy in flow.RequestBody
+	// is truncated to BodyMaxBytes.
+	body := p.readRequestBody(r)
+	reqBody := body.prefix
+	reqBodyTruncated := body.streaming || len(reqBody) > bodyMax
+	if !body.streaming {
 		r.Body = io.NopCloser(bytes.NewReader(reqBody))
 	}
 
@@ -231,66 +415,116 @@ func (p *MITMProxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
 		Provider:             "other",
 		RequestBodyTruncated: reqBodyTruncated,
 	}
+	defer endSpan(span, flow)
+	p.checkRequestJSON(flow, r.Header, body.whole())
+	p.applyManualCost(flow, r)
 
 	// Assign task
 	if p.taskAssigner != nil {
-		assignment := p.taskAssigner.Assign(r.Host, r.Header, reqBody)
+		assignment := p.taskAssigner.AssignRequest(r.Host, r, reqBody)
 		flow.TaskID = &assignment.TaskID
 		flow.TaskSource = &assignment.Source
+		flow.URL = r.URL.String() // Task markers are stripped before forwarding
 	}
 
 	// Redact and store request (body truncated to BodyMaxBytes for storage only)
 	storedBody := reqBody
-	if len(storedBody) > p.cfg.Persistence.BodyMaxBytes {
-		storedBody = storedBody[:p.cfg.Persistence.BodyMaxBytes]
+	if len(storedBody) > bodyMax {
+		storedBody = storedBody[:bodyMax]
+	}
+	if metadataOnly {
+		storedBody = nil
 	}
-	if p.redactor != nil {
-		flow.RequestHeaders = redact.HeadersToMap(p.redactor.RedactHeaders(r.Header))
-		if p.redactor.ShouldStoreBody() && len(storedBody) > 0 {
-			redacted := p.redactor.RedactBody(string(storedBody))
+	if redactor != nil {
+		flow.RequestHeaders = redact.HeadersToMap(redactor.RedactHeaders(p.headerFilter.Headers(r.Header)))
+		if redactor.ShouldStoreBody() && len(storedBody) > 0 {
+			redacted := redactor.RedactForStorage(string(storedBody))
 			flow.RequestBody = &redacted
 		}
 	} else {
-		flow.RequestHeaders = redact.HeadersToMap(r.Header)
+		flow.RequestHeaders = redact.HeadersToMap(p.headerFilter.Headers(r.Header))
 		if len(storedBody) > 0 {
 			s := string(storedBody)
 			flow.RequestBody = &s
 		}
 	}
 
+	p.captureCacheBreakpoints(flow, reqBody)
+
+	// A streamed body's signature would cover only its prefix
+	if !body.streaming {
+		signature := requestSignature(flow.Method, flow.Host, flow.Path, reqBody)
+		flow.RequestSignature = &signature
+	}
+
 	// Save flow immediately so SSE events can reference it (langley-2fa)
 	if p.store != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		if err := p.store.SaveFlow(ctx, flow); err != nil {
+		err := p.store.SaveFlow(ctx, flow)
+		if err != nil {
 			p.logger.Error("failed to save initial flow", "flow_id", flow.ID, "error", err)
 		}
+		p.capture.Record(err)
 		cancel()
 	}
 
 	// Correlate tool_results in request body with prior tool invocations (langley-io4)
-	p.correlateToolResults(reqBody)
+	p.correlateToolResults(body.whole())
 
 	// Notify flow started
 	if p.onFlow != nil {
 		p.onFlow(flow)
 	}
 
+	// Abort on shutdown cancels the upstream request mid-stream
+	reqCtx, abort := context.WithCancel(r.Context())
+	defer abort()
+	inflight := p.beginFlow(abort)
+	defer p.endFlow(inflight)
+
 	// Forward request
-	outReq, err := http.NewRequestWithContext(r.Context(), r.Method, r.URL.String(), bytes.NewReader(reqBody))
+	outReq, err := http.NewRequestWithContext(reqCtx, r.Method, r.URL.String(), body.reader)
 	if err != nil {
 		p.logger.Error("failed to create request", "error", err)
+		body.closeUnlessDrained(w)
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
+	outReq.ContentLength = body.length
 	copyHeaders(outReq.Header, r.Header)
 	removeHopByHopHeaders(outReq.Header)
 	// Strip Accept-Encoding so upstream sends uncompressed responses.
 	// The proxy needs plaintext to store readable bodies and parse usage/SSE.
 	outReq.Header.Del("Accept-Encoding")
+	propagateSpan(span, outReq)
+
+	if err := p.throttle(reqCtx, flow.ID, reqBody); err != nil {
+		var limited *rateLimitedError
+		if errors.As(err, &limited) {
+			body.closeUnlessDrained(w)
+			w.Header().Set("Retry-After", limited.retryAfterHeader())
+			http.Error(w, limited.Error(), http.StatusTooManyRequests)
+		}
+		p.saveUnforwardedFlow(flow, err)
+		return
+	}
+
+	release, err := p.upstream.Acquire(reqCtx)
+	if err != nil {
+		if errors.Is(err, errUpstreamBusy) {
+			body.closeUnlessDrained(w)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many concurrent upstream requests", http.StatusServiceUnavailable)
+		}
+		p.saveUnforwardedFlow(flow, err)
+		return
+	}
+	defer release()
 
 	resp, err := p.client.Do(outReq)
 	if err != nil {
-		p.logger.Error("failed to forward request", "error", err)
+		p.logger.Error("failed to forward request", "error", p.logRedact.Err(err))
+		body.closeUnlessDrained(w)
 		http.Error(w, "Bad gateway", http.StatusBadGateway)
 		flow.FlowIntegrity = "interrupted"
 		p.saveFlow(flow)
@@ -298,6 +532,8 @@ func (p *MITMProxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	defer resp.Body.Close()
 
+	p.recordForward(startTime)
+
 	// Update flow with response info
 	duration := time.Since(startTime).Milliseconds()
 	flow.DurationMs = &duration
@@ -305,9 +541,10 @@ func (p *MITMProxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
 	statusText := resp.Status
 	flow.StatusText = &statusText
 
-	// Check if SSE
+	// Check if SSE (or Bedrock's binary event stream, parsed the same way)
 	contentType := resp.Header.Get("Content-Type")
-	flow.IsSSE = strings.Contains(contentType, "text/event-stream")
+	flow.IsSSE = strings.Contains(contentType, "text/event-stream") || eventstream.IsContentType(contentType)
+	p.checkStreamMismatch(flow, body.whole())
 
 	// Copy response headers
 	copyHeaders(w.Header(), resp.Header)
@@ -316,47 +553,69 @@ func (p *MITMProxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Stream response body while capturing
 	var respBody bytes.Buffer
-	maxBody := p.cfg.Persistence.BodyMaxBytes
-	limitedWriter := &limitedBuffer{buf: &respBody, max: maxBody}
+	limitedWriter := &limitedBuffer{buf: &respBody, max: bodyMax}
+	var usageBody []byte // Parsed stream events, when the capture is truncated
 
 	// Use SSE parser for event-stream responses
 	if flow.IsSSE {
 		// For SSE, wrap ResponseWriter with flusher to ensure immediate delivery
 		flushWriter := newFlushWriter(w)
-		if err := p.streamSSEWithParser(flowID, flow.TaskID, resp.Body, flushWriter, limitedWriter); err != nil {
+		skipped, dropped, streamUsage, err := p.streamSSEWithParser(flowID, flow.TaskID, contentType, resp.Body, flushWriter, limitedWriter, !metadataOnly)
+		if err != nil {
 			p.logger.Debug("error streaming SSE response", "error", err)
 		}
+		flow.EventsSkippedCount = skipped
+		flow.EventsDroppedCount += dropped
+		usageBody = streamUsage
 	} else {
-		multiWriter := io.MultiWriter(w, limitedWriter)
+		// Ollama streams newline-delimited JSON; flush it through as it arrives
+		var client io.Writer = w
+		if strings.Contains(contentType, "application/x-ndjson") {
+			client = newFlushWriter(w)
+		}
+		multiWriter := io.MultiWriter(client, limitedWriter)
 		if _, err := io.Copy(multiWriter, resp.Body); err != nil {
 			p.logger.Debug("error copying response", "error", err)
 		}
 	}
 
+	if inflight.Aborted() {
+		flow.FlowIntegrity = "interrupted"
+	}
+
+	p.captureRateLimits(flow, resp.Header)
+
 	// Finalize flow
-	if p.redactor != nil {
-		flow.ResponseHeaders = redact.HeadersToMap(p.redactor.RedactHeaders(resp.Header))
-		if p.redactor.ShouldStoreBody() && respBody.Len() > 0 {
-			redacted := p.redactor.RedactBody(respBody.String())
+	if redactor != nil {
+		flow.ResponseHeaders = redact.HeadersToMap(redactor.RedactHeaders(p.headerFilter.Headers(resp.Header)))
+		if !metadataOnly && redactor.ShouldStoreBody() && respBody.Len() > 0 {
+			redacted := redactor.RedactForStorage(respBody.String())
 			flow.ResponseBody = &redacted
 		}
 	} else {
-		flow.ResponseHeaders = redact.HeadersToMap(resp.Header)
-		if respBody.Len() > 0 {
+		flow.ResponseHeaders = redact.HeadersToMap(p.headerFilter.Headers(resp.Header))
+		if !metadataOnly && respBody.Len() > 0 {
 			s := respBody.String()
 			flow.ResponseBody = &s
 		}
 	}
 	flow.ResponseBodyTruncated = limitedWriter.truncated
+	reqSize := body.size()
+	flow.RequestBodySize = &reqSize
+	flow.ResponseBodySize = &limitedWriter.total
 
 	// Detect provider and extract usage from captured body
 	if prov := p.providers.Detect(r.Host); prov != nil {
 		flow.Provider = prov.Name()
-		if respBody.Len() > 0 {
-			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-			p.extractUsageAndCost(ctx, flow, prov, respBody.Bytes())
-			cancel()
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		if usageBody != nil {
+			p.extractUsageAndCost(ctx, flow, prov, usageBody, body.whole())
+		} else if respBody.Len() > 0 {
+			p.extractUsageAndCost(ctx, flow, prov, respBody.Bytes(), body.whole())
 		}
+		setRequestModel(flow, body.whole())
+		p.estimateMissingUsage(ctx, flow, body.whole())
+		cancel()
 	}
 
 	// Extract tool invocations from non-streaming JSON responses (langley-ahgo)
@@ -374,6 +633,37 @@ func (p *MITMProxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleHTTPPassthrough forwards a plain HTTP request to a host that isn't
+// intercepted without capturing, parsing or altering it beyond removing
+// hop-by-hop headers.
+func (p *MITMProxy) handleHTTPPassthrough(w http.ResponseWriter, r *http.Request) {
+	p.logger.Debug("HTTP passthrough", "host", r.Host, "url", p.logRedact.URL(r.URL))
+
+	outReq, err := http.NewRequestWithContext(r.Context(), r.Method, r.URL.String(), r.Body)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	outReq.ContentLength = r.ContentLength
+	copyHeaders(outReq.Header, r.Header)
+	removeHopByHopHeaders(outReq.Header)
+
+	resp, err := p.client.Do(outReq)
+	if err != nil {
+		p.logger.Error("passthrough: failed to forward request", "host", r.Host, "error", p.logRedact.Err(err))
+		http.Error(w, "Bad gateway", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	copyHeaders(w.Header(), resp.Header)
+	removeHopByHopHeaders(w.Header())
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(newFlushWriter(w), resp.Body); err != nil {
+		p.logger.Debug("passthrough: error copying response", "error", err)
+	}
+}
+
 // handleConnect routes HTTPS CONNECT requests: MITM for known LLM hosts,
 // transparent passthrough for everything else.
 func (p *MITMProxy) handleConnect(w http.ResponseWriter, r *http.Request) {
@@ -395,6 +685,20 @@ func (p *MITMProxy) shouldIntercept(host string) bool {
 	return matchConfigHosts(host, p.cfg.Proxy.InterceptHosts)
 }
 
+// WarmCertificates generates leaf certificates for the built-in and custom
+// provider hosts and intercept_hosts, so the first tunnel to each doesn't
+// wait on key generation. Run it in the background at startup.
+func (p *MITMProxy) WarmCertificates() error {
+	var hosts []string
+	for _, host := range append(p.providers.Hosts(), p.cfg.Proxy.InterceptHosts...) {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		hosts = append(hosts, strings.ToLower(host))
+	}
+	return p.certCache.Warm(hosts)
+}
+
 // matchConfigHosts checks whether host matches any entry in the user-configured
 // intercept_hosts list using domain-suffix matching.
 func matchConfigHosts(host string, interceptHosts []string) bool {
@@ -432,16 +736,9 @@ func (p *MITMProxy) closeTunnels() {
 // handleConnectPassthrough tunnels the connection transparently without MITM.
 // The client sees the upstream server's real TLS certificate.
 func (p *MITMProxy) handleConnectPassthrough(w http.ResponseWriter, r *http.Request) {
-	// Parse host for upstream connection
-	host := r.Host
-	if !strings.Contains(host, ":") {
-		host = host + ":443"
-	}
-
 	// Dial upstream BEFORE sending 200 OK — so we can report errors properly
-	upstreamConn, err := net.DialTimeout("tcp", host, 10*time.Second)
+	upstreamConn, err := p.dialPassthrough(r.Host)
 	if err != nil {
-		p.logger.Error("passthrough: failed to connect to upstream", "host", host, "error", err)
 		http.Error(w, "Bad gateway", http.StatusBadGateway)
 		return
 	}
@@ -471,15 +768,93 @@ func (p *MITMProxy) handleConnectPassthrough(w http.ResponseWriter, r *http.Requ
 	}
 
 	// Hand off to bidirectional tunnel with idle timeout (langley-ga3l)
+	p.trackTunnel(clientConn, upstreamConn)
+	go p.runPassthrough(clientConn, upstreamConn, r.Host, time.Now(), 0)
+}
+
+// dialPassthrough connects to the upstream of a passthrough tunnel to host,
+// a CONNECT host with or without a port.
+func (p *MITMProxy) dialPassthrough(host string) (net.Conn, error) {
+	host = upstreamHostPort(host)
+	conn, err := net.DialTimeout("tcp", p.overrides.resolve(p.hostRewrite(host)), 10*time.Second)
+	if err != nil {
+		p.logger.Error("passthrough: failed to connect to upstream", "host", host, "error", err)
+	}
+	return conn, err
+}
+
+// trackTunnel registers a passthrough tunnel's connections, so shutdown
+// closes them and waits for runPassthrough to finish.
+func (p *MITMProxy) trackTunnel(clientConn, upstreamConn net.Conn) {
 	p.trackConn(clientConn)
 	p.trackConn(upstreamConn)
 	p.tunnelWg.Add(1)
-	go func() {
-		defer p.tunnelWg.Done()
-		defer p.untrackConn(clientConn)
-		defer p.untrackConn(upstreamConn)
-		tunnel(clientConn, upstreamConn, p.logger, r.Host)
-	}()
+}
+
+// runPassthrough copies between a tunnel's connections, registered with
+// trackTunnel, until either side closes or idles out, then records the
+// tunnel. sent counts bytes already forwarded upstream.
+func (p *MITMProxy) runPassthrough(clientConn, upstreamConn net.Conn, host string, started time.Time, sent int64) {
+	defer p.tunnelWg.Done()
+	defer p.untrackConn(clientConn)
+	defer p.untrackConn(upstreamConn)
+	up, down := tunnelWithTimeout(clientConn, upstreamConn, p.logger, host, p.idleTimeout())
+	p.recordTunnel(host, store.TunnelModePassthrough, started, sent+up, down)
+}
+
+// captureRateLimits copies normalized rate-limit headers onto the flow.
+// Headers are read before redaction so quota values are never masked.
+func (p *MITMProxy) captureRateLimits(flow *store.Flow, h http.Header) {
+	if !p.cfg.Analytics.CaptureRateLimits {
+		return
+	}
+	rl := provider.ParseRateLimits(h, time.Now())
+	if rl == nil {
+		return
+	}
+	flow.RateLimitRequestsLimit = rl.RequestsLimit
+	flow.RateLimitRequestsRemaining = rl.RequestsRemaining
+	flow.RateLimitTokensLimit = rl.TokensLimit
+	flow.RateLimitTokensRemaining = rl.TokensRemaining
+	flow.RateLimitReset = rl.Reset
+}
+
+// captureCacheBreakpoints records the prompt-cache breakpoints (cache_control
+// markers) in the request body. It reads the full body, so breakpoints past
+// BodyMaxBytes are still counted.
+func (p *MITMProxy) captureCacheBreakpoints(flow *store.Flow, reqBody []byte) {
+	if !p.cfg.Analytics.CaptureCacheBreakpoints {
+		return
+	}
+	positions := parser.ExtractCacheBreakpoints(reqBody)
+	if positions == nil {
+		return
+	}
+	count := len(positions)
+	flow.CacheBreakpoints = &count
+	flow.CacheBreakpointPositions = positions
+}
+
+// recordTunnel adds a finished CONNECT tunnel to the tunnel access log.
+func (p *MITMProxy) recordTunnel(host, mode string, started time.Time, up, down int64) {
+	if p.store == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t := &store.Tunnel{
+		Host:      host,
+		Mode:      mode,
+		StartedAt: started,
+		EndedAt:   time.Now(),
+		BytesUp:   up,
+		BytesDown: down,
+	}
+	if err := p.store.SaveTunnel(ctx, t); err != nil {
+		p.logger.Error("failed to save tunnel", "host", host, "mode", mode, "error", err)
+	}
 }
 
 // handleConnectMITM handles HTTPS CONNECT requests with TLS interception.
@@ -505,13 +880,35 @@ func (p *MITMProxy) handleConnectMITM(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// gRPC clients need HTTP/2 end to end, which interception can't give
+	// them, so look at the ClientHello before deciding to intercept
+	started := time.Now()
+	hello, peeked, err := peekClientHello(clientConn)
+	if err != nil {
+		p.logger.Debug("failed to read TLS ClientHello", "host", r.Host, "error", err)
+		clientConn.Close()
+		return
+	}
+	if isGRPCHello(hello.SupportedProtos, matchConfigHosts(r.Host, p.cfg.Proxy.GRPCHosts)) {
+		p.logger.Info("HTTP/2 (gRPC) client detected, tunneling without interception",
+			"host", r.Host, "alpn", hello.SupportedProtos)
+		p.tunnelPeeked(clientConn, peeked, r.Host, started)
+		return
+	}
+
+	// Count client-side bytes for the tunnel access log
+	counted := &countingConn{Conn: newReplayConn(clientConn, peeked)}
+	defer func() {
+		p.recordTunnel(r.Host, store.TunnelModeIntercepted, started, counted.read.Load(), counted.written.Load())
+	}()
+
 	// Start TLS handshake with client using generated cert
 	// Explicitly negotiate HTTP/1.1 to prevent HTTP/2 issues (langley-a4m)
 	tlsConfig := &tls.Config{
 		GetCertificate: p.certCache.GetCertificate,
 		NextProtos:     []string{"http/1.1"},
 	}
-	tlsConn := tls.Server(clientConn, tlsConfig)
+	tlsConn := tls.Server(counted, tlsConfig)
 	if err := tlsConn.Handshake(); err != nil {
 		p.logger.Debug("TLS handshake failed", "host", r.Host, "error", err)
 		clientConn.Close()
@@ -521,70 +918,212 @@ func (p *MITMProxy) handleConnectMITM(w http.ResponseWriter, r *http.Request) {
 	// Log negotiated protocol for debugging (langley-a4m)
 	p.logger.Debug("TLS handshake complete", "host", r.Host, "negotiated_protocol", tlsConn.ConnectionState().NegotiatedProtocol)
 
-	// Parse host for upstream connection
-	host := r.Host
-	if !strings.Contains(host, ":") {
-		host = host + ":443"
+	// Connect to upstream before reading requests, so an unreachable or
+	// untrusted upstream fails the tunnel as a whole
+	upstream, err := p.upstreamPool.get(upstreamHostPort(r.Host))
+	if err != nil {
+		p.logger.Error("failed to connect to upstream", "host", r.Host, "error", err)
+		tlsConn.Close()
+		return
 	}
 
-	// Connect to upstream - force HTTP/1.1 to match client negotiation (langley-a4m)
-	upstreamConn, err := tls.Dial("tcp", host, &tls.Config{
+	// Handle requests on this connection
+	p.handleTLSConnection(tlsConn, upstream, r.Host)
+}
+
+// dialUpstreamTLS connects to an intercepted upstream, host being
+// host:port. It forces HTTP/1.1 to match client negotiation (langley-a4m).
+// SNI and verification use the original host even when the dial is
+// overridden, but follow HostRewrite.
+func (p *MITMProxy) dialUpstreamTLS(host string) (*tls.Conn, error) {
+	host = p.hostRewrite(host)
+	serverName, _, _ := net.SplitHostPort(host)
+	return tls.Dial("tcp", p.overrides.resolve(host), &tls.Config{
+		ServerName:         serverName,
 		InsecureSkipVerify: p.insecureSkipVerifyUpstream, // Only skip for testing (langley-vu5)
+		RootCAs:            p.upstreamRoots,
 		NextProtos:         []string{"http/1.1"},
 	})
+}
+
+// upstreamHostPort adds the default HTTPS port to a CONNECT host without one.
+func upstreamHostPort(host string) string {
+	if !strings.Contains(host, ":") {
+		return host + ":443"
+	}
+	return host
+}
+
+// tunnelPeeked tunnels an already hijacked CONNECT connection to the
+// upstream without interception, replaying the bytes peeked from the client.
+func (p *MITMProxy) tunnelPeeked(clientConn net.Conn, peeked []byte, hostPort string, started time.Time) {
+	upstreamConn, err := p.dialPassthrough(hostPort)
 	if err != nil {
-		p.logger.Error("failed to connect to upstream", "host", host, "error", err)
-		tlsConn.Close()
+		clientConn.Close()
+		return
+	}
+	if _, err := upstreamConn.Write(peeked); err != nil {
+		p.logger.Error("passthrough: failed to forward ClientHello", "host", hostPort, "error", err)
+		clientConn.Close()
+		upstreamConn.Close()
 		return
 	}
 
-	// Handle requests on this connection
-	p.handleTLSConnection(tlsConn, upstreamConn, r.Host)
+	p.trackTunnel(clientConn, upstreamConn)
+	p.runPassthrough(clientConn, upstreamConn, hostPort, started, int64(len(peeked)))
 }
 
-// handleTLSConnection handles HTTP requests over an established TLS connection.
-func (p *MITMProxy) handleTLSConnection(clientConn *tls.Conn, upstreamConn *tls.Conn, host string) {
+// handleTLSConnection handles HTTP requests over an established TLS
+// connection. upstream serves the first request; later ones take a
+// connection from the pool.
+func (p *MITMProxy) handleTLSConnection(clientConn *tls.Conn, upstream *pooledConn, host string) {
 	defer clientConn.Close()
-	defer upstreamConn.Close()
+	defer func() {
+		if upstream != nil {
+			p.upstreamPool.put(upstream) // Unused: the client sent no request
+		}
+	}()
 
-	clientReader := bufio.NewReader(clientConn)
+	clientReader := bufio.NewReaderSize(clientConn, tlsReaderSize)
+	idleTimeout := p.idleTimeout()
 
 	for {
+		// Reap keep-alive connections the client abandons between requests.
+		// The deadline is lifted once a request arrives so slow bodies and
+		// long streams aren't cut off.
+		_ = clientConn.SetReadDeadline(time.Now().Add(idleTimeout))
+
+		// Capture header order before parsing discards it (http.Header is a map)
+		headerOrder := peekHeaderOrder(clientReader)
+
 		// Read request from client
 		req, err := http.ReadRequest(clientReader)
 		if err != nil {
-			if err != io.EOF {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				p.logger.Debug("closing idle TLS connection", "host", host, "idle_timeout", idleTimeout)
+			} else if err != io.EOF {
 				p.logger.Debug("error reading request from TLS connection", "host", host, "error", err)
 			}
 			return
 		}
+		_ = clientConn.SetReadDeadline(time.Time{})
 		p.logger.Debug("read request from TLS connection", "host", host, "method", req.Method, "path", req.URL.Path)
 
 		// Fix up the request URL
 		req.URL.Scheme = "https"
 		req.URL.Host = host
 
-		// Handle this request
-		p.handleTLSRequest(req, clientConn, upstreamConn, host)
+		// A WebSocket takes over the connection for the rest of its life,
+		// so its upstream connection is never pooled
+		if isWebSocketUpgrade(req.Header) {
+			if upstream == nil {
+				if upstream, err = p.upstreamPool.get(upstreamHostPort(host)); err != nil {
+					p.logger.Error("failed to connect to upstream", "host", host, "error", err)
+					p.sendError(clientConn, http.StatusBadGateway, "Bad gateway")
+					return
+				}
+			}
+			conn := upstream
+			upstream = nil
+			defer conn.Close()
+			p.handleWebSocket(req, headerOrder, clientReader, clientConn, conn.Conn, host)
+			return
+		}
+
+		// Handle this request; it owns upstream from here
+		p.handleTLSRequest(req, headerOrder, clientReader, clientConn, upstream, host)
+		upstream = nil
+	}
+}
+
+// sendUpstream writes req to upstream and reads the response head. The
+// client's header order is kept when known, since some upstreams and
+// signing schemes are order-sensitive.
+func sendUpstream(upstream *pooledConn, req *http.Request, body *requestBody, headerOrder []string) (*http.Response, error) {
+	var err error
+	switch {
+	case headerOrder != nil && body.streaming:
+		err = writeStreamingRequestInOrder(upstream, req, body.reader, body.length, headerOrder)
+	case headerOrder != nil:
+		err = writeRequestInOrder(upstream, req, body.prefix, headerOrder)
+	default:
+		err = req.Write(upstream)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("writing request: %w", err)
+	}
+	resp, err := http.ReadResponse(upstream.br, req)
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+	return resp, nil
+}
+
+// watchClientClose calls cancel if the client closes clientConn before the
+// returned stop is called. It peeks rather than reads, so a body still
+// being sent or a pipelined request stays buffered in br; stop must be
+// called before anything else reads from br.
+func watchClientClose(clientConn net.Conn, br *bufio.Reader, cancel context.CancelFunc) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := br.Peek(1); err != nil {
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				cancel()
+			}
+		}
+	}()
+	return func() {
+		// A past deadline unblocks the peek; a read timeout leaves the
+		// connection usable once the deadline is lifted
+		_ = clientConn.SetReadDeadline(time.Now())
+		<-done
+		_ = clientConn.SetReadDeadline(time.Time{})
+	}
+}
+
+// idleTimeout returns proxy.tls_idle_timeout, or the default when unset.
+func (p *MITMProxy) idleTimeout() time.Duration {
+	if p.cfg.Proxy.TLSIdleTimeout <= 0 {
+		return defaultIdleTimeout
 	}
+	return p.cfg.Proxy.TLSIdleTimeout
 }
 
 // handleTLSRequest handles a single HTTP request over TLS.
-func (p *MITMProxy) handleTLSRequest(r *http.Request, clientConn net.Conn, upstreamConn *tls.Conn, host string) {
+// headerOrder is the client's original header order, or nil if unknown.
+// clientReader is the buffered reader over clientConn that r was read from.
+// upstream is the connection to forward on, or nil to take one from the pool.
+func (p *MITMProxy) handleTLSRequest(r *http.Request, headerOrder []string, clientReader *bufio.Reader, clientConn net.Conn, upstream *pooledConn, host string) {
 	startTime := time.Now()
 	flowID := uuid.New().String()
 
 	p.logger.Debug("HTTPS request", "flow_id", flowID, "method", r.Method, "host", host, "path", r.URL.Path)
 
-	// Read full request body for forwarding and parsing.
-	// Only the stored copy in flow.RequestBody is truncated to BodyMaxBytes.
-	var reqBody []byte
-	var reqBodyTruncated bool
-	if r.Body != nil {
-		reqBody, _ = io.ReadAll(r.Body)
-		r.Body.Close()
-		reqBodyTruncated = len(reqBody) > p.cfg.Persistence.BodyMaxBytes
-	}
+	span := p.startSpan(r)
+
+	// Under memory pressure, keep forwarding but capture metadata only
+	metadataOnly := p.memGuard.MetadataOnly()
+
+	// One redactor for the whole flow, even if a reload swaps it midway
+	redactor := p.redactor.Load()
+	bodyMax := p.cfg.Persistence.StoredBodyLimit()
+
+	// Read the request body for forwarding and parsing; large bodies keep a
+	// prefix and stream the rest. Only the stored copy in flow.RequestBody
+	// is truncated to BodyMaxBytes.
+	body := p.readRequestBody(r)
+	reqBody := body.prefix
+	reqBodyTruncated := body.streaming || len(reqBody) > bodyMax
+	defer func() {
+		// A streamed body left partly unread can't be skipped to reach the
+		// next request, so the connection has to go
+		if !body.drained() {
+			clientConn.Close()
+		}
+	}()
 
 	// Create flow
 	flow := &store.Flow{
@@ -598,28 +1137,36 @@ func (p *MITMProxy) handleTLSRequest(r *http.Request, clientConn net.Conn, upstr
 		FlowIntegrity:        "complete",
 		Provider:             "other",
 		RequestBodyTruncated: reqBodyTruncated,
+		RequestHeaderOrder:   p.headerFilter.Order(headerOrder),
 	}
+	defer endSpan(span, flow)
+	p.checkRequestJSON(flow, r.Header, body.whole())
+	p.applyManualCost(flow, r)
 
 	// Assign task
 	if p.taskAssigner != nil {
-		assignment := p.taskAssigner.Assign(host, r.Header, reqBody)
+		assignment := p.taskAssigner.AssignRequest(host, r, reqBody)
 		flow.TaskID = &assignment.TaskID
 		flow.TaskSource = &assignment.Source
+		flow.URL = r.URL.String() // Task markers are stripped before forwarding
 	}
 
 	// Redact and store request (body truncated to BodyMaxBytes for storage only)
 	storedBody := reqBody
-	if len(storedBody) > p.cfg.Persistence.BodyMaxBytes {
-		storedBody = storedBody[:p.cfg.Persistence.BodyMaxBytes]
+	if len(storedBody) > bodyMax {
+		storedBody = storedBody[:bodyMax]
+	}
+	if metadataOnly {
+		storedBody = nil
 	}
-	if p.redactor != nil {
-		flow.RequestHeaders = redact.HeadersToMap(p.redactor.RedactHeaders(r.Header))
-		if p.redactor.ShouldStoreBody() && len(storedBody) > 0 {
-			redacted := p.redactor.RedactBody(string(storedBody))
+	if redactor != nil {
+		flow.RequestHeaders = redact.HeadersToMap(redactor.RedactHeaders(p.headerFilter.Headers(r.Header)))
+		if redactor.ShouldStoreBody() && len(storedBody) > 0 {
+			redacted := redactor.RedactForStorage(string(storedBody))
 			flow.RequestBody = &redacted
 		}
 	} else {
-		flow.RequestHeaders = redact.HeadersToMap(r.Header)
+		flow.RequestHeaders = redact.HeadersToMap(p.headerFilter.Headers(r.Header))
 		if len(storedBody) > 0 {
 			s := string(storedBody)
 			flow.RequestBody = &s
@@ -631,54 +1178,151 @@ func (p *MITMProxy) handleTLSRequest(r *http.Request, clientConn net.Conn, upstr
 		flow.Provider = prov.Name()
 	}
 
+	// gRPC-Web payloads are forwarded and stored, but they're protobuf, so
+	// skip everything that parses bodies as JSON
+	grpc := isGRPCContentType(r.Header.Get("Content-Type"))
+	if !grpc {
+		p.captureCacheBreakpoints(flow, reqBody)
+	}
+
+	// A streamed body's signature would cover only its prefix
+	if !body.streaming {
+		signature := requestSignature(flow.Method, flow.Host, flow.Path, reqBody)
+		flow.RequestSignature = &signature
+	}
+
 	// Save flow immediately so SSE events can reference it (langley-2fa)
 	if p.store != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		if err := p.store.SaveFlow(ctx, flow); err != nil {
+		err := p.store.SaveFlow(ctx, flow)
+		if err != nil {
 			p.logger.Error("failed to save initial flow", "flow_id", flow.ID, "error", err)
 		}
+		p.capture.Record(err)
 		cancel()
 	}
 
 	// Correlate tool_results in request body with prior tool invocations (langley-io4)
-	p.correlateToolResults(reqBody)
+	if !grpc {
+		p.correlateToolResults(body.whole())
+	}
 
 	// Notify flow started
 	if p.onFlow != nil {
 		p.onFlow(flow)
 	}
 
+	// Abort on shutdown closes both connections so a stream in progress ends,
+	// and stops waiting for an upstream slot, as does the client going away
+	queueCtx, cancelQueue := context.WithCancel(context.Background())
+	defer cancelQueue()
+	var aborting atomic.Pointer[pooledConn]
+	inflight := p.beginFlow(func() {
+		cancelQueue()
+		if upstream := aborting.Load(); upstream != nil {
+			upstream.Close()
+		}
+		clientConn.Close()
+	})
+	defer p.endFlow(inflight)
+	aborting.Store(upstream)
+
+	// The upstream connection goes back to the pool unless a request was
+	// written to it and its response not read to the end, which would leave
+	// the next request reading the rest of this one
+	idle := true
+	defer func() {
+		if upstream == nil {
+			return
+		}
+		if idle && !inflight.Aborted() {
+			p.upstreamPool.put(upstream)
+		} else {
+			upstream.Close()
+		}
+	}()
+
 	// Forward request to upstream
-	outReq, err := http.NewRequest(r.Method, r.URL.String(), bytes.NewReader(reqBody))
+	outReq, err := http.NewRequest(r.Method, r.URL.String(), body.reader)
 	if err != nil {
 		p.sendError(clientConn, http.StatusBadRequest, "Bad request")
 		return
 	}
+	outReq.ContentLength = body.length
 	copyHeaders(outReq.Header, r.Header)
 	removeHopByHopHeaders(outReq.Header)
 	// Strip Accept-Encoding so upstream sends uncompressed responses.
 	// The proxy needs plaintext to store readable bodies and parse usage/SSE.
 	outReq.Header.Del("Accept-Encoding")
+	propagateSpan(span, outReq)
 
-	// Write request to upstream
-	if err := outReq.Write(upstreamConn); err != nil {
-		p.logger.Error("failed to write to upstream", "error", err)
-		p.sendError(clientConn, http.StatusBadGateway, "Bad gateway")
-		flow.FlowIntegrity = "interrupted"
-		p.saveFlow(flow)
+	stopWatching := func() {}
+	if p.models != nil || p.upstream != nil {
+		stopWatching = watchClientClose(clientConn, clientReader, cancelQueue)
+	}
+	if err := p.throttle(queueCtx, flow.ID, reqBody); err != nil {
+		stopWatching()
+		var limited *rateLimitedError
+		if errors.As(err, &limited) {
+			p.sendRateLimited(clientConn, limited)
+		}
+		p.saveUnforwardedFlow(flow, err)
+		return
+	}
+
+	release, err := p.upstream.Acquire(queueCtx)
+	stopWatching()
+	if err != nil {
+		if errors.Is(err, errUpstreamBusy) {
+			p.sendError(clientConn, http.StatusServiceUnavailable, "Too many concurrent upstream requests")
+		}
+		p.saveUnforwardedFlow(flow, err)
 		return
 	}
+	defer release()
 
-	// Read response from upstream
-	upstreamReader := bufio.NewReader(upstreamConn)
-	resp, err := http.ReadResponse(upstreamReader, outReq)
+	if upstream == nil {
+		if upstream, err = p.upstreamPool.get(upstreamHostPort(host)); err != nil {
+			p.logger.Error("failed to connect to upstream", "host", host, "error", err)
+			p.sendError(clientConn, http.StatusBadGateway, "Bad gateway")
+			flow.FlowIntegrity = "interrupted"
+			p.saveFlow(flow)
+			return
+		}
+		aborting.Store(upstream)
+	}
+	idle = false
+
+	received := upstream.received
+	resp, err := sendUpstream(upstream, outReq, body, headerOrder)
+	// An upstream can close a pooled connection just as it is taken, so the
+	// request fails before any answer. Nothing was handled then, and a
+	// request whose body can be sent again gets one more try on a new
+	// connection.
+	if err != nil && upstream.reused && !body.streaming && upstream.received == received {
+		p.logger.Debug("pooled upstream connection failed, redialing", "host", host, "error", err)
+		upstream.Close()
+		if upstream, err = p.upstreamPool.dialNew(upstreamHostPort(host)); err == nil {
+			aborting.Store(upstream)
+			if outReq.GetBody != nil {
+				outReq.Body, _ = outReq.GetBody()
+			}
+			resp, err = sendUpstream(upstream, outReq, body, headerOrder)
+		}
+	}
 	if err != nil {
-		p.logger.Error("failed to read upstream response", "error", err)
+		p.logger.Error("upstream request failed", "host", host, "error", err)
 		p.sendError(clientConn, http.StatusBadGateway, "Bad gateway")
 		flow.FlowIntegrity = "interrupted"
 		p.saveFlow(flow)
 		return
 	}
+	// A 1xx response is followed by the real one, which isn't read here
+	reusable := !resp.Close && resp.StatusCode >= http.StatusOK
+	var respEOF bool
+	respBodyReader := &eofReader{r: resp.Body, eof: &respEOF}
+
+	p.recordForward(startTime)
 
 	// Update flow with response info
 	duration := time.Since(startTime).Milliseconds()
@@ -687,14 +1331,15 @@ func (p *MITMProxy) handleTLSRequest(r *http.Request, clientConn net.Conn, upstr
 	statusText := resp.Status
 	flow.StatusText = &statusText
 
-	// Check if SSE
+	// Check if SSE (or Bedrock's binary event stream, parsed the same way)
 	contentType := resp.Header.Get("Content-Type")
-	flow.IsSSE = strings.Contains(contentType, "text/event-stream")
+	flow.IsSSE = strings.Contains(contentType, "text/event-stream") || eventstream.IsContentType(contentType)
+	p.checkStreamMismatch(flow, body.whole())
 
 	// Capture response body
 	var respBody bytes.Buffer
-	maxBody := p.cfg.Persistence.BodyMaxBytes
-	limitedWriter := &limitedBuffer{buf: &respBody, max: maxBody}
+	limitedWriter := &limitedBuffer{buf: &respBody, max: bodyMax}
+	var usageBody []byte // Parsed stream events, when the capture is truncated
 
 	// Build response headers - remove hop-by-hop headers since Go de-chunks automatically
 	respHeaders := resp.Header.Clone()
@@ -712,7 +1357,7 @@ func (p *MITMProxy) handleTLSRequest(r *http.Request, clientConn net.Conn, upstr
 		_ = respHeaders.Write(&responseBuf)
 		responseBuf.WriteString("\r\n")
 
-		p.logger.Debug("sending SSE response headers", "flow_id", flowID, "headers", responseBuf.String())
+		p.logger.Debug("sending SSE response headers", "flow_id", flowID, "status", resp.Status, "headers", p.logRedact.Headers(respHeaders))
 
 		if _, err := clientConn.Write(responseBuf.Bytes()); err != nil {
 			p.logger.Debug("error writing SSE response headers", "error", err)
@@ -722,16 +1367,20 @@ func (p *MITMProxy) handleTLSRequest(r *http.Request, clientConn net.Conn, upstr
 
 		// Wrap client connection in chunked writer for proper HTTP/1.1 framing
 		chunkedWriter := newChunkedWriter(clientConn)
-		if err := p.streamSSEWithParser(flowID, flow.TaskID, resp.Body, chunkedWriter, limitedWriter); err != nil {
+		skipped, dropped, streamUsage, err := p.streamSSEWithParser(flowID, flow.TaskID, contentType, respBodyReader, chunkedWriter, limitedWriter, !metadataOnly)
+		if err != nil {
 			p.logger.Debug("error streaming SSE response", "error", err)
 		}
+		flow.EventsSkippedCount = skipped
+		flow.EventsDroppedCount += dropped
+		usageBody = streamUsage
 		// Write final chunk to signal end of response
 		chunkedWriter.Close()
 	} else {
 		// Non-SSE: buffer body first to set Content-Length (required after removing Transfer-Encoding)
 		var bodyBuf bytes.Buffer
 		multiWriter := io.MultiWriter(&bodyBuf, limitedWriter)
-		if _, err := io.Copy(multiWriter, resp.Body); err != nil {
+		if _, err := io.Copy(multiWriter, respBodyReader); err != nil {
 			p.logger.Debug("error reading response body", "error", err)
 		}
 
@@ -754,34 +1403,50 @@ func (p *MITMProxy) handleTLSRequest(r *http.Request, clientConn net.Conn, upstr
 		}
 	}
 	resp.Body.Close()
+	idle = reusable && respEOF
+
+	if inflight.Aborted() {
+		flow.FlowIntegrity = "interrupted"
+	}
+
+	p.captureRateLimits(flow, resp.Header)
 
 	// Finalize flow
-	if p.redactor != nil {
-		flow.ResponseHeaders = redact.HeadersToMap(p.redactor.RedactHeaders(resp.Header))
-		if p.redactor.ShouldStoreBody() && respBody.Len() > 0 {
-			redacted := p.redactor.RedactBody(respBody.String())
+	if redactor != nil {
+		flow.ResponseHeaders = redact.HeadersToMap(redactor.RedactHeaders(p.headerFilter.Headers(resp.Header)))
+		if !metadataOnly && redactor.ShouldStoreBody() && respBody.Len() > 0 {
+			redacted := redactor.RedactForStorage(respBody.String())
 			flow.ResponseBody = &redacted
 		}
 	} else {
-		flow.ResponseHeaders = redact.HeadersToMap(resp.Header)
-		if respBody.Len() > 0 {
+		flow.ResponseHeaders = redact.HeadersToMap(p.headerFilter.Headers(resp.Header))
+		if !metadataOnly && respBody.Len() > 0 {
 			s := respBody.String()
 			flow.ResponseBody = &s
 		}
 	}
 	flow.ResponseBodyTruncated = limitedWriter.truncated
+	reqSize := body.size()
+	flow.RequestBodySize = &reqSize
+	flow.ResponseBodySize = &limitedWriter.total
 
 	// Extract usage from captured body (provider was detected earlier at request time)
-	if flow.Provider != "" && respBody.Len() > 0 {
+	if !grpc && flow.Provider != "" {
 		if prov := p.providers.Get(flow.Provider); prov != nil {
 			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-			p.extractUsageAndCost(ctx, flow, prov, respBody.Bytes())
+			if usageBody != nil {
+				p.extractUsageAndCost(ctx, flow, prov, usageBody, body.whole())
+			} else if respBody.Len() > 0 {
+				p.extractUsageAndCost(ctx, flow, prov, respBody.Bytes(), body.whole())
+			}
+			setRequestModel(flow, body.whole())
+			p.estimateMissingUsage(ctx, flow, body.whole())
 			cancel()
 		}
 	}
 
 	// Extract tool invocations from non-streaming JSON responses (langley-ahgo)
-	if !flow.IsSSE && respBody.Len() > 0 {
+	if !grpc && !flow.IsSSE && respBody.Len() > 0 {
 		tools := parser.ExtractToolUsesFromJSON(respBody.Bytes())
 		p.saveToolInvocations(flowID, flow.TaskID, tools)
 	}
@@ -804,6 +1469,8 @@ func (p *MITMProxy) sendError(conn net.Conn, status int, message string) {
 
 // correlateToolResults parses request bodies for tool_result blocks and updates
 // the matching tool invocations with success/failure and duration (langley-io4).
+// Duration is the wall-clock time from the tool_use to the first request that
+// carried its result; later requests resend the history and don't change it.
 func (p *MITMProxy) correlateToolResults(reqBody []byte) {
 	if p.store == nil || len(reqBody) == 0 {
 		return
@@ -840,14 +1507,17 @@ func (p *MITMProxy) saveFlow(flow *store.Flow) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Set expiration based on retention config
-	expiresAt := time.Now().AddDate(0, 0, p.cfg.Retention.FlowsTTLDays)
+	// Set expiration based on retention config; the store applies any task
+	// retention override
+	expiresAt := time.Now().AddDate(0, 0, p.cfg.Retention.Snapshot().FlowsTTLDays)
 	flow.ExpiresAt = &expiresAt
 
 	// Use UpdateFlow since flow was already saved at request start (langley-2fa)
-	if err := p.store.UpdateFlow(ctx, flow); err != nil {
+	err := p.store.UpdateFlow(ctx, flow)
+	if err != nil {
 		p.logger.Error("failed to update flow", "flow_id", flow.ID, "error", err)
 	}
+	p.capture.Record(err)
 }
 
 // saveToolInvocations persists extracted tool uses to the store.
@@ -858,13 +1528,19 @@ func (p *MITMProxy) saveToolInvocations(flowID string, taskID *string, tools []*
 
 	for _, tool := range tools {
 		toolUseID := tool.ID
+		// Latency is measured from when the tool_use block finished streaming;
+		// non-streamed responses only have the time the body was read
+		ts := tool.Timestamp
+		if ts.IsZero() {
+			ts = time.Now()
+		}
 		inv := &store.ToolInvocation{
 			ID:        uuid.New().String(),
 			FlowID:    flowID,
 			TaskID:    taskID,
 			ToolUseID: &toolUseID,
 			ToolName:  tool.Name,
-			Timestamp: time.Now(),
+			Timestamp: ts,
 		}
 
 		// Serialize tool input to JSON for storage
@@ -886,8 +1562,11 @@ func (p *MITMProxy) saveToolInvocations(flowID string, taskID *string, tools []*
 }
 
 // extractUsageAndCost parses usage data from the captured response body and calculates cost.
-// The body parameter is the raw captured response — independent of whether it's stored on the flow.
-func (p *MITMProxy) extractUsageAndCost(ctx context.Context, flow *store.Flow, prov provider.Provider, body []byte) {
+// When the response names no model, as with most errors, the request's
+// "model" field is used instead.
+// The body parameter is the raw captured response — independent of whether it's stored on the flow —
+// or, for a stream longer than body_max_bytes, the parsed events re-encoded.
+func (p *MITMProxy) extractUsageAndCost(ctx context.Context, flow *store.Flow, prov provider.Provider, body, reqBody []byte) {
 	if len(body) == 0 {
 		return
 	}
@@ -910,9 +1589,38 @@ func (p *MITMProxy) extractUsageAndCost(ctx context.Context, flow *store.Flow, p
 		if usage.CacheReadTokens > 0 {
 			flow.CacheReadTokens = &usage.CacheReadTokens
 		}
+		if usage.StopReason != "" {
+			flow.StopReason = &usage.StopReason
+		}
+		if usage.ErrorType != "" {
+			flow.ErrorType = &usage.ErrorType
+		}
+		if usage.ErrorMessage != "" {
+			flow.ErrorMessage = &usage.ErrorMessage
+		}
 	}
+	setRequestModel(flow, reqBody)
 
-	// Calculate cost if we have token counts and analytics engine
+	p.calculateCost(ctx, flow)
+}
+
+// setRequestModel sets a flow without a model to the "model" field of its
+// JSON request body, if it has one.
+func setRequestModel(flow *store.Flow, reqBody []byte) {
+	if flow.Model != nil {
+		return
+	}
+	if model := requestModel(reqBody); model != "" {
+		flow.Model = &model
+	}
+}
+
+// calculateCost prices the flow's token counts, if it has any and there is
+// an analytics engine. A cost set by CostHeader is kept.
+func (p *MITMProxy) calculateCost(ctx context.Context, flow *store.Flow) {
+	if hasManualCost(flow) {
+		return
+	}
 	if p.analytics != nil && flow.InputTokens != nil {
 		inputTokens := 0
 		outputTokens := 0
@@ -945,21 +1653,64 @@ func (p *MITMProxy) extractUsageAndCost(ctx context.Context, flow *store.Flow, p
 	}
 }
 
+// estimateMissingUsage fills in an estimated input token count for a
+// non-streaming flow whose response reported no usage, such as an error
+// returned before generation, counting the prompt in the request body. The
+// flow is costed from the estimate and its cost source is "estimated" either
+// way, so estimated counts can be told apart from reported ones, except that
+// a local provider's flow stays "local" since it is free whatever the count.
+// The model comes from the request if the response didn't name one.
+func (p *MITMProxy) estimateMissingUsage(ctx context.Context, flow *store.Flow, reqBody []byte) {
+	if flow.IsSSE || flow.IsWebSocket || flow.InputTokens != nil || len(reqBody) == 0 {
+		return
+	}
+	tokens, ok := analytics.EstimateInputTokens(reqBody)
+	if !ok {
+		return
+	}
+	flow.InputTokens = &tokens
+	setRequestModel(flow, reqBody)
+	if hasManualCost(flow) {
+		return
+	}
+	p.calculateCost(ctx, flow)
+	if flow.CostSource != nil && *flow.CostSource == "local" {
+		return
+	}
+	estimated := "estimated"
+	flow.CostSource = &estimated
+}
+
 // streamSSEWithParser streams SSE response body while parsing events.
 // It writes to the client, captures to buffer, and emits parsed events.
 // After streaming completes, it extracts tool invocations and saves them.
-func (p *MITMProxy) streamSSEWithParser(flowID string, taskID *string, reader io.Reader, client io.Writer, capture *limitedBuffer) error {
+// When persistEvents is false (memory pressure), events are still parsed and
+// broadcast but not written to the store. AWS event stream bodies (Bedrock)
+// are decoded with the event stream parser instead of the SSE parser.
+// Content deltas are stored per parser.store_deltas; it returns how many
+// were left out, and how many events weren't stored because the flow
+// reached parser.max_events_per_flow. The parser sees the whole stream
+// however much capture keeps, so when capture was truncated it also returns
+// the parsed events re-encoded as SSE, for usage that arrives after the cut
+// (Anthropic's message_delta, OpenAI's final usage chunk) to be read from.
+func (p *MITMProxy) streamSSEWithParser(flowID string, taskID *string, contentType string, reader io.Reader, client io.Writer, capture *limitedBuffer, persistEvents bool) (skipped, dropped int, usageBody []byte, err error) {
 	// Create a pipe to tee the data
 	pr, pw := io.Pipe()
 
 	// Create event channel
 	eventsCh := make(chan *store.Event, 100)
 
-	// Start SSE parser in goroutine
-	sseParser := parser.NewSSEParserWithLogger(flowID, eventsCh, p.logger)
+	// Start stream parser in goroutine
+	var streamParser interface{ Parse(io.Reader) error }
+	binary := eventstream.IsContentType(contentType)
+	if binary {
+		streamParser = parser.NewEventStreamParser(flowID, eventsCh, p.logger)
+	} else {
+		streamParser = parser.NewSSEParserWithLogger(flowID, eventsCh, p.logger)
+	}
 	var parseErr error
 	go func() {
-		parseErr = sseParser.Parse(pr)
+		parseErr = streamParser.Parse(pr)
 		close(eventsCh)
 	}()
 
@@ -968,6 +1719,9 @@ func (p *MITMProxy) streamSSEWithParser(flowID string, taskID *string, reader io
 	// to consume events, the parser blocks on a full channel, which blocks the
 	// pipe write, which blocks the multi-writer, which blocks io.Copy. Deadlock.
 	var collectedEvents []*store.Event
+	sampler := p.deltaFilter.sampler()
+	maxEvents := p.cfg.Parser.MaxEventsPerFlow
+	stored := 0
 	var eventWg sync.WaitGroup
 	eventWg.Add(1)
 	go func() {
@@ -975,12 +1729,26 @@ func (p *MITMProxy) streamSSEWithParser(flowID string, taskID *string, reader io
 		for event := range eventsCh {
 			collectedEvents = append(collectedEvents, event)
 
-			if p.store != nil {
-				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-				if saveErr := p.store.SaveEvent(ctx, event); saveErr != nil {
-					p.logger.Error("failed to save SSE event", "flow_id", flowID, "error", saveErr)
+			if p.store != nil && persistEvents && sampler.keep(event) {
+				if maxEvents > 0 && stored >= maxEvents {
+					// One drop log entry per flow, not one per event
+					if dropped == 0 {
+						p.logMaxEventsDrop(flowID, event)
+					}
+					dropped++
+				} else {
+					stored++
+					// Events expire on their own TTL, independent of the flow
+					if ttl := p.cfg.Retention.Snapshot().EventsTTLDays; ttl > 0 {
+						expiresAt := event.Timestamp.AddDate(0, 0, ttl)
+						event.ExpiresAt = &expiresAt
+					}
+					ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+					if saveErr := p.store.SaveEvent(ctx, event); saveErr != nil {
+						p.logger.Error("failed to save SSE event", "flow_id", flowID, "error", saveErr)
+					}
+					cancel()
 				}
-				cancel()
 			}
 
 			if p.onEvent != nil {
@@ -993,7 +1761,7 @@ func (p *MITMProxy) streamSSEWithParser(flowID string, taskID *string, reader io
 	mw := io.MultiWriter(client, capture, pw)
 
 	// Copy data through the multi-writer
-	_, err := io.Copy(mw, reader)
+	_, err = io.Copy(mw, reader)
 	pw.Close() // Signal parser that we're done
 
 	// Wait for all events to be consumed
@@ -1005,20 +1773,49 @@ func (p *MITMProxy) streamSSEWithParser(flowID string, taskID *string, reader io
 		p.saveToolInvocations(flowID, taskID, tools)
 	}
 
+	// Binary event stream messages don't re-encode as SSE; their usage is
+	// parsed from the capture as before
+	if capture.truncated && !binary && len(collectedEvents) > 0 {
+		usageBody = parser.EncodeSSE(collectedEvents)
+	}
+
 	if err != nil {
-		return err
+		return sampler.skipped, dropped, usageBody, err
+	}
+	return sampler.skipped, dropped, usageBody, parseErr
+}
+
+// logMaxEventsDrop records in the drop log that a flow's stream reached
+// parser.max_events_per_flow, naming the first event that wasn't stored.
+func (p *MITMProxy) logMaxEventsDrop(flowID string, event *store.Event) {
+	p.logger.Warn("flow reached parser.max_events_per_flow, not storing further events",
+		"flow_id", flowID, "max_events", p.cfg.Parser.MaxEventsPerFlow)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	entry := &store.DropLogEntry{
+		FlowID:    &flowID,
+		EventType: &event.EventType,
+		Priority:  event.Priority,
+		Reason:    "max_events",
+		Timestamp: time.Now(),
+	}
+	if err := p.store.LogDrop(ctx, entry); err != nil {
+		p.logger.Error("failed to log dropped events", "flow_id", flowID, "error", err)
 	}
-	return parseErr
 }
 
-// limitedBuffer is a writer that stops writing after max bytes.
+// limitedBuffer is a writer that stops writing after max bytes. It always
+// reports the whole write, so it can sit in an io.MultiWriter next to the
+// client without cutting the response short.
 type limitedBuffer struct {
 	buf       *bytes.Buffer
 	max       int
 	truncated bool
+	total     int64 // Bytes written, kept or not
 }
 
 func (l *limitedBuffer) Write(p []byte) (n int, err error) {
+	l.total += int64(len(p))
 	if l.buf.Len() >= l.max {
 		l.truncated = true
 		return len(p), nil // Pretend we wrote it all
@@ -1026,7 +1823,8 @@ func (l *limitedBuffer) Write(p []byte) (n int, err error) {
 	remaining := l.max - l.buf.Len()
 	if len(p) > remaining {
 		l.truncated = true
-		return l.buf.Write(p[:remaining])
+		l.buf.Write(p[:remaining])
+		return len(p), nil
 	}
 	return l.buf.Write(p)
 }
@@ -0,0 +1,271 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/HakAl/langley/internal/analytics"
+	"github.com/HakAl/langley/internal/config"
+)
+
+// Rate limit overflow modes for proxy.rate_limit_overflow.
+const (
+	RateLimitOverflowDelay  = "delay"
+	RateLimitOverflowReject = "reject"
+)
+
+// rateLimitWindow is the sliding window requests and tokens are counted in.
+const rateLimitWindow = time.Minute
+
+// rateLimitedError is returned by Wait in reject mode when a request is
+// over its model's limit.
+type rateLimitedError struct {
+	model      string        // Pattern of the rule that was exceeded
+	retryAfter time.Duration // Until the rule has room again
+}
+
+func (e *rateLimitedError) Error() string {
+	return fmt.Sprintf("rate limit for model %s exceeded", e.model)
+}
+
+// retryAfterHeader returns the Retry-After value in whole seconds, at least 1.
+func (e *rateLimitedError) retryAfterHeader() string {
+	return strconv.Itoa(max(1, int(math.Ceil(e.retryAfter.Seconds()))))
+}
+
+// ModelLimiter paces requests per model using proxy.rate_limits. Each
+// rule counts the requests and estimated input tokens it admitted over the
+// last minute; a request that would exceed either waits until enough of
+// them age out, or is rejected, depending on the overflow mode. A nil
+// limiter admits everything.
+type ModelLimiter struct {
+	reject bool
+	window time.Duration
+
+	mu    sync.Mutex
+	rules []*modelRule
+}
+
+// modelRule is one rate limit and the admissions in its window.
+type modelRule struct {
+	limit     config.ModelRateLimit
+	admitted  []admission // Oldest first
+	tokens    int         // Sum of admitted[].tokens
+	delayed   int64
+	delayedMs int64
+	rejected  int64
+}
+
+type admission struct {
+	at     time.Time
+	tokens int
+}
+
+// NewModelLimiter creates a limiter from the proxy config. It returns nil
+// when no rate limits are configured.
+func NewModelLimiter(cfg config.ProxyConfig) (*ModelLimiter, error) {
+	var reject bool
+	switch cfg.RateLimitOverflow {
+	case "", RateLimitOverflowDelay:
+	case RateLimitOverflowReject:
+		reject = true
+	default:
+		return nil, fmt.Errorf("proxy.rate_limit_overflow must be %q or %q, got %q",
+			RateLimitOverflowDelay, RateLimitOverflowReject, cfg.RateLimitOverflow)
+	}
+	if len(cfg.RateLimits) == 0 {
+		return nil, nil
+	}
+	l := &ModelLimiter{reject: reject, window: rateLimitWindow}
+	for i, limit := range cfg.RateLimits {
+		switch {
+		case limit.Model == "":
+			return nil, fmt.Errorf("proxy.rate_limits[%d]: model is required", i)
+		case limit.RequestsPerMinute < 0 || limit.TokensPerMinute < 0:
+			return nil, fmt.Errorf("proxy.rate_limits[%d]: limits must not be negative", i)
+		case limit.RequestsPerMinute == 0 && limit.TokensPerMinute == 0:
+			return nil, fmt.Errorf("proxy.rate_limits[%d]: set requests_per_minute, tokens_per_minute or both", i)
+		}
+		l.rules = append(l.rules, &modelRule{limit: limit})
+	}
+	return l, nil
+}
+
+// Wait admits a request for model carrying an estimated tokens of input.
+// In delay mode it blocks until the first matching rule has room or ctx is
+// done; in reject mode it returns a *rateLimitedError instead. It returns
+// how long the request was held. Models matching no rule, including an
+// unknown (empty) model, are admitted straight away.
+func (l *ModelLimiter) Wait(ctx context.Context, model string, tokens int) (time.Duration, error) {
+	if l == nil || model == "" {
+		return 0, nil
+	}
+	rule := l.match(model)
+	if rule == nil {
+		return 0, nil
+	}
+
+	start := time.Now()
+	for delayed := false; ; delayed = true {
+		l.mu.Lock()
+		now := time.Now()
+		wait := rule.admit(now, tokens, l.window)
+		if wait == 0 {
+			var waited time.Duration
+			if delayed {
+				waited = now.Sub(start)
+				rule.delayed++
+				rule.delayedMs += waited.Milliseconds()
+			}
+			l.mu.Unlock()
+			return waited, nil
+		}
+		if l.reject {
+			rule.rejected++
+			l.mu.Unlock()
+			return 0, &rateLimitedError{model: rule.limit.Model, retryAfter: wait}
+		}
+		l.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return time.Since(start), ctx.Err()
+		}
+	}
+}
+
+// match returns the first rule whose pattern matches model.
+func (l *ModelLimiter) match(model string) *modelRule {
+	for _, rule := range l.rules {
+		if analytics.MatchModelPattern(rule.limit.Model, model) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// admit records the request and returns 0 if the rule has room for it at
+// now, or else how long until enough admissions age out of the window. A
+// request estimated above the whole token limit is let through once the
+// window is empty, so it isn't held forever.
+func (r *modelRule) admit(now time.Time, tokens int, window time.Duration) time.Duration {
+	r.prune(now.Add(-window))
+	fits := func(count, used int) bool {
+		if count == 0 {
+			return true
+		}
+		if rpm := r.limit.RequestsPerMinute; rpm > 0 && count >= rpm {
+			return false
+		}
+		if tpm := r.limit.TokensPerMinute; tpm > 0 && used+tokens > tpm {
+			return false
+		}
+		return true
+	}
+	if fits(len(r.admitted), r.tokens) {
+		r.admitted = append(r.admitted, admission{at: now, tokens: tokens})
+		r.tokens += tokens
+		return 0
+	}
+
+	// Find the oldest admission whose expiry makes enough room
+	used := r.tokens
+	for i, a := range r.admitted {
+		used -= a.tokens
+		if fits(len(r.admitted)-i-1, used) {
+			return a.at.Add(window).Sub(now)
+		}
+	}
+	return window // Unreachable: an empty window always fits
+}
+
+// prune drops admissions at or before cutoff.
+func (r *modelRule) prune(cutoff time.Time) {
+	for len(r.admitted) > 0 && !r.admitted[0].at.After(cutoff) {
+		r.tokens -= r.admitted[0].tokens
+		r.admitted = r.admitted[1:]
+	}
+}
+
+// Stats returns the current usage of each rule. A nil limiter returns nil.
+func (l *ModelLimiter) Stats() []analytics.ModelLimitStats {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cutoff := time.Now().Add(-l.window)
+	stats := make([]analytics.ModelLimitStats, 0, len(l.rules))
+	for _, rule := range l.rules {
+		rule.prune(cutoff)
+		stats = append(stats, analytics.ModelLimitStats{
+			Model:             rule.limit.Model,
+			RequestsPerMinute: rule.limit.RequestsPerMinute,
+			TokensPerMinute:   rule.limit.TokensPerMinute,
+			Requests:          len(rule.admitted),
+			Tokens:            rule.tokens,
+			Delayed:           rule.delayed,
+			DelayedMs:         rule.delayedMs,
+			Rejected:          rule.rejected,
+		})
+	}
+	return stats
+}
+
+// requestModel returns the "model" field of a JSON request body, or "" if
+// there isn't one (e.g. Bedrock and Gemini put the model in the path).
+func requestModel(body []byte) string {
+	var req struct {
+		Model string `json:"model"`
+	}
+	if json.Unmarshal(body, &req) != nil {
+		return ""
+	}
+	return req.Model
+}
+
+// estimateInputTokens roughly estimates the input tokens in a request body
+// at four bytes per token. JSON structure is counted too, so it errs high.
+func estimateInputTokens(body []byte) int {
+	return (len(body) + 3) / 4
+}
+
+// throttle applies proxy.rate_limits to a request before it is forwarded,
+// logging any delay or rejection.
+func (p *MITMProxy) throttle(ctx context.Context, flowID string, reqBody []byte) error {
+	if p.models == nil {
+		return nil
+	}
+	model := requestModel(reqBody)
+	waited, err := p.models.Wait(ctx, model, estimateInputTokens(reqBody))
+	var limited *rateLimitedError
+	switch {
+	case errors.As(err, &limited):
+		p.logger.Info("request rejected by rate limit", "flow_id", flowID, "model", model,
+			"rule", limited.model, "retry_after", limited.retryAfter.Round(time.Millisecond))
+	case err == nil && waited > 0:
+		p.logger.Info("request delayed by rate limit", "flow_id", flowID, "model", model,
+			"waited", waited.Round(time.Millisecond))
+	}
+	return err
+}
+
+// sendRateLimited writes a 429 with Retry-After to an intercepted
+// connection.
+func (p *MITMProxy) sendRateLimited(conn net.Conn, limited *rateLimitedError) {
+	message := limited.Error()
+	response := fmt.Sprintf("HTTP/1.1 %d %s\r\nContent-Type: text/plain\r\nRetry-After: %s\r\nContent-Length: %d\r\n\r\n%s",
+		http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests), limited.retryAfterHeader(), len(message), message)
+	_, _ = conn.Write([]byte(response))
+}
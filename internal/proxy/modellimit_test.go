@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/config"
+	"github.com/HakAl/langley/internal/store"
+)
+
+func TestNewModelLimiter(t *testing.T) {
+	if l, err := NewModelLimiter(config.ProxyConfig{}); err != nil || l != nil {
+		t.Errorf("no limits: got %v, %v; want nil limiter", l, err)
+	}
+	for _, cfg := range []config.ProxyConfig{
+		{RateLimitOverflow: "drop"},
+		{RateLimits: []config.ModelRateLimit{{RequestsPerMinute: 10}}},
+		{RateLimits: []config.ModelRateLimit{{Model: "claude-*", RequestsPerMinute: -1}}},
+		{RateLimits: []config.ModelRateLimit{{Model: "claude-*"}}},
+	} {
+		if _, err := NewModelLimiter(cfg); err == nil {
+			t.Errorf("NewModelLimiter(%+v) succeeded, want error", cfg)
+		}
+	}
+}
+
+func TestModelLimiter_Delays(t *testing.T) {
+	l, err := NewModelLimiter(config.ProxyConfig{RateLimits: []config.ModelRateLimit{
+		{Model: "claude-*", RequestsPerMinute: 2},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.window = 200 * time.Millisecond
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if waited, err := l.Wait(ctx, "claude-sonnet-4", 10); err != nil || waited != 0 {
+			t.Fatalf("request %d: waited %v, %v; want admitted straight away", i, waited, err)
+		}
+	}
+	// Other models and requests without a model aren't limited
+	if waited, _ := l.Wait(ctx, "gpt-4o", 10); waited != 0 {
+		t.Errorf("unmatched model waited %v", waited)
+	}
+	if waited, _ := l.Wait(ctx, "", 10); waited != 0 {
+		t.Errorf("request without a model waited %v", waited)
+	}
+
+	waited, err := l.Wait(ctx, "claude-opus-4", 10)
+	if err != nil || waited < 100*time.Millisecond {
+		t.Errorf("third request waited %v, %v; want it held until the window has room", waited, err)
+	}
+	stats := l.Stats()
+	if len(stats) != 1 || stats[0].Delayed != 1 || stats[0].Rejected != 0 {
+		t.Errorf("stats = %+v, want one delayed request", stats)
+	}
+
+	// A cancelled wait gives up
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, _ = l.Wait(ctx, "claude-opus-4", 10)
+	if _, err := l.Wait(cancelled, "claude-opus-4", 10); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled wait error = %v, want context.Canceled", err)
+	}
+}
+
+func TestModelLimiter_Tokens(t *testing.T) {
+	l, err := NewModelLimiter(config.ProxyConfig{
+		RateLimitOverflow: RateLimitOverflowReject,
+		RateLimits:        []config.ModelRateLimit{{Model: "claude-*", TokensPerMinute: 100}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// Over the whole limit on its own is let through when nothing else is
+	// in the window, so it can't wait forever
+	if _, err := l.Wait(ctx, "claude-haiku", 150); err != nil {
+		t.Fatalf("oversized first request: %v", err)
+	}
+	_, err = l.Wait(ctx, "claude-haiku", 10)
+	var limited *rateLimitedError
+	if !errors.As(err, &limited) {
+		t.Fatalf("error = %v, want a rate limit rejection", err)
+	}
+	if limited.retryAfter <= 0 || limited.retryAfter > time.Minute || limited.retryAfterHeader() != "60" {
+		t.Errorf("retry after %v (header %s), want about a minute", limited.retryAfter, limited.retryAfterHeader())
+	}
+	if stats := l.Stats(); stats[0].Tokens != 150 || stats[0].Requests != 1 || stats[0].Rejected != 1 {
+		t.Errorf("stats = %+v", stats[0])
+	}
+}
+
+func TestRequestModel(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"model":"claude-sonnet-4","max_tokens":10}`, "claude-sonnet-4"},
+		{`{"contents":[]}`, ""},
+		{`not json`, ""},
+		{``, ""},
+	}
+	for _, tt := range tests {
+		if got := requestModel([]byte(tt.body)); got != tt.want {
+			t.Errorf("requestModel(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestMITMProxy_RateLimitRejects(t *testing.T) {
+	t.Parallel()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	})
+	limits := func(cfg *config.Config) {
+		cfg.Proxy.RateLimitOverflow = RateLimitOverflowReject
+		cfg.Proxy.RateLimits = []config.ModelRateLimit{{Model: "claude-*", RequestsPerMinute: 1}}
+	}
+
+	t.Run("http", func(t *testing.T) {
+		t.Parallel()
+		upstream := httptest.NewServer(handler)
+		defer upstream.Close()
+
+		p, proxyAddr, _, cleanup := setupMITMProxy(t, limits)
+		defer cleanup()
+		client := &http.Client{
+			Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, "http://"+proxyAddr))},
+			Timeout:   5 * time.Second,
+		}
+		checkRateLimitRejects(t, p, client, upstream.URL)
+	})
+
+	t.Run("https", func(t *testing.T) {
+		t.Parallel()
+		upstream := httptest.NewTLSServer(handler)
+		defer upstream.Close()
+
+		p, proxyAddr, _, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+			limits(cfg)
+			cfg.Proxy.UpstreamOverrides = map[string]string{"api.anthropic.com": upstream.Listener.Addr().String()}
+		})
+		defer cleanup()
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(p.ca.CertPEM())
+		client := &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyURL(mustParseURL(t, "http://"+proxyAddr)),
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+			Timeout: 5 * time.Second,
+		}
+		checkRateLimitRejects(t, p, client, "https://api.anthropic.com")
+	})
+}
+
+// checkRateLimitRejects sends three requests through a proxy limited to
+// one claude-* request a minute: the second claude request must get a 429
+// and be saved as one, while another model still gets through.
+func checkRateLimitRejects(t *testing.T, p *MITMProxy, client *http.Client, baseURL string) {
+	t.Helper()
+	post := func(path, model string) *http.Response {
+		t.Helper()
+		resp, err := client.Post(baseURL+path, "application/json", strings.NewReader(`{"model":"`+model+`"}`))
+		if err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := post("/first", "claude-sonnet-4"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("first status = %d, want 200", resp.StatusCode)
+	}
+	resp := post("/second", "claude-sonnet-4")
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("second status = %d, want 429", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("429 without Retry-After")
+	}
+	if resp := post("/other", "gpt-4o"); resp.StatusCode != http.StatusOK {
+		t.Errorf("unlimited model status = %d, want 200", resp.StatusCode)
+	}
+
+	if stats := p.models.Stats(); stats[0].Rejected != 1 || stats[0].Requests != 1 {
+		t.Errorf("stats = %+v", stats[0])
+	}
+	waitFor(t, "rejected flow saved", func() bool {
+		flows, _ := p.store.ListFlows(context.Background(), store.FlowFilter{})
+		for _, f := range flows {
+			if f.Path == "/second" && f.StatusCode != nil && *f.StatusCode == http.StatusTooManyRequests {
+				return true
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"log/slog"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// memoryCheckInterval bounds how often runtime.ReadMemStats is called.
+	memoryCheckInterval = time.Second
+
+	// memoryRecoverPercent is the share of the threshold heap usage must fall
+	// below before full capture resumes. The gap prevents flapping.
+	memoryRecoverPercent = 80
+)
+
+// memoryGuard switches the proxy to metadata-only capture when heap usage
+// exceeds a configured threshold, and back once usage drops well below it.
+// Forwarding is never affected; only body storage and event persistence are.
+type memoryGuard struct {
+	threshold uint64
+	recoverAt uint64
+	logger    *slog.Logger
+	readHeap  func() uint64 // Overridable for tests
+
+	degraded atomic.Bool
+
+	mu        sync.Mutex
+	lastCheck time.Time
+}
+
+// newMemoryGuard creates a guard for the given threshold in megabytes.
+// Returns nil when thresholdMB is zero or negative (guard disabled).
+func newMemoryGuard(thresholdMB int, logger *slog.Logger) *memoryGuard {
+	if thresholdMB <= 0 {
+		return nil
+	}
+	threshold := uint64(thresholdMB) * 1024 * 1024
+	return &memoryGuard{
+		threshold: threshold,
+		recoverAt: threshold * memoryRecoverPercent / 100,
+		logger:    logger,
+		readHeap:  readHeapAlloc,
+	}
+}
+
+// readHeapAlloc returns the bytes of allocated heap objects.
+func readHeapAlloc() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+// MetadataOnly reports whether capture should skip bodies and events.
+// A nil guard never degrades.
+func (g *memoryGuard) MetadataOnly() bool {
+	if g == nil {
+		return false
+	}
+
+	g.mu.Lock()
+	if time.Since(g.lastCheck) >= memoryCheckInterval {
+		g.lastCheck = time.Now()
+		g.mu.Unlock()
+		g.check()
+	} else {
+		g.mu.Unlock()
+	}
+
+	return g.degraded.Load()
+}
+
+// check samples heap usage and updates the degraded state, logging transitions.
+func (g *memoryGuard) check() {
+	heap := g.readHeap()
+
+	switch {
+	case heap >= g.threshold:
+		if g.degraded.CompareAndSwap(false, true) {
+			g.logger.Warn("memory pressure: switching to metadata-only capture",
+				"heap_mb", heap/1024/1024, "threshold_mb", g.threshold/1024/1024)
+		}
+	case heap < g.recoverAt:
+		if g.degraded.CompareAndSwap(true, false) {
+			g.logger.Info("memory pressure subsided: resuming full capture",
+				"heap_mb", heap/1024/1024, "threshold_mb", g.threshold/1024/1024)
+		}
+	}
+}
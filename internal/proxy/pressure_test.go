@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/redact"
+	"github.com/HakAl/langley/internal/store/storetest"
+	langleytls "github.com/HakAl/langley/internal/tls"
+)
+
+func TestMemoryGuard_DegradesAndRecovers(t *testing.T) {
+	t.Parallel()
+
+	const mb = 1024 * 1024
+	var heap atomic.Uint64
+
+	g := newMemoryGuard(100, testLogger())
+	g.readHeap = heap.Load
+
+	heap.Store(50 * mb)
+	g.check()
+	if g.degraded.Load() {
+		t.Fatal("should not be degraded below threshold")
+	}
+
+	heap.Store(120 * mb)
+	g.check()
+	if !g.degraded.Load() {
+		t.Fatal("should be degraded above threshold")
+	}
+
+	// Between the recovery level and the threshold the state is sticky
+	heap.Store(90 * mb)
+	g.check()
+	if !g.degraded.Load() {
+		t.Fatal("should stay degraded until usage drops below the recovery level")
+	}
+
+	heap.Store(70 * mb)
+	g.check()
+	if g.degraded.Load() {
+		t.Fatal("should recover once usage drops below the recovery level")
+	}
+}
+
+func TestMemoryGuard_Disabled(t *testing.T) {
+	t.Parallel()
+
+	g := newMemoryGuard(0, testLogger())
+	if g != nil {
+		t.Fatal("expected nil guard when threshold is 0")
+	}
+	if g.MetadataOnly() {
+		t.Error("nil guard should never report metadata-only")
+	}
+}
+
+func TestMITMProxy_MemoryPressureSkipsBodies(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("response body"))
+	}))
+	defer upstream.Close()
+
+	cfg := testConfig()
+	ca, _ := langleytls.LoadOrCreateCA(t.TempDir())
+	redactor, _ := redact.New(&cfg.Redaction)
+
+	var heap atomic.Uint64
+	heap.Store(200 * 1024 * 1024)
+
+	capture := &flowCapture{}
+	p, err := NewMITMProxy(MITMProxyConfig{
+		Config:    cfg,
+		Logger:    testLogger(),
+		CA:        ca,
+		CertCache: langleytls.NewCertCache(ca, 100),
+		Redactor:  redactor,
+		Store:     storetest.New(storetest.WithForeignKeys()),
+		OnUpdate:  capture.OnUpdate,
+	})
+	if err != nil {
+		t.Fatalf("NewMITMProxy: %v", err)
+	}
+	p.memGuard = newMemoryGuard(100, testLogger())
+	p.memGuard.readHeap = heap.Load
+
+	proxyServer := httptest.NewServer(p)
+	defer proxyServer.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyURL(mustParseURL(t, proxyServer.URL)),
+		},
+	}
+
+	req, _ := http.NewRequest("POST", upstream.URL+"/test", strings.NewReader("request body"))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200 (forwarding must continue under pressure)", resp.StatusCode)
+	}
+
+	flow := capture.WaitForFlow(2 * time.Second)
+	if flow == nil {
+		t.Fatal("expected captured flow")
+	}
+	if flow.RequestBody != nil {
+		t.Errorf("RequestBody should be nil under memory pressure, got %q", *flow.RequestBody)
+	}
+	if flow.ResponseBody != nil {
+		t.Errorf("ResponseBody should be nil under memory pressure, got %q", *flow.ResponseBody)
+	}
+	if flow.StatusCode == nil || *flow.StatusCode != http.StatusOK {
+		t.Error("metadata (status code) should still be captured")
+	}
+}
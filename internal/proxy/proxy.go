@@ -14,15 +14,17 @@ import (
 	"time"
 
 	"github.com/HakAl/langley/internal/config"
+	"github.com/HakAl/langley/internal/redact"
 )
 
 // Proxy is the main HTTP/HTTPS intercepting proxy.
 type Proxy struct {
-	cfg      *config.Config
-	logger   *slog.Logger
-	server   *http.Server
-	client   *http.Client
-	shutdown sync.WaitGroup
+	cfg       *config.Config
+	logger    *slog.Logger
+	logRedact *logRedactor
+	server    *http.Server
+	client    *http.Client
+	shutdown  sync.WaitGroup
 }
 
 // New creates a new Proxy with the given configuration.
@@ -60,10 +62,16 @@ func New(cfg *config.Config, logger *slog.Logger) (*Proxy, error) {
 		Timeout: 0, // No timeout - streaming responses can be long
 	}
 
+	redactor, err := redact.New(&cfg.Redaction)
+	if err != nil {
+		return nil, fmt.Errorf("creating redactor: %w", err)
+	}
+
 	p := &Proxy{
-		cfg:    cfg,
-		logger: logger,
-		client: client,
+		cfg:       cfg,
+		logger:    logger,
+		logRedact: newLogRedactor(cfg.Logging.RedactLogs, redactor),
+		client:    client,
 	}
 
 	// Create HTTP server
@@ -119,7 +127,7 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 // handleHTTP handles regular HTTP requests (non-CONNECT).
 func (p *Proxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
-	p.logger.Debug("HTTP request", "method", r.Method, "url", r.URL.String())
+	p.logger.Debug("HTTP request", "method", r.Method, "url", p.logRedact.URL(r.URL))
 
 	// Create outbound request
 	outReq, err := http.NewRequestWithContext(r.Context(), r.Method, r.URL.String(), r.Body)
@@ -138,7 +146,7 @@ func (p *Proxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
 	// Forward request
 	resp, err := p.client.Do(outReq)
 	if err != nil {
-		p.logger.Error("failed to forward request", "error", err)
+		p.logger.Error("failed to forward request", "error", p.logRedact.Err(err))
 		http.Error(w, "Bad gateway", http.StatusBadGateway)
 		return
 	}
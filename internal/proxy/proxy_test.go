@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/tls"
@@ -8,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -17,19 +19,23 @@ import (
 	"testing"
 	"time"
 
+	"github.com/HakAl/langley/internal/analytics"
 	"github.com/HakAl/langley/internal/config"
 	"github.com/HakAl/langley/internal/provider"
 	"github.com/HakAl/langley/internal/redact"
 	"github.com/HakAl/langley/internal/store"
+	"github.com/HakAl/langley/internal/store/storetest"
 	"github.com/HakAl/langley/internal/task"
+	"github.com/HakAl/langley/internal/testutil"
 	langleytls "github.com/HakAl/langley/internal/tls"
 )
 
 // flowCapture provides thread-safe capture of flow data for tests.
 type flowCapture struct {
-	mu     sync.Mutex
-	flow   *store.Flow
-	events []*store.Event
+	mu      sync.Mutex
+	flow    *store.Flow
+	events  []*store.Event
+	updated bool // OnUpdate has run
 }
 
 func (c *flowCapture) OnFlow(flow *store.Flow) {
@@ -42,6 +48,7 @@ func (c *flowCapture) OnUpdate(flow *store.Flow) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.flow = flow
+	c.updated = true
 }
 
 func (c *flowCapture) OnEvent(event *store.Event) {
@@ -74,11 +81,30 @@ func (c *flowCapture) WaitForFlow(timeout time.Duration) *store.Flow {
 	return nil
 }
 
+// WaitForUpdate waits for a flow to be completed with the response, which
+// happens after the client has it.
+func (c *flowCapture) WaitForUpdate(timeout time.Duration) *store.Flow {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		f, updated := c.flow, c.updated
+		c.mu.Unlock()
+		if updated {
+			return f
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
 // testConfig returns a minimal config for testing
 func testConfig() *config.Config {
 	return &config.Config{
 		Proxy: config.ProxyConfig{
 			Listen: "127.0.0.1:0", // Random port
+			// Test upstreams are httptest servers on loopback; capture them
+			// like a provider. Passthrough tests clear this.
+			InterceptHosts: []string{"127.0.0.1"},
 		},
 		Persistence: config.PersistenceConfig{
 			BodyMaxBytes: 1024 * 1024,
@@ -205,106 +231,6 @@ func TestRemoveHopByHopHeaders_ConnectionValues(t *testing.T) {
 	}
 }
 
-// mockStore implements store.Store for testing
-type mockStore struct {
-	flows  map[string]*store.Flow
-	events map[string][]*store.Event
-}
-
-func newMockStore() *mockStore {
-	return &mockStore{
-		flows:  make(map[string]*store.Flow),
-		events: make(map[string][]*store.Event),
-	}
-}
-
-func (m *mockStore) SaveFlow(ctx context.Context, flow *store.Flow) error {
-	m.flows[flow.ID] = flow
-	return nil
-}
-
-func (m *mockStore) UpdateFlow(ctx context.Context, flow *store.Flow) error {
-	m.flows[flow.ID] = flow
-	return nil
-}
-
-func (m *mockStore) GetFlow(ctx context.Context, id string) (*store.Flow, error) {
-	return m.flows[id], nil
-}
-
-func (m *mockStore) ListFlows(ctx context.Context, filter store.FlowFilter) ([]*store.Flow, error) {
-	var result []*store.Flow
-	for _, f := range m.flows {
-		result = append(result, f)
-	}
-	return result, nil
-}
-
-func (m *mockStore) DeleteFlow(ctx context.Context, id string) error {
-	delete(m.flows, id)
-	return nil
-}
-
-func (m *mockStore) CountFlows(ctx context.Context, filter store.FlowFilter) (int, error) {
-	return len(m.flows), nil
-}
-
-func (m *mockStore) SaveEvent(ctx context.Context, event *store.Event) error {
-	// Simulate foreign key constraint - flow must exist (langley-2fa)
-	if _, exists := m.flows[event.FlowID]; !exists {
-		return fmt.Errorf("FOREIGN KEY constraint failed: flow %s does not exist", event.FlowID)
-	}
-	m.events[event.FlowID] = append(m.events[event.FlowID], event)
-	return nil
-}
-
-func (m *mockStore) SaveEvents(ctx context.Context, events []*store.Event) error {
-	for _, e := range events {
-		m.events[e.FlowID] = append(m.events[e.FlowID], e)
-	}
-	return nil
-}
-
-func (m *mockStore) GetEventsByFlow(ctx context.Context, flowID string) ([]*store.Event, error) {
-	return m.events[flowID], nil
-}
-
-func (m *mockStore) SaveToolInvocation(ctx context.Context, inv *store.ToolInvocation) error {
-	return nil
-}
-
-func (m *mockStore) GetToolInvocationsByFlow(ctx context.Context, flowID string) ([]*store.ToolInvocation, error) {
-	return nil, nil
-}
-
-func (m *mockStore) GetToolInvocation(ctx context.Context, id string) (*store.ToolInvocation, error) {
-	return nil, nil
-}
-
-func (m *mockStore) ListToolInvocations(ctx context.Context, toolName string, start, end time.Time, limit, offset int) ([]*store.ToolInvocation, int, error) {
-	return nil, 0, nil
-}
-
-func (m *mockStore) UpdateToolResult(ctx context.Context, toolUseID string, success bool, errorMsg *string, resultContent *string, resultTime time.Time) error {
-	return nil
-}
-
-func (m *mockStore) LogDrop(ctx context.Context, entry *store.DropLogEntry) error {
-	return nil
-}
-
-func (m *mockStore) RunRetention(ctx context.Context) (int64, error) {
-	return 0, nil
-}
-
-func (m *mockStore) Close() error {
-	return nil
-}
-
-func (m *mockStore) DB() interface{} {
-	return nil
-}
-
 func TestMITMProxy_HTTPForwarding(t *testing.T) {
 	t.Parallel()
 
@@ -337,7 +263,7 @@ func TestMITMProxy_HTTPForwarding(t *testing.T) {
 		t.Fatalf("failed to create redactor: %v", err)
 	}
 
-	mockStore := newMockStore()
+	mockStore := storetest.New(storetest.WithForeignKeys())
 	taskAssigner := task.NewAssigner(task.AssignerConfig{})
 
 	capture := &flowCapture{}
@@ -443,7 +369,7 @@ func TestMITMProxy_SSEResponse(t *testing.T) {
 	ca, _ := langleytls.LoadOrCreateCA(tmpDir)
 	certCache := langleytls.NewCertCache(ca, 100)
 	redactor, _ := redact.New(&config.RedactionConfig{})
-	mockStore := newMockStore()
+	mockStore := storetest.New(storetest.WithForeignKeys())
 
 	capture := &flowCapture{}
 
@@ -499,6 +425,141 @@ func TestMITMProxy_SSEResponse(t *testing.T) {
 	}
 }
 
+func TestMITMProxy_BedrockEventStreamResponse(t *testing.T) {
+	t.Parallel()
+
+	stream := testutil.LoadEventStream(t, "bedrock_invoke_stream")
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+		w.Header().Set("X-Amzn-Bedrock-Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		flusher := w.(http.Flusher)
+		// Split mid-message to exercise reassembly across reads
+		for _, part := range [][]byte{stream[:100], stream[100:700], stream[700:]} {
+			_, _ = w.Write(part)
+			flusher.Flush()
+		}
+	}))
+	defer upstream.Close()
+
+	cfg := testConfig()
+	ca, _ := langleytls.LoadOrCreateCA(t.TempDir())
+	redactor, _ := redact.New(&config.RedactionConfig{})
+	capture := &flowCapture{}
+	proxy, err := NewMITMProxy(MITMProxyConfig{
+		Config:    cfg,
+		Logger:    testLogger(),
+		CA:        ca,
+		CertCache: langleytls.NewCertCache(ca, 100),
+		Redactor:  redactor,
+		Store:     storetest.New(storetest.WithForeignKeys()),
+		OnUpdate:  capture.OnUpdate,
+		OnEvent:   capture.OnEvent,
+	})
+	if err != nil {
+		t.Fatalf("NewMITMProxy: %v", err)
+	}
+	proxyServer := httptest.NewServer(proxy)
+	defer proxyServer.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, proxyServer.URL))},
+	}
+	resp, err := client.Post(upstream.URL+"/model/anthropic.claude-3-5-sonnet/invoke-with-response-stream", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// The binary stream reaches the client unchanged
+	if !bytes.Equal(body, stream) {
+		t.Errorf("client received %d bytes, want the original %d", len(body), len(stream))
+	}
+
+	flow := capture.WaitForFlow(2 * time.Second)
+	if flow == nil {
+		t.Fatal("flow not captured")
+	}
+	if !flow.IsSSE {
+		t.Error("event stream flow should be marked as streaming")
+	}
+
+	events := capture.Events()
+	if len(events) != 7 {
+		t.Fatalf("got %d events, want 7", len(events))
+	}
+	if events[0].EventType != "message_start" || events[6].EventType != "message_stop" {
+		t.Errorf("event types = %s..%s, want message_start..message_stop", events[0].EventType, events[6].EventType)
+	}
+}
+
+func TestMITMProxy_CacheBreakpointCapture(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(upstream.Close) // Subtests run in parallel after this function returns
+
+	body := `{"system":[{"type":"text","text":"ctx","cache_control":{"type":"ephemeral"}}],` +
+		`"messages":[{"role":"user","content":[{"type":"text","text":"q","cache_control":{"type":"ephemeral"}}]}]}`
+
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprintf("enabled=%v", enabled), func(t *testing.T) {
+			t.Parallel()
+
+			cfg := testConfig()
+			cfg.Analytics.CaptureCacheBreakpoints = enabled
+			ca, _ := langleytls.LoadOrCreateCA(t.TempDir())
+			redactor, _ := redact.New(&cfg.Redaction)
+			capture := &flowCapture{}
+			proxy, err := NewMITMProxy(MITMProxyConfig{
+				Config:    cfg,
+				Logger:    testLogger(),
+				CA:        ca,
+				CertCache: langleytls.NewCertCache(ca, 100),
+				Redactor:  redactor,
+				Store:     storetest.New(storetest.WithForeignKeys()),
+				OnUpdate:  capture.OnUpdate,
+			})
+			if err != nil {
+				t.Fatalf("NewMITMProxy: %v", err)
+			}
+			proxyServer := httptest.NewServer(proxy)
+			defer proxyServer.Close()
+
+			client := &http.Client{
+				Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, proxyServer.URL))},
+			}
+			resp, err := client.Post(upstream.URL+"/v1/messages", "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			flow := capture.WaitForFlow(2 * time.Second)
+			if flow == nil {
+				t.Fatal("flow not captured")
+			}
+			if !enabled {
+				if flow.CacheBreakpoints != nil {
+					t.Errorf("CacheBreakpoints = %d, want nil when capture is off", *flow.CacheBreakpoints)
+				}
+				return
+			}
+			if flow.CacheBreakpoints == nil || *flow.CacheBreakpoints != 2 {
+				t.Fatalf("CacheBreakpoints = %v, want 2", flow.CacheBreakpoints)
+			}
+			want := []string{"system[0]", "messages[0].content[0]"}
+			if strings.Join(flow.CacheBreakpointPositions, ",") != strings.Join(want, ",") {
+				t.Errorf("CacheBreakpointPositions = %v, want %v", flow.CacheBreakpointPositions, want)
+			}
+		})
+	}
+}
+
 func TestMITMProxy_ErrorResponse(t *testing.T) {
 	t.Parallel()
 
@@ -517,7 +578,7 @@ func TestMITMProxy_ErrorResponse(t *testing.T) {
 	ca, _ := langleytls.LoadOrCreateCA(tmpDir)
 	certCache := langleytls.NewCertCache(ca, 100)
 	redactor, _ := redact.New(&config.RedactionConfig{})
-	mockStore := newMockStore()
+	mockStore := storetest.New(storetest.WithForeignKeys())
 
 	capture := &flowCapture{}
 	proxy, _ := NewMITMProxy(MITMProxyConfig{
@@ -574,7 +635,7 @@ func TestMITMProxy_TaskAssignment(t *testing.T) {
 	ca, _ := langleytls.LoadOrCreateCA(tmpDir)
 	certCache := langleytls.NewCertCache(ca, 100)
 	redactor, _ := redact.New(&config.RedactionConfig{})
-	mockStore := newMockStore()
+	mockStore := storetest.New(storetest.WithForeignKeys())
 	taskAssigner := task.NewAssigner(task.AssignerConfig{IdleGapMinutes: 5})
 
 	capture := &flowCapture{}
@@ -641,6 +702,74 @@ func TestMITMProxy_TaskAssignment(t *testing.T) {
 	})
 }
 
+func TestMITMProxy_TaskMarkersStripped(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var gotQuery, gotCookie string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		gotQuery = r.URL.RawQuery
+		gotCookie = r.Header.Get("Cookie")
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	tmpDir := t.TempDir()
+	ca, _ := langleytls.LoadOrCreateCA(tmpDir)
+	redactor, _ := redact.New(&config.RedactionConfig{})
+
+	capture := &flowCapture{}
+	proxy, _ := NewMITMProxy(MITMProxyConfig{
+		Config:       testConfig(),
+		Logger:       testLogger(),
+		CA:           ca,
+		CertCache:    langleytls.NewCertCache(ca, 100),
+		Redactor:     redactor,
+		Store:        storetest.New(storetest.WithForeignKeys()),
+		TaskAssigner: task.NewAssigner(task.AssignerConfig{}),
+		OnUpdate:     capture.OnUpdate,
+	})
+
+	proxyServer := httptest.NewServer(proxy)
+	defer proxyServer.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyURL(mustParseURL(t, proxyServer.URL)),
+		},
+	}
+
+	req, _ := http.NewRequest("GET", upstream.URL+"/test?a=1&langley_task=query-task", nil)
+	req.Header.Set("Cookie", "session=abc; langley_task=cookie-task")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	capturedFlow := capture.WaitForFlow(2 * time.Second)
+	if capturedFlow == nil || capturedFlow.TaskID == nil {
+		t.Fatal("task not assigned")
+	}
+	if *capturedFlow.TaskID != "query-task" {
+		t.Errorf("TaskID = %q, want %q", *capturedFlow.TaskID, "query-task")
+	}
+	if strings.Contains(capturedFlow.URL, "langley_task") {
+		t.Errorf("flow URL should not contain task marker, got %q", capturedFlow.URL)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if gotQuery != "a=1" {
+		t.Errorf("upstream query = %q, want %q", gotQuery, "a=1")
+	}
+	if gotCookie != "session=abc" {
+		t.Errorf("upstream Cookie = %q, want %q", gotCookie, "session=abc")
+	}
+}
+
 func TestMITMProxy_BodyTruncation(t *testing.T) {
 	t.Parallel()
 
@@ -662,7 +791,7 @@ func TestMITMProxy_BodyTruncation(t *testing.T) {
 	ca, _ := langleytls.LoadOrCreateCA(tmpDir)
 	certCache := langleytls.NewCertCache(ca, 100)
 	redactor, _ := redact.New(&config.RedactionConfig{})
-	mockStore := newMockStore()
+	mockStore := storetest.New(storetest.WithForeignKeys())
 
 	capture := &flowCapture{}
 	proxy, _ := NewMITMProxy(MITMProxyConfig{
@@ -734,7 +863,7 @@ func TestMITMProxy_DisableBodyStorage(t *testing.T) {
 	redactor, _ := redact.New(&config.RedactionConfig{
 		DisableBodyStorage: true,
 	})
-	mockStore := newMockStore()
+	mockStore := storetest.New(storetest.WithForeignKeys())
 
 	capture := &flowCapture{}
 	proxy, _ := NewMITMProxy(MITMProxyConfig{
@@ -888,7 +1017,7 @@ func TestMITMProxy_CONNECT_SSE(t *testing.T) {
 
 	certCache := langleytls.NewCertCache(ca, 100)
 	redactor, _ := redact.New(&config.RedactionConfig{})
-	mockStore := newMockStore()
+	mockStore := storetest.New(storetest.WithForeignKeys())
 
 	capture := &flowCapture{}
 
@@ -1067,7 +1196,7 @@ func TestExtractUsageAndCost_SSE(t *testing.T) {
 	prov := provider.NewRegistry().Get("anthropic")
 
 	ctx := context.Background()
-	p.extractUsageAndCost(ctx, flow, prov, sseBody)
+	p.extractUsageAndCost(ctx, flow, prov, sseBody, nil)
 
 	if flow.InputTokens == nil || *flow.InputTokens != 150 {
 		t.Errorf("InputTokens = %v, want 150", flow.InputTokens)
@@ -1108,7 +1237,7 @@ func TestExtractUsageAndCost_JSON(t *testing.T) {
 	prov := provider.NewRegistry().Get("anthropic")
 
 	ctx := context.Background()
-	p.extractUsageAndCost(ctx, flow, prov, jsonBody)
+	p.extractUsageAndCost(ctx, flow, prov, jsonBody, nil)
 
 	if flow.InputTokens == nil || *flow.InputTokens != 200 {
 		t.Errorf("InputTokens = %v, want 200", flow.InputTokens)
@@ -1130,13 +1259,93 @@ func TestExtractUsageAndCost_EmptyBody(t *testing.T) {
 	prov := provider.NewRegistry().Get("anthropic")
 
 	ctx := context.Background()
-	p.extractUsageAndCost(ctx, flow, prov, nil)
+	p.extractUsageAndCost(ctx, flow, prov, nil, nil)
 
 	if flow.InputTokens != nil {
 		t.Errorf("InputTokens should be nil for empty body, got %v", flow.InputTokens)
 	}
 }
 
+// TestExtractUsageAndCost_RequestModel verifies that a response without a
+// model, such as an error, takes the model from the request, while a model
+// named in the response wins.
+func TestExtractUsageAndCost_RequestModel(t *testing.T) {
+	t.Parallel()
+
+	p := &MITMProxy{}
+	prov := provider.NewRegistry().Get("anthropic")
+	ctx := context.Background()
+	reqBody := []byte(`{"model":"claude-sonnet-4-5","max_tokens":100}`)
+
+	errored := &store.Flow{}
+	p.extractUsageAndCost(ctx, errored, prov, []byte(`{"type":"error","error":{"type":"invalid_request_error","message":"max_tokens: too large"}}`), reqBody)
+	if errored.Model == nil || *errored.Model != "claude-sonnet-4-5" {
+		t.Errorf("error response: Model = %v, want the request's claude-sonnet-4-5", errored.Model)
+	}
+
+	answered := &store.Flow{}
+	p.extractUsageAndCost(ctx, answered, prov, []byte(`{"model":"claude-sonnet-4-5-20250929","usage":{"input_tokens":7,"output_tokens":3}}`), reqBody)
+	if answered.Model == nil || *answered.Model != "claude-sonnet-4-5-20250929" {
+		t.Errorf("answered: Model = %v, want the response's claude-sonnet-4-5-20250929", answered.Model)
+	}
+
+	unnamed := &store.Flow{}
+	p.extractUsageAndCost(ctx, unnamed, prov, []byte(`{"type":"error"}`), []byte(`not json`))
+	if unnamed.Model != nil {
+		t.Errorf("request without a model: Model = %q, want nil", *unnamed.Model)
+	}
+}
+
+// TestEstimateMissingUsage verifies that a non-streaming flow whose response
+// had no usage gets its input tokens estimated from the request, marked as
+// an estimate, while reported usage and streams are left alone.
+func TestEstimateMissingUsage(t *testing.T) {
+	t.Parallel()
+
+	p := &MITMProxy{}
+	prov := provider.NewRegistry().Get("anthropic")
+	ctx := context.Background()
+	reqBody := []byte(`{"model":"claude-sonnet-4-20250514","max_tokens":100,"messages":[{"role":"user","content":"` + strings.Repeat("word ", 80) + `"}]}`)
+
+	// An error returned before generation carries no usage
+	flow := &store.Flow{}
+	p.extractUsageAndCost(ctx, flow, prov, []byte(`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`), reqBody)
+	p.estimateMissingUsage(ctx, flow, reqBody)
+	if flow.InputTokens == nil || *flow.InputTokens < 100 {
+		t.Errorf("InputTokens = %v, want an estimate of about 100", flow.InputTokens)
+	}
+	if flow.CostSource == nil || *flow.CostSource != "estimated" {
+		t.Errorf("CostSource = %v, want estimated", flow.CostSource)
+	}
+	if flow.Model == nil || *flow.Model != "claude-sonnet-4-20250514" {
+		t.Errorf("Model = %v, want the request's model", flow.Model)
+	}
+	if flow.ErrorType == nil || *flow.ErrorType != "overloaded_error" {
+		t.Errorf("ErrorType = %v, want overloaded_error", flow.ErrorType)
+	}
+
+	reported := &store.Flow{}
+	p.extractUsageAndCost(ctx, reported, prov, []byte(`{"model":"claude-sonnet-4-20250514","usage":{"input_tokens":7,"output_tokens":3}}`), reqBody)
+	p.estimateMissingUsage(ctx, reported, reqBody)
+	if reported.InputTokens == nil || *reported.InputTokens != 7 || reported.CostSource != nil {
+		t.Errorf("reported usage: InputTokens = %v, CostSource = %v; want 7 and no estimate", reported.InputTokens, reported.CostSource)
+	}
+
+	stream := &store.Flow{IsSSE: true}
+	p.estimateMissingUsage(ctx, stream, reqBody)
+	if stream.InputTokens != nil {
+		t.Errorf("SSE flow InputTokens = %v, want nil", stream.InputTokens)
+	}
+
+	// Local models are free, so the estimate doesn't make their cost one
+	local := &MITMProxy{analytics: analytics.NewEngine(nil)}
+	ollama := &store.Flow{Provider: "ollama"}
+	local.estimateMissingUsage(ctx, ollama, []byte(`{"model":"llama3.2","messages":[{"role":"user","content":"hi"}]}`))
+	if ollama.InputTokens == nil || ollama.CostSource == nil || *ollama.CostSource != "local" {
+		t.Errorf("ollama: InputTokens = %v, CostSource = %v; want an estimate costed as local", ollama.InputTokens, ollama.CostSource)
+	}
+}
+
 // TestExtractUsageAndCost_DecoupledFromResponseBody proves the core fix:
 // extractUsageAndCost works with a []byte body parameter, independent of
 // flow.ResponseBody. When body storage is disabled, ResponseBody is nil but
@@ -1157,7 +1366,7 @@ func TestExtractUsageAndCost_DecoupledFromResponseBody(t *testing.T) {
 
 	ctx := context.Background()
 	// Pass body bytes directly — this is the captured buffer, not flow.ResponseBody
-	p.extractUsageAndCost(ctx, flow, prov, sseBody)
+	p.extractUsageAndCost(ctx, flow, prov, sseBody, nil)
 
 	// Tokens extracted even though flow.ResponseBody is nil
 	if flow.ResponseBody != nil {
@@ -1193,7 +1402,7 @@ func setupMITMProxy(t *testing.T, cfgOverride func(*config.Config)) (*MITMProxy,
 	}
 	certCache := langleytls.NewCertCache(ca, 100)
 	redactor, _ := redact.New(&config.RedactionConfig{})
-	ms := newMockStore()
+	ms := storetest.New(storetest.WithForeignKeys())
 
 	capture := &flowCapture{}
 	proxy, err := NewMITMProxy(MITMProxyConfig{
@@ -1236,7 +1445,9 @@ func TestMITMProxy_Passthrough_NoMITM(t *testing.T) {
 	defer upstream.Close()
 
 	// Setup proxy — no custom intercept_hosts
-	_, proxyAddr, capture, cleanup := setupMITMProxy(t, nil)
+	_, proxyAddr, capture, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+		cfg.Proxy.InterceptHosts = nil
+	})
 	defer cleanup()
 
 	// Client that trusts the upstream's self-signed cert (NOT our proxy CA)
@@ -1296,6 +1507,366 @@ func TestMITMProxy_Passthrough_DialFailure(t *testing.T) {
 	// (Go's HTTP client turns the proxy's 502 into a transport error)
 }
 
+// TestMITMProxy_PlainHTTPInterceptDecision verifies that plain HTTP gets
+// the same intercept decision as CONNECT: a host that isn't a provider or
+// in intercept_hosts is forwarded without capture, while a custom provider
+// matched by host and port is captured with its usage parsed.
+func TestMITMProxy_PlainHTTPInterceptDecision(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"type":"message","model":"local-model","usage":{"input_tokens":12,"output_tokens":5}}`))
+	}))
+	defer upstream.Close()
+	hostPort := strings.TrimPrefix(upstream.URL, "http://")
+
+	tests := []struct {
+		name      string
+		configure func(*config.Config)
+		captured  bool
+	}{
+		{"not intercepted", func(cfg *config.Config) { cfg.Proxy.InterceptHosts = nil }, false},
+		{"other port", func(cfg *config.Config) { cfg.Proxy.InterceptHosts = []string{"127.0.0.1:1"} }, false},
+		{"custom provider", func(cfg *config.Config) {
+			cfg.Proxy.InterceptHosts = nil
+			cfg.Providers.Custom = []config.CustomProviderConfig{{Name: "local", Hosts: []string{hostPort}, Parser: "anthropic"}}
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, proxyAddr, capture, cleanup := setupMITMProxy(t, tt.configure)
+			defer cleanup()
+
+			client := &http.Client{
+				Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, "http://"+proxyAddr))},
+				Timeout:   5 * time.Second,
+			}
+			resp, err := client.Post(upstream.URL+"/v1/messages", "application/json", strings.NewReader(`{"model":"local-model"}`))
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "local-model") {
+				t.Fatalf("response = %d %s, want the upstream's body", resp.StatusCode, body)
+			}
+
+			if !tt.captured {
+				if n, _ := p.store.CountFlows(context.Background(), store.FlowFilter{}); n != 0 || capture.Flow() != nil {
+					t.Errorf("got %d stored flows, want the request forwarded without capture", n)
+				}
+				return
+			}
+			waitFor(t, "parsed flow", func() bool {
+				f := capture.Flow()
+				return f != nil && f.InputTokens != nil
+			})
+			if f := capture.Flow(); f.Provider != "anthropic" || *f.InputTokens != 12 || f.OutputTokens == nil || *f.OutputTokens != 5 {
+				t.Errorf("flow provider %q, tokens %v/%v; want anthropic usage 12/5", f.Provider, f.InputTokens, f.OutputTokens)
+			}
+		})
+	}
+}
+
+// TestMITMProxy_ErrorKeepsRequestModel verifies that a request the provider
+// rejects with an error naming no model is still recorded with the model it
+// asked for.
+func TestMITMProxy_ErrorKeepsRequestModel(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(529)
+		_, _ = w.Write([]byte(`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	_, proxyAddr, capture, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+		cfg.Proxy.InterceptHosts = nil
+		cfg.Providers.Custom = []config.CustomProviderConfig{{Name: "local", Hosts: []string{strings.TrimPrefix(upstream.URL, "http://")}, Parser: "anthropic"}}
+	})
+	defer cleanup()
+
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, "http://"+proxyAddr))},
+		Timeout:   5 * time.Second,
+	}
+	resp, err := client.Post(upstream.URL+"/v1/messages", "application/json", strings.NewReader(`{"model":"claude-opus-4-1","max_tokens":1024}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	f := capture.WaitForUpdate(5 * time.Second)
+	if f == nil {
+		t.Fatal("flow not completed")
+	}
+	if f.StatusCode == nil || *f.StatusCode != 529 {
+		t.Errorf("status = %v, want 529", f.StatusCode)
+	}
+	if f.Model == nil || *f.Model != "claude-opus-4-1" {
+		t.Errorf("Model = %v, want the request's claude-opus-4-1", f.Model)
+	}
+	if f.ErrorType == nil || *f.ErrorType != "overloaded_error" {
+		t.Errorf("ErrorType = %v, want overloaded_error", f.ErrorType)
+	}
+}
+
+// TestMITMProxy_EmptyErrorKeepsRequestModel verifies that an error response
+// without a body, which has no usage to parse, still records the request's
+// model.
+func TestMITMProxy_EmptyErrorKeepsRequestModel(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(upstream.Close)
+
+	_, proxyAddr, capture, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+		cfg.Proxy.InterceptHosts = nil
+		cfg.Providers.Custom = []config.CustomProviderConfig{{Name: "local", Hosts: []string{strings.TrimPrefix(upstream.URL, "http://")}, Parser: "anthropic"}}
+	})
+	defer cleanup()
+
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, "http://"+proxyAddr))},
+		Timeout:   5 * time.Second,
+	}
+	resp, err := client.Post(upstream.URL+"/v1/messages", "application/json", strings.NewReader(`{"model":"claude-opus-4-1","max_tokens":1024}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	f := capture.WaitForUpdate(5 * time.Second)
+	if f == nil {
+		t.Fatal("flow not completed")
+	}
+	if f.StatusCode == nil || *f.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status = %v, want 503", f.StatusCode)
+	}
+	if f.Model == nil || *f.Model != "claude-opus-4-1" {
+		t.Errorf("Model = %v, want the request's claude-opus-4-1", f.Model)
+	}
+}
+
+// TestMITMProxy_PlainHTTPKeepAlive verifies that requests reusing one
+// keep-alive connection to the forward proxy each get their own flow with
+// their own bodies, whether sent with a length, chunked or without a body.
+func TestMITMProxy_PlainHTTPKeepAlive(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"path":%q,"echo":%q}`, r.URL.Path, body)
+	}))
+	t.Cleanup(upstream.Close)
+
+	p, proxyAddr, _, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+		cfg.Proxy.InterceptHosts = []string{strings.TrimPrefix(upstream.URL, "http://")}
+	})
+	defer cleanup()
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	requests := []struct {
+		path    string
+		body    string
+		chunked bool
+	}{
+		{"/v1/first", `{"n":1}`, false},
+		{"/v1/second", `{"n":2,"longer":true}`, true},
+		{"/v1/third", "", false},
+	}
+	for _, tt := range requests {
+		req, _ := http.NewRequest(http.MethodPost, upstream.URL+tt.path, strings.NewReader(tt.body))
+		if tt.chunked {
+			req.ContentLength = -1
+			req.TransferEncoding = []string{"chunked"}
+		}
+		if err := req.WriteProxy(conn); err != nil {
+			t.Fatalf("%s: writing request: %v", tt.path, err)
+		}
+		resp, err := http.ReadResponse(reader, req)
+		if err != nil {
+			t.Fatalf("%s: reading response: %v", tt.path, err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if want := fmt.Sprintf(`{"path":%q,"echo":%q}`, tt.path, tt.body); string(got) != want {
+			t.Errorf("%s: response = %s, want %s", tt.path, got, want)
+		}
+		if resp.Close {
+			t.Fatalf("%s: proxy closed the keep-alive connection", tt.path)
+		}
+	}
+
+	var flows []*store.Flow
+	waitFor(t, "three completed flows", func() bool {
+		flows, _ = p.store.ListFlows(context.Background(), store.FlowFilter{})
+		if len(flows) != len(requests) {
+			return false
+		}
+		for _, f := range flows {
+			if f.StatusCode == nil {
+				return false
+			}
+		}
+		return true
+	})
+	str := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	byPath := make(map[string]*store.Flow)
+	for _, f := range flows {
+		byPath[f.Path] = f
+	}
+	for _, tt := range requests {
+		f := byPath[tt.path]
+		if f == nil {
+			t.Errorf("no flow for %s", tt.path)
+			continue
+		}
+		if gotBody := str(f.RequestBody); gotBody != tt.body {
+			t.Errorf("%s: stored request body %q, want %q", tt.path, gotBody, tt.body)
+		}
+		if !strings.Contains(str(f.ResponseBody), tt.path) {
+			t.Errorf("%s: stored response body %q belongs to another request", tt.path, str(f.ResponseBody))
+		}
+	}
+	if len(byPath) != len(requests) {
+		t.Errorf("got flows for %d distinct paths, want %d", len(byPath), len(requests))
+	}
+}
+
+// TestMITMProxy_PlainHTTPRejectedStreamCloses verifies that a request
+// rejected before its streamed body was read closes the keep-alive
+// connection, while a rejected request read whole leaves it open.
+func TestMITMProxy_PlainHTTPRejectedStreamCloses(t *testing.T) {
+	t.Parallel()
+
+	upstream := newBlockingUpstream(t)
+	_, proxyAddr, _, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+		cfg.Proxy.MaxConcurrentUpstream = 1
+		cfg.Proxy.UpstreamOverflow = UpstreamOverflowReject
+		cfg.Proxy.RequestStreamThresholdBytes = 1024
+	})
+	defer cleanup()
+
+	// Hold the only upstream slot so the next requests are rejected
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, "http://"+proxyAddr))},
+		Timeout:   10 * time.Second,
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if resp, err := client.Get(upstream.URL + "/hold"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	defer func() { <-done }()
+	defer close(upstream.release)
+	waitFor(t, "held request upstream", func() bool { return upstream.inFlight.Load() == 1 })
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	send := func(body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, upstream.URL+"/v1/messages", strings.NewReader(body))
+		// Write in the background: a rejected upload is never read to the end
+		go func() { _ = req.WriteProxy(conn) }()
+		resp, err := http.ReadResponse(reader, req)
+		if err != nil {
+			t.Fatalf("reading response: %v", err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("status = %d, want 503", resp.StatusCode)
+		}
+		return resp
+	}
+
+	if resp := send(`{"small":true}`); resp.Close {
+		t.Error("rejected request read whole closed the keep-alive connection")
+	}
+	if resp := send(strings.Repeat("x", 256*1024)); !resp.Close {
+		t.Error("rejected streamed request left the connection open mid-body")
+	}
+}
+
+// TestMITMProxy_OllamaStream verifies that a streamed NDJSON response is
+// relayed intact and its final object's counts end up on the flow.
+func TestMITMProxy_OllamaStream(t *testing.T) {
+	t.Parallel()
+
+	chunks := []string{
+		`{"model":"llama3.2","message":{"role":"assistant","content":"Hel"},"done":false}`,
+		`{"model":"llama3.2","message":{"role":"assistant","content":"lo"},"done":false}`,
+		`{"model":"llama3.2","message":{"role":"assistant","content":""},"done_reason":"stop","done":true,"prompt_eval_count":15,"eval_count":2}`,
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for _, c := range chunks {
+			_, _ = io.WriteString(w, c+"\n")
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer upstream.Close()
+
+	// Ollama's own address is fixed, so reuse its parser on the test server's
+	_, proxyAddr, capture, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+		cfg.Proxy.InterceptHosts = nil
+		cfg.Providers.Custom = []config.CustomProviderConfig{{Name: "ollama-test", Hosts: []string{strings.TrimPrefix(upstream.URL, "http://")}, Parser: "ollama"}}
+	})
+	defer cleanup()
+
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, "http://"+proxyAddr))},
+		Timeout:   5 * time.Second,
+	}
+	resp, err := client.Post(upstream.URL+"/api/chat", "application/json", strings.NewReader(`{"model":"llama3.2","messages":[]}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if want := strings.Join(chunks, "\n") + "\n"; string(body) != want {
+		t.Errorf("body = %q, want the stream relayed intact", body)
+	}
+
+	waitFor(t, "parsed flow", func() bool {
+		f := capture.Flow()
+		return f != nil && f.InputTokens != nil
+	})
+	f := capture.Flow()
+	if f.Provider != "ollama" || f.Model == nil || *f.Model != "llama3.2" || *f.InputTokens != 15 || f.OutputTokens == nil || *f.OutputTokens != 2 {
+		t.Errorf("flow provider %q, model %v, tokens %v/%v; want ollama llama3.2 15/2", f.Provider, f.Model, f.InputTokens, f.OutputTokens)
+	}
+	if f.StopReason == nil || *f.StopReason != "stop" {
+		t.Errorf("StopReason = %v, want stop", f.StopReason)
+	}
+}
+
 // TestMITMProxy_InterceptHosts_CapturesFlow verifies that a host in
 // intercept_hosts config is MITM'd and produces a captured flow with provider
 // "other" (since it's not a built-in provider).
@@ -1340,7 +1911,7 @@ func TestMITMProxy_InterceptHosts_CapturesFlow(t *testing.T) {
 	}
 	certCache := langleytls.NewCertCache(ca2, 100)
 	redactor, _ := redact.New(&config.RedactionConfig{})
-	ms := newMockStore()
+	ms := storetest.New(storetest.WithForeignKeys())
 
 	capture2 := &flowCapture{}
 	proxy, err := NewMITMProxy(MITMProxyConfig{
@@ -1457,3 +2028,139 @@ func TestMITMProxy_ShouldIntercept_Unit(t *testing.T) {
 		})
 	}
 }
+
+// TestMITMProxy_CustomProvider verifies that a provider defined in config
+// parses usage for a host no built-in provider recognises.
+func TestMITMProxy_CustomProvider(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"model":"meta-llama/Llama-3.1-8B-Instruct","choices":[],"usage":{"prompt_tokens":42,"completion_tokens":7}}`))
+	}))
+	defer upstream.Close()
+
+	_, proxyAddr, capture, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+		cfg.Providers.Custom = []config.CustomProviderConfig{
+			{Name: "vllm", Hosts: []string{"127.0.0.1"}, Parser: "openai"},
+		}
+	})
+	defer cleanup()
+
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, "http://"+proxyAddr))},
+	}
+	resp, err := client.Post(upstream.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	flow := capture.WaitForFlow(2 * time.Second)
+	if flow == nil {
+		t.Fatal("flow not captured")
+	}
+	if flow.Provider != "openai" {
+		t.Errorf("Provider = %q, want openai", flow.Provider)
+	}
+	if flow.InputTokens == nil || *flow.InputTokens != 42 || flow.OutputTokens == nil || *flow.OutputTokens != 7 {
+		t.Errorf("tokens = %v/%v, want 42/7", flow.InputTokens, flow.OutputTokens)
+	}
+	if flow.Model == nil || *flow.Model != "meta-llama/Llama-3.1-8B-Instruct" {
+		t.Errorf("Model = %v", flow.Model)
+	}
+}
+
+// TestMITMProxy_CacheTokenCost checks that a response with all four Anthropic
+// token categories is costed at the seeded per-category rates, not with cache
+// tokens lumped into input.
+func TestMITMProxy_CacheTokenCost(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"model":"claude-sonnet-4-20250514","stop_reason":"end_turn","usage":{"input_tokens":1000,"output_tokens":500,"cache_creation_input_tokens":2000,"cache_read_input_tokens":10000}}`))
+	}))
+	defer upstream.Close()
+
+	cfg := testConfig()
+	cfg.Providers.Custom = []config.CustomProviderConfig{
+		{Name: "anthropic-local", Hosts: []string{"127.0.0.1"}, Parser: "anthropic"},
+	}
+	dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer dataStore.Close()
+
+	ca, _ := langleytls.LoadOrCreateCA(t.TempDir())
+	redactor, _ := redact.New(&cfg.Redaction)
+	capture := &flowCapture{}
+	p, err := NewMITMProxy(MITMProxyConfig{
+		Config:    cfg,
+		Logger:    testLogger(),
+		CA:        ca,
+		CertCache: langleytls.NewCertCache(ca, 100),
+		Redactor:  redactor,
+		Store:     dataStore,
+		OnUpdate:  capture.OnUpdate,
+	})
+	if err != nil {
+		t.Fatalf("NewMITMProxy: %v", err)
+	}
+	proxyServer := httptest.NewServer(p)
+	defer proxyServer.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, proxyServer.URL))},
+	}
+	resp, err := client.Post(upstream.URL+"/v1/messages", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	captured := capture.WaitForFlow(2 * time.Second)
+	if captured == nil {
+		t.Fatal("flow not captured")
+	}
+	flow, err := dataStore.GetFlow(context.Background(), captured.ID)
+	if err != nil {
+		t.Fatalf("GetFlow: %v", err)
+	}
+
+	// Seeded claude-sonnet-4% rates per 1k: input 0.003, output 0.015,
+	// cache creation 0.00375, cache read 0.0003
+	want := 1000*0.003/1000 + 500*0.015/1000 + 2000*0.00375/1000 + 10000*0.0003/1000
+	if flow.TotalCost == nil {
+		t.Fatal("TotalCost not set")
+	}
+	if math.Abs(*flow.TotalCost-want) > 1e-9 {
+		t.Errorf("TotalCost = %v, want %v", *flow.TotalCost, want)
+	}
+	if flow.CostSource == nil || *flow.CostSource != "exact" {
+		t.Errorf("CostSource = %v, want exact", flow.CostSource)
+	}
+	if flow.CacheCreationTokens == nil || *flow.CacheCreationTokens != 2000 || flow.CacheReadTokens == nil || *flow.CacheReadTokens != 10000 {
+		t.Errorf("cache tokens = %v/%v, want 2000/10000", flow.CacheCreationTokens, flow.CacheReadTokens)
+	}
+}
+
+func TestNewMITMProxy_InvalidCustomProvider(t *testing.T) {
+	cfg := testConfig()
+	cfg.Providers.Custom = []config.CustomProviderConfig{
+		{Name: "vllm", Hosts: []string{"llm.internal"}, Parser: "vllm"},
+	}
+	ca, _ := langleytls.LoadOrCreateCA(t.TempDir())
+	redactor, _ := redact.New(&cfg.Redaction)
+	_, err := NewMITMProxy(MITMProxyConfig{
+		Config:    cfg,
+		Logger:    testLogger(),
+		CA:        ca,
+		CertCache: langleytls.NewCertCache(ca, 100),
+		Redactor:  redactor,
+	})
+	if err == nil || !strings.Contains(err.Error(), "unknown parser") {
+		t.Errorf("NewMITMProxy error = %v, want unknown parser", err)
+	}
+}
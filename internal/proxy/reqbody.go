@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// defaultRequestStreamThreshold is the request body size above which the
+// body is streamed upstream instead of read whole, when
+// proxy.request_stream_threshold_bytes is unset.
+const defaultRequestStreamThreshold = 8 * 1024 * 1024
+
+// requestBody is a client request body ready to forward. Bodies up to the
+// stream threshold are read whole. Larger ones keep only the first
+// threshold bytes, for storage and parsing, and stream the rest from the
+// client, so an upload never has to fit in memory.
+type requestBody struct {
+	prefix    []byte    // The whole body, or its first threshold bytes when streaming
+	streaming bool      // The body is longer than prefix
+	reader    io.Reader // Yields the whole body for forwarding
+	length    int64     // Length to forward, -1 if unknown (chunked)
+	eof       bool      // reader has returned io.EOF
+	read      int64     // Bytes reader has returned, when streaming
+}
+
+// readRequestBody reads r.Body up to the stream threshold. When streaming,
+// the caller must forward reader before reading the next request from the
+// client connection.
+func (p *MITMProxy) readRequestBody(r *http.Request) *requestBody {
+	if r.Body == nil || r.Body == http.NoBody {
+		return &requestBody{reader: bytes.NewReader(nil)}
+	}
+
+	threshold := int64(p.cfg.Proxy.RequestStreamThresholdBytes)
+	if threshold <= 0 {
+		threshold = defaultRequestStreamThreshold
+	}
+	prefix, _ := io.ReadAll(io.LimitReader(r.Body, threshold+1))
+	if int64(len(prefix)) <= threshold {
+		r.Body.Close()
+		return &requestBody{
+			prefix: prefix,
+			reader: bytes.NewReader(prefix),
+			length: int64(len(prefix)),
+		}
+	}
+
+	// Keep the threshold bytes and forward the byte past it with the rest
+	length := r.ContentLength
+	if length < 0 {
+		length = -1
+	}
+	b := &requestBody{
+		prefix:    prefix[:threshold],
+		streaming: true,
+		length:    length,
+	}
+	b.reader = &eofReader{r: io.MultiReader(bytes.NewReader(prefix), r.Body), eof: &b.eof, n: &b.read}
+	return b
+}
+
+// drained reports whether the whole body has been read from the client. A
+// streamed body that wasn't, e.g. because the request was rejected before
+// forwarding, leaves its connection mid-request.
+func (b *requestBody) drained() bool {
+	return !b.streaming || b.eof
+}
+
+// closeUnlessDrained asks the server to close a keep-alive connection whose
+// streamed body wasn't read to the end, instead of reading through the rest
+// of the upload to find the client's next request. It must be called before
+// the response is written.
+func (b *requestBody) closeUnlessDrained(w http.ResponseWriter) {
+	if !b.drained() {
+		w.Header().Set("Connection", "close")
+	}
+}
+
+// size returns the body's length as received from the client: all of a
+// streamed body once it has been forwarded, only what was read if the
+// request was rejected first.
+func (b *requestBody) size() int64 {
+	if b.streaming {
+		return b.read
+	}
+	return int64(len(b.prefix))
+}
+
+// whole returns the complete body, or nil when streaming: checks that parse
+// the whole document would misread a prefix cut off mid-way.
+func (b *requestBody) whole() []byte {
+	if b.streaming {
+		return nil
+	}
+	return b.prefix
+}
+
+// eofReader records when r reaches io.EOF and, if n is set, counts the
+// bytes read.
+type eofReader struct {
+	r   io.Reader
+	eof *bool
+	n   *int64
+}
+
+func (e *eofReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if e.n != nil {
+		*e.n += int64(n)
+	}
+	if err == io.EOF {
+		*e.eof = true
+	}
+	return n, err
+}
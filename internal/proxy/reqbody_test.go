@@ -0,0 +1,251 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/config"
+)
+
+// bodyRecorder is an upstream that records the length and hash of each
+// request body it receives.
+type bodyRecorder struct {
+	mu     sync.Mutex
+	length int
+	sum    [sha256.Size]byte
+}
+
+func (b *bodyRecorder) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		b.mu.Lock()
+		b.length = len(body)
+		b.sum = sha256.Sum256(body)
+		b.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	})
+}
+
+// TestMITMProxy_StreamsLargeRequestBody verifies that a request body over
+// the stream threshold reaches upstream whole, with or without a known
+// length, while the stored copy is cut to body_max_bytes.
+func TestMITMProxy_StreamsLargeRequestBody(t *testing.T) {
+	t.Parallel()
+
+	const (
+		threshold    = 64 * 1024
+		bodyMaxBytes = 16 * 1024
+	)
+	large := bytes.Repeat([]byte(`{"image":"iVBORw0KGgoAAAANSUhEUgAA"},`), 32*1024) // ~1.2MB
+	small := []byte(`{"model":"claude-sonnet-4-20250514","messages":[]}`)
+	setup := func(cfg *config.Config) {
+		cfg.Proxy.RequestStreamThresholdBytes = threshold
+		cfg.Persistence.BodyMaxBytes = bodyMaxBytes
+	}
+
+	tests := []struct {
+		name          string
+		body          []byte
+		chunked       bool
+		wantTruncated bool
+	}{
+		{"small body", small, false, false},
+		{"large body", large, false, true},
+		{"large chunked body", large, true, true},
+	}
+
+	for _, scheme := range []string{"http", "https"} {
+		t.Run(scheme, func(t *testing.T) {
+			t.Parallel()
+			recorder := &bodyRecorder{}
+			var upstream *httptest.Server
+			var capture *flowCapture
+			var client *http.Client
+			var target string
+			if scheme == "https" {
+				upstream = httptest.NewTLSServer(recorder.handler())
+				p, proxyAddr, c, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+					setup(cfg)
+					cfg.Proxy.UpstreamOverrides = map[string]string{"api.anthropic.com": upstream.Listener.Addr().String()}
+				})
+				defer cleanup()
+				capture = c
+				pool := x509.NewCertPool()
+				pool.AppendCertsFromPEM(p.ca.CertPEM())
+				client = &http.Client{
+					Transport: &http.Transport{
+						Proxy:           http.ProxyURL(mustParseURL(t, "http://"+proxyAddr)),
+						TLSClientConfig: &tls.Config{RootCAs: pool},
+					},
+					Timeout: 10 * time.Second,
+				}
+				target = "https://api.anthropic.com/v1/messages"
+			} else {
+				upstream = httptest.NewServer(recorder.handler())
+				_, proxyAddr, c, cleanup := setupMITMProxy(t, setup)
+				defer cleanup()
+				capture = c
+				client = &http.Client{
+					Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, "http://"+proxyAddr))},
+					Timeout:   10 * time.Second,
+				}
+				target = upstream.URL + "/v1/messages"
+			}
+			defer upstream.Close()
+
+			for _, tt := range tests {
+				var reqBody io.Reader = bytes.NewReader(tt.body)
+				if tt.chunked {
+					reqBody = io.MultiReader(reqBody) // Hides the length, so the client sends it chunked
+				}
+				req, err := http.NewRequest("POST", target, reqBody)
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.Header.Set("Content-Type", "application/json")
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatalf("%s: request failed: %v", tt.name, err)
+				}
+				respBody, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if !strings.Contains(string(respBody), `"ok":true`) {
+					t.Fatalf("%s: response = %d %s", tt.name, resp.StatusCode, respBody)
+				}
+
+				recorder.mu.Lock()
+				gotLen, gotSum := recorder.length, recorder.sum
+				recorder.mu.Unlock()
+				if gotLen != len(tt.body) || gotSum != sha256.Sum256(tt.body) {
+					t.Errorf("%s: upstream got %d bytes (hash match %v), want all %d",
+						tt.name, gotLen, gotSum == sha256.Sum256(tt.body), len(tt.body))
+				}
+
+				// The flow was announced before forwarding, so it's this request's
+				flow := capture.Flow()
+				if flow == nil || flow.RequestBody == nil {
+					t.Fatalf("%s: no stored request body", tt.name)
+				}
+				wantStored := min(len(tt.body), bodyMaxBytes)
+				if len(*flow.RequestBody) != wantStored || flow.RequestBodyTruncated != tt.wantTruncated {
+					t.Errorf("%s: stored %d bytes, truncated %v; want %d, %v",
+						tt.name, len(*flow.RequestBody), flow.RequestBodyTruncated, wantStored, tt.wantTruncated)
+				}
+				if (flow.RequestSignature == nil) != tt.wantTruncated {
+					t.Errorf("%s: RequestSignature = %v, want one only for bodies read whole", tt.name, flow.RequestSignature)
+				}
+			}
+		})
+	}
+}
+
+// TestMITMProxy_RecordsBodySizes verifies that a flow records the full
+// request and response body lengths, not the truncated stored ones.
+func TestMITMProxy_RecordsBodySizes(t *testing.T) {
+	t.Parallel()
+
+	const (
+		threshold    = 64 * 1024
+		bodyMaxBytes = 16 * 1024
+	)
+	response := []byte(`{"data":"` + strings.Repeat("x", 100*1024) + `"}`)
+	upstreamHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(response)
+	})
+
+	tests := []struct {
+		name    string
+		body    []byte
+		chunked bool
+	}{
+		{"truncated body", bytes.Repeat([]byte("a"), 32*1024), false},
+		{"streamed body", bytes.Repeat([]byte("b"), 200*1024), false},
+		{"streamed chunked body", bytes.Repeat([]byte("c"), 200*1024), true},
+	}
+
+	for _, scheme := range []string{"http", "https"} {
+		for _, tt := range tests {
+			t.Run(scheme+"/"+tt.name, func(t *testing.T) {
+				t.Parallel()
+				setup := func(cfg *config.Config) {
+					cfg.Proxy.RequestStreamThresholdBytes = threshold
+					cfg.Persistence.BodyMaxBytes = bodyMaxBytes
+				}
+				var upstream *httptest.Server
+				var capture *flowCapture
+				var client *http.Client
+				var target string
+				if scheme == "https" {
+					upstream = httptest.NewTLSServer(upstreamHandler)
+					p, proxyAddr, c, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+						setup(cfg)
+						cfg.Proxy.UpstreamOverrides = map[string]string{"api.anthropic.com": upstream.Listener.Addr().String()}
+					})
+					defer cleanup()
+					capture = c
+					pool := x509.NewCertPool()
+					pool.AppendCertsFromPEM(p.ca.CertPEM())
+					client = &http.Client{
+						Transport: &http.Transport{
+							Proxy:           http.ProxyURL(mustParseURL(t, "http://"+proxyAddr)),
+							TLSClientConfig: &tls.Config{RootCAs: pool},
+						},
+						Timeout: 10 * time.Second,
+					}
+					target = "https://api.anthropic.com/v1/messages"
+				} else {
+					upstream = httptest.NewServer(upstreamHandler)
+					_, proxyAddr, c, cleanup := setupMITMProxy(t, setup)
+					defer cleanup()
+					capture = c
+					client = &http.Client{
+						Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, "http://"+proxyAddr))},
+						Timeout:   10 * time.Second,
+					}
+					target = upstream.URL + "/v1/messages"
+				}
+				defer upstream.Close()
+
+				var reqBody io.Reader = bytes.NewReader(tt.body)
+				if tt.chunked {
+					reqBody = io.MultiReader(reqBody) // Hides the length, so the client sends it chunked
+				}
+				resp, err := client.Post(target, "application/json", reqBody)
+				if err != nil {
+					t.Fatalf("request failed: %v", err)
+				}
+				got, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if !bytes.Equal(got, response) {
+					t.Fatalf("client got %d response bytes, want %d", len(got), len(response))
+				}
+
+				flow := capture.WaitForUpdate(2 * time.Second)
+				if flow == nil {
+					t.Fatal("no completed flow")
+				}
+				if !flow.RequestBodyTruncated || !flow.ResponseBodyTruncated {
+					t.Errorf("stored bodies truncated = %v/%v, want both", flow.RequestBodyTruncated, flow.ResponseBodyTruncated)
+				}
+				if flow.RequestBodySize == nil || *flow.RequestBodySize != int64(len(tt.body)) {
+					t.Errorf("RequestBodySize = %v, want %d", flow.RequestBodySize, len(tt.body))
+				}
+				if flow.ResponseBodySize == nil || *flow.ResponseBodySize != int64(len(response)) {
+					t.Errorf("ResponseBodySize = %v, want %d", flow.ResponseBodySize, len(response))
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// volatileBodyFields are top-level request body fields that can differ
+// between retries of the same request, and so are left out of signatures.
+var volatileBodyFields = []string{"metadata", "user", "request_id"}
+
+// requestSignature returns a stable hash identifying a request, so retries
+// and repeated identical requests share a signature. JSON bodies are
+// normalized (key order and whitespace ignored, volatile fields removed);
+// other bodies are hashed as-is.
+func requestSignature(method, host, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(host))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(normalizeBody(body))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// normalizeBody re-encodes a JSON object body without volatile fields.
+// encoding/json sorts map keys, so equal objects encode identically.
+func normalizeBody(body []byte) []byte {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return body
+	}
+	for _, field := range volatileBodyFields {
+		delete(obj, field)
+	}
+	normalized, err := json.Marshal(obj)
+	if err != nil {
+		return body
+	}
+	return normalized
+}
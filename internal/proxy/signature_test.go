@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/redact"
+	"github.com/HakAl/langley/internal/store"
+	langleytls "github.com/HakAl/langley/internal/tls"
+)
+
+func TestRequestSignature(t *testing.T) {
+	base := requestSignature("POST", "api.anthropic.com", "/v1/messages",
+		[]byte(`{"model":"claude-sonnet-4","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`))
+
+	same := []struct {
+		name string
+		body string
+	}{
+		{"key order and whitespace", `{ "messages": [{"content": "hi", "role": "user"}], "max_tokens": 100, "model": "claude-sonnet-4" }`},
+		{"volatile fields", `{"model":"claude-sonnet-4","max_tokens":100,"messages":[{"role":"user","content":"hi"}],"metadata":{"user_id":"u-42"},"request_id":"r-1"}`},
+	}
+	for _, tt := range same {
+		if got := requestSignature("POST", "api.anthropic.com", "/v1/messages", []byte(tt.body)); got != base {
+			t.Errorf("%s: signature differs", tt.name)
+		}
+	}
+
+	different := []struct {
+		name               string
+		method, host, path string
+		body               string
+	}{
+		{"body", "POST", "api.anthropic.com", "/v1/messages", `{"model":"claude-sonnet-4","max_tokens":100,"messages":[{"role":"user","content":"bye"}]}`},
+		{"path", "POST", "api.anthropic.com", "/v1/complete", `{"model":"claude-sonnet-4","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`},
+		{"host", "POST", "api.openai.com", "/v1/messages", `{"model":"claude-sonnet-4","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`},
+	}
+	for _, tt := range different {
+		if got := requestSignature(tt.method, tt.host, tt.path, []byte(tt.body)); got == base {
+			t.Errorf("%s change: signature unchanged", tt.name)
+		}
+	}
+
+	// Non-JSON bodies are hashed as-is
+	if requestSignature("POST", "h", "/p", []byte("a b")) == requestSignature("POST", "h", "/p", []byte("a  b")) {
+		t.Error("non-JSON bodies with different bytes share a signature")
+	}
+}
+
+// TestMITMProxy_DuplicateRequests sends the same request twice through the
+// proxy and checks that both flows are stored with one signature and are
+// reported as a duplicate group.
+func TestMITMProxy_DuplicateRequests(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"id":"msg_1","type":"message"}`)
+	}))
+	defer upstream.Close()
+
+	cfg := testConfig()
+	dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer dataStore.Close()
+
+	ca, _ := langleytls.LoadOrCreateCA(t.TempDir())
+	redactor, _ := redact.New(&cfg.Redaction)
+	p, err := NewMITMProxy(MITMProxyConfig{
+		Config:    cfg,
+		Logger:    testLogger(),
+		CA:        ca,
+		CertCache: langleytls.NewCertCache(ca, 100),
+		Redactor:  redactor,
+		Store:     dataStore,
+	})
+	if err != nil {
+		t.Fatalf("NewMITMProxy: %v", err)
+	}
+	proxyServer := httptest.NewServer(p)
+	defer proxyServer.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, proxyServer.URL))},
+	}
+	bodies := []string{
+		`{"model":"claude-sonnet-4","messages":[{"role":"user","content":"hi"}],"metadata":{"user_id":"a"}}`,
+		`{"messages":[{"role":"user","content":"hi"}],"model":"claude-sonnet-4","metadata":{"user_id":"b"}}`,
+		`{"model":"claude-sonnet-4","messages":[{"role":"user","content":"other"}]}`,
+	}
+	for _, body := range bodies {
+		resp, err := client.Post(upstream.URL+"/v1/messages", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	ctx := context.Background()
+	var flows []*store.Flow
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		flows, err = dataStore.ListFlows(ctx, store.FlowFilter{})
+		if err != nil {
+			t.Fatalf("ListFlows: %v", err)
+		}
+		if len(flows) == len(bodies) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(flows) != len(bodies) {
+		t.Fatalf("stored %d flows, want %d", len(flows), len(bodies))
+	}
+
+	signatures := map[string]int{}
+	for _, f := range flows {
+		if f.RequestSignature == nil {
+			t.Fatalf("flow %s has no request signature", f.ID)
+		}
+		signatures[*f.RequestSignature]++
+	}
+	if len(signatures) != 2 {
+		t.Errorf("got %d distinct signatures, want 2", len(signatures))
+	}
+
+	groups, err := p.analytics.FindDuplicateRequests(ctx, time.Now().Add(-time.Minute), time.Now().Add(time.Minute), 10)
+	if err != nil {
+		t.Fatalf("FindDuplicateRequests: %v", err)
+	}
+	if len(groups) != 1 || groups[0].Count != 2 {
+		t.Errorf("groups = %+v, want one group of 2", groups)
+	}
+}
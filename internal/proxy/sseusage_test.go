@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/config"
+)
+
+func TestMITMProxy_SSEUsageAfterBodyTruncation(t *testing.T) {
+	t.Parallel()
+
+	const deltas = 200
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		send := func(eventType, data string) {
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, data)
+			w.(http.Flusher).Flush()
+		}
+		send("message_start", `{"type":"message_start","message":{"model":"claude-sonnet-4-20250514","usage":{"input_tokens":25,"cache_read_input_tokens":5}}}`)
+		for i := range deltas {
+			send("content_block_delta", fmt.Sprintf(`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"%d %s"}}`, i, strings.Repeat("x", 200)))
+		}
+		send("message_delta", `{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":777}}`)
+		send("message_stop", `{"type":"message_stop"}`)
+	}))
+	defer upstream.Close()
+
+	const maxBody = 4096
+	p, proxyAddr, capture, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+		cfg.Persistence.BodyMaxBytes = maxBody
+		cfg.Proxy.UpstreamOverrides = map[string]string{"api.anthropic.com": upstream.Listener.Addr().String()}
+	})
+	defer cleanup()
+
+	resp, err := tunnelPerRequestClient(t, p, proxyAddr).Post("https://api.anthropic.com/v1/messages",
+		"application/json", strings.NewReader(`{"model":"claude-sonnet-4-20250514","stream":true}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("reading stream: %v", err)
+	}
+
+	// The client gets the whole stream even though storage stops at maxBody
+	if n := strings.Count(string(body), "event: content_block_delta"); n != deltas {
+		t.Errorf("client got %d deltas, want %d", n, deltas)
+	}
+	if !strings.Contains(string(body), "event: message_stop") {
+		t.Error("client stream is missing message_stop")
+	}
+
+	flow := capture.WaitForFlow(5 * time.Second)
+	if flow == nil {
+		t.Fatal("no flow captured")
+	}
+	if !flow.ResponseBodyTruncated || flow.ResponseBody == nil || len(*flow.ResponseBody) > maxBody {
+		t.Errorf("stored body should be truncated to %d bytes, got truncated=%v", maxBody, flow.ResponseBodyTruncated)
+	}
+	if strings.Contains(*flow.ResponseBody, "message_delta") {
+		t.Fatal("test stream too short: the stored body still has the usage event")
+	}
+
+	// Usage from both ends of the stream
+	if flow.Model == nil || *flow.Model != "claude-sonnet-4-20250514" {
+		t.Errorf("Model = %v, want claude-sonnet-4-20250514", flow.Model)
+	}
+	if flow.InputTokens == nil || *flow.InputTokens != 25 || flow.CacheReadTokens == nil || *flow.CacheReadTokens != 5 {
+		t.Errorf("InputTokens = %v, CacheReadTokens = %v; want 25, 5", flow.InputTokens, flow.CacheReadTokens)
+	}
+	if flow.OutputTokens == nil || *flow.OutputTokens != 777 {
+		t.Errorf("OutputTokens = %v, want 777 from the trailing message_delta", flow.OutputTokens)
+	}
+	if flow.StopReason == nil || *flow.StopReason != "end_turn" {
+		t.Errorf("StopReason = %v, want end_turn", flow.StopReason)
+	}
+}
@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"encoding/json"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+// requestStream returns the "stream" field of a JSON request body, and
+// whether the body has one.
+func requestStream(body []byte) (stream, ok bool) {
+	var req struct {
+		Stream *bool `json:"stream"`
+	}
+	if json.Unmarshal(body, &req) != nil || req.Stream == nil {
+		return false, false
+	}
+	return *req.Stream, true
+}
+
+// checkStreamMismatch sets flow.StreamMismatch when a successful response
+// disagrees with the request's "stream" field: streaming was asked for but
+// the response isn't an event stream, or the other way round. This usually
+// means a client or proxy in between is misconfigured, and the response
+// won't parse the way the client expects. Requests without a "stream" field
+// (providers that pick streaming by path) and error responses, which are
+// JSON either way, are not checked.
+func (p *MITMProxy) checkStreamMismatch(flow *store.Flow, reqBody []byte) {
+	if flow.StatusCode == nil || *flow.StatusCode < 200 || *flow.StatusCode >= 300 {
+		return
+	}
+	stream, ok := requestStream(reqBody)
+	if !ok || stream == flow.IsSSE {
+		return
+	}
+
+	flow.StreamMismatch = true
+	p.logger.Warn("response streaming doesn't match the request",
+		"flow_id", flow.ID, "host", flow.Host, "path", flow.Path,
+		"request_stream", stream, "response_stream", flow.IsSSE)
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMITMProxy_StreamMismatch(t *testing.T) {
+	t.Parallel()
+
+	// The upstream streams on /sse and answers JSON anywhere else
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sse":
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+		case "/error":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"type":"error"}`)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"type":"message"}`)
+		}
+	}))
+	t.Cleanup(upstream.Close) // Outlives the parallel subtests
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		want bool
+	}{
+		{"stream requested, JSON returned", "/json", `{"model":"claude-sonnet-4","stream":true}`, true},
+		{"no stream requested, event stream returned", "/sse", `{"model":"claude-sonnet-4","stream":false}`, true},
+		{"stream requested and returned", "/sse", `{"model":"claude-sonnet-4","stream":true}`, false},
+		{"no stream requested or returned", "/json", `{"model":"claude-sonnet-4","stream":false}`, false},
+		{"no stream field", "/sse", `{"model":"claude-sonnet-4"}`, false},
+		{"error response", "/error", `{"model":"claude-sonnet-4","stream":true}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, proxyAddr, capture, cleanup := setupMITMProxy(t, nil)
+			defer cleanup()
+
+			client := &http.Client{
+				Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, "http://"+proxyAddr))},
+				Timeout:   5 * time.Second,
+			}
+			req, _ := http.NewRequest("POST", upstream.URL+tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			flow := capture.WaitForUpdate(2 * time.Second)
+			if flow == nil {
+				t.Fatal("flow was not completed")
+			}
+			if flow.StreamMismatch != tt.want {
+				t.Errorf("StreamMismatch = %v, want %v (is_sse %v)", flow.StreamMismatch, tt.want, flow.IsSSE)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/HakAl/langley/internal/analytics"
+)
+
+// throughputWindow is how far back Throughput looks, in one-second
+// buckets.
+const throughputWindow = 60
+
+// throughputBucket holds the requests answered in one second.
+type throughputBucket struct {
+	second  int64 // Unix second the bucket holds; stale buckets are reset
+	count   int
+	latency time.Duration
+}
+
+// throughputTracker keeps a rolling minute of forwarding latencies.
+type throughputTracker struct {
+	mu      sync.Mutex
+	buckets [throughputWindow]throughputBucket
+}
+
+// record adds a request answered at now after latency.
+func (t *throughputTracker) record(now time.Time, latency time.Duration) {
+	sec := now.Unix()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := &t.buckets[sec%throughputWindow]
+	if b.second != sec {
+		*b = throughputBucket{second: sec}
+	}
+	b.count++
+	b.latency += latency
+}
+
+// snapshot returns the request count and average latency of the minute
+// before now.
+func (t *throughputTracker) snapshot(now time.Time) (count int, avgLatencyMs float64) {
+	oldest := now.Unix() - throughputWindow
+	var total time.Duration
+	t.mu.Lock()
+	for _, b := range t.buckets {
+		if b.second > oldest {
+			count += b.count
+			total += b.latency
+		}
+	}
+	t.mu.Unlock()
+	if count > 0 {
+		avgLatencyMs = float64(total.Microseconds()) / 1000 / float64(count)
+	}
+	return count, avgLatencyMs
+}
+
+// recordForward notes that a request received at start got its upstream
+// response headers.
+func (p *MITMProxy) recordForward(start time.Time) {
+	now := time.Now()
+	p.throughput.record(now, now.Sub(start))
+}
+
+// Throughput returns the proxy's in-flight request count and forwarding
+// rate and latency over the last minute.
+func (p *MITMProxy) Throughput() analytics.ThroughputStats {
+	p.flowsMu.Lock()
+	inFlight := len(p.flows)
+	p.flowsMu.Unlock()
+	count, avg := p.throughput.snapshot(time.Now())
+	return analytics.ThroughputStats{InFlight: inFlight, RequestsLastMinute: count, AvgLatencyMs: avg}
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/analytics"
+)
+
+func TestThroughputTracker_RollingWindow(t *testing.T) {
+	var tr throughputTracker
+	now := time.Unix(1_700_000_000, 0)
+
+	if count, avg := tr.snapshot(now); count != 0 || avg != 0 {
+		t.Fatalf("empty tracker = %d requests, %vms; want zero", count, avg)
+	}
+
+	tr.record(now, 100*time.Millisecond)
+	tr.record(now, 300*time.Millisecond)
+	tr.record(now.Add(30*time.Second), 50*time.Millisecond)
+	if count, avg := tr.snapshot(now.Add(30 * time.Second)); count != 3 || avg != 150 {
+		t.Errorf("after 3 requests = %d requests, avg %vms; want 3, 150ms", count, avg)
+	}
+
+	// The first two requests age out of the minute
+	if count, avg := tr.snapshot(now.Add(61 * time.Second)); count != 1 || avg != 50 {
+		t.Errorf("a minute later = %d requests, avg %vms; want 1, 50ms", count, avg)
+	}
+
+	// A request a whole window later reuses the old bucket
+	tr.record(now.Add(120*time.Second), 10*time.Millisecond)
+	if count, avg := tr.snapshot(now.Add(120 * time.Second)); count != 1 || avg != 10 {
+		t.Errorf("two minutes later = %d requests, avg %vms; want 1, 10ms", count, avg)
+	}
+}
+
+func TestMITMProxy_Throughput(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer upstream.Close()
+
+	p, proxyAddr, capture, cleanup := setupMITMProxy(t, nil)
+	defer cleanup()
+
+	if got := p.Throughput(); got != (analytics.ThroughputStats{}) {
+		t.Errorf("before any requests: %+v, want zero", got)
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, "http://"+proxyAddr))},
+		Timeout:   5 * time.Second,
+	}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(upstream.URL + "/v1/models")
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+	if capture.WaitForUpdate(2*time.Second) == nil {
+		t.Fatal("flow was not completed")
+	}
+
+	got := p.Throughput()
+	if got.RequestsLastMinute != 3 {
+		t.Errorf("RequestsLastMinute = %d, want 3", got.RequestsLastMinute)
+	}
+	if got.AvgLatencyMs < 20 {
+		t.Errorf("AvgLatencyMs = %v, want at least the upstream's 20ms", got.AvgLatencyMs)
+	}
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/redact"
+	"github.com/HakAl/langley/internal/store"
+	langleytls "github.com/HakAl/langley/internal/tls"
+)
+
+// TestToolResultLatency_AcrossFlows streams a tool_use in one flow and sends
+// its tool_result in later ones, checking the latency recorded in between.
+func TestToolResultLatency_AcrossFlows(t *testing.T) {
+	t.Parallel()
+
+	const streamTail = 300 * time.Millisecond // stream keeps going after the tool_use closes
+	const toolRun = 200 * time.Millisecond    // client "runs the tool" after the stream ends
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stream" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"type":"message","content":[{"type":"text","text":"ok"}]}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher := w.(http.Flusher)
+		fmt.Fprint(w, "event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"tool_use\",\"id\":\"toolu_latency\",\"name\":\"Bash\",\"input\":{}}}\n\n")
+		fmt.Fprint(w, "event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":0}\n\n")
+		flusher.Flush()
+		time.Sleep(streamTail)
+		fmt.Fprint(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+	}))
+	defer upstream.Close()
+
+	cfg := testConfig()
+	dataStore, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer dataStore.Close()
+
+	ca, _ := langleytls.LoadOrCreateCA(t.TempDir())
+	redactor, _ := redact.New(&cfg.Redaction)
+	p, err := NewMITMProxy(MITMProxyConfig{
+		Config:    cfg,
+		Logger:    testLogger(),
+		CA:        ca,
+		CertCache: langleytls.NewCertCache(ca, 100),
+		Redactor:  redactor,
+		Store:     dataStore,
+	})
+	if err != nil {
+		t.Fatalf("NewMITMProxy: %v", err)
+	}
+	proxyServer := httptest.NewServer(p)
+	defer proxyServer.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, proxyServer.URL))},
+	}
+	post := func(path, body string) {
+		t.Helper()
+		resp, err := client.Post(upstream.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	toolResultBody := `{"messages":[{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_latency","content":"done"}]}]}`
+
+	post("/stream", `{"stream":true}`)
+	time.Sleep(toolRun)
+	post("/next", toolResultBody)
+
+	ctx := context.Background()
+	invocations, _, err := dataStore.ListToolInvocations(ctx, "Bash", time.Now().Add(-time.Minute), time.Now(), 10, 0)
+	if err != nil {
+		t.Fatalf("ListToolInvocations: %v", err)
+	}
+	if len(invocations) != 1 {
+		t.Fatalf("got %d invocations, want 1", len(invocations))
+	}
+	inv := invocations[0]
+	if inv.DurationMs == nil {
+		t.Fatal("DurationMs not set after tool_result")
+	}
+	// Measured from the end of the tool_use block, not the end of the flow
+	latency := time.Duration(*inv.DurationMs) * time.Millisecond
+	if min := streamTail + toolRun - 50*time.Millisecond; latency < min {
+		t.Errorf("latency = %v, want at least %v", latency, min)
+	}
+	if latency > 5*time.Second {
+		t.Errorf("latency = %v, unreasonably large", latency)
+	}
+	if inv.Success == nil || !*inv.Success {
+		t.Errorf("Success = %v, want true", inv.Success)
+	}
+
+	// Later requests carry the same tool_result as history and must not
+	// stretch the recorded latency
+	time.Sleep(100 * time.Millisecond)
+	post("/next", toolResultBody)
+
+	invocations, _, _ = dataStore.ListToolInvocations(ctx, "Bash", time.Now().Add(-time.Minute), time.Now(), 10, 0)
+	if got := *invocations[0].DurationMs; got != *inv.DurationMs {
+		t.Errorf("DurationMs changed from %d to %d on repeated tool_result", *inv.DurationMs, got)
+	}
+}
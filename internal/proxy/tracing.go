@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/HakAl/langley/internal/store"
+	"github.com/HakAl/langley/internal/telemetry"
+)
+
+// startSpan starts the flow's span as a child of the client's traceparent,
+// if it sent one, and points the upstream request's traceparent at it.
+// Returns nil when tracing is off.
+func (p *MITMProxy) startSpan(r *http.Request) *telemetry.Span {
+	if p.tracer == nil {
+		return nil
+	}
+	parent, _ := telemetry.ParseTraceparent(r.Header.Get(telemetry.TraceparentHeader))
+	return p.tracer.Start(r.Method, parent)
+}
+
+// propagateSpan sets the upstream request's traceparent to span.
+func propagateSpan(span *telemetry.Span, outReq *http.Request) {
+	if span == nil {
+		return
+	}
+	outReq.Header.Set(telemetry.TraceparentHeader, span.Context().Traceparent())
+}
+
+// endSpan records what's known about the flow on its span and ends it.
+// Attribute names follow the OpenTelemetry HTTP and GenAI conventions.
+func endSpan(span *telemetry.Span, flow *store.Flow) {
+	if span == nil {
+		return
+	}
+	span.SetAttribute("http.request.method", flow.Method)
+	span.SetAttribute("server.address", flow.Host)
+	span.SetAttribute("url.path", flow.Path)
+	span.SetAttribute("gen_ai.system", flow.Provider)
+	span.SetAttribute("langley.flow_id", flow.ID)
+	span.SetAttribute("langley.flow_integrity", flow.FlowIntegrity)
+	if flow.TaskID != nil {
+		span.SetAttribute("langley.task_id", *flow.TaskID)
+	}
+	if flow.Model != nil {
+		span.SetAttribute("gen_ai.request.model", *flow.Model)
+	}
+	if flow.InputTokens != nil {
+		span.SetAttribute("gen_ai.usage.input_tokens", *flow.InputTokens)
+	}
+	if flow.OutputTokens != nil {
+		span.SetAttribute("gen_ai.usage.output_tokens", *flow.OutputTokens)
+	}
+	if flow.StatusCode != nil {
+		span.SetAttribute("http.response.status_code", *flow.StatusCode)
+		if *flow.StatusCode >= 500 {
+			span.SetError(http.StatusText(*flow.StatusCode))
+		}
+	}
+	if flow.FlowIntegrity == "interrupted" {
+		span.SetError("flow interrupted")
+	}
+	span.End()
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/config"
+	"github.com/HakAl/langley/internal/telemetry"
+)
+
+func TestMITMProxy_TracingSpan(t *testing.T) {
+	t.Parallel()
+
+	gotTraceparent := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTraceparent <- r.Header.Get("traceparent")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"model":"gpt-4o","choices":[],"usage":{"prompt_tokens":42,"completion_tokens":7}}`))
+	}))
+	defer upstream.Close()
+
+	p, proxyAddr, capture, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+		cfg.Providers.Custom = []config.CustomProviderConfig{
+			{Name: "vllm", Hosts: []string{"127.0.0.1"}, Parser: "openai"},
+		}
+	})
+	defer cleanup()
+	exporter := &telemetry.InMemoryExporter{}
+	tracer := telemetry.NewTracer(exporter, testLogger())
+	p.tracer = tracer
+
+	const clientTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req, _ := http.NewRequest("POST", upstream.URL+"/v1/chat/completions", strings.NewReader(`{}`))
+	req.Header.Set("traceparent", clientTraceparent)
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, "http://"+proxyAddr))},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	flow := capture.WaitForFlow(2 * time.Second)
+	if flow == nil {
+		t.Fatal("flow not captured")
+	}
+
+	// The span ends after the flow is saved, so flush until it shows up
+	var spans []*telemetry.SpanData
+	deadline := time.Now().Add(2 * time.Second)
+	for len(spans) == 0 && time.Now().Before(deadline) {
+		if err := tracer.Flush(context.Background()); err != nil {
+			t.Fatalf("Flush: %v", err)
+		}
+		spans = exporter.Spans()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if len(spans) != 1 {
+		t.Fatalf("exported %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+
+	parent, _ := telemetry.ParseTraceparent(clientTraceparent)
+	if span.SpanContext.TraceID != parent.TraceID {
+		t.Error("span did not join the client's trace")
+	}
+	if span.Parent.SpanID != parent.SpanID {
+		t.Error("span parent is not the client's span")
+	}
+	if got, want := <-gotTraceparent, span.SpanContext.Traceparent(); got != want {
+		t.Errorf("upstream traceparent = %q, want %q", got, want)
+	}
+
+	want := map[string]interface{}{
+		"http.request.method":        "POST",
+		"server.address":             upstream.Listener.Addr().String(),
+		"gen_ai.system":              "openai",
+		"gen_ai.request.model":       "gpt-4o",
+		"http.response.status_code":  200,
+		"gen_ai.usage.input_tokens":  42,
+		"gen_ai.usage.output_tokens": 7,
+		"langley.flow_id":            flow.ID,
+	}
+	for k, v := range want {
+		if span.Attributes[k] != v {
+			t.Errorf("attribute %s = %v, want %v", k, span.Attributes[k], v)
+		}
+	}
+	if span.Error {
+		t.Error("successful flow marked as error")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,12 +13,13 @@ const defaultIdleTimeout = 5 * time.Minute
 
 // tunnel copies data bidirectionally between clientConn and upstreamConn.
 // Either side closing or going idle (no reads for idleTimeout) tears down both.
-func tunnel(clientConn, upstreamConn net.Conn, logger *slog.Logger, host string) {
-	tunnelWithTimeout(clientConn, upstreamConn, logger, host, defaultIdleTimeout)
+// It returns the bytes copied client -> upstream (up) and upstream -> client (down).
+func tunnel(clientConn, upstreamConn net.Conn, logger *slog.Logger, host string) (up, down int64) {
+	return tunnelWithTimeout(clientConn, upstreamConn, logger, host, defaultIdleTimeout)
 }
 
 // tunnelWithTimeout is the testable core that accepts an explicit idle timeout.
-func tunnelWithTimeout(clientConn, upstreamConn net.Conn, logger *slog.Logger, host string, idleTimeout time.Duration) {
+func tunnelWithTimeout(clientConn, upstreamConn net.Conn, logger *slog.Logger, host string, idleTimeout time.Duration) (up, down int64) {
 	logger.Debug("tunnel established", "host", host)
 
 	var once sync.Once
@@ -35,35 +37,58 @@ func tunnelWithTimeout(clientConn, upstreamConn net.Conn, logger *slog.Logger, h
 	// client -> upstream
 	go func() {
 		defer wg.Done()
-		copyWithIdleTimeout(upstreamConn, clientConn, idleTimeout)
+		up = copyWithIdleTimeout(upstreamConn, clientConn, idleTimeout)
 		closeAll()
 	}()
 
 	// upstream -> client
 	go func() {
 		defer wg.Done()
-		copyWithIdleTimeout(clientConn, upstreamConn, idleTimeout)
+		down = copyWithIdleTimeout(clientConn, upstreamConn, idleTimeout)
 		closeAll()
 	}()
 
 	wg.Wait()
+	return up, down
 }
 
 // copyWithIdleTimeout copies from src to dst, resetting a read deadline on src
 // after every successful read. If no data arrives within idleTimeout, the copy
-// stops and the caller tears down both sides.
-func copyWithIdleTimeout(dst io.Writer, src net.Conn, idleTimeout time.Duration) {
+// stops and the caller tears down both sides. It returns the bytes written to dst.
+func copyWithIdleTimeout(dst io.Writer, src net.Conn, idleTimeout time.Duration) (written int64) {
 	buf := make([]byte, 32*1024)
 	for {
 		_ = src.SetReadDeadline(time.Now().Add(idleTimeout))
 		n, err := src.Read(buf)
 		if n > 0 {
-			if _, wErr := dst.Write(buf[:n]); wErr != nil {
-				return
+			w, wErr := dst.Write(buf[:n])
+			written += int64(w)
+			if wErr != nil {
+				return written
 			}
 		}
 		if err != nil {
-			return
+			return written
 		}
 	}
 }
+
+// countingConn counts bytes read from and written to a connection.
+// Used to measure intercepted tunnels, whose traffic isn't copied by tunnel().
+type countingConn struct {
+	net.Conn
+	read    atomic.Int64
+	written atomic.Int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(int64(n))
+	return n, err
+}
@@ -1,11 +1,26 @@
 package proxy
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"io"
 	"log/slog"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/HakAl/langley/internal/redact"
+	"github.com/HakAl/langley/internal/store"
+	"github.com/HakAl/langley/internal/store/storetest"
+	langleytls "github.com/HakAl/langley/internal/tls"
 )
 
 func testTunnelLogger() *slog.Logger {
@@ -112,3 +127,183 @@ func TestTunnel_IdleTimeout(t *testing.T) {
 		t.Error("upstream write should fail after tunnel timeout")
 	}
 }
+
+// TestMITMProxy_PassthroughTunnelRecorded checks that a passthrough CONNECT,
+// which captures no flow, still lands in the tunnel log with its byte counts.
+func TestMITMProxy_PassthroughTunnelRecorded(t *testing.T) {
+	t.Parallel()
+
+	const upBytes, downBytes = 100, 250
+
+	// Upstream reads the client's bytes, replies, and hangs up
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.ReadFull(conn, make([]byte, upBytes))
+		_, _ = conn.Write(bytes.Repeat([]byte("d"), downBytes))
+	}()
+
+	cfg := testConfig()
+	cfg.Proxy.InterceptHosts = nil
+	ca, _ := langleytls.LoadOrCreateCA(t.TempDir())
+	redactor, _ := redact.New(&cfg.Redaction)
+	mock := storetest.New(storetest.WithForeignKeys())
+	p, err := NewMITMProxy(MITMProxyConfig{
+		Config:    cfg,
+		Logger:    testLogger(),
+		CA:        ca,
+		CertCache: langleytls.NewCertCache(ca, 100),
+		Redactor:  redactor,
+		Store:     mock,
+	})
+	if err != nil {
+		t.Fatalf("NewMITMProxy: %v", err)
+	}
+	proxyServer := httptest.NewServer(p)
+	defer proxyServer.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxyServer.URL, "http://"))
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	defer conn.Close()
+
+	target := ln.Addr().String()
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT failed: %v %v", resp, err)
+	}
+
+	if _, err := conn.Write(bytes.Repeat([]byte("u"), upBytes)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	got, _ := io.ReadAll(br)
+	if len(got) != downBytes {
+		t.Fatalf("read %d bytes from tunnel, want %d", len(got), downBytes)
+	}
+
+	// The tunnel is recorded after both directions finish
+	var tunnels []*store.Tunnel
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if tunnels, _ = mock.ListTunnels(context.Background(), 10); len(tunnels) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(tunnels) != 1 {
+		t.Fatalf("got %d tunnels recorded, want 1", len(tunnels))
+	}
+	tun := tunnels[0]
+	if tun.Mode != store.TunnelModePassthrough {
+		t.Errorf("mode = %q, want passthrough", tun.Mode)
+	}
+	if tun.Host != target {
+		t.Errorf("host = %q, want %q", tun.Host, target)
+	}
+	if tun.BytesUp != upBytes || tun.BytesDown != downBytes {
+		t.Errorf("bytes up/down = %d/%d, want %d/%d", tun.BytesUp, tun.BytesDown, upBytes, downBytes)
+	}
+	if tun.EndedAt.Before(tun.StartedAt) {
+		t.Errorf("ended %v before started %v", tun.EndedAt, tun.StartedAt)
+	}
+	if n, _ := mock.CountFlows(context.Background(), store.FlowFilter{}); n != 0 {
+		t.Errorf("passthrough should capture no flows, got %d", n)
+	}
+}
+
+// TestMITMProxy_InterceptedConnIdleTimeout checks that an intercepted
+// keep-alive connection left idle after a request is closed, along with its
+// upstream, once proxy.tls_idle_timeout passes.
+func TestMITMProxy_InterceptedConnIdleTimeout(t *testing.T) {
+	t.Parallel()
+
+	var upstreamClosed atomic.Bool
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	upstream.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			upstreamClosed.Store(true)
+		}
+	}
+	upstream.StartTLS()
+	defer upstream.Close()
+	target := strings.TrimPrefix(upstream.URL, "https://")
+
+	cfg := testConfig()
+	cfg.Proxy.InterceptHosts = []string{"127.0.0.1"}
+	cfg.Proxy.TLSIdleTimeout = 200 * time.Millisecond
+	ca, _ := langleytls.LoadOrCreateCA(t.TempDir())
+	redactor, _ := redact.New(&cfg.Redaction)
+	p, err := NewMITMProxy(MITMProxyConfig{
+		Config:                     cfg,
+		Logger:                     testLogger(),
+		CA:                         ca,
+		CertCache:                  langleytls.NewCertCache(ca, 100),
+		Redactor:                   redactor,
+		Store:                      storetest.New(storetest.WithForeignKeys()),
+		InsecureSkipVerifyUpstream: true,
+	})
+	if err != nil {
+		t.Fatalf("NewMITMProxy: %v", err)
+	}
+	proxyServer := httptest.NewServer(p)
+	defer proxyServer.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxyServer.URL, "http://"))
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT failed: %v %v", resp, err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.CertPEM())
+	tlsConn := tls.Client(conn, &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"})
+	br := bufio.NewReader(tlsConn)
+
+	// One request on the keep-alive connection
+	fmt.Fprintf(tlsConn, "POST /v1/messages HTTP/1.1\r\nHost: %s\r\nContent-Length: 2\r\n\r\n{}", target)
+	resp, err = http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// Then go quiet: the proxy should hang up
+	started := time.Now()
+	_ = tlsConn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := br.ReadByte(); err == nil {
+		t.Fatal("expected connection to be closed, read a byte")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("idle connection was not closed by the proxy")
+	}
+	if elapsed := time.Since(started); elapsed < 150*time.Millisecond {
+		t.Errorf("connection closed after %v, before the idle timeout", elapsed)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !upstreamClosed.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("upstream connection not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
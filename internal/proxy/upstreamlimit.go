@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/HakAl/langley/internal/analytics"
+	"github.com/HakAl/langley/internal/config"
+	"github.com/HakAl/langley/internal/store"
+)
+
+// Upstream overflow modes for proxy.upstream_overflow.
+const (
+	UpstreamOverflowQueue  = "queue"
+	UpstreamOverflowReject = "reject"
+)
+
+// errUpstreamBusy is returned by Acquire when no slot is free and the
+// request was rejected or timed out in the queue.
+var errUpstreamBusy = errors.New("too many concurrent upstream requests")
+
+// UpstreamLimiter caps how many requests are forwarded upstream at once.
+// Requests over the cap wait for a slot or get a 503, depending on the
+// overflow mode. A nil limiter admits everything.
+type UpstreamLimiter struct {
+	slots        chan struct{}
+	reject       bool
+	queueTimeout time.Duration
+
+	mu       sync.Mutex
+	waiting  int
+	queued   int64
+	rejected int64
+}
+
+// NewUpstreamLimiter creates a limiter from the proxy config. It returns
+// nil when max_concurrent_upstream is 0 (unlimited).
+func NewUpstreamLimiter(cfg config.ProxyConfig) (*UpstreamLimiter, error) {
+	if cfg.MaxConcurrentUpstream < 0 {
+		return nil, fmt.Errorf("proxy.max_concurrent_upstream must not be negative, got %d", cfg.MaxConcurrentUpstream)
+	}
+	if cfg.UpstreamQueueTimeout < 0 {
+		return nil, fmt.Errorf("proxy.upstream_queue_timeout must not be negative, got %s", cfg.UpstreamQueueTimeout)
+	}
+	var reject bool
+	switch cfg.UpstreamOverflow {
+	case "", UpstreamOverflowQueue:
+	case UpstreamOverflowReject:
+		reject = true
+	default:
+		return nil, fmt.Errorf("proxy.upstream_overflow must be %q or %q, got %q",
+			UpstreamOverflowQueue, UpstreamOverflowReject, cfg.UpstreamOverflow)
+	}
+	if cfg.MaxConcurrentUpstream == 0 {
+		return nil, nil
+	}
+	return &UpstreamLimiter{
+		slots:        make(chan struct{}, cfg.MaxConcurrentUpstream),
+		reject:       reject,
+		queueTimeout: cfg.UpstreamQueueTimeout,
+	}, nil
+}
+
+// Acquire takes a slot, waiting in queue mode until one frees up, the
+// queue timeout passes or ctx is done. Call release once the upstream
+// response has been fully relayed.
+func (l *UpstreamLimiter) Acquire(ctx context.Context) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	release = func() { <-l.slots }
+
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	l.mu.Lock()
+	if l.reject {
+		l.rejected++
+		l.mu.Unlock()
+		return nil, errUpstreamBusy
+	}
+	l.queued++
+	l.waiting++
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.waiting--
+		l.mu.Unlock()
+	}()
+
+	var timeout <-chan time.Time
+	if l.queueTimeout > 0 {
+		timer := time.NewTimer(l.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-timeout:
+		l.mu.Lock()
+		l.rejected++
+		l.mu.Unlock()
+		return nil, errUpstreamBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Stats returns the current limit usage. A nil limiter returns nil.
+func (l *UpstreamLimiter) Stats() *analytics.UpstreamStats {
+	if l == nil {
+		return nil
+	}
+	overflow := UpstreamOverflowQueue
+	if l.reject {
+		overflow = UpstreamOverflowReject
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return &analytics.UpstreamStats{
+		MaxConcurrent: cap(l.slots),
+		Overflow:      overflow,
+		InFlight:      len(l.slots),
+		Waiting:       l.waiting,
+		Queued:        l.queued,
+		Rejected:      l.rejected,
+	}
+}
+
+// saveUnforwardedFlow saves a flow that never reached the upstream because
+// no slot was free (recorded as a 503), its model's rate limit was exceeded
+// (recorded as a 429) or the wait was abandoned.
+func (p *MITMProxy) saveUnforwardedFlow(flow *store.Flow, err error) {
+	var limited *rateLimitedError
+	status := 0
+	switch {
+	case errors.Is(err, errUpstreamBusy):
+		p.logger.Debug("upstream concurrency limit reached", "flow_id", flow.ID, "host", flow.Host)
+		status = http.StatusServiceUnavailable
+	case errors.As(err, &limited):
+		status = http.StatusTooManyRequests
+	default:
+		flow.FlowIntegrity = "interrupted"
+	}
+	if status != 0 {
+		statusText := fmt.Sprintf("%d %s", status, http.StatusText(status))
+		flow.StatusCode = &status
+		flow.StatusText = &statusText
+	}
+	p.saveFlow(flow)
+}
@@ -0,0 +1,257 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/config"
+	"github.com/HakAl/langley/internal/store"
+)
+
+func TestNewUpstreamLimiter(t *testing.T) {
+	if l, err := NewUpstreamLimiter(config.ProxyConfig{}); err != nil || l != nil {
+		t.Errorf("unlimited: got %v, %v; want nil limiter", l, err)
+	}
+	for _, cfg := range []config.ProxyConfig{
+		{MaxConcurrentUpstream: -1},
+		{MaxConcurrentUpstream: 2, UpstreamOverflow: "drop"},
+		{MaxConcurrentUpstream: 2, UpstreamQueueTimeout: -time.Second},
+	} {
+		if _, err := NewUpstreamLimiter(cfg); err == nil {
+			t.Errorf("NewUpstreamLimiter(%+v) succeeded, want error", cfg)
+		}
+	}
+}
+
+// blockingUpstream serves requests only once release is closed, tracking
+// the most requests it saw at once.
+type blockingUpstream struct {
+	*httptest.Server
+	release  chan struct{}
+	inFlight atomic.Int32
+	maxSeen  atomic.Int32
+}
+
+func newBlockingUpstream(t *testing.T) *blockingUpstream {
+	u := &blockingUpstream{release: make(chan struct{})}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := u.inFlight.Add(1)
+		for {
+			max := u.maxSeen.Load()
+			if n <= max || u.maxSeen.CompareAndSwap(max, n) {
+				break
+			}
+		}
+		<-u.release
+		u.inFlight.Add(-1)
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMITMProxy_UpstreamLimitQueues(t *testing.T) {
+	t.Parallel()
+
+	upstream := newBlockingUpstream(t)
+	p, proxyAddr, _, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+		cfg.Proxy.MaxConcurrentUpstream = 2
+	})
+	defer cleanup()
+
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, "http://"+proxyAddr))},
+		Timeout:   10 * time.Second,
+	}
+	const requests = 5
+	codes := make(chan int, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(upstream.URL)
+			if err != nil {
+				t.Errorf("request failed: %v", err)
+				return
+			}
+			resp.Body.Close()
+			codes <- resp.StatusCode
+		}()
+	}
+
+	waitFor(t, "queued requests", func() bool {
+		s := p.upstream.Stats()
+		return s.InFlight == 2 && s.Waiting == requests-2
+	})
+	if n := upstream.inFlight.Load(); n != 2 {
+		t.Errorf("upstream in flight = %d, want 2", n)
+	}
+	close(upstream.release)
+	wg.Wait()
+	close(codes)
+
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("status = %d, want 200", code)
+		}
+	}
+	if max := upstream.maxSeen.Load(); max > 2 {
+		t.Errorf("upstream saw %d requests at once, want at most 2", max)
+	}
+	if s := p.upstream.Stats(); s.Queued != requests-2 || s.Rejected != 0 || s.InFlight != 0 {
+		t.Errorf("stats = %+v", s)
+	}
+}
+
+func TestMITMProxy_UpstreamLimitRejects(t *testing.T) {
+	t.Parallel()
+
+	upstream := newBlockingUpstream(t)
+	p, proxyAddr, _, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+		cfg.Proxy.MaxConcurrentUpstream = 1
+		cfg.Proxy.UpstreamOverflow = UpstreamOverflowReject
+	})
+	defer cleanup()
+
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, "http://"+proxyAddr))},
+		Timeout:   10 * time.Second,
+	}
+	first := make(chan int, 1)
+	go func() {
+		resp, err := client.Get(upstream.URL + "/first")
+		if err != nil {
+			t.Errorf("first request failed: %v", err)
+			first <- 0
+			return
+		}
+		resp.Body.Close()
+		first <- resp.StatusCode
+	}()
+	waitFor(t, "first request upstream", func() bool { return upstream.inFlight.Load() == 1 })
+
+	resp, err := client.Get(upstream.URL + "/second")
+	if err != nil {
+		t.Fatalf("second request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("second status = %d, want 503", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("503 without Retry-After")
+	}
+
+	close(upstream.release)
+	if code := <-first; code != http.StatusOK {
+		t.Errorf("first status = %d, want 200", code)
+	}
+	if s := p.upstream.Stats(); s.Rejected != 1 || s.Queued != 0 {
+		t.Errorf("stats = %+v", s)
+	}
+
+	// The rejected flow is saved with its 503
+	flows, _ := p.store.ListFlows(context.Background(), store.FlowFilter{})
+	var saved bool
+	for _, f := range flows {
+		if f.Path == "/second" {
+			saved = f.StatusCode != nil && *f.StatusCode == http.StatusServiceUnavailable
+		}
+	}
+	if !saved {
+		t.Error("rejected flow not saved with status 503")
+	}
+}
+
+func TestMITMProxy_UpstreamLimitClientGoesAway(t *testing.T) {
+	t.Parallel()
+
+	upstream := newBlockingUpstream(t)
+	upstreamTLS := httptest.NewTLSServer(upstream.Config.Handler)
+	t.Cleanup(upstreamTLS.Close)
+	p, proxyAddr, _, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+		cfg.Proxy.MaxConcurrentUpstream = 1
+		cfg.Proxy.UpstreamOverrides = map[string]string{"api.anthropic.com": upstreamTLS.Listener.Addr().String()}
+	})
+	defer cleanup()
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(p.ca.CertPEM())
+	newClient := func() *http.Client {
+		return &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyURL(mustParseURL(t, "http://"+proxyAddr)),
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+			Timeout: 10 * time.Second,
+		}
+	}
+
+	// Released on failure too, or the upstream's Close waits on the handler
+	var releaseOnce sync.Once
+	release := func() { releaseOnce.Do(func() { close(upstream.release) }) }
+	defer release()
+
+	first := make(chan int, 1)
+	go func() {
+		resp, err := newClient().Get("https://api.anthropic.com/first")
+		if err != nil {
+			t.Errorf("first request failed: %v", err)
+			first <- 0
+			return
+		}
+		resp.Body.Close()
+		first <- resp.StatusCode
+	}()
+	waitFor(t, "first request upstream", func() bool { return upstream.inFlight.Load() == 1 })
+
+	// The second request queues behind the first until its client gives up
+	ctx, cancel := context.WithCancel(context.Background())
+	second := make(chan error, 1)
+	go func() {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.anthropic.com/second", nil)
+		resp, err := newClient().Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		second <- err
+	}()
+	waitFor(t, "queued request", func() bool { return p.upstream.Stats().Waiting == 1 })
+	cancel()
+	if err := <-second; err == nil {
+		t.Error("cancelled request succeeded")
+	}
+
+	// The proxy stops waiting while the first request still holds the slot
+	waitFor(t, "queue to empty", func() bool { return p.upstream.Stats().Waiting == 0 })
+	if n := upstream.inFlight.Load(); n != 1 {
+		t.Errorf("upstream in flight = %d, want 1", n)
+	}
+
+	release()
+	if code := <-first; code != http.StatusOK {
+		t.Errorf("first status = %d, want 200", code)
+	}
+	if s := p.upstream.Stats(); s.Queued != 1 || s.InFlight != 0 {
+		t.Errorf("stats = %+v", s)
+	}
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// upstreamOverrides redirects dials for configured hosts to another address
+// (proxy.upstream_overrides). Only the TCP destination changes: the Host
+// header, TLS SNI and captured flow keep the original host.
+type upstreamOverrides map[string]string
+
+// newUpstreamOverrides validates proxy.upstream_overrides. Keys are a host
+// ("api.anthropic.com", any port) or host:port; targets must be host:port.
+func newUpstreamOverrides(cfg map[string]string) (upstreamOverrides, error) {
+	if len(cfg) == 0 {
+		return nil, nil
+	}
+	o := make(upstreamOverrides, len(cfg))
+	for host, target := range cfg {
+		if strings.TrimSpace(host) == "" {
+			return nil, fmt.Errorf("proxy.upstream_overrides: empty host")
+		}
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return nil, fmt.Errorf("proxy.upstream_overrides[%s]: target %q must be host:port", host, target)
+		}
+		o[strings.ToLower(strings.TrimSpace(host))] = target
+	}
+	return o, nil
+}
+
+// resolve returns the address to dial for addr (host:port): the override
+// target if addr or its host is overridden, else addr unchanged.
+func (o upstreamOverrides) resolve(addr string) string {
+	if len(o) == 0 {
+		return addr
+	}
+	if target, ok := o[strings.ToLower(addr)]; ok {
+		return target
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if target, ok := o[strings.ToLower(host)]; ok {
+		return target
+	}
+	return addr
+}
@@ -0,0 +1,255 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/config"
+	"github.com/HakAl/langley/internal/redact"
+	"github.com/HakAl/langley/internal/store/storetest"
+	langleytls "github.com/HakAl/langley/internal/tls"
+)
+
+func TestUpstreamOverrides_Resolve(t *testing.T) {
+	o, err := newUpstreamOverrides(map[string]string{
+		"API.anthropic.com":   "127.0.0.1:8443",
+		"api.openai.com:8080": "127.0.0.1:9000",
+	})
+	if err != nil {
+		t.Fatalf("newUpstreamOverrides: %v", err)
+	}
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"api.anthropic.com:443", "127.0.0.1:8443"},
+		{"api.anthropic.com:80", "127.0.0.1:8443"},
+		{"api.openai.com:8080", "127.0.0.1:9000"},
+		{"api.openai.com:443", "api.openai.com:443"},
+		{"anthropic.com:443", "anthropic.com:443"},
+	}
+	for _, tt := range tests {
+		if got := o.resolve(tt.addr); got != tt.want {
+			t.Errorf("resolve(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+
+	if _, err := newUpstreamOverrides(map[string]string{"api.anthropic.com": "localhost"}); err == nil {
+		t.Error("target without a port accepted, want error")
+	}
+}
+
+// overrideTarget records the Host header and SNI of requests it receives.
+type overrideTarget struct {
+	mu         sync.Mutex
+	host       string
+	serverName string
+}
+
+func (o *overrideTarget) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o.mu.Lock()
+		o.host = r.Host
+		if r.TLS != nil {
+			o.serverName = r.TLS.ServerName
+		}
+		o.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"mock":true}`))
+	})
+}
+
+func TestMITMProxy_UpstreamOverride(t *testing.T) {
+	t.Parallel()
+
+	t.Run("https", func(t *testing.T) {
+		t.Parallel()
+		target := &overrideTarget{}
+		upstream := httptest.NewTLSServer(target.handler())
+		defer upstream.Close()
+
+		p, proxyAddr, capture, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+			cfg.Proxy.UpstreamOverrides = map[string]string{"api.anthropic.com": upstream.Listener.Addr().String()}
+		})
+		defer cleanup()
+
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(p.ca.CertPEM())
+		client := &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyURL(mustParseURL(t, "http://"+proxyAddr)),
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+			Timeout: 5 * time.Second,
+		}
+		resp, err := client.Get("https://api.anthropic.com/v1/models")
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !strings.Contains(string(body), `"mock":true`) {
+			t.Fatalf("body = %s, want the override target's response", body)
+		}
+
+		target.mu.Lock()
+		defer target.mu.Unlock()
+		// The CONNECT authority (api.anthropic.com:443) is kept as the Host
+		if !strings.HasPrefix(target.host, "api.anthropic.com") || target.serverName != "api.anthropic.com" {
+			t.Errorf("target saw Host %q, SNI %q; want api.anthropic.com", target.host, target.serverName)
+		}
+		if flow := capture.WaitForFlow(2 * time.Second); flow == nil || !strings.HasPrefix(flow.Host, "api.anthropic.com") {
+			t.Errorf("captured flow = %+v, want host api.anthropic.com", flow)
+		}
+	})
+
+	t.Run("http", func(t *testing.T) {
+		t.Parallel()
+		target := &overrideTarget{}
+		upstream := httptest.NewServer(target.handler())
+		defer upstream.Close()
+
+		_, proxyAddr, capture, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+			cfg.Proxy.UpstreamOverrides = map[string]string{"api.anthropic.com": upstream.Listener.Addr().String()}
+		})
+		defer cleanup()
+
+		client := &http.Client{
+			Transport: &http.Transport{Proxy: http.ProxyURL(mustParseURL(t, "http://"+proxyAddr))},
+			Timeout:   5 * time.Second,
+		}
+		resp, err := client.Get("http://api.anthropic.com/v1/models")
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !strings.Contains(string(body), `"mock":true`) {
+			t.Fatalf("body = %s, want the override target's response", body)
+		}
+
+		target.mu.Lock()
+		defer target.mu.Unlock()
+		if target.host != "api.anthropic.com" {
+			t.Errorf("target saw Host %q, want api.anthropic.com", target.host)
+		}
+		if flow := capture.WaitForFlow(2 * time.Second); flow == nil || flow.Host != "api.anthropic.com" {
+			t.Errorf("captured flow = %+v, want host api.anthropic.com", flow)
+		}
+	})
+}
+
+func TestMITMProxy_HostRewrite(t *testing.T) {
+	t.Parallel()
+
+	target := &overrideTarget{}
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target.mu.Lock()
+		target.host = r.Host
+		target.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"msg_1","type":"message","model":"claude-sonnet-4-20250514",` +
+			`"content":[{"type":"text","text":"hi"}],"usage":{"input_tokens":12,"output_tokens":3}}`))
+	}))
+	defer upstream.Close()
+
+	// The mock's certificate is for 127.0.0.1, which the rewritten SNI
+	// verifies against: trusted like any private upstream, not skipped
+	certPath := filepath.Join(t.TempDir(), "mock.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw})
+	if err := os.WriteFile(certPath, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig()
+	cfg.Proxy.UpstreamTrustCerts = []string{certPath}
+
+	var mu sync.Mutex
+	var rewritten []string
+	ca, err := langleytls.LoadOrCreateCA(t.TempDir())
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA: %v", err)
+	}
+	redactor, _ := redact.New(&config.RedactionConfig{})
+	capture := &flowCapture{}
+	p, err := NewMITMProxy(MITMProxyConfig{
+		Config:    cfg,
+		Logger:    testLogger(),
+		CA:        ca,
+		CertCache: langleytls.NewCertCache(ca, 10),
+		Redactor:  redactor,
+		Store:     storetest.New(storetest.WithForeignKeys()),
+		OnFlow:    capture.OnFlow,
+		OnUpdate:  capture.OnUpdate,
+		HostRewrite: func(host string) string {
+			mu.Lock()
+			defer mu.Unlock()
+			rewritten = append(rewritten, host)
+			if host == "api.anthropic.com:443" {
+				return upstream.Listener.Addr().String()
+			}
+			return host
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewMITMProxy: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listener: %v", err)
+	}
+	defer ln.Close()
+	go func() { _ = http.Serve(ln, p) }()
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.CertPEM())
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyURL(mustParseURL(t, "http://"+ln.Addr().String())),
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+		Timeout: 5 * time.Second,
+	}
+	resp, err := client.Post("https://api.anthropic.com/v1/messages", "application/json",
+		strings.NewReader(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[]}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "msg_1") {
+		t.Fatalf("status %d, body %s; want the mock's response", resp.StatusCode, body)
+	}
+
+	target.mu.Lock()
+	if !strings.HasPrefix(target.host, "api.anthropic.com") {
+		t.Errorf("mock saw Host %q, want api.anthropic.com", target.host)
+	}
+	target.mu.Unlock()
+	mu.Lock()
+	if len(rewritten) == 0 {
+		t.Error("HostRewrite was never called")
+	}
+	mu.Unlock()
+
+	flow := capture.WaitForUpdate(2 * time.Second)
+	if flow == nil {
+		t.Fatal("no flow captured")
+	}
+	if !strings.HasPrefix(flow.Host, "api.anthropic.com") || flow.Provider != "anthropic" {
+		t.Errorf("flow host %q, provider %q; want api.anthropic.com, anthropic", flow.Host, flow.Provider)
+	}
+	if flow.InputTokens == nil || *flow.InputTokens != 12 || flow.OutputTokens == nil || *flow.OutputTokens != 3 {
+		t.Errorf("flow tokens = %v/%v, want 12/3", flow.InputTokens, flow.OutputTokens)
+	}
+}
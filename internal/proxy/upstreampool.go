@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultUpstreamMaxIdlePerHost is used when proxy.upstream_max_idle_per_host is unset.
+	defaultUpstreamMaxIdlePerHost = 4
+	// defaultUpstreamIdleTimeout is used when proxy.upstream_idle_timeout is
+	// unset. It is kept below common load balancer idle timeouts so pooled
+	// connections are usually dropped before the upstream drops them.
+	defaultUpstreamIdleTimeout = 30 * time.Second
+	// aliveProbeTimeout bounds the check that an idle connection is still open.
+	aliveProbeTimeout = time.Millisecond
+)
+
+// pooledConn is an HTTP/1.1 TLS connection to an upstream host. br reads
+// its responses and outlives any single request, so bytes read ahead stay
+// with the connection.
+type pooledConn struct {
+	*tls.Conn
+	br       *bufio.Reader
+	host     string
+	idleAt   time.Time
+	reused   bool  // Taken from the pool rather than dialed for this request
+	received int64 // Bytes read from the upstream
+}
+
+// Read reads from the connection, counting the bytes received.
+func (c *pooledConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.received += int64(n)
+	return n, err
+}
+
+// alive reports whether an idle connection can take another request: the
+// upstream hasn't closed it or sent anything unasked. A response is only
+// expected after a request, so any byte, or EOF, means the connection is
+// done.
+func (c *pooledConn) alive() bool {
+	_ = c.SetReadDeadline(time.Now().Add(aliveProbeTimeout))
+	_, err := c.br.Peek(1)
+	_ = c.SetReadDeadline(time.Time{})
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// upstreamPool keeps idle TLS connections to intercepted upstreams so later
+// CONNECT tunnels to the same host skip the dial and handshake. Connections
+// are checked out for one request at a time and only put back once its
+// response has been read to the end, so a response can't reach the wrong
+// request. Connections idle longer than idleTimeout are closed.
+type upstreamPool struct {
+	dial        func(host string) (*tls.Conn, error)
+	maxIdle     int // Per host; 0 disables pooling
+	idleTimeout time.Duration
+
+	mu       sync.Mutex
+	idle     map[string][]*pooledConn // By host:port, most recently used last
+	evicting bool                     // An eviction timer is pending
+	closed   bool
+
+	dials  atomic.Int64
+	reuses atomic.Int64
+}
+
+// newUpstreamPool returns a pool using dial for new connections.
+// maxIdle 0 uses the default and a negative one disables pooling;
+// idleTimeout 0 uses the default.
+func newUpstreamPool(dial func(host string) (*tls.Conn, error), maxIdle int, idleTimeout time.Duration) *upstreamPool {
+	if maxIdle == 0 {
+		maxIdle = defaultUpstreamMaxIdlePerHost
+	}
+	if idleTimeout <= 0 {
+		idleTimeout = defaultUpstreamIdleTimeout
+	}
+	return &upstreamPool{
+		dial:        dial,
+		maxIdle:     max(maxIdle, 0),
+		idleTimeout: idleTimeout,
+		idle:        make(map[string][]*pooledConn),
+	}
+}
+
+// get returns an idle connection to host that is still open, or dials a new one.
+func (u *upstreamPool) get(host string) (*pooledConn, error) {
+	for {
+		c := u.popIdle(host)
+		if c == nil {
+			break
+		}
+		if c.alive() {
+			u.reuses.Add(1)
+			c.reused = true
+			return c, nil
+		}
+		c.Close()
+	}
+	return u.dialNew(host)
+}
+
+// dialNew dials a new connection to host, bypassing the idle ones.
+func (u *upstreamPool) dialNew(host string) (*pooledConn, error) {
+	conn, err := u.dial(host)
+	if err != nil {
+		return nil, err
+	}
+	u.dials.Add(1)
+	c := &pooledConn{Conn: conn, host: host}
+	c.br = bufio.NewReader(c)
+	return c, nil
+}
+
+// popIdle removes and returns host's most recently used idle connection
+// that hasn't timed out, or nil.
+func (u *upstreamPool) popIdle(host string) *pooledConn {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	conns := u.idle[host]
+	for len(conns) > 0 {
+		c := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		if time.Since(c.idleAt) < u.idleTimeout {
+			u.setIdle(host, conns)
+			return c
+		}
+		c.Close()
+	}
+	u.setIdle(host, conns)
+	return nil
+}
+
+// put returns a connection whose last response was read to the end. It is
+// closed instead if the pool is full for its host, disabled or closed.
+func (u *upstreamPool) put(c *pooledConn) {
+	c.idleAt = time.Now()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.closed || len(u.idle[c.host]) >= u.maxIdle {
+		c.Close()
+		return
+	}
+	u.idle[c.host] = append(u.idle[c.host], c)
+	if !u.evicting {
+		u.evicting = true
+		time.AfterFunc(u.idleTimeout, u.evictIdle)
+	}
+}
+
+// evictIdle closes connections idle longer than idleTimeout, and runs
+// again while any remain.
+func (u *upstreamPool) evictIdle() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var next time.Duration
+	for host, conns := range u.idle {
+		kept := conns[:0]
+		for _, c := range conns {
+			if left := u.idleTimeout - time.Since(c.idleAt); left > 0 {
+				kept = append(kept, c)
+				if next == 0 || left < next {
+					next = left
+				}
+			} else {
+				c.Close()
+			}
+		}
+		u.setIdle(host, kept)
+	}
+	u.evicting = next > 0 && !u.closed
+	if u.evicting {
+		time.AfterFunc(next, u.evictIdle)
+	}
+}
+
+// setIdle replaces host's idle list. Callers hold u.mu.
+func (u *upstreamPool) setIdle(host string, conns []*pooledConn) {
+	if len(conns) == 0 {
+		delete(u.idle, host)
+		return
+	}
+	u.idle[host] = conns
+}
+
+// idleCount returns the number of idle connections to host.
+func (u *upstreamPool) idleCount(host string) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.idle[host])
+}
+
+// close closes the idle connections and stops pooling. Connections checked
+// out are closed when they're put back.
+func (u *upstreamPool) close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.closed = true
+	for host, conns := range u.idle {
+		for _, c := range conns {
+			c.Close()
+		}
+		delete(u.idle, host)
+	}
+}
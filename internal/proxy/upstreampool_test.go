@@ -0,0 +1,303 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/config"
+)
+
+const pooledHost = "api.anthropic.com:443"
+
+// countingUpstream is a TLS upstream that counts the connections it
+// accepts. /sse streams events; anything else echoes the path as JSON.
+func countingUpstream(t *testing.T, configure func(*httptest.Server)) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var conns atomic.Int64
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sse" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for i := range 3 {
+				fmt.Fprintf(w, "event: ping\ndata: {\"n\":%d}\n\n", i)
+				w.(http.Flusher).Flush()
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"path":%q}`, r.URL.Path)
+	}))
+	upstream.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	if configure != nil {
+		configure(upstream)
+	}
+	upstream.StartTLS()
+	t.Cleanup(upstream.Close)
+	return upstream, &conns
+}
+
+// tunnelPerRequestClient opens a new CONNECT tunnel for every request.
+func tunnelPerRequestClient(t *testing.T, p *MITMProxy, proxyAddr string) *http.Client {
+	t.Helper()
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(p.ca.CertPEM())
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:             http.ProxyURL(mustParseURL(t, "http://"+proxyAddr)),
+			TLSClientConfig:   &tls.Config{RootCAs: roots},
+			DisableKeepAlives: true,
+		},
+		Timeout: 10 * time.Second,
+	}
+}
+
+func getBody(t *testing.T, client *http.Client, path string) string {
+	t.Helper()
+	resp, err := client.Get("https://api.anthropic.com" + path)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("GET %s: reading body: %v", path, err)
+	}
+	return string(body)
+}
+
+// waitIdle waits for the pool to hold n idle connections to host; a
+// response reaches the client before its connection is put back.
+func waitIdle(t *testing.T, pool *upstreamPool, host string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for pool.idleCount(host) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("idle connections to %s = %d, want %d", host, pool.idleCount(host), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMITMProxy_ReusesUpstreamConnAcrossTunnels(t *testing.T) {
+	t.Parallel()
+	upstream, conns := countingUpstream(t, nil)
+	p, proxyAddr, _, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+		cfg.Proxy.UpstreamOverrides = map[string]string{"api.anthropic.com": upstream.Listener.Addr().String()}
+	})
+	defer cleanup()
+	client := tunnelPerRequestClient(t, p, proxyAddr)
+
+	// A streamed response, then plain ones, each on its own tunnel
+	if body := getBody(t, client, "/sse"); strings.Count(body, "event: ping") != 3 {
+		t.Fatalf("SSE body = %q, want 3 events", body)
+	}
+	for i := range 3 {
+		waitIdle(t, p.upstreamPool, pooledHost, 1)
+		path := fmt.Sprintf("/v1/req-%d", i)
+		if body, want := getBody(t, client, path), fmt.Sprintf(`{"path":%q}`, path); body != want {
+			t.Errorf("body = %s, want %s", body, want)
+		}
+	}
+
+	if n := conns.Load(); n != 1 {
+		t.Errorf("upstream accepted %d connections, want 1 shared by all tunnels", n)
+	}
+	if dials, reuses := p.upstreamPool.dials.Load(), p.upstreamPool.reuses.Load(); dials != 1 || reuses != 3 {
+		t.Errorf("pool dials = %d, reuses = %d; want 1, 3", dials, reuses)
+	}
+}
+
+func TestMITMProxy_PooledUpstreamConcurrentTunnels(t *testing.T) {
+	t.Parallel()
+	upstream, conns := countingUpstream(t, nil)
+	p, proxyAddr, _, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+		cfg.Proxy.UpstreamOverrides = map[string]string{"api.anthropic.com": upstream.Listener.Addr().String()}
+	})
+	defer cleanup()
+	client := tunnelPerRequestClient(t, p, proxyAddr)
+
+	// Every response must reach the request that asked for it
+	const workers, requests = 8, 10
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range requests {
+				path := fmt.Sprintf("/v1/w%d-r%d", w, i)
+				if body, want := getBody(t, client, path), fmt.Sprintf(`{"path":%q}`, path); body != want {
+					t.Errorf("body = %s, want %s", body, want)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := conns.Load(); n >= workers*requests {
+		t.Errorf("upstream accepted %d connections for %d requests, want reuse", n, workers*requests)
+	}
+}
+
+func TestMITMProxy_PooledUpstreamClosedByServer(t *testing.T) {
+	t.Parallel()
+	upstream, conns := countingUpstream(t, func(s *httptest.Server) {
+		s.Config.IdleTimeout = 50 * time.Millisecond
+	})
+	p, proxyAddr, _, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+		cfg.Proxy.UpstreamOverrides = map[string]string{"api.anthropic.com": upstream.Listener.Addr().String()}
+	})
+	defer cleanup()
+	client := tunnelPerRequestClient(t, p, proxyAddr)
+
+	getBody(t, client, "/v1/first")
+	waitIdle(t, p.upstreamPool, pooledHost, 1)
+
+	// The upstream hangs up on the idle connection; the next tunnel must
+	// notice and dial instead of failing
+	time.Sleep(200 * time.Millisecond)
+	if body := getBody(t, client, "/v1/second"); body != `{"path":"/v1/second"}` {
+		t.Errorf("body = %s", body)
+	}
+	if n := conns.Load(); n != 2 {
+		t.Errorf("upstream accepted %d connections, want 2", n)
+	}
+}
+
+func TestMITMProxy_PooledUpstreamHangsUpOnRequest(t *testing.T) {
+	t.Parallel()
+	var refused, hangups atomic.Int64
+	upstream, conns := countingUpstream(t, func(s *httptest.Server) {
+		echo := s.Config.Handler
+		s.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hangUp := func() {
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+			}
+			switch {
+			case r.URL.Path == "/v1/refuse":
+				refused.Add(1)
+				hangUp()
+			case r.URL.Path == "/v1/hangup" && hangups.Add(1) == 1:
+				hangUp()
+			case r.URL.Path == "/v1/hangup":
+				body, _ := io.ReadAll(r.Body)
+				_, _ = w.Write(body)
+			default:
+				echo.ServeHTTP(w, r)
+			}
+		})
+	})
+	p, proxyAddr, _, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+		cfg.Proxy.UpstreamOverrides = map[string]string{"api.anthropic.com": upstream.Listener.Addr().String()}
+	})
+	defer cleanup()
+	client := tunnelPerRequestClient(t, p, proxyAddr)
+
+	// A new connection that fails isn't retried: the upstream may have
+	// acted on the request
+	resp, err := client.Post("https://api.anthropic.com/v1/refuse", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("POST /v1/refuse: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway || refused.Load() != 1 {
+		t.Errorf("fresh connection hung up: status %d after %d tries, want 502 after 1", resp.StatusCode, refused.Load())
+	}
+
+	getBody(t, client, "/v1/first")
+	waitIdle(t, p.upstreamPool, pooledHost, 1)
+
+	// The pooled connection passes the idle check but is closed on the
+	// request; it is sent again, body and all, on a new connection
+	resp, err = client.Post("https://api.anthropic.com/v1/hangup", "application/json", strings.NewReader(`{"n":1}`))
+	if err != nil {
+		t.Fatalf("POST /v1/hangup: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != `{"n":1}` {
+		t.Errorf("pooled connection hung up: status %d, body %s; want 200 and the request body", resp.StatusCode, body)
+	}
+	if n := hangups.Load(); n != 2 {
+		t.Errorf("upstream saw /v1/hangup %d times, want 2", n)
+	}
+	if n, dials, reuses := conns.Load(), p.upstreamPool.dials.Load(), p.upstreamPool.reuses.Load(); n != 3 || dials != 3 || reuses != 1 {
+		t.Errorf("connections = %d, pool dials = %d, reuses = %d; want 3, 3, 1", n, dials, reuses)
+	}
+}
+
+func TestMITMProxy_UpstreamConnectionCloseNotPooled(t *testing.T) {
+	t.Parallel()
+	upstream, conns := countingUpstream(t, func(s *httptest.Server) {
+		s.Config.SetKeepAlivesEnabled(false) // Responses carry Connection: close
+	})
+	p, proxyAddr, _, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+		cfg.Proxy.UpstreamOverrides = map[string]string{"api.anthropic.com": upstream.Listener.Addr().String()}
+	})
+	defer cleanup()
+	client := tunnelPerRequestClient(t, p, proxyAddr)
+
+	for i := range 2 {
+		if body, want := getBody(t, client, fmt.Sprintf("/v1/%d", i)), fmt.Sprintf(`{"path":"/v1/%d"}`, i); body != want {
+			t.Errorf("body = %s, want %s", body, want)
+		}
+	}
+	if n := conns.Load(); n != 2 {
+		t.Errorf("upstream accepted %d connections, want 2", n)
+	}
+	if n := p.upstreamPool.idleCount(pooledHost); n != 0 {
+		t.Errorf("idle connections = %d, want 0", n)
+	}
+}
+
+func TestUpstreamPool_IdleEvictionAndLimit(t *testing.T) {
+	t.Parallel()
+	pool := newUpstreamPool(func(host string) (*tls.Conn, error) {
+		client, server := net.Pipe()
+		t.Cleanup(func() { server.Close() })
+		return tls.Client(client, &tls.Config{ServerName: "example.com"}), nil
+	}, 2, 50*time.Millisecond)
+
+	var checkedOut []*pooledConn
+	for range 3 {
+		c, err := pool.get("example.com:443")
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		checkedOut = append(checkedOut, c)
+	}
+	for _, c := range checkedOut {
+		pool.put(c)
+	}
+	if n := pool.idleCount("example.com:443"); n != 2 {
+		t.Errorf("idle after putting 3 = %d, want the limit of 2", n)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if n := pool.idleCount("example.com:443"); n != 0 {
+		t.Errorf("idle after timeout = %d, want 0", n)
+	}
+
+	pool.close()
+	c, err := pool.get("example.com:443")
+	if err != nil {
+		t.Fatalf("get after close: %v", err)
+	}
+	pool.put(c)
+	if n := pool.idleCount("example.com:443"); n != 0 {
+		t.Errorf("idle after close = %d, want 0", n)
+	}
+}
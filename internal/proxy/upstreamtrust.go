@@ -0,0 +1,30 @@
+package proxy
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// loadUpstreamRoots returns the system roots plus the certificates in the
+// given PEM files, for upstreams with self-signed or private-CA certificates.
+// It returns nil, meaning the system roots alone, when paths is empty.
+func loadUpstreamRoots(paths []string) (*x509.CertPool, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("proxy.upstream_trust_certs: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("proxy.upstream_trust_certs: no PEM certificates in %s", path)
+		}
+	}
+	return pool, nil
+}
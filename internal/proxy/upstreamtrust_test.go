@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/config"
+	"github.com/HakAl/langley/internal/redact"
+	"github.com/HakAl/langley/internal/store/storetest"
+	langleytls "github.com/HakAl/langley/internal/tls"
+)
+
+func TestMITMProxy_UpstreamTrustCerts(t *testing.T) {
+	t.Parallel()
+
+	// httptest's TLS server uses a self-signed certificate
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	certPath := filepath.Join(t.TempDir(), "gateway.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw})
+	if err := os.WriteFile(certPath, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(trust []string) (int, error) {
+		cfg := testConfig()
+		cfg.Proxy.InterceptHosts = []string{upstreamURL.Hostname()}
+		cfg.Proxy.UpstreamTrustCerts = trust
+
+		ca, err := langleytls.LoadOrCreateCA(t.TempDir())
+		if err != nil {
+			t.Fatalf("LoadOrCreateCA: %v", err)
+		}
+		redactor, _ := redact.New(&config.RedactionConfig{})
+		p, err := NewMITMProxy(MITMProxyConfig{
+			Config:    cfg,
+			Logger:    testLogger(),
+			CA:        ca,
+			CertCache: langleytls.NewCertCache(ca, 10),
+			Redactor:  redactor,
+			Store:     storetest.New(storetest.WithForeignKeys()),
+		})
+		if err != nil {
+			t.Fatalf("NewMITMProxy: %v", err)
+		}
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listener: %v", err)
+		}
+		defer ln.Close()
+		go func() { _ = http.Serve(ln, p) }()
+
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(ca.CertPEM())
+		client := &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyURL(mustParseURL(t, "http://"+ln.Addr().String())),
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+			Timeout: 5 * time.Second,
+		}
+		resp, err := client.Get(upstream.URL + "/v1/models")
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	if code, err := request(nil); err == nil {
+		t.Errorf("untrusted self-signed upstream: got status %d, want connection failure", code)
+	}
+	if code, err := request([]string{certPath}); err != nil || code != http.StatusOK {
+		t.Errorf("trusted upstream: status %d, err %v; want 200", code, err)
+	}
+}
+
+func TestLoadUpstreamRoots_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "not.pem")
+	if err := os.WriteFile(notPEM, []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{notPEM, filepath.Join(dir, "missing.pem")} {
+		if _, err := loadUpstreamRoots([]string{path}); err == nil {
+			t.Errorf("loadUpstreamRoots(%s) succeeded, want error", path)
+		}
+	}
+	if pool, err := loadUpstreamRoots(nil); pool != nil || err != nil {
+		t.Errorf("no paths: got %v, %v; want system roots (nil)", pool, err)
+	}
+}
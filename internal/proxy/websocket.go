@@ -0,0 +1,468 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/HakAl/langley/internal/parser"
+	"github.com/HakAl/langley/internal/queue"
+	"github.com/HakAl/langley/internal/redact"
+	"github.com/HakAl/langley/internal/store"
+)
+
+// WebSocket opcodes (RFC 6455 section 5.2)
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+)
+
+// isWebSocketUpgrade reports whether h asks to upgrade to a WebSocket.
+func isWebSocketUpgrade(h http.Header) bool {
+	if !strings.EqualFold(strings.TrimSpace(h.Get("Upgrade")), "websocket") {
+		return false
+	}
+	for _, v := range h.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleWebSocket forwards a WebSocket handshake on an intercepted connection.
+// Once upstream switches protocols, frames are relayed byte for byte in both
+// directions and each text message is stored as an event on the flow. The
+// connection can't return to HTTP afterwards, so the caller closes it; a
+// declined upgrade is answered with Connection: close for the same reason.
+func (p *MITMProxy) handleWebSocket(r *http.Request, headerOrder []string, clientReader *bufio.Reader, clientConn net.Conn, upstreamConn net.Conn, host string) {
+	startTime := time.Now()
+	flowID := uuid.New().String()
+
+	p.logger.Debug("WebSocket upgrade", "flow_id", flowID, "host", host, "path", r.URL.Path)
+
+	metadataOnly := p.memGuard.MetadataOnly()
+
+	flow := &store.Flow{
+		ID:                 flowID,
+		Host:               host,
+		Method:             r.Method,
+		Path:               r.URL.Path,
+		URL:                r.URL.String(),
+		Timestamp:          startTime,
+		TimestampMono:      time.Now().UnixNano(),
+		FlowIntegrity:      "complete",
+		Provider:           "other",
+		RequestHeaderOrder: p.headerFilter.Order(headerOrder),
+	}
+	if p.taskAssigner != nil {
+		assignment := p.taskAssigner.AssignRequest(host, r, nil)
+		flow.TaskID = &assignment.TaskID
+		flow.TaskSource = &assignment.Source
+		flow.URL = r.URL.String()
+	}
+	if redactor := p.redactor.Load(); redactor != nil {
+		flow.RequestHeaders = redact.HeadersToMap(redactor.RedactHeaders(p.headerFilter.Headers(r.Header)))
+	} else {
+		flow.RequestHeaders = redact.HeadersToMap(p.headerFilter.Headers(r.Header))
+	}
+	if prov := p.providers.Detect(host); prov != nil {
+		flow.Provider = prov.Name()
+	}
+
+	if p.store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := p.store.SaveFlow(ctx, flow)
+		if err != nil {
+			p.logger.Error("failed to save initial flow", "flow_id", flow.ID, "error", err)
+		}
+		p.capture.Record(err)
+		cancel()
+	}
+	if p.onFlow != nil {
+		p.onFlow(flow)
+	}
+
+	inflight := p.beginFlow(func() {
+		upstreamConn.Close()
+		clientConn.Close()
+	})
+	defer p.endFlow(inflight)
+
+	// Forward the handshake. Hop-by-hop headers are dropped as usual and the
+	// upgrade put back; extensions are stripped so frames arrive uncompressed
+	// and text messages can be stored, as with Accept-Encoding over HTTP.
+	outReq, err := http.NewRequest(r.Method, r.URL.String(), nil)
+	if err != nil {
+		p.sendError(clientConn, http.StatusBadRequest, "Bad request")
+		return
+	}
+	copyHeaders(outReq.Header, r.Header)
+	removeHopByHopHeaders(outReq.Header)
+	outReq.Header.Del("Sec-WebSocket-Extensions")
+	outReq.Header.Set("Connection", "Upgrade")
+	outReq.Header.Set("Upgrade", "websocket")
+
+	if headerOrder != nil {
+		err = writeRequestInOrder(upstreamConn, outReq, nil, headerOrder)
+	} else {
+		err = outReq.Write(upstreamConn)
+	}
+	if err != nil {
+		p.logger.Error("failed to write WebSocket handshake to upstream", "error", err)
+		p.sendError(clientConn, http.StatusBadGateway, "Bad gateway")
+		flow.FlowIntegrity = "interrupted"
+		p.saveFlow(flow)
+		return
+	}
+
+	upstreamReader := bufio.NewReader(upstreamConn)
+	resp, err := http.ReadResponse(upstreamReader, outReq)
+	if err != nil {
+		p.logger.Error("failed to read WebSocket handshake response", "error", err)
+		p.sendError(clientConn, http.StatusBadGateway, "Bad gateway")
+		flow.FlowIntegrity = "interrupted"
+		p.saveFlow(flow)
+		return
+	}
+	defer resp.Body.Close()
+
+	flow.StatusCode = &resp.StatusCode
+	statusText := resp.Status
+	flow.StatusText = &statusText
+	if redactor := p.redactor.Load(); redactor != nil {
+		flow.ResponseHeaders = redact.HeadersToMap(redactor.RedactHeaders(p.headerFilter.Headers(resp.Header)))
+	} else {
+		flow.ResponseHeaders = redact.HeadersToMap(p.headerFilter.Headers(resp.Header))
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		p.declineWebSocket(flow, resp, clientConn, startTime, metadataOnly)
+		return
+	}
+
+	var head bytes.Buffer
+	fmt.Fprintf(&head, "HTTP/1.1 %s\r\n", resp.Status)
+	_ = resp.Header.Write(&head)
+	head.WriteString("\r\n")
+	if _, err := clientConn.Write(head.Bytes()); err != nil {
+		p.logger.Debug("error writing WebSocket handshake response", "error", err)
+		flow.FlowIntegrity = "interrupted"
+		p.saveFlow(flow)
+		return
+	}
+
+	flow.IsWebSocket = true
+	if p.onUpdate != nil {
+		p.onUpdate(flow)
+	}
+
+	rec := p.newWSRecorder(flowID, !metadataOnly)
+	idleTimeout := p.idleTimeout()
+	var once sync.Once
+	closeAll := func() {
+		once.Do(func() {
+			clientConn.Close()
+			upstreamConn.Close()
+		})
+	}
+	var wg sync.WaitGroup
+	var clientClosed, upstreamClosed bool
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		clientClosed = p.relayWebSocket(upstreamConn, clientReader, clientConn, "client", rec, idleTimeout)
+		closeAll()
+	}()
+	go func() {
+		defer wg.Done()
+		upstreamClosed = p.relayWebSocket(clientConn, upstreamReader, upstreamConn, "server", rec, idleTimeout)
+		closeAll()
+	}()
+	wg.Wait()
+	skipped, dropped := rec.finish()
+	flow.EventsSkippedCount = skipped
+	flow.EventsDroppedCount += dropped
+
+	duration := time.Since(startTime).Milliseconds()
+	flow.DurationMs = &duration
+	// A session that ends without a close frame from either side was cut off
+	if inflight.Aborted() || (!clientClosed && !upstreamClosed) {
+		flow.FlowIntegrity = "interrupted"
+	}
+	p.saveFlow(flow)
+	if p.onUpdate != nil {
+		p.onUpdate(flow)
+	}
+}
+
+// declineWebSocket relays an upstream response that refused the upgrade.
+func (p *MITMProxy) declineWebSocket(flow *store.Flow, resp *http.Response, clientConn net.Conn, startTime time.Time, metadataOnly bool) {
+	var respBody bytes.Buffer
+	limitedWriter := &limitedBuffer{buf: &respBody, max: p.cfg.Persistence.StoredBodyLimit()}
+	var bodyBuf bytes.Buffer
+	if _, err := io.Copy(io.MultiWriter(&bodyBuf, limitedWriter), resp.Body); err != nil {
+		p.logger.Debug("error reading response body", "error", err)
+	}
+
+	respHeaders := resp.Header.Clone()
+	removeHopByHopHeaders(respHeaders)
+	respHeaders.Set("Content-Length", fmt.Sprintf("%d", bodyBuf.Len()))
+	respHeaders.Set("Connection", "close")
+
+	var responseBuf bytes.Buffer
+	fmt.Fprintf(&responseBuf, "HTTP/1.1 %s\r\n", resp.Status)
+	_ = respHeaders.Write(&responseBuf)
+	responseBuf.WriteString("\r\n")
+	responseBuf.Write(bodyBuf.Bytes())
+	if _, err := clientConn.Write(responseBuf.Bytes()); err != nil {
+		p.logger.Debug("error writing response", "error", err)
+	}
+
+	duration := time.Since(startTime).Milliseconds()
+	flow.DurationMs = &duration
+	if !metadataOnly && respBody.Len() > 0 {
+		s := respBody.String()
+		if redactor := p.redactor.Load(); redactor != nil {
+			if !redactor.ShouldStoreBody() {
+				s = ""
+			} else {
+				s = redactor.RedactForStorage(s)
+			}
+		}
+		if s != "" {
+			flow.ResponseBody = &s
+		}
+	}
+	flow.ResponseBodyTruncated = limitedWriter.truncated
+	p.saveFlow(flow)
+	if p.onUpdate != nil {
+		p.onUpdate(flow)
+	}
+}
+
+// relayWebSocket copies frames from src to dst unchanged until either side
+// fails, recording text messages from direction ("client" or "server").
+// It reports whether a close frame was relayed.
+func (p *MITMProxy) relayWebSocket(dst io.Writer, src *bufio.Reader, srcConn net.Conn, direction string, rec *wsRecorder, idleTimeout time.Duration) (closed bool) {
+	maxBytes := p.cfg.Persistence.StoredBodyLimit()
+	var msg bytes.Buffer
+	var msgOpcode byte
+	var msgTruncated bool
+
+	for {
+		_ = srcConn.SetReadDeadline(time.Now().Add(idleTimeout))
+		header, fin, opcode, length, mask, err := readWSFrameHeader(src)
+		if err != nil {
+			return closed
+		}
+		_ = srcConn.SetReadDeadline(time.Time{})
+		if _, err := dst.Write(header); err != nil {
+			return closed
+		}
+
+		if opcode != wsOpContinuation && opcode < wsOpClose {
+			msgOpcode = opcode
+			msg.Reset()
+			msgTruncated = false
+		}
+
+		// Read as much of a text payload as fits under the cap; the rest is
+		// streamed through without being kept
+		var keep int64
+		if msgOpcode == wsOpText && opcode < wsOpClose {
+			keep = min(length, int64(max(maxBytes-msg.Len(), 0)))
+			msgTruncated = msgTruncated || keep < length
+		}
+		if keep > 0 {
+			payload := make([]byte, keep)
+			if _, err := io.ReadFull(src, payload); err != nil {
+				return closed
+			}
+			if _, err := dst.Write(payload); err != nil {
+				return closed
+			}
+			if mask != nil {
+				for i := range payload {
+					payload[i] ^= mask[i%4]
+				}
+			}
+			msg.Write(payload)
+		}
+		if _, err := io.CopyN(dst, src, length-keep); err != nil {
+			return closed
+		}
+
+		if opcode == wsOpClose {
+			closed = true
+		}
+		if fin && opcode < wsOpClose && msgOpcode == wsOpText {
+			rec.record(direction, msg.Bytes(), msgTruncated)
+		}
+	}
+}
+
+// readWSFrameHeader reads one frame header from r, returning its raw bytes
+// for relaying along with the decoded fields. mask is nil for unmasked frames.
+func readWSFrameHeader(r io.Reader) (raw []byte, fin bool, opcode byte, length int64, mask []byte, err error) {
+	raw = make([]byte, 2, 14)
+	if _, err = io.ReadFull(r, raw); err != nil {
+		return nil, false, 0, 0, nil, err
+	}
+	fin = raw[0]&0x80 != 0
+	opcode = raw[0] & 0x0f
+	masked := raw[1]&0x80 != 0
+	length = int64(raw[1] & 0x7f)
+
+	var ext int
+	switch length {
+	case 126:
+		ext = 2
+	case 127:
+		ext = 8
+	}
+	if masked {
+		ext += 4
+	}
+	if ext > 0 {
+		raw = raw[:2+ext]
+		if _, err = io.ReadFull(r, raw[2:]); err != nil {
+			return nil, false, 0, 0, nil, err
+		}
+	}
+	switch length {
+	case 126:
+		length = int64(binary.BigEndian.Uint16(raw[2:4]))
+	case 127:
+		length = int64(binary.BigEndian.Uint64(raw[2:10]) & (1<<63 - 1))
+	}
+	if masked {
+		mask = raw[len(raw)-4:]
+	}
+	return raw, fin, opcode, length, mask, nil
+}
+
+// wsRecorder stores the text messages of one WebSocket session as events,
+// numbered in the order they pass through the proxy in either direction.
+// Events go to a writer goroutine, as in streamSSEWithParser, so the relay
+// doesn't wait on each store write; parser.store_deltas and
+// parser.max_events_per_flow apply as they do to SSE.
+type wsRecorder struct {
+	p        *MITMProxy
+	flowID   string
+	persist  bool
+	mu       sync.Mutex
+	sequence int
+	events   chan *store.Event
+	done     chan struct{}
+	skipped  int // Set by the writer; read after done is closed
+	dropped  int
+}
+
+// newWSRecorder returns a recorder for flowID with its writer running.
+// Call finish once both relays have stopped.
+func (p *MITMProxy) newWSRecorder(flowID string, persist bool) *wsRecorder {
+	w := &wsRecorder{
+		p:       p,
+		flowID:  flowID,
+		persist: persist,
+		events:  make(chan *store.Event, 100),
+		done:    make(chan struct{}),
+	}
+	go w.write()
+	return w
+}
+
+// write stores and broadcasts events until the channel is closed.
+func (w *wsRecorder) write() {
+	defer close(w.done)
+	p := w.p
+	sampler := p.deltaFilter.sampler()
+	maxEvents := p.cfg.Parser.MaxEventsPerFlow
+	stored := 0
+	for event := range w.events {
+		if p.store != nil && w.persist && sampler.keep(event) {
+			if maxEvents > 0 && stored >= maxEvents {
+				// One drop log entry per flow, not one per event
+				if w.dropped == 0 {
+					p.logMaxEventsDrop(w.flowID, event)
+				}
+				w.dropped++
+			} else {
+				stored++
+				if ttl := p.cfg.Retention.Snapshot().EventsTTLDays; ttl > 0 {
+					expiresAt := event.Timestamp.AddDate(0, 0, ttl)
+					event.ExpiresAt = &expiresAt
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				if err := p.store.SaveEvent(ctx, event); err != nil {
+					p.logger.Error("failed to save WebSocket event", "flow_id", w.flowID, "error", err)
+				}
+				cancel()
+			}
+		}
+		if p.onEvent != nil {
+			p.onEvent(event)
+		}
+	}
+	w.skipped = sampler.skipped
+}
+
+// finish waits for the writer to handle every recorded event and returns
+// how many deltas were skipped and events dropped.
+func (w *wsRecorder) finish() (skipped, dropped int) {
+	close(w.events)
+	<-w.done
+	return w.skipped, w.dropped
+}
+
+// record queues a text message. JSON objects are stored as parsed, with their
+// "type" field as the event type, the way SSE events are; anything else is
+// kept under "raw". "_direction" says which side sent it.
+func (w *wsRecorder) record(direction string, data []byte, truncated bool) {
+	eventType := "message"
+	var eventData map[string]interface{}
+	if err := json.Unmarshal(data, &eventData); err != nil || eventData == nil {
+		eventData = map[string]interface{}{"raw": string(data)}
+	} else if t, ok := eventData["type"].(string); ok && t != "" {
+		eventType = t
+	}
+	eventData["_direction"] = direction
+	if truncated {
+		eventData["_truncated"] = true
+	}
+	priority, ok := parser.EventPriority[eventType]
+	if !ok {
+		priority = queue.PriorityMedium
+	}
+
+	// Queued under the lock so events reach the writer in sequence order
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.sequence++
+	w.events <- &store.Event{
+		ID:            uuid.New().String(),
+		FlowID:        w.flowID,
+		Sequence:      w.sequence,
+		Timestamp:     time.Now(),
+		TimestampMono: time.Now().UnixNano(),
+		EventType:     eventType,
+		EventData:     eventData,
+		Priority:      priority,
+	}
+}
@@ -0,0 +1,300 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/HakAl/langley/internal/config"
+	"github.com/HakAl/langley/internal/store/storetest"
+)
+
+func TestIsWebSocketUpgrade(t *testing.T) {
+	tests := []struct {
+		connection string
+		upgrade    string
+		want       bool
+	}{
+		{"Upgrade", "websocket", true},
+		{"keep-alive, Upgrade", "WebSocket", true},
+		{"keep-alive", "websocket", false},
+		{"Upgrade", "h2c", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.connection != "" {
+			h.Set("Connection", tt.connection)
+		}
+		if tt.upgrade != "" {
+			h.Set("Upgrade", tt.upgrade)
+		}
+		if got := isWebSocketUpgrade(h); got != tt.want {
+			t.Errorf("isWebSocketUpgrade(Connection=%q, Upgrade=%q) = %v, want %v", tt.connection, tt.upgrade, got, tt.want)
+		}
+	}
+}
+
+// wsEchoServer echoes every message back with the same message type.
+func wsEchoServer() *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			mt, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(mt, data); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func TestMITMProxy_WebSocket(t *testing.T) {
+	t.Parallel()
+
+	upstream := wsEchoServer()
+	defer upstream.Close()
+
+	p, proxyAddr, capture, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+		cfg.Proxy.UpstreamOverrides = map[string]string{"api.anthropic.com": upstream.Listener.Addr().String()}
+		cfg.Persistence.BodyMaxBytes = 1024
+	})
+	defer cleanup()
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(p.ca.CertPEM())
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyURL(mustParseURL(t, "http://"+proxyAddr)),
+		TLSClientConfig:  &tls.Config{RootCAs: pool},
+		HandshakeTimeout: 5 * time.Second,
+	}
+	conn, resp, err := dialer.Dial("wss://api.anthropic.com/v1/realtime", nil)
+	if err != nil {
+		t.Fatalf("dial through proxy: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status = %d, want 101", resp.StatusCode)
+	}
+
+	// A JSON message, a binary message and a text message larger than both
+	// the 16-bit length form and the capture cap
+	large := strings.Repeat("x", 70000)
+	messages := []struct {
+		mt   int
+		data []byte
+	}{
+		{websocket.TextMessage, []byte(`{"type":"session.update","session":{"voice":"alloy"}}`)},
+		{websocket.BinaryMessage, []byte{0x00, 0xff, 0x10, 0x80}},
+		{websocket.TextMessage, []byte(large)},
+	}
+	for _, m := range messages {
+		if err := conn.WriteMessage(m.mt, m.data); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		mt, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read echo: %v", err)
+		}
+		if mt != m.mt || !bytes.Equal(data, m.data) {
+			t.Fatalf("echo = type %d, %d bytes; want type %d, %d bytes intact", mt, len(data), m.mt, len(m.data))
+		}
+	}
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	_, _, _ = conn.ReadMessage()
+	conn.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if f := capture.Flow(); f != nil && f.DurationMs != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	flow := capture.Flow()
+	if flow == nil || !flow.IsWebSocket || flow.DurationMs == nil {
+		t.Fatalf("flow = %+v, want a finished WebSocket flow", flow)
+	}
+	if flow.StatusCode == nil || *flow.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("StatusCode = %v, want 101", flow.StatusCode)
+	}
+	if flow.FlowIntegrity != "complete" {
+		t.Errorf("FlowIntegrity = %q, want complete", flow.FlowIntegrity)
+	}
+
+	// Two text messages each way; binary messages aren't stored
+	events := capture.Events()
+	if len(events) != 4 {
+		t.Fatalf("got %d events, want 4", len(events))
+	}
+	var client, server int
+	for _, e := range events {
+		if e.FlowID != flow.ID {
+			t.Errorf("event flow_id = %q, want %q", e.FlowID, flow.ID)
+		}
+		switch e.EventData["_direction"] {
+		case "client":
+			client++
+		case "server":
+			server++
+		}
+		switch e.EventType {
+		case "session.update":
+			session, _ := e.EventData["session"].(map[string]interface{})
+			if session["voice"] != "alloy" {
+				t.Errorf("session.update data = %v", e.EventData)
+			}
+		case "message":
+			raw, _ := e.EventData["raw"].(string)
+			if len(raw) != 1024 || e.EventData["_truncated"] != true {
+				t.Errorf("large message stored %d bytes, truncated=%v; want 1024, true", len(raw), e.EventData["_truncated"])
+			}
+		default:
+			t.Errorf("unexpected event type %q", e.EventType)
+		}
+	}
+	if client != 2 || server != 2 {
+		t.Errorf("directions: %d client, %d server; want 2 each", client, server)
+	}
+}
+
+func TestMITMProxy_WebSocketEventLimits(t *testing.T) {
+	t.Parallel()
+
+	upstream := wsEchoServer()
+	defer upstream.Close()
+
+	p, proxyAddr, capture, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+		cfg.Proxy.UpstreamOverrides = map[string]string{"api.anthropic.com": upstream.Listener.Addr().String()}
+		cfg.Parser.StoreDeltas = "sampled:2"
+		cfg.Parser.MaxEventsPerFlow = 5
+	})
+	defer cleanup()
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(p.ca.CertPEM())
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyURL(mustParseURL(t, "http://"+proxyAddr)),
+		TLSClientConfig:  &tls.Config{RootCAs: pool},
+		HandshakeTimeout: 5 * time.Second,
+	}
+	conn, _, err := dialer.Dial("wss://api.anthropic.com/v1/realtime", nil)
+	if err != nil {
+		t.Fatalf("dial through proxy: %v", err)
+	}
+
+	// Each message is echoed: 2 session.update and 12 audio appends in all
+	messages := []string{`{"type":"session.update"}`}
+	for i := 0; i < 6; i++ {
+		messages = append(messages, fmt.Sprintf(`{"type":"input_audio_buffer.append","audio":"AAAA%d"}`, i))
+	}
+	for _, m := range messages {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(m)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, _, err := conn.ReadMessage(); err != nil {
+			t.Fatalf("read echo: %v", err)
+		}
+	}
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	_, _, _ = conn.ReadMessage()
+	conn.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if f := capture.Flow(); f != nil && f.DurationMs != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	flow := capture.Flow()
+	if flow == nil || flow.DurationMs == nil {
+		t.Fatalf("flow = %+v, want a finished WebSocket flow", flow)
+	}
+
+	// Half the appends are sampled out; 8 events remain, 5 of them stored
+	if flow.EventsSkippedCount != 6 {
+		t.Errorf("EventsSkippedCount = %d, want 6", flow.EventsSkippedCount)
+	}
+	if flow.EventsDroppedCount != 3 {
+		t.Errorf("EventsDroppedCount = %d, want 3", flow.EventsDroppedCount)
+	}
+	if got := len(capture.Events()); got != 14 {
+		t.Errorf("broadcast %d events, want 14", got)
+	}
+	ms := p.store.(*storetest.Store)
+	events, err := ms.GetEventsByFlow(context.Background(), flow.ID)
+	if err != nil {
+		t.Fatalf("GetEventsByFlow: %v", err)
+	}
+	if len(events) != 5 {
+		t.Errorf("stored %d events, want 5", len(events))
+	}
+	for i, e := range events {
+		if e.Sequence <= 0 || (i > 0 && e.Sequence <= events[i-1].Sequence) {
+			t.Errorf("stored events out of sequence: %d after %d", e.Sequence, events[max(i-1, 0)].Sequence)
+		}
+	}
+	drops := ms.DropLog()
+	if len(drops) != 1 || drops[0].Reason != "max_events" {
+		t.Errorf("drop log = %+v, want one max_events entry", drops)
+	}
+}
+
+func TestMITMProxy_WebSocketDeclined(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no websockets here", http.StatusForbidden)
+	}))
+	defer upstream.Close()
+
+	p, proxyAddr, capture, cleanup := setupMITMProxy(t, func(cfg *config.Config) {
+		cfg.Proxy.UpstreamOverrides = map[string]string{"api.anthropic.com": upstream.Listener.Addr().String()}
+	})
+	defer cleanup()
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(p.ca.CertPEM())
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyURL(mustParseURL(t, "http://"+proxyAddr)),
+		TLSClientConfig:  &tls.Config{RootCAs: pool},
+		HandshakeTimeout: 5 * time.Second,
+	}
+	_, resp, err := dialer.Dial("wss://api.anthropic.com/v1/realtime", nil)
+	if err == nil {
+		t.Fatal("dial succeeded, want the upstream's refusal")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("response = %v, want 403", resp)
+	}
+
+	flow := capture.WaitForFlow(2 * time.Second)
+	if flow == nil || flow.IsWebSocket {
+		t.Fatalf("flow = %+v, want a non-WebSocket flow", flow)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && capture.Flow().ResponseBody == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if body := capture.Flow().ResponseBody; body == nil || !strings.Contains(*body, "no websockets here") {
+		t.Errorf("ResponseBody = %v, want the upstream's error", body)
+	}
+}
@@ -3,7 +3,9 @@
 package redact
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
 
@@ -14,6 +16,9 @@ const (
 	// RedactedValue is the replacement for redacted content.
 	RedactedValue = "[REDACTED]"
 
+	// RedactedJWTValue is the replacement for redacted JWTs and bearer tokens.
+	RedactedJWTValue = "[REDACTED_JWT]"
+
 	// RedactedImageValue is the replacement for redacted base64 images.
 	RedactedImageValue = "[IMAGE base64 redacted]"
 
@@ -25,17 +30,26 @@ const (
 
 // Redactor handles credential redaction.
 type Redactor struct {
-	cfg                   *config.RedactionConfig
+	cfg                   config.RedactionConfig // Copied; a config change needs a new Redactor
 	headerPatterns        []*regexp.Regexp
 	apiKeyPattern         *regexp.Regexp
+	tokenPattern          *regexp.Regexp
 	base64Pattern         *regexp.Regexp
 	jsonCredentialPattern *regexp.Regexp
+	customPatterns        []customPattern
+}
+
+// customPattern is a compiled redaction.custom_patterns entry.
+type customPattern struct {
+	re          *regexp.Regexp
+	replacement string
 }
 
-// New creates a new Redactor with the given configuration.
+// New creates a new Redactor from a copy of cfg, so it never changes
+// under flows that are using it.
 func New(cfg *config.RedactionConfig) (*Redactor, error) {
 	r := &Redactor{
-		cfg: cfg,
+		cfg: cfg.Snapshot(),
 	}
 
 	// Compile header patterns
@@ -71,6 +85,15 @@ func New(cfg *config.RedactionConfig) (*Redactor, error) {
 		`api[_-]?key[=:]\\?"?[a-zA-Z0-9_-]{20,}` +
 		`)`)
 
+	// Bearer token patterns for credentials that aren't provider keys:
+	// - JWT: three base64url segments, header and payload being JSON objects
+	//   (so starting eyJ), with a signature long enough for any real algorithm
+	// - Bearer <token>: an opaque token of 20+ chars after "Bearer", which
+	//   RedactBody only redacts if it also looks random (see opaqueToken)
+	// Minimum lengths keep version numbers, hostnames and prose from matching.
+	r.tokenPattern = regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{16,}|` +
+		`(?i:\bbearer)\s+[A-Za-z0-9._~+/-]{20,}=*`)
+
 	// Base64 image pattern (data URLs and raw base64 in JSON)
 	r.base64Pattern = regexp.MustCompile(`(?i)(data:image/[^;]+;base64,)[A-Za-z0-9+/=]{100,}|"(source|data)":\s*\{\s*"type":\s*"base64"[^}]*"data":\s*"[A-Za-z0-9+/=]{100,}"`)
 
@@ -80,6 +103,20 @@ func New(cfg *config.RedactionConfig) (*Redactor, error) {
 	// Handles both regular and JSON-escaped quotes
 	r.jsonCredentialPattern = regexp.MustCompile(`(?i)"([^"]*(?:password|secret|credential)[^"]*)":\s*"([^"\\]*(?:\\.[^"\\]*)*)"`)
 
+	// Custom patterns are user-supplied, so a bad regex is a startup error
+	// rather than a silently skipped rule
+	for i, p := range cfg.CustomPatterns {
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redaction.custom_patterns[%d] %q: %w", i, p.Pattern, err)
+		}
+		replacement := p.Replacement
+		if replacement == "" {
+			replacement = RedactedValue
+		}
+		r.customPatterns = append(r.customPatterns, customPattern{re: re, replacement: replacement})
+	}
+
 	return r, nil
 }
 
@@ -169,6 +206,20 @@ func (r *Redactor) RedactBody(body string) string {
 		})
 	}
 
+	// Redact JWTs and bearer tokens
+	if r.cfg.RedactAPIKeys {
+		result = r.tokenPattern.ReplaceAllStringFunc(result, func(match string) string {
+			// Keep the scheme so the redacted body still reads as an auth value
+			if i := strings.IndexAny(match, " \t\r\n"); i > 0 && strings.EqualFold(match[:i], "bearer") {
+				if !opaqueToken(strings.TrimLeft(match[i:], " \t\r\n")) {
+					return match
+				}
+				return match[:i] + " " + RedactedJWTValue
+			}
+			return RedactedJWTValue
+		})
+	}
+
 	// Redact base64 images
 	if r.cfg.RedactBase64Images {
 		result = r.base64Pattern.ReplaceAllStringFunc(result, func(match string) string {
@@ -201,15 +252,95 @@ func (r *Redactor) RedactBody(body string) string {
 		})
 	}
 
+	// Redact user-defined patterns (always on when configured)
+	for _, p := range r.customPatterns {
+		result = p.re.ReplaceAllString(result, p.replacement)
+	}
+
 	return result
 }
 
+// sensitiveQueryParam matches query parameter names that commonly carry
+// credentials, e.g. Gemini's ?key= or presigned ?X-Amz-Signature=.
+var sensitiveQueryParam = regexp.MustCompile(`(?i)(key|token|secret|password|signature|credential|auth)`)
+
+// opaqueToken reports whether a bearer value looks like a generated token
+// rather than a long word: it has a digit, or upper and lower case letters
+// past a leading capital.
+func opaqueToken(s string) bool {
+	var upper, lower bool
+	for i, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			return true
+		case c >= 'A' && c <= 'Z':
+			upper = upper || i > 0
+		case c >= 'a' && c <= 'z':
+			lower = true
+		}
+	}
+	return upper && lower
+}
+
+// RedactURL returns u as a string with credentials removed: the userinfo
+// password, values of sensitive query parameters, and any secrets RedactBody
+// would catch in the remainder. Parameter order is preserved.
+func (r *Redactor) RedactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	c := *u
+	if c.RawQuery != "" {
+		params := strings.Split(c.RawQuery, "&")
+		for i, param := range params {
+			name, _, _ := strings.Cut(param, "=")
+			if unescaped, err := url.QueryUnescape(name); err == nil {
+				name = unescaped
+			}
+			if sensitiveQueryParam.MatchString(name) {
+				params[i] = url.QueryEscape(name) + "=" + RedactedValue
+			}
+		}
+		c.RawQuery = strings.Join(params, "&")
+	}
+	return r.RedactBody(c.Redacted())
+}
+
+// RedactURLString is RedactURL for an unparsed URL. Unparseable input is
+// redacted as a body string.
+func (r *Redactor) RedactURLString(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return r.RedactBody(raw)
+	}
+	return r.RedactURL(u)
+}
+
 // RedactBodyBytes redacts sensitive content in a body.
 // Returns the redacted body as bytes.
 func (r *Redactor) RedactBodyBytes(body []byte) []byte {
 	return []byte(r.RedactBody(string(body)))
 }
 
+// RedactForStorage redacts a body about to be stored. With scrub_on_read
+// the body is stored raw and RedactForRead redacts it when served.
+func (r *Redactor) RedactForStorage(body string) string {
+	if r.cfg.ScrubOnRead {
+		return body
+	}
+	return r.RedactBody(body)
+}
+
+// RedactForRead redacts a stored body about to be served, when
+// scrub_on_read is on. Otherwise the body was redacted when stored and is
+// returned as-is.
+func (r *Redactor) RedactForRead(body string) string {
+	if !r.cfg.ScrubOnRead {
+		return body
+	}
+	return r.RedactBody(body)
+}
+
 // ShouldStoreBody returns whether body storage is enabled (default: true).
 func (r *Redactor) ShouldStoreBody() bool {
 	return !r.cfg.DisableBodyStorage
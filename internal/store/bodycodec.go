@@ -0,0 +1,53 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// bodyEncodingGzip marks a request_body or response_body column holding a
+// gzip stream instead of text. A NULL encoding is plain text, which is how
+// every body was stored before compression existed.
+const bodyEncodingGzip = "gzip"
+
+// encodeBody returns the value to store for body and its encoding. With
+// compress set, the body is gzipped, unless that doesn't make it smaller,
+// as with short or already compressed bodies.
+func encodeBody(body *string, compress bool) (value interface{}, encoding *string) {
+	if body == nil {
+		return nil, nil
+	}
+	if !compress {
+		return *body, nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(zw, *body); err != nil || zw.Close() != nil || buf.Len() >= len(*body) {
+		return *body, nil
+	}
+	enc := bodyEncodingGzip
+	return buf.Bytes(), &enc
+}
+
+// decodeBody reverses encodeBody for a stored body and its encoding column.
+func decodeBody(stored string, encoding string) (string, error) {
+	switch encoding {
+	case "":
+		return stored, nil
+	case bodyEncodingGzip:
+		zr, err := gzip.NewReader(bytes.NewReader([]byte(stored)))
+		if err != nil {
+			return "", err
+		}
+		body, err := io.ReadAll(zr)
+		if err != nil {
+			return "", err
+		}
+		return string(body), nil
+	default:
+		return "", fmt.Errorf("unknown body encoding %q", encoding)
+	}
+}
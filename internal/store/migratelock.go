@@ -0,0 +1,111 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrMigrationLocked is returned when another instance holds the migration
+// lock for longer than the wait timeout.
+var ErrMigrationLocked = errors.New("migration lock held by another instance")
+
+// Migration lock timing. The lock is only taken while migrations run, so a
+// holder older than migrationLockStaleAfter crashed mid-migration.
+var (
+	migrationLockTimeout    = 30 * time.Second
+	migrationLockPoll       = 100 * time.Millisecond
+	migrationLockStaleAfter = 5 * time.Minute
+)
+
+// newInstanceID returns an id identifying this process in lock_holder.
+func newInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s:%d:%s", host, os.Getpid(), uuid.New().String()[:8])
+}
+
+// formatLockHolder encodes the holder id and acquisition time for lock_holder.
+func formatLockHolder(instanceID string, at time.Time) string {
+	return instanceID + "@" + at.UTC().Format(time.RFC3339Nano)
+}
+
+// parseLockHolder splits a lock_holder value into id and acquisition time.
+// A value without a parseable time returns the zero time, which is stale.
+func parseLockHolder(v string) (string, time.Time) {
+	i := strings.LastIndex(v, "@")
+	if i < 0 {
+		return v, time.Time{}
+	}
+	at, err := time.Parse(time.RFC3339Nano, v[i+1:])
+	if err != nil {
+		return v, time.Time{}
+	}
+	return v[:i], at
+}
+
+// acquireMigrationLock sets lock_holder to this instance, waiting up to
+// migrationLockTimeout for another holder to finish. Stale holders are taken
+// over. It returns the value to pass to releaseMigrationLock.
+func (s *SQLiteStore) acquireMigrationLock(instanceID string) (string, error) {
+	deadline := time.Now().Add(migrationLockTimeout)
+	for {
+		var current sql.NullString
+		if err := s.db.QueryRow("SELECT lock_holder FROM schema_version WHERE id = 1").Scan(&current); err != nil {
+			if isBusy(err) && time.Now().Before(deadline) {
+				time.Sleep(migrationLockPoll)
+				continue
+			}
+			return "", fmt.Errorf("reading migration lock: %w", err)
+		}
+
+		holderID, since := parseLockHolder(current.String)
+		if !current.Valid || current.String == "" || time.Since(since) > migrationLockStaleAfter {
+			// Compare-and-swap so two instances can't both take a free lock
+			holder := formatLockHolder(instanceID, time.Now())
+			res, err := s.db.Exec(
+				"UPDATE schema_version SET lock_holder = ? WHERE id = 1 AND COALESCE(lock_holder, '') = ?",
+				holder, current.String,
+			)
+			if err != nil {
+				if isBusy(err) && time.Now().Before(deadline) {
+					time.Sleep(migrationLockPoll)
+					continue
+				}
+				return "", fmt.Errorf("acquiring migration lock: %w", err)
+			}
+			if n, _ := res.RowsAffected(); n == 1 {
+				return holder, nil
+			}
+			continue // Lost the race; re-read the new holder
+		}
+
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("%w: %s since %s (taken over automatically after %s)",
+				ErrMigrationLocked, holderID, since.Format(time.RFC3339), migrationLockStaleAfter)
+		}
+		time.Sleep(migrationLockPoll)
+	}
+}
+
+// releaseMigrationLock clears lock_holder if this instance still holds it.
+func (s *SQLiteStore) releaseMigrationLock(holder string) error {
+	if _, err := s.db.Exec("UPDATE schema_version SET lock_holder = NULL WHERE id = 1 AND lock_holder = ?", holder); err != nil {
+		return fmt.Errorf("releasing migration lock: %w", err)
+	}
+	return nil
+}
+
+// isBusy reports whether err is SQLite refusing access because another
+// connection holds a conflicting lock.
+func isBusy(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "SQLITE_BUSY") || strings.Contains(msg, "database is locked")
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"runtime"
@@ -17,14 +18,135 @@ import (
 // SQLiteStore implements Store using SQLite.
 type SQLiteStore struct {
 	db        *sql.DB
+	readDB    *sql.DB // read-only pool, nil when reads share db
+	path      string
 	retention *config.RetentionConfig
+	// compressBodies gzips request and response bodies on write
+	compressBodies bool
+}
+
+// SQLiteOption configures optional SQLiteStore settings.
+type SQLiteOption func(*sqliteOptions)
+
+type sqliteOptions struct {
+	walAutocheckpointPages int
+	busyTimeoutMs          int
+	synchronous            string
+	cacheSizeKB            int
+	readConnections        int
+	compressBodies         bool
+}
+
+// Defaults used when an option is unset.
+const (
+	defaultBusyTimeoutMs = 5000
+	defaultSynchronous   = "NORMAL"
+)
+
+// WithWALAutocheckpoint sets PRAGMA wal_autocheckpoint to pages and limits
+// the WAL file left on disk after a checkpoint to the same size. Values <= 0
+// keep SQLite's defaults.
+func WithWALAutocheckpoint(pages int) SQLiteOption {
+	return func(o *sqliteOptions) {
+		o.walAutocheckpointPages = pages
+	}
+}
+
+// WithBusyTimeout sets PRAGMA busy_timeout, how long a connection waits on a
+// locked database before failing. Values <= 0 keep the 5s default.
+func WithBusyTimeout(ms int) SQLiteOption {
+	return func(o *sqliteOptions) {
+		o.busyTimeoutMs = ms
+	}
+}
+
+// WithSynchronous sets PRAGMA synchronous to OFF, NORMAL, FULL or EXTRA.
+// An empty mode keeps the NORMAL default.
+func WithSynchronous(mode string) SQLiteOption {
+	return func(o *sqliteOptions) {
+		o.synchronous = mode
+	}
+}
+
+// WithCacheSize sets PRAGMA cache_size to kb kibibytes per connection.
+// Values <= 0 keep SQLite's default.
+func WithCacheSize(kb int) SQLiteOption {
+	return func(o *sqliteOptions) {
+		o.cacheSizeKB = kb
+	}
+}
+
+// WithReadPool opens a second pool of n read-only connections that serve
+// flow listing and lookups, so they don't queue behind writes on the single
+// writer connection. Values <= 0, and in-memory databases, share the writer.
+func WithReadPool(n int) SQLiteOption {
+	return func(o *sqliteOptions) {
+		o.readConnections = n
+	}
+}
+
+// WithCompressBodies gzips request and response bodies before they are
+// written, when that makes them smaller. Bodies are decompressed on read
+// either way, so stores that already hold compressed or plain bodies can
+// switch freely.
+func WithCompressBodies(compress bool) SQLiteOption {
+	return func(o *sqliteOptions) {
+		o.compressBodies = compress
+	}
+}
+
+// WithPersistenceConfig applies the SQLite tuning and storage settings from cfg.
+func WithPersistenceConfig(cfg *config.PersistenceConfig) SQLiteOption {
+	return func(o *sqliteOptions) {
+		WithWALAutocheckpoint(cfg.WALAutocheckpointPages)(o)
+		WithBusyTimeout(cfg.BusyTimeoutMs)(o)
+		WithSynchronous(cfg.Synchronous)(o)
+		WithCacheSize(cfg.CacheSizeKB)(o)
+		WithReadPool(cfg.ReadConnections)(o)
+		WithCompressBodies(cfg.CompressBodies)(o)
+	}
+}
+
+// dsn builds the connection string for dbPath. The driver applies each
+// _pragma to every new connection, so settings hold across the pool.
+func (o *sqliteOptions) dsn(dbPath string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s?_pragma=journal_mode(WAL)&_pragma=synchronous(%s)&_pragma=busy_timeout(%d)&_pragma=foreign_keys(1)",
+		dbPath, o.synchronous, o.busyTimeoutMs)
+	if o.cacheSizeKB > 0 {
+		// A negative cache_size is in KiB rather than pages
+		fmt.Fprintf(&b, "&_pragma=cache_size(-%d)", o.cacheSizeKB)
+	}
+	if o.walAutocheckpointPages > 0 {
+		fmt.Fprintf(&b, "&_pragma=wal_autocheckpoint(%d)&_pragma=journal_size_limit(%d)",
+			o.walAutocheckpointPages, int64(o.walAutocheckpointPages)*walPageSize)
+	}
+	return b.String()
 }
 
 // NewSQLiteStore creates a new SQLite store.
-func NewSQLiteStore(dbPath string, retention *config.RetentionConfig) (*SQLiteStore, error) {
-	// Open database with WAL mode and recommended pragmas
-	dsn := fmt.Sprintf("%s?_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000", dbPath)
-	db, err := sql.Open("sqlite", dsn)
+func NewSQLiteStore(dbPath string, retention *config.RetentionConfig, opts ...SQLiteOption) (*SQLiteStore, error) {
+	var o sqliteOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.busyTimeoutMs <= 0 {
+		o.busyTimeoutMs = defaultBusyTimeoutMs
+	}
+	if o.synchronous == "" {
+		o.synchronous = defaultSynchronous
+	}
+	o.synchronous = strings.ToUpper(o.synchronous)
+	switch o.synchronous {
+	case "OFF", "NORMAL", "FULL", "EXTRA":
+	default:
+		return nil, fmt.Errorf("invalid synchronous mode %q", o.synchronous)
+	}
+
+	// Open database with WAL mode and recommended pragmas. Foreign keys are
+	// enabled for CASCADE behavior.
+	db, err := sql.Open("sqlite", o.dsn(dbPath))
 	if err != nil {
 		return nil, fmt.Errorf("opening database: %w", err)
 	}
@@ -35,12 +157,6 @@ func NewSQLiteStore(dbPath string, retention *config.RetentionConfig) (*SQLiteSt
 		return nil, fmt.Errorf("connecting to database: %w", err)
 	}
 
-	// Enable foreign keys for CASCADE behavior
-	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("enabling foreign keys: %w", err)
-	}
-
 	// SECURITY: Set restrictive file permissions (2.2.11)
 	// Database may contain sensitive request/response data
 	if err := setSecureFilePermissions(dbPath); err != nil {
@@ -54,8 +170,10 @@ func NewSQLiteStore(dbPath string, retention *config.RetentionConfig) (*SQLiteSt
 	db.SetMaxIdleConns(1)
 
 	store := &SQLiteStore{
-		db:        db,
-		retention: retention,
+		db:             db,
+		path:           dbPath,
+		retention:      retention,
+		compressBodies: o.compressBodies,
 	}
 
 	// Run migrations
@@ -64,9 +182,36 @@ func NewSQLiteStore(dbPath string, retention *config.RetentionConfig) (*SQLiteSt
 		return nil, fmt.Errorf("running migrations: %w", err)
 	}
 
+	// Open the read pool after migrations so it never sees a partial schema.
+	// An in-memory database is private to its connection, so it can't have one.
+	if o.readConnections > 0 && dbPath != ":memory:" {
+		readDB, err := sql.Open("sqlite", o.dsn(dbPath)+"&_pragma=query_only(1)")
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("opening read pool: %w", err)
+		}
+		if err := readDB.Ping(); err != nil {
+			readDB.Close()
+			db.Close()
+			return nil, fmt.Errorf("connecting read pool: %w", err)
+		}
+		readDB.SetMaxOpenConns(o.readConnections)
+		readDB.SetMaxIdleConns(o.readConnections)
+		store.readDB = readDB
+	}
+
 	return store, nil
 }
 
+// reader returns the pool for read-only queries: the read pool when one is
+// configured, else the writer connection.
+func (s *SQLiteStore) reader() *sql.DB {
+	if s.readDB != nil {
+		return s.readDB
+	}
+	return s.db
+}
+
 // setSecureFilePermissions sets restrictive permissions on the database file.
 // On Unix: 0600 (owner read/write only)
 // On Windows: This is best-effort as file permissions work differently
@@ -92,11 +237,13 @@ func setSecureFilePermissions(path string) error {
 	return nil
 }
 
-// migrate runs database migrations.
-func (s *SQLiteStore) migrate() error {
+// migrate runs database migrations. Pending migrations run under the
+// schema_version lock_holder advisory lock so that two instances sharing a
+// database don't both apply them.
+func (s *SQLiteStore) migrate() (err error) {
 	// Check current version
 	var version int
-	err := s.db.QueryRow("SELECT version FROM schema_version WHERE id = 1").Scan(&version)
+	err = s.db.QueryRow("SELECT version FROM schema_version WHERE id = 1").Scan(&version)
 	if err != nil {
 		// Table doesn't exist, create it
 		if _, err := s.db.Exec(`
@@ -113,13 +260,51 @@ func (s *SQLiteStore) migrate() error {
 		version = 0
 	}
 
-	// Run migrations
 	migrations := []string{
-		migrationV1, // Initial schema
-		migrationV2, // Add tool_use_id to tool_invocations
-		migrationV3, // Add tool_input and tool_result to tool_invocations
+		migrationV1,  // Initial schema
+		migrationV2,  // Add tool_use_id to tool_invocations
+		migrationV3,  // Add tool_input and tool_result to tool_invocations
+		migrationV4,  // Add request_header_order to flows
+		migrationV5,  // Add flow_tags
+		migrationV6,  // Add tunnels
+		migrationV7,  // Add rate-limit columns to flows
+		migrationV8,  // Add prompt-cache breakpoint columns to flows
+		migrationV9,  // Add stop_reason and error columns to flows
+		migrationV10, // Add events_skipped_count to flows
+		migrationV11, // Index flows by request_signature
+		migrationV12, // Index flows by provider
+		migrationV13, // Add request_body_invalid to flows
+		migrationV14, // Add is_websocket to flows
+		migrationV15, // Allow the ollama provider and local cost source
+		migrationV16, // Add notes to flows
+		migrationV17, // Add task_retention
+		migrationV18, // Add stream_mismatch to flows
+		migrationV19, // Allow the manual cost source
+		migrationV20, // Add body encodings to flows
+		migrationV21, // Add request and response body sizes to flows
+		migrationV22, // Store timestamps in UTC with a fixed-width fraction
+		migrationV23, // Add completed_at to flows
+	}
+	if version >= len(migrations) {
+		return nil
+	}
+
+	holder, err := s.acquireMigrationLock(newInstanceID())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if releaseErr := s.releaseMigrationLock(holder); releaseErr != nil && err == nil {
+			err = releaseErr
+		}
+	}()
+
+	// Another instance may have migrated while we waited for the lock
+	if err := s.db.QueryRow("SELECT version FROM schema_version WHERE id = 1").Scan(&version); err != nil {
+		return fmt.Errorf("reading schema version: %w", err)
 	}
 
+	// Run migrations
 	for i := version; i < len(migrations); i++ {
 		if _, err := s.db.Exec(migrations[i]); err != nil {
 			return fmt.Errorf("running migration %d: %w", i+1, err)
@@ -267,29 +452,389 @@ ALTER TABLE tool_invocations ADD COLUMN tool_input TEXT;
 ALTER TABLE tool_invocations ADD COLUMN tool_result TEXT;
 `
 
+const migrationV4 = `
+-- Add request_header_order column for order-preserving replay
+ALTER TABLE flows ADD COLUMN request_header_order TEXT;
+`
+
+const migrationV5 = `
+-- User-defined flow labels; deleted with their flow
+CREATE TABLE IF NOT EXISTS flow_tags (
+	flow_id TEXT NOT NULL REFERENCES flows(id) ON DELETE CASCADE,
+	key TEXT NOT NULL,
+	value TEXT NOT NULL DEFAULT '',
+	created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+	PRIMARY KEY (flow_id, key)
+);
+CREATE INDEX IF NOT EXISTS idx_flow_tags_key_value ON flow_tags(key, value);
+`
+
+const migrationV6 = `
+-- CONNECT tunnel access log, including passthrough hosts that record no flow
+CREATE TABLE IF NOT EXISTS tunnels (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	host TEXT NOT NULL,
+	mode TEXT NOT NULL CHECK (mode IN ('passthrough', 'intercepted')),
+	started_at TEXT NOT NULL,
+	ended_at TEXT NOT NULL,
+	bytes_up INTEGER NOT NULL DEFAULT 0,
+	bytes_down INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_tunnels_started ON tunnels(started_at DESC);
+`
+
+const migrationV7 = `
+-- Normalized rate-limit headers for quota tracking
+ALTER TABLE flows ADD COLUMN ratelimit_requests_limit INTEGER;
+ALTER TABLE flows ADD COLUMN ratelimit_requests_remaining INTEGER;
+ALTER TABLE flows ADD COLUMN ratelimit_tokens_limit INTEGER;
+ALTER TABLE flows ADD COLUMN ratelimit_tokens_remaining INTEGER;
+ALTER TABLE flows ADD COLUMN ratelimit_reset TEXT;
+`
+
+const migrationV8 = `
+-- Prompt-cache breakpoints (cache_control markers) in the request body
+ALTER TABLE flows ADD COLUMN cache_breakpoints INTEGER;
+ALTER TABLE flows ADD COLUMN cache_breakpoint_positions TEXT;
+`
+
+const migrationV9 = `
+-- Why generation stopped, and the API error for failed requests
+ALTER TABLE flows ADD COLUMN stop_reason TEXT;
+ALTER TABLE flows ADD COLUMN error_type TEXT;
+ALTER TABLE flows ADD COLUMN error_message TEXT;
+CREATE INDEX IF NOT EXISTS idx_flows_stop_reason ON flows(stop_reason);
+`
+
+const migrationV10 = `
+-- SSE deltas left out of storage by parser.store_deltas
+ALTER TABLE flows ADD COLUMN events_skipped_count INTEGER DEFAULT 0;
+`
+
+const migrationV11 = `
+-- Finding repeated identical requests groups by signature
+CREATE INDEX IF NOT EXISTS idx_flows_request_signature ON flows(request_signature, timestamp) WHERE request_signature IS NOT NULL;
+`
+
+const migrationV12 = `
+-- Filtering flows and exports by provider
+CREATE INDEX IF NOT EXISTS idx_flows_provider ON flows(provider, timestamp DESC);
+`
+
+const migrationV13 = `
+-- Request bodies sent as JSON that failed to parse (proxy.validate_request_json)
+ALTER TABLE flows ADD COLUMN request_body_invalid INTEGER DEFAULT 0;
+`
+
+const migrationV14 = `
+-- WebSocket sessions captured through the MITM proxy
+ALTER TABLE flows ADD COLUMN is_websocket INTEGER DEFAULT 0;
+`
+
+// migrationV15 widens the provider and cost_source CHECK constraints. SQLite
+// can't alter a constraint, so flows is rebuilt with the same columns, copied
+// by name. Foreign keys are off while the old table is dropped, or the
+// drop would cascade to events, tool invocations and tags.
+const migrationV15 = `
+-- Local models served by Ollama, which cost nothing
+PRAGMA foreign_keys = OFF;
+CREATE TABLE flows_v15 (
+	id TEXT PRIMARY KEY,
+	task_id TEXT,
+	task_source TEXT CHECK (task_source IS NULL OR task_source IN ('explicit', 'metadata', 'inferred')),
+	host TEXT NOT NULL,
+	method TEXT NOT NULL,
+	path TEXT NOT NULL,
+	url TEXT NOT NULL,
+	timestamp TEXT NOT NULL,
+	timestamp_mono INTEGER,
+	duration_ms INTEGER,
+	status_code INTEGER,
+	status_text TEXT,
+	is_sse INTEGER DEFAULT 0,
+	flow_integrity TEXT DEFAULT 'complete' CHECK (flow_integrity IN ('complete', 'partial', 'corrupted', 'interrupted')),
+	events_dropped_count INTEGER DEFAULT 0,
+	request_body TEXT,
+	request_body_truncated INTEGER DEFAULT 0,
+	response_body TEXT,
+	response_body_truncated INTEGER DEFAULT 0,
+	request_headers TEXT,
+	response_headers TEXT,
+	request_signature TEXT,
+	request_signature_version INTEGER DEFAULT 1,
+	input_tokens INTEGER,
+	output_tokens INTEGER,
+	cache_creation_tokens INTEGER,
+	cache_read_tokens INTEGER,
+	total_cost REAL,
+	cost_source TEXT CHECK (cost_source IS NULL OR cost_source IN ('exact', 'estimated', 'local')),
+	model TEXT,
+	provider TEXT DEFAULT 'anthropic' CHECK (provider IN ('anthropic', 'openai', 'bedrock', 'gemini', 'ollama', 'other')),
+	created_at TEXT NOT NULL DEFAULT (datetime('now')),
+	expires_at TEXT,
+	request_header_order TEXT,
+	ratelimit_requests_limit INTEGER,
+	ratelimit_requests_remaining INTEGER,
+	ratelimit_tokens_limit INTEGER,
+	ratelimit_tokens_remaining INTEGER,
+	ratelimit_reset TEXT,
+	cache_breakpoints INTEGER,
+	cache_breakpoint_positions TEXT,
+	stop_reason TEXT,
+	error_type TEXT,
+	error_message TEXT,
+	events_skipped_count INTEGER DEFAULT 0,
+	request_body_invalid INTEGER DEFAULT 0,
+	is_websocket INTEGER DEFAULT 0
+);
+INSERT INTO flows_v15 (
+	id, task_id, task_source, host, method, path, url, timestamp, timestamp_mono, duration_ms,
+	status_code, status_text, is_sse, flow_integrity, events_dropped_count, request_body,
+	request_body_truncated, response_body, response_body_truncated, request_headers,
+	response_headers, request_signature, request_signature_version, input_tokens, output_tokens,
+	cache_creation_tokens, cache_read_tokens, total_cost, cost_source, model, provider, created_at,
+	expires_at, request_header_order, ratelimit_requests_limit, ratelimit_requests_remaining,
+	ratelimit_tokens_limit, ratelimit_tokens_remaining, ratelimit_reset, cache_breakpoints,
+	cache_breakpoint_positions, stop_reason, error_type, error_message, events_skipped_count,
+	request_body_invalid, is_websocket
+)
+SELECT
+	id, task_id, task_source, host, method, path, url, timestamp, timestamp_mono, duration_ms,
+	status_code, status_text, is_sse, flow_integrity, events_dropped_count, request_body,
+	request_body_truncated, response_body, response_body_truncated, request_headers,
+	response_headers, request_signature, request_signature_version, input_tokens, output_tokens,
+	cache_creation_tokens, cache_read_tokens, total_cost, cost_source, model, provider, created_at,
+	expires_at, request_header_order, ratelimit_requests_limit, ratelimit_requests_remaining,
+	ratelimit_tokens_limit, ratelimit_tokens_remaining, ratelimit_reset, cache_breakpoints,
+	cache_breakpoint_positions, stop_reason, error_type, error_message, events_skipped_count,
+	request_body_invalid, is_websocket
+FROM flows;
+DROP TABLE flows;
+ALTER TABLE flows_v15 RENAME TO flows;
+CREATE INDEX IF NOT EXISTS idx_flows_timestamp ON flows(timestamp DESC);
+CREATE INDEX IF NOT EXISTS idx_flows_task_timestamp ON flows(task_id, timestamp DESC);
+CREATE INDEX IF NOT EXISTS idx_flows_host_timestamp ON flows(host, timestamp DESC);
+CREATE INDEX IF NOT EXISTS idx_flows_expires ON flows(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_flows_model ON flows(model, timestamp);
+CREATE INDEX IF NOT EXISTS idx_flows_stop_reason ON flows(stop_reason);
+CREATE INDEX IF NOT EXISTS idx_flows_request_signature ON flows(request_signature, timestamp) WHERE request_signature IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_flows_provider ON flows(provider, timestamp DESC);
+PRAGMA foreign_keys = ON;
+`
+
+const migrationV16 = `
+-- Free-text triage notes, set from the dashboard
+ALTER TABLE flows ADD COLUMN notes TEXT;
+`
+
+const migrationV17 = `
+-- Per-task retention overrides: a task's flows expire ttl_days after their
+-- timestamp instead of after the global flows_ttl_days
+CREATE TABLE IF NOT EXISTS task_retention (
+	task_id TEXT PRIMARY KEY,
+	ttl_days INTEGER NOT NULL CHECK (ttl_days > 0),
+	created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+);
+`
+
+const migrationV18 = `
+-- Responses that streamed when the request said not to, or the other way round
+ALTER TABLE flows ADD COLUMN stream_mismatch INTEGER DEFAULT 0;
+`
+
+// migrationV19 widens the cost_source CHECK constraint, rebuilding flows the
+// same way as migrationV15.
+const migrationV19 = `
+-- Costs set by the client with X-Langley-Cost
+PRAGMA foreign_keys = OFF;
+CREATE TABLE flows_v19 (
+	id TEXT PRIMARY KEY,
+	task_id TEXT,
+	task_source TEXT CHECK (task_source IS NULL OR task_source IN ('explicit', 'metadata', 'inferred')),
+	host TEXT NOT NULL,
+	method TEXT NOT NULL,
+	path TEXT NOT NULL,
+	url TEXT NOT NULL,
+	timestamp TEXT NOT NULL,
+	timestamp_mono INTEGER,
+	duration_ms INTEGER,
+	status_code INTEGER,
+	status_text TEXT,
+	is_sse INTEGER DEFAULT 0,
+	flow_integrity TEXT DEFAULT 'complete' CHECK (flow_integrity IN ('complete', 'partial', 'corrupted', 'interrupted')),
+	events_dropped_count INTEGER DEFAULT 0,
+	request_body TEXT,
+	request_body_truncated INTEGER DEFAULT 0,
+	response_body TEXT,
+	response_body_truncated INTEGER DEFAULT 0,
+	request_headers TEXT,
+	response_headers TEXT,
+	request_signature TEXT,
+	request_signature_version INTEGER DEFAULT 1,
+	input_tokens INTEGER,
+	output_tokens INTEGER,
+	cache_creation_tokens INTEGER,
+	cache_read_tokens INTEGER,
+	total_cost REAL,
+	cost_source TEXT CHECK (cost_source IS NULL OR cost_source IN ('exact', 'estimated', 'local', 'manual')),
+	model TEXT,
+	provider TEXT DEFAULT 'anthropic' CHECK (provider IN ('anthropic', 'openai', 'bedrock', 'gemini', 'ollama', 'other')),
+	created_at TEXT NOT NULL DEFAULT (datetime('now')),
+	expires_at TEXT,
+	request_header_order TEXT,
+	ratelimit_requests_limit INTEGER,
+	ratelimit_requests_remaining INTEGER,
+	ratelimit_tokens_limit INTEGER,
+	ratelimit_tokens_remaining INTEGER,
+	ratelimit_reset TEXT,
+	cache_breakpoints INTEGER,
+	cache_breakpoint_positions TEXT,
+	stop_reason TEXT,
+	error_type TEXT,
+	error_message TEXT,
+	events_skipped_count INTEGER DEFAULT 0,
+	request_body_invalid INTEGER DEFAULT 0,
+	is_websocket INTEGER DEFAULT 0,
+	notes TEXT,
+	stream_mismatch INTEGER DEFAULT 0
+);
+INSERT INTO flows_v19 (
+	id, task_id, task_source, host, method, path, url, timestamp, timestamp_mono, duration_ms,
+	status_code, status_text, is_sse, flow_integrity, events_dropped_count, request_body,
+	request_body_truncated, response_body, response_body_truncated, request_headers,
+	response_headers, request_signature, request_signature_version, input_tokens, output_tokens,
+	cache_creation_tokens, cache_read_tokens, total_cost, cost_source, model, provider, created_at,
+	expires_at, request_header_order, ratelimit_requests_limit, ratelimit_requests_remaining,
+	ratelimit_tokens_limit, ratelimit_tokens_remaining, ratelimit_reset, cache_breakpoints,
+	cache_breakpoint_positions, stop_reason, error_type, error_message, events_skipped_count,
+	request_body_invalid, is_websocket, notes, stream_mismatch
+)
+SELECT
+	id, task_id, task_source, host, method, path, url, timestamp, timestamp_mono, duration_ms,
+	status_code, status_text, is_sse, flow_integrity, events_dropped_count, request_body,
+	request_body_truncated, response_body, response_body_truncated, request_headers,
+	response_headers, request_signature, request_signature_version, input_tokens, output_tokens,
+	cache_creation_tokens, cache_read_tokens, total_cost, cost_source, model, provider, created_at,
+	expires_at, request_header_order, ratelimit_requests_limit, ratelimit_requests_remaining,
+	ratelimit_tokens_limit, ratelimit_tokens_remaining, ratelimit_reset, cache_breakpoints,
+	cache_breakpoint_positions, stop_reason, error_type, error_message, events_skipped_count,
+	request_body_invalid, is_websocket, notes, stream_mismatch
+FROM flows;
+DROP TABLE flows;
+ALTER TABLE flows_v19 RENAME TO flows;
+CREATE INDEX IF NOT EXISTS idx_flows_timestamp ON flows(timestamp DESC);
+CREATE INDEX IF NOT EXISTS idx_flows_task_timestamp ON flows(task_id, timestamp DESC);
+CREATE INDEX IF NOT EXISTS idx_flows_host_timestamp ON flows(host, timestamp DESC);
+CREATE INDEX IF NOT EXISTS idx_flows_expires ON flows(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_flows_model ON flows(model, timestamp);
+CREATE INDEX IF NOT EXISTS idx_flows_stop_reason ON flows(stop_reason);
+CREATE INDEX IF NOT EXISTS idx_flows_request_signature ON flows(request_signature, timestamp) WHERE request_signature IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_flows_provider ON flows(provider, timestamp DESC);
+PRAGMA foreign_keys = ON;
+`
+
+const migrationV20 = `
+-- Bodies gzipped by persistence.compress_bodies; NULL is plain text
+ALTER TABLE flows ADD COLUMN request_body_encoding TEXT;
+ALTER TABLE flows ADD COLUMN response_body_encoding TEXT;
+`
+
+const migrationV21 = `
+-- Full body lengths as transferred, however much of each body is stored
+ALTER TABLE flows ADD COLUMN request_body_size INTEGER;
+ALTER TABLE flows ADD COLUMN response_body_size INTEGER;
+`
+
+const migrationV22 = `
+-- Timestamps were written in the local offset with a variable fraction,
+-- which doesn't compare as a string; rewrite them as UTC with nine digits
+UPDATE flows SET timestamp = COALESCE(strftime('%Y-%m-%dT%H:%M:%f000000Z', timestamp), timestamp);
+UPDATE flows SET expires_at = COALESCE(strftime('%Y-%m-%dT%H:%M:%f000000Z', expires_at), expires_at);
+UPDATE events SET timestamp = COALESCE(strftime('%Y-%m-%dT%H:%M:%f000000Z', timestamp), timestamp);
+UPDATE events SET expires_at = COALESCE(strftime('%Y-%m-%dT%H:%M:%f000000Z', expires_at), expires_at);
+UPDATE tool_invocations SET timestamp = COALESCE(strftime('%Y-%m-%dT%H:%M:%f000000Z', timestamp), timestamp);
+UPDATE tool_invocations SET expires_at = COALESCE(strftime('%Y-%m-%dT%H:%M:%f000000Z', expires_at), expires_at);
+UPDATE tunnels SET started_at = COALESCE(strftime('%Y-%m-%dT%H:%M:%f000000Z', started_at), started_at);
+UPDATE tunnels SET ended_at = COALESCE(strftime('%Y-%m-%dT%H:%M:%f000000Z', ended_at), ended_at);
+`
+
+const migrationV23 = `
+-- When a flow finished: its timestamp plus duration_ms, NULL while in progress
+ALTER TABLE flows ADD COLUMN completed_at TEXT;
+UPDATE flows SET completed_at = strftime('%Y-%m-%dT%H:%M:%f000000Z', julianday(timestamp) + duration_ms / 86400000.0)
+WHERE duration_ms IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_flows_completed_at ON flows(completed_at) WHERE completed_at IS NOT NULL;
+`
+
+// flowCompletedAt returns the completed_at SaveFlow and UpdateFlow write:
+// the flow's timestamp plus its duration, or nil while it has none.
+func flowCompletedAt(flow *Flow) *string {
+	if flow.DurationMs == nil {
+		return nil
+	}
+	completed := FormatTime(flow.Timestamp.Add(time.Duration(*flow.DurationMs) * time.Millisecond))
+	return &completed
+}
+
+// flowExpiresAt is the expires_at SaveFlow and UpdateFlow write: the
+// flow's own, or if it has one and its task has a retention override, the
+// override counted from its timestamp. Applying the override here means a
+// flow finishing while the override is set can't keep a stale expiry. Its
+// arguments are the expiry, the timestamp, the task ID and the expiry again.
+const flowExpiresAt = `CASE WHEN ? IS NULL THEN NULL ELSE COALESCE(
+			(SELECT strftime('%Y-%m-%dT%H:%M:%f000000Z', julianday(?) + ttl_days) FROM task_retention WHERE task_id = ?), ?) END`
+
 // SaveFlow inserts a new flow.
 func (s *SQLiteStore) SaveFlow(ctx context.Context, flow *Flow) error {
 	reqHeaders, _ := json.Marshal(flow.RequestHeaders)
 	respHeaders, _ := json.Marshal(flow.ResponseHeaders)
+	var headerOrder *string
+	if flow.RequestHeaderOrder != nil {
+		b, _ := json.Marshal(flow.RequestHeaderOrder)
+		order := string(b)
+		headerOrder = &order
+	}
+	var breakpointPositions *string
+	if flow.CacheBreakpointPositions != nil {
+		b, _ := json.Marshal(flow.CacheBreakpointPositions)
+		positions := string(b)
+		breakpointPositions = &positions
+	}
+	reqBody, reqEncoding := encodeBody(flow.RequestBody, s.compressBodies)
+	respBody, respEncoding := encodeBody(flow.ResponseBody, s.compressBodies)
+	timestamp := FormatTime(flow.Timestamp)
+	expires := formatNullableTime(flow.ExpiresAt)
 
 	_, err := s.db.ExecContext(ctx, `
 		INSERT INTO flows (
 			id, task_id, task_source, host, method, path, url,
 			timestamp, timestamp_mono, duration_ms, status_code, status_text,
-			is_sse, flow_integrity, events_dropped_count,
-			request_body, request_body_truncated, response_body, response_body_truncated,
+			is_sse, is_websocket, stream_mismatch, flow_integrity, events_dropped_count, events_skipped_count,
+			request_body, request_body_truncated, request_body_invalid, response_body, response_body_truncated,
 			request_headers, response_headers, request_signature,
 			input_tokens, output_tokens, cache_creation_tokens, cache_read_tokens,
-			total_cost, cost_source, model, provider, expires_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			total_cost, cost_source, model, provider, expires_at, request_header_order,
+			ratelimit_requests_limit, ratelimit_requests_remaining,
+			ratelimit_tokens_limit, ratelimit_tokens_remaining, ratelimit_reset,
+			cache_breakpoints, cache_breakpoint_positions,
+			stop_reason, error_type, error_message,
+			request_body_encoding, response_body_encoding,
+			request_body_size, response_body_size, completed_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, `+flowExpiresAt+`, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		flow.ID, flow.TaskID, flow.TaskSource, flow.Host, flow.Method, flow.Path, flow.URL,
-		flow.Timestamp.Format(time.RFC3339Nano), flow.TimestampMono, flow.DurationMs, flow.StatusCode, flow.StatusText,
-		flow.IsSSE, flow.FlowIntegrity, flow.EventsDroppedCount,
-		flow.RequestBody, flow.RequestBodyTruncated, flow.ResponseBody, flow.ResponseBodyTruncated,
+		timestamp, flow.TimestampMono, flow.DurationMs, flow.StatusCode, flow.StatusText,
+		flow.IsSSE, flow.IsWebSocket, flow.StreamMismatch, flow.FlowIntegrity, flow.EventsDroppedCount, flow.EventsSkippedCount,
+		reqBody, flow.RequestBodyTruncated, flow.RequestBodyInvalid, respBody, flow.ResponseBodyTruncated,
 		string(reqHeaders), string(respHeaders), flow.RequestSignature,
 		flow.InputTokens, flow.OutputTokens, flow.CacheCreationTokens, flow.CacheReadTokens,
-		flow.TotalCost, flow.CostSource, flow.Model, flow.Provider, formatNullableTime(flow.ExpiresAt),
+		flow.TotalCost, flow.CostSource, flow.Model, flow.Provider, expires, timestamp, flow.TaskID, expires, headerOrder,
+		flow.RateLimitRequestsLimit, flow.RateLimitRequestsRemaining,
+		flow.RateLimitTokensLimit, flow.RateLimitTokensRemaining, formatNullableTime(flow.RateLimitReset),
+		flow.CacheBreakpoints, breakpointPositions,
+		flow.StopReason, flow.ErrorType, flow.ErrorMessage,
+		reqEncoding, respEncoding,
+		flow.RequestBodySize, flow.ResponseBodySize, flowCompletedAt(flow),
 	)
 	return err
 }
@@ -298,38 +843,63 @@ func (s *SQLiteStore) SaveFlow(ctx context.Context, flow *Flow) error {
 func (s *SQLiteStore) UpdateFlow(ctx context.Context, flow *Flow) error {
 	reqHeaders, _ := json.Marshal(flow.RequestHeaders)
 	respHeaders, _ := json.Marshal(flow.ResponseHeaders)
+	respBody, respEncoding := encodeBody(flow.ResponseBody, s.compressBodies)
+	expires := formatNullableTime(flow.ExpiresAt)
 
 	_, err := s.db.ExecContext(ctx, `
 		UPDATE flows SET
 			task_id = ?, task_source = ?, duration_ms = ?, status_code = ?, status_text = ?,
-			is_sse = ?, flow_integrity = ?, events_dropped_count = ?,
-			response_body = ?, response_body_truncated = ?,
+			is_sse = ?, is_websocket = ?, stream_mismatch = ?, flow_integrity = ?, events_dropped_count = ?, events_skipped_count = ?,
+			response_body = ?, response_body_encoding = ?, response_body_truncated = ?,
 			request_headers = ?, response_headers = ?,
 			input_tokens = ?, output_tokens = ?, cache_creation_tokens = ?, cache_read_tokens = ?,
-			total_cost = ?, cost_source = ?, model = ?
+			total_cost = ?, cost_source = ?, model = ?,
+			ratelimit_requests_limit = ?, ratelimit_requests_remaining = ?,
+			ratelimit_tokens_limit = ?, ratelimit_tokens_remaining = ?, ratelimit_reset = ?,
+			stop_reason = ?, error_type = ?, error_message = ?,
+			request_body_size = ?, response_body_size = ?, completed_at = ?,
+			expires_at = COALESCE(`+flowExpiresAt+`, expires_at)
 		WHERE id = ?
 	`,
 		flow.TaskID, flow.TaskSource, flow.DurationMs, flow.StatusCode, flow.StatusText,
-		flow.IsSSE, flow.FlowIntegrity, flow.EventsDroppedCount,
-		flow.ResponseBody, flow.ResponseBodyTruncated,
+		flow.IsSSE, flow.IsWebSocket, flow.StreamMismatch, flow.FlowIntegrity, flow.EventsDroppedCount, flow.EventsSkippedCount,
+		respBody, respEncoding, flow.ResponseBodyTruncated,
 		string(reqHeaders), string(respHeaders),
 		flow.InputTokens, flow.OutputTokens, flow.CacheCreationTokens, flow.CacheReadTokens,
 		flow.TotalCost, flow.CostSource, flow.Model,
+		flow.RateLimitRequestsLimit, flow.RateLimitRequestsRemaining,
+		flow.RateLimitTokensLimit, flow.RateLimitTokensRemaining, formatNullableTime(flow.RateLimitReset),
+		flow.StopReason, flow.ErrorType, flow.ErrorMessage,
+		flow.RequestBodySize, flow.ResponseBodySize, flowCompletedAt(flow),
+		expires, FormatTime(flow.Timestamp), flow.TaskID, expires,
 		flow.ID,
 	)
 	return err
 }
 
+// flowSizeColumns computes Flow.EventCount and Flow.StoredBodyBytes when
+// reading flows. The count uses the events (flow_id, sequence) index; bodies
+// are cast to BLOB so length() counts bytes rather than characters.
+const flowSizeColumns = `(SELECT COUNT(*) FROM events WHERE events.flow_id = flows.id),
+			COALESCE(length(CAST(request_body AS BLOB)), 0) + COALESCE(length(CAST(response_body AS BLOB)), 0)`
+
 // GetFlow retrieves a flow by ID.
 func (s *SQLiteStore) GetFlow(ctx context.Context, id string) (*Flow, error) {
-	row := s.db.QueryRowContext(ctx, `
+	row := s.reader().QueryRowContext(ctx, `
 		SELECT id, task_id, task_source, host, method, path, url,
 			timestamp, timestamp_mono, duration_ms, status_code, status_text,
-			is_sse, flow_integrity, events_dropped_count,
-			request_body, request_body_truncated, response_body, response_body_truncated,
+			is_sse, is_websocket, stream_mismatch, flow_integrity, events_dropped_count, events_skipped_count,
+			request_body, request_body_truncated, request_body_invalid, response_body, response_body_truncated,
 			request_headers, response_headers, request_signature,
 			input_tokens, output_tokens, cache_creation_tokens, cache_read_tokens,
-			total_cost, cost_source, model, provider, created_at, expires_at
+			total_cost, cost_source, model, provider, created_at, expires_at, request_header_order,
+			ratelimit_requests_limit, ratelimit_requests_remaining,
+			ratelimit_tokens_limit, ratelimit_tokens_remaining, ratelimit_reset,
+			cache_breakpoints, cache_breakpoint_positions,
+			stop_reason, error_type, error_message, notes,
+			request_body_encoding, response_body_encoding,
+			request_body_size, response_body_size,
+			`+flowSizeColumns+`
 		FROM flows WHERE id = ?
 	`, id)
 
@@ -342,11 +912,18 @@ func (s *SQLiteStore) ListFlows(ctx context.Context, filter FlowFilter) ([]*Flow
 	query.WriteString(`
 		SELECT id, task_id, task_source, host, method, path, url,
 			timestamp, timestamp_mono, duration_ms, status_code, status_text,
-			is_sse, flow_integrity, events_dropped_count,
-			request_body, request_body_truncated, response_body, response_body_truncated,
+			is_sse, is_websocket, stream_mismatch, flow_integrity, events_dropped_count, events_skipped_count,
+			request_body, request_body_truncated, request_body_invalid, response_body, response_body_truncated,
 			request_headers, response_headers, request_signature,
 			input_tokens, output_tokens, cache_creation_tokens, cache_read_tokens,
-			total_cost, cost_source, model, provider, created_at, expires_at
+			total_cost, cost_source, model, provider, created_at, expires_at, request_header_order,
+			ratelimit_requests_limit, ratelimit_requests_remaining,
+			ratelimit_tokens_limit, ratelimit_tokens_remaining, ratelimit_reset,
+			cache_breakpoints, cache_breakpoint_positions,
+			stop_reason, error_type, error_message, notes,
+			request_body_encoding, response_body_encoding,
+			request_body_size, response_body_size,
+			`+flowSizeColumns+`
 		FROM flows WHERE 1=1
 	`)
 
@@ -368,16 +945,56 @@ func (s *SQLiteStore) ListFlows(ctx context.Context, filter FlowFilter) ([]*Flow
 		query.WriteString(" AND model = ?")
 		args = append(args, *filter.Model)
 	}
+	if filter.StopReason != nil {
+		query.WriteString(" AND stop_reason = ?")
+		args = append(args, *filter.StopReason)
+	}
+	if filter.Provider != nil {
+		query.WriteString(" AND provider = ?")
+		args = append(args, *filter.Provider)
+	}
+	if filter.StatusMin != nil {
+		query.WriteString(" AND status_code >= ?")
+		args = append(args, *filter.StatusMin)
+	}
+	if filter.StatusMax != nil {
+		query.WriteString(" AND status_code <= ?")
+		args = append(args, *filter.StatusMax)
+	}
+	if filter.Tag != nil {
+		key, value, hasValue := strings.Cut(*filter.Tag, "=")
+		if hasValue {
+			query.WriteString(" AND id IN (SELECT flow_id FROM flow_tags WHERE key = ? AND value = ?)")
+			args = append(args, key, value)
+		} else {
+			query.WriteString(" AND id IN (SELECT flow_id FROM flow_tags WHERE key = ?)")
+			args = append(args, key)
+		}
+	}
 	if filter.StartTime != nil {
 		query.WriteString(" AND timestamp >= ?")
-		args = append(args, filter.StartTime.Format(time.RFC3339Nano))
+		args = append(args, FormatTime(*filter.StartTime))
 	}
 	if filter.EndTime != nil {
 		query.WriteString(" AND timestamp <= ?")
-		args = append(args, filter.EndTime.Format(time.RFC3339Nano))
+		args = append(args, FormatTime(*filter.EndTime))
+	}
+	if filter.CompletedAfter != nil {
+		query.WriteString(" AND completed_at > ?")
+		args = append(args, FormatTime(*filter.CompletedAfter))
+	}
+	if filter.After != nil && !filter.SortByCost {
+		after := FormatTime(filter.After.Timestamp)
+		query.WriteString(" AND (timestamp < ? OR (timestamp = ? AND id < ?))")
+		args = append(args, after, after, filter.After.ID)
 	}
 
-	query.WriteString(" ORDER BY timestamp DESC")
+	if filter.SortByCost {
+		query.WriteString(" AND total_cost IS NOT NULL ORDER BY total_cost DESC, timestamp DESC")
+	} else {
+		// id breaks ties so After and Offset pages follow one order
+		query.WriteString(" ORDER BY timestamp DESC, id DESC")
+	}
 
 	if filter.Limit > 0 {
 		query.WriteString(" LIMIT ?")
@@ -388,7 +1005,7 @@ func (s *SQLiteStore) ListFlows(ctx context.Context, filter FlowFilter) ([]*Flow
 		args = append(args, filter.Offset)
 	}
 
-	rows, err := s.db.QueryContext(ctx, query.String(), args...)
+	rows, err := s.reader().QueryContext(ctx, query.String(), args...)
 	if err != nil {
 		return nil, err
 	}
@@ -429,17 +1046,50 @@ func (s *SQLiteStore) CountFlows(ctx context.Context, filter FlowFilter) (int, e
 		query.WriteString(" AND model = ?")
 		args = append(args, *filter.Model)
 	}
+	if filter.StopReason != nil {
+		query.WriteString(" AND stop_reason = ?")
+		args = append(args, *filter.StopReason)
+	}
+	if filter.Provider != nil {
+		query.WriteString(" AND provider = ?")
+		args = append(args, *filter.Provider)
+	}
+	if filter.StatusMin != nil {
+		query.WriteString(" AND status_code >= ?")
+		args = append(args, *filter.StatusMin)
+	}
+	if filter.StatusMax != nil {
+		query.WriteString(" AND status_code <= ?")
+		args = append(args, *filter.StatusMax)
+	}
+	if filter.Tag != nil {
+		key, value, hasValue := strings.Cut(*filter.Tag, "=")
+		if hasValue {
+			query.WriteString(" AND id IN (SELECT flow_id FROM flow_tags WHERE key = ? AND value = ?)")
+			args = append(args, key, value)
+		} else {
+			query.WriteString(" AND id IN (SELECT flow_id FROM flow_tags WHERE key = ?)")
+			args = append(args, key)
+		}
+	}
 	if filter.StartTime != nil {
 		query.WriteString(" AND timestamp >= ?")
-		args = append(args, filter.StartTime.Format(time.RFC3339Nano))
+		args = append(args, FormatTime(*filter.StartTime))
 	}
 	if filter.EndTime != nil {
 		query.WriteString(" AND timestamp <= ?")
-		args = append(args, filter.EndTime.Format(time.RFC3339Nano))
+		args = append(args, FormatTime(*filter.EndTime))
+	}
+	if filter.CompletedAfter != nil {
+		query.WriteString(" AND completed_at > ?")
+		args = append(args, FormatTime(*filter.CompletedAfter))
+	}
+	if filter.SortByCost {
+		query.WriteString(" AND total_cost IS NOT NULL")
 	}
 
 	var count int
-	err := s.db.QueryRowContext(ctx, query.String(), args...).Scan(&count)
+	err := s.reader().QueryRowContext(ctx, query.String(), args...).Scan(&count)
 	return count, err
 }
 
@@ -449,6 +1099,160 @@ func (s *SQLiteStore) DeleteFlow(ctx context.Context, id string) error {
 	return err
 }
 
+// SetFlowNotes sets a flow's notes; nil clears them. It returns
+// sql.ErrNoRows if the flow doesn't exist.
+func (s *SQLiteStore) SetFlowNotes(ctx context.Context, flowID string, notes *string) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE flows SET notes = ? WHERE id = ?", notes, flowID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ListFlowFacets returns the distinct hosts, models and providers of all
+// flows, and the taskLimit most recently active tasks. Each query groups on
+// a column with its own index.
+func (s *SQLiteStore) ListFlowFacets(ctx context.Context, taskLimit int) (*FlowFacets, error) {
+	var facets FlowFacets
+	var err error
+	if facets.Hosts, err = s.facetValues(ctx, "host", "COUNT(*) DESC, host", -1); err != nil {
+		return nil, fmt.Errorf("listing hosts: %w", err)
+	}
+	if facets.Models, err = s.facetValues(ctx, "model", "COUNT(*) DESC, model", -1); err != nil {
+		return nil, fmt.Errorf("listing models: %w", err)
+	}
+	if facets.Providers, err = s.facetValues(ctx, "provider", "COUNT(*) DESC, provider", -1); err != nil {
+		return nil, fmt.Errorf("listing providers: %w", err)
+	}
+	if facets.Tasks, err = s.facetValues(ctx, "task_id", "MAX(julianday(timestamp)) DESC, task_id", taskLimit); err != nil {
+		return nil, fmt.Errorf("listing tasks: %w", err)
+	}
+	return &facets, nil
+}
+
+// facetValues counts the flows with each non-empty value of column, in
+// order, up to limit values (negative for all). column and order are
+// trusted SQL.
+func (s *SQLiteStore) facetValues(ctx context.Context, column, order string, limit int) ([]*FacetValue, error) {
+	rows, err := s.reader().QueryContext(ctx, `
+		SELECT `+column+`, COUNT(*), strftime('%Y-%m-%dT%H:%M:%fZ', MAX(julianday(timestamp)))
+		FROM flows
+		WHERE `+column+` IS NOT NULL AND `+column+` != ''
+		GROUP BY `+column+`
+		ORDER BY `+order+`
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []*FacetValue{}
+	for rows.Next() {
+		var v FacetValue
+		var lastSeen string
+		if err := rows.Scan(&v.Value, &v.Count, &lastSeen); err != nil {
+			return nil, err
+		}
+		v.LastSeen, _ = time.Parse(time.RFC3339Nano, lastSeen)
+		values = append(values, &v)
+	}
+	return values, rows.Err()
+}
+
+// SetTaskRetention overrides how many days a task's flows are kept;
+// ttlDays <= 0 removes the override. The expires_at of the task's finished
+// flows is recomputed from their timestamp, against the global
+// FlowsTTLDays when the override is removed. While the override is set,
+// RunRetention keeps the flows' events and bodies as long as the flows.
+func (s *SQLiteStore) SetTaskRetention(ctx context.Context, taskID string, ttlDays int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning task retention update: %w", err)
+	}
+	defer tx.Rollback()
+
+	days := ttlDays
+	if ttlDays > 0 {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO task_retention (task_id, ttl_days) VALUES (?, ?)
+			ON CONFLICT (task_id) DO UPDATE SET ttl_days = excluded.ttl_days
+		`, taskID, ttlDays)
+	} else {
+		days = s.retention.Snapshot().FlowsTTLDays
+		_, err = tx.ExecContext(ctx, "DELETE FROM task_retention WHERE task_id = ?", taskID)
+	}
+	if err != nil {
+		return fmt.Errorf("saving task retention: %w", err)
+	}
+
+	// Flows still in progress get their expires_at when they finish
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE flows SET expires_at = strftime('%Y-%m-%dT%H:%M:%f000000Z', julianday(timestamp) + ?)
+		WHERE task_id = ? AND expires_at IS NOT NULL
+	`, days, taskID); err != nil {
+		return fmt.Errorf("updating flow expiry: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing task retention: %w", err)
+	}
+	return nil
+}
+
+// GetTaskRetention returns a task's retention override in days, or 0 if
+// it has none.
+func (s *SQLiteStore) GetTaskRetention(ctx context.Context, taskID string) (int, error) {
+	var ttlDays int
+	err := s.db.QueryRowContext(ctx, "SELECT ttl_days FROM task_retention WHERE task_id = ?", taskID).Scan(&ttlDays)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return ttlDays, err
+}
+
+// AddFlowTag sets a tag on a flow, replacing the value if the key exists.
+func (s *SQLiteStore) AddFlowTag(ctx context.Context, flowID, key, value string) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO flow_tags (flow_id, key, value) VALUES (?, ?, ?)
+		ON CONFLICT (flow_id, key) DO UPDATE SET value = excluded.value
+	`, flowID, key, value)
+	return err
+}
+
+// ListFlowTags returns a flow's tags ordered by key.
+func (s *SQLiteStore) ListFlowTags(ctx context.Context, flowID string) ([]*FlowTag, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT flow_id, key, value, created_at FROM flow_tags
+		WHERE flow_id = ? ORDER BY key
+	`, flowID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []*FlowTag{}
+	for rows.Next() {
+		var tag FlowTag
+		var createdAt string
+		if err := rows.Scan(&tag.FlowID, &tag.Key, &tag.Value, &createdAt); err != nil {
+			return nil, err
+		}
+		tag.CreatedAt, _ = time.Parse(time.RFC3339Nano, createdAt)
+		tags = append(tags, &tag)
+	}
+	return tags, rows.Err()
+}
+
+// DeleteFlowTag removes a tag from a flow. Deleting a missing tag is not an error.
+func (s *SQLiteStore) DeleteFlowTag(ctx context.Context, flowID, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM flow_tags WHERE flow_id = ? AND key = ?", flowID, key)
+	return err
+}
+
 // SaveEvent inserts a new event.
 func (s *SQLiteStore) SaveEvent(ctx context.Context, event *Event) error {
 	eventData, _ := json.Marshal(event.EventData)
@@ -457,7 +1261,7 @@ func (s *SQLiteStore) SaveEvent(ctx context.Context, event *Event) error {
 		INSERT INTO events (id, flow_id, sequence, timestamp, timestamp_mono, event_type, event_data, priority, expires_at)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
-		event.ID, event.FlowID, event.Sequence, event.Timestamp.Format(time.RFC3339Nano),
+		event.ID, event.FlowID, event.Sequence, FormatTime(event.Timestamp),
 		event.TimestampMono, event.EventType, string(eventData), event.Priority,
 		formatNullableTime(event.ExpiresAt),
 	)
@@ -488,7 +1292,7 @@ func (s *SQLiteStore) SaveEvents(ctx context.Context, events []*Event) error {
 	for _, event := range events {
 		eventData, _ := json.Marshal(event.EventData)
 		_, err := stmt.ExecContext(ctx,
-			event.ID, event.FlowID, event.Sequence, event.Timestamp.Format(time.RFC3339Nano),
+			event.ID, event.FlowID, event.Sequence, FormatTime(event.Timestamp),
 			event.TimestampMono, event.EventType, string(eventData), event.Priority,
 			formatNullableTime(event.ExpiresAt),
 		)
@@ -502,10 +1306,16 @@ func (s *SQLiteStore) SaveEvents(ctx context.Context, events []*Event) error {
 
 // GetEventsByFlow returns events for a flow.
 func (s *SQLiteStore) GetEventsByFlow(ctx context.Context, flowID string) ([]*Event, error) {
-	rows, err := s.db.QueryContext(ctx, `
+	return s.GetEventsByFlowLimit(ctx, flowID, -1)
+}
+
+// GetEventsByFlowLimit retrieves the first limit events for a flow, by
+// sequence. A negative limit returns them all.
+func (s *SQLiteStore) GetEventsByFlowLimit(ctx context.Context, flowID string, limit int) ([]*Event, error) {
+	rows, err := s.reader().QueryContext(ctx, `
 		SELECT id, flow_id, sequence, timestamp, timestamp_mono, event_type, event_data, priority, created_at, expires_at
-		FROM events WHERE flow_id = ? ORDER BY sequence
-	`, flowID)
+		FROM events WHERE flow_id = ? ORDER BY sequence LIMIT ?
+	`, flowID, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -549,7 +1359,7 @@ func (s *SQLiteStore) SaveToolInvocation(ctx context.Context, inv *ToolInvocatio
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		inv.ID, inv.FlowID, inv.TaskID, inv.ToolUseID, inv.ToolName, inv.ToolType,
-		inv.Timestamp.Format(time.RFC3339Nano), inv.DurationMs, inv.Success, inv.ErrorMessage,
+		FormatTime(inv.Timestamp), inv.DurationMs, inv.Success, inv.ErrorMessage,
 		inv.ToolInput, inv.ToolResult,
 		inv.InputTokens, inv.OutputTokens, inv.Cost, formatNullableTime(inv.ExpiresAt),
 	)
@@ -662,7 +1472,7 @@ func (s *SQLiteStore) ListToolInvocations(ctx context.Context, toolName string,
 	err := s.db.QueryRowContext(ctx, `
 		SELECT COUNT(*) FROM tool_invocations
 		WHERE tool_name = ? AND timestamp >= ? AND timestamp <= ?
-	`, toolName, start.Format(time.RFC3339Nano), end.Format(time.RFC3339Nano)).Scan(&total)
+	`, toolName, FormatTime(start), FormatTime(end)).Scan(&total)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -674,7 +1484,7 @@ func (s *SQLiteStore) ListToolInvocations(ctx context.Context, toolName string,
 		WHERE tool_name = ? AND timestamp >= ? AND timestamp <= ?
 		ORDER BY timestamp DESC
 		LIMIT ? OFFSET ?
-	`, toolName, start.Format(time.RFC3339Nano), end.Format(time.RFC3339Nano), limit, offset)
+	`, toolName, FormatTime(start), FormatTime(end), limit, offset)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -694,15 +1504,17 @@ func (s *SQLiteStore) ListToolInvocations(ctx context.Context, toolName string,
 
 // UpdateToolResult updates a tool invocation with its result, matched by tool_use_id.
 // Duration is calculated as the difference between resultTime and the stored timestamp.
+// Only the first result is recorded: every later request in the conversation
+// repeats the same tool_result, which would otherwise stretch the duration.
 func (s *SQLiteStore) UpdateToolResult(ctx context.Context, toolUseID string, success bool, errorMsg *string, resultContent *string, resultTime time.Time) error {
 	_, err := s.db.ExecContext(ctx, `
 		UPDATE tool_invocations
 		SET success = ?,
 		    error_message = ?,
 		    tool_result = ?,
-		    duration_ms = CAST((julianday(?) - julianday(timestamp)) * 86400000 AS INTEGER)
-		WHERE tool_use_id = ?
-	`, success, errorMsg, resultContent, resultTime.Format(time.RFC3339Nano), toolUseID)
+		    duration_ms = MAX(0, CAST((julianday(?) - julianday(timestamp)) * 86400000 AS INTEGER))
+		WHERE tool_use_id = ? AND duration_ms IS NULL
+	`, success, errorMsg, resultContent, FormatTime(resultTime), toolUseID)
 	return err
 }
 
@@ -714,23 +1526,124 @@ func (s *SQLiteStore) LogDrop(ctx context.Context, entry *DropLogEntry) error {
 	return err
 }
 
+// SaveTunnel records a finished CONNECT tunnel.
+func (s *SQLiteStore) SaveTunnel(ctx context.Context, t *Tunnel) error {
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO tunnels (host, mode, started_at, ended_at, bytes_up, bytes_down) VALUES (?, ?, ?, ?, ?, ?)
+	`, t.Host, t.Mode, FormatTime(t.StartedAt), FormatTime(t.EndedAt), t.BytesUp, t.BytesDown)
+	if err != nil {
+		return err
+	}
+	t.ID, _ = res.LastInsertId()
+	return nil
+}
+
+// ListTunnels returns the most recent tunnels, newest first.
+func (s *SQLiteStore) ListTunnels(ctx context.Context, limit int) ([]*Tunnel, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, host, mode, started_at, ended_at, bytes_up, bytes_down
+		FROM tunnels
+		ORDER BY started_at DESC, id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tunnels := []*Tunnel{}
+	for rows.Next() {
+		var t Tunnel
+		var startedAt, endedAt string
+		if err := rows.Scan(&t.ID, &t.Host, &t.Mode, &startedAt, &endedAt, &t.BytesUp, &t.BytesDown); err != nil {
+			return nil, err
+		}
+		t.StartedAt, _ = time.Parse(time.RFC3339Nano, startedAt)
+		t.EndedAt, _ = time.Parse(time.RFC3339Nano, endedAt)
+		tunnels = append(tunnels, &t)
+	}
+	return tunnels, rows.Err()
+}
+
+// Retention conditions, shared by RunRetention and PreviewRetention. The
+// flows condition takes the current time; the events condition the current
+// time and a cutoff; the bodies and tunnels conditions a cutoff. Times are
+// in TimeLayout and compared as strings, so the timestamp and expires_at
+// indexes apply. The drop log condition takes a "-N days" modifier, as
+// drop_log timestamps are SQLite datetimes. Task retention overrides are
+// already in flows' expires_at, which SetTaskRetention rewrites; the events
+// and bodies of a task with an override are kept until its flows expire.
+const (
+	retentionFlowsWhere  = `expires_at < ?`
+	retentionEventsWhere = `(expires_at < ?
+			   OR (expires_at IS NULL AND timestamp < ?))
+			  AND flow_id NOT IN (SELECT id FROM flows WHERE task_id IN (SELECT task_id FROM task_retention))`
+	retentionBodiesWhere = `(request_body IS NOT NULL OR response_body IS NOT NULL)
+			  AND timestamp < ?
+			  AND (task_id IS NULL OR task_id NOT IN (SELECT task_id FROM task_retention))`
+	retentionTunnelsWhere = "started_at < ?"
+	retentionDropLogWhere = "timestamp < datetime('now', ?)"
+)
+
+// cutoff returns the time days before now, for the retention conditions.
+func cutoff(now time.Time, days int) string {
+	return FormatTime(now.AddDate(0, 0, -days))
+}
+
+func daysAgo(days int) string {
+	return fmt.Sprintf("-%d days", days)
+}
+
 // RunRetention deletes expired data.
+// Events and bodies have their own, usually shorter, TTLs: events are deleted
+// and bodies stripped while the flow's metadata (tokens, cost, timing) is kept
+// until the flow itself expires. The returned count covers deleted rows only.
 func (s *SQLiteStore) RunRetention(ctx context.Context) (int64, error) {
 	var totalDeleted int64
+	retention := s.retention.Snapshot()
+	now := time.Now()
 
 	// Delete expired flows (cascades to events and tool_invocations)
-	res, err := s.db.ExecContext(ctx, "DELETE FROM flows WHERE expires_at < datetime('now')")
+	res, err := s.db.ExecContext(ctx, "DELETE FROM flows WHERE "+retentionFlowsWhere, FormatTime(now))
 	if err != nil {
 		return totalDeleted, err
 	}
 	n, _ := res.RowsAffected()
 	totalDeleted += n
 
+	// Delete expired events of surviving flows. Events saved without an
+	// expires_at fall back to their timestamp plus EventsTTLDays.
+	if retention.EventsTTLDays > 0 {
+		res, err = s.db.ExecContext(ctx, "DELETE FROM events WHERE "+retentionEventsWhere,
+			FormatTime(now), cutoff(now, retention.EventsTTLDays))
+		if err != nil {
+			return totalDeleted, err
+		}
+		n, _ = res.RowsAffected()
+		totalDeleted += n
+	}
+
+	// Strip bodies from flows older than BodiesTTLDays
+	if retention.BodiesTTLDays > 0 {
+		if _, err := s.db.ExecContext(ctx,
+			"UPDATE flows SET request_body = NULL, response_body = NULL, request_body_encoding = NULL, response_body_encoding = NULL WHERE "+retentionBodiesWhere,
+			cutoff(now, retention.BodiesTTLDays)); err != nil {
+			return totalDeleted, err
+		}
+	}
+
+	// Tunnel access log entries live as long as flows
+	res, err = s.db.ExecContext(ctx, "DELETE FROM tunnels WHERE "+retentionTunnelsWhere,
+		cutoff(now, retention.FlowsTTLDays))
+	if err != nil {
+		return totalDeleted, err
+	}
+	n, _ = res.RowsAffected()
+	totalDeleted += n
+
 	// Delete old drop_log
-	res, err = s.db.ExecContext(ctx,
-		"DELETE FROM drop_log WHERE timestamp < datetime('now', ?)",
-		fmt.Sprintf("-%d days", s.retention.DropLogTTLDays),
-	)
+	res, err = s.db.ExecContext(ctx, "DELETE FROM drop_log WHERE "+retentionDropLogWhere,
+		daysAgo(retention.DropLogTTLDays))
 	if err != nil {
 		return totalDeleted, err
 	}
@@ -740,8 +1653,141 @@ func (s *SQLiteStore) RunRetention(ctx context.Context) (int64, error) {
 	return totalDeleted, nil
 }
 
-// Close closes the database connection.
+// PreviewRetention counts the rows RunRetention would delete or strip,
+// using the same conditions, without changing anything.
+func (s *SQLiteStore) PreviewRetention(ctx context.Context) (*RetentionPreview, error) {
+	retention := s.retention.Snapshot()
+	now := time.Now()
+	nowArg := FormatTime(now)
+	var p RetentionPreview
+	var flowBytes, eventBytes, bodyBytes int64
+
+	// Flows and everything that cascades from them
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(
+			COALESCE(length(request_body), 0) + COALESCE(length(response_body), 0) +
+			COALESCE(length(request_headers), 0) + COALESCE(length(response_headers), 0)), 0)
+		FROM flows WHERE `+retentionFlowsWhere, nowArg).Scan(&p.Flows, &flowBytes)
+	if err != nil {
+		return nil, fmt.Errorf("counting flows: %w", err)
+	}
+	err = s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM tool_invocations
+		WHERE flow_id IN (SELECT id FROM flows WHERE `+retentionFlowsWhere+`)`, nowArg).Scan(&p.ToolCalls)
+	if err != nil {
+		return nil, fmt.Errorf("counting tool invocations: %w", err)
+	}
+
+	eventsQuery := `SELECT COUNT(*), COALESCE(SUM(COALESCE(length(event_data), 0)), 0) FROM events
+		WHERE flow_id IN (SELECT id FROM flows WHERE ` + retentionFlowsWhere + `)`
+	eventsArgs := []interface{}{nowArg}
+	if retention.EventsTTLDays > 0 {
+		eventsQuery += " OR (" + retentionEventsWhere + ")"
+		eventsArgs = append(eventsArgs, nowArg, cutoff(now, retention.EventsTTLDays))
+	}
+	if err := s.db.QueryRowContext(ctx, eventsQuery, eventsArgs...).Scan(&p.Events, &eventBytes); err != nil {
+		return nil, fmt.Errorf("counting events: %w", err)
+	}
+
+	if retention.BodiesTTLDays > 0 {
+		err = s.db.QueryRowContext(ctx, `
+			SELECT COUNT(*), COALESCE(SUM(COALESCE(length(request_body), 0) + COALESCE(length(response_body), 0)), 0)
+			FROM flows WHERE `+retentionBodiesWhere+` AND NOT COALESCE(`+retentionFlowsWhere+`, 0)`,
+			cutoff(now, retention.BodiesTTLDays), nowArg).Scan(&p.BodiesStripped, &bodyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("counting bodies: %w", err)
+		}
+	}
+
+	err = s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM tunnels WHERE "+retentionTunnelsWhere,
+		cutoff(now, retention.FlowsTTLDays)).Scan(&p.Tunnels)
+	if err != nil {
+		return nil, fmt.Errorf("counting tunnels: %w", err)
+	}
+	err = s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM drop_log WHERE "+retentionDropLogWhere,
+		daysAgo(retention.DropLogTTLDays)).Scan(&p.DropLog)
+	if err != nil {
+		return nil, fmt.Errorf("counting drop log: %w", err)
+	}
+
+	p.ReclaimableBytes = flowBytes + eventBytes + bodyBytes
+	return &p, nil
+}
+
+// Vacuum rebuilds the database file to reclaim space freed by deletes and
+// returns the database size in bytes before and after.
+// VACUUM needs exclusive access. The pool is limited to one connection, so it
+// waits for in-flight queries and other callers queue behind it until it finishes.
+func (s *SQLiteStore) Vacuum(ctx context.Context) (sizeBefore, sizeAfter int64, err error) {
+	sizeBefore, err = s.dbSize(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("reading size: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, "VACUUM"); err != nil {
+		return sizeBefore, 0, fmt.Errorf("vacuum: %w", err)
+	}
+
+	// In WAL mode the rewritten pages land in the WAL; fold them back into the
+	// main file and truncate the WAL so the space is actually released.
+	if _, err := s.db.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return sizeBefore, 0, fmt.Errorf("checkpoint: %w", err)
+	}
+
+	sizeAfter, err = s.dbSize(ctx)
+	if err != nil {
+		return sizeBefore, 0, fmt.Errorf("reading size: %w", err)
+	}
+	return sizeBefore, sizeAfter, nil
+}
+
+// purgeTables lists the captured-data tables cleared by PurgeAll, children
+// first. Schema version and pricing are configuration, not captured data;
+// task retention overrides go with the flows they were set for.
+var purgeTables = []string{"events", "tool_invocations", "flow_tags", "flows", "drop_log", "tunnels", "task_retention"}
+
+// PurgeAll deletes all captured data in one transaction, keeping the schema
+// and pricing. Run Vacuum afterwards to release the space.
+func (s *SQLiteStore) PurgeAll(ctx context.Context) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("beginning purge: %w", err)
+	}
+	defer tx.Rollback()
+
+	var deleted int64
+	for _, table := range purgeTables {
+		res, err := tx.ExecContext(ctx, "DELETE FROM "+table)
+		if err != nil {
+			return 0, fmt.Errorf("purging %s: %w", table, err)
+		}
+		n, _ := res.RowsAffected()
+		deleted += n
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("committing purge: %w", err)
+	}
+	return deleted, nil
+}
+
+// dbSize returns the database size in bytes (page_count * page_size).
+func (s *SQLiteStore) dbSize(ctx context.Context) (int64, error) {
+	var pageCount, pageSize int64
+	if err := s.db.QueryRowContext(ctx, "PRAGMA page_count").Scan(&pageCount); err != nil {
+		return 0, err
+	}
+	if err := s.db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, err
+	}
+	return pageCount * pageSize, nil
+}
+
+// Close closes the database connections.
 func (s *SQLiteStore) Close() error {
+	if s.readDB != nil {
+		s.readDB.Close()
+	}
 	return s.db.Close()
 }
 
@@ -755,7 +1801,8 @@ func (s *SQLiteStore) scanFlow(row *sql.Row) (*Flow, error) {
 	var flow Flow
 	var ts, createdAt string
 	var expiresAt, taskID, taskSource, statusText, reqBody, respBody sql.NullString
-	var reqHeaders, respHeaders, reqSig, costSource, model sql.NullString
+	var reqHeaders, respHeaders, reqSig, costSource, model, headerOrder, rateLimitReset sql.NullString
+	var breakpointPositions, reqEncoding, respEncoding sql.NullString
 	var timestampMono, durationMs sql.NullInt64
 	var statusCode, inputTokens, outputTokens, cacheCreation, cacheRead sql.NullInt64
 	var totalCost sql.NullFloat64
@@ -763,11 +1810,18 @@ func (s *SQLiteStore) scanFlow(row *sql.Row) (*Flow, error) {
 	err := row.Scan(
 		&flow.ID, &taskID, &taskSource, &flow.Host, &flow.Method, &flow.Path, &flow.URL,
 		&ts, &timestampMono, &durationMs, &statusCode, &statusText,
-		&flow.IsSSE, &flow.FlowIntegrity, &flow.EventsDroppedCount,
-		&reqBody, &flow.RequestBodyTruncated, &respBody, &flow.ResponseBodyTruncated,
+		&flow.IsSSE, &flow.IsWebSocket, &flow.StreamMismatch, &flow.FlowIntegrity, &flow.EventsDroppedCount, &flow.EventsSkippedCount,
+		&reqBody, &flow.RequestBodyTruncated, &flow.RequestBodyInvalid, &respBody, &flow.ResponseBodyTruncated,
 		&reqHeaders, &respHeaders, &reqSig,
 		&inputTokens, &outputTokens, &cacheCreation, &cacheRead,
-		&totalCost, &costSource, &model, &flow.Provider, &createdAt, &expiresAt,
+		&totalCost, &costSource, &model, &flow.Provider, &createdAt, &expiresAt, &headerOrder,
+		&flow.RateLimitRequestsLimit, &flow.RateLimitRequestsRemaining,
+		&flow.RateLimitTokensLimit, &flow.RateLimitTokensRemaining, &rateLimitReset,
+		&flow.CacheBreakpoints, &breakpointPositions,
+		&flow.StopReason, &flow.ErrorType, &flow.ErrorMessage, &flow.Notes,
+		&reqEncoding, &respEncoding,
+		&flow.RequestBodySize, &flow.ResponseBodySize,
+		&flow.EventCount, &flow.StoredBodyBytes,
 	)
 	if err != nil {
 		return nil, err
@@ -790,10 +1844,18 @@ func (s *SQLiteStore) scanFlow(row *sql.Row) (*Flow, error) {
 		flow.StatusText = &statusText.String
 	}
 	if reqBody.Valid {
-		flow.RequestBody = &reqBody.String
+		body, err := decodeBody(reqBody.String, reqEncoding.String)
+		if err != nil {
+			return nil, fmt.Errorf("decoding request body of flow %s: %w", flow.ID, err)
+		}
+		flow.RequestBody = &body
 	}
 	if respBody.Valid {
-		flow.ResponseBody = &respBody.String
+		body, err := decodeBody(respBody.String, respEncoding.String)
+		if err != nil {
+			return nil, fmt.Errorf("decoding response body of flow %s: %w", flow.ID, err)
+		}
+		flow.ResponseBody = &body
 	}
 	if reqHeaders.Valid {
 		_ = json.Unmarshal([]byte(reqHeaders.String), &flow.RequestHeaders)
@@ -801,6 +1863,12 @@ func (s *SQLiteStore) scanFlow(row *sql.Row) (*Flow, error) {
 	if respHeaders.Valid {
 		_ = json.Unmarshal([]byte(respHeaders.String), &flow.ResponseHeaders)
 	}
+	if headerOrder.Valid {
+		_ = json.Unmarshal([]byte(headerOrder.String), &flow.RequestHeaderOrder)
+	}
+	if breakpointPositions.Valid {
+		_ = json.Unmarshal([]byte(breakpointPositions.String), &flow.CacheBreakpointPositions)
+	}
 	if reqSig.Valid {
 		flow.RequestSignature = &reqSig.String
 	}
@@ -839,6 +1907,10 @@ func (s *SQLiteStore) scanFlow(row *sql.Row) (*Flow, error) {
 		t, _ := time.Parse(time.RFC3339Nano, expiresAt.String)
 		flow.ExpiresAt = &t
 	}
+	if rateLimitReset.Valid {
+		t, _ := time.Parse(time.RFC3339Nano, rateLimitReset.String)
+		flow.RateLimitReset = &t
+	}
 
 	return &flow, nil
 }
@@ -848,7 +1920,8 @@ func (s *SQLiteStore) scanFlowRows(rows *sql.Rows) (*Flow, error) {
 	var flow Flow
 	var ts, createdAt string
 	var expiresAt, taskID, taskSource, statusText, reqBody, respBody sql.NullString
-	var reqHeaders, respHeaders, reqSig, costSource, model sql.NullString
+	var reqHeaders, respHeaders, reqSig, costSource, model, headerOrder, rateLimitReset sql.NullString
+	var breakpointPositions, reqEncoding, respEncoding sql.NullString
 	var timestampMono, durationMs sql.NullInt64
 	var statusCode, inputTokens, outputTokens, cacheCreation, cacheRead sql.NullInt64
 	var totalCost sql.NullFloat64
@@ -856,11 +1929,18 @@ func (s *SQLiteStore) scanFlowRows(rows *sql.Rows) (*Flow, error) {
 	err := rows.Scan(
 		&flow.ID, &taskID, &taskSource, &flow.Host, &flow.Method, &flow.Path, &flow.URL,
 		&ts, &timestampMono, &durationMs, &statusCode, &statusText,
-		&flow.IsSSE, &flow.FlowIntegrity, &flow.EventsDroppedCount,
-		&reqBody, &flow.RequestBodyTruncated, &respBody, &flow.ResponseBodyTruncated,
+		&flow.IsSSE, &flow.IsWebSocket, &flow.StreamMismatch, &flow.FlowIntegrity, &flow.EventsDroppedCount, &flow.EventsSkippedCount,
+		&reqBody, &flow.RequestBodyTruncated, &flow.RequestBodyInvalid, &respBody, &flow.ResponseBodyTruncated,
 		&reqHeaders, &respHeaders, &reqSig,
 		&inputTokens, &outputTokens, &cacheCreation, &cacheRead,
-		&totalCost, &costSource, &model, &flow.Provider, &createdAt, &expiresAt,
+		&totalCost, &costSource, &model, &flow.Provider, &createdAt, &expiresAt, &headerOrder,
+		&flow.RateLimitRequestsLimit, &flow.RateLimitRequestsRemaining,
+		&flow.RateLimitTokensLimit, &flow.RateLimitTokensRemaining, &rateLimitReset,
+		&flow.CacheBreakpoints, &breakpointPositions,
+		&flow.StopReason, &flow.ErrorType, &flow.ErrorMessage, &flow.Notes,
+		&reqEncoding, &respEncoding,
+		&flow.RequestBodySize, &flow.ResponseBodySize,
+		&flow.EventCount, &flow.StoredBodyBytes,
 	)
 	if err != nil {
 		return nil, err
@@ -883,10 +1963,18 @@ func (s *SQLiteStore) scanFlowRows(rows *sql.Rows) (*Flow, error) {
 		flow.StatusText = &statusText.String
 	}
 	if reqBody.Valid {
-		flow.RequestBody = &reqBody.String
+		body, err := decodeBody(reqBody.String, reqEncoding.String)
+		if err != nil {
+			return nil, fmt.Errorf("decoding request body of flow %s: %w", flow.ID, err)
+		}
+		flow.RequestBody = &body
 	}
 	if respBody.Valid {
-		flow.ResponseBody = &respBody.String
+		body, err := decodeBody(respBody.String, respEncoding.String)
+		if err != nil {
+			return nil, fmt.Errorf("decoding response body of flow %s: %w", flow.ID, err)
+		}
+		flow.ResponseBody = &body
 	}
 	if reqHeaders.Valid {
 		_ = json.Unmarshal([]byte(reqHeaders.String), &flow.RequestHeaders)
@@ -894,6 +1982,12 @@ func (s *SQLiteStore) scanFlowRows(rows *sql.Rows) (*Flow, error) {
 	if respHeaders.Valid {
 		_ = json.Unmarshal([]byte(respHeaders.String), &flow.ResponseHeaders)
 	}
+	if headerOrder.Valid {
+		_ = json.Unmarshal([]byte(headerOrder.String), &flow.RequestHeaderOrder)
+	}
+	if breakpointPositions.Valid {
+		_ = json.Unmarshal([]byte(breakpointPositions.String), &flow.CacheBreakpointPositions)
+	}
 	if reqSig.Valid {
 		flow.RequestSignature = &reqSig.String
 	}
@@ -932,13 +2026,27 @@ func (s *SQLiteStore) scanFlowRows(rows *sql.Rows) (*Flow, error) {
 		t, _ := time.Parse(time.RFC3339Nano, expiresAt.String)
 		flow.ExpiresAt = &t
 	}
+	if rateLimitReset.Valid {
+		t, _ := time.Parse(time.RFC3339Nano, rateLimitReset.String)
+		flow.RateLimitReset = &t
+	}
 
 	return &flow, nil
 }
 
+// TimeLayout is how timestamps are stored: UTC with a fixed nine-digit
+// fraction, so that they sort and compare correctly as strings.
+const TimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// FormatTime formats t in TimeLayout, for storing or for comparing against
+// stored timestamps.
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimeLayout)
+}
+
 func formatNullableTime(t *time.Time) interface{} {
 	if t == nil {
 		return nil
 	}
-	return t.Format(time.RFC3339Nano)
+	return FormatTime(*t)
 }
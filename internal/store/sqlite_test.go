@@ -3,8 +3,12 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -99,6 +103,108 @@ func TestNewSQLiteStore(t *testing.T) {
 	})
 }
 
+func TestNewSQLiteStore_TuningPragmas(t *testing.T) {
+	t.Parallel()
+
+	pragmas := func(t *testing.T, db *sql.DB) (busy, sync, cache, fk int64) {
+		t.Helper()
+		for _, p := range []struct {
+			name string
+			dst  *int64
+		}{
+			{"busy_timeout", &busy},
+			{"synchronous", &sync},
+			{"cache_size", &cache},
+			{"foreign_keys", &fk},
+		} {
+			if err := db.QueryRow("PRAGMA " + p.name).Scan(p.dst); err != nil {
+				t.Fatalf("PRAGMA %s: %v", p.name, err)
+			}
+		}
+		return busy, sync, cache, fk
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		store, _ := setupTestDBFile(t)
+		busy, sync, _, fk := pragmas(t, store.db)
+		// synchronous: 0 OFF, 1 NORMAL, 2 FULL, 3 EXTRA
+		if busy != 5000 || sync != 1 || fk != 1 {
+			t.Errorf("busy_timeout=%d synchronous=%d foreign_keys=%d, want 5000/1/1", busy, sync, fk)
+		}
+		if store.readDB != nil {
+			t.Error("read pool opened without WithReadPool")
+		}
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "test.db")
+		store, err := NewSQLiteStore(dbPath, testRetention(), WithPersistenceConfig(&config.PersistenceConfig{
+			BusyTimeoutMs:   12000,
+			Synchronous:     "full",
+			CacheSizeKB:     8192,
+			ReadConnections: 4,
+		}))
+		if err != nil {
+			t.Fatalf("NewSQLiteStore: %v", err)
+		}
+		defer store.Close()
+
+		for name, db := range map[string]*sql.DB{"writer": store.db, "reader": store.readDB} {
+			if db == nil {
+				t.Fatalf("%s pool is nil", name)
+			}
+			busy, sync, cache, fk := pragmas(t, db)
+			if busy != 12000 || sync != 2 || cache != -8192 || fk != 1 {
+				t.Errorf("%s: busy_timeout=%d synchronous=%d cache_size=%d foreign_keys=%d, want 12000/2/-8192/1",
+					name, busy, sync, cache, fk)
+			}
+		}
+
+		var queryOnly int
+		if err := store.readDB.QueryRow("PRAGMA query_only").Scan(&queryOnly); err != nil {
+			t.Fatal(err)
+		}
+		if queryOnly != 1 {
+			t.Errorf("reader query_only = %d, want 1", queryOnly)
+		}
+		if got := store.readDB.Stats().MaxOpenConnections; got != 4 {
+			t.Errorf("reader MaxOpenConnections = %d, want 4", got)
+		}
+
+		// Reads through the pool see the writer's commits
+		ctx := context.Background()
+		if err := store.SaveFlow(ctx, &Flow{ID: "f1", Host: "api.anthropic.com", Method: "POST",
+			Path: "/v1/messages", URL: "https://api.anthropic.com/v1/messages", Timestamp: time.Now(),
+			FlowIntegrity: "complete", Provider: "anthropic"}); err != nil {
+			t.Fatalf("SaveFlow: %v", err)
+		}
+		if _, err := store.GetFlow(ctx, "f1"); err != nil {
+			t.Errorf("GetFlow via read pool: %v", err)
+		}
+		if n, err := store.CountFlows(ctx, FlowFilter{}); err != nil || n != 1 {
+			t.Errorf("CountFlows = %d, %v, want 1", n, err)
+		}
+	})
+
+	t.Run("in-memory skips read pool", func(t *testing.T) {
+		store, err := NewSQLiteStore(":memory:", testRetention(), WithReadPool(2))
+		if err != nil {
+			t.Fatalf("NewSQLiteStore: %v", err)
+		}
+		defer store.Close()
+		if store.readDB != nil {
+			t.Error("read pool opened for :memory:")
+		}
+	})
+
+	t.Run("invalid synchronous", func(t *testing.T) {
+		_, err := NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"), testRetention(), WithSynchronous("fast"))
+		if err == nil {
+			t.Fatal("expected error for invalid synchronous mode")
+		}
+	})
+}
+
 func TestSaveFlow_GetFlow(t *testing.T) {
 	t.Parallel()
 	store := setupTestDB(t)
@@ -240,6 +346,262 @@ func TestUpdateFlow(t *testing.T) {
 	}
 }
 
+func TestUpdateFlow_StopReasonAndError(t *testing.T) {
+	t.Parallel()
+	store := setupTestDB(t)
+	ctx := context.Background()
+
+	strPtr := func(s string) *string { return &s }
+	flows := []*Flow{
+		{ID: "stop-end", StopReason: strPtr("end_turn")},
+		{ID: "stop-max", StopReason: strPtr("max_tokens")},
+		{ID: "stop-error", ErrorType: strPtr("overloaded_error"), ErrorMessage: strPtr("Overloaded")},
+	}
+	for i, f := range flows {
+		// Saved at request start without a stop reason, filled in on completion
+		saved := &Flow{ID: f.ID, Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages",
+			Timestamp: time.Now().Add(time.Duration(i) * time.Second), FlowIntegrity: "partial", Provider: "anthropic"}
+		if err := store.SaveFlow(ctx, saved); err != nil {
+			t.Fatalf("SaveFlow(%s): %v", f.ID, err)
+		}
+		saved.StopReason, saved.ErrorType, saved.ErrorMessage = f.StopReason, f.ErrorType, f.ErrorMessage
+		if err := store.UpdateFlow(ctx, saved); err != nil {
+			t.Fatalf("UpdateFlow(%s): %v", f.ID, err)
+		}
+	}
+
+	got, err := store.GetFlow(ctx, "stop-error")
+	if err != nil {
+		t.Fatalf("GetFlow: %v", err)
+	}
+	if got.StopReason != nil {
+		t.Errorf("StopReason = %q, want nil", *got.StopReason)
+	}
+	if got.ErrorType == nil || *got.ErrorType != "overloaded_error" || got.ErrorMessage == nil || *got.ErrorMessage != "Overloaded" {
+		t.Errorf("ErrorType/ErrorMessage = %v/%v", got.ErrorType, got.ErrorMessage)
+	}
+
+	filter := FlowFilter{StopReason: strPtr("max_tokens")}
+	listed, err := store.ListFlows(ctx, filter)
+	if err != nil {
+		t.Fatalf("ListFlows: %v", err)
+	}
+	if len(listed) != 1 || listed[0].ID != "stop-max" || *listed[0].StopReason != "max_tokens" {
+		t.Errorf("ListFlows(stop_reason=max_tokens) = %v", listed)
+	}
+	if n, err := store.CountFlows(ctx, filter); err != nil || n != 1 {
+		t.Errorf("CountFlows(stop_reason=max_tokens) = %d, %v; want 1", n, err)
+	}
+}
+
+func TestFlowBodySizes_RoundTrip(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store, err := NewSQLiteStore(":memory:", testRetention())
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer store.Close()
+
+	// Sizes are only known once the response has been read
+	flow := &Flow{
+		ID: "flow-sizes", Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages",
+		URL: "https://api.anthropic.com/v1/messages", Timestamp: time.Now(), FlowIntegrity: "complete",
+		Provider: "anthropic",
+	}
+	if err := store.SaveFlow(ctx, flow); err != nil {
+		t.Fatalf("SaveFlow: %v", err)
+	}
+	if got, err := store.GetFlow(ctx, "flow-sizes"); err != nil || got.RequestBodySize != nil || got.ResponseBodySize != nil {
+		t.Fatalf("sizes before completion = %v/%v (err %v), want nil", got.RequestBodySize, got.ResponseBodySize, err)
+	}
+
+	reqSize, respSize := int64(12_000_000), int64(48_213)
+	flow.RequestBodySize, flow.ResponseBodySize = &reqSize, &respSize
+	if err := store.UpdateFlow(ctx, flow); err != nil {
+		t.Fatalf("UpdateFlow: %v", err)
+	}
+	got, err := store.GetFlow(ctx, "flow-sizes")
+	if err != nil {
+		t.Fatalf("GetFlow: %v", err)
+	}
+	listed, err := store.ListFlows(ctx, FlowFilter{})
+	if err != nil || len(listed) != 1 {
+		t.Fatalf("ListFlows = %d flows, %v", len(listed), err)
+	}
+	for _, f := range []*Flow{got, listed[0]} {
+		if f.RequestBodySize == nil || *f.RequestBodySize != reqSize || f.ResponseBodySize == nil || *f.ResponseBodySize != respSize {
+			t.Errorf("sizes = %v/%v, want %d/%d", f.RequestBodySize, f.ResponseBodySize, reqSize, respSize)
+		}
+	}
+}
+
+func TestCompressBodies_RoundTrip(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store, err := NewSQLiteStore(":memory:", testRetention(), WithCompressBodies(true))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer store.Close()
+
+	reqBody := `{"model":"claude-sonnet-4-20250514","messages":[` + strings.Repeat(`{"role":"user","content":"hello there"},`, 200) + `{"role":"user","content":"bye"}]}`
+	respBody := `{"content":[{"type":"text","text":"` + strings.Repeat("la ", 500) + `"}]}`
+	small := "{}"
+	flow := &Flow{
+		ID: "flow-gzip", Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages",
+		URL: "https://api.anthropic.com/v1/messages", Timestamp: time.Now(), FlowIntegrity: "complete",
+		Provider: "anthropic", RequestBody: &reqBody,
+	}
+	if err := store.SaveFlow(ctx, flow); err != nil {
+		t.Fatalf("SaveFlow: %v", err)
+	}
+	flow.ResponseBody = &respBody
+	if err := store.UpdateFlow(ctx, flow); err != nil {
+		t.Fatalf("UpdateFlow: %v", err)
+	}
+	tiny := &Flow{
+		ID: "flow-small", Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages",
+		URL: "https://api.anthropic.com/v1/messages", Timestamp: time.Now(), FlowIntegrity: "complete",
+		Provider: "anthropic", RequestBody: &small,
+	}
+	if err := store.SaveFlow(ctx, tiny); err != nil {
+		t.Fatalf("SaveFlow(small): %v", err)
+	}
+
+	got, err := store.GetFlow(ctx, "flow-gzip")
+	if err != nil {
+		t.Fatalf("GetFlow: %v", err)
+	}
+	if got.RequestBody == nil || *got.RequestBody != reqBody {
+		t.Error("request body didn't round-trip")
+	}
+	if got.ResponseBody == nil || *got.ResponseBody != respBody {
+		t.Error("response body didn't round-trip")
+	}
+	if got.StoredBodyBytes >= int64(len(reqBody)+len(respBody)) {
+		t.Errorf("StoredBodyBytes = %d, want less than the %d plaintext bytes", got.StoredBodyBytes, len(reqBody)+len(respBody))
+	}
+	listed, err := store.ListFlows(ctx, FlowFilter{})
+	if err != nil {
+		t.Fatalf("ListFlows: %v", err)
+	}
+	for _, f := range listed {
+		if f.ID == "flow-gzip" && (f.RequestBody == nil || *f.RequestBody != reqBody) {
+			t.Error("ListFlows returned the compressed request body")
+		}
+	}
+
+	var reqEncoding, respEncoding, smallEncoding sql.NullString
+	_ = store.db.QueryRow(`SELECT request_body_encoding, response_body_encoding FROM flows WHERE id = 'flow-gzip'`).Scan(&reqEncoding, &respEncoding)
+	_ = store.db.QueryRow(`SELECT request_body_encoding FROM flows WHERE id = 'flow-small'`).Scan(&smallEncoding)
+	if reqEncoding.String != "gzip" || respEncoding.String != "gzip" {
+		t.Errorf("encodings = %q, %q; want gzip", reqEncoding.String, respEncoding.String)
+	}
+	if smallEncoding.Valid {
+		t.Errorf("a body gzip can't shrink was stored as %q, want plain", smallEncoding.String)
+	}
+	if got, _ := store.GetFlow(ctx, "flow-small"); got == nil || got.RequestBody == nil || *got.RequestBody != small {
+		t.Error("small body didn't round-trip")
+	}
+}
+
+func TestCompressBodies_MixedRows(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	dbPath := filepath.Join(t.TempDir(), "mixed.db")
+	body := strings.Repeat(`{"role":"user","content":"hello"},`, 100)
+
+	save := func(id string, compress bool) {
+		t.Helper()
+		store, err := NewSQLiteStore(dbPath, testRetention(), WithCompressBodies(compress))
+		if err != nil {
+			t.Fatalf("NewSQLiteStore: %v", err)
+		}
+		defer store.Close()
+		flow := &Flow{
+			ID: id, Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages",
+			URL: "https://api.anthropic.com/v1/messages", Timestamp: time.Now(), FlowIntegrity: "complete",
+			Provider: "anthropic", RequestBody: &body, ResponseBody: &body,
+		}
+		if err := store.SaveFlow(ctx, flow); err != nil {
+			t.Fatalf("SaveFlow(%s): %v", id, err)
+		}
+	}
+	save("plain", false)
+	save("compressed", true)
+
+	// Either setting reads both rows
+	for _, compress := range []bool{false, true} {
+		store, err := NewSQLiteStore(dbPath, testRetention(), WithCompressBodies(compress))
+		if err != nil {
+			t.Fatalf("NewSQLiteStore: %v", err)
+		}
+		flows, err := store.ListFlows(ctx, FlowFilter{})
+		if err != nil {
+			t.Fatalf("compress=%v: ListFlows: %v", compress, err)
+		}
+		if len(flows) != 2 {
+			t.Errorf("compress=%v: got %d flows, want 2", compress, len(flows))
+		}
+		for _, f := range flows {
+			if f.RequestBody == nil || *f.RequestBody != body || f.ResponseBody == nil || *f.ResponseBody != body {
+				t.Errorf("compress=%v: flow %s bodies didn't read back", compress, f.ID)
+			}
+		}
+		store.Close()
+	}
+
+	// An encoding this version doesn't know fails loudly instead of
+	// returning the raw bytes
+	store, err := NewSQLiteStore(dbPath, testRetention())
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer store.Close()
+	if _, err := store.db.Exec(`UPDATE flows SET request_body_encoding = 'zstd' WHERE id = 'plain'`); err != nil {
+		t.Fatalf("marking row: %v", err)
+	}
+	if _, err := store.GetFlow(ctx, "plain"); err == nil {
+		t.Error("GetFlow with an unknown body encoding succeeded, want an error")
+	}
+}
+
+func TestSaveFlow_RequestHeaderOrder(t *testing.T) {
+	t.Parallel()
+	store := setupTestDB(t)
+	ctx := context.Background()
+
+	order := []string{"x-stainless-lang", "Host", "anthropic-version", "Accept", "Accept"}
+	flows := []*Flow{
+		{ID: "ordered", Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages",
+			Timestamp: time.Now(), FlowIntegrity: "complete", Provider: "anthropic", RequestHeaderOrder: order},
+		{ID: "unordered", Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages",
+			Timestamp: time.Now(), FlowIntegrity: "complete", Provider: "anthropic"},
+	}
+	for _, f := range flows {
+		if err := store.SaveFlow(ctx, f); err != nil {
+			t.Fatalf("SaveFlow(%s): %v", f.ID, err)
+		}
+	}
+
+	got, err := store.GetFlow(ctx, "ordered")
+	if err != nil {
+		t.Fatalf("GetFlow: %v", err)
+	}
+	if strings.Join(got.RequestHeaderOrder, ",") != strings.Join(order, ",") {
+		t.Errorf("RequestHeaderOrder = %v, want %v", got.RequestHeaderOrder, order)
+	}
+
+	got, err = store.GetFlow(ctx, "unordered")
+	if err != nil {
+		t.Fatalf("GetFlow: %v", err)
+	}
+	if got.RequestHeaderOrder != nil {
+		t.Errorf("RequestHeaderOrder = %v, want nil", got.RequestHeaderOrder)
+	}
+}
+
 func TestDeleteFlow(t *testing.T) {
 	t.Parallel()
 	store := setupTestDB(t)
@@ -336,23 +698,463 @@ func TestListFlows(t *testing.T) {
 		}
 	})
 
-	t.Run("limit and offset", func(t *testing.T) {
-		flows, err := store.ListFlows(ctx, FlowFilter{Limit: 2})
-		if err != nil {
-			t.Fatalf("ListFlows failed: %v", err)
-		}
-		if len(flows) != 2 {
-			t.Errorf("len(flows) = %d, want 2", len(flows))
-		}
+	t.Run("limit and offset", func(t *testing.T) {
+		flows, err := store.ListFlows(ctx, FlowFilter{Limit: 2})
+		if err != nil {
+			t.Fatalf("ListFlows failed: %v", err)
+		}
+		if len(flows) != 2 {
+			t.Errorf("len(flows) = %d, want 2", len(flows))
+		}
+
+		flows, err = store.ListFlows(ctx, FlowFilter{Limit: 2, Offset: 2})
+		if err != nil {
+			t.Fatalf("ListFlows failed: %v", err)
+		}
+		if len(flows) != 1 {
+			t.Errorf("len(flows) = %d, want 1", len(flows))
+		}
+	})
+}
+
+func TestListFlows_MixedOffsets(t *testing.T) {
+	t.Parallel()
+	store := setupTestDB(t)
+	ctx := context.Background()
+
+	// The same instants written from three different local offsets
+	base := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	zones := []*time.Location{time.FixedZone("UTC+5", 5*3600), time.UTC, time.FixedZone("UTC-7", -7*3600)}
+	for i, loc := range zones {
+		flow := &Flow{
+			ID:            fmt.Sprintf("flow-offset-%d", i),
+			Host:          "api.anthropic.com",
+			Method:        "POST",
+			Path:          "/v1/messages",
+			URL:           "https://api.anthropic.com/v1/messages",
+			Timestamp:     base.Add(time.Duration(i) * time.Minute).In(loc),
+			FlowIntegrity: "complete",
+			Provider:      "anthropic",
+		}
+		if err := store.SaveFlow(ctx, flow); err != nil {
+			t.Fatalf("SaveFlow %d failed: %v", i, err)
+		}
+	}
+
+	ids := func(filter FlowFilter) string {
+		t.Helper()
+		flows, err := store.ListFlows(ctx, filter)
+		if err != nil {
+			t.Fatalf("ListFlows failed: %v", err)
+		}
+		var got []string
+		for _, f := range flows {
+			got = append(got, strings.TrimPrefix(f.ID, "flow-offset-"))
+		}
+		return strings.Join(got, ",")
+	}
+	start, end := base.Add(30*time.Second), base.Add(90*time.Second)
+	tests := []struct {
+		name   string
+		filter FlowFilter
+		want   string
+	}{
+		{"newest first", FlowFilter{}, "2,1,0"},
+		{"time range", FlowFilter{StartTime: &start, EndTime: &end}, "1"},
+		{"since", FlowFilter{StartTime: &start}, "2,1"},
+		{"after cursor", FlowFilter{After: &FlowCursor{Timestamp: base.Add(2 * time.Minute).In(zones[0]), ID: "flow-offset-2"}}, "1,0"},
+		{"after timestamp", FlowFilter{After: &FlowCursor{Timestamp: base.Add(time.Minute).In(zones[2])}}, "0"},
+	}
+	for _, tt := range tests {
+		if got := ids(tt.filter); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	// Rows written before timestamps were normalized are rewritten
+	if _, err := store.db.Exec(`UPDATE flows SET timestamp = '2025-06-01T17:00:00.5+05:00' WHERE id = 'flow-offset-0'`); err != nil {
+		t.Fatalf("seeding offset timestamp: %v", err)
+	}
+	if _, err := store.db.Exec(migrationV22); err != nil {
+		t.Fatalf("migrationV22: %v", err)
+	}
+	var ts string
+	_ = store.db.QueryRow(`SELECT timestamp FROM flows WHERE id = 'flow-offset-0'`).Scan(&ts)
+	if want := "2025-06-01T12:00:00.500000000Z"; ts != want {
+		t.Errorf("migrated timestamp = %q, want %q", ts, want)
+	}
+}
+
+func TestFlows_EventCountAndBodySize(t *testing.T) {
+	t.Parallel()
+	store := setupTestDB(t)
+	ctx := context.Background()
+
+	reqBody := `{"model":"claude"}`
+	respBody := "é" // Two bytes in UTF-8, one character
+	seed := map[string]int{"streamed": 5, "plain": 0}
+	for id, events := range seed {
+		flow := &Flow{
+			ID:            id,
+			Host:          "api.anthropic.com",
+			Method:        "POST",
+			Path:          "/v1/messages",
+			Timestamp:     time.Now(),
+			FlowIntegrity: "complete",
+			Provider:      "anthropic",
+			IsSSE:         events > 0,
+			RequestBody:   &reqBody,
+			ResponseBody:  &respBody,
+		}
+		if err := store.SaveFlow(ctx, flow); err != nil {
+			t.Fatalf("SaveFlow(%s) failed: %v", id, err)
+		}
+		for i := 0; i < events; i++ {
+			event := &Event{
+				ID:        fmt.Sprintf("%s-%d", id, i),
+				FlowID:    id,
+				Sequence:  i,
+				Timestamp: time.Now(),
+				EventType: "content_block_delta",
+				EventData: map[string]interface{}{"index": i},
+				Priority:  "low",
+			}
+			if err := store.SaveEvent(ctx, event); err != nil {
+				t.Fatalf("SaveEvent failed: %v", err)
+			}
+		}
+	}
+
+	wantBytes := int64(len(reqBody) + len(respBody))
+	flows, err := store.ListFlows(ctx, FlowFilter{})
+	if err != nil {
+		t.Fatalf("ListFlows failed: %v", err)
+	}
+	for _, f := range flows {
+		if f.EventCount != seed[f.ID] || f.StoredBodyBytes != wantBytes {
+			t.Errorf("ListFlows %s: event_count %d, stored_body_bytes %d; want %d, %d",
+				f.ID, f.EventCount, f.StoredBodyBytes, seed[f.ID], wantBytes)
+		}
+	}
+
+	f, err := store.GetFlow(ctx, "streamed")
+	if err != nil {
+		t.Fatalf("GetFlow failed: %v", err)
+	}
+	events, _ := store.GetEventsByFlow(ctx, "streamed")
+	if f.EventCount != len(events) || f.StoredBodyBytes != wantBytes {
+		t.Errorf("GetFlow: event_count %d, stored_body_bytes %d; want %d, %d",
+			f.EventCount, f.StoredBodyBytes, len(events), wantBytes)
+	}
+}
+
+func TestListFlows_StatusAndProvider(t *testing.T) {
+	t.Parallel()
+	store := setupTestDB(t)
+	ctx := context.Background()
+
+	status := func(v int) *int { return &v }
+	seed := []struct {
+		id       string
+		provider string
+		status   *int
+	}{
+		{"ok-anthropic", "anthropic", status(200)},
+		{"throttled-anthropic", "anthropic", status(429)},
+		{"failed-openai", "openai", status(500)},
+		{"ok-openai", "openai", status(200)},
+		{"no-response", "openai", nil},
+	}
+	base := time.Now()
+	for i, sd := range seed {
+		flow := &Flow{
+			ID:            sd.id,
+			Host:          "api." + sd.provider + ".com",
+			Method:        "POST",
+			Path:          "/v1/messages",
+			Timestamp:     base.Add(time.Duration(i) * time.Second),
+			FlowIntegrity: "complete",
+			Provider:      sd.provider,
+			StatusCode:    sd.status,
+		}
+		if err := store.SaveFlow(ctx, flow); err != nil {
+			t.Fatalf("SaveFlow(%s) failed: %v", sd.id, err)
+		}
+	}
+
+	openai := "openai"
+	tests := []struct {
+		name   string
+		filter FlowFilter
+		want   string // IDs, newest first
+	}{
+		{"errors", FlowFilter{StatusMin: status(400)}, "failed-openai,throttled-anthropic"},
+		{"client errors", FlowFilter{StatusMin: status(400), StatusMax: status(499)}, "throttled-anthropic"},
+		{"successes", FlowFilter{StatusMax: status(299)}, "ok-openai,ok-anthropic"},
+		{"provider", FlowFilter{Provider: &openai}, "no-response,ok-openai,failed-openai"},
+		{"provider errors", FlowFilter{Provider: &openai, StatusMin: status(400)}, "failed-openai"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flows, err := store.ListFlows(ctx, tt.filter)
+			if err != nil {
+				t.Fatalf("ListFlows failed: %v", err)
+			}
+			var got []string
+			for _, f := range flows {
+				got = append(got, f.ID)
+			}
+			if strings.Join(got, ",") != tt.want {
+				t.Errorf("ListFlows = %v, want %s", got, tt.want)
+			}
+
+			count, err := store.CountFlows(ctx, tt.filter)
+			if err != nil {
+				t.Fatalf("CountFlows failed: %v", err)
+			}
+			if count != len(got) {
+				t.Errorf("CountFlows = %d, want %d", count, len(got))
+			}
+		})
+	}
+}
+
+func TestListFlows_SortByCost(t *testing.T) {
+	t.Parallel()
+	store := setupTestDB(t)
+	ctx := context.Background()
+
+	cost := func(v float64) *float64 { return &v }
+	seed := []struct {
+		id   string
+		cost *float64
+	}{
+		{"cheap", cost(0.01)},
+		{"pricey", cost(2.50)},
+		{"mid", cost(0.75)},
+		{"unpriced", nil},
+		{"top", cost(9.99)},
+	}
+	base := time.Now()
+	for i, sd := range seed {
+		flow := &Flow{
+			ID:            sd.id,
+			Host:          "api.anthropic.com",
+			Method:        "POST",
+			Path:          "/v1/messages",
+			Timestamp:     base.Add(time.Duration(i) * time.Second),
+			FlowIntegrity: "complete",
+			Provider:      "anthropic",
+			TotalCost:     sd.cost,
+		}
+		if err := store.SaveFlow(ctx, flow); err != nil {
+			t.Fatalf("SaveFlow(%s) failed: %v", sd.id, err)
+		}
+	}
+
+	flows, err := store.ListFlows(ctx, FlowFilter{SortByCost: true, Limit: 3})
+	if err != nil {
+		t.Fatalf("ListFlows failed: %v", err)
+	}
+	var got []string
+	for _, f := range flows {
+		got = append(got, f.ID)
+	}
+	if want := "top,pricey,mid"; strings.Join(got, ",") != want {
+		t.Errorf("order = %v, want %s", got, want)
+	}
+
+	count, err := store.CountFlows(ctx, FlowFilter{SortByCost: true})
+	if err != nil {
+		t.Fatalf("CountFlows failed: %v", err)
+	}
+	if count != 4 {
+		t.Errorf("count = %d, want 4 (unpriced flows excluded)", count)
+	}
+}
+
+func TestFlowTags(t *testing.T) {
+	t.Parallel()
+	store := setupTestDB(t)
+	ctx := context.Background()
+
+	for _, id := range []string{"tagged", "other", "untagged"} {
+		flow := &Flow{
+			ID:            id,
+			Host:          "api.anthropic.com",
+			Method:        "POST",
+			Path:          "/v1/messages",
+			Timestamp:     time.Now(),
+			FlowIntegrity: "complete",
+			Provider:      "anthropic",
+		}
+		if err := store.SaveFlow(ctx, flow); err != nil {
+			t.Fatalf("SaveFlow(%s) failed: %v", id, err)
+		}
+	}
+
+	mustTag := func(flowID, key, value string) {
+		t.Helper()
+		if err := store.AddFlowTag(ctx, flowID, key, value); err != nil {
+			t.Fatalf("AddFlowTag(%s, %s) failed: %v", flowID, key, err)
+		}
+	}
+	mustTag("tagged", "experiment", "a")
+	mustTag("tagged", "reviewed", "")
+	mustTag("other", "experiment", "b")
+
+	// Re-adding a key replaces its value
+	mustTag("tagged", "experiment", "c")
+
+	tags, err := store.ListFlowTags(ctx, "tagged")
+	if err != nil {
+		t.Fatalf("ListFlowTags failed: %v", err)
+	}
+	if len(tags) != 2 {
+		t.Fatalf("got %d tags, want 2", len(tags))
+	}
+	if tags[0].Key != "experiment" || tags[0].Value != "c" {
+		t.Errorf("tags[0] = %+v, want experiment=c", tags[0])
+	}
+	if tags[1].Key != "reviewed" || tags[1].Value != "" {
+		t.Errorf("tags[1] = %+v, want reviewed with empty value", tags[1])
+	}
+
+	filterIDs := func(tag string) []string {
+		t.Helper()
+		flows, err := store.ListFlows(ctx, FlowFilter{Tag: &tag})
+		if err != nil {
+			t.Fatalf("ListFlows(tag=%s) failed: %v", tag, err)
+		}
+		ids := make([]string, 0, len(flows))
+		for _, f := range flows {
+			ids = append(ids, f.ID)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+	if got := filterIDs("experiment"); strings.Join(got, ",") != "other,tagged" {
+		t.Errorf("tag=experiment matched %v", got)
+	}
+	if got := filterIDs("experiment=b"); strings.Join(got, ",") != "other" {
+		t.Errorf("tag=experiment=b matched %v", got)
+	}
+	if got := filterIDs("missing"); len(got) != 0 {
+		t.Errorf("tag=missing matched %v", got)
+	}
+
+	tag := "experiment"
+	count, err := store.CountFlows(ctx, FlowFilter{Tag: &tag})
+	if err != nil {
+		t.Fatalf("CountFlows failed: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("count = %d, want 2", count)
+	}
+
+	if err := store.DeleteFlowTag(ctx, "tagged", "reviewed"); err != nil {
+		t.Fatalf("DeleteFlowTag failed: %v", err)
+	}
+	tags, _ = store.ListFlowTags(ctx, "tagged")
+	if len(tags) != 1 {
+		t.Errorf("got %d tags after delete, want 1", len(tags))
+	}
+}
+
+func TestSetFlowNotes(t *testing.T) {
+	t.Parallel()
+	store := setupTestDB(t)
+	ctx := context.Background()
+
+	flow := &Flow{
+		ID:            "noted",
+		Host:          "api.anthropic.com",
+		Method:        "POST",
+		Path:          "/v1/messages",
+		Timestamp:     time.Now(),
+		FlowIntegrity: "complete",
+		Provider:      "anthropic",
+	}
+	if err := store.SaveFlow(ctx, flow); err != nil {
+		t.Fatalf("SaveFlow failed: %v", err)
+	}
+
+	notes := "retried three times"
+	if err := store.SetFlowNotes(ctx, "noted", &notes); err != nil {
+		t.Fatalf("SetFlowNotes failed: %v", err)
+	}
+	got, err := store.GetFlow(ctx, "noted")
+	if err != nil {
+		t.Fatalf("GetFlow failed: %v", err)
+	}
+	if got.Notes == nil || *got.Notes != notes {
+		t.Errorf("Notes = %v, want %q", got.Notes, notes)
+	}
+
+	// The proxy updating the flow must not clobber notes it never loaded
+	status := 200
+	flow.StatusCode = &status
+	if err := store.UpdateFlow(ctx, flow); err != nil {
+		t.Fatalf("UpdateFlow failed: %v", err)
+	}
+	flows, err := store.ListFlows(ctx, FlowFilter{})
+	if err != nil {
+		t.Fatalf("ListFlows failed: %v", err)
+	}
+	if len(flows) != 1 || flows[0].Notes == nil || *flows[0].Notes != notes {
+		t.Errorf("Notes after UpdateFlow = %v, want %q", flows[0].Notes, notes)
+	}
+
+	if err := store.SetFlowNotes(ctx, "noted", nil); err != nil {
+		t.Fatalf("SetFlowNotes(nil) failed: %v", err)
+	}
+	got, _ = store.GetFlow(ctx, "noted")
+	if got.Notes != nil {
+		t.Errorf("Notes after clear = %q, want nil", *got.Notes)
+	}
+
+	if err := store.SetFlowNotes(ctx, "missing", &notes); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("SetFlowNotes(missing) = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestFlowTags_CascadeDelete(t *testing.T) {
+	t.Parallel()
+	store := setupTestDB(t)
+	ctx := context.Background()
+
+	flow := &Flow{
+		ID:            "doomed",
+		Host:          "api.anthropic.com",
+		Method:        "POST",
+		Path:          "/v1/messages",
+		Timestamp:     time.Now(),
+		FlowIntegrity: "complete",
+		Provider:      "anthropic",
+	}
+	if err := store.SaveFlow(ctx, flow); err != nil {
+		t.Fatalf("SaveFlow failed: %v", err)
+	}
+	if err := store.AddFlowTag(ctx, "doomed", "experiment", "a"); err != nil {
+		t.Fatalf("AddFlowTag failed: %v", err)
+	}
+
+	if err := store.DeleteFlow(ctx, "doomed"); err != nil {
+		t.Fatalf("DeleteFlow failed: %v", err)
+	}
 
-		flows, err = store.ListFlows(ctx, FlowFilter{Limit: 2, Offset: 2})
-		if err != nil {
-			t.Fatalf("ListFlows failed: %v", err)
-		}
-		if len(flows) != 1 {
-			t.Errorf("len(flows) = %d, want 1", len(flows))
-		}
-	})
+	var n int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM flow_tags").Scan(&n); err != nil {
+		t.Fatalf("count tags: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("%d tags left after deleting their flow, want 0", n)
+	}
+
+	// Tagging a flow that doesn't exist violates the foreign key
+	if err := store.AddFlowTag(ctx, "doomed", "experiment", "a"); err == nil {
+		t.Error("expected error tagging a deleted flow")
+	}
 }
 
 func TestSaveEvent_GetEventsByFlow(t *testing.T) {
@@ -475,6 +1277,14 @@ func TestSaveEvents_Batch(t *testing.T) {
 	if len(got) != 100 {
 		t.Errorf("len(events) = %d, want 100", len(got))
 	}
+
+	first, err := store.GetEventsByFlowLimit(ctx, "flow-batch-1", 10)
+	if err != nil {
+		t.Fatalf("GetEventsByFlowLimit failed: %v", err)
+	}
+	if len(first) != 10 || first[0].Sequence != 1 || first[9].Sequence != 10 {
+		t.Errorf("GetEventsByFlowLimit(10) = %d events, want sequences 1-10", len(first))
+	}
 }
 
 func TestSaveToolInvocation_GetToolInvocationsByFlow(t *testing.T) {
@@ -660,109 +1470,622 @@ func TestUpdateToolResult(t *testing.T) {
 		}
 	})
 
-	t.Run("nonexistent tool_use_id", func(t *testing.T) {
-		// Should not error, just update 0 rows
-		err := store.UpdateToolResult(ctx, "toolu_nonexistent", true, nil, nil, time.Now())
-		if err != nil {
-			t.Errorf("UpdateToolResult with nonexistent ID should not error, got: %v", err)
+	t.Run("nonexistent tool_use_id", func(t *testing.T) {
+		// Should not error, just update 0 rows
+		err := store.UpdateToolResult(ctx, "toolu_nonexistent", true, nil, nil, time.Now())
+		if err != nil {
+			t.Errorf("UpdateToolResult with nonexistent ID should not error, got: %v", err)
+		}
+	})
+}
+
+func TestLogDrop(t *testing.T) {
+	t.Parallel()
+	store := setupTestDB(t)
+	ctx := context.Background()
+
+	flowID := "flow-drop-1"
+	eventType := "content_block_delta"
+
+	entry := &DropLogEntry{
+		FlowID:    &flowID,
+		EventType: &eventType,
+		Priority:  "low",
+		Reason:    "queue full",
+	}
+
+	err := store.LogDrop(ctx, entry)
+	if err != nil {
+		t.Fatalf("LogDrop failed: %v", err)
+	}
+
+	// Verify by direct query
+	var count int
+	err = store.db.QueryRow("SELECT COUNT(*) FROM drop_log").Scan(&count)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("drop_log count = %d, want 1", count)
+	}
+}
+
+func TestRunRetention(t *testing.T) {
+	t.Parallel()
+	store := setupTestDB(t)
+	ctx := context.Background()
+
+	// Create expired flow
+	expiredTime := time.Now().Add(-24 * time.Hour)
+	expiredFlow := &Flow{
+		ID:            "flow-expired",
+		Host:          "api.anthropic.com",
+		Method:        "POST",
+		Path:          "/v1/messages",
+		URL:           "https://api.anthropic.com/v1/messages",
+		Timestamp:     expiredTime,
+		TimestampMono: expiredTime.UnixNano(),
+		FlowIntegrity: "complete",
+		Provider:      "anthropic",
+		ExpiresAt:     &expiredTime,
+	}
+	if err := store.SaveFlow(ctx, expiredFlow); err != nil {
+		t.Fatalf("SaveFlow failed: %v", err)
+	}
+
+	// Create non-expired flow
+	futureTime := time.Now().Add(7 * 24 * time.Hour)
+	validFlow := &Flow{
+		ID:            "flow-valid",
+		Host:          "api.anthropic.com",
+		Method:        "POST",
+		Path:          "/v1/messages",
+		URL:           "https://api.anthropic.com/v1/messages",
+		Timestamp:     time.Now(),
+		TimestampMono: time.Now().UnixNano(),
+		FlowIntegrity: "complete",
+		Provider:      "anthropic",
+		ExpiresAt:     &futureTime,
+	}
+	if err := store.SaveFlow(ctx, validFlow); err != nil {
+		t.Fatalf("SaveFlow failed: %v", err)
+	}
+
+	// Run retention
+	deleted, err := store.RunRetention(ctx)
+	if err != nil {
+		t.Fatalf("RunRetention failed: %v", err)
+	}
+	if deleted < 1 {
+		t.Errorf("deleted = %d, want >= 1", deleted)
+	}
+
+	// Verify expired flow deleted
+	_, err = store.GetFlow(ctx, "flow-expired")
+	if err != sql.ErrNoRows {
+		t.Errorf("expected expired flow to be deleted, got err: %v", err)
+	}
+
+	// Verify valid flow still exists
+	got, err := store.GetFlow(ctx, "flow-valid")
+	if err != nil {
+		t.Errorf("expected valid flow to exist, got err: %v", err)
+	}
+	if got == nil {
+		t.Error("valid flow should still exist")
+	}
+}
+
+func TestRunRetention_EventsOutliveTheirTTL(t *testing.T) {
+	t.Parallel()
+	store := setupTestDB(t) // EventsTTLDays: 3, FlowsTTLDays: 7
+	ctx := context.Background()
+
+	flowTime := time.Now().Add(-5 * 24 * time.Hour)
+	flowExpires := flowTime.AddDate(0, 0, 7)
+	flow := &Flow{
+		ID:            "flow-old-events",
+		Host:          "api.anthropic.com",
+		Method:        "POST",
+		Path:          "/v1/messages",
+		Timestamp:     flowTime,
+		FlowIntegrity: "complete",
+		Provider:      "anthropic",
+		ExpiresAt:     &flowExpires,
+	}
+	if err := store.SaveFlow(ctx, flow); err != nil {
+		t.Fatalf("SaveFlow failed: %v", err)
+	}
+
+	expired := flowTime.AddDate(0, 0, 3)
+	notExpired := time.Now().Add(time.Hour)
+	events := []*Event{
+		// expires_at set by the proxy
+		{ID: "ev-expired", FlowID: flow.ID, Sequence: 1, Timestamp: flowTime, EventType: "message_start",
+			EventData: map[string]interface{}{}, Priority: "high", ExpiresAt: &expired},
+		// No expires_at: falls back to timestamp + EventsTTLDays
+		{ID: "ev-legacy", FlowID: flow.ID, Sequence: 2, Timestamp: flowTime, EventType: "content_block_delta",
+			EventData: map[string]interface{}{}, Priority: "low"},
+		{ID: "ev-recent", FlowID: flow.ID, Sequence: 3, Timestamp: time.Now(), EventType: "message_stop",
+			EventData: map[string]interface{}{}, Priority: "high", ExpiresAt: &notExpired},
+	}
+	for _, e := range events {
+		if err := store.SaveEvent(ctx, e); err != nil {
+			t.Fatalf("SaveEvent(%s) failed: %v", e.ID, err)
+		}
+	}
+
+	deleted, err := store.RunRetention(ctx)
+	if err != nil {
+		t.Fatalf("RunRetention failed: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("deleted = %d, want 2 events", deleted)
+	}
+
+	if _, err := store.GetFlow(ctx, flow.ID); err != nil {
+		t.Fatalf("flow should survive event retention: %v", err)
+	}
+	remaining, err := store.GetEventsByFlow(ctx, flow.ID)
+	if err != nil {
+		t.Fatalf("GetEventsByFlow failed: %v", err)
+	}
+	if len(remaining) != 1 || remaining[0].ID != "ev-recent" {
+		t.Errorf("remaining events = %d, want only ev-recent", len(remaining))
+	}
+}
+
+func TestTaskRetention(t *testing.T) {
+	t.Parallel()
+	store := setupTestDB(t) // FlowsTTLDays: 7
+	ctx := context.Background()
+
+	now := time.Now()
+	day := 24 * time.Hour
+	saveFlow := func(id, taskID string, age time.Duration) {
+		t.Helper()
+		ts := now.Add(-age)
+		expires := ts.AddDate(0, 0, 7) // Global TTL, as the proxy computed it
+		f := &Flow{ID: id, TaskID: &taskID, Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages",
+			Timestamp: ts, FlowIntegrity: "complete", Provider: "anthropic", ExpiresAt: &expires}
+		if err := store.SaveFlow(ctx, f); err != nil {
+			t.Fatalf("SaveFlow(%s): %v", id, err)
+		}
+	}
+	saveFlow("flagged-old", "important", 10*day)
+	saveFlow("flagged-ancient", "important", 40*day)
+	saveFlow("other-old", "routine", 10*day)
+
+	if err := store.SetTaskRetention(ctx, "important", 30); err != nil {
+		t.Fatalf("SetTaskRetention: %v", err)
+	}
+	if days, err := store.GetTaskRetention(ctx, "important"); err != nil || days != 30 {
+		t.Fatalf("GetTaskRetention = %d, %v; want 30", days, err)
+	}
+	if days, err := store.GetTaskRetention(ctx, "routine"); err != nil || days != 0 {
+		t.Fatalf("GetTaskRetention(routine) = %d, %v; want 0", days, err)
+	}
+
+	// Saved after the override with a stale expires_at, as by a flow that
+	// finished while it was being set
+	saveFlow("flagged-late", "important", 10*day)
+
+	got, err := store.GetFlow(ctx, "flagged-old")
+	if err != nil {
+		t.Fatalf("GetFlow: %v", err)
+	}
+	if want := got.Timestamp.AddDate(0, 0, 30); got.ExpiresAt == nil || got.ExpiresAt.Sub(want).Abs() > time.Second {
+		t.Errorf("ExpiresAt = %v, want %v", got.ExpiresAt, want)
+	}
+
+	preview, err := store.PreviewRetention(ctx)
+	if err != nil {
+		t.Fatalf("PreviewRetention: %v", err)
+	}
+	if preview.Flows != 2 {
+		t.Errorf("preview.Flows = %d, want 2", preview.Flows)
+	}
+	if _, err := store.RunRetention(ctx); err != nil {
+		t.Fatalf("RunRetention: %v", err)
+	}
+	for id, wantKept := range map[string]bool{
+		"flagged-old":     true,
+		"flagged-late":    true,
+		"flagged-ancient": false, // Past even the override
+		"other-old":       false,
+	} {
+		_, err := store.GetFlow(ctx, id)
+		if kept := err == nil; kept != wantKept {
+			t.Errorf("%s kept = %v (err %v), want %v", id, kept, err, wantKept)
+		}
+	}
+
+	// Removing the override puts the task back on the global TTL
+	if err := store.SetTaskRetention(ctx, "important", 0); err != nil {
+		t.Fatalf("SetTaskRetention(0): %v", err)
+	}
+	if days, err := store.GetTaskRetention(ctx, "important"); err != nil || days != 0 {
+		t.Fatalf("GetTaskRetention after removal = %d, %v; want 0", days, err)
+	}
+	if _, err := store.RunRetention(ctx); err != nil {
+		t.Fatalf("RunRetention: %v", err)
+	}
+	if n, err := store.CountFlows(ctx, FlowFilter{}); err != nil || n != 0 {
+		t.Errorf("CountFlows = %d, %v; want 0", n, err)
+	}
+}
+
+func TestTaskRetention_KeepsEventsAndBodies(t *testing.T) {
+	t.Parallel()
+	store, err := NewSQLiteStore(":memory:", &config.RetentionConfig{
+		FlowsTTLDays:   30,
+		EventsTTLDays:  7,
+		BodiesTTLDays:  3,
+		DropLogTTLDays: 7,
+	})
+	if err != nil {
+		t.Fatalf("failed to create test store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	ctx := context.Background()
+
+	if err := store.SetTaskRetention(ctx, "important", 365); err != nil {
+		t.Fatalf("SetTaskRetention: %v", err)
+	}
+	body := `{"messages":[]}`
+	ts := time.Now().Add(-10 * 24 * time.Hour)
+	for _, taskID := range []string{"important", "routine"} {
+		expires := ts.AddDate(0, 0, 30)
+		f := &Flow{ID: taskID, TaskID: &taskID, Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages",
+			Timestamp: ts, FlowIntegrity: "complete", Provider: "anthropic",
+			RequestBody: &body, ResponseBody: &body, ExpiresAt: &expires}
+		if err := store.SaveFlow(ctx, f); err != nil {
+			t.Fatalf("SaveFlow(%s): %v", taskID, err)
+		}
+		eventExpires := ts.AddDate(0, 0, 7)
+		if err := store.SaveEvent(ctx, &Event{ID: "ev-" + taskID, FlowID: taskID, Sequence: 1, Timestamp: ts,
+			EventType: "message_start", EventData: map[string]interface{}{}, Priority: "high", ExpiresAt: &eventExpires}); err != nil {
+			t.Fatalf("SaveEvent(%s): %v", taskID, err)
+		}
+	}
+
+	preview, err := store.PreviewRetention(ctx)
+	if err != nil {
+		t.Fatalf("PreviewRetention: %v", err)
+	}
+	if preview.Events != 1 || preview.BodiesStripped != 1 {
+		t.Errorf("preview events = %d, bodies = %d; want 1 each", preview.Events, preview.BodiesStripped)
+	}
+	if _, err := store.RunRetention(ctx); err != nil {
+		t.Fatalf("RunRetention: %v", err)
+	}
+
+	for id, wantKept := range map[string]bool{"important": true, "routine": false} {
+		f, err := store.GetFlow(ctx, id)
+		if err != nil {
+			t.Fatalf("GetFlow(%s): %v", id, err)
+		}
+		if kept := f.RequestBody != nil && f.ResponseBody != nil; kept != wantKept {
+			t.Errorf("%s bodies kept = %v, want %v", id, kept, wantKept)
+		}
+		events, err := store.GetEventsByFlow(ctx, id)
+		if err != nil {
+			t.Fatalf("GetEventsByFlow(%s): %v", id, err)
+		}
+		if kept := len(events) == 1; kept != wantKept {
+			t.Errorf("%s events kept = %v, want %v", id, kept, wantKept)
+		}
+	}
+}
+
+func TestPreviewRetention_MatchesRunRetention(t *testing.T) {
+	t.Parallel()
+	store, err := NewSQLiteStore(":memory:", &config.RetentionConfig{
+		FlowsTTLDays:   7,
+		EventsTTLDays:  3,
+		BodiesTTLDays:  3,
+		DropLogTTLDays: 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create test store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	ctx := context.Background()
+
+	body := strings.Repeat("b", 100)
+	now := time.Now()
+	newFlow := func(id string, age, expiresIn time.Duration) *Flow {
+		expires := now.Add(expiresIn)
+		return &Flow{
+			ID:            id,
+			Host:          "api.anthropic.com",
+			Method:        "POST",
+			Path:          "/v1/messages",
+			Timestamp:     now.Add(-age),
+			FlowIntegrity: "complete",
+			Provider:      "anthropic",
+			RequestBody:   &body,
+			ResponseBody:  &body,
+			ExpiresAt:     &expires,
+		}
+	}
+	day := 24 * time.Hour
+	flows := []*Flow{
+		newFlow("flow-expired", 8*day, -day),     // deleted with its events and tool calls
+		newFlow("flow-old", 5*day, 2*day),        // kept, bodies stripped
+		newFlow("flow-recent", time.Hour, 7*day), // untouched
+	}
+	for _, f := range flows {
+		if err := store.SaveFlow(ctx, f); err != nil {
+			t.Fatalf("SaveFlow(%s) failed: %v", f.ID, err)
+		}
+	}
+
+	events := []*Event{
+		{ID: "ev-1", FlowID: "flow-expired", Sequence: 1, Timestamp: now.Add(-8 * day), EventType: "message_start", Priority: "high"},
+		{ID: "ev-2", FlowID: "flow-expired", Sequence: 2, Timestamp: now.Add(-8 * day), EventType: "message_stop", Priority: "high"},
+		{ID: "ev-3", FlowID: "flow-old", Sequence: 1, Timestamp: now.Add(-5 * day), EventType: "message_start", Priority: "high"},
+		{ID: "ev-4", FlowID: "flow-recent", Sequence: 1, Timestamp: now, EventType: "message_start", Priority: "high"},
+	}
+	for _, e := range events {
+		e.EventData = map[string]interface{}{"type": e.EventType}
+		if err := store.SaveEvent(ctx, e); err != nil {
+			t.Fatalf("SaveEvent(%s) failed: %v", e.ID, err)
+		}
+	}
+	if err := store.SaveToolInvocation(ctx, &ToolInvocation{
+		ID: "inv-1", FlowID: "flow-expired", ToolName: "Read", Timestamp: now.Add(-8 * day),
+	}); err != nil {
+		t.Fatalf("SaveToolInvocation failed: %v", err)
+	}
+	for _, started := range []time.Time{now.Add(-10 * day), now} {
+		if err := store.SaveTunnel(ctx, &Tunnel{Host: "example.com:443", Mode: TunnelModePassthrough, StartedAt: started, EndedAt: started}); err != nil {
+			t.Fatalf("SaveTunnel failed: %v", err)
+		}
+	}
+	if err := store.LogDrop(ctx, &DropLogEntry{Priority: "low", Reason: "queue_full"}); err != nil {
+		t.Fatalf("LogDrop failed: %v", err)
+	}
+	if _, err := store.db.ExecContext(ctx,
+		"INSERT INTO drop_log (priority, reason, timestamp) VALUES ('low', 'queue_full', datetime('now', '-3 days'))"); err != nil {
+		t.Fatalf("seeding old drop_log: %v", err)
+	}
+
+	countRows := func() map[string]int64 {
+		counts := make(map[string]int64)
+		for _, table := range []string{"flows", "events", "tool_invocations", "tunnels", "drop_log"} {
+			var n int64
+			if err := store.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&n); err != nil {
+				t.Fatalf("counting %s: %v", table, err)
+			}
+			counts[table] = n
+		}
+		var n int64
+		if err := store.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM flows WHERE request_body IS NOT NULL").Scan(&n); err != nil {
+			t.Fatalf("counting bodies: %v", err)
+		}
+		counts["bodies"] = n
+		return counts
+	}
+
+	before := countRows()
+	preview, err := store.PreviewRetention(ctx)
+	if err != nil {
+		t.Fatalf("PreviewRetention failed: %v", err)
+	}
+	if got := countRows(); fmt.Sprint(got) != fmt.Sprint(before) {
+		t.Fatalf("PreviewRetention changed the data: %v -> %v", before, got)
+	}
+
+	if _, err := store.RunRetention(ctx); err != nil {
+		t.Fatalf("RunRetention failed: %v", err)
+	}
+	after := countRows()
+
+	checks := []struct {
+		name    string
+		preview int64
+		removed int64
+		want    int64
+	}{
+		{"flows", preview.Flows, before["flows"] - after["flows"], 1},
+		{"events", preview.Events, before["events"] - after["events"], 3},
+		{"tool_invocations", preview.ToolCalls, before["tool_invocations"] - after["tool_invocations"], 1},
+		{"tunnels", preview.Tunnels, before["tunnels"] - after["tunnels"], 1},
+		{"drop_log", preview.DropLog, before["drop_log"] - after["drop_log"], 1},
+		// The expired flow's bodies go with the flow, flow-old's are stripped
+		{"bodies", preview.BodiesStripped + preview.Flows, before["bodies"] - after["bodies"], 2},
+	}
+	for _, c := range checks {
+		if c.preview != c.removed || c.removed != c.want {
+			t.Errorf("%s: preview %d, RunRetention removed %d, want %d", c.name, c.preview, c.removed, c.want)
 		}
-	})
+	}
+	if preview.ReclaimableBytes < int64(4*len(body)) {
+		t.Errorf("ReclaimableBytes = %d, want at least the %d body bytes", preview.ReclaimableBytes, 4*len(body))
+	}
 }
 
-func TestLogDrop(t *testing.T) {
+func TestRunRetention_StripsBodiesKeepsMetadata(t *testing.T) {
 	t.Parallel()
-	store := setupTestDB(t)
+	store, err := NewSQLiteStore(":memory:", &config.RetentionConfig{
+		FlowsTTLDays:   30,
+		EventsTTLDays:  7,
+		BodiesTTLDays:  3,
+		DropLogTTLDays: 7,
+	})
+	if err != nil {
+		t.Fatalf("failed to create test store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
 	ctx := context.Background()
 
-	flowID := "flow-drop-1"
-	eventType := "content_block_delta"
-
-	entry := &DropLogEntry{
-		FlowID:    &flowID,
-		EventType: &eventType,
-		Priority:  "low",
-		Reason:    "queue full",
+	body := `{"messages":[]}`
+	tokens := 1200
+	cost := 0.42
+	newFlow := func(id string, age time.Duration) *Flow {
+		ts := time.Now().Add(-age)
+		expires := ts.AddDate(0, 0, 30)
+		return &Flow{
+			ID:            id,
+			Host:          "api.anthropic.com",
+			Method:        "POST",
+			Path:          "/v1/messages",
+			Timestamp:     ts,
+			FlowIntegrity: "complete",
+			Provider:      "anthropic",
+			RequestBody:   &body,
+			ResponseBody:  &body,
+			InputTokens:   &tokens,
+			TotalCost:     &cost,
+			ExpiresAt:     &expires,
+		}
+	}
+	for _, f := range []*Flow{newFlow("flow-old", 5*24*time.Hour), newFlow("flow-new", time.Hour)} {
+		if err := store.SaveFlow(ctx, f); err != nil {
+			t.Fatalf("SaveFlow(%s) failed: %v", f.ID, err)
+		}
 	}
 
-	err := store.LogDrop(ctx, entry)
-	if err != nil {
-		t.Fatalf("LogDrop failed: %v", err)
+	if _, err := store.RunRetention(ctx); err != nil {
+		t.Fatalf("RunRetention failed: %v", err)
 	}
 
-	// Verify by direct query
-	var count int
-	err = store.db.QueryRow("SELECT COUNT(*) FROM drop_log").Scan(&count)
+	old, err := store.GetFlow(ctx, "flow-old")
 	if err != nil {
-		t.Fatalf("query failed: %v", err)
+		t.Fatalf("old flow should survive body retention: %v", err)
 	}
-	if count != 1 {
-		t.Errorf("drop_log count = %d, want 1", count)
+	if old.RequestBody != nil || old.ResponseBody != nil {
+		t.Error("bodies of the old flow should be stripped")
+	}
+	if old.InputTokens == nil || *old.InputTokens != tokens {
+		t.Errorf("InputTokens = %v, want %d", old.InputTokens, tokens)
+	}
+	if old.TotalCost == nil || *old.TotalCost != cost {
+		t.Errorf("TotalCost = %v, want %v", old.TotalCost, cost)
+	}
+
+	recent, _ := store.GetFlow(ctx, "flow-new")
+	if recent.RequestBody == nil || recent.ResponseBody == nil {
+		t.Error("bodies of the recent flow should be kept")
 	}
 }
 
-func TestRunRetention(t *testing.T) {
+func TestSaveTunnel_ListTunnels(t *testing.T) {
 	t.Parallel()
 	store := setupTestDB(t)
 	ctx := context.Background()
 
-	// Create expired flow
-	expiredTime := time.Now().Add(-24 * time.Hour)
-	expiredFlow := &Flow{
-		ID:            "flow-expired",
-		Host:          "api.anthropic.com",
-		Method:        "POST",
-		Path:          "/v1/messages",
-		URL:           "https://api.anthropic.com/v1/messages",
-		Timestamp:     expiredTime,
-		TimestampMono: expiredTime.UnixNano(),
-		FlowIntegrity: "complete",
-		Provider:      "anthropic",
-		ExpiresAt:     &expiredTime,
+	base := time.Now().Add(-time.Minute)
+	for i, host := range []string{"github.com:443", "api.anthropic.com:443", "pypi.org:443"} {
+		mode := TunnelModePassthrough
+		if host == "api.anthropic.com:443" {
+			mode = TunnelModeIntercepted
+		}
+		tun := &Tunnel{
+			Host:      host,
+			Mode:      mode,
+			StartedAt: base.Add(time.Duration(i) * time.Second),
+			EndedAt:   base.Add(time.Duration(i)*time.Second + 500*time.Millisecond),
+			BytesUp:   int64(100 * (i + 1)),
+			BytesDown: int64(1000 * (i + 1)),
+		}
+		if err := store.SaveTunnel(ctx, tun); err != nil {
+			t.Fatalf("SaveTunnel(%s) failed: %v", host, err)
+		}
+		if tun.ID == 0 {
+			t.Errorf("SaveTunnel(%s) did not set ID", host)
+		}
 	}
-	if err := store.SaveFlow(ctx, expiredFlow); err != nil {
-		t.Fatalf("SaveFlow failed: %v", err)
+
+	tunnels, err := store.ListTunnels(ctx, 2)
+	if err != nil {
+		t.Fatalf("ListTunnels failed: %v", err)
+	}
+	if len(tunnels) != 2 {
+		t.Fatalf("got %d tunnels, want 2", len(tunnels))
+	}
+	// Newest first
+	if tunnels[0].Host != "pypi.org:443" || tunnels[1].Host != "api.anthropic.com:443" {
+		t.Errorf("order = %s, %s", tunnels[0].Host, tunnels[1].Host)
+	}
+	if tunnels[1].Mode != TunnelModeIntercepted || tunnels[1].BytesUp != 200 || tunnels[1].BytesDown != 2000 {
+		t.Errorf("unexpected tunnel %+v", tunnels[1])
+	}
+	if got := tunnels[0].EndedAt.Sub(tunnels[0].StartedAt); got != 500*time.Millisecond {
+		t.Errorf("duration = %v, want 500ms", got)
 	}
 
-	// Create non-expired flow
-	futureTime := time.Now().Add(7 * 24 * time.Hour)
-	validFlow := &Flow{
-		ID:            "flow-valid",
-		Host:          "api.anthropic.com",
-		Method:        "POST",
-		Path:          "/v1/messages",
-		URL:           "https://api.anthropic.com/v1/messages",
-		Timestamp:     time.Now(),
-		TimestampMono: time.Now().UnixNano(),
-		FlowIntegrity: "complete",
-		Provider:      "anthropic",
-		ExpiresAt:     &futureTime,
+	// Only the two known modes are accepted
+	if err := store.SaveTunnel(ctx, &Tunnel{Host: "x", Mode: "other", StartedAt: base, EndedAt: base}); err == nil {
+		t.Error("expected error for unknown tunnel mode")
 	}
-	if err := store.SaveFlow(ctx, validFlow); err != nil {
-		t.Fatalf("SaveFlow failed: %v", err)
+}
+
+func TestVacuum_ShrinksAfterDeletes(t *testing.T) {
+	t.Parallel()
+	store, dbPath := setupTestDBFile(t)
+	ctx := context.Background()
+
+	// Fill the database with large bodies, then delete them
+	body := strings.Repeat("x", 64*1024)
+	for i := 0; i < 100; i++ {
+		flow := &Flow{
+			ID:            fmt.Sprintf("flow-vacuum-%d", i),
+			Host:          "api.anthropic.com",
+			Method:        "POST",
+			Path:          "/v1/messages",
+			URL:           "https://api.anthropic.com/v1/messages",
+			Timestamp:     time.Now(),
+			TimestampMono: time.Now().UnixNano(),
+			FlowIntegrity: "complete",
+			Provider:      "anthropic",
+			RequestBody:   &body,
+		}
+		if err := store.SaveFlow(ctx, flow); err != nil {
+			t.Fatalf("SaveFlow failed: %v", err)
+		}
+	}
+	if _, err := store.db.ExecContext(ctx, "DELETE FROM flows"); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if _, err := store.db.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		t.Fatalf("checkpoint failed: %v", err)
+	}
+	fileBefore, err := os.Stat(dbPath)
+	if err != nil {
+		t.Fatalf("stat failed: %v", err)
 	}
 
-	// Run retention
-	deleted, err := store.RunRetention(ctx)
+	sizeBefore, sizeAfter, err := store.Vacuum(ctx)
 	if err != nil {
-		t.Fatalf("RunRetention failed: %v", err)
+		t.Fatalf("Vacuum failed: %v", err)
 	}
-	if deleted < 1 {
-		t.Errorf("deleted = %d, want >= 1", deleted)
+	if sizeAfter >= sizeBefore {
+		t.Errorf("size after vacuum = %d, want < %d", sizeAfter, sizeBefore)
 	}
 
-	// Verify expired flow deleted
-	_, err = store.GetFlow(ctx, "flow-expired")
-	if err != sql.ErrNoRows {
-		t.Errorf("expected expired flow to be deleted, got err: %v", err)
+	fileAfter, err := os.Stat(dbPath)
+	if err != nil {
+		t.Fatalf("stat failed: %v", err)
+	}
+	if fileAfter.Size() >= fileBefore.Size() {
+		t.Errorf("db file size after vacuum = %d, want < %d", fileAfter.Size(), fileBefore.Size())
 	}
 
-	// Verify valid flow still exists
-	got, err := store.GetFlow(ctx, "flow-valid")
-	if err != nil {
-		t.Errorf("expected valid flow to exist, got err: %v", err)
+	// Store remains usable afterwards
+	flow := &Flow{
+		ID:            "flow-after-vacuum",
+		Host:          "api.anthropic.com",
+		Method:        "POST",
+		Path:          "/v1/messages",
+		URL:           "https://api.anthropic.com/v1/messages",
+		Timestamp:     time.Now(),
+		FlowIntegrity: "complete",
+		Provider:      "anthropic",
 	}
-	if got == nil {
-		t.Error("valid flow should still exist")
+	if err := store.SaveFlow(ctx, flow); err != nil {
+		t.Fatalf("SaveFlow after vacuum failed: %v", err)
 	}
 }
 
@@ -892,6 +2215,280 @@ func TestMigrationIdempotent(t *testing.T) {
 	}
 }
 
+func TestMigrationV15_RebuildKeepsData(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	dbPath := filepath.Join(t.TempDir(), "v14.db")
+
+	// A database at version 14 holding a flow with an event and a tag
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE schema_version (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			version INTEGER NOT NULL,
+			applied_at TEXT NOT NULL DEFAULT (datetime('now')),
+			lock_holder TEXT
+		);
+		INSERT INTO schema_version (id, version) VALUES (1, 14);
+	`); err != nil {
+		t.Fatalf("seeding schema_version: %v", err)
+	}
+	for i, m := range []string{migrationV1, migrationV2, migrationV3, migrationV4, migrationV5, migrationV6, migrationV7,
+		migrationV8, migrationV9, migrationV10, migrationV11, migrationV12, migrationV13, migrationV14} {
+		if _, err := db.Exec(m); err != nil {
+			t.Fatalf("migration %d: %v", i+1, err)
+		}
+	}
+	if _, err := db.Exec(`
+		INSERT INTO flows (id, host, method, path, url, timestamp, provider, model, is_websocket)
+		VALUES ('f1', 'api.anthropic.com', 'POST', '/v1/messages', 'https://api.anthropic.com/v1/messages', '2025-01-01T00:00:00Z', 'anthropic', 'claude-sonnet-4', 1);
+		INSERT INTO events (id, flow_id, sequence, timestamp, timestamp_mono, event_type) VALUES ('e1', 'f1', 1, '2025-01-01T00:00:00Z', 0, 'ping');
+		INSERT INTO flow_tags (flow_id, key, value) VALUES ('f1', 'env', 'prod');
+	`); err != nil {
+		t.Fatalf("seeding flow: %v", err)
+	}
+	db.Close()
+
+	s, err := NewSQLiteStore(dbPath, testRetention())
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer s.Close()
+
+	flow, err := s.GetFlow(ctx, "f1")
+	if err != nil || flow.Model == nil || *flow.Model != "claude-sonnet-4" || !flow.IsWebSocket {
+		t.Fatalf("flow after rebuild = %+v, %v", flow, err)
+	}
+	events, _ := s.GetEventsByFlow(ctx, "f1")
+	tags, _ := s.ListFlowTags(ctx, "f1")
+	if len(events) != 1 || len(tags) != 1 {
+		t.Errorf("after rebuild: %d events, %d tags; want 1 each", len(events), len(tags))
+	}
+
+	local := "local"
+	cost := 0.0
+	ollama := &Flow{ID: "f2", Host: "localhost:11434", Method: "POST", Path: "/api/chat", URL: "http://localhost:11434/api/chat",
+		Timestamp: time.Now(), Provider: "ollama", TotalCost: &cost, CostSource: &local, FlowIntegrity: "complete"}
+	if err := s.SaveFlow(ctx, ollama); err != nil {
+		t.Errorf("SaveFlow(ollama): %v", err)
+	}
+
+	// Foreign keys still cascade on the rebuilt table
+	if err := s.DeleteFlow(ctx, "f1"); err != nil {
+		t.Fatalf("DeleteFlow: %v", err)
+	}
+	if events, _ := s.GetEventsByFlow(ctx, "f1"); len(events) != 0 {
+		t.Errorf("%d events left after DeleteFlow", len(events))
+	}
+	var indexes int
+	_ = s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND tbl_name = 'flows' AND name LIKE 'idx_%'`).Scan(&indexes)
+	if indexes != 9 {
+		t.Errorf("flows has %d indexes after rebuild, want 9", indexes)
+	}
+}
+
+func TestMigrationV19_AllowsManualCost(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	dbPath := filepath.Join(t.TempDir(), "v18.db")
+
+	// A database at version 18 holding a flow with notes, an event and a tool call
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE schema_version (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			version INTEGER NOT NULL,
+			applied_at TEXT NOT NULL DEFAULT (datetime('now')),
+			lock_holder TEXT
+		);
+		INSERT INTO schema_version (id, version) VALUES (1, 18);
+	`); err != nil {
+		t.Fatalf("seeding schema_version: %v", err)
+	}
+	for i, m := range []string{migrationV1, migrationV2, migrationV3, migrationV4, migrationV5, migrationV6, migrationV7,
+		migrationV8, migrationV9, migrationV10, migrationV11, migrationV12, migrationV13, migrationV14, migrationV15,
+		migrationV16, migrationV17, migrationV18} {
+		if _, err := db.Exec(m); err != nil {
+			t.Fatalf("migration %d: %v", i+1, err)
+		}
+	}
+	if _, err := db.Exec(`
+		INSERT INTO flows (id, host, method, path, url, timestamp, provider, notes, stream_mismatch)
+		VALUES ('f1', 'api.anthropic.com', 'POST', '/v1/messages', 'https://api.anthropic.com/v1/messages', '2025-01-01T00:00:00Z', 'anthropic', 'retry storm', 1);
+		INSERT INTO events (id, flow_id, sequence, timestamp, timestamp_mono, event_type) VALUES ('e1', 'f1', 1, '2025-01-01T00:00:00Z', 0, 'ping');
+		INSERT INTO tool_invocations (id, flow_id, tool_name, timestamp) VALUES ('t1', 'f1', 'Read', '2025-01-01T00:00:00Z');
+	`); err != nil {
+		t.Fatalf("seeding flow: %v", err)
+	}
+	db.Close()
+
+	s, err := NewSQLiteStore(dbPath, testRetention())
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer s.Close()
+
+	flow, err := s.GetFlow(ctx, "f1")
+	if err != nil || flow.Notes == nil || *flow.Notes != "retry storm" || !flow.StreamMismatch {
+		t.Fatalf("flow after rebuild = %+v, %v", flow, err)
+	}
+	events, _ := s.GetEventsByFlow(ctx, "f1")
+	tools, _ := s.GetToolInvocationsByFlow(ctx, "f1")
+	if len(events) != 1 || len(tools) != 1 {
+		t.Errorf("after rebuild: %d events, %d tool invocations; want 1 each", len(events), len(tools))
+	}
+	var indexes int
+	_ = s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND tbl_name = 'flows' AND name LIKE 'idx_%'`).Scan(&indexes)
+	if indexes != 9 {
+		t.Errorf("flows has %d indexes after rebuild, want 9", indexes)
+	}
+
+	manual := "manual"
+	cost := 0.42
+	priced := &Flow{ID: "f2", Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages", URL: "https://api.anthropic.com/v1/messages",
+		Timestamp: time.Now(), Provider: "anthropic", TotalCost: &cost, CostSource: &manual, FlowIntegrity: "complete"}
+	if err := s.SaveFlow(ctx, priced); err != nil {
+		t.Errorf("SaveFlow(manual cost): %v", err)
+	}
+}
+
+// seedMigrationLock creates an unmigrated database whose migration lock is
+// held by holder, as if another instance were mid-migration.
+func seedMigrationLock(t *testing.T, holder string) string {
+	t.Helper()
+	dbPath := filepath.Join(t.TempDir(), "locked.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`
+		CREATE TABLE schema_version (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			version INTEGER NOT NULL,
+			applied_at TEXT NOT NULL DEFAULT (datetime('now')),
+			lock_holder TEXT
+		);
+		INSERT INTO schema_version (id, version, lock_holder) VALUES (1, 0, ?);
+	`, holder); err != nil {
+		t.Fatalf("seeding schema_version: %v", err)
+	}
+	return dbPath
+}
+
+// withMigrationLockTimeout shortens the migration lock wait for a test.
+// Tests using it must not run in parallel.
+func withMigrationLockTimeout(t *testing.T, d time.Duration) {
+	t.Helper()
+	prev := migrationLockTimeout
+	migrationLockTimeout = d
+	t.Cleanup(func() { migrationLockTimeout = prev })
+}
+
+func lockHolder(t *testing.T, s *SQLiteStore) sql.NullString {
+	t.Helper()
+	var holder sql.NullString
+	if err := s.db.QueryRow("SELECT lock_holder FROM schema_version WHERE id = 1").Scan(&holder); err != nil {
+		t.Fatalf("reading lock_holder: %v", err)
+	}
+	return holder
+}
+
+func TestMigrationLock_HeldByOtherInstance(t *testing.T) {
+	withMigrationLockTimeout(t, 300*time.Millisecond)
+
+	dbPath := seedMigrationLock(t, formatLockHolder("other-host:4242:abcd1234", time.Now()))
+
+	start := time.Now()
+	s, err := NewSQLiteStore(dbPath, testRetention())
+	if err == nil {
+		s.Close()
+		t.Fatal("expected NewSQLiteStore to fail while another instance holds the migration lock")
+	}
+	if !errors.Is(err, ErrMigrationLocked) {
+		t.Errorf("error = %v, want ErrMigrationLocked", err)
+	}
+	if !strings.Contains(err.Error(), "other-host:4242:abcd1234") {
+		t.Errorf("error should name the holder: %v", err)
+	}
+	if waited := time.Since(start); waited < 300*time.Millisecond {
+		t.Errorf("gave up after %v, want to wait for the timeout", waited)
+	}
+}
+
+func TestMigrationLock_WaitsForRelease(t *testing.T) {
+	withMigrationLockTimeout(t, 5*time.Second)
+
+	holder := formatLockHolder("other-host:4242:abcd1234", time.Now())
+	dbPath := seedMigrationLock(t, holder)
+
+	// The other instance finishes after a short while
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		db, err := sql.Open("sqlite", dbPath)
+		if err != nil {
+			return
+		}
+		defer db.Close()
+		_, _ = db.Exec("UPDATE schema_version SET lock_holder = NULL WHERE id = 1")
+	}()
+
+	s, err := NewSQLiteStore(dbPath, testRetention())
+	if err != nil {
+		t.Fatalf("NewSQLiteStore should succeed once the lock is released: %v", err)
+	}
+	defer s.Close()
+
+	if h := lockHolder(t, s); h.Valid {
+		t.Errorf("lock_holder = %q after migrating, want NULL", h.String)
+	}
+	var version int
+	_ = s.db.QueryRow("SELECT version FROM schema_version WHERE id = 1").Scan(&version)
+	if version == 0 {
+		t.Error("migrations did not run")
+	}
+}
+
+func TestMigrationLock_TakesOverStaleHolder(t *testing.T) {
+	withMigrationLockTimeout(t, 300*time.Millisecond)
+
+	// A holder that crashed mid-migration long ago
+	dbPath := seedMigrationLock(t, formatLockHolder("crashed:1:deadbeef", time.Now().Add(-time.Hour)))
+
+	s, err := NewSQLiteStore(dbPath, testRetention())
+	if err != nil {
+		t.Fatalf("NewSQLiteStore should take over a stale lock: %v", err)
+	}
+	defer s.Close()
+
+	if h := lockHolder(t, s); h.Valid {
+		t.Errorf("lock_holder = %q after migrating, want NULL", h.String)
+	}
+}
+
+func TestMigrationLock_NotTakenWhenUpToDate(t *testing.T) {
+	s, dbPath := setupTestDBFile(t)
+
+	// A recent holder on an up-to-date database doesn't block opening it
+	holder := formatLockHolder("other-host:4242:abcd1234", time.Now())
+	if _, err := s.db.Exec("UPDATE schema_version SET lock_holder = ? WHERE id = 1", holder); err != nil {
+		t.Fatalf("setting lock_holder: %v", err)
+	}
+
+	withMigrationLockTimeout(t, 100*time.Millisecond)
+	s2, err := NewSQLiteStore(dbPath, testRetention())
+	if err != nil {
+		t.Fatalf("opening an up-to-date database should not need the lock: %v", err)
+	}
+	s2.Close()
+}
+
 func TestNullableFields(t *testing.T) {
 	t.Parallel()
 	store := setupTestDB(t)
@@ -1079,3 +2676,79 @@ func TestConcurrentAccess(t *testing.T) {
 		t.Errorf("len(flows) = %d, want %d", len(flows), expected)
 	}
 }
+
+func TestPurgeAll(t *testing.T) {
+	t.Parallel()
+	store := setupTestDB(t)
+	ctx := context.Background()
+
+	var pricingBefore int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM pricing").Scan(&pricingBefore); err != nil {
+		t.Fatalf("counting pricing: %v", err)
+	}
+
+	flow := &Flow{ID: "flow-purge", Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages",
+		Timestamp: time.Now(), FlowIntegrity: "complete", Provider: "anthropic"}
+	if err := store.SaveFlow(ctx, flow); err != nil {
+		t.Fatalf("SaveFlow failed: %v", err)
+	}
+	if err := store.SaveEvent(ctx, &Event{ID: "evt-purge", FlowID: flow.ID, Sequence: 1,
+		Timestamp: time.Now(), EventType: "message_start", Priority: "high"}); err != nil {
+		t.Fatalf("SaveEvent failed: %v", err)
+	}
+	if err := store.SaveToolInvocation(ctx, &ToolInvocation{ID: "tool-purge", FlowID: flow.ID,
+		ToolName: "Read", Timestamp: time.Now()}); err != nil {
+		t.Fatalf("SaveToolInvocation failed: %v", err)
+	}
+	if err := store.AddFlowTag(ctx, flow.ID, "bug", "1234"); err != nil {
+		t.Fatalf("AddFlowTag failed: %v", err)
+	}
+	if err := store.LogDrop(ctx, &DropLogEntry{Priority: "low", Reason: "queue full"}); err != nil {
+		t.Fatalf("LogDrop failed: %v", err)
+	}
+	if err := store.SaveTunnel(ctx, &Tunnel{Host: "github.com:443", Mode: TunnelModePassthrough,
+		StartedAt: time.Now(), EndedAt: time.Now()}); err != nil {
+		t.Fatalf("SaveTunnel failed: %v", err)
+	}
+
+	if err := store.SetTaskRetention(ctx, "task-purge", 30); err != nil {
+		t.Fatalf("SetTaskRetention failed: %v", err)
+	}
+
+	deleted, err := store.PurgeAll(ctx)
+	if err != nil {
+		t.Fatalf("PurgeAll failed: %v", err)
+	}
+	if deleted != 7 {
+		t.Errorf("deleted = %d, want 7", deleted)
+	}
+
+	for _, table := range purgeTables {
+		var n int
+		if err := store.db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+			t.Fatalf("counting %s: %v", table, err)
+		}
+		if n != 0 {
+			t.Errorf("%s has %d rows after purge, want 0", table, n)
+		}
+	}
+
+	var pricingAfter, version int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM pricing").Scan(&pricingAfter); err != nil {
+		t.Fatalf("counting pricing: %v", err)
+	}
+	if pricingAfter != pricingBefore || pricingAfter == 0 {
+		t.Errorf("pricing rows = %d, want %d", pricingAfter, pricingBefore)
+	}
+	if err := store.db.QueryRow("SELECT version FROM schema_version WHERE id = 1").Scan(&version); err != nil {
+		t.Fatalf("reading schema version: %v", err)
+	}
+	if version == 0 {
+		t.Error("schema version reset by purge")
+	}
+
+	// The store keeps working afterwards
+	if err := store.SaveFlow(ctx, flow); err != nil {
+		t.Errorf("SaveFlow after purge failed: %v", err)
+	}
+}
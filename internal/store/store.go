@@ -21,13 +21,18 @@ type Flow struct {
 	StatusCode            *int
 	StatusText            *string
 	IsSSE                 bool
+	IsWebSocket           bool   // Upgraded to a WebSocket; frames are stored as events
 	FlowIntegrity         string // 'complete', 'partial', 'corrupted', 'interrupted'
 	EventsDroppedCount    int
+	EventsSkippedCount    int // Deltas not stored because of parser.store_deltas
 	RequestBody           *string
 	RequestBodyTruncated  bool
+	RequestBodyInvalid    bool // Content-Type was JSON but the body didn't parse (proxy.validate_request_json)
+	StreamMismatch        bool // Request's "stream" field disagreed with whether the response streamed
 	ResponseBody          *string
 	ResponseBodyTruncated bool
 	RequestHeaders        map[string][]string
+	RequestHeaderOrder    []string // Header names in the order the client sent them (nil if unknown)
 	ResponseHeaders       map[string][]string
 	RequestSignature      *string
 	InputTokens           *int
@@ -35,11 +40,52 @@ type Flow struct {
 	CacheCreationTokens   *int
 	CacheReadTokens       *int
 	TotalCost             *float64
-	CostSource            *string // 'exact', 'estimated'
+	CostSource            *string // 'exact', 'estimated', 'local', 'manual'
 	Model                 *string
 	Provider              string // 'anthropic', 'bedrock', 'other'
 	CreatedAt             time.Time
 	ExpiresAt             *time.Time
+
+	// Normalized rate-limit response headers (nil if not reported)
+	RateLimitRequestsLimit     *int64
+	RateLimitRequestsRemaining *int64
+	RateLimitTokensLimit       *int64
+	RateLimitTokensRemaining   *int64
+	RateLimitReset             *time.Time
+
+	// Prompt-cache breakpoints (cache_control markers) in the request body,
+	// nil if not captured. Positions are paths like "messages[2].content[0]".
+	CacheBreakpoints         *int
+	CacheBreakpointPositions []string
+
+	// Why generation stopped (e.g. "end_turn", "max_tokens", "tool_use") and,
+	// for API errors, the provider's error type and message
+	StopReason   *string
+	ErrorType    *string
+	ErrorMessage *string
+
+	// Triage notes, set only through SetFlowNotes; saving or updating a
+	// flow leaves them alone
+	Notes *string
+
+	// Full request and response body lengths as transferred, even when the
+	// stored bodies are truncated (nil if not recorded)
+	RequestBodySize  *int64
+	ResponseBodySize *int64
+
+	// Computed when the flow is read, not stored: the number of saved events
+	// and the bytes of request and response body kept
+	EventCount      int
+	StoredBodyBytes int64
+}
+
+// FlowTag is a user-defined label on a flow, e.g. "bug-1234" or "env=prod".
+// Value may be empty for plain labels.
+type FlowTag struct {
+	FlowID    string
+	Key       string
+	Value     string
+	CreatedAt time.Time
 }
 
 // Event represents an SSE event.
@@ -87,16 +133,103 @@ type DropLogEntry struct {
 	Timestamp time.Time
 }
 
+// Tunnel modes.
+const (
+	TunnelModePassthrough = "passthrough"
+	TunnelModeIntercepted = "intercepted"
+)
+
+// Tunnel records a CONNECT tunnel, intercepted or passed through.
+// Bytes are counted on the client side of the tunnel, so for intercepted
+// tunnels they include TLS overhead.
+type Tunnel struct {
+	ID        int64
+	Host      string
+	Mode      string // TunnelModePassthrough or TunnelModeIntercepted
+	StartedAt time.Time
+	EndedAt   time.Time
+	BytesUp   int64 // client -> upstream
+	BytesDown int64 // upstream -> client
+}
+
+// RetentionPreview counts what RunRetention would remove right now.
+type RetentionPreview struct {
+	Flows          int64 // Expired flows
+	Events         int64 // Events of expired flows plus expired events
+	ToolCalls      int64 // Tool invocations of expired flows
+	BodiesStripped int64 // Surviving flows whose bodies would be stripped
+	Tunnels        int64
+	DropLog        int64
+	// ReclaimableBytes estimates the stored payload freed: bodies, headers
+	// and event data. Row and index overhead isn't counted, and the file only
+	// shrinks after a VACUUM.
+	ReclaimableBytes int64
+}
+
+// Capture health statuses, matching the /api/health status values.
+const (
+	CaptureStatusOK       = "ok"
+	CaptureStatusDegraded = "degraded"
+	CaptureStatusError    = "error"
+)
+
+// CaptureHealth is a snapshot of recent flow persistence outcomes.
+type CaptureHealth struct {
+	Status        string     `json:"status"`
+	FailureRate   float64    `json:"failure_rate"` // Over the last Samples writes
+	Samples       int        `json:"samples"`
+	Failures      int        `json:"failures"`
+	TotalFailures int64      `json:"total_failures"` // Since startup
+	LastError     string     `json:"last_error,omitempty"`
+	LastFailureAt *time.Time `json:"last_failure_at,omitempty"`
+}
+
+// FlowFacets lists the distinct values of the fields flows are filtered
+// by, each with how many flows have it.
+type FlowFacets struct {
+	Hosts     []*FacetValue // Most flows first
+	Models    []*FacetValue // Most flows first; flows without a model aren't listed
+	Providers []*FacetValue // Most flows first
+	Tasks     []*FacetValue // Most recently active first
+}
+
+// FacetValue is one distinct value of a flow field.
+type FacetValue struct {
+	Value    string
+	Count    int64
+	LastSeen time.Time // Timestamp of the newest flow with the value
+}
+
 // FlowFilter defines filter criteria for flow queries.
 type FlowFilter struct {
 	Host       *string
 	TaskID     *string
 	TaskSource *string
 	Model      *string
+	StopReason *string
+	Provider   *string
+	StatusMin  *int // Inclusive; flows without a status are excluded
+	StatusMax  *int // Inclusive; flows without a status are excluded
 	StartTime  *time.Time
 	EndTime    *time.Time
-	Limit      int
-	Offset     int
+	Tag        *string // "key" matches any value, "key=value" matches exactly
+	SortByCost bool    // Most expensive first; flows without a cost are excluded
+	// CompletedAfter keeps flows that finished after it, whenever they
+	// started; flows still in progress are excluded
+	CompletedAfter *time.Time
+	// After continues a newest-first listing past the flow it names: only
+	// flows older than it, or as old with a smaller ID, are returned. Unlike
+	// Offset it neither skips nor repeats flows saved between pages. Not
+	// used with SortByCost.
+	After  *FlowCursor
+	Limit  int
+	Offset int
+}
+
+// FlowCursor is a position in the newest-first flow order.
+type FlowCursor struct {
+	Timestamp time.Time
+	ID        string // Empty to continue after every flow at Timestamp
 }
 
 // Store defines the interface for data persistence.
@@ -109,11 +242,19 @@ type Store interface {
 	ListFlows(ctx context.Context, filter FlowFilter) ([]*Flow, error)
 	CountFlows(ctx context.Context, filter FlowFilter) (int, error)
 	DeleteFlow(ctx context.Context, id string) error
+	SetFlowNotes(ctx context.Context, flowID string, notes *string) error
+	ListFlowFacets(ctx context.Context, taskLimit int) (*FlowFacets, error)
+
+	// Flow Tags
+	AddFlowTag(ctx context.Context, flowID, key, value string) error
+	ListFlowTags(ctx context.Context, flowID string) ([]*FlowTag, error)
+	DeleteFlowTag(ctx context.Context, flowID, key string) error
 
 	// Events
 	SaveEvent(ctx context.Context, event *Event) error
 	SaveEvents(ctx context.Context, events []*Event) error
 	GetEventsByFlow(ctx context.Context, flowID string) ([]*Event, error)
+	GetEventsByFlowLimit(ctx context.Context, flowID string, limit int) ([]*Event, error)
 
 	// Tool Invocations
 	SaveToolInvocation(ctx context.Context, inv *ToolInvocation) error
@@ -125,8 +266,19 @@ type Store interface {
 	// Drop Log
 	LogDrop(ctx context.Context, entry *DropLogEntry) error
 
+	// Tunnels
+	SaveTunnel(ctx context.Context, t *Tunnel) error
+	ListTunnels(ctx context.Context, limit int) ([]*Tunnel, error)
+
+	// Task Retention
+	SetTaskRetention(ctx context.Context, taskID string, ttlDays int) error
+	GetTaskRetention(ctx context.Context, taskID string) (ttlDays int, err error)
+
 	// Maintenance
 	RunRetention(ctx context.Context) (deleted int64, err error)
+	PreviewRetention(ctx context.Context) (*RetentionPreview, error)
+	Vacuum(ctx context.Context) (sizeBefore, sizeAfter int64, err error)
+	PurgeAll(ctx context.Context) (deleted int64, err error)
 	Close() error
 
 	// DB returns the underlying database connection for analytics queries.
@@ -0,0 +1,822 @@
+// Package storetest provides an in-memory store.Store for tests.
+//
+// Store keeps copies of everything saved, so later changes to a flow by the
+// code under test don't show up until it is saved again, as with SQLite. It
+// is safe for concurrent use. Options simulate foreign-key enforcement and
+// failing methods.
+package storetest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HakAl/langley/internal/store"
+)
+
+var _ store.Store = (*Store)(nil)
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithForeignKeys makes events, tool invocations and tags reference an
+// existing flow, failing like SQLite's FOREIGN KEY constraint otherwise.
+func WithForeignKeys() Option {
+	return func(s *Store) { s.foreignKeys = true }
+}
+
+// WithFlows seeds the store with flows, in order.
+func WithFlows(flows ...*store.Flow) Option {
+	return func(s *Store) {
+		for _, f := range flows {
+			s.putFlow(f)
+		}
+	}
+}
+
+// WithError makes the named Store method (e.g. "SaveFlow") return err.
+func WithError(method string, err error) Option {
+	return func(s *Store) { s.errs[method] = err }
+}
+
+// Store is an in-memory store.Store.
+type Store struct {
+	mu          sync.Mutex
+	foreignKeys bool
+	errs        map[string]error
+
+	flows     map[string]*store.Flow
+	flowOrder []string // Flow IDs in insertion order, for stable listing
+	tags      map[string]map[string]*store.FlowTag
+	events    map[string][]*store.Event
+	tools     []*store.ToolInvocation
+	drops     []*store.DropLogEntry
+	tunnels   []*store.Tunnel
+	taskTTL   map[string]int // Task retention overrides in days
+	nextID    int64
+}
+
+// New returns an empty Store configured by opts.
+func New(opts ...Option) *Store {
+	s := &Store{
+		errs:    make(map[string]error),
+		flows:   make(map[string]*store.Flow),
+		tags:    make(map[string]map[string]*store.FlowTag),
+		events:  make(map[string][]*store.Event),
+		taskTTL: make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// SetError makes method return err from now on; a nil err clears it.
+func (s *Store) SetError(method string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		delete(s.errs, method)
+		return
+	}
+	s.errs[method] = err
+}
+
+// DropLog returns the drop log entries recorded so far.
+func (s *Store) DropLog() []*store.DropLogEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.drops)
+}
+
+// fail returns the error configured for method. Callers hold s.mu.
+func (s *Store) fail(method string) error {
+	return s.errs[method]
+}
+
+// checkFlow enforces foreign keys when enabled. Callers hold s.mu.
+func (s *Store) checkFlow(flowID string) error {
+	if !s.foreignKeys {
+		return nil
+	}
+	if _, ok := s.flows[flowID]; !ok {
+		return fmt.Errorf("FOREIGN KEY constraint failed: flow %s does not exist", flowID)
+	}
+	return nil
+}
+
+// putFlow stores a copy of flow. Callers hold s.mu or own s.
+func (s *Store) putFlow(flow *store.Flow) {
+	c := *flow
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = time.Now()
+	}
+	if _, ok := s.flows[c.ID]; !ok {
+		s.flowOrder = append(s.flowOrder, c.ID)
+	}
+	s.flows[c.ID] = &c
+}
+
+// SaveFlow inserts a copy of flow. Saving an existing ID fails, as the
+// primary key would.
+func (s *Store) SaveFlow(ctx context.Context, flow *store.Flow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("SaveFlow"); err != nil {
+		return err
+	}
+	if _, ok := s.flows[flow.ID]; ok {
+		return fmt.Errorf("UNIQUE constraint failed: flows.id %s", flow.ID)
+	}
+	s.putFlow(flow)
+	return nil
+}
+
+// UpdateFlow replaces a saved flow. Updating a missing flow does nothing.
+func (s *Store) UpdateFlow(ctx context.Context, flow *store.Flow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("UpdateFlow"); err != nil {
+		return err
+	}
+	if old, ok := s.flows[flow.ID]; ok {
+		notes := old.Notes
+		s.putFlow(flow)
+		s.flows[flow.ID].Notes = notes // Only SetFlowNotes changes notes
+	}
+	return nil
+}
+
+// GetFlow returns a copy of the flow, or sql.ErrNoRows.
+func (s *Store) GetFlow(ctx context.Context, id string) (*store.Flow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("GetFlow"); err != nil {
+		return nil, err
+	}
+	f, ok := s.flows[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return s.readFlow(f), nil
+}
+
+// readFlow returns a copy of a stored flow with its computed size fields
+// filled in, as the SQLite store reads them. Callers hold s.mu.
+func (s *Store) readFlow(f *store.Flow) *store.Flow {
+	c := *f
+	c.EventCount = len(s.events[f.ID])
+	c.StoredBodyBytes = 0
+	if c.RequestBody != nil {
+		c.StoredBodyBytes += int64(len(*c.RequestBody))
+	}
+	if c.ResponseBody != nil {
+		c.StoredBodyBytes += int64(len(*c.ResponseBody))
+	}
+	return &c
+}
+
+// ListFlows returns copies of the flows matching filter, newest first with
+// ties by descending ID (most expensive first with SortByCost), paginated by
+// After, Limit and Offset.
+func (s *Store) ListFlows(ctx context.Context, filter store.FlowFilter) ([]*store.Flow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("ListFlows"); err != nil {
+		return nil, err
+	}
+	flows := s.matchFlows(filter)
+	if filter.Offset >= len(flows) {
+		return []*store.Flow{}, nil
+	}
+	flows = flows[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(flows) {
+		flows = flows[:filter.Limit]
+	}
+	result := make([]*store.Flow, len(flows))
+	for i, f := range flows {
+		result[i] = s.readFlow(f)
+	}
+	return result, nil
+}
+
+// CountFlows counts the flows matching filter, ignoring Limit and Offset.
+func (s *Store) CountFlows(ctx context.Context, filter store.FlowFilter) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("CountFlows"); err != nil {
+		return 0, err
+	}
+	return len(s.matchFlows(filter)), nil
+}
+
+// matchFlows returns the stored flows matching filter in list order.
+// Callers hold s.mu.
+func (s *Store) matchFlows(filter store.FlowFilter) []*store.Flow {
+	var flows []*store.Flow
+	for _, id := range s.flowOrder {
+		f := s.flows[id]
+		if matchFlow(f, filter, s.tags[id]) {
+			flows = append(flows, f)
+		}
+	}
+	slices.SortStableFunc(flows, func(a, b *store.Flow) int {
+		if filter.SortByCost && *a.TotalCost != *b.TotalCost {
+			if *a.TotalCost > *b.TotalCost {
+				return -1
+			}
+			return 1
+		}
+		if c := b.Timestamp.Compare(a.Timestamp); c != 0 || filter.SortByCost {
+			return c
+		}
+		return strings.Compare(b.ID, a.ID)
+	})
+	return flows
+}
+
+func matchFlow(f *store.Flow, filter store.FlowFilter, tags map[string]*store.FlowTag) bool {
+	if filter.Host != nil && f.Host != *filter.Host {
+		return false
+	}
+	if filter.TaskID != nil && (f.TaskID == nil || *f.TaskID != *filter.TaskID) {
+		return false
+	}
+	if filter.TaskSource != nil && (f.TaskSource == nil || *f.TaskSource != *filter.TaskSource) {
+		return false
+	}
+	if filter.Model != nil && (f.Model == nil || *f.Model != *filter.Model) {
+		return false
+	}
+	if filter.StopReason != nil && (f.StopReason == nil || *f.StopReason != *filter.StopReason) {
+		return false
+	}
+	if filter.Provider != nil && f.Provider != *filter.Provider {
+		return false
+	}
+	if filter.StatusMin != nil && (f.StatusCode == nil || *f.StatusCode < *filter.StatusMin) {
+		return false
+	}
+	if filter.StatusMax != nil && (f.StatusCode == nil || *f.StatusCode > *filter.StatusMax) {
+		return false
+	}
+	if filter.StartTime != nil && f.Timestamp.Before(*filter.StartTime) {
+		return false
+	}
+	if filter.EndTime != nil && f.Timestamp.After(*filter.EndTime) {
+		return false
+	}
+	if filter.CompletedAfter != nil && (f.DurationMs == nil ||
+		!f.Timestamp.Add(time.Duration(*f.DurationMs)*time.Millisecond).After(*filter.CompletedAfter)) {
+		return false
+	}
+	if filter.SortByCost && f.TotalCost == nil {
+		return false
+	}
+	if a := filter.After; a != nil && !filter.SortByCost {
+		if c := f.Timestamp.Compare(a.Timestamp); c > 0 || (c == 0 && f.ID >= a.ID) {
+			return false
+		}
+	}
+	if filter.Tag != nil {
+		key, value, hasValue := strings.Cut(*filter.Tag, "=")
+		tag, ok := tags[key]
+		if !ok || (hasValue && tag.Value != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// DeleteFlow removes a flow with its tags, events and tool invocations.
+func (s *Store) DeleteFlow(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("DeleteFlow"); err != nil {
+		return err
+	}
+	s.deleteFlow(id)
+	return nil
+}
+
+// deleteFlow cascades like the schema's ON DELETE CASCADE. Callers hold s.mu.
+func (s *Store) deleteFlow(id string) {
+	delete(s.flows, id)
+	delete(s.tags, id)
+	delete(s.events, id)
+	s.flowOrder = slices.DeleteFunc(s.flowOrder, func(fid string) bool { return fid == id })
+	s.tools = slices.DeleteFunc(s.tools, func(inv *store.ToolInvocation) bool { return inv.FlowID == id })
+}
+
+// SetFlowNotes sets a flow's notes; nil clears them. It returns
+// sql.ErrNoRows if the flow doesn't exist.
+func (s *Store) SetFlowNotes(ctx context.Context, flowID string, notes *string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("SetFlowNotes"); err != nil {
+		return err
+	}
+	f, ok := s.flows[flowID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if notes != nil {
+		n := *notes
+		notes = &n
+	}
+	f.Notes = notes
+	return nil
+}
+
+// ListFlowFacets returns the distinct hosts, models and providers of the
+// stored flows, and the taskLimit most recently active tasks (negative for
+// all), ordered as the SQLite store orders them.
+func (s *Store) ListFlowFacets(ctx context.Context, taskLimit int) (*store.FlowFacets, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("ListFlowFacets"); err != nil {
+		return nil, err
+	}
+	hosts := make(map[string]*store.FacetValue)
+	models := make(map[string]*store.FacetValue)
+	providers := make(map[string]*store.FacetValue)
+	tasks := make(map[string]*store.FacetValue)
+	for _, f := range s.flows {
+		addFacetValue(hosts, f.Host, f.Timestamp)
+		addFacetValue(providers, f.Provider, f.Timestamp)
+		if f.Model != nil {
+			addFacetValue(models, *f.Model, f.Timestamp)
+		}
+		if f.TaskID != nil {
+			addFacetValue(tasks, *f.TaskID, f.Timestamp)
+		}
+	}
+
+	byCount := func(a, b *store.FacetValue) int {
+		if a.Count != b.Count {
+			return int(b.Count - a.Count)
+		}
+		return strings.Compare(a.Value, b.Value)
+	}
+	facets := &store.FlowFacets{
+		Hosts:     sortedFacetValues(hosts, byCount),
+		Models:    sortedFacetValues(models, byCount),
+		Providers: sortedFacetValues(providers, byCount),
+		Tasks: sortedFacetValues(tasks, func(a, b *store.FacetValue) int {
+			if c := b.LastSeen.Compare(a.LastSeen); c != 0 {
+				return c
+			}
+			return strings.Compare(a.Value, b.Value)
+		}),
+	}
+	if taskLimit >= 0 && taskLimit < len(facets.Tasks) {
+		facets.Tasks = facets.Tasks[:taskLimit]
+	}
+	return facets, nil
+}
+
+func addFacetValue(values map[string]*store.FacetValue, value string, ts time.Time) {
+	if value == "" {
+		return
+	}
+	v, ok := values[value]
+	if !ok {
+		v = &store.FacetValue{Value: value}
+		values[value] = v
+	}
+	v.Count++
+	if ts.After(v.LastSeen) {
+		v.LastSeen = ts
+	}
+}
+
+func sortedFacetValues(values map[string]*store.FacetValue, cmp func(a, b *store.FacetValue) int) []*store.FacetValue {
+	out := make([]*store.FacetValue, 0, len(values))
+	for _, v := range values {
+		out = append(out, v)
+	}
+	slices.SortFunc(out, cmp)
+	return out
+}
+
+// AddFlowTag sets a tag on a flow, replacing the value of an existing key.
+func (s *Store) AddFlowTag(ctx context.Context, flowID, key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("AddFlowTag"); err != nil {
+		return err
+	}
+	if err := s.checkFlow(flowID); err != nil {
+		return err
+	}
+	if s.tags[flowID] == nil {
+		s.tags[flowID] = make(map[string]*store.FlowTag)
+	}
+	s.tags[flowID][key] = &store.FlowTag{FlowID: flowID, Key: key, Value: value, CreatedAt: time.Now()}
+	return nil
+}
+
+// ListFlowTags returns a flow's tags ordered by key.
+func (s *Store) ListFlowTags(ctx context.Context, flowID string) ([]*store.FlowTag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("ListFlowTags"); err != nil {
+		return nil, err
+	}
+	tags := []*store.FlowTag{}
+	for _, tag := range s.tags[flowID] {
+		c := *tag
+		tags = append(tags, &c)
+	}
+	slices.SortFunc(tags, func(a, b *store.FlowTag) int { return strings.Compare(a.Key, b.Key) })
+	return tags, nil
+}
+
+// DeleteFlowTag removes a tag. Deleting a missing tag is not an error.
+func (s *Store) DeleteFlowTag(ctx context.Context, flowID, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("DeleteFlowTag"); err != nil {
+		return err
+	}
+	delete(s.tags[flowID], key)
+	return nil
+}
+
+// SaveEvent stores a copy of event.
+func (s *Store) SaveEvent(ctx context.Context, event *store.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("SaveEvent"); err != nil {
+		return err
+	}
+	return s.putEvent(event)
+}
+
+// SaveEvents stores copies of events, all or none.
+func (s *Store) SaveEvents(ctx context.Context, events []*store.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("SaveEvents"); err != nil {
+		return err
+	}
+	for _, e := range events {
+		if err := s.checkFlow(e.FlowID); err != nil {
+			return err
+		}
+	}
+	for _, e := range events {
+		_ = s.putEvent(e)
+	}
+	return nil
+}
+
+// putEvent stores a copy of event. Callers hold s.mu.
+func (s *Store) putEvent(event *store.Event) error {
+	if err := s.checkFlow(event.FlowID); err != nil {
+		return err
+	}
+	c := *event
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = time.Now()
+	}
+	s.events[c.FlowID] = append(s.events[c.FlowID], &c)
+	return nil
+}
+
+// GetEventsByFlow returns copies of a flow's events ordered by sequence.
+func (s *Store) GetEventsByFlow(ctx context.Context, flowID string) ([]*store.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("GetEventsByFlow"); err != nil {
+		return nil, err
+	}
+	events := make([]*store.Event, 0, len(s.events[flowID]))
+	for _, e := range s.events[flowID] {
+		c := *e
+		events = append(events, &c)
+	}
+	slices.SortStableFunc(events, func(a, b *store.Event) int { return a.Sequence - b.Sequence })
+	return events, nil
+}
+
+// GetEventsByFlowLimit returns copies of a flow's first limit events by
+// sequence, or all of them if limit is negative.
+func (s *Store) GetEventsByFlowLimit(ctx context.Context, flowID string, limit int) ([]*store.Event, error) {
+	events, err := s.GetEventsByFlow(ctx, flowID)
+	if err != nil || limit < 0 || limit >= len(events) {
+		return events, err
+	}
+	return events[:limit], nil
+}
+
+// SaveToolInvocation stores a copy of inv.
+func (s *Store) SaveToolInvocation(ctx context.Context, inv *store.ToolInvocation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("SaveToolInvocation"); err != nil {
+		return err
+	}
+	if err := s.checkFlow(inv.FlowID); err != nil {
+		return err
+	}
+	c := *inv
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = time.Now()
+	}
+	s.tools = append(s.tools, &c)
+	return nil
+}
+
+// GetToolInvocationsByFlow returns copies of a flow's tool invocations
+// ordered by timestamp.
+func (s *Store) GetToolInvocationsByFlow(ctx context.Context, flowID string) ([]*store.ToolInvocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("GetToolInvocationsByFlow"); err != nil {
+		return nil, err
+	}
+	invs := []*store.ToolInvocation{}
+	for _, inv := range s.tools {
+		if inv.FlowID == flowID {
+			c := *inv
+			invs = append(invs, &c)
+		}
+	}
+	slices.SortStableFunc(invs, func(a, b *store.ToolInvocation) int { return a.Timestamp.Compare(b.Timestamp) })
+	return invs, nil
+}
+
+// GetToolInvocation returns a copy of the invocation, or sql.ErrNoRows.
+func (s *Store) GetToolInvocation(ctx context.Context, id string) (*store.ToolInvocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("GetToolInvocation"); err != nil {
+		return nil, err
+	}
+	for _, inv := range s.tools {
+		if inv.ID == id {
+			c := *inv
+			return &c, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// ListToolInvocations returns invocations of toolName between start and end
+// (inclusive), newest first, with the total before pagination.
+func (s *Store) ListToolInvocations(ctx context.Context, toolName string, start, end time.Time, limit, offset int) ([]*store.ToolInvocation, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("ListToolInvocations"); err != nil {
+		return nil, 0, err
+	}
+	var matched []*store.ToolInvocation
+	for _, inv := range s.tools {
+		if inv.ToolName == toolName && !inv.Timestamp.Before(start) && !inv.Timestamp.After(end) {
+			matched = append(matched, inv)
+		}
+	}
+	slices.SortStableFunc(matched, func(a, b *store.ToolInvocation) int { return b.Timestamp.Compare(a.Timestamp) })
+	total := len(matched)
+
+	invs := []*store.ToolInvocation{}
+	for i := offset; i < total && len(invs) < limit; i++ {
+		c := *matched[i]
+		invs = append(invs, &c)
+	}
+	return invs, total, nil
+}
+
+// UpdateToolResult records the first result for toolUseID; later results
+// are ignored, as in the SQLite store.
+func (s *Store) UpdateToolResult(ctx context.Context, toolUseID string, success bool, errorMsg *string, resultContent *string, resultTime time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("UpdateToolResult"); err != nil {
+		return err
+	}
+	for _, inv := range s.tools {
+		if inv.ToolUseID == nil || *inv.ToolUseID != toolUseID || inv.DurationMs != nil {
+			continue
+		}
+		duration := max(resultTime.Sub(inv.Timestamp).Milliseconds(), 0)
+		inv.Success = &success
+		inv.ErrorMessage = errorMsg
+		inv.ToolResult = resultContent
+		inv.DurationMs = &duration
+	}
+	return nil
+}
+
+// LogDrop records a dropped event; see DropLog.
+func (s *Store) LogDrop(ctx context.Context, entry *store.DropLogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("LogDrop"); err != nil {
+		return err
+	}
+	s.nextID++
+	c := *entry
+	c.ID = s.nextID
+	if c.Timestamp.IsZero() {
+		c.Timestamp = time.Now()
+	}
+	s.drops = append(s.drops, &c)
+	return nil
+}
+
+// SaveTunnel records a tunnel and sets its ID.
+func (s *Store) SaveTunnel(ctx context.Context, t *store.Tunnel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("SaveTunnel"); err != nil {
+		return err
+	}
+	s.nextID++
+	t.ID = s.nextID
+	c := *t
+	s.tunnels = append(s.tunnels, &c)
+	return nil
+}
+
+// SetTaskRetention overrides how many days a task's flows are kept;
+// ttlDays <= 0 removes the override. Unlike the SQLite store it doesn't
+// rewrite the flows' ExpiresAt; RunRetention consults the override instead.
+func (s *Store) SetTaskRetention(ctx context.Context, taskID string, ttlDays int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("SetTaskRetention"); err != nil {
+		return err
+	}
+	if ttlDays > 0 {
+		s.taskTTL[taskID] = ttlDays
+	} else {
+		delete(s.taskTTL, taskID)
+	}
+	return nil
+}
+
+// GetTaskRetention returns a task's retention override in days, or 0 if
+// it has none.
+func (s *Store) GetTaskRetention(ctx context.Context, taskID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("GetTaskRetention"); err != nil {
+		return 0, err
+	}
+	return s.taskTTL[taskID], nil
+}
+
+// ListTunnels returns up to limit tunnels, most recently started first.
+func (s *Store) ListTunnels(ctx context.Context, limit int) ([]*store.Tunnel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("ListTunnels"); err != nil {
+		return nil, err
+	}
+	tunnels := make([]*store.Tunnel, 0, len(s.tunnels))
+	for _, t := range s.tunnels {
+		c := *t
+		tunnels = append(tunnels, &c)
+	}
+	slices.SortStableFunc(tunnels, func(a, b *store.Tunnel) int {
+		if c := b.StartedAt.Compare(a.StartedAt); c != 0 {
+			return c
+		}
+		return int(b.ID - a.ID)
+	})
+	if limit >= 0 && limit < len(tunnels) {
+		tunnels = tunnels[:limit]
+	}
+	return tunnels, nil
+}
+
+// RunRetention deletes flows and events whose ExpiresAt, or task retention
+// override, has passed; a task's events are kept while it has an override.
+// Unlike the SQLite store it doesn't apply the configured TTLs to bodies,
+// tunnels or the drop log.
+func (s *Store) RunRetention(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("RunRetention"); err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	var deleted int64
+	for _, id := range slices.Clone(s.flowOrder) {
+		if s.flowExpired(s.flows[id], now) {
+			deleted += 1 + int64(len(s.events[id]))
+			s.deleteFlow(id)
+		}
+	}
+	for id, events := range s.events {
+		if s.hasTaskTTL(s.flows[id]) {
+			continue
+		}
+		kept := slices.DeleteFunc(events, func(e *store.Event) bool { return expired(e.ExpiresAt, now) })
+		deleted += int64(len(events) - len(kept))
+		s.events[id] = kept
+	}
+	return deleted, nil
+}
+
+// PreviewRetention counts what RunRetention would delete.
+func (s *Store) PreviewRetention(ctx context.Context) (*store.RetentionPreview, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("PreviewRetention"); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	preview := &store.RetentionPreview{}
+	for id, f := range s.flows {
+		flowExpired := s.flowExpired(f, now)
+		if flowExpired {
+			preview.Flows++
+			for _, inv := range s.tools {
+				if inv.FlowID == id {
+					preview.ToolCalls++
+				}
+			}
+		}
+		for _, e := range s.events[id] {
+			if flowExpired || (!s.hasTaskTTL(f) && expired(e.ExpiresAt, now)) {
+				preview.Events++
+			}
+		}
+	}
+	return preview, nil
+}
+
+// flowExpired reports whether f is past its task's retention override, or
+// its ExpiresAt if the task has none. Callers hold s.mu.
+func (s *Store) flowExpired(f *store.Flow, now time.Time) bool {
+	if f.TaskID != nil {
+		if days, ok := s.taskTTL[*f.TaskID]; ok {
+			return f.Timestamp.AddDate(0, 0, days).Before(now)
+		}
+	}
+	return expired(f.ExpiresAt, now)
+}
+
+// hasTaskTTL reports whether f's task has a retention override. Callers
+// hold s.mu.
+func (s *Store) hasTaskTTL(f *store.Flow) bool {
+	if f == nil || f.TaskID == nil {
+		return false
+	}
+	_, ok := s.taskTTL[*f.TaskID]
+	return ok
+}
+
+func expired(expiresAt *time.Time, now time.Time) bool {
+	return expiresAt != nil && expiresAt.Before(now)
+}
+
+// Vacuum does nothing; there is no file to compact.
+func (s *Store) Vacuum(ctx context.Context) (int64, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return 0, 0, s.fail("Vacuum")
+}
+
+// PurgeAll deletes all captured data and returns the number of rows removed.
+func (s *Store) PurgeAll(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail("PurgeAll"); err != nil {
+		return 0, err
+	}
+	deleted := int64(len(s.flows) + len(s.tools) + len(s.drops) + len(s.tunnels) + len(s.taskTTL))
+	for _, events := range s.events {
+		deleted += int64(len(events))
+	}
+	for _, tags := range s.tags {
+		deleted += int64(len(tags))
+	}
+	s.flows = make(map[string]*store.Flow)
+	s.flowOrder = nil
+	s.tags = make(map[string]map[string]*store.FlowTag)
+	s.events = make(map[string][]*store.Event)
+	s.tools, s.drops, s.tunnels = nil, nil, nil
+	s.taskTTL = make(map[string]int)
+	return deleted, nil
+}
+
+// Close does nothing.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fail("Close")
+}
+
+// DB returns nil: there is no database behind the store, so analytics
+// queries that need one must use a SQLite store.
+func (s *Store) DB() interface{} {
+	return nil
+}
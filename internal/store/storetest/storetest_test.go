@@ -0,0 +1,295 @@
+package storetest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HakAl/langley/internal/config"
+	"github.com/HakAl/langley/internal/store"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+// stores returns a fresh Store and SQLite store, so behavior the mock
+// promises can be checked against the real thing.
+func stores(t *testing.T) map[string]store.Store {
+	t.Helper()
+	sqlite, err := store.NewSQLiteStore(":memory:", &config.RetentionConfig{FlowsTTLDays: 7})
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+	return map[string]store.Store{
+		"storetest": New(WithForeignKeys()),
+		"sqlite":    sqlite,
+	}
+}
+
+func TestStore_MatchesSQLite(t *testing.T) {
+	base := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	flows := []*store.Flow{
+		{ID: "a", Host: "api.anthropic.com", Provider: "anthropic", Timestamp: base, StatusCode: ptr(200), TotalCost: ptr(0.5), FlowIntegrity: "complete"},
+		{ID: "b", Host: "api.openai.com", Provider: "openai", Timestamp: base.Add(time.Minute), StatusCode: ptr(429), FlowIntegrity: "complete"},
+		{ID: "c", Host: "api.anthropic.com", Provider: "anthropic", Timestamp: base.Add(2 * time.Minute), StatusCode: ptr(500), TotalCost: ptr(1.5), FlowIntegrity: "complete"},
+		{ID: "d", Host: "api.anthropic.com", Provider: "anthropic", Timestamp: base.Add(3 * time.Minute), FlowIntegrity: "complete"},
+	}
+
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, f := range flows {
+				if err := s.SaveFlow(ctx, f); err != nil {
+					t.Fatalf("SaveFlow(%s): %v", f.ID, err)
+				}
+			}
+			if err := s.SaveFlow(ctx, flows[0]); err == nil {
+				t.Error("saving a duplicate flow ID succeeded")
+			}
+			if err := s.AddFlowTag(ctx, "c", "env", "prod"); err != nil {
+				t.Fatalf("AddFlowTag: %v", err)
+			}
+
+			ids := func(filter store.FlowFilter) string {
+				t.Helper()
+				list, err := s.ListFlows(ctx, filter)
+				if err != nil {
+					t.Fatalf("ListFlows: %v", err)
+				}
+				n, err := s.CountFlows(ctx, store.FlowFilter{
+					Host: filter.Host, Provider: filter.Provider, StatusMin: filter.StatusMin,
+					StatusMax: filter.StatusMax, Tag: filter.Tag, SortByCost: filter.SortByCost,
+				})
+				if err != nil {
+					t.Fatalf("CountFlows: %v", err)
+				}
+				var got string
+				for _, f := range list {
+					got += f.ID
+				}
+				return fmt.Sprintf("%s/%d", got, n)
+			}
+			tests := []struct {
+				name   string
+				filter store.FlowFilter
+				want   string
+			}{
+				{"newest first", store.FlowFilter{}, "dcba/4"},
+				{"paginated", store.FlowFilter{Limit: 2, Offset: 1}, "cb/4"},
+				{"host", store.FlowFilter{Host: ptr("api.anthropic.com")}, "dca/3"},
+				{"provider", store.FlowFilter{Provider: ptr("openai")}, "b/1"},
+				{"status range", store.FlowFilter{StatusMin: ptr(400), StatusMax: ptr(499)}, "b/1"},
+				{"tag", store.FlowFilter{Tag: ptr("env=prod")}, "c/1"},
+				{"by cost", store.FlowFilter{SortByCost: true}, "ca/2"},
+				{"after cursor", store.FlowFilter{After: &store.FlowCursor{Timestamp: base.Add(2 * time.Minute), ID: "c"}}, "ba/4"},
+				{"after timestamp", store.FlowFilter{After: &store.FlowCursor{Timestamp: base.Add(time.Minute)}}, "a/4"},
+			}
+			for _, tt := range tests {
+				if got := ids(tt.filter); got != tt.want {
+					t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+				}
+			}
+
+			if _, err := s.GetFlow(ctx, "missing"); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("GetFlow(missing) error = %v, want sql.ErrNoRows", err)
+			}
+			if err := s.SaveEvent(ctx, &store.Event{ID: "e0", FlowID: "missing", EventType: "ping", Priority: "medium"}); err == nil {
+				t.Error("SaveEvent for a missing flow succeeded, want a foreign key error")
+			}
+
+			// Events come back by sequence; deleting a flow takes its events,
+			// tool invocations and tags with it
+			for _, seq := range []int{2, 1} {
+				e := &store.Event{ID: fmt.Sprintf("e%d", seq), FlowID: "c", Sequence: seq, Timestamp: base, EventType: "ping", Priority: "medium"}
+				if err := s.SaveEvent(ctx, e); err != nil {
+					t.Fatalf("SaveEvent: %v", err)
+				}
+			}
+			events, _ := s.GetEventsByFlow(ctx, "c")
+			if len(events) != 2 || events[0].Sequence != 1 {
+				t.Errorf("events = %d, first sequence %v; want 2 ordered by sequence", len(events), events)
+			}
+			inv := &store.ToolInvocation{ID: "t1", FlowID: "c", ToolName: "Read", ToolUseID: ptr("toolu_1"), Timestamp: base}
+			if err := s.SaveToolInvocation(ctx, inv); err != nil {
+				t.Fatalf("SaveToolInvocation: %v", err)
+			}
+			if err := s.UpdateToolResult(ctx, "toolu_1", true, nil, ptr("ok"), base.Add(2*time.Second)); err != nil {
+				t.Fatalf("UpdateToolResult: %v", err)
+			}
+			_ = s.UpdateToolResult(ctx, "toolu_1", false, nil, ptr("again"), base.Add(time.Minute))
+			got, err := s.GetToolInvocation(ctx, "t1")
+			// SQLite's julianday arithmetic can round a millisecond off
+			if err != nil || got.DurationMs == nil || *got.DurationMs < 1999 || *got.DurationMs > 2000 || !*got.Success {
+				t.Errorf("tool invocation = %+v, %v; want the first result, 2000ms", got, err)
+			}
+
+			if err := s.DeleteFlow(ctx, "c"); err != nil {
+				t.Fatalf("DeleteFlow: %v", err)
+			}
+			events, _ = s.GetEventsByFlow(ctx, "c")
+			tools, _ := s.GetToolInvocationsByFlow(ctx, "c")
+			tags, _ := s.ListFlowTags(ctx, "c")
+			if len(events)+len(tools)+len(tags) != 0 {
+				t.Errorf("after DeleteFlow: %d events, %d tools, %d tags left", len(events), len(tools), len(tags))
+			}
+		})
+	}
+}
+
+func TestStore_FlowFacets(t *testing.T) {
+	base := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	flows := []*store.Flow{
+		{ID: "a", Host: "api.anthropic.com", Provider: "anthropic", Model: ptr("claude-sonnet-4"), TaskID: ptr("t1"), Timestamp: base},
+		{ID: "b", Host: "api.anthropic.com", Provider: "anthropic", Model: ptr("claude-haiku-4"), TaskID: ptr("t2"), Timestamp: base.Add(time.Minute)},
+		{ID: "c", Host: "api.openai.com", Provider: "openai", Model: ptr("gpt-4o"), TaskID: ptr("t3"), Timestamp: base.Add(2 * time.Minute)},
+		{ID: "d", Host: "api.anthropic.com", Provider: "anthropic", Model: ptr("claude-sonnet-4"), TaskID: ptr("t1"), Timestamp: base.Add(3 * time.Minute)},
+		{ID: "e", Host: "localhost:11434", Provider: "other", Timestamp: base.Add(4 * time.Minute)},
+	}
+
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, f := range flows {
+				f.FlowIntegrity = "complete"
+				if err := s.SaveFlow(ctx, f); err != nil {
+					t.Fatalf("SaveFlow(%s): %v", f.ID, err)
+				}
+			}
+
+			facets, err := s.ListFlowFacets(ctx, 2)
+			if err != nil {
+				t.Fatalf("ListFlowFacets: %v", err)
+			}
+			list := func(values []*store.FacetValue) string {
+				var out string
+				for _, v := range values {
+					out += fmt.Sprintf("%s:%d ", v.Value, v.Count)
+				}
+				return out
+			}
+			for _, tt := range []struct {
+				name   string
+				values []*store.FacetValue
+				want   string
+			}{
+				{"hosts", facets.Hosts, "api.anthropic.com:3 api.openai.com:1 localhost:11434:1 "},
+				{"models", facets.Models, "claude-sonnet-4:2 claude-haiku-4:1 gpt-4o:1 "},
+				{"providers", facets.Providers, "anthropic:3 openai:1 other:1 "},
+				{"tasks", facets.Tasks, "t1:2 t3:1 "}, // Most recently active, limited to 2
+			} {
+				if got := list(tt.values); got != tt.want {
+					t.Errorf("%s = %q, want %q", tt.name, got, tt.want)
+				}
+			}
+			if got := facets.Tasks[0].LastSeen; !got.Equal(base.Add(3 * time.Minute)) {
+				t.Errorf("t1 last seen %v, want %v", got, base.Add(3*time.Minute))
+			}
+		})
+	}
+}
+
+func TestStore_CopiesOnSave(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+	flow := &store.Flow{ID: "f", Host: "api.anthropic.com"}
+	if err := s.SaveFlow(ctx, flow); err != nil {
+		t.Fatal(err)
+	}
+	flow.Host = "changed"
+	got, _ := s.GetFlow(ctx, "f")
+	if got.Host != "api.anthropic.com" {
+		t.Errorf("saved flow changed to %q without an update", got.Host)
+	}
+	got.Host = "changed again"
+	if again, _ := s.GetFlow(ctx, "f"); again.Host != "api.anthropic.com" {
+		t.Errorf("returned flow aliases the stored one")
+	}
+}
+
+func TestStore_Errors(t *testing.T) {
+	ctx := context.Background()
+	errFull := errors.New("database or disk is full")
+	s := New(WithError("SaveFlow", errFull))
+
+	if err := s.SaveFlow(ctx, &store.Flow{ID: "f"}); !errors.Is(err, errFull) {
+		t.Fatalf("SaveFlow error = %v, want %v", err, errFull)
+	}
+	if err := s.UpdateFlow(ctx, &store.Flow{ID: "f"}); err != nil {
+		t.Errorf("UpdateFlow error = %v, want only SaveFlow to fail", err)
+	}
+
+	s.SetError("SaveFlow", nil)
+	s.SetError("ListFlows", errFull)
+	if err := s.SaveFlow(ctx, &store.Flow{ID: "f"}); err != nil {
+		t.Errorf("SaveFlow after clearing the error: %v", err)
+	}
+	if _, err := s.ListFlows(ctx, store.FlowFilter{}); !errors.Is(err, errFull) {
+		t.Errorf("ListFlows error = %v, want %v", err, errFull)
+	}
+	if n, err := s.CountFlows(ctx, store.FlowFilter{}); err != nil || n != 1 {
+		t.Errorf("CountFlows = %d, %v; want 1", n, err)
+	}
+}
+
+func TestStore_ForeignKeysOptional(t *testing.T) {
+	ctx := context.Background()
+	event := &store.Event{ID: "e", FlowID: "missing"}
+	if err := New().SaveEvent(ctx, event); err != nil {
+		t.Errorf("SaveEvent without foreign keys: %v", err)
+	}
+	if err := New(WithForeignKeys()).SaveEvents(ctx, []*store.Event{event}); err == nil {
+		t.Error("SaveEvents for a missing flow succeeded with foreign keys on")
+	}
+}
+
+func TestStore_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	s := New(WithForeignKeys())
+	const workers, perWorker = 8, 50
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				id := fmt.Sprintf("flow-%d-%d", w, i)
+				if err := s.SaveFlow(ctx, &store.Flow{ID: id, Timestamp: time.Now()}); err != nil {
+					t.Errorf("SaveFlow: %v", err)
+					return
+				}
+				if err := s.SaveEvent(ctx, &store.Event{ID: id + "-e", FlowID: id}); err != nil {
+					t.Errorf("SaveEvent: %v", err)
+				}
+				if err := s.SaveTunnel(ctx, &store.Tunnel{Host: id}); err != nil {
+					t.Errorf("SaveTunnel: %v", err)
+				}
+				_, _ = s.ListFlows(ctx, store.FlowFilter{Limit: 10})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n, _ := s.CountFlows(ctx, store.FlowFilter{}); n != workers*perWorker {
+		t.Errorf("CountFlows = %d, want %d", n, workers*perWorker)
+	}
+	tunnels, _ := s.ListTunnels(ctx, 1000)
+	seen := make(map[int64]bool)
+	for _, tun := range tunnels {
+		if seen[tun.ID] {
+			t.Fatalf("tunnel ID %d assigned twice", tun.ID)
+		}
+		seen[tun.ID] = true
+	}
+	if len(seen) != workers*perWorker {
+		t.Errorf("got %d tunnels, want %d", len(seen), workers*perWorker)
+	}
+	deleted, err := s.PurgeAll(ctx)
+	if err != nil || deleted != 3*workers*perWorker {
+		t.Errorf("PurgeAll = %d, %v; want %d", deleted, err, 3*workers*perWorker)
+	}
+}
@@ -0,0 +1,155 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// walPageSize is SQLite's default page size, used to size journal_size_limit
+// before the database is open.
+const walPageSize = 4096
+
+// walEscalateAfter is how many PASSIVE checkpoints in a row may be blocked by
+// readers before the monitor escalates to TRUNCATE.
+const walEscalateAfter = 3
+
+// WALCheckpoint is the result of a PRAGMA wal_checkpoint.
+type WALCheckpoint struct {
+	Mode         string // PASSIVE, FULL, RESTART or TRUNCATE
+	Busy         bool   // The checkpoint couldn't take the locks it needed
+	LogPages     int    // Frames in the WAL
+	Checkpointed int    // Frames copied back into the database
+}
+
+// Complete reports whether every WAL frame made it into the database.
+func (c *WALCheckpoint) Complete() bool {
+	return !c.Busy && c.Checkpointed >= c.LogPages
+}
+
+// Checkpoint runs PRAGMA wal_checkpoint in the given mode.
+func (s *SQLiteStore) Checkpoint(ctx context.Context, mode string) (*WALCheckpoint, error) {
+	mode = strings.ToUpper(mode)
+	switch mode {
+	case "PASSIVE", "FULL", "RESTART", "TRUNCATE":
+	default:
+		return nil, fmt.Errorf("unknown checkpoint mode %q", mode)
+	}
+
+	res := &WALCheckpoint{Mode: mode}
+	var busy int
+	err := s.db.QueryRowContext(ctx, "PRAGMA wal_checkpoint("+mode+")").Scan(&busy, &res.LogPages, &res.Checkpointed)
+	if err != nil {
+		return nil, fmt.Errorf("wal_checkpoint(%s): %w", mode, err)
+	}
+	res.Busy = busy != 0
+	return res, nil
+}
+
+// WALPages returns the size of the WAL file in pages, read from disk so that
+// measuring it doesn't checkpoint. In-memory databases have no WAL file and
+// report 0.
+func (s *SQLiteStore) WALPages(ctx context.Context) (int64, error) {
+	info, err := os.Stat(s.path + "-wal")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("reading WAL size: %w", err)
+	}
+	var pageSize int64
+	if err := s.db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, fmt.Errorf("reading page size: %w", err)
+	}
+	return info.Size() / pageSize, nil
+}
+
+// WALMonitor keeps the WAL from growing without bound when long-lived readers
+// stop SQLite's own autocheckpoint from finishing. Once the WAL passes the
+// threshold it runs a PASSIVE checkpoint; if readers block that
+// walEscalateAfter times in a row it escalates to TRUNCATE, which waits out
+// the readers (up to the busy timeout) and empties the WAL file.
+type WALMonitor struct {
+	store          *SQLiteStore
+	thresholdPages int64
+	logger         *slog.Logger
+
+	blocked int // Consecutive PASSIVE checkpoints that didn't complete
+}
+
+// NewWALMonitor creates a monitor that checkpoints once the WAL holds more
+// than thresholdPages pages.
+func NewWALMonitor(s *SQLiteStore, thresholdPages int, logger *slog.Logger) *WALMonitor {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &WALMonitor{
+		store:          s,
+		thresholdPages: int64(thresholdPages),
+		logger:         logger,
+	}
+}
+
+// Check runs one monitoring pass. It returns nil when the WAL is under the
+// threshold, otherwise the last checkpoint attempted.
+func (m *WALMonitor) Check(ctx context.Context) (*WALCheckpoint, error) {
+	pages, err := m.store.WALPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if pages <= m.thresholdPages {
+		return nil, nil
+	}
+
+	res, err := m.store.Checkpoint(ctx, "PASSIVE")
+	if err != nil {
+		return nil, err
+	}
+	m.logger.Info("WAL checkpoint", "mode", res.Mode, "wal_pages", pages,
+		"log_pages", res.LogPages, "checkpointed", res.Checkpointed, "busy", res.Busy)
+	if res.Complete() {
+		m.blocked = 0
+		return res, nil
+	}
+
+	m.blocked++
+	if m.blocked < walEscalateAfter {
+		return res, nil
+	}
+
+	res, err = m.store.Checkpoint(ctx, "TRUNCATE")
+	if err != nil {
+		return nil, err
+	}
+	if res.Complete() {
+		m.blocked = 0
+		m.logger.Info("WAL checkpoint", "mode", res.Mode, "wal_pages", pages,
+			"log_pages", res.LogPages, "checkpointed", res.Checkpointed, "busy", res.Busy)
+	} else {
+		m.logger.Warn("WAL checkpoint blocked by readers", "mode", res.Mode, "wal_pages", pages,
+			"log_pages", res.LogPages, "checkpointed", res.Checkpointed, "blocked_passive", m.blocked)
+	}
+	return res, nil
+}
+
+// Run checks the WAL every interval until ctx is done.
+func (m *WALMonitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkCtx, cancel := context.WithTimeout(ctx, time.Minute)
+			if _, err := m.Check(checkCtx); err != nil {
+				m.logger.Error("WAL monitor check failed", "error", err)
+			}
+			cancel()
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeWALPages saves flows with large bodies in one transaction so the WAL
+// grows well past a small threshold.
+func writeWALPages(t *testing.T, s *SQLiteStore, prefix string, n int) {
+	t.Helper()
+	ctx := context.Background()
+	body := strings.Repeat("x", 16*1024)
+	for i := 0; i < n; i++ {
+		flow := &Flow{
+			ID:            fmt.Sprintf("%s-%d", prefix, i),
+			Host:          "api.anthropic.com",
+			Method:        "POST",
+			Path:          "/v1/messages",
+			Timestamp:     time.Now(),
+			FlowIntegrity: "complete",
+			Provider:      "anthropic",
+			RequestBody:   &body,
+		}
+		if err := s.SaveFlow(ctx, flow); err != nil {
+			t.Fatalf("SaveFlow: %v", err)
+		}
+	}
+}
+
+func TestNewSQLiteStore_WALPragmas(t *testing.T) {
+	t.Parallel()
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	s, err := NewSQLiteStore(dbPath, testRetention(), WithWALAutocheckpoint(200))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer s.Close()
+
+	var mode string
+	var autocheckpoint, sizeLimit int64
+	if err := s.db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.QueryRow("PRAGMA wal_autocheckpoint").Scan(&autocheckpoint); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.QueryRow("PRAGMA journal_size_limit").Scan(&sizeLimit); err != nil {
+		t.Fatal(err)
+	}
+	if mode != "wal" || autocheckpoint != 200 || sizeLimit != 200*walPageSize {
+		t.Errorf("journal_mode=%s wal_autocheckpoint=%d journal_size_limit=%d, want wal/200/%d",
+			mode, autocheckpoint, sizeLimit, 200*walPageSize)
+	}
+}
+
+func TestWALMonitor_CheckpointsLargeWAL(t *testing.T) {
+	t.Parallel()
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	s, err := NewSQLiteStore(dbPath, testRetention(), WithWALAutocheckpoint(100))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	monitor := NewWALMonitor(s, 100, logger)
+
+	if res, err := monitor.Check(ctx); err != nil || res != nil {
+		t.Fatalf("Check on a small WAL = %+v, %v; want no checkpoint", res, err)
+	}
+
+	// A reader holding an old snapshot stops SQLite's autocheckpoint (and the
+	// monitor's PASSIVE checkpoints) from backfilling past it.
+	reader, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("opening reader: %v", err)
+	}
+	defer reader.Close()
+	readTx, err := reader.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("BeginTx: %v", err)
+	}
+	var n int
+	if err := readTx.QueryRow("SELECT COUNT(*) FROM flows").Scan(&n); err != nil {
+		t.Fatalf("reader query: %v", err)
+	}
+
+	writeWALPages(t, s, "flow", 50)
+	before, err := s.WALPages(ctx)
+	if err != nil {
+		t.Fatalf("WALPages: %v", err)
+	}
+	if before <= 100 {
+		t.Fatalf("WAL = %d pages after writes, want > 100", before)
+	}
+
+	for i := 1; i < walEscalateAfter; i++ {
+		res, err := monitor.Check(ctx)
+		if err != nil {
+			t.Fatalf("Check %d: %v", i, err)
+		}
+		if res == nil || res.Mode != "PASSIVE" || res.Complete() {
+			t.Fatalf("Check %d = %+v, want an incomplete PASSIVE checkpoint", i, res)
+		}
+	}
+
+	// The next check escalates to TRUNCATE, which waits for the reader
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		_ = readTx.Rollback()
+	}()
+	res, err := monitor.Check(ctx)
+	if err != nil {
+		t.Fatalf("escalated Check: %v", err)
+	}
+	if res == nil || res.Mode != "TRUNCATE" || !res.Complete() {
+		t.Fatalf("escalated Check = %+v, want a complete TRUNCATE checkpoint", res)
+	}
+
+	after, err := s.WALPages(ctx)
+	if err != nil {
+		t.Fatalf("WALPages: %v", err)
+	}
+	if after >= before || after > 100 {
+		t.Errorf("WAL = %d pages after checkpoint, want it to shrink from %d to at most 100", after, before)
+	}
+
+	// Without readers, a PASSIVE checkpoint completes and the WAL is cut back
+	// to journal_size_limit on the next write.
+	writeWALPages(t, s, "again", 50)
+	if res, err := monitor.Check(ctx); err != nil || (res != nil && !res.Complete()) {
+		t.Fatalf("Check without readers = %+v, %v; want complete or no checkpoint", res, err)
+	}
+	writeWALPages(t, s, "tail", 1)
+	if pages, _ := s.WALPages(ctx); pages > 100 {
+		t.Errorf("WAL = %d pages after checkpoint and write, want at most 100", pages)
+	}
+}
@@ -1,13 +1,15 @@
 // Package task provides task boundary detection and assignment.
 // This implements the layered approach from REVIEW.md:
-// Priority 1: X-Langley-Task header (explicit)
-// Priority 2: request.metadata.user_id (metadata)
+// Priority 1: X-Langley-Task header, langley_task query param or cookie (explicit)
+// Priority 2: request.metadata.user_id, or task.id_json_path (metadata)
 // Priority 3: host + idle gap heuristic (inferred)
 package task
 
 import (
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 )
@@ -23,8 +25,18 @@ const (
 	// TaskHeader is the header used for explicit task assignment.
 	TaskHeader = "X-Langley-Task"
 
+	// TaskCookie is the cookie used for explicit task assignment by clients
+	// that can't set custom headers (e.g. browsers).
+	TaskCookie = "langley_task"
+
+	// DefaultTaskQueryParam is the default query parameter for explicit task assignment.
+	DefaultTaskQueryParam = "langley_task"
+
 	// DefaultIdleGapMinutes is the default idle gap for task boundaries.
 	DefaultIdleGapMinutes = 5
+
+	// DefaultIDJSONPath is the request body field read for metadata task IDs.
+	DefaultIDJSONPath = "metadata.user_id"
 )
 
 // Assignment represents a task assignment.
@@ -39,12 +51,16 @@ type Assigner struct {
 	lastActivity  map[string]time.Time // host -> last activity time
 	lastTaskID    map[string]string    // host -> last task ID
 	idleGap       time.Duration
+	queryParam    string
+	idPath        []string // Dotted task.id_json_path, split into keys
 	taskCounter   int
 }
 
 // AssignerConfig configures the task assigner.
 type AssignerConfig struct {
 	IdleGapMinutes int
+	QueryParam     string // Query parameter carrying an explicit task ID (default: langley_task)
+	IDJSONPath     string // Dotted path of the body field holding a task ID (default: metadata.user_id)
 }
 
 // NewAssigner creates a new task assigner.
@@ -54,10 +70,22 @@ func NewAssigner(cfg AssignerConfig) *Assigner {
 		idleGap = cfg.IdleGapMinutes
 	}
 
+	queryParam := DefaultTaskQueryParam
+	if cfg.QueryParam != "" {
+		queryParam = cfg.QueryParam
+	}
+
+	idPath := DefaultIDJSONPath
+	if cfg.IDJSONPath != "" {
+		idPath = cfg.IDJSONPath
+	}
+
 	return &Assigner{
 		lastActivity: make(map[string]time.Time),
 		lastTaskID:   make(map[string]string),
 		idleGap:      time.Duration(idleGap) * time.Minute,
+		queryParam:   queryParam,
+		idPath:       strings.Split(idPath, "."),
 	}
 }
 
@@ -72,7 +100,7 @@ func (a *Assigner) Assign(host string, headers http.Header, body []byte) *Assign
 	}
 
 	// Priority 2: Metadata from request body
-	if taskID := extractMetadataTaskID(body); taskID != "" {
+	if taskID := extractMetadataTaskID(body, a.idPath); taskID != "" {
 		return &Assignment{
 			TaskID: taskID,
 			Source: SourceMetadata,
@@ -83,6 +111,99 @@ func (a *Assigner) Assign(host string, headers http.Header, body []byte) *Assign
 	return a.assignByHeuristic(host)
 }
 
+// AssignRequest determines the task for a proxied request. In addition to the
+// X-Langley-Task header it accepts the task query parameter and the
+// langley_task cookie, all of which count as explicit (header wins, then
+// query param, then cookie). The query parameter and cookie are always
+// stripped from r so they are not forwarded upstream.
+func (a *Assigner) AssignRequest(host string, r *http.Request, body []byte) *Assignment {
+	fromQuery := stripQueryParam(r, a.queryParam)
+	fromCookie := stripCookie(r, TaskCookie)
+
+	if r.Header.Get(TaskHeader) == "" {
+		taskID := fromQuery
+		if taskID == "" {
+			taskID = fromCookie
+		}
+		if taskID != "" {
+			return &Assignment{
+				TaskID: taskID,
+				Source: SourceExplicit,
+			}
+		}
+	}
+
+	return a.Assign(host, r.Header, body)
+}
+
+// stripQueryParam removes every occurrence of name from the request URL,
+// preserving the order and encoding of the remaining parameters.
+// Returns the first non-empty value found.
+func stripQueryParam(r *http.Request, name string) string {
+	if r.URL == nil || r.URL.RawQuery == "" {
+		return ""
+	}
+
+	var value string
+	var kept []string
+	found := false
+	for _, pair := range strings.Split(r.URL.RawQuery, "&") {
+		key, v, _ := strings.Cut(pair, "=")
+		if k, err := url.QueryUnescape(key); err == nil && k == name {
+			found = true
+			if value == "" {
+				value, _ = url.QueryUnescape(v)
+			}
+			continue
+		}
+		kept = append(kept, pair)
+	}
+
+	if found {
+		r.URL.RawQuery = strings.Join(kept, "&")
+		r.RequestURI = ""
+	}
+	return value
+}
+
+// stripCookie removes the named cookie from the request's Cookie headers.
+// Returns its value, or "" if not present.
+func stripCookie(r *http.Request, name string) string {
+	lines := r.Header.Values("Cookie")
+	if len(lines) == 0 {
+		return ""
+	}
+
+	var value string
+	var kept []string
+	found := false
+	for _, line := range lines {
+		for _, part := range strings.Split(line, ";") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			key, v, _ := strings.Cut(part, "=")
+			if strings.TrimSpace(key) == name {
+				found = true
+				if value == "" {
+					value = strings.Trim(strings.TrimSpace(v), `"`)
+				}
+				continue
+			}
+			kept = append(kept, part)
+		}
+	}
+
+	if found {
+		r.Header.Del("Cookie")
+		if len(kept) > 0 {
+			r.Header.Set("Cookie", strings.Join(kept, "; "))
+		}
+	}
+	return value
+}
+
 // assignByHeuristic assigns a task using the idle gap heuristic.
 func (a *Assigner) assignByHeuristic(host string) *Assignment {
 	a.mu.Lock()
@@ -110,24 +231,38 @@ func (a *Assigner) assignByHeuristic(host string) *Assignment {
 	}
 }
 
-// extractMetadataTaskID extracts task ID from request body metadata.
-// Claude API requests can include metadata.user_id which we use for task grouping.
-func extractMetadataTaskID(body []byte) string {
-	if len(body) == 0 {
+// extractMetadataTaskID extracts a task ID from the request body field at
+// path, e.g. ["metadata", "user_id"]. String and number values are used;
+// a missing field or any other type yields "".
+func extractMetadataTaskID(body []byte, path []string) string {
+	if len(body) == 0 || len(path) == 0 {
 		return ""
 	}
 
-	var request struct {
-		Metadata struct {
-			UserID string `json:"user_id"`
-		} `json:"metadata"`
+	// Descend one object at a time, leaving everything else undecoded
+	raw := json.RawMessage(body)
+	for _, key := range path {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return ""
+		}
+		var ok bool
+		if raw, ok = obj[key]; !ok {
+			return ""
+		}
 	}
 
-	if err := json.Unmarshal(body, &request); err != nil {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
 		return ""
 	}
-
-	return request.Metadata.UserID
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strings.TrimSpace(string(raw)) // Keep the literal, e.g. large integer IDs
+	}
+	return ""
 }
 
 // generateTaskID generates a task ID for heuristic assignment.
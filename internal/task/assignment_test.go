@@ -2,6 +2,7 @@ package task
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"sync"
 	"testing"
 	"time"
@@ -178,6 +179,120 @@ func TestAssign_Priority(t *testing.T) {
 	}
 }
 
+func TestAssignRequest_QueryParam(t *testing.T) {
+	t.Parallel()
+
+	a := NewAssigner(AssignerConfig{})
+	r := httptest.NewRequest("POST", "https://api.anthropic.com/v1/messages?beta=true&langley_task=q-task&x=1", nil)
+	body := []byte(`{"metadata": {"user_id": "metadata-task"}}`)
+
+	assignment := a.AssignRequest("api.anthropic.com", r, body)
+
+	if assignment.TaskID != "q-task" {
+		t.Errorf("TaskID = %q, want %q (query param should beat metadata)", assignment.TaskID, "q-task")
+	}
+	if assignment.Source != SourceExplicit {
+		t.Errorf("Source = %q, want %q", assignment.Source, SourceExplicit)
+	}
+	if r.URL.RawQuery != "beta=true&x=1" {
+		t.Errorf("RawQuery = %q, want marker stripped and other params preserved", r.URL.RawQuery)
+	}
+}
+
+func TestAssignRequest_CustomQueryParam(t *testing.T) {
+	t.Parallel()
+
+	a := NewAssigner(AssignerConfig{QueryParam: "task"})
+	r := httptest.NewRequest("GET", "https://api.anthropic.com/v1/models?task=custom", nil)
+
+	assignment := a.AssignRequest("api.anthropic.com", r, nil)
+
+	if assignment.TaskID != "custom" || assignment.Source != SourceExplicit {
+		t.Errorf("assignment = %+v, want custom/explicit", assignment)
+	}
+	if r.URL.RawQuery != "" {
+		t.Errorf("RawQuery = %q, want empty", r.URL.RawQuery)
+	}
+}
+
+func TestAssignRequest_Cookie(t *testing.T) {
+	t.Parallel()
+
+	a := NewAssigner(AssignerConfig{})
+	r := httptest.NewRequest("POST", "https://api.anthropic.com/v1/messages", nil)
+	r.Header.Set("Cookie", "session=abc; langley_task=cookie-task; theme=dark")
+
+	assignment := a.AssignRequest("api.anthropic.com", r, nil)
+
+	if assignment.TaskID != "cookie-task" {
+		t.Errorf("TaskID = %q, want %q", assignment.TaskID, "cookie-task")
+	}
+	if assignment.Source != SourceExplicit {
+		t.Errorf("Source = %q, want %q", assignment.Source, SourceExplicit)
+	}
+	if got := r.Header.Get("Cookie"); got != "session=abc; theme=dark" {
+		t.Errorf("Cookie = %q, want task cookie stripped", got)
+	}
+}
+
+func TestAssignRequest_CookieOnlyRemovesHeader(t *testing.T) {
+	t.Parallel()
+
+	a := NewAssigner(AssignerConfig{})
+	r := httptest.NewRequest("POST", "https://api.anthropic.com/v1/messages", nil)
+	r.Header.Set("Cookie", "langley_task=only")
+
+	a.AssignRequest("api.anthropic.com", r, nil)
+
+	if _, ok := r.Header["Cookie"]; ok {
+		t.Errorf("Cookie header should be removed, got %q", r.Header.Get("Cookie"))
+	}
+}
+
+func TestAssignRequest_Precedence(t *testing.T) {
+	t.Parallel()
+
+	a := NewAssigner(AssignerConfig{})
+
+	t.Run("header beats query param and cookie", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "https://api.anthropic.com/v1/messages?langley_task=q-task", nil)
+		r.Header.Set(TaskHeader, "header-task")
+		r.Header.Set("Cookie", "langley_task=cookie-task")
+
+		assignment := a.AssignRequest("api.anthropic.com", r, nil)
+
+		if assignment.TaskID != "header-task" {
+			t.Errorf("TaskID = %q, want header-task", assignment.TaskID)
+		}
+		// Markers are stripped even when the header wins
+		if r.URL.RawQuery != "" || r.Header.Get("Cookie") != "" {
+			t.Errorf("markers not stripped: query=%q cookie=%q", r.URL.RawQuery, r.Header.Get("Cookie"))
+		}
+	})
+
+	t.Run("query param beats cookie", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "https://api.anthropic.com/v1/messages?langley_task=q-task", nil)
+		r.Header.Set("Cookie", "langley_task=cookie-task")
+
+		assignment := a.AssignRequest("api.anthropic.com", r, nil)
+
+		if assignment.TaskID != "q-task" {
+			t.Errorf("TaskID = %q, want q-task", assignment.TaskID)
+		}
+	})
+
+	t.Run("falls back to metadata", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "https://api.anthropic.com/v1/messages", nil)
+		body := []byte(`{"metadata": {"user_id": "metadata-task"}}`)
+
+		assignment := a.AssignRequest("api.anthropic.com", r, body)
+
+		if assignment.TaskID != "metadata-task" || assignment.Source != SourceMetadata {
+			t.Errorf("assignment = %+v, want metadata-task/metadata", assignment)
+		}
+	})
+}
+
 func TestAssign_Heuristic(t *testing.T) {
 	t.Parallel()
 
@@ -286,7 +401,7 @@ func TestExtractMetadataTaskID(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
-			got := extractMetadataTaskID(tt.body)
+			got := extractMetadataTaskID(tt.body, []string{"metadata", "user_id"})
 			if got != tt.want {
 				t.Errorf("extractMetadataTaskID() = %q, want %q", got, tt.want)
 			}
@@ -409,6 +524,39 @@ func TestGenerateTaskID(t *testing.T) {
 	}
 }
 
+func TestAssign_CustomIDJSONPath(t *testing.T) {
+	t.Parallel()
+
+	a := NewAssigner(AssignerConfig{IDJSONPath: "request.context.trace_id"})
+
+	tests := []struct {
+		name       string
+		body       string
+		wantID     string
+		wantSource string
+	}{
+		{"nested string", `{"request": {"context": {"trace_id": "trace-abc"}}}`, "trace-abc", SourceMetadata},
+		{"number", `{"request": {"context": {"trace_id": 12345678901234567890}}}`, "12345678901234567890", SourceMetadata},
+		{"default path ignored", `{"metadata": {"user_id": "user-1"}}`, "", SourceInferred},
+		{"missing leaf", `{"request": {"context": {}}}`, "", SourceInferred},
+		{"intermediate not an object", `{"request": {"context": "flat"}}`, "", SourceInferred},
+		{"leaf is an object", `{"request": {"context": {"trace_id": {"id": "x"}}}}`, "", SourceInferred},
+		{"invalid JSON", `{"request":`, "", SourceInferred},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := a.Assign("api.example.com", http.Header{}, []byte(tt.body))
+			if got.Source != tt.wantSource {
+				t.Errorf("Source = %q, want %q", got.Source, tt.wantSource)
+			}
+			if tt.wantID != "" && got.TaskID != tt.wantID {
+				t.Errorf("TaskID = %q, want %q", got.TaskID, tt.wantID)
+			}
+		})
+	}
+}
+
 func TestReset(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,314 @@
+// Package telemetry records a tracing span per proxied flow and exports it
+// over OTLP/HTTP. It follows the OpenTelemetry data model and W3C Trace
+// Context so langley spans join the caller's and upstream's traces, without
+// pulling in the OpenTelemetry SDK.
+package telemetry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentHeader is the W3C Trace Context header.
+const TraceparentHeader = "traceparent"
+
+// Batching limits for exported spans.
+const (
+	maxBatchSize  = 512
+	maxQueueSize  = 4096
+	batchInterval = 5 * time.Second
+)
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether both IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats the span context as a version 00 traceparent value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceparent parses a traceparent header value. It returns false for
+// a missing or malformed value, or all-zero IDs.
+func ParseTraceparent(v string) (SpanContext, bool) {
+	var sc SpanContext
+	v = strings.TrimSpace(v)
+	if strings.ToLower(v) != v {
+		return sc, false // IDs must be lowercase hex
+	}
+	parts := strings.Split(v, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false // Version 00 has exactly four fields
+	}
+	var version, flags [1]byte
+	if _, err := hex.Decode(version[:], []byte(parts[0])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// SpanData is a finished span as handed to an Exporter.
+type SpanData struct {
+	Name          string
+	SpanContext   SpanContext
+	Parent        SpanContext // Zero for a root span
+	Start         time.Time
+	End           time.Time
+	Attributes    map[string]interface{} // string, bool, int, int64 or float64
+	Error         bool
+	StatusMessage string
+}
+
+// Span is an in-progress span. All methods are safe on a nil Span, which
+// is what a nil Tracer starts, so callers don't need to check whether
+// tracing is on.
+type Span struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// Context returns the span's context, for propagating to the upstream.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// SetAttribute records an attribute. Later values replace earlier ones.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes[key] = value
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = true
+	s.data.StatusMessage = msg
+}
+
+// End finishes the span and queues it for export. Only the first call counts.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.SpanContext.Sampled {
+		s.tracer.enqueue(&data)
+	}
+}
+
+// Exporter sends finished spans to a backend.
+type Exporter interface {
+	ExportSpans(ctx context.Context, spans []*SpanData) error
+}
+
+// Tracer starts spans and exports finished ones in batches.
+type Tracer struct {
+	exporter Exporter
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	queue   []*SpanData
+	dropped int
+	kick    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewTracer returns a tracer exporting to exporter. Call Shutdown to flush
+// the remaining spans.
+func NewTracer(exporter Exporter, logger *slog.Logger) *Tracer {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	t := &Tracer{
+		exporter: exporter,
+		logger:   logger,
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start begins a span. With a valid parent (e.g. from the client's
+// traceparent) the span joins that trace and keeps its sampling decision;
+// otherwise it starts a new, sampled trace. A nil Tracer returns nil.
+func (t *Tracer) Start(name string, parent SpanContext) *Span {
+	if t == nil {
+		return nil
+	}
+	sc := SpanContext{Sampled: true}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+	} else {
+		parent = SpanContext{}
+		_, _ = rand.Read(sc.TraceID[:])
+	}
+	_, _ = rand.Read(sc.SpanID[:])
+
+	return &Span{
+		tracer: t,
+		data: SpanData{
+			Name:        name,
+			SpanContext: sc,
+			Parent:      parent,
+			Start:       time.Now(),
+			Attributes:  make(map[string]interface{}),
+		},
+	}
+}
+
+// enqueue adds a finished span, dropping it if the exporter has fallen
+// too far behind.
+func (t *Tracer) enqueue(s *SpanData) {
+	t.mu.Lock()
+	if len(t.queue) >= maxQueueSize {
+		t.dropped++
+		t.mu.Unlock()
+		return
+	}
+	t.queue = append(t.queue, s)
+	full := len(t.queue) >= maxBatchSize
+	t.mu.Unlock()
+
+	if full {
+		select {
+		case t.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run exports queued spans every batchInterval, or sooner when a batch fills.
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		case <-t.kick:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := t.Flush(ctx); err != nil {
+			t.logger.Warn("failed to export spans", "error", err)
+		}
+		cancel()
+	}
+}
+
+// Flush exports every queued span.
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	for {
+		t.mu.Lock()
+		n := min(len(t.queue), maxBatchSize)
+		batch := t.queue[:n:n]
+		t.queue = t.queue[n:]
+		dropped := t.dropped
+		t.dropped = 0
+		t.mu.Unlock()
+
+		if dropped > 0 {
+			t.logger.Warn("span queue full, dropped spans", "count", dropped)
+		}
+		if n == 0 {
+			return nil
+		}
+		if err := t.exporter.ExportSpans(ctx, batch); err != nil {
+			return fmt.Errorf("exporting %d spans: %w", n, err)
+		}
+	}
+}
+
+// Shutdown stops the background export and flushes the remaining spans.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	close(t.stop)
+	<-t.done
+	return t.Flush(ctx)
+}
+
+// InMemoryExporter keeps exported spans in memory, for tests.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []*SpanData
+}
+
+// ExportSpans implements Exporter.
+func (e *InMemoryExporter) ExportSpans(ctx context.Context, spans []*SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+// Spans returns the spans exported so far.
+func (e *InMemoryExporter) Spans() []*SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*SpanData(nil), e.spans...)
+}
@@ -0,0 +1,141 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		value   string
+		ok      bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false, false},
+		{"00-zzf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+	}
+	for _, tt := range tests {
+		sc, ok := ParseTraceparent(tt.value)
+		if ok != tt.ok {
+			t.Errorf("ParseTraceparent(%q) ok = %v, want %v", tt.value, ok, tt.ok)
+			continue
+		}
+		if ok && sc.Sampled != tt.sampled {
+			t.Errorf("ParseTraceparent(%q) sampled = %v, want %v", tt.value, sc.Sampled, tt.sampled)
+		}
+	}
+
+	const v = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, _ := ParseTraceparent(v)
+	if got := sc.Traceparent(); got != v {
+		t.Errorf("Traceparent() = %q, want %q", got, v)
+	}
+}
+
+func TestTracer_StartAndExport(t *testing.T) {
+	exporter := &InMemoryExporter{}
+	tracer := NewTracer(exporter, nil)
+
+	root := tracer.Start("GET", SpanContext{})
+	if !root.Context().IsValid() || !root.Context().Sampled {
+		t.Fatal("root span should have a new sampled context")
+	}
+	root.SetAttribute("k", "v")
+	root.End()
+	root.End() // Second End is a no-op
+
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	unsampled := tracer.Start("POST", parent)
+	if unsampled.Context().TraceID != parent.TraceID {
+		t.Error("child span should join the parent's trace")
+	}
+	unsampled.End()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	spans := exporter.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("exported %d spans, want 1 (unsampled span dropped)", len(spans))
+	}
+	if spans[0].Attributes["k"] != "v" {
+		t.Errorf("attributes = %v", spans[0].Attributes)
+	}
+
+	var nilTracer *Tracer
+	span := nilTracer.Start("GET", SpanContext{})
+	span.SetAttribute("k", "v")
+	span.SetError("boom")
+	span.End()
+	if span.Context().IsValid() {
+		t.Error("nil tracer span should have no context")
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var got struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []otlpKeyValue `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []otlpSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	var gotPath, gotAuth string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding export: %v", err)
+		}
+	}))
+	defer collector.Close()
+
+	exporter := NewOTLPExporter(collector.URL, "", map[string]string{"Authorization": "Bearer x"})
+	tracer := NewTracer(exporter, nil)
+	span := tracer.Start("POST", SpanContext{})
+	span.SetAttribute("gen_ai.usage.input_tokens", 42)
+	span.SetError("upstream failed")
+	span.End()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	if gotPath != "/v1/traces" {
+		t.Errorf("path = %q, want /v1/traces", gotPath)
+	}
+	if gotAuth != "Bearer x" {
+		t.Errorf("Authorization = %q", gotAuth)
+	}
+	if len(got.ResourceSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans[0].Spans) != 1 {
+		t.Fatalf("unexpected export shape: %+v", got)
+	}
+	res := got.ResourceSpans[0].Resource.Attributes
+	if len(res) != 1 || res[0].Key != "service.name" || *res[0].Value.StringValue != "langley" {
+		t.Errorf("resource attributes = %+v", res)
+	}
+	s := got.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if s.TraceID != span.Context().Traceparent()[3:35] {
+		t.Errorf("traceId = %q", s.TraceID)
+	}
+	if s.Status == nil || s.Status.Code != otlpStatusError {
+		t.Errorf("status = %+v, want error", s.Status)
+	}
+	if len(s.Attributes) != 1 || s.Attributes[0].Value.IntValue == nil || *s.Attributes[0].Value.IntValue != "42" {
+		t.Errorf("attributes = %+v", s.Attributes)
+	}
+}
@@ -11,7 +11,7 @@ import (
 	"github.com/HakAl/langley/internal/store"
 )
 
-//go:embed flows/*.json sse/*.txt responses/*.json
+//go:embed flows/*.json sse/*.txt responses/*.json eventstream/*.bin
 var fixtures embed.FS
 
 // FlowBuilder provides a fluent API for building test flows.
@@ -78,6 +78,10 @@ func (b *FlowBuilder) WithProvider(provider string) *FlowBuilder {
 		b.flow.Host = "generativelanguage.googleapis.com"
 		b.flow.URL = "https://generativelanguage.googleapis.com/v1beta/models/gemini-1.5-pro:generateContent"
 		b.flow.Path = "/v1beta/models/gemini-1.5-pro:generateContent"
+	case "ollama":
+		b.flow.Host = "localhost:11434"
+		b.flow.URL = "http://localhost:11434/api/chat"
+		b.flow.Path = "/api/chat"
 	}
 	return b
 }
@@ -182,6 +186,19 @@ func LoadSSE(t testing.TB, name string) string {
 	return string(data)
 }
 
+// LoadEventStream loads a binary AWS event stream fixture (Bedrock streaming).
+// The name should not include the .bin extension.
+func LoadEventStream(t testing.TB, name string) []byte {
+	t.Helper()
+
+	data, err := fixtures.ReadFile(path.Join("eventstream", name+".bin"))
+	if err != nil {
+		t.Fatalf("failed to load event stream fixture %q: %v", name, err)
+	}
+
+	return data
+}
+
 // LoadResponse loads a provider response fixture.
 // The name should not include the .json extension.
 func LoadResponse(t testing.TB, name string) []byte {
@@ -1,6 +1,7 @@
 package testutil
 
 import (
+	"bytes"
 	"strings"
 	"testing"
 )
@@ -262,6 +263,17 @@ func TestLoadSSE_Gemini(t *testing.T) {
 	}
 }
 
+func TestLoadEventStream_Bedrock(t *testing.T) {
+	data := LoadEventStream(t, "bedrock_invoke_stream")
+
+	if len(data) == 0 {
+		t.Fatal("event stream fixture should not be empty")
+	}
+	if !bytes.Contains(data, []byte(":event-type")) {
+		t.Error("event stream should contain :event-type headers")
+	}
+}
+
 func TestLoadResponse_Anthropic(t *testing.T) {
 	resp := LoadResponse(t, "anthropic")
 
@@ -6,6 +6,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
@@ -21,16 +22,27 @@ const (
 
 	// DefaultMaxCacheSize is the default LRU cache size (addresses langley-bma).
 	DefaultMaxCacheSize = 1000
+
+	// DefaultMaxCertGenConcurrency is the default number of leaf
+	// certificates generated at once.
+	DefaultMaxCertGenConcurrency = 4
 )
 
 // CertCache is an LRU cache for dynamically generated TLS certificates.
 // This addresses langley-bma (unbounded cache leading to memory exhaustion).
+// Generation runs outside the cache lock, bounded by a semaphore, and
+// concurrent requests for the same host share a single generation.
 type CertCache struct {
 	ca       *CA
 	maxSize  int
 	mu       sync.Mutex
 	cache    map[string]*cacheEntry
 	order    []string // LRU order (oldest first)
+	inflight map[string]*certCall
+	gen      genLimiter
+
+	// generate creates a leaf certificate; replaced in tests.
+	generate func(host string) (*tls.Certificate, error)
 }
 
 type cacheEntry struct {
@@ -38,17 +50,74 @@ type cacheEntry struct {
 	createdAt time.Time
 }
 
+// certCall is an in-progress generation that other callers can wait on.
+type certCall struct {
+	done chan struct{}
+	cert *tls.Certificate
+	err  error
+}
+
 // NewCertCache creates a new certificate cache with the given CA and max size.
 func NewCertCache(ca *CA, maxSize int) *CertCache {
 	if maxSize <= 0 {
 		maxSize = DefaultMaxCacheSize
 	}
-	return &CertCache{
-		ca:      ca,
-		maxSize: maxSize,
-		cache:   make(map[string]*cacheEntry),
-		order:   make([]string, 0, maxSize),
+	c := &CertCache{
+		ca:       ca,
+		maxSize:  maxSize,
+		cache:    make(map[string]*cacheEntry),
+		order:    make([]string, 0, maxSize),
+		inflight: make(map[string]*certCall),
+	}
+	c.gen.setLimit(DefaultMaxCertGenConcurrency)
+	c.generate = c.generateCert
+	return c
+}
+
+// SetMaxConcurrentGenerations limits how many certificates are generated
+// at once. Values <= 0 use DefaultMaxCertGenConcurrency. It may be called
+// while the cache is in use: generations already running finish, and new
+// ones start once fewer than n are running.
+func (c *CertCache) SetMaxConcurrentGenerations(n int) {
+	if n <= 0 {
+		n = DefaultMaxCertGenConcurrency
+	}
+	c.gen.setLimit(n)
+}
+
+// genLimiter is a counting semaphore whose limit can change while it is
+// held.
+type genLimiter struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active int
+}
+
+func (l *genLimiter) setLimit(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cond == nil {
+		l.cond = sync.NewCond(&l.mu)
+	}
+	l.limit = n
+	l.cond.Broadcast()
+}
+
+func (l *genLimiter) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.active >= l.limit {
+		l.cond.Wait()
 	}
+	l.active++
+}
+
+func (l *genLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	l.cond.Signal()
 }
 
 // GetCertificate returns a TLS certificate for the given hostname.
@@ -63,36 +132,94 @@ func (c *CertCache) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate
 			return nil, fmt.Errorf("no server name in ClientHello")
 		}
 	}
+	return c.certificate(host)
+}
 
+// Warm generates certificates for hosts ahead of their first connection, so
+// the first handshake to each is a cache hit. Hosts already cached are left
+// alone. Only the first maxSize distinct hosts are warmed, so warming never
+// evicts a certificate it just made. Generations run concurrently, bounded
+// like on-demand ones; failures for individual hosts are joined.
+func (c *CertCache) Warm(hosts []string) error {
+	seen := make(map[string]bool, len(hosts))
+	var warm []string
+	for _, host := range hosts {
+		if host == "" || seen[host] {
+			continue
+		}
+		seen[host] = true
+		warm = append(warm, host)
+	}
+	if len(warm) > c.maxSize {
+		warm = warm[:c.maxSize]
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(warm))
+	for i, host := range warm {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = c.certificate(host)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// certificate returns the cached certificate for host, generating it if
+// needed.
+func (c *CertCache) certificate(host string) (*tls.Certificate, error) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
 
 	// Check cache
 	if entry, ok := c.cache[host]; ok {
 		// Move to end of LRU order (most recently used)
 		c.moveToEnd(host)
+		c.mu.Unlock()
 		return entry.cert, nil
 	}
 
-	// Generate new certificate
-	cert, err := c.generateCert(host)
-	if err != nil {
-		return nil, fmt.Errorf("generating certificate for %s: %w", host, err)
+	// Wait for a generation already in progress for this host
+	if call, ok := c.inflight[host]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.cert, call.err
 	}
 
-	// Evict if at capacity
-	if len(c.cache) >= c.maxSize {
-		c.evictOldest()
+	call := &certCall{done: make(chan struct{})}
+	c.inflight[host] = call
+	c.mu.Unlock()
+
+	// Generate new certificate without holding the lock
+	c.gen.acquire()
+	cert, err := c.generate(host)
+	c.gen.release()
+	if err != nil {
+		err = fmt.Errorf("generating certificate for %s: %w", host, err)
 	}
 
-	// Add to cache
-	c.cache[host] = &cacheEntry{
-		cert:      cert,
-		createdAt: time.Now(),
+	c.mu.Lock()
+	delete(c.inflight, host)
+	if err == nil {
+		// Evict if at capacity
+		if len(c.cache) >= c.maxSize {
+			c.evictOldest()
+		}
+
+		// Add to cache
+		c.cache[host] = &cacheEntry{
+			cert:      cert,
+			createdAt: time.Now(),
+		}
+		c.order = append(c.order, host)
 	}
-	c.order = append(c.order, host)
+	c.mu.Unlock()
+
+	call.cert, call.err = cert, err
+	close(call.done)
 
-	return cert, nil
+	return cert, err
 }
 
 // generateCert generates a TLS certificate for the given hostname.
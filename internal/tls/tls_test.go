@@ -4,11 +4,15 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
+	"fmt"
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // TestLoadOrCreateCA_CreatesNew tests that a new CA is created when none exists.
@@ -602,3 +606,237 @@ func TestCertCache_CRLDistributionPoint(t *testing.T) {
 		t.Errorf("unexpected CRL URL: got %q, want %q", leafCert.CRLDistributionPoints[0], crlURL)
 	}
 }
+
+// TestCertCache_SingleflightSameHost tests that concurrent requests for the
+// same new host generate only one certificate.
+func TestCertCache_SingleflightSameHost(t *testing.T) {
+	ca, err := LoadOrCreateCA(t.TempDir())
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA failed: %v", err)
+	}
+
+	cache := NewCertCache(ca, 100)
+	var generations atomic.Int32
+	release := make(chan struct{})
+	cache.generate = func(host string) (*tls.Certificate, error) {
+		generations.Add(1)
+		<-release
+		return cache.generateCert(host)
+	}
+
+	const callers = 20
+	var wg sync.WaitGroup
+	certs := make([]*tls.Certificate, callers)
+	errs := make([]error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			certs[i], errs[i] = cache.GetCertificate(mockClientHelloInfo("burst.example.com"))
+		}(i)
+	}
+
+	// Give every caller time to reach the cache before generation finishes
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := generations.Load(); n != 1 {
+		t.Errorf("generations = %d, want 1", n)
+	}
+	for i := range certs {
+		if errs[i] != nil {
+			t.Fatalf("caller %d: %v", i, errs[i])
+		}
+		if certs[i] != certs[0] {
+			t.Errorf("caller %d got a different certificate", i)
+		}
+	}
+	if cache.Size() != 1 {
+		t.Errorf("cache size = %d, want 1", cache.Size())
+	}
+}
+
+// TestCertCache_MaxConcurrentGenerations tests that generation for distinct
+// hosts is bounded by the configured limit.
+func TestCertCache_MaxConcurrentGenerations(t *testing.T) {
+	ca, err := LoadOrCreateCA(t.TempDir())
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA failed: %v", err)
+	}
+
+	cache := NewCertCache(ca, 100)
+	cache.SetMaxConcurrentGenerations(2)
+	var running, peak atomic.Int32
+	cache.generate = func(host string) (*tls.Certificate, error) {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		running.Add(-1)
+		return &tls.Certificate{}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			host := "host" + string(rune('0'+i)) + ".example.com"
+			if _, err := cache.GetCertificate(mockClientHelloInfo(host)); err != nil {
+				t.Errorf("GetCertificate(%s): %v", host, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if p := peak.Load(); p > 2 {
+		t.Errorf("peak concurrent generations = %d, want <= 2", p)
+	}
+	if cache.Size() != 10 {
+		t.Errorf("cache size = %d, want 10", cache.Size())
+	}
+}
+
+// TestCertCache_ResizeGenerationsInUse tests that the generation limit can
+// change while generations are running.
+func TestCertCache_ResizeGenerationsInUse(t *testing.T) {
+	ca, err := LoadOrCreateCA(t.TempDir())
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA failed: %v", err)
+	}
+
+	cache := NewCertCache(ca, 100)
+	cache.SetMaxConcurrentGenerations(1)
+	var running atomic.Int32
+	release := make(chan struct{}) // One send lets one generation finish
+	cache.generate = func(host string) (*tls.Certificate, error) {
+		running.Add(1)
+		<-release
+		running.Add(-1)
+		return &tls.Certificate{}, nil
+	}
+
+	var wg sync.WaitGroup
+	get := func(i int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			host := fmt.Sprintf("host%d.example.com", i)
+			if _, err := cache.GetCertificate(mockClientHelloInfo(host)); err != nil {
+				t.Errorf("GetCertificate(%s): %v", host, err)
+			}
+		}()
+	}
+	waitRunning := func(want int32) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for running.Load() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("running = %d, want %d", running.Load(), want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		get(i)
+	}
+	waitRunning(1)
+
+	// Raising the limit starts the waiting generations
+	cache.SetMaxConcurrentGenerations(3)
+	waitRunning(3)
+
+	// Lowering it holds new ones back until the running ones finish
+	cache.SetMaxConcurrentGenerations(1)
+	get(3)
+	get(4)
+	time.Sleep(20 * time.Millisecond)
+	if n := running.Load(); n != 3 {
+		t.Errorf("running after lowering the limit = %d, want 3", n)
+	}
+	for i := 0; i < 3; i++ {
+		release <- struct{}{}
+	}
+	waitRunning(1)
+	time.Sleep(20 * time.Millisecond)
+	if n := running.Load(); n != 1 {
+		t.Errorf("running under the lowered limit = %d, want 1", n)
+	}
+	release <- struct{}{}
+	release <- struct{}{}
+	wg.Wait()
+
+	if cache.Size() != 5 {
+		t.Errorf("cache size = %d, want 5", cache.Size())
+	}
+}
+
+// TestCertCache_Warm tests that warmed hosts are cache hits on their first
+// handshake and that warming stays within the cache size.
+func TestCertCache_Warm(t *testing.T) {
+	ca, err := LoadOrCreateCA(t.TempDir())
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA failed: %v", err)
+	}
+
+	cache := NewCertCache(ca, 3)
+	var generations atomic.Int32
+	cache.generate = func(host string) (*tls.Certificate, error) {
+		generations.Add(1)
+		if host == "broken.example.com" {
+			return nil, fmt.Errorf("no key")
+		}
+		return cache.generateCert(host)
+	}
+
+	hosts := []string{"api.anthropic.com", "api.openai.com", "api.anthropic.com", "", "llm.internal", "extra.example.com"}
+	if err := cache.Warm(hosts); err != nil {
+		t.Fatalf("Warm: %v", err)
+	}
+	if n := generations.Load(); n != 3 {
+		t.Errorf("generations = %d, want 3 (duplicates, empty and hosts past the size skipped)", n)
+	}
+	if cache.Size() != 3 {
+		t.Errorf("cache size = %d, want the max of 3", cache.Size())
+	}
+
+	for _, host := range []string{"api.anthropic.com", "api.openai.com", "llm.internal"} {
+		cert, err := cache.GetCertificate(mockClientHelloInfo(host))
+		if err != nil {
+			t.Fatalf("GetCertificate(%s): %v", host, err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatalf("parse leaf: %v", err)
+		}
+		if err := leaf.VerifyHostname(host); err != nil {
+			t.Errorf("warmed certificate for %s: %v", host, err)
+		}
+	}
+	if n := generations.Load(); n != 3 {
+		t.Errorf("generations after handshakes = %d, want 3 (all cache hits)", n)
+	}
+
+	// Failures are reported per host, and warming a cached host is a no-op
+	cache.Clear()
+	err = cache.Warm([]string{"ok.example.com", "broken.example.com"})
+	if err == nil || !strings.Contains(err.Error(), "broken.example.com") {
+		t.Errorf("Warm error = %v, want one naming broken.example.com", err)
+	}
+	if cache.Size() != 1 {
+		t.Errorf("cache size = %d, want only ok.example.com", cache.Size())
+	}
+	before := generations.Load()
+	if err := cache.Warm([]string{"ok.example.com"}); err != nil {
+		t.Fatalf("Warm: %v", err)
+	}
+	if generations.Load() != before {
+		t.Error("Warm regenerated a cached certificate")
+	}
+}
@@ -0,0 +1,97 @@
+package ws
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/HakAl/langley/internal/analytics"
+)
+
+// MessageTypeTaskUpdate carries a TaskUpdate.
+const MessageTypeTaskUpdate = "task_update"
+
+// defaultTaskUpdateDebounce is how long completions under a task are
+// collected before its totals are read and broadcast, so a burst of flows
+// sends one task_update instead of one each.
+const defaultTaskUpdateDebounce = 500 * time.Millisecond
+
+// TaskSummarizer reads a task's totals. analytics.Engine implements it.
+type TaskSummarizer interface {
+	GetTaskSummary(ctx context.Context, taskID string) (*analytics.TaskSummary, error)
+}
+
+// TaskUpdate is the data of a task_update message: the totals of a task
+// that flows just completed under, as in its TaskSummaryResponse.
+type TaskUpdate struct {
+	TaskID         string    `json:"task_id"`
+	FlowCount      int       `json:"flow_count"`
+	TotalTokensIn  int       `json:"total_tokens_in"`
+	TotalTokensOut int       `json:"total_tokens_out"`
+	TotalCost      float64   `json:"total_cost"`
+	LastSeen       time.Time `json:"last_seen"`
+}
+
+// WithTaskSummaries enables task_update messages, with totals read from
+// tasks after flows complete under a task.
+func WithTaskSummaries(tasks TaskSummarizer) HubOption {
+	return func(h *Hub) {
+		h.tasks = &taskUpdates{
+			hub:      h,
+			tasks:    tasks,
+			debounce: defaultTaskUpdateDebounce,
+			pending:  make(map[string]struct{}),
+		}
+	}
+}
+
+// taskUpdates debounces task_update messages per task.
+type taskUpdates struct {
+	hub      *Hub
+	tasks    TaskSummarizer
+	debounce time.Duration
+
+	mu      sync.Mutex
+	pending map[string]struct{} // Tasks with an update scheduled
+}
+
+// flowCompleted schedules an update for taskID unless one already is.
+func (t *taskUpdates) flowCompleted(taskID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.pending[taskID]; ok {
+		return
+	}
+	t.pending[taskID] = struct{}{}
+	time.AfterFunc(t.debounce, func() { t.send(taskID) })
+}
+
+// send reads taskID's totals and broadcasts them. Completions from here on
+// schedule the next update.
+func (t *taskUpdates) send(taskID string) {
+	t.mu.Lock()
+	delete(t.pending, taskID)
+	t.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	summary, err := t.tasks.GetTaskSummary(ctx, taskID)
+	if err != nil {
+		t.hub.logger.Warn("failed to read task totals", "task_id", taskID, "error", err)
+		return
+	}
+
+	t.hub.Broadcast(&Message{
+		Type:      MessageTypeTaskUpdate,
+		Timestamp: time.Now(),
+		Data: TaskUpdate{
+			TaskID:         summary.TaskID,
+			FlowCount:      summary.FlowCount,
+			TotalTokensIn:  summary.TotalTokensIn,
+			TotalTokensOut: summary.TotalTokensOut,
+			TotalCost:      summary.TotalCost,
+			LastSeen:       summary.LastSeen,
+		},
+	})
+}
@@ -0,0 +1,137 @@
+package ws
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/HakAl/langley/internal/analytics"
+	"github.com/HakAl/langley/internal/store"
+)
+
+func TestTaskUpdates(t *testing.T) {
+	cfg := testConfig()
+	st, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer st.Close()
+
+	hub := NewHub(cfg, slog.Default(), WithTaskSummaries(analytics.NewEngine(st.DB().(*sql.DB))))
+	hub.tasks.debounce = 50 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hub.Run(ctx)
+
+	server := httptest.NewServer(hub.Handler(cfg.Auth.Token))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer test-token"}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	waitFor := func(cond func() bool) {
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor(func() bool { return hub.ClientCount() == 1 })
+
+	n := 0
+	complete := func(taskID *string, in, out int, cost float64) {
+		t.Helper()
+		n++
+		status := 200
+		f := &store.Flow{ID: "flow-" + string(rune('a'+n)), Host: "api.anthropic.com", Method: "POST", Path: "/v1/messages",
+			Timestamp: time.Now(), FlowIntegrity: "complete", Provider: "anthropic", StatusCode: &status,
+			TaskID: taskID, InputTokens: &in, OutputTokens: &out, TotalCost: &cost}
+		if err := st.SaveFlow(context.Background(), f); err != nil {
+			t.Fatalf("SaveFlow: %v", err)
+		}
+		hub.BroadcastFlowComplete(f)
+	}
+
+	updateCh := make(chan TaskUpdate, 16)
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			for _, line := range strings.Split(string(data), "\n") {
+				var msg struct {
+					Type string     `json:"type"`
+					Data TaskUpdate `json:"data"`
+				}
+				if json.Unmarshal([]byte(line), &msg) == nil && msg.Type == MessageTypeTaskUpdate {
+					updateCh <- msg.Data
+				}
+			}
+		}
+	}()
+
+	// readUpdates collects task_update messages until want have arrived and
+	// a few debounce intervals pass without another
+	readUpdates := func(want int) []TaskUpdate {
+		t.Helper()
+		var updates []TaskUpdate
+		for {
+			wait := 2 * time.Second
+			if len(updates) >= want {
+				wait = 200 * time.Millisecond
+			}
+			select {
+			case u := <-updateCh:
+				updates = append(updates, u)
+			case <-time.After(wait):
+				if len(updates) < want {
+					t.Fatalf("got %d updates, want %d", len(updates), want)
+				}
+				return updates
+			}
+		}
+	}
+
+	taskA, taskB := "task-a", "task-b"
+	complete(&taskA, 100, 10, 0.01)
+	complete(&taskA, 200, 20, 0.02)
+	complete(&taskB, 50, 5, 0.005)
+	complete(nil, 1000, 100, 1) // No task, no update
+	complete(&taskA, 300, 30, 0.03)
+
+	// One update per task for the burst, with the totals so far
+	updates := readUpdates(2)
+	if len(updates) != 2 {
+		t.Fatalf("got %d updates for the burst, want 2: %+v", len(updates), updates)
+	}
+	byTask := map[string]TaskUpdate{}
+	for _, u := range updates {
+		byTask[u.TaskID] = u
+	}
+	if a := byTask[taskA]; a.FlowCount != 3 || a.TotalTokensIn != 600 || a.TotalTokensOut != 60 || a.TotalCost < 0.0599 || a.TotalCost > 0.0601 {
+		t.Errorf("task-a update = %+v, want 3 flows, 600/60 tokens, $0.06", a)
+	}
+	if b := byTask[taskB]; b.FlowCount != 1 || b.TotalTokensIn != 50 || b.TotalTokensOut != 5 {
+		t.Errorf("task-b update = %+v, want 1 flow, 50/5 tokens", b)
+	}
+
+	// A later completion sends the new totals
+	complete(&taskA, 400, 40, 0.04)
+	updates = readUpdates(1)
+	if len(updates) != 1 || updates[0].TaskID != taskA || updates[0].FlowCount != 4 || updates[0].TotalTokensIn != 1000 {
+		t.Errorf("updates after another flow = %+v, want task-a with 4 flows, 1000 tokens in", updates)
+	}
+}
@@ -2,10 +2,13 @@
 package ws
 
 import (
+	"bytes"
 	"context"
 	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"log/slog"
+	"net"
 	"net/http"
 	"strings"
 	"sync"
@@ -13,6 +16,7 @@ import (
 
 	"github.com/gorilla/websocket"
 
+	"github.com/HakAl/langley/internal/analytics"
 	"github.com/HakAl/langley/internal/config"
 	"github.com/HakAl/langley/internal/store"
 )
@@ -20,6 +24,33 @@ import (
 // sessionCookieName must match the cookie name used in api package.
 const sessionCookieName = "langley_session"
 
+// Browsers can't set headers on a WebSocket upgrade, so clients may pass the
+// token as a subprotocol instead: new WebSocket(url, ["langley", "bearer." + token]).
+const (
+	subprotocol       = "langley"
+	bearerProtocolPfx = "bearer."
+)
+
+// subprotocolToken returns the token offered as a "bearer.<token>"
+// subprotocol, if any, and the subprotocol to accept in the response
+// (empty if none was offered). The plain "langley" protocol is preferred so
+// the token isn't echoed back.
+func subprotocolToken(r *http.Request) (token, accept string, ok bool) {
+	offered := false
+	for _, p := range websocket.Subprotocols(r) {
+		switch {
+		case p == subprotocol:
+			offered = true
+		case strings.HasPrefix(p, bearerProtocolPfx) && !ok:
+			token, accept, ok = strings.TrimPrefix(p, bearerProtocolPfx), p, true
+		}
+	}
+	if offered {
+		accept = subprotocol
+	}
+	return token, accept, ok
+}
+
 // isLocalhostOrigin checks if the Origin header indicates a localhost request.
 func isLocalhostOrigin(origin string) bool {
 	return strings.HasPrefix(origin, "http://localhost") ||
@@ -47,22 +78,65 @@ type Hub struct {
 	register  chan *Client
 	unregister chan *Client
 	mu        sync.RWMutex
+
+	subsMu sync.Mutex
+	subs   map[string]map[chan *store.Event]struct{} // Event subscribers by flow ID
+
+	flows store.Store // Source of flows to replay, nil to disable ?since=
+	tasks *taskUpdates // Nil unless WithTaskSummaries
 }
 
+// HubOption configures a Hub.
+type HubOption func(*Hub)
+
+// WithFlowStore lets clients that connect with ?since= catch up on the
+// flows they missed, read from s.
+func WithFlowStore(s store.Store) HubOption {
+	return func(h *Hub) {
+		h.flows = s
+	}
+}
+
+// Keepalive defaults, used when api.ws_ping_interval and api.ws_pong_timeout
+// are unset.
+const (
+	defaultPingInterval = 30 * time.Second
+	defaultPongTimeout  = 10 * time.Second
+)
+
+// eventSubscriberBuffer is how far an event subscriber may fall behind
+// before it is dropped.
+const eventSubscriberBuffer = 1024
+
+// replayLimit caps how many missed flows a reconnecting client is sent; past
+// it only the newest are replayed.
+const replayLimit = 500
+
 // Client represents a WebSocket client connection.
 type Client struct {
 	hub  *Hub
 	conn *websocket.Conn
 	send chan []byte
+
+	pingInterval time.Duration
+	pongTimeout  time.Duration
+
+	// replayed holds the flows sent on connect. Their live messages, queued
+	// while the replay was read, are skipped. Only writePump touches it
+	// once started.
+	replayed map[string]struct{}
 }
 
 // Message types for WebSocket communication.
 const (
-	MessageTypeFlowStart   = "flow_start"
-	MessageTypeFlowUpdate  = "flow_update"
-	MessageTypeFlowComplete = "flow_complete"
-	MessageTypeEvent       = "event"
-	MessageTypePing        = "ping"
+	MessageTypeFlowStart     = "flow_start"
+	MessageTypeFlowUpdate    = "flow_update"
+	MessageTypeFlowComplete  = "flow_complete"
+	MessageTypeEvent         = "event"
+	MessageTypePing          = "ping"
+	MessageTypeBudgetAlert   = "budget_alert"
+	MessageTypeCaptureHealth = "capture_health"
+	MessageTypeFlowNotes     = "flow_notes"
 )
 
 // Message is a WebSocket message.
@@ -73,19 +147,24 @@ type Message struct {
 }
 
 // NewHub creates a new WebSocket hub.
-func NewHub(cfg *config.Config, logger *slog.Logger) *Hub {
+func NewHub(cfg *config.Config, logger *slog.Logger, opts ...HubOption) *Hub {
 	if logger == nil {
 		logger = slog.Default()
 	}
 
-	return &Hub{
+	h := &Hub{
 		cfg:        cfg,
 		logger:     logger,
 		clients:    make(map[*Client]bool),
 		broadcast:  make(chan *Message, 256),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		subs:       make(map[string]map[chan *store.Event]struct{}),
+	}
+	for _, opt := range opts {
+		opt(h)
 	}
+	return h
 }
 
 // Run starts the hub's main loop.
@@ -189,22 +268,133 @@ func (h *Hub) BroadcastFlowUpdate(flow *store.Flow) {
 	})
 }
 
-// BroadcastFlowComplete broadcasts a flow completion event.
+// BroadcastFlowComplete broadcasts a flow completion event and ends the
+// flow's event subscriptions. A flow under a task also schedules a
+// task_update, with WithTaskSummaries.
 func (h *Hub) BroadcastFlowComplete(flow *store.Flow) {
 	h.Broadcast(&Message{
 		Type:      MessageTypeFlowComplete,
 		Timestamp: time.Now(),
 		Data:      flowToSummary(flow),
 	})
+	h.closeSubscribers(flow.ID)
+	if h.tasks != nil && flow.TaskID != nil {
+		h.tasks.flowCompleted(*flow.TaskID)
+	}
 }
 
-// BroadcastEvent broadcasts an SSE event.
+// BroadcastEvent broadcasts an SSE event, and delivers it to subscribers
+// of its flow.
 func (h *Hub) BroadcastEvent(event *store.Event) {
 	h.Broadcast(&Message{
 		Type:      MessageTypeEvent,
 		Timestamp: time.Now(),
 		Data:      event,
 	})
+	h.publishEvent(event)
+}
+
+// SubscribeEvents returns a channel receiving the SSE events broadcast for
+// flowID, and a function that cancels the subscription. The channel is
+// closed when the flow completes, when the subscriber falls too far behind,
+// or on cancel.
+func (h *Hub) SubscribeEvents(flowID string) (<-chan *store.Event, func()) {
+	ch := make(chan *store.Event, eventSubscriberBuffer)
+
+	h.subsMu.Lock()
+	if h.subs[flowID] == nil {
+		h.subs[flowID] = make(map[chan *store.Event]struct{})
+	}
+	h.subs[flowID][ch] = struct{}{}
+	h.subsMu.Unlock()
+
+	return ch, func() {
+		h.subsMu.Lock()
+		defer h.subsMu.Unlock()
+		h.removeSubscriber(flowID, ch)
+	}
+}
+
+// publishEvent delivers event to its flow's subscribers. Subscribers that
+// can't keep up are dropped rather than block the proxy.
+func (h *Hub) publishEvent(event *store.Event) {
+	h.subsMu.Lock()
+	defer h.subsMu.Unlock()
+
+	for ch := range h.subs[event.FlowID] {
+		select {
+		case ch <- event:
+		default:
+			h.logger.Warn("event subscriber too slow, dropping", "flow_id", event.FlowID)
+			h.removeSubscriber(event.FlowID, ch)
+		}
+	}
+}
+
+// closeSubscribers ends every subscription to flowID's events.
+func (h *Hub) closeSubscribers(flowID string) {
+	h.subsMu.Lock()
+	defer h.subsMu.Unlock()
+
+	for ch := range h.subs[flowID] {
+		h.removeSubscriber(flowID, ch)
+	}
+}
+
+// removeSubscriber closes and forgets ch. Must be called with subsMu held.
+func (h *Hub) removeSubscriber(flowID string, ch chan *store.Event) {
+	if _, ok := h.subs[flowID][ch]; !ok {
+		return
+	}
+	delete(h.subs[flowID], ch)
+	close(ch)
+	if len(h.subs[flowID]) == 0 {
+		delete(h.subs, flowID)
+	}
+}
+
+// BroadcastBudgetAlert broadcasts that the daily budget has been crossed.
+func (h *Hub) BroadcastBudgetAlert(alert *analytics.BudgetAlert) {
+	h.Broadcast(&Message{
+		Type:      MessageTypeBudgetAlert,
+		Timestamp: time.Now(),
+		Data:      alert,
+	})
+}
+
+// BroadcastCaptureHealth broadcasts a change in flow capture health, both
+// when failures cross a threshold and when capture recovers.
+func (h *Hub) BroadcastCaptureHealth(health store.CaptureHealth) {
+	h.Broadcast(&Message{
+		Type:      MessageTypeCaptureHealth,
+		Timestamp: time.Now(),
+		Data:      health,
+	})
+}
+
+// BroadcastFlowNotes broadcasts that a flow's notes were set or, with nil
+// notes, cleared.
+func (h *Hub) BroadcastFlowNotes(flowID string, notes *string) {
+	h.Broadcast(&Message{
+		Type:      MessageTypeFlowNotes,
+		Timestamp: time.Now(),
+		Data:      map[string]interface{}{"id": flowID, "notes": notes},
+	})
+}
+
+// keepalive returns the WebSocket ping interval and pong timeout, from
+// config when set.
+func (h *Hub) keepalive() (interval, timeout time.Duration) {
+	interval, timeout = defaultPingInterval, defaultPongTimeout
+	if h.cfg != nil {
+		if h.cfg.API.WSPingInterval > 0 {
+			interval = h.cfg.API.WSPingInterval
+		}
+		if h.cfg.API.WSPongTimeout > 0 {
+			timeout = h.cfg.API.WSPongTimeout
+		}
+	}
+	return interval, timeout
 }
 
 // ClientCount returns the number of connected clients.
@@ -216,48 +406,63 @@ func (h *Hub) ClientCount() int {
 
 // Handler returns an HTTP handler for WebSocket connections.
 // Uses constant-time comparison to prevent timing attacks.
-// NOTE: Token is read from h.cfg.Auth.Token to support hot-reload.
+// NOTE: Tokens are read from h.cfg.Auth to support hot-reload. Any token
+// in auth.tokens is accepted; the feed is read-only so scope doesn't matter.
 //
 // Authentication modes (checked in order):
 // 1. Session cookie - browser sends automatically
 // 2. Authorization header - for CLI
-// 3. Token query param - for CLI (WebSocket can't set headers easily)
+// 3. "bearer.<token>" subprotocol - for clients that can't set headers
+// 4. Token query param - deprecated, the token ends up in access logs
+//
+// A client reconnecting after a drop can pass ?since=<timestamp>, the
+// RFC 3339 timestamp of the newest flow it has, to be sent the flows that
+// completed after it before live updates resume.
 func (h *Hub) Handler(authToken string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Read current token from config (supports hot-reload)
-		currentToken := authToken
-		if h.cfg != nil {
-			currentToken = h.cfg.Auth.Token
+		// Read current tokens from config (supports hot-reload)
+		valid := func(token string) bool {
+			if h.cfg == nil {
+				return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(authToken)) == 1
+			}
+			_, ok := h.cfg.Auth.Authenticate(token)
+			return ok
 		}
 
 		authenticated := false
 
 		// 1. Check session cookie first
 		cookie, err := r.Cookie(sessionCookieName)
-		if err == nil && subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(currentToken)) == 1 {
+		if err == nil && valid(cookie.Value) {
 			authenticated = true
 		}
 
 		// 2. Check Authorization header
 		if !authenticated {
-			auth := r.Header.Get("Authorization")
-			expectedAuth := "Bearer " + currentToken
-			if subtle.ConstantTimeCompare([]byte(auth), []byte(expectedAuth)) == 1 {
+			if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && valid(bearer) {
 				authenticated = true
 			}
 		}
 
-		// 3. Check token query param (for CLI tools that can't set headers)
+		// 3. Check Sec-WebSocket-Protocol (browser-compatible header auth)
+		token, accept, ok := subprotocolToken(r)
+		if !authenticated && ok && valid(token) {
+			authenticated = true
+		}
+
+		// 4. Check token query param (deprecated)
 		if !authenticated {
-			token := r.URL.Query().Get("token")
-			if subtle.ConstantTimeCompare([]byte(token), []byte(currentToken)) == 1 {
+			queryToken := r.URL.Query().Get("token")
+			if valid(queryToken) {
 				authenticated = true
+				h.logger.Warn("WebSocket token query parameter is deprecated, pass it as a \"bearer.<token>\" Sec-WebSocket-Protocol instead",
+					"remote_addr", r.RemoteAddr)
 			}
 		}
 
 		// Validate Origin if present (security check)
 		origin := r.Header.Get("Origin")
-		if origin != "" && !isLocalhostOrigin(origin) {
+		if origin != "" && !isLocalhostOrigin(origin) && !h.cfg.API.AllowsOrigin(origin) {
 			h.logger.Warn("rejected non-localhost WebSocket origin", "origin", origin)
 			http.Error(w, "Forbidden: non-localhost origin", http.StatusForbidden)
 			return
@@ -268,7 +473,25 @@ func (h *Hub) Handler(authToken string) http.HandlerFunc {
 			return
 		}
 
-		conn, err := upgrader.Upgrade(w, r, nil)
+		var since *time.Time
+		if v := r.URL.Query().Get("since"); v != "" {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				http.Error(w, "Invalid since: want an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			since = &t
+		}
+
+		// The origin was checked above, including api.cors_origins
+		up := upgrader
+		up.CheckOrigin = func(*http.Request) bool { return true }
+		var responseHeader http.Header
+		if accept != "" {
+			// Clients that offer subprotocols require one to be selected
+			responseHeader = http.Header{"Sec-WebSocket-Protocol": {accept}}
+		}
+		conn, err := up.Upgrade(w, r, responseHeader)
 		if err != nil {
 			h.logger.Error("failed to upgrade connection", "error", err)
 			return
@@ -279,18 +502,28 @@ func (h *Hub) Handler(authToken string) http.HandlerFunc {
 			conn: conn,
 			send: make(chan []byte, 256),
 		}
+		client.pingInterval, client.pongTimeout = h.keepalive()
 
 		h.register <- client
 
+		// Registered first, so a flow completing while the replay is read
+		// is either in it or queued live, never missed
+		if since != nil && h.flows != nil {
+			ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+			client.replay(ctx, *since)
+			cancel()
+		}
+
 		// Start client goroutines
 		go client.writePump()
 		go client.readPump()
 	}
 }
 
-// writePump pumps messages from the hub to the websocket connection.
+// writePump pumps messages from the hub to the websocket connection, and
+// pings the client every pingInterval.
 func (c *Client) writePump() {
-	ticker := time.NewTicker(54 * time.Second)
+	ticker := time.NewTicker(c.pingInterval)
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
@@ -305,6 +538,10 @@ func (c *Client) writePump() {
 				return
 			}
 
+			if c.alreadyReplayed(message) {
+				continue
+			}
+
 			w, err := c.conn.NextWriter(websocket.TextMessage)
 			if err != nil {
 				return
@@ -314,8 +551,12 @@ func (c *Client) writePump() {
 			// Batch any queued messages
 			n := len(c.send)
 			for i := 0; i < n; i++ {
+				next := <-c.send
+				if c.alreadyReplayed(next) {
+					continue
+				}
 				_, _ = w.Write([]byte{'\n'})
-				_, _ = w.Write(<-c.send)
+				_, _ = w.Write(next)
 			}
 
 			if err := w.Close(); err != nil {
@@ -331,24 +572,96 @@ func (c *Client) writePump() {
 	}
 }
 
-// readPump pumps messages from the websocket connection to the hub.
+// replay sends the flows that completed after since, oldest first, and
+// records them in replayed. A flow that was streaming when the client went
+// away started before since, so flows are picked by when they finished. It
+// writes to the connection directly, so it must finish before writePump
+// starts.
+func (c *Client) replay(ctx context.Context, since time.Time) {
+	flows, err := c.hub.flows.ListFlows(ctx, store.FlowFilter{CompletedAfter: &since, Limit: replayLimit})
+	if err != nil {
+		c.hub.logger.Warn("failed to load flows to replay", "error", err)
+		return
+	}
+
+	c.replayed = make(map[string]struct{}, len(flows))
+	for i := len(flows) - 1; i >= 0; i-- {
+		f := flows[i]
+		if f.StatusCode == nil {
+			continue
+		}
+		data, err := json.Marshal(&Message{
+			Type:      MessageTypeFlowComplete,
+			Timestamp: time.Now(),
+			Data:      flowToSummary(f),
+		})
+		if err != nil {
+			continue
+		}
+		_ = c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			return
+		}
+		c.replayed[f.ID] = struct{}{}
+	}
+}
+
+// alreadyReplayed reports whether message is a live flow_start, flow_update
+// or flow_complete for a flow the client was replayed on connect. The
+// replayed copy is final, so these would only repeat or regress it.
+func (c *Client) alreadyReplayed(message []byte) bool {
+	if len(c.replayed) == 0 || !bytes.HasPrefix(message, []byte(`{"type":"flow_`)) {
+		return false
+	}
+	var m struct {
+		Type string `json:"type"`
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if json.Unmarshal(message, &m) != nil {
+		return false
+	}
+	switch m.Type {
+	case MessageTypeFlowStart, MessageTypeFlowUpdate, MessageTypeFlowComplete:
+	default:
+		return false
+	}
+	if _, ok := c.replayed[m.Data.ID]; !ok {
+		return false
+	}
+	if m.Type == MessageTypeFlowComplete {
+		delete(c.replayed, m.Data.ID)
+	}
+	return true
+}
+
+// readPump pumps messages from the websocket connection to the hub. The
+// read deadline is pushed back on every pong; a client that misses one for
+// longer than pongTimeout after the next ping is due times out and is
+// unregistered.
 func (c *Client) readPump() {
 	defer func() {
 		c.hub.unregister <- c
 		c.conn.Close()
 	}()
 
+	pongWait := c.pingInterval + c.pongTimeout
 	c.conn.SetReadLimit(512)
-	_ = c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	_ = c.conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.conn.SetPongHandler(func(string) error {
-		_ = c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		_ = c.conn.SetReadDeadline(time.Now().Add(pongWait))
 		return nil
 	})
 
 	for {
 		_, _, err := c.conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				c.hub.logger.Debug("websocket client stopped answering pings, disconnecting",
+					"remote_addr", c.conn.RemoteAddr().String())
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				c.hub.logger.Debug("websocket error", "error", err)
 			}
 			break
@@ -1,14 +1,22 @@
 package ws
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
+
 	"github.com/HakAl/langley/internal/config"
 	"github.com/HakAl/langley/internal/store"
+	"github.com/HakAl/langley/internal/testutil"
 )
 
 func testConfig() *config.Config {
@@ -107,6 +115,38 @@ func TestBroadcastEvent(t *testing.T) {
 	hub.BroadcastEvent(event)
 }
 
+// TestSubscribeEvents verifies that subscribers receive only their flow's
+// events and that the subscription ends when the flow completes.
+func TestSubscribeEvents(t *testing.T) {
+	hub := NewHub(testConfig(), slog.Default())
+
+	events, cancelSub := hub.SubscribeEvents("flow-1")
+	defer cancelSub()
+	other, cancelOther := hub.SubscribeEvents("flow-2")
+
+	hub.BroadcastEvent(&store.Event{FlowID: "flow-1", Sequence: 0, EventType: "message_start"})
+	hub.BroadcastEvent(&store.Event{FlowID: "flow-2", Sequence: 0, EventType: "message_start"})
+	hub.BroadcastEvent(&store.Event{FlowID: "flow-1", Sequence: 1, EventType: "message_stop"})
+	hub.BroadcastFlowComplete(&store.Flow{ID: "flow-1"})
+
+	var got []string
+	for e := range events { // Closed by BroadcastFlowComplete
+		got = append(got, e.EventType)
+	}
+	if strings.Join(got, ",") != "message_start,message_stop" {
+		t.Errorf("flow-1 events = %v", got)
+	}
+
+	if e := <-other; e.FlowID != "flow-2" {
+		t.Errorf("flow-2 subscriber got event for %s", e.FlowID)
+	}
+	cancelOther()
+	cancelOther() // Cancelling twice is harmless
+	if _, ok := <-other; ok {
+		t.Error("flow-2 channel still open after cancel")
+	}
+}
+
 // TestConcurrentBroadcast verifies no race condition when broadcasting
 // while clients connect/disconnect.
 func TestConcurrentBroadcast(t *testing.T) {
@@ -375,3 +415,223 @@ func BenchmarkBroadcast(b *testing.B) {
 		hub.Broadcast(msg)
 	}
 }
+
+func TestHandlerAuth(t *testing.T) {
+	cfg := testConfig()
+	var logs bytes.Buffer
+	hub := NewHub(cfg, slog.New(slog.NewTextHandler(&logs, nil)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hub.Run(ctx)
+
+	server := httptest.NewServer(hub.Handler(cfg.Auth.Token))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	tests := []struct {
+		name         string
+		query        string
+		header       http.Header
+		protocols    []string
+		wantStatus   int
+		wantProtocol string
+	}{
+		{name: "authorization header", header: http.Header{"Authorization": {"Bearer test-token"}},
+			wantStatus: http.StatusSwitchingProtocols},
+		{name: "subprotocol", protocols: []string{"langley", "bearer.test-token"},
+			wantStatus: http.StatusSwitchingProtocols, wantProtocol: "langley"},
+		{name: "subprotocol only token", protocols: []string{"bearer.test-token"},
+			wantStatus: http.StatusSwitchingProtocols, wantProtocol: "bearer.test-token"},
+		{name: "query param", query: "?token=test-token",
+			wantStatus: http.StatusSwitchingProtocols},
+		{name: "bad subprotocol token", protocols: []string{"langley", "bearer.wrong"},
+			wantStatus: http.StatusUnauthorized},
+		{name: "bad query token", query: "?token=wrong",
+			wantStatus: http.StatusUnauthorized},
+		{name: "no token", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := websocket.Dialer{Subprotocols: tt.protocols}
+			conn, resp, err := dialer.Dial(wsURL+tt.query, tt.header)
+			if resp == nil {
+				t.Fatalf("dial failed without response: %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (err %v)", resp.StatusCode, tt.wantStatus, err)
+			}
+			if conn == nil {
+				return
+			}
+			defer conn.Close()
+			if got := conn.Subprotocol(); got != tt.wantProtocol {
+				t.Errorf("accepted subprotocol = %q, want %q", got, tt.wantProtocol)
+			}
+		})
+	}
+
+	if got := strings.Count(logs.String(), "query parameter is deprecated"); got != 1 {
+		t.Errorf("logged %d deprecation warnings, want 1 (for the query param connection)", got)
+	}
+}
+
+func TestHandlerReapsClientsThatStopAnsweringPings(t *testing.T) {
+	cfg := testConfig()
+	cfg.API.WSPingInterval = 50 * time.Millisecond
+	cfg.API.WSPongTimeout = 100 * time.Millisecond
+	hub := NewHub(cfg, slog.Default())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hub.Run(ctx)
+
+	server := httptest.NewServer(hub.Handler(cfg.Auth.Token))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	header := http.Header{"Authorization": {"Bearer test-token"}}
+
+	// Both clients keep reading; only the healthy one answers pings
+	dial := func(answerPings bool) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, header)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		if !answerPings {
+			conn.SetPingHandler(func(string) error { return nil })
+		}
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		return conn
+	}
+	healthy := dial(true)
+	defer healthy.Close()
+	stale := dial(false)
+	defer stale.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for hub.ClientCount() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("clients = %d, want 2 registered", hub.ClientCount())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Reaped after a ping interval plus the pong timeout, with some slack
+	start := time.Now()
+	for hub.ClientCount() != 1 {
+		if time.Since(start) > time.Second {
+			t.Fatalf("clients = %d, want the stale one reaped", hub.ClientCount())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The healthy client outlives several more ping intervals
+	time.Sleep(300 * time.Millisecond)
+	if n := hub.ClientCount(); n != 1 {
+		t.Errorf("clients = %d, want the healthy one still connected", n)
+	}
+}
+
+func TestHandlerReplaysFlowsSince(t *testing.T) {
+	cfg := testConfig()
+	st, err := store.NewSQLiteStore(":memory:", &cfg.Retention)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer st.Close()
+
+	since := time.Date(2026, 5, 6, 12, 0, 0, 0, time.UTC)
+	flow := func(id string, at time.Duration, b *testutil.FlowBuilder) *store.Flow {
+		f := b.WithID(id).WithProvider("anthropic").Build()
+		f.Timestamp = since.Add(at)
+		return f
+	}
+	// A flow counts as missed when it completed after the cursor, whenever
+	// it started
+	streaming := flow("streaming", -5*time.Second, testutil.NewFlow().Streaming().WithDuration(10000))
+	missed1 := flow("missed-1", time.Second, testutil.NewFlow().WithStatus(200))
+	missed2 := flow("missed-2", 2*time.Second, testutil.NewFlow().WithStatus(200))
+	inFlight := flow("in-flight", 3*time.Second, testutil.NewFlow())
+	inFlight.StatusCode, inFlight.DurationMs = nil, nil
+	for _, f := range []*store.Flow{
+		flow("seen", -2*time.Second, testutil.NewFlow().WithDuration(1000)),
+		flow("last-seen", -time.Second, testutil.NewFlow().WithDuration(1000)), // Completed at the cursor
+		streaming,
+		missed2,
+		missed1,
+		inFlight,
+	} {
+		if err := st.SaveFlow(context.Background(), f); err != nil {
+			t.Fatalf("SaveFlow(%s): %v", f.ID, err)
+		}
+	}
+
+	hub := NewHub(cfg, slog.Default(), WithFlowStore(st))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hub.Run(ctx)
+
+	server := httptest.NewServer(hub.Handler(cfg.Auth.Token))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	header := http.Header{"Authorization": {"Bearer test-token"}}
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL+"?since=yesterday", header)
+	if resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bad since: resp %v, err %v, want 400", resp, err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?since="+since.Format(time.RFC3339Nano), header)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	var got []string
+	read := func(n int) {
+		t.Helper()
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for len(got) < n {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatalf("read after %v: %v", got, err)
+			}
+			for _, line := range strings.Split(string(data), "\n") {
+				var msg struct {
+					Type string `json:"type"`
+					Data struct {
+						ID string `json:"id"`
+					} `json:"data"`
+				}
+				if err := json.Unmarshal([]byte(line), &msg); err != nil {
+					t.Fatalf("unmarshal %q: %v", line, err)
+				}
+				if msg.Type != MessageTypePing {
+					got = append(got, msg.Type+" "+msg.Data.ID)
+				}
+			}
+		}
+	}
+
+	// The backlog arrives oldest first, once the client is registered
+	read(3)
+
+	// Live messages for replayed flows, as if queued during the replay, are
+	// skipped; the rest follow the backlog
+	hub.BroadcastFlowUpdate(missed1)
+	hub.BroadcastFlowComplete(missed2)
+	live := flow("live", 4*time.Second, testutil.NewFlow().WithStatus(200))
+	hub.BroadcastFlowStart(live)
+	hub.BroadcastFlowComplete(live)
+	read(5)
+
+	want := []string{"flow_complete streaming", "flow_complete missed-1", "flow_complete missed-2", "flow_start live", "flow_complete live"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("messages = %v, want %v", got, want)
+	}
+}
@@ -94,6 +94,8 @@ func TestE2E_DirectHandler(t *testing.T) {
 	cfg := &config.Config{
 		Proxy: config.ProxyConfig{
 			Listen: "127.0.0.1:0",
+			// The mock provider is a loopback server, not a known provider host
+			InterceptHosts: []string{"127.0.0.1"},
 		},
 		Persistence: config.PersistenceConfig{
 			DBPath:       dbPath,
@@ -288,6 +290,7 @@ func TestE2E_SSEStreaming(t *testing.T) {
 	certCache := langleytls.NewCertCache(ca, 100)
 
 	cfg := &config.Config{
+		Proxy:       config.ProxyConfig{InterceptHosts: []string{"127.0.0.1"}},
 		Persistence: config.PersistenceConfig{BodyMaxBytes: 1024 * 1024},
 		Redaction:   config.RedactionConfig{RedactAPIKeys: true},
 	}
@@ -390,6 +393,7 @@ func TestE2E_TaskAssignment(t *testing.T) {
 	certCache := langleytls.NewCertCache(ca, 100)
 
 	cfg := &config.Config{
+		Proxy:       config.ProxyConfig{InterceptHosts: []string{"127.0.0.1"}},
 		Persistence: config.PersistenceConfig{BodyMaxBytes: 1024 * 1024},
 		Redaction:   config.RedactionConfig{RedactAPIKeys: true},
 	}
@@ -520,6 +524,7 @@ func TestE2E_NonStreamingToolUse_Anthropic(t *testing.T) {
 	certCache := langleytls.NewCertCache(ca, 100)
 
 	cfg := &config.Config{
+		Proxy:       config.ProxyConfig{InterceptHosts: []string{"127.0.0.1"}},
 		Persistence: config.PersistenceConfig{BodyMaxBytes: 1024 * 1024},
 		Redaction:   config.RedactionConfig{RedactAPIKeys: true},
 	}
@@ -657,6 +662,7 @@ func TestE2E_NonStreamingToolUse_OpenAI(t *testing.T) {
 	certCache := langleytls.NewCertCache(ca, 100)
 
 	cfg := &config.Config{
+		Proxy:       config.ProxyConfig{InterceptHosts: []string{"127.0.0.1"}},
 		Persistence: config.PersistenceConfig{BodyMaxBytes: 1024 * 1024},
 		Redaction:   config.RedactionConfig{RedactAPIKeys: true},
 	}
@@ -753,6 +759,7 @@ func TestE2E_ErrorHandling(t *testing.T) {
 	certCache := langleytls.NewCertCache(ca, 100)
 
 	cfg := &config.Config{
+		Proxy:       config.ProxyConfig{InterceptHosts: []string{"127.0.0.1"}},
 		Persistence: config.PersistenceConfig{BodyMaxBytes: 1024 * 1024},
 		Redaction:   config.RedactionConfig{RedactAPIKeys: true},
 	}
@@ -828,6 +835,7 @@ func TestE2E_Redaction(t *testing.T) {
 	certCache := langleytls.NewCertCache(ca, 100)
 
 	cfg := &config.Config{
+		Proxy:       config.ProxyConfig{InterceptHosts: []string{"127.0.0.1"}},
 		Persistence: config.PersistenceConfig{BodyMaxBytes: 1024 * 1024},
 		Redaction: config.RedactionConfig{
 			RedactAPIKeys:       true,
@@ -1,13 +1,18 @@
 import { useCallback } from 'react'
-import type { Anomaly, ApiResult, CostPeriod, Flow, Settings, Stats, TaskSummary, ToolInvocation, ToolStats } from '../types'
+import type { Anomaly, ApiError, ApiResult, CostPeriod, Flow, Settings, Stats, TaskSummary, ToolInvocation, ToolStats } from '../types'
+
+// errorMessage reads the message from an API error response
+async function errorMessage(res: Response): Promise<string> {
+  const body = await res.json().catch(() => null) as ApiError | null
+  return body?.error?.message || `HTTP ${res.status}`
+}
 
 export function useApi() {
   const apiFetch = useCallback(async <T,>(path: string): Promise<ApiResult<T>> => {
     try {
       const res = await fetch(path, { credentials: 'include' })
       if (res.ok) return { data: await res.json(), error: null }
-      const text = await res.text().catch(() => '')
-      return { data: null, error: text || `HTTP ${res.status}` }
+      return { data: null, error: await errorMessage(res) }
     } catch (err) {
       return { data: null, error: err instanceof Error ? err.message : 'Network error' }
     }
@@ -63,14 +68,13 @@ export function useApi() {
   const updateSettings = useCallback(async (newSettings: Partial<Settings>): Promise<ApiResult<Settings>> => {
     try {
       const res = await fetch('/api/settings', {
-        method: 'PUT',
+        method: 'PATCH',
         headers: { 'Content-Type': 'application/json' },
         credentials: 'include',
         body: JSON.stringify(newSettings)
       })
       if (res.ok) return { data: await res.json(), error: null }
-      const text = await res.text().catch(() => '')
-      return { data: null, error: text || `HTTP ${res.status}` }
+      return { data: null, error: await errorMessage(res) }
     } catch (err) {
       return { data: null, error: err instanceof Error ? err.message : 'Network error' }
     }
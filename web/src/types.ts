@@ -7,6 +7,8 @@ export interface Flow {
   status_code?: number
   status_text?: string
   is_sse: boolean
+  is_websocket?: boolean
+  stream_mismatch?: boolean
   timestamp: string
   duration_ms?: number
   task_id?: string
@@ -17,16 +19,38 @@ export interface Flow {
   cache_creation_tokens?: number
   cache_read_tokens?: number
   total_cost?: number
+  event_count?: number
+  stored_body_bytes?: number
+  request_body_size?: number
+  response_body_size?: number
   cost_source?: string
   provider?: string
   flow_integrity?: string
   events_dropped_count?: number
+  events_skipped_count?: number
   request_body?: string
   response_body?: string
   request_body_truncated?: boolean
+  request_body_invalid?: boolean
   response_body_truncated?: boolean
   request_headers?: Record<string, string[]>
   response_headers?: Record<string, string[]>
+  rate_limit?: RateLimit
+  cache_breakpoints?: number
+  cache_breakpoint_positions?: string[]
+  request_signature?: string
+  stop_reason?: string
+  error_type?: string
+  error_message?: string
+  notes?: string
+}
+
+export interface RateLimit {
+  requests_limit?: number
+  requests_remaining?: number
+  tokens_limit?: number
+  tokens_remaining?: number
+  reset?: string
 }
 
 export interface WSMessage {
@@ -45,6 +69,30 @@ export interface Stats {
   total_tasks: number
   total_tool_calls: number
   avg_cost_per_flow: number
+  by_provider: StatsFacet[]
+  by_model: StatsFacet[]
+  fallback?: boolean
+}
+
+export interface Facets {
+  hosts: FacetValue[]
+  models: FacetValue[]
+  providers: FacetValue[]
+  tasks: FacetValue[]
+}
+
+export interface FacetValue {
+  value: string
+  count: number
+  last_seen: string
+}
+
+export interface StatsFacet {
+  name: string
+  flow_count: number
+  total_cost: number
+  total_tokens_in: number
+  total_tokens_out: number
 }
 
 export interface TaskSummary {
@@ -58,6 +106,10 @@ export interface TaskSummary {
   duration_ms: number
 }
 
+// Data of a task_update WebSocket message, sent when flows complete under a task
+export type TaskUpdate = Pick<TaskSummary,
+  'task_id' | 'flow_count' | 'total_tokens_in' | 'total_tokens_out' | 'total_cost' | 'last_seen'>
+
 export interface ToolStats {
   tool_name: string
   invocation_count: number
@@ -65,6 +117,9 @@ export interface ToolStats {
   failure_count: number
   success_rate: number
   avg_duration_ms: number
+  p50_duration_ms: number
+  p95_duration_ms: number
+  p99_duration_ms: number
 }
 
 export interface ToolInvocation {
@@ -100,8 +155,95 @@ export interface CostPeriod {
   total_tokens_out: number
 }
 
+export interface CostBucket {
+  flow_count: number
+  cost: number
+  input_tokens: number
+  output_tokens: number
+}
+
+export interface CostReconciliation {
+  start: string
+  end: string
+  total_flows: number
+  total_cost: number
+  exact: CostBucket
+  estimated: CostBucket
+  uncosted: CostBucket
+  missing_usage: number
+  unknown_model: number
+  coverage: number
+}
+
+export interface ErrorGroup {
+  provider: string
+  status_code: number
+  error_type: string
+  count: number
+  last_seen: string
+}
+
+export interface ErrorStats {
+  start: string
+  end: string
+  total_errors: number
+  groups: ErrorGroup[]
+}
+
+export interface BandwidthPoint {
+  period: string
+  flow_count: number
+  request_bytes: number
+  response_bytes: number
+}
+
+export interface Bandwidth {
+  start: string
+  end: string
+  bucket: 'hour' | 'day'
+  total_request_bytes: number
+  total_response_bytes: number
+  points: BandwidthPoint[]
+}
+
+export interface Pricing {
+  provider: string
+  model_pattern: string
+  input_cost_per_1k: number
+  output_cost_per_1k: number
+  cache_creation_per_1k?: number
+  cache_read_per_1k?: number
+  effective_date: string
+}
+
+export interface QuotaPoint {
+  provider: string
+  period: string
+  samples: number
+  requests_limit: number | null
+  requests_remaining: number | null
+  tokens_limit: number | null
+  tokens_remaining: number | null
+}
+
 export interface Settings {
   idle_gap_minutes: number
+  body_max_bytes: number
+  flows_ttl_days: number
+  events_ttl_days: number
+  bodies_ttl_days: number
+  drop_log_ttl_days: number
+  redact_api_keys: boolean
+  redact_base64_images: boolean
+  disable_body_storage: boolean
+}
+
+// Body of API error responses
+export interface ApiError {
+  error: {
+    code: string
+    message: string
+  }
 }
 
 export interface ApiResult<T> {
@@ -109,6 +109,7 @@ export function ToolsView({
               <th>Invocations</th>
               <th>Success Rate</th>
               <th>Avg Duration</th>
+              <th>P95 Latency</th>
             </tr>
           </thead>
           <tbody>
@@ -132,6 +133,7 @@ export function ToolsView({
                   {tool.success_rate.toFixed(1)}%
                 </td>
                 <td>{tool.avg_duration_ms.toFixed(0)}ms</td>
+                <td>{tool.p95_duration_ms}ms</td>
               </tr>
             ))}
           </tbody>
@@ -156,6 +156,9 @@ export const mockTools = [
     success_rate: 96.0,
     total_cost: 2.50,
     avg_duration_ms: 150,
+    p50_duration_ms: 120,
+    p95_duration_ms: 400,
+    p99_duration_ms: 650,
   },
   {
     tool_name: 'Edit',
@@ -165,6 +168,9 @@ export const mockTools = [
     success_rate: 80.0,
     total_cost: 1.25,
     avg_duration_ms: 200,
+    p50_duration_ms: 180,
+    p95_duration_ms: 520,
+    p99_duration_ms: 900,
   },
 ];
 
@@ -189,6 +195,14 @@ export const mockDailyCosts = [
 
 export const mockSettings = {
   idle_gap_minutes: 5,
+  body_max_bytes: 1048576,
+  flows_ttl_days: 30,
+  events_ttl_days: 7,
+  bodies_ttl_days: 3,
+  drop_log_ttl_days: 7,
+  redact_api_keys: true,
+  redact_base64_images: true,
+  disable_body_storage: false,
 };
 
 // --- Helpers ---
@@ -270,7 +284,7 @@ export async function setupMocks(page: Page, options: SetupMocksOptions = {}) {
         contentType: 'application/json',
         body: JSON.stringify(mockSettings),
       });
-    } else if (route.request().method() === 'PUT') {
+    } else if (route.request().method() === 'PATCH') {
       const body = route.request().postDataJSON();
       await route.fulfill({
         status: 200,